@@ -0,0 +1,138 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrAgentRunning is returned by LockAgent if another process holds the
+// agent lock.
+var ErrAgentRunning = errors.New("another agent is already running")
+
+// maxPreviousThumbprints bounds the history kept in AgentState.
+const maxPreviousThumbprints = 10
+
+// AgentState is what a renewal agent records between runs, so that it
+// resumes after a restart or crash instead of provisioning from scratch.
+type AgentState struct {
+	// LastRenewal is when the certificate was last renewed.
+	LastRenewal time.Time `json:"last_renewal"`
+	// Container is the key container in use.
+	Container string `json:"container"`
+	// Thumbprint is the SHA1 thumbprint of the certificate in use.
+	Thumbprint string `json:"thumbprint,omitempty"`
+	// PreviousThumbprints lists the replaced certificates, most recent first.
+	PreviousThumbprints []string `json:"previous_thumbprints,omitempty"`
+}
+
+// Renewed records that the certificate with thumbprint in container replaced
+// the current one at now.
+func (s *AgentState) Renewed(container, thumbprint string, now time.Time) {
+	if s.Thumbprint != "" && s.Thumbprint != thumbprint {
+		s.PreviousThumbprints = append([]string{s.Thumbprint}, s.PreviousThumbprints...)
+		if len(s.PreviousThumbprints) > maxPreviousThumbprints {
+			s.PreviousThumbprints = s.PreviousThumbprints[:maxPreviousThumbprints]
+		}
+	}
+	s.LastRenewal = now
+	s.Container = container
+	s.Thumbprint = thumbprint
+}
+
+// signedAgentState is the encoding of the state file. Signature signs the
+// SHA256 digest of State.
+type signedAgentState struct {
+	State     json.RawMessage `json:"state"`
+	Signature []byte          `json:"signature"`
+}
+
+// checkAgentName returns an *ArgError if name cannot be used in a file or
+// object name.
+func checkAgentName(op, name string) error {
+	if name == "" || strings.ContainsAny(name, `\/:*?"<>|`) {
+		return &ArgError{Op: op, Arg: "name", Reason: fmt.Sprintf("%q is not a valid agent name", name)}
+	}
+	return nil
+}
+
+// SaveAgentState signs state with signer and writes it to path. The file is
+// replaced atomically, so a crash leaves either the old or the new state.
+func SaveAgentState(path string, state *AgentState, signer crypto.Signer) error {
+	if state == nil {
+		return &ArgError{Op: "SaveAgentState", Arg: "state", Reason: "state is nil"}
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(b)
+	sig, err := signer.Sign(nil, digest[:], crypto.SHA256)
+	if err != nil {
+		return fmt.Errorf("could not sign agent state: %v", err)
+	}
+	data, err := json.Marshal(signedAgentState{State: b, Signature: sig})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// LoadAgentState reads the state at path and verifies that it was signed by
+// the key of pub. It returns nil and no error if there is no state file,
+// which means the agent has not run before.
+func LoadAgentState(path string, pub crypto.PublicKey) (*AgentState, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var signed signedAgentState
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return nil, fmt.Errorf("could not decode agent state %s: %v", path, err)
+	}
+	digest := sha256.Sum256(signed.State)
+	if err := verifySignature(pub, digest[:], crypto.SHA256, signed.Signature); err != nil {
+		return nil, fmt.Errorf("agent state %s has an invalid signature: %v", path, err)
+	}
+	state := &AgentState{}
+	if err := json.Unmarshal(signed.State, state); err != nil {
+		return nil, fmt.Errorf("could not decode agent state %s: %v", path, err)
+	}
+	return state, nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAgentState(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	s := &AgentState{}
+	s.Renewed("c", "aa", now)
+	s.Renewed("c", "bb", now.Add(time.Hour))
+	s.Renewed("c", "bb", now.Add(2*time.Hour))
+	want := &AgentState{LastRenewal: now.Add(2 * time.Hour), Container: "c", Thumbprint: "bb", PreviousThumbprints: []string{"aa"}}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("unexpected state got: %+v, want: %+v", s, want)
+	}
+	for i := 0; i < 2*maxPreviousThumbprints; i++ {
+		s.Renewed("c", string(rune('a'+i)), now)
+	}
+	if len(s.PreviousThumbprints) != maxPreviousThumbprints {
+		t.Errorf("state keeps %d previous thumbprints, want: %d", len(s.PreviousThumbprints), maxPreviousThumbprints)
+	}
+}
+
+func TestSaveLoadAgentState(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "certtostore", "agent.state")
+
+	if s, err := LoadAgentState(path, &key.PublicKey); s != nil || err != nil {
+		t.Errorf("LoadAgentState without a file = %v, %v, want: nil, nil", s, err)
+	}
+	want := &AgentState{LastRenewal: time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC), Container: "c", Thumbprint: "bb", PreviousThumbprints: []string{"aa"}}
+	if err := SaveAgentState(path, want, key); err != nil {
+		t.Fatalf("SaveAgentState returned %v", err)
+	}
+	got, err := LoadAgentState(path, &key.PublicKey)
+	if err != nil {
+		t.Fatalf("LoadAgentState returned %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected state got: %+v, want: %+v", got, want)
+	}
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	if _, err := LoadAgentState(path, &other.PublicKey); err == nil {
+		t.Error("LoadAgentState succeeded with the wrong key, want error")
+	}
+}
@@ -0,0 +1,78 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/windows"
+)
+
+// LockAgent acquires the machine wide lock of the agent called name, so that
+// only one instance runs at a time. It does not wait and returns
+// ErrAgentRunning if another process holds the lock. The returned function
+// releases it and may be called from any goroutine. Mutexes are owned by
+// threads, so like the container locks the lock is held by a goroutine locked
+// to its own OS thread until it is released.
+func LockAgent(name string) (func(), error) {
+	if err := checkAgentName("LockAgent", name); err != nil {
+		return nil, err
+	}
+	mutexName, err := windows.UTF16PtrFromString(containerObjectName("certtostore-agent-", "", name))
+	if err != nil {
+		return nil, err
+	}
+	return holdMutex(func() (windows.Handle, error) { return acquireAgentMutex(mutexName, name) })
+}
+
+// acquireAgentMutex opens the mutex of the agent called name and takes it
+// for the calling thread unless another process holds it.
+func acquireAgentMutex(mutexName *uint16, name string) (windows.Handle, error) {
+	mu, err := windows.CreateMutex(nil, false, mutexName)
+	if err != nil {
+		return 0, fmt.Errorf("CreateMutex returned %v", err)
+	}
+	ev, err := windows.WaitForSingleObject(mu, 0)
+	switch {
+	case err != nil:
+		windows.CloseHandle(mu)
+		return 0, fmt.Errorf("WaitForSingleObject returned %v", err)
+	case ev == waitTimeout:
+		windows.CloseHandle(mu)
+		return 0, ErrAgentRunning
+	case ev == windows.WAIT_ABANDONED:
+		// The previous agent crashed, its state file tells where to resume.
+		logWarning("Acquired an abandoned agent lock.", opField("lockagent"), field("agent", name))
+	}
+	return mu, nil
+}
+
+// AgentStatePath returns the path of the state file of the agent called name
+// under ProgramData, for SaveAgentState and LoadAgentState.
+func AgentStatePath(name string) (string, error) {
+	if err := checkAgentName("AgentStatePath", name); err != nil {
+		return "", err
+	}
+	dir := os.Getenv("ProgramData")
+	if dir == "" {
+		return "", errors.New("ProgramData is not set")
+	}
+	return filepath.Join(dir, "certtostore", name+".state"), nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// oidEnrollAttestationStatement is szOID_ENROLL_ATTESTATION_STATEMENT, under
+// which ADCS expects the key attestation statement of a request.
+var oidEnrollAttestationStatement = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 21, 24}
+
+// AttestationFormat selects how a key attestation statement is embedded in a
+// PKCS #10 request.
+type AttestationFormat int
+
+const (
+	// AttestationAttribute embeds the statement as a request attribute, as
+	// expected by Microsoft ADCS.
+	AttestationAttribute AttestationFormat = iota
+	// AttestationExtension embeds the statement as a non-critical requested
+	// extension under the same OID, for privacy CAs that only copy or inspect
+	// requested extensions.
+	AttestationExtension
+)
+
+func (f AttestationFormat) String() string {
+	switch f {
+	case AttestationAttribute:
+		return "attribute"
+	case AttestationExtension:
+		return "extension"
+	default:
+		return fmt.Sprintf("AttestationFormat(%d)", int(f))
+	}
+}
+
+// csrAttestationExtension returns the requested extension carrying statement.
+func csrAttestationExtension(statement []byte) (pkix.Extension, error) {
+	v, err := asn1.Marshal(statement)
+	return pkix.Extension{Id: oidEnrollAttestationStatement, Value: v}, err
+}
+
+// rawCSR and rawCSRInfo decode a PKCS #10 request far enough to add an
+// attribute and sign it again.
+type rawCSR struct {
+	TBS    asn1.RawValue
+	SigAlg pkix.AlgorithmIdentifier
+	Sig    asn1.BitString
+}
+
+type rawCSRInfo struct {
+	Version    int
+	Subject    asn1.RawValue
+	PublicKey  asn1.RawValue
+	Attributes []asn1.RawValue `asn1:"tag:0"`
+}
+
+// csrAttribute is a PKCS #10 attribute with a single value.
+type csrAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// addCSRAttributes adds attrs to the DER encoded request der, which must have
+// been signed by signer, and signs it again.
+func addCSRAttributes(rand io.Reader, der []byte, signer crypto.Signer, attrs []csrAttribute) ([]byte, error) {
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := csrSignerOpts(csr.SignatureAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	var outer rawCSR
+	if _, err := asn1.Unmarshal(der, &outer); err != nil {
+		return nil, fmt.Errorf("could not decode request: %v", err)
+	}
+	var info rawCSRInfo
+	if _, err := asn1.Unmarshal(outer.TBS.FullBytes, &info); err != nil {
+		return nil, fmt.Errorf("could not decode request info: %v", err)
+	}
+	for _, a := range attrs {
+		attr, err := asn1.Marshal(a)
+		if err != nil {
+			return nil, err
+		}
+		info.Attributes = append(info.Attributes, asn1.RawValue{FullBytes: attr})
+	}
+	tbs, err := asn1.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := tbs
+	if h := opts.HashFunc(); h != 0 {
+		hh := h.New()
+		hh.Write(tbs)
+		digest = hh.Sum(nil)
+	}
+	sig, err := signer.Sign(rand, digest, opts)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(rawCSR{
+		TBS:    asn1.RawValue{FullBytes: tbs},
+		SigAlg: outer.SigAlg,
+		Sig:    asn1.BitString{Bytes: sig, BitLength: 8 * len(sig)},
+	})
+}
+
+// csrSignerOpts returns the signer options for a request signature algorithm.
+func csrSignerOpts(alg x509.SignatureAlgorithm) (crypto.SignerOpts, error) {
+	switch alg {
+	case x509.SHA1WithRSA, x509.ECDSAWithSHA1:
+		return crypto.SHA1, nil
+	case x509.SHA256WithRSA, x509.ECDSAWithSHA256:
+		return crypto.SHA256, nil
+	case x509.SHA384WithRSA, x509.ECDSAWithSHA384:
+		return crypto.SHA384, nil
+	case x509.SHA512WithRSA, x509.ECDSAWithSHA512:
+		return crypto.SHA512, nil
+	case x509.SHA256WithRSAPSS:
+		return &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}, nil
+	case x509.SHA384WithRSAPSS:
+		return &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA384}, nil
+	case x509.SHA512WithRSAPSS:
+		return &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA512}, nil
+	default:
+		return nil, fmt.Errorf("cannot add attributes to a request signed with %v", alg)
+	}
+}
+
+// errNoAttestationStatement is returned by CSR for attested requests without
+// a statement.
+var errNoAttestationStatement = errors.New("attested requests need an AttestationStatement, or must be rendered for CertEnroll")
@@ -0,0 +1,94 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"runtime"
+	"time"
+	"unsafe"
+)
+
+const (
+	ncryptClaimAuthorityAndSubject       = 0x3  // NCRYPT_CLAIM_AUTHORITY_AND_SUBJECT
+	ncryptBufferClaimKeyAttestationNonce = 0x29 // NCRYPTBUFFER_CLAIM_KEYATTESTATION_NONCE
+)
+
+var nCryptCreateClaim = nCrypt.MustFindProc("NCryptCreateClaim")
+
+// AttestationClaim returns the attestation statement of the TPM key k,
+// certified by the attestation identity key aik, to be embedded in a request
+// with RequestTemplate.AttestationStatement. nonce is the challenge of the CA
+// and may be empty.
+func (k *RsaKey) AttestationClaim(aik Key, nonce []byte) (_ []byte, err error) {
+	defer keyOp(k.stats, k.breaker, "attest", k.Container, time.Now(), &err)
+	if err := k.breaker.allow(); err != nil {
+		return nil, err
+	}
+	return attestationClaim(k.handle, aik, nonce)
+}
+
+// AttestationClaim returns the attestation statement of the TPM key k, see
+// RsaKey.AttestationClaim.
+func (k *EcdsaKey) AttestationClaim(aik Key, nonce []byte) (_ []byte, err error) {
+	defer keyOp(k.stats, k.breaker, "attest", k.Container, time.Now(), &err)
+	if err := k.breaker.allow(); err != nil {
+		return nil, err
+	}
+	return attestationClaim(k.handle, aik, nonce)
+}
+
+func attestationClaim(kh uintptr, aik Key, nonce []byte) ([]byte, error) {
+	if aik == nil || aik.Handle() == 0 {
+		return nil, &ArgError{Op: "AttestationClaim", Arg: "aik", Reason: "attestation key has no handle"}
+	}
+	var list []kdfParam
+	if len(nonce) > 0 {
+		list = append(list, kdfParam{typ: ncryptBufferClaimKeyAttestationNonce, data: nonce})
+	}
+	desc, bufs := kdfBufferDesc(list)
+
+	// Get the size of the claim first.
+	var size uint32
+	r, _, err := nCryptCreateClaim.Call(
+		kh,
+		aik.Handle(),
+		ncryptClaimAuthorityAndSubject,
+		uintptr(unsafe.Pointer(desc)),
+		0,
+		0,
+		uintptr(unsafe.Pointer(&size)),
+		0)
+	if r != 0 {
+		return nil, ncryptErr("NCryptCreateClaim", r, "for the claim size", err)
+	}
+	claim := make([]byte, size)
+	r, _, err = nCryptCreateClaim.Call(
+		kh,
+		aik.Handle(),
+		ncryptClaimAuthorityAndSubject,
+		uintptr(unsafe.Pointer(desc)),
+		uintptr(unsafe.Pointer(&claim[0])),
+		uintptr(size),
+		uintptr(unsafe.Pointer(&size)),
+		0)
+	runtime.KeepAlive(bufs)
+	runtime.KeepAlive(list)
+	if r != 0 {
+		return nil, ncryptErr("NCryptCreateClaim", r, "", err)
+	}
+	return claim[:size], nil
+}
@@ -0,0 +1,276 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditEntry is a single record in an AuditLog. Each entry commits to the
+// one before it through PrevHash, so removing, reordering or editing an
+// entry breaks the chain for every entry after it. Anyone who can write an
+// unkeyed log can also rebuild its chain, so the chain should either be keyed,
+// see OpenAuditLogWithKey, or its head anchored outside of the machine, see
+// AuditLog.Head.
+type AuditEntry struct {
+	// Seq is the position of the entry in the log, starting at 1.
+	Seq uint64 `json:"seq"`
+	// Time is when the change was recorded.
+	Time time.Time `json:"time"`
+	// Operation is the operation that made the change, such as "store".
+	Operation string `json:"operation"`
+	// Container is the key container the operation worked with.
+	Container string `json:"container,omitempty"`
+	Change
+	// PrevHash is the Hash of the previous entry, empty for the first one.
+	PrevHash string `json:"prev_hash,omitempty"`
+	// Hash is the hex SHA256 of the entry with Hash left empty, or its
+	// HMAC-SHA256 for keyed logs.
+	Hash string `json:"hash"`
+}
+
+// sum returns the hash of e with the Hash field cleared, keyed with key if
+// it is not nil.
+func (e AuditEntry) sum(key []byte) (string, error) {
+	e.Hash = ""
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	if key != nil {
+		m := hmac.New(sha256.New, key)
+		m.Write(b)
+		return hex.EncodeToString(m.Sum(nil)), nil
+	}
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// checkAuditKey returns an error if key cannot key an audit log.
+func checkAuditKey(op string, key []byte) error {
+	if len(key) == 0 {
+		return &ArgError{Op: op, Arg: "key", Reason: "key is empty"}
+	}
+	return nil
+}
+
+// AuditChainError is returned by VerifyAuditLog and OpenAuditLog if the log
+// was tampered with or damaged.
+type AuditChainError struct {
+	// Line is the 1-based line of the first bad entry.
+	Line   int
+	Reason string
+}
+
+func (e *AuditChainError) Error() string {
+	return fmt.Sprintf("audit log line %d: %s", e.Line, e.Reason)
+}
+
+// VerifyAuditLog reads the log at path and checks its hash chain. It returns
+// the entries up to the first broken one, and an *AuditChainError describing
+// the break if there is one. A missing file is an empty, valid log.
+func VerifyAuditLog(path string) ([]AuditEntry, error) {
+	return verifyAuditLog(path, nil)
+}
+
+// VerifyAuditLogWithKey is like VerifyAuditLog for a log written by an
+// AuditLog opened with OpenAuditLogWithKey and key.
+func VerifyAuditLogWithKey(path string, key []byte) ([]AuditEntry, error) {
+	if err := checkAuditKey("VerifyAuditLogWithKey", key); err != nil {
+		return nil, err
+	}
+	return verifyAuditLog(path, key)
+}
+
+func verifyAuditLog(path string, key []byte) ([]AuditEntry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []AuditEntry
+	prev := ""
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	for line := 1; s.Scan(); line++ {
+		var e AuditEntry
+		d := json.NewDecoder(bytes.NewReader(s.Bytes()))
+		d.DisallowUnknownFields()
+		if err := d.Decode(&e); err != nil {
+			return entries, &AuditChainError{Line: line, Reason: fmt.Sprintf("malformed entry: %v", err)}
+		}
+		if e.Seq != uint64(line) {
+			return entries, &AuditChainError{Line: line, Reason: fmt.Sprintf("sequence number %d, want %d", e.Seq, line)}
+		}
+		if e.PrevHash != prev {
+			return entries, &AuditChainError{Line: line, Reason: "previous hash does not match the preceding entry"}
+		}
+		sum, err := e.sum(key)
+		if err != nil {
+			return entries, err
+		}
+		if !hmac.Equal([]byte(e.Hash), []byte(sum)) {
+			return entries, &AuditChainError{Line: line, Reason: "entry hash does not match its contents"}
+		}
+		entries = append(entries, e)
+		prev = e.Hash
+	}
+	return entries, s.Err()
+}
+
+// AuditLog is an append-only, hash-chained log of the changes this package
+// makes. Install one with SetAuditLog.
+type AuditLog struct {
+	mu   sync.Mutex
+	path string
+	// key, if set, keys the hashes of the entries.
+	key  []byte
+	seq  uint64
+	last string
+}
+
+// OpenAuditLog opens the log at path, creating it on the first Append. The
+// existing entries are verified first so that new entries are never chained
+// onto a broken log.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	return openAuditLog(path, nil)
+}
+
+// OpenAuditLogWithKey is like OpenAuditLog, but chains the entries with
+// HMAC-SHA256 under key, so that a chain rebuilt without the key does not
+// verify. The key should be kept away from the users who can write the log,
+// for example in a secret store of the verifier.
+func OpenAuditLogWithKey(path string, key []byte) (*AuditLog, error) {
+	if err := checkAuditKey("OpenAuditLogWithKey", key); err != nil {
+		return nil, err
+	}
+	return openAuditLog(path, append([]byte(nil), key...))
+}
+
+func openAuditLog(path string, key []byte) (*AuditLog, error) {
+	entries, err := verifyAuditLog(path, key)
+	if err != nil {
+		return nil, err
+	}
+	l := &AuditLog{path: path, key: key}
+	if n := len(entries); n > 0 {
+		l.seq = entries[n-1].Seq
+		l.last = entries[n-1].Hash
+	}
+	return l, nil
+}
+
+// auditNow is replaced in tests.
+var auditNow = time.Now
+
+// Append records e, filling in Seq, Time, PrevHash and Hash. The entry is
+// flushed to disk before Append returns.
+func (l *AuditLog) Append(e AuditEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e.Seq = l.seq + 1
+	e.Time = auditNow().UTC()
+	e.PrevHash = l.last
+	e.Hash = ""
+	sum, err := e.sum(l.key)
+	if err != nil {
+		return err
+	}
+	e.Hash = sum
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	l.seq, l.last = e.Seq, e.Hash
+	return nil
+}
+
+// Head returns the sequence number and hash of the last entry, or 0 and ""
+// for an empty log. Recording it outside of the machine, such as with a
+// remote log service, anchors the chain: a log whose entry at the recorded
+// sequence number has a different hash was rewritten.
+func (l *AuditLog) Head() (uint64, string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.seq, l.last
+}
+
+var (
+	auditMu  sync.RWMutex
+	auditLog *AuditLog
+)
+
+// SetAuditLog records every change this package makes to l. A nil AuditLog
+// turns auditing off, which is the default.
+func SetAuditLog(l *AuditLog) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	auditLog = l
+}
+
+// audit appends c to the package AuditLog, if there is one. Failures are
+// reported as warnings on r because the change has already been made.
+func (r *Result) audit(c Change) {
+	if err := auditChange(r.Operation, r.Container, c); err != nil {
+		r.warnf("writing the audit log: %v", err)
+	}
+}
+
+// auditChange appends c, made by operation in container, to the package
+// AuditLog, if there is one. Failures are logged, and returned for callers
+// that can report them.
+func auditChange(operation, container string, c Change) error {
+	auditMu.RLock()
+	l := auditLog
+	auditMu.RUnlock()
+	if l == nil {
+		return nil
+	}
+	if err := l.Append(AuditEntry{Operation: operation, Container: container, Change: c}); err != nil {
+		logWarning("Writing the audit log failed.", opField(operation), thumbprintField(c.Thumbprint), errField(err))
+		return err
+	}
+	return nil
+}
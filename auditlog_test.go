@@ -0,0 +1,155 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auditlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	defer func(f func() time.Time) { auditNow = f }(auditNow)
+	auditNow = func() time.Time { return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	l, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatalf("OpenAuditLog on a new file returned %v", err)
+	}
+	SetAuditLog(l)
+	defer SetAuditLog(nil)
+
+	r := &Result{Operation: "store", Container: "test"}
+	r.addChange(ActionGenerated, "", "Microsoft Platform Crypto Provider")
+	r.addChange(ActionAdded, "7309859BA6BB16AA3BD00636FE3966D0753CC069", `LocalMachine\MY`)
+	(&Result{Operation: "syncfromdir", dryRun: true}).addChange(ActionRemoved, "AB", `LocalMachine\Root`)
+	if len(r.Warnings) != 0 {
+		t.Fatalf("auditing returned warnings: %v", r.Warnings)
+	}
+
+	// Reopening continues the existing chain.
+	l, err = OpenAuditLog(path)
+	if err != nil {
+		t.Fatalf("OpenAuditLog on an existing file returned %v", err)
+	}
+	SetAuditLog(l)
+	(&Result{Operation: "link"}).addChange(ActionAdded, "7309859BA6BB16AA3BD00636FE3966D0753CC069", `CurrentUser\MY`)
+
+	entries, err := VerifyAuditLog(path)
+	if err != nil {
+		t.Fatalf("VerifyAuditLog returned %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("VerifyAuditLog returned %d entries, want 3", len(entries))
+	}
+	for i, op := range []string{"store", "store", "link"} {
+		if entries[i].Operation != op || entries[i].Seq != uint64(i+1) {
+			t.Errorf("entry %d is %q with sequence %d, want %q with sequence %d", i, entries[i].Operation, entries[i].Seq, op, i+1)
+		}
+	}
+	if entries[2].PrevHash != entries[1].Hash {
+		t.Error("reopened log did not chain onto the last entry")
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Replace(b, []byte(`LocalMachine\\MY`), []byte(`LocalMachine\\CA`), 1)
+	if err := ioutil.WriteFile(path, tampered, 0600); err != nil {
+		t.Fatal(err)
+	}
+	entries, err = VerifyAuditLog(path)
+	if ce, ok := err.(*AuditChainError); !ok || ce.Line != 2 {
+		t.Errorf("VerifyAuditLog on an edited log returned %v, want an AuditChainError for line 2", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("VerifyAuditLog on an edited log returned %d entries, want 1", len(entries))
+	}
+	if _, err := OpenAuditLog(path); err == nil {
+		t.Error("OpenAuditLog on an edited log returned no error")
+	}
+
+	lines := bytes.SplitAfter(b, []byte("\n"))
+	if err := ioutil.WriteFile(path, append(lines[0], lines[2]...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyAuditLog(path); err == nil {
+		t.Error("VerifyAuditLog on a log with a removed entry returned no error")
+	}
+}
+
+func TestKeyedAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auditlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	key := []byte("audit key")
+
+	if _, err := OpenAuditLogWithKey(path, nil); err == nil {
+		t.Fatal("OpenAuditLogWithKey with an empty key returned no error")
+	}
+	l, err := OpenAuditLogWithKey(path, key)
+	if err != nil {
+		t.Fatalf("OpenAuditLogWithKey returned %v", err)
+	}
+	if seq, head := l.Head(); seq != 0 || head != "" {
+		t.Errorf("Head of an empty log = %d, %q, want 0, \"\"", seq, head)
+	}
+	SetAuditLog(l)
+	defer SetAuditLog(nil)
+
+	r := &Result{Operation: "store", Container: "test"}
+	if err := auditChange("generate", "test", Change{Action: ActionGenerated, Store: "Microsoft Software Key Storage Provider"}); err != nil {
+		t.Fatalf("auditChange returned %v", err)
+	}
+	// Changes generate already audited are not written again.
+	r.addAuditedChange(ActionGenerated, "", "Microsoft Software Key Storage Provider")
+	r.addChange(ActionAdded, "7309859BA6BB16AA3BD00636FE3966D0753CC069", `LocalMachine\MY`)
+
+	entries, err := VerifyAuditLogWithKey(path, key)
+	if err != nil {
+		t.Fatalf("VerifyAuditLogWithKey returned %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("the log has %d entries, want 2", len(entries))
+	}
+	if seq, head := l.Head(); seq != 2 || head != entries[1].Hash {
+		t.Errorf("Head = %d, %q, want 2, %q", seq, head, entries[1].Hash)
+	}
+
+	// The chain does not verify without the key or under another one.
+	if _, err := VerifyAuditLog(path); err == nil {
+		t.Error("VerifyAuditLog of a keyed log returned no error")
+	}
+	if _, err := VerifyAuditLogWithKey(path, []byte("other key")); err == nil {
+		t.Error("VerifyAuditLogWithKey with the wrong key returned no error")
+	}
+	if _, err := OpenAuditLogWithKey(path, []byte("other key")); err == nil {
+		t.Error("OpenAuditLogWithKey with the wrong key returned no error")
+	}
+}
@@ -0,0 +1,643 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build awskms
+
+package certtostore
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	smtypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+const (
+	// defaultAWSTimeout bounds each AWS request if AWSKMSOptions.Timeout is
+	// zero.
+	defaultAWSTimeout = time.Minute
+	// defaultAWSDeletionWindow is the number of days KMS waits before it
+	// deletes a replaced key if AWSKMSOptions.DeletionWindow is zero.
+	defaultAWSDeletionWindow = 30
+)
+
+// awsName matches the names that are valid both as a KMS alias and as a
+// Secrets Manager secret.
+var awsName = regexp.MustCompile(`^[a-zA-Z0-9/_-]{1,200}$`)
+
+// AWSKMSOptions configures OpenAWSKMS.
+type AWSKMSOptions struct {
+	// Config holds the region and credentials, for example as loaded by
+	// config.LoadDefaultConfig from the instance or task role.
+	Config aws.Config
+	// Name names the Secrets Manager secret holding the certificates. The
+	// key of a pending enrollment is reachable as alias/<Name>-pending.
+	Name string
+	// Decrypt creates RSA keys for decryption instead of signing, since a
+	// KMS key can only be used for one of them.
+	Decrypt bool
+	// DeletionWindow is the number of days, 7 to 30, after which KMS
+	// deletes a key replaced by Store or removed by Remove. It defaults
+	// to 30.
+	DeletionWindow int32
+	// Timeout bounds each request to AWS, one minute by default.
+	Timeout time.Duration
+}
+
+func (o AWSKMSOptions) validate() error {
+	switch {
+	case o.Config.Region == "":
+		return &ArgError{Op: "OpenAWSKMS", Arg: "opts", Reason: "no region configured"}
+	case o.Config.Credentials == nil:
+		return &ArgError{Op: "OpenAWSKMS", Arg: "opts", Reason: "no credentials configured"}
+	case !awsName.MatchString(o.Name):
+		return &ArgError{Op: "OpenAWSKMS", Arg: "opts", Reason: fmt.Sprintf("name %q must have 1 to 200 letters, digits, '/', '_' or '-'", o.Name)}
+	case o.DeletionWindow != 0 && (o.DeletionWindow < 7 || o.DeletionWindow > 30):
+		return &ArgError{Op: "OpenAWSKMS", Arg: "opts", Reason: fmt.Sprintf("deletion window of %d days is not between 7 and 30", o.DeletionWindow)}
+	case o.Timeout < 0:
+		return &ArgError{Op: "OpenAWSKMS", Arg: "opts", Reason: fmt.Sprintf("negative timeout %v", o.Timeout)}
+	}
+	return nil
+}
+
+// awsKMS is the part of *kms.Client used by AWSKMSStore.
+type awsKMS interface {
+	CreateKey(ctx context.Context, params *kms.CreateKeyInput, optFns ...func(*kms.Options)) (*kms.CreateKeyOutput, error)
+	GetPublicKey(ctx context.Context, params *kms.GetPublicKeyInput, optFns ...func(*kms.Options)) (*kms.GetPublicKeyOutput, error)
+	CreateAlias(ctx context.Context, params *kms.CreateAliasInput, optFns ...func(*kms.Options)) (*kms.CreateAliasOutput, error)
+	UpdateAlias(ctx context.Context, params *kms.UpdateAliasInput, optFns ...func(*kms.Options)) (*kms.UpdateAliasOutput, error)
+	DeleteAlias(ctx context.Context, params *kms.DeleteAliasInput, optFns ...func(*kms.Options)) (*kms.DeleteAliasOutput, error)
+	ScheduleKeyDeletion(ctx context.Context, params *kms.ScheduleKeyDeletionInput, optFns ...func(*kms.Options)) (*kms.ScheduleKeyDeletionOutput, error)
+	Sign(ctx context.Context, params *kms.SignInput, optFns ...func(*kms.Options)) (*kms.SignOutput, error)
+	Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error)
+}
+
+// awsSecrets is the part of *secretsmanager.Client used by AWSKMSStore.
+type awsSecrets interface {
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+	CreateSecret(ctx context.Context, params *secretsmanager.CreateSecretInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.CreateSecretOutput, error)
+	PutSecretValue(ctx context.Context, params *secretsmanager.PutSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.PutSecretValueOutput, error)
+	DeleteSecret(ctx context.Context, params *secretsmanager.DeleteSecretInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.DeleteSecretOutput, error)
+}
+
+// awsCertRecord is the value of the secret of an AWSKMSStore.
+type awsCertRecord struct {
+	// KeyID is the ARN of the KMS key the certificate is issued for.
+	KeyID string `json:"key_id"`
+	// Chain holds the PEM encoded certificate and intermediate.
+	Chain string `json:"chain"`
+}
+
+// AWSKMSStore stores a key in AWS KMS and its certificate and intermediate in
+// a Secrets Manager secret, so services on EC2 and ECS can enroll with the
+// same code as Windows machines. KMS keys cannot be rotated in place, so
+// Generate creates a new key, which Store installs by recording it in the
+// secret, and the replaced key is scheduled for deletion. It is only built
+// with the awskms build tag, so that importers who don't need it don't pull
+// in the AWS SDK.
+type AWSKMSStore struct {
+	name           string
+	decrypt        bool
+	deletionWindow int32
+	timeout        time.Duration
+	kms            awsKMS
+	secrets        awsSecrets
+}
+
+var _ CertStorage = &AWSKMSStore{}
+var _ AlgorithmGenerator = &AWSKMSStore{}
+var _ ManagedStorage = &AWSKMSStore{}
+
+// OpenAWSKMS returns the store for the certificates named opts.Name and
+// their key.
+func OpenAWSKMS(opts AWSKMSOptions) (*AWSKMSStore, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	return newAWSKMSStore(opts, kms.NewFromConfig(opts.Config), secretsmanager.NewFromConfig(opts.Config)), nil
+}
+
+func newAWSKMSStore(opts AWSKMSOptions, k awsKMS, secrets awsSecrets) *AWSKMSStore {
+	s := &AWSKMSStore{
+		name:           opts.Name,
+		decrypt:        opts.Decrypt,
+		deletionWindow: opts.DeletionWindow,
+		timeout:        opts.Timeout,
+		kms:            k,
+		secrets:        secrets,
+	}
+	if s.deletionWindow == 0 {
+		s.deletionWindow = defaultAWSDeletionWindow
+	}
+	if s.timeout == 0 {
+		s.timeout = defaultAWSTimeout
+	}
+	return s
+}
+
+// pendingAlias names the key created by Generate until Store installs it.
+func (s *AWSKMSStore) pendingAlias() string {
+	return "alias/" + s.name + "-pending"
+}
+
+// awsNotFound reports whether err reports a missing KMS key or alias or a
+// missing secret.
+func awsNotFound(err error) bool {
+	var kmsErr *kmstypes.NotFoundException
+	var smErr *smtypes.ResourceNotFoundException
+	return errors.As(err, &kmsErr) || errors.As(err, &smErr)
+}
+
+// record returns the content of the secret, or nil if there is none.
+func (s *AWSKMSStore) record() (*awsCertRecord, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	resp, err := s.secrets.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(s.name)})
+	if awsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("GetSecretValue(%s) returned %v", s.name, err)
+	}
+	if resp.SecretString == nil {
+		return nil, fmt.Errorf("secret %s has no value", s.name)
+	}
+	rec := new(awsCertRecord)
+	if err := json.Unmarshal([]byte(*resp.SecretString), rec); err != nil {
+		return nil, fmt.Errorf("could not decode secret %s: %v", s.name, err)
+	}
+	return rec, nil
+}
+
+// chain returns the certificates of the secret, or nil if there is none.
+func (s *AWSKMSStore) chain() ([]*x509.Certificate, error) {
+	rec, err := s.record()
+	if err != nil || rec == nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	rest := []byte(rec.Chain)
+	for {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse certificate in secret %s: %v", s.name, err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// Cert returns the current certificate or nil if there is none.
+func (s *AWSKMSStore) Cert() (*x509.Certificate, error) {
+	certs, err := s.chain()
+	if err != nil || len(certs) < 1 {
+		return nil, err
+	}
+	return certs[0], nil
+}
+
+// Intermediate returns the current intermediate certificate or nil if there
+// is none.
+func (s *AWSKMSStore) Intermediate() (*x509.Certificate, error) {
+	certs, err := s.chain()
+	if err != nil || len(certs) < 2 {
+		return nil, err
+	}
+	return certs[1], nil
+}
+
+// Generate creates a new RSA key of keySize bits, see GenerateAlgorithm.
+func (s *AWSKMSStore) Generate(keySize int) (crypto.Signer, error) {
+	return s.GenerateAlgorithm(keySize, "RSA")
+}
+
+// GenerateAlgorithm creates a new key and returns a signer that can be used to
+// make a CSR for it. alg is "RSA", "ECDSA_P256", "ECDSA_P384" or "ECDSA_P521",
+// an empty alg generates an RSA key of 2048, 3072 or 4096 bits. The key is
+// reachable through the pending alias until Store installs it, which also works
+// from another process, and a key pending from an earlier Generate is scheduled
+// for deletion. Signer keeps returning the current key until then.
+func (s *AWSKMSStore) GenerateAlgorithm(keySize int, alg string) (crypto.Signer, error) {
+	input := &kms.CreateKeyInput{
+		Description: aws.String("certtostore key of " + s.name),
+		KeyUsage:    kmstypes.KeyUsageTypeSignVerify,
+		Tags:        []kmstypes.Tag{{TagKey: aws.String("created-by"), TagValue: aws.String("certtostore")}},
+	}
+	switch alg {
+	case "RSA", "":
+		switch keySize {
+		case 2048:
+			input.KeySpec = kmstypes.KeySpecRsa2048
+		case 3072:
+			input.KeySpec = kmstypes.KeySpecRsa3072
+		case 4096:
+			input.KeySpec = kmstypes.KeySpecRsa4096
+		default:
+			return nil, fmt.Errorf("unsupported RSA key size %d, KMS supports 2048, 3072 and 4096", keySize)
+		}
+		if s.decrypt {
+			input.KeyUsage = kmstypes.KeyUsageTypeEncryptDecrypt
+		}
+	case "ECDSA_P256":
+		input.KeySpec = kmstypes.KeySpecEccNistP256
+	case "ECDSA_P384":
+		input.KeySpec = kmstypes.KeySpecEccNistP384
+	case "ECDSA_P521":
+		input.KeySpec = kmstypes.KeySpecEccNistP521
+	default:
+		return nil, fmt.Errorf("unsupported algorithm: %s", alg)
+	}
+
+	previous, err := s.key(s.pendingAlias())
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	resp, err := s.kms.CreateKey(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("CreateKey(%s) returned %v", s.name, err)
+	}
+	if resp.KeyMetadata == nil || resp.KeyMetadata.Arn == nil {
+		return nil, errors.New("KMS returned an incomplete key")
+	}
+	id := *resp.KeyMetadata.Arn
+	if err := s.pointAlias(ctx, s.pendingAlias(), id); err != nil {
+		s.scheduleDeletion(ctx, id)
+		return nil, err
+	}
+	if previous != nil {
+		s.scheduleDeletion(ctx, previous.id)
+	}
+	k, err := s.key(id)
+	if err != nil {
+		return nil, err
+	}
+	logInfo("Created key.", opField("generate"), containerField(s.name), field("key", id))
+	return k, nil
+}
+
+// pointAlias points alias at the key id, creating the alias if needed.
+func (s *AWSKMSStore) pointAlias(ctx context.Context, alias, id string) error {
+	_, err := s.kms.UpdateAlias(ctx, &kms.UpdateAliasInput{AliasName: aws.String(alias), TargetKeyId: aws.String(id)})
+	if awsNotFound(err) {
+		_, err = s.kms.CreateAlias(ctx, &kms.CreateAliasInput{AliasName: aws.String(alias), TargetKeyId: aws.String(id)})
+	}
+	if err != nil {
+		return fmt.Errorf("pointing %s at %s returned %v", alias, id, err)
+	}
+	return nil
+}
+
+// scheduleDeletion schedules the deletion of the key id after the deletion
+// window. It is only used for keys that are no longer needed, so failures are
+// logged rather than returned.
+func (s *AWSKMSStore) scheduleDeletion(ctx context.Context, id string) {
+	_, err := s.kms.ScheduleKeyDeletion(ctx, &kms.ScheduleKeyDeletionInput{KeyId: aws.String(id), PendingWindowInDays: aws.Int32(s.deletionWindow)})
+	if err != nil && !awsNotFound(err) {
+		logWarning("Could not schedule the deletion of a replaced key.", containerField(s.name), field("key", id), errField(err))
+		return
+	}
+	logInfo("Scheduled key deletion.", containerField(s.name), field("key", id), field("days", s.deletionWindow))
+}
+
+// key returns the key identified by id, which is a key ARN or an alias, or
+// nil if there is none.
+func (s *AWSKMSStore) key(id string) (*AWSKMSKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	resp, err := s.kms.GetPublicKey(ctx, &kms.GetPublicKeyInput{KeyId: aws.String(id)})
+	if awsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("GetPublicKey(%s) returned %v", id, err)
+	}
+	if resp.KeyId == nil {
+		return nil, errors.New("KMS returned an incomplete key")
+	}
+	pub, err := x509.ParsePKIXPublicKey(resp.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse public key of %s: %v", id, err)
+	}
+	switch pub.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+	return &AWSKMSKey{store: s, id: *resp.KeyId, pub: pub}, nil
+}
+
+// Store writes cert and intermediate to the secret together with the key
+// cert is issued for, which installs a key created by Generate. cert must be
+// issued for the pending key or for the current one.
+func (s *AWSKMSStore) Store(cert *x509.Certificate, intermediate *x509.Certificate) error {
+	if err := checkCert("Store", "cert", cert); err != nil {
+		return err
+	}
+	if err := checkCert("Store", "intermediate", intermediate); err != nil {
+		return err
+	}
+	rec, err := s.record()
+	if err != nil {
+		return err
+	}
+	k, pending, err := s.keyFor(cert, rec)
+	if err != nil {
+		return err
+	}
+
+	var chain bytes.Buffer
+	for _, c := range []*x509.Certificate{cert, intermediate} {
+		if err := pem.Encode(&chain, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw}); err != nil {
+			return fmt.Errorf("could not encode cert to PEM: %v", err)
+		}
+	}
+	value, err := json.Marshal(&awsCertRecord{KeyID: k.id, Chain: chain.String()})
+	if err != nil {
+		return fmt.Errorf("could not encode secret: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	if rec == nil {
+		_, err = s.secrets.CreateSecret(ctx, &secretsmanager.CreateSecretInput{Name: aws.String(s.name), SecretString: aws.String(string(value))})
+	} else {
+		_, err = s.secrets.PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{SecretId: aws.String(s.name), SecretString: aws.String(string(value))})
+	}
+	if err != nil {
+		return fmt.Errorf("storing secret %s returned %v", s.name, err)
+	}
+	logInfo("Stored certificate.", opField("store"), containerField(s.name), field("key", k.id), thumbprintField(thumbprint(cert)))
+
+	if !pending {
+		return nil
+	}
+	if _, err := s.kms.DeleteAlias(ctx, &kms.DeleteAliasInput{AliasName: aws.String(s.pendingAlias())}); err != nil && !awsNotFound(err) {
+		logWarning("Could not delete the pending alias.", opField("store"), containerField(s.name), errField(err))
+	}
+	if rec != nil && rec.KeyID != k.id {
+		s.scheduleDeletion(ctx, rec.KeyID)
+	}
+	return nil
+}
+
+// keyFor returns the key cert is issued for, the pending key or the current
+// key of rec, and whether it is the pending one.
+func (s *AWSKMSStore) keyFor(cert *x509.Certificate, rec *awsCertRecord) (*AWSKMSKey, bool, error) {
+	pending, err := s.key(s.pendingAlias())
+	if err != nil {
+		return nil, false, err
+	}
+	if pending != nil && pending.pub.(interface{ Equal(crypto.PublicKey) bool }).Equal(cert.PublicKey) {
+		return pending, true, nil
+	}
+	if rec != nil {
+		current, err := s.key(rec.KeyID)
+		if err != nil {
+			return nil, false, err
+		}
+		if current != nil && current.pub.(interface{ Equal(crypto.PublicKey) bool }).Equal(cert.PublicKey) {
+			return current, false, nil
+		}
+	}
+	if pending == nil && rec == nil {
+		return nil, false, errors.New("no key to store the certificate for, call Generate first")
+	}
+	return nil, false, fmt.Errorf("certificate %q is not issued for the pending or the current key of %s", cert.Subject, s.name)
+}
+
+// Signer returns the key the current certificate is issued for, or nil if
+// there is no certificate. The key returned by Generate is only installed by
+// Store.
+func (s *AWSKMSStore) Signer() (crypto.Signer, error) {
+	rec, err := s.record()
+	if err != nil || rec == nil {
+		return nil, err
+	}
+	k, err := s.key(rec.KeyID)
+	if err != nil || k == nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Remove deletes the certificates and schedules the deletion of the current
+// and the pending key. Secrets Manager and KMS keep them recoverable for
+// their recovery and deletion windows. KMS has no system wide location, so
+// removeSystem is ignored.
+func (s *AWSKMSStore) Remove(removeSystem bool) error {
+	rec, err := s.record()
+	if err != nil {
+		return err
+	}
+	pending, err := s.key(s.pendingAlias())
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	if _, err := s.secrets.DeleteSecret(ctx, &secretsmanager.DeleteSecretInput{SecretId: aws.String(s.name)}); err != nil && !awsNotFound(err) {
+		return fmt.Errorf("DeleteSecret(%s) returned %v", s.name, err)
+	}
+	if pending != nil {
+		if _, err := s.kms.DeleteAlias(ctx, &kms.DeleteAliasInput{AliasName: aws.String(s.pendingAlias())}); err != nil && !awsNotFound(err) {
+			return fmt.Errorf("DeleteAlias(%s) returned %v", s.pendingAlias(), err)
+		}
+		s.scheduleDeletion(ctx, pending.id)
+	}
+	if rec != nil {
+		s.scheduleDeletion(ctx, rec.KeyID)
+	}
+	logInfo("Removed key and certificates.", opField("remove"), containerField(s.name))
+	return nil
+}
+
+// Link does nothing, access to KMS and Secrets Manager is granted by IAM
+// policies.
+func (s *AWSKMSStore) Link() error {
+	return nil
+}
+
+// AWSKMSKey is a key in AWS KMS. It implements crypto.Signer and, for RSA
+// keys created with AWSKMSOptions.Decrypt, crypto.Decrypter. KMS returns
+// ECDSA signatures ASN.1 encoded like those of crypto/ecdsa.
+type AWSKMSKey struct {
+	store *AWSKMSStore
+	id    string
+	pub   crypto.PublicKey
+}
+
+var (
+	_ crypto.Signer    = &AWSKMSKey{}
+	_ crypto.Decrypter = &AWSKMSKey{}
+	_ CryptoKey        = &AWSKMSKey{}
+)
+
+// Public returns the public key to implement crypto.Signer.
+func (k *AWSKMSKey) Public() crypto.PublicKey {
+	return k.pub
+}
+
+// ID returns the ARN of the key.
+func (k *AWSKMSKey) ID() string {
+	return k.id
+}
+
+// PublicDER returns the DER encoded SubjectPublicKeyInfo of the key.
+func (k *AWSKMSKey) PublicDER() ([]byte, error) {
+	return publicDER(k.pub)
+}
+
+// PublicPEM returns the SubjectPublicKeyInfo of the key as a PEM block.
+func (k *AWSKMSKey) PublicPEM() ([]byte, error) {
+	return publicPEM(k.pub)
+}
+
+// awsSigningAlgorithm returns the KMS algorithm signing a digest of hash with
+// pub. KMS uses a PSS salt as long as the hash, and ECDSA algorithms fix the
+// hash to the curve.
+func awsSigningAlgorithm(pub crypto.PublicKey, opts crypto.SignerOpts) (kmstypes.SigningAlgorithmSpec, error) {
+	hash := opts.HashFunc()
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if pssOpts, ok := opts.(*rsa.PSSOptions); ok {
+			if salt := pssOpts.SaltLength; salt != rsa.PSSSaltLengthAuto && salt != rsa.PSSSaltLengthEqualsHash && salt != hash.Size() {
+				return "", fmt.Errorf("PSS salt length %d is not supported by KMS, which uses %d", salt, hash.Size())
+			}
+			switch hash {
+			case crypto.SHA256:
+				return kmstypes.SigningAlgorithmSpecRsassaPssSha256, nil
+			case crypto.SHA384:
+				return kmstypes.SigningAlgorithmSpecRsassaPssSha384, nil
+			case crypto.SHA512:
+				return kmstypes.SigningAlgorithmSpecRsassaPssSha512, nil
+			}
+		} else {
+			switch hash {
+			case crypto.SHA256:
+				return kmstypes.SigningAlgorithmSpecRsassaPkcs1V15Sha256, nil
+			case crypto.SHA384:
+				return kmstypes.SigningAlgorithmSpecRsassaPkcs1V15Sha384, nil
+			case crypto.SHA512:
+				return kmstypes.SigningAlgorithmSpecRsassaPkcs1V15Sha512, nil
+			}
+		}
+	case *ecdsa.PublicKey:
+		switch {
+		case pub.Curve == elliptic.P256() && hash == crypto.SHA256:
+			return kmstypes.SigningAlgorithmSpecEcdsaSha256, nil
+		case pub.Curve == elliptic.P384() && hash == crypto.SHA384:
+			return kmstypes.SigningAlgorithmSpecEcdsaSha384, nil
+		case pub.Curve == elliptic.P521() && hash == crypto.SHA512:
+			return kmstypes.SigningAlgorithmSpecEcdsaSha512, nil
+		}
+		return "", fmt.Errorf("KMS cannot sign %v digests with a %s key", hash, pub.Curve.Params().Name)
+	default:
+		return "", fmt.Errorf("unsupported public key type %T", pub)
+	}
+	return "", fmt.Errorf("unsupported hash algorithm %v", hash)
+}
+
+// Sign signs digest with the key to implement crypto.Signer. If opts is a
+// *rsa.PSSOptions RSA signatures use PSS padding, otherwise PKCS #1 v1.5.
+func (k *AWSKMSKey) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts == nil {
+		return nil, &ArgError{Op: "Sign", Arg: "opts", Reason: "opts is nil"}
+	}
+	if err := checkDigest("Sign", digest, opts.HashFunc()); err != nil {
+		return nil, err
+	}
+	alg, err := awsSigningAlgorithm(k.pub, opts)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), k.store.timeout)
+	defer cancel()
+	resp, err := k.store.kms.Sign(ctx, &kms.SignInput{
+		KeyId:            aws.String(k.id),
+		Message:          digest,
+		MessageType:      kmstypes.MessageTypeDigest,
+		SigningAlgorithm: alg,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Sign(%s) returned %v", k.id, err)
+	}
+	return resp.Signature, nil
+}
+
+// SignMessage hashes the message read from r with hash and signs the digest.
+func (k *AWSKMSKey) SignMessage(r io.Reader, hash crypto.Hash) ([]byte, error) {
+	return SignMessage(k, r, hash)
+}
+
+// Decrypt decrypts blob with an RSA key to implement crypto.Decrypter. KMS
+// only supports OAEP padding, so opts must be a *rsa.OAEPOptions with SHA-1
+// or SHA-256 and no label. ECDSA keys return ErrNotSupported.
+func (k *AWSKMSKey) Decrypt(rand io.Reader, blob []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	if _, ok := k.pub.(*rsa.PublicKey); !ok {
+		return nil, ErrNotSupported
+	}
+	if err := checkNotEmpty("Decrypt", "blob", blob); err != nil {
+		return nil, err
+	}
+	oaep, ok := opts.(*rsa.OAEPOptions)
+	if !ok {
+		return nil, fmt.Errorf("unsupported decrypter options %T, KMS only supports OAEP", opts)
+	}
+	if len(oaep.Label) > 0 {
+		return nil, errors.New("KMS does not support OAEP labels")
+	}
+	if oaep.MGFHash != 0 && oaep.MGFHash != oaep.Hash {
+		return nil, fmt.Errorf("MGF1 hash %v differs from the OAEP hash %v", oaep.MGFHash, oaep.Hash)
+	}
+	var alg kmstypes.EncryptionAlgorithmSpec
+	switch oaep.Hash {
+	case crypto.SHA1:
+		alg = kmstypes.EncryptionAlgorithmSpecRsaesOaepSha1
+	case crypto.SHA256:
+		alg = kmstypes.EncryptionAlgorithmSpecRsaesOaepSha256
+	default:
+		return nil, fmt.Errorf("unsupported OAEP hash %v", oaep.Hash)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), k.store.timeout)
+	defer cancel()
+	resp, err := k.store.kms.Decrypt(ctx, &kms.DecryptInput{KeyId: aws.String(k.id), CiphertextBlob: blob, EncryptionAlgorithm: alg})
+	if err != nil {
+		return nil, fmt.Errorf("Decrypt(%s) returned %v", k.id, err)
+	}
+	return resp.Plaintext, nil
+}
@@ -0,0 +1,334 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build awskms
+
+package certtostore
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	smtypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/google/certtostore/testdata/helpers"
+)
+
+// fakeAWS implements awsKMS and awsSecrets with software keys.
+type fakeAWS struct {
+	keys      map[string]crypto.Signer
+	aliases   map[string]string
+	scheduled map[string]bool
+	secret    *string
+}
+
+func newFakeAWS() *fakeAWS {
+	return &fakeAWS{keys: make(map[string]crypto.Signer), aliases: make(map[string]string), scheduled: make(map[string]bool)}
+}
+
+// lookup resolves id, a key ARN or an alias, to the ARN and the key.
+func (f *fakeAWS) lookup(id string) (string, crypto.Signer, error) {
+	if arn, ok := f.aliases[id]; ok {
+		id = arn
+	}
+	key, ok := f.keys[id]
+	if !ok || f.scheduled[id] {
+		return "", nil, &kmstypes.NotFoundException{}
+	}
+	return id, key, nil
+}
+
+func (f *fakeAWS) CreateKey(ctx context.Context, p *kms.CreateKeyInput, _ ...func(*kms.Options)) (*kms.CreateKeyOutput, error) {
+	var key crypto.Signer
+	var err error
+	switch p.KeySpec {
+	case kmstypes.KeySpecRsa2048:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case kmstypes.KeySpecEccNistP256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		err = fmt.Errorf("unexpected key spec %s", p.KeySpec)
+	}
+	if err != nil {
+		return nil, err
+	}
+	arn := fmt.Sprintf("arn:aws:kms:us-east-1:111122223333:key/%d", len(f.keys))
+	f.keys[arn] = key
+	return &kms.CreateKeyOutput{KeyMetadata: &kmstypes.KeyMetadata{Arn: aws.String(arn)}}, nil
+}
+
+func (f *fakeAWS) GetPublicKey(ctx context.Context, p *kms.GetPublicKeyInput, _ ...func(*kms.Options)) (*kms.GetPublicKeyOutput, error) {
+	arn, key, err := f.lookup(*p.KeyId)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &kms.GetPublicKeyOutput{KeyId: aws.String(arn), PublicKey: der}, nil
+}
+
+func (f *fakeAWS) CreateAlias(ctx context.Context, p *kms.CreateAliasInput, _ ...func(*kms.Options)) (*kms.CreateAliasOutput, error) {
+	if _, ok := f.aliases[*p.AliasName]; ok {
+		return nil, &kmstypes.AlreadyExistsException{}
+	}
+	f.aliases[*p.AliasName] = *p.TargetKeyId
+	return &kms.CreateAliasOutput{}, nil
+}
+
+func (f *fakeAWS) UpdateAlias(ctx context.Context, p *kms.UpdateAliasInput, _ ...func(*kms.Options)) (*kms.UpdateAliasOutput, error) {
+	if _, ok := f.aliases[*p.AliasName]; !ok {
+		return nil, &kmstypes.NotFoundException{}
+	}
+	f.aliases[*p.AliasName] = *p.TargetKeyId
+	return &kms.UpdateAliasOutput{}, nil
+}
+
+func (f *fakeAWS) DeleteAlias(ctx context.Context, p *kms.DeleteAliasInput, _ ...func(*kms.Options)) (*kms.DeleteAliasOutput, error) {
+	if _, ok := f.aliases[*p.AliasName]; !ok {
+		return nil, &kmstypes.NotFoundException{}
+	}
+	delete(f.aliases, *p.AliasName)
+	return &kms.DeleteAliasOutput{}, nil
+}
+
+func (f *fakeAWS) ScheduleKeyDeletion(ctx context.Context, p *kms.ScheduleKeyDeletionInput, _ ...func(*kms.Options)) (*kms.ScheduleKeyDeletionOutput, error) {
+	arn, _, err := f.lookup(*p.KeyId)
+	if err != nil {
+		return nil, err
+	}
+	f.scheduled[arn] = true
+	return &kms.ScheduleKeyDeletionOutput{}, nil
+}
+
+func (f *fakeAWS) Sign(ctx context.Context, p *kms.SignInput, _ ...func(*kms.Options)) (*kms.SignOutput, error) {
+	_, key, err := f.lookup(*p.KeyId)
+	if err != nil {
+		return nil, err
+	}
+	if p.MessageType != kmstypes.MessageTypeDigest {
+		return nil, fmt.Errorf("unexpected message type %s", p.MessageType)
+	}
+	switch p.SigningAlgorithm {
+	case kmstypes.SigningAlgorithmSpecRsassaPkcs1V15Sha256, kmstypes.SigningAlgorithmSpecEcdsaSha256:
+	default:
+		return nil, fmt.Errorf("unexpected algorithm %s", p.SigningAlgorithm)
+	}
+	sig, err := key.Sign(rand.Reader, p.Message, crypto.SHA256)
+	return &kms.SignOutput{Signature: sig}, err
+}
+
+func (f *fakeAWS) Decrypt(ctx context.Context, p *kms.DecryptInput, _ ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	_, key, err := f.lookup(*p.KeyId)
+	if err != nil {
+		return nil, err
+	}
+	if p.EncryptionAlgorithm != kmstypes.EncryptionAlgorithmSpecRsaesOaepSha256 {
+		return nil, fmt.Errorf("unexpected algorithm %s", p.EncryptionAlgorithm)
+	}
+	plain, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, key.(*rsa.PrivateKey), p.CiphertextBlob, nil)
+	return &kms.DecryptOutput{Plaintext: plain}, err
+}
+
+func (f *fakeAWS) GetSecretValue(ctx context.Context, p *secretsmanager.GetSecretValueInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	if f.secret == nil {
+		return nil, &smtypes.ResourceNotFoundException{}
+	}
+	return &secretsmanager.GetSecretValueOutput{SecretString: f.secret}, nil
+}
+
+func (f *fakeAWS) CreateSecret(ctx context.Context, p *secretsmanager.CreateSecretInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.CreateSecretOutput, error) {
+	if f.secret != nil {
+		return nil, &smtypes.ResourceExistsException{}
+	}
+	f.secret = p.SecretString
+	return &secretsmanager.CreateSecretOutput{}, nil
+}
+
+func (f *fakeAWS) PutSecretValue(ctx context.Context, p *secretsmanager.PutSecretValueInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.PutSecretValueOutput, error) {
+	if f.secret == nil {
+		return nil, &smtypes.ResourceNotFoundException{}
+	}
+	f.secret = p.SecretString
+	return &secretsmanager.PutSecretValueOutput{}, nil
+}
+
+func (f *fakeAWS) DeleteSecret(ctx context.Context, p *secretsmanager.DeleteSecretInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.DeleteSecretOutput, error) {
+	if f.secret == nil {
+		return nil, &smtypes.ResourceNotFoundException{}
+	}
+	f.secret = nil
+	return &secretsmanager.DeleteSecretOutput{}, nil
+}
+
+func TestAWSKMSStore(t *testing.T) {
+	if _, err := OpenAWSKMS(AWSKMSOptions{Config: aws.Config{Region: "us-east-1"}, Name: "test"}); err == nil {
+		t.Error("OpenAWSKMS succeeded without credentials")
+	}
+	if err := (AWSKMSOptions{Config: aws.Config{Region: "us-east-1", Credentials: aws.AnonymousCredentials{}}, Name: "a b"}).validate(); err == nil {
+		t.Error("validate accepted a name with a space")
+	}
+
+	fake := newFakeAWS()
+	s := newAWSKMSStore(AWSKMSOptions{Name: "test", Decrypt: true}, fake, fake)
+	if key, err := s.Signer(); err != nil || key != nil {
+		t.Errorf("expected no key on an empty account, instead %v, %v", key, err)
+	}
+	if cert, err := s.Cert(); err != nil || cert != nil {
+		t.Errorf("expected no cert on an empty account, instead %v, %v", cert, err)
+	}
+
+	signer, err := s.GenerateAlgorithm(0, "ECDSA_P256")
+	if err != nil {
+		t.Fatalf("Generate returned %v", err)
+	}
+	cert := signedBy(t, signer)
+	if key, _ := s.Signer(); key != nil {
+		t.Error("Generate installed the key before Store")
+	}
+	// A store opened later, as after a restart, finds the pending key.
+	s = newAWSKMSStore(AWSKMSOptions{Name: "test", Decrypt: true}, fake, fake)
+	if err := s.Store(cert, cert); err != nil {
+		t.Fatalf("Store returned %v", err)
+	}
+	if got, err := s.Cert(); err != nil || !got.Equal(cert) {
+		t.Errorf("expected read-back cert to match, instead %v, %v", got, err)
+	}
+	if got, err := s.Intermediate(); err != nil || !got.Equal(cert) {
+		t.Errorf("expected read-back intermediate to match, instead %v, %v", got, err)
+	}
+	ecKey := signer.(*AWSKMSKey)
+	if _, ok := fake.aliases["alias/test-pending"]; ok {
+		t.Error("Store left the pending alias")
+	}
+	// A renewed certificate for the current key can be stored again.
+	if err := s.Store(cert, cert); err != nil {
+		t.Errorf("Store of a certificate for the current key returned %v", err)
+	}
+
+	// A new key is not used until a certificate is stored for it.
+	rsaSigner, err := s.GenerateAlgorithm(2048, "RSA")
+	if err != nil {
+		t.Fatalf("Generate returned %v", err)
+	}
+	key, err := s.Signer()
+	if err != nil {
+		t.Fatalf("Signer returned %v", err)
+	}
+	if key.(*AWSKMSKey).ID() != ecKey.ID() {
+		t.Errorf("Signer returned key %s, want %s", key.(*AWSKMSKey).ID(), ecKey.ID())
+	}
+
+	blob, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, rsaSigner.Public().(*rsa.PublicKey), []byte("secret"), nil)
+	if err != nil {
+		t.Fatalf("failed to encrypt test blob: %v", err)
+	}
+	plain, err := rsaSigner.(crypto.Decrypter).Decrypt(rand.Reader, blob, &rsa.OAEPOptions{Hash: crypto.SHA256})
+	if err != nil || string(plain) != "secret" {
+		t.Errorf("Decrypt returned %q, %v", plain, err)
+	}
+	if _, err := rsaSigner.(crypto.Decrypter).Decrypt(rand.Reader, blob, nil); err == nil {
+		t.Error("Decrypt with PKCS #1 v1.5 padding succeeded")
+	}
+	if _, err := key.(crypto.Decrypter).Decrypt(rand.Reader, blob, nil); err != ErrNotSupported {
+		t.Errorf("Decrypt with an ECDSA key returned %v, want ErrNotSupported", err)
+	}
+
+	rsaCert := signedBy(t, mustSoftwareRSA(t))
+	if err := s.Store(rsaCert, rsaCert); err == nil {
+		t.Error("Store succeeded with a certificate for an unknown key")
+	}
+
+	if err := s.Remove(false); err != nil {
+		t.Fatalf("Remove returned %v", err)
+	}
+	if key, err := s.Signer(); err != nil || key != nil {
+		t.Errorf("expected no key after remove, instead %v, %v", key, err)
+	}
+	if !fake.scheduled[ecKey.ID()] || !fake.scheduled[rsaSigner.(*AWSKMSKey).ID()] {
+		t.Errorf("Remove scheduled the deletion of %v, want both keys", fake.scheduled)
+	}
+	if err := s.Remove(false); err != nil {
+		t.Errorf("Remove of an empty account returned %v", err)
+	}
+}
+
+func mustSoftwareRSA(t *testing.T) crypto.Signer {
+	t.Helper()
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	return k
+}
+
+func TestAWSSigningAlgorithm(t *testing.T) {
+	rsaPub := &rsa.PublicKey{N: big.NewInt(1), E: 65537}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	for _, tc := range []struct {
+		pub  crypto.PublicKey
+		opts crypto.SignerOpts
+		want kmstypes.SigningAlgorithmSpec
+	}{
+		{rsaPub, crypto.SHA384, kmstypes.SigningAlgorithmSpecRsassaPkcs1V15Sha384},
+		{rsaPub, &rsa.PSSOptions{Hash: crypto.SHA256}, kmstypes.SigningAlgorithmSpecRsassaPssSha256},
+		{&p384.PublicKey, crypto.SHA384, kmstypes.SigningAlgorithmSpecEcdsaSha384},
+	} {
+		if got, err := awsSigningAlgorithm(tc.pub, tc.opts); err != nil || got != tc.want {
+			t.Errorf("awsSigningAlgorithm(%T, %v) = %s, %v, want %s", tc.pub, tc.opts.HashFunc(), got, err, tc.want)
+		}
+	}
+	for _, tc := range []struct {
+		pub  crypto.PublicKey
+		opts crypto.SignerOpts
+	}{
+		{rsaPub, crypto.SHA1},
+		{rsaPub, &rsa.PSSOptions{Hash: crypto.SHA256, SaltLength: 20}},
+		{&p384.PublicKey, crypto.SHA256},
+	} {
+		if _, err := awsSigningAlgorithm(tc.pub, tc.opts); err == nil {
+			t.Errorf("awsSigningAlgorithm(%T, %v) succeeded", tc.pub, tc.opts.HashFunc())
+		}
+	}
+}
+
+func TestAWSKMSKeyHelperVectors(t *testing.T) {
+	fake := newFakeAWS()
+	fake.keys["arn:aws:kms:us-east-1:111122223333:key/rsa"] = helpers.RSAKey()
+	fake.keys["arn:aws:kms:us-east-1:111122223333:key/ecdsa"] = helpers.ECDSAKey()
+	s := newAWSKMSStore(AWSKMSOptions{Name: "test"}, fake, fake)
+	for arn := range fake.keys {
+		key, err := s.key(arn)
+		if err != nil {
+			t.Fatalf("key(%s) returned %v", arn, err)
+		}
+		helpers.AssertSigner(t, key)
+	}
+}
@@ -0,0 +1,554 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build azurekv
+
+package certtostore
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+)
+
+const (
+	// azureKeyVersionTag is the tag of the certificate secret that records
+	// the version of the key the certificate is issued for.
+	azureKeyVersionTag = "key-version"
+	// defaultAzureTimeout bounds each Key Vault request if
+	// AzureKeyVaultOptions.Timeout is zero.
+	defaultAzureTimeout = time.Minute
+)
+
+// AzureKeyVaultOptions configures OpenAzureKeyVault.
+type AzureKeyVaultOptions struct {
+	// VaultURL is the URL of the vault, such as
+	// https://myvault.vault.azure.net/.
+	VaultURL string
+	// Credential authenticates to the vault, for example an
+	// azidentity.ManagedIdentityCredential.
+	Credential azcore.TokenCredential
+	// Name names the key and the secret holding the certificates, the way
+	// Key Vault names the key and secret of its own certificates.
+	Name string
+	// HSM creates keys protected by an HSM, which requires a premium vault.
+	HSM bool
+	// Timeout bounds each request to the vault, one minute by default.
+	Timeout time.Duration
+}
+
+func (o AzureKeyVaultOptions) validate() error {
+	switch {
+	case o.VaultURL == "":
+		return &ArgError{Op: "OpenAzureKeyVault", Arg: "opts", Reason: "no vault URL configured"}
+	case o.Credential == nil:
+		return &ArgError{Op: "OpenAzureKeyVault", Arg: "opts", Reason: "no credential configured"}
+	case o.Name == "":
+		return &ArgError{Op: "OpenAzureKeyVault", Arg: "opts", Reason: "no name configured"}
+	case o.Timeout < 0:
+		return &ArgError{Op: "OpenAzureKeyVault", Arg: "opts", Reason: fmt.Sprintf("negative timeout %v", o.Timeout)}
+	}
+	return nil
+}
+
+// azureKeys is the part of *azkeys.Client used by AzureKeyVaultStore.
+type azureKeys interface {
+	CreateKey(ctx context.Context, name string, parameters azkeys.CreateKeyParameters, options *azkeys.CreateKeyOptions) (azkeys.CreateKeyResponse, error)
+	GetKey(ctx context.Context, name string, version string, options *azkeys.GetKeyOptions) (azkeys.GetKeyResponse, error)
+	DeleteKey(ctx context.Context, name string, options *azkeys.DeleteKeyOptions) (azkeys.DeleteKeyResponse, error)
+	Sign(ctx context.Context, name string, version string, parameters azkeys.SignParameters, options *azkeys.SignOptions) (azkeys.SignResponse, error)
+	Decrypt(ctx context.Context, name string, version string, parameters azkeys.KeyOperationParameters, options *azkeys.DecryptOptions) (azkeys.DecryptResponse, error)
+}
+
+// azureSecrets is the part of *azsecrets.Client used by AzureKeyVaultStore.
+type azureSecrets interface {
+	GetSecret(ctx context.Context, name string, version string, options *azsecrets.GetSecretOptions) (azsecrets.GetSecretResponse, error)
+	SetSecret(ctx context.Context, name string, parameters azsecrets.SetSecretParameters, options *azsecrets.SetSecretOptions) (azsecrets.SetSecretResponse, error)
+	DeleteSecret(ctx context.Context, name string, options *azsecrets.DeleteSecretOptions) (azsecrets.DeleteSecretResponse, error)
+}
+
+// AzureKeyVaultStore stores a key in Azure Key Vault and its certificate and
+// intermediate in a secret of the same name, so cloud workloads can enroll
+// with the same code as Windows machines. Key Vault keeps every version of
+// the key; the secret records the version the certificate is issued for,
+// which is the one Signer returns. It is only built with the azurekv build
+// tag, so that importers who don't need it don't pull in the Azure SDK.
+type AzureKeyVaultStore struct {
+	name    string
+	hsm     bool
+	timeout time.Duration
+	keys    azureKeys
+	secrets azureSecrets
+}
+
+var _ CertStorage = &AzureKeyVaultStore{}
+var _ AlgorithmGenerator = &AzureKeyVaultStore{}
+var _ ManagedStorage = &AzureKeyVaultStore{}
+
+// OpenAzureKeyVault returns the store for the key and certificates named
+// opts.Name in the vault.
+func OpenAzureKeyVault(opts AzureKeyVaultOptions) (*AzureKeyVaultStore, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	keys, err := azkeys.NewClient(opts.VaultURL, opts.Credential, nil)
+	if err != nil {
+		return nil, fmt.Errorf("azkeys.NewClient returned %v", err)
+	}
+	secrets, err := azsecrets.NewClient(opts.VaultURL, opts.Credential, nil)
+	if err != nil {
+		return nil, fmt.Errorf("azsecrets.NewClient returned %v", err)
+	}
+	return newAzureKeyVaultStore(opts, keys, secrets), nil
+}
+
+func newAzureKeyVaultStore(opts AzureKeyVaultOptions, keys azureKeys, secrets azureSecrets) *AzureKeyVaultStore {
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = defaultAzureTimeout
+	}
+	return &AzureKeyVaultStore{name: opts.Name, hsm: opts.HSM, timeout: timeout, keys: keys, secrets: secrets}
+}
+
+// azureNotFound reports whether err is a 404 response of the vault.
+func azureNotFound(err error) bool {
+	var re *azcore.ResponseError
+	return errors.As(err, &re) && re.StatusCode == http.StatusNotFound
+}
+
+// chain returns the certificates of the secret and the key version they are
+// issued for, or nils if there is no secret.
+func (s *AzureKeyVaultStore) chain() ([]*x509.Certificate, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	resp, err := s.secrets.GetSecret(ctx, s.name, "", nil)
+	if azureNotFound(err) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("GetSecret(%s) returned %v", s.name, err)
+	}
+	if resp.Value == nil {
+		return nil, "", fmt.Errorf("secret %s has no value", s.name)
+	}
+	var certs []*x509.Certificate
+	rest := []byte(*resp.Value)
+	for {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, "", fmt.Errorf("could not parse certificate in secret %s: %v", s.name, err)
+		}
+		certs = append(certs, cert)
+	}
+	version := ""
+	if v := resp.Tags[azureKeyVersionTag]; v != nil {
+		version = *v
+	}
+	return certs, version, nil
+}
+
+// Cert returns the current certificate or nil if there is none.
+func (s *AzureKeyVaultStore) Cert() (*x509.Certificate, error) {
+	certs, _, err := s.chain()
+	if err != nil || len(certs) < 1 {
+		return nil, err
+	}
+	return certs[0], nil
+}
+
+// Intermediate returns the current intermediate certificate or nil if there
+// is none.
+func (s *AzureKeyVaultStore) Intermediate() (*x509.Certificate, error) {
+	certs, _, err := s.chain()
+	if err != nil || len(certs) < 2 {
+		return nil, err
+	}
+	return certs[1], nil
+}
+
+// Generate creates a new RSA key of keySize bits, see GenerateAlgorithm.
+func (s *AzureKeyVaultStore) Generate(keySize int) (crypto.Signer, error) {
+	return s.GenerateAlgorithm(keySize, "RSA")
+}
+
+// GenerateAlgorithm creates a new version of the key and returns a signer that
+// can be used to make a CSR for it. alg is "RSA", "ECDSA_P256", "ECDSA_P384" or
+// "ECDSA_P521", an empty alg generates an RSA key. Signer keeps returning the
+// current version until Store installs a certificate for the new one.
+func (s *AzureKeyVaultStore) GenerateAlgorithm(keySize int, alg string) (crypto.Signer, error) {
+	params := azkeys.CreateKeyParameters{
+		KeyOps: []*azkeys.KeyOperation{azureKeyOp(azkeys.KeyOperationSign)},
+		Tags:   map[string]*string{"created-by": azureString("certtostore")},
+	}
+	rsaType, ecType := azkeys.KeyTypeRSA, azkeys.KeyTypeEC
+	if s.hsm {
+		rsaType, ecType = azkeys.KeyTypeRSAHSM, azkeys.KeyTypeECHSM
+	}
+	var curve azkeys.CurveName
+	switch alg {
+	case "RSA", "":
+		size := int32(keySize)
+		params.Kty, params.KeySize = &rsaType, &size
+		params.KeyOps = append(params.KeyOps, azureKeyOp(azkeys.KeyOperationDecrypt))
+	case "ECDSA_P256":
+		curve = azkeys.CurveNameP256
+	case "ECDSA_P384":
+		curve = azkeys.CurveNameP384
+	case "ECDSA_P521":
+		curve = azkeys.CurveNameP521
+	default:
+		return nil, fmt.Errorf("unsupported algorithm: %s", alg)
+	}
+	if curve != "" {
+		params.Kty, params.Curve = &ecType, &curve
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	resp, err := s.keys.CreateKey(ctx, s.name, params, nil)
+	if err != nil {
+		return nil, fmt.Errorf("CreateKey(%s) returned %v", s.name, err)
+	}
+	k, err := s.key(resp.Key)
+	if err != nil {
+		return nil, err
+	}
+	logInfo("Created key version.", opField("generate"), containerField(s.name), field("version", k.version))
+	return k, nil
+}
+
+// key returns the AzureKey for a key returned by the vault.
+func (s *AzureKeyVaultStore) key(jwk *azkeys.JSONWebKey) (*AzureKey, error) {
+	if jwk == nil || jwk.KID == nil || jwk.Kty == nil {
+		return nil, errors.New("vault returned an incomplete key")
+	}
+	pub, err := azurePublicKey(jwk)
+	if err != nil {
+		return nil, err
+	}
+	return &AzureKey{store: s, version: jwk.KID.Version(), pub: pub}, nil
+}
+
+// azurePublicKey converts the public part of a JSON web key.
+func azurePublicKey(jwk *azkeys.JSONWebKey) (crypto.PublicKey, error) {
+	switch *jwk.Kty {
+	case azkeys.KeyTypeRSA, azkeys.KeyTypeRSAHSM:
+		e := new(big.Int).SetBytes(jwk.E)
+		if len(jwk.N) == 0 || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("vault returned an invalid RSA key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(jwk.N), E: int(e.Int64())}, nil
+	case azkeys.KeyTypeEC, azkeys.KeyTypeECHSM:
+		if jwk.Crv == nil {
+			return nil, errors.New("vault returned an EC key without a curve")
+		}
+		var curve elliptic.Curve
+		switch *jwk.Crv {
+		case azkeys.CurveNameP256:
+			curve = elliptic.P256()
+		case azkeys.CurveNameP384:
+			curve = elliptic.P384()
+		case azkeys.CurveNameP521:
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", *jwk.Crv)
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(jwk.X), Y: new(big.Int).SetBytes(jwk.Y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("vault returned an EC point that is not on the curve")
+		}
+		return pub, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", *jwk.Kty)
+}
+
+// Store writes cert and intermediate to the secret and records the key
+// version cert is issued for, which installs a key created by Generate. cert
+// must be issued for a version of the key.
+func (s *AzureKeyVaultStore) Store(cert *x509.Certificate, intermediate *x509.Certificate) error {
+	if err := checkCert("Store", "cert", cert); err != nil {
+		return err
+	}
+	if err := checkCert("Store", "intermediate", intermediate); err != nil {
+		return err
+	}
+	version, err := s.versionFor(cert)
+	if err != nil {
+		return err
+	}
+
+	var chain bytes.Buffer
+	for _, c := range []*x509.Certificate{cert, intermediate} {
+		if err := pem.Encode(&chain, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw}); err != nil {
+			return fmt.Errorf("could not encode cert to PEM: %v", err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	_, err = s.secrets.SetSecret(ctx, s.name, azsecrets.SetSecretParameters{
+		Value:       azureString(chain.String()),
+		ContentType: azureString("application/x-pem-file"),
+		Tags:        map[string]*string{azureKeyVersionTag: azureString(version)},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("SetSecret(%s) returned %v", s.name, err)
+	}
+	logInfo("Stored certificate.", opField("store"), containerField(s.name), field("version", version), field("thumbprint", thumbprint(cert)))
+	return nil
+}
+
+// versionFor returns the version of the key that cert is issued for. Only
+// the latest version is considered, which is the one Generate creates.
+func (s *AzureKeyVaultStore) versionFor(cert *x509.Certificate) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	resp, err := s.keys.GetKey(ctx, s.name, "", nil)
+	if azureNotFound(err) {
+		return "", errors.New("no key to store the certificate for, call Generate first")
+	}
+	if err != nil {
+		return "", fmt.Errorf("GetKey(%s) returned %v", s.name, err)
+	}
+	k, err := s.key(resp.Key)
+	if err != nil {
+		return "", err
+	}
+	if !k.pub.(interface{ Equal(crypto.PublicKey) bool }).Equal(cert.PublicKey) {
+		return "", fmt.Errorf("certificate %q is not issued for the latest version of key %s", cert.Subject, s.name)
+	}
+	return k.version, nil
+}
+
+// Signer returns the version of the key the current certificate is issued
+// for, or nil if there is no certificate. The key returned by Generate is
+// only installed by Store.
+func (s *AzureKeyVaultStore) Signer() (crypto.Signer, error) {
+	_, version, err := s.chain()
+	if err != nil || version == "" {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	resp, err := s.keys.GetKey(ctx, s.name, version, nil)
+	if azureNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("GetKey(%s/%s) returned %v", s.name, version, err)
+	}
+	return s.key(resp.Key)
+}
+
+// Remove deletes the key, with all its versions, and the certificates. The
+// vault keeps them recoverable for its soft delete retention period. Key
+// Vault has no system wide location, so removeSystem is ignored.
+func (s *AzureKeyVaultStore) Remove(removeSystem bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	if _, err := s.secrets.DeleteSecret(ctx, s.name, nil); err != nil && !azureNotFound(err) {
+		return fmt.Errorf("DeleteSecret(%s) returned %v", s.name, err)
+	}
+	if _, err := s.keys.DeleteKey(ctx, s.name, nil); err != nil && !azureNotFound(err) {
+		return fmt.Errorf("DeleteKey(%s) returned %v", s.name, err)
+	}
+	logInfo("Removed key and certificates.", opField("remove"), containerField(s.name))
+	return nil
+}
+
+// Link does nothing, access to the vault is granted by its access policies.
+func (s *AzureKeyVaultStore) Link() error {
+	return nil
+}
+
+// AzureKey is a version of a key in Azure Key Vault. It implements
+// crypto.Signer and, for RSA keys, crypto.Decrypter. ECDSA signatures are
+// ASN.1 encoded like those of crypto/ecdsa.
+type AzureKey struct {
+	store   *AzureKeyVaultStore
+	version string
+	pub     crypto.PublicKey
+}
+
+var (
+	_ crypto.Signer    = &AzureKey{}
+	_ crypto.Decrypter = &AzureKey{}
+	_ CryptoKey        = &AzureKey{}
+)
+
+// Public returns the public key to implement crypto.Signer.
+func (k *AzureKey) Public() crypto.PublicKey {
+	return k.pub
+}
+
+// Version returns the version of the key in the vault.
+func (k *AzureKey) Version() string {
+	return k.version
+}
+
+// PublicDER returns the DER encoded SubjectPublicKeyInfo of the key.
+func (k *AzureKey) PublicDER() ([]byte, error) {
+	return publicDER(k.pub)
+}
+
+// PublicPEM returns the SubjectPublicKeyInfo of the key as a PEM block.
+func (k *AzureKey) PublicPEM() ([]byte, error) {
+	return publicPEM(k.pub)
+}
+
+// azureSignatureAlgorithm returns the Key Vault algorithm signing a digest of
+// hash with pub. Key Vault uses a PSS salt as long as the hash, and ECDSA
+// algorithms fix the hash to the curve.
+func azureSignatureAlgorithm(pub crypto.PublicKey, opts crypto.SignerOpts) (azkeys.SignatureAlgorithm, error) {
+	hash := opts.HashFunc()
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if pssOpts, ok := opts.(*rsa.PSSOptions); ok {
+			if salt := pssOpts.SaltLength; salt != rsa.PSSSaltLengthAuto && salt != rsa.PSSSaltLengthEqualsHash && salt != hash.Size() {
+				return "", fmt.Errorf("PSS salt length %d is not supported by Key Vault, which uses %d", salt, hash.Size())
+			}
+			switch hash {
+			case crypto.SHA256:
+				return azkeys.SignatureAlgorithmPS256, nil
+			case crypto.SHA384:
+				return azkeys.SignatureAlgorithmPS384, nil
+			case crypto.SHA512:
+				return azkeys.SignatureAlgorithmPS512, nil
+			}
+		} else {
+			switch hash {
+			case crypto.SHA256:
+				return azkeys.SignatureAlgorithmRS256, nil
+			case crypto.SHA384:
+				return azkeys.SignatureAlgorithmRS384, nil
+			case crypto.SHA512:
+				return azkeys.SignatureAlgorithmRS512, nil
+			}
+		}
+	case *ecdsa.PublicKey:
+		switch {
+		case pub.Curve == elliptic.P256() && hash == crypto.SHA256:
+			return azkeys.SignatureAlgorithmES256, nil
+		case pub.Curve == elliptic.P384() && hash == crypto.SHA384:
+			return azkeys.SignatureAlgorithmES384, nil
+		case pub.Curve == elliptic.P521() && hash == crypto.SHA512:
+			return azkeys.SignatureAlgorithmES512, nil
+		}
+		return "", fmt.Errorf("Key Vault cannot sign %v digests with a %s key", hash, pub.Curve.Params().Name)
+	default:
+		return "", fmt.Errorf("unsupported public key type %T", pub)
+	}
+	return "", fmt.Errorf("unsupported hash algorithm %v", hash)
+}
+
+// Sign signs digest with the key to implement crypto.Signer. If opts is a
+// *rsa.PSSOptions RSA signatures use PSS padding, otherwise PKCS #1 v1.5.
+func (k *AzureKey) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts == nil {
+		return nil, &ArgError{Op: "Sign", Arg: "opts", Reason: "opts is nil"}
+	}
+	if err := checkDigest("Sign", digest, opts.HashFunc()); err != nil {
+		return nil, err
+	}
+	alg, err := azureSignatureAlgorithm(k.pub, opts)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), k.store.timeout)
+	defer cancel()
+	resp, err := k.store.keys.Sign(ctx, k.store.name, k.version, azkeys.SignParameters{Algorithm: &alg, Value: digest}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Sign(%s/%s) returned %v", k.store.name, k.version, err)
+	}
+	if _, ok := k.pub.(*ecdsa.PublicKey); ok {
+		return ecdsaRawToASN1(resp.Result)
+	}
+	return resp.Result, nil
+}
+
+// SignMessage hashes the message read from r with hash and signs the digest.
+func (k *AzureKey) SignMessage(r io.Reader, hash crypto.Hash) ([]byte, error) {
+	return SignMessage(k, r, hash)
+}
+
+// Decrypt decrypts blob with an RSA key to implement crypto.Decrypter. opts
+// is a *rsa.OAEPOptions with SHA-1 or SHA-256 and no label for OAEP padding,
+// or nil or a *rsa.PKCS1v15DecryptOptions for PKCS #1 v1.5 padding. ECDSA
+// keys return ErrNotSupported.
+func (k *AzureKey) Decrypt(rand io.Reader, blob []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	if _, ok := k.pub.(*rsa.PublicKey); !ok {
+		return nil, ErrNotSupported
+	}
+	if err := checkNotEmpty("Decrypt", "blob", blob); err != nil {
+		return nil, err
+	}
+	var alg azkeys.EncryptionAlgorithm
+	switch opts := opts.(type) {
+	case nil, *rsa.PKCS1v15DecryptOptions:
+		alg = azkeys.EncryptionAlgorithmRSA15
+	case *rsa.OAEPOptions:
+		if len(opts.Label) > 0 {
+			return nil, errors.New("Key Vault does not support OAEP labels")
+		}
+		if opts.MGFHash != 0 && opts.MGFHash != opts.Hash {
+			return nil, fmt.Errorf("MGF1 hash %v differs from the OAEP hash %v", opts.MGFHash, opts.Hash)
+		}
+		switch opts.Hash {
+		case crypto.SHA1:
+			alg = azkeys.EncryptionAlgorithmRSAOAEP
+		case crypto.SHA256:
+			alg = azkeys.EncryptionAlgorithmRSAOAEP256
+		default:
+			return nil, fmt.Errorf("unsupported OAEP hash %v", opts.Hash)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported decrypter options %T", opts)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), k.store.timeout)
+	defer cancel()
+	resp, err := k.store.keys.Decrypt(ctx, k.store.name, k.version, azkeys.KeyOperationParameters{Algorithm: &alg, Value: blob}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Decrypt(%s/%s) returned %v", k.store.name, k.version, err)
+	}
+	return resp.Result, nil
+}
+
+func azureString(s string) *string {
+	return &s
+}
+
+func azureKeyOp(op azkeys.KeyOperation) *azkeys.KeyOperation {
+	return &op
+}
@@ -0,0 +1,286 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build azurekv
+
+package certtostore
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/google/certtostore/testdata/helpers"
+)
+
+var errAzureNotFound = &azcore.ResponseError{StatusCode: http.StatusNotFound}
+
+// fakeAzureVault implements azureKeys and azureSecrets with software keys.
+type fakeAzureVault struct {
+	versions []crypto.Signer
+	secret   *azsecrets.Secret
+}
+
+func (f *fakeAzureVault) bundle(version int) azkeys.KeyBundle {
+	kid := azkeys.ID(fmt.Sprintf("https://vault.example/keys/test/v%d", version))
+	jwk := &azkeys.JSONWebKey{KID: &kid}
+	switch pub := f.versions[version].Public().(type) {
+	case *rsa.PublicKey:
+		kty := azkeys.KeyTypeRSA
+		jwk.Kty, jwk.N, jwk.E = &kty, pub.N.Bytes(), big.NewInt(int64(pub.E)).Bytes()
+	case *ecdsa.PublicKey:
+		kty, crv := azkeys.KeyTypeEC, azkeys.CurveName(pub.Curve.Params().Name)
+		jwk.Kty, jwk.Crv, jwk.X, jwk.Y = &kty, &crv, pub.X.Bytes(), pub.Y.Bytes()
+	}
+	return azkeys.KeyBundle{Key: jwk}
+}
+
+// version returns the signer of a version, the latest one for "".
+func (f *fakeAzureVault) version(v string) (crypto.Signer, error) {
+	if len(f.versions) == 0 {
+		return nil, errAzureNotFound
+	}
+	if v == "" {
+		return f.versions[len(f.versions)-1], nil
+	}
+	var i int
+	if _, err := fmt.Sscanf(v, "v%d", &i); err != nil || i >= len(f.versions) {
+		return nil, errAzureNotFound
+	}
+	return f.versions[i], nil
+}
+
+func (f *fakeAzureVault) CreateKey(ctx context.Context, name string, p azkeys.CreateKeyParameters, _ *azkeys.CreateKeyOptions) (azkeys.CreateKeyResponse, error) {
+	var key crypto.Signer
+	var err error
+	switch *p.Kty {
+	case azkeys.KeyTypeRSA:
+		key, err = rsa.GenerateKey(rand.Reader, int(*p.KeySize))
+	case azkeys.KeyTypeEC:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		err = fmt.Errorf("unexpected key type %s", *p.Kty)
+	}
+	if err != nil {
+		return azkeys.CreateKeyResponse{}, err
+	}
+	f.versions = append(f.versions, key)
+	return azkeys.CreateKeyResponse{KeyBundle: f.bundle(len(f.versions) - 1)}, nil
+}
+
+func (f *fakeAzureVault) GetKey(ctx context.Context, name, version string, _ *azkeys.GetKeyOptions) (azkeys.GetKeyResponse, error) {
+	if _, err := f.version(version); err != nil {
+		return azkeys.GetKeyResponse{}, err
+	}
+	i := len(f.versions) - 1
+	if version != "" {
+		fmt.Sscanf(version, "v%d", &i)
+	}
+	return azkeys.GetKeyResponse{KeyBundle: f.bundle(i)}, nil
+}
+
+func (f *fakeAzureVault) DeleteKey(ctx context.Context, name string, _ *azkeys.DeleteKeyOptions) (azkeys.DeleteKeyResponse, error) {
+	if len(f.versions) == 0 {
+		return azkeys.DeleteKeyResponse{}, errAzureNotFound
+	}
+	f.versions = nil
+	return azkeys.DeleteKeyResponse{}, nil
+}
+
+func (f *fakeAzureVault) Sign(ctx context.Context, name, version string, p azkeys.SignParameters, _ *azkeys.SignOptions) (azkeys.SignResponse, error) {
+	key, err := f.version(version)
+	if err != nil {
+		return azkeys.SignResponse{}, err
+	}
+	var sig []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		if *p.Algorithm != azkeys.SignatureAlgorithmRS256 {
+			return azkeys.SignResponse{}, fmt.Errorf("unexpected algorithm %s", *p.Algorithm)
+		}
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, p.Value)
+	case *ecdsa.PrivateKey:
+		if *p.Algorithm != azkeys.SignatureAlgorithmES256 {
+			return azkeys.SignResponse{}, fmt.Errorf("unexpected algorithm %s", *p.Algorithm)
+		}
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, key, p.Value)
+		if err == nil {
+			sig = make([]byte, 64)
+			r.FillBytes(sig[:32])
+			s.FillBytes(sig[32:])
+		}
+	}
+	return azkeys.SignResponse{KeyOperationResult: azkeys.KeyOperationResult{Result: sig}}, err
+}
+
+func (f *fakeAzureVault) Decrypt(ctx context.Context, name, version string, p azkeys.KeyOperationParameters, _ *azkeys.DecryptOptions) (azkeys.DecryptResponse, error) {
+	key, err := f.version(version)
+	if err != nil {
+		return azkeys.DecryptResponse{}, err
+	}
+	if *p.Algorithm != azkeys.EncryptionAlgorithmRSAOAEP256 {
+		return azkeys.DecryptResponse{}, fmt.Errorf("unexpected algorithm %s", *p.Algorithm)
+	}
+	plain, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, key.(*rsa.PrivateKey), p.Value, nil)
+	return azkeys.DecryptResponse{KeyOperationResult: azkeys.KeyOperationResult{Result: plain}}, err
+}
+
+func (f *fakeAzureVault) GetSecret(ctx context.Context, name, version string, _ *azsecrets.GetSecretOptions) (azsecrets.GetSecretResponse, error) {
+	if f.secret == nil {
+		return azsecrets.GetSecretResponse{}, errAzureNotFound
+	}
+	return azsecrets.GetSecretResponse{Secret: *f.secret}, nil
+}
+
+func (f *fakeAzureVault) SetSecret(ctx context.Context, name string, p azsecrets.SetSecretParameters, _ *azsecrets.SetSecretOptions) (azsecrets.SetSecretResponse, error) {
+	f.secret = &azsecrets.Secret{Value: p.Value, ContentType: p.ContentType, Tags: p.Tags}
+	return azsecrets.SetSecretResponse{}, nil
+}
+
+func (f *fakeAzureVault) DeleteSecret(ctx context.Context, name string, _ *azsecrets.DeleteSecretOptions) (azsecrets.DeleteSecretResponse, error) {
+	if f.secret == nil {
+		return azsecrets.DeleteSecretResponse{}, errAzureNotFound
+	}
+	f.secret = nil
+	return azsecrets.DeleteSecretResponse{}, nil
+}
+
+func TestAzureKeyVaultStore(t *testing.T) {
+	if _, err := OpenAzureKeyVault(AzureKeyVaultOptions{VaultURL: "https://vault.example/"}); err == nil {
+		t.Error("OpenAzureKeyVault succeeded without a credential")
+	}
+
+	vault := &fakeAzureVault{}
+	s := newAzureKeyVaultStore(AzureKeyVaultOptions{Name: "test"}, vault, vault)
+	if key, err := s.Signer(); err != nil || key != nil {
+		t.Errorf("expected no key on an empty vault, instead %v, %v", key, err)
+	}
+	if cert, err := s.Cert(); err != nil || cert != nil {
+		t.Errorf("expected no cert on an empty vault, instead %v, %v", cert, err)
+	}
+
+	signer, err := s.GenerateAlgorithm(0, "ECDSA_P256")
+	if err != nil {
+		t.Fatalf("Generate returned %v", err)
+	}
+	// The vault signs in r||s form, which the key converts for x509.
+	cert := signedBy(t, signer)
+	if key, _ := s.Signer(); key != nil {
+		t.Error("Generate installed the key before Store")
+	}
+	if err := s.Store(cert, cert); err != nil {
+		t.Fatalf("Store returned %v", err)
+	}
+	if got, err := s.Cert(); err != nil || !got.Equal(cert) {
+		t.Errorf("expected read-back cert to match, instead %v, %v", got, err)
+	}
+	if got, err := s.Intermediate(); err != nil || !got.Equal(cert) {
+		t.Errorf("expected read-back intermediate to match, instead %v, %v", got, err)
+	}
+
+	// A new version is not used until a certificate is stored for it.
+	rsaSigner, err := s.GenerateAlgorithm(2048, "RSA")
+	if err != nil {
+		t.Fatalf("Generate returned %v", err)
+	}
+	key, err := s.Signer()
+	if err != nil {
+		t.Fatalf("Signer returned %v", err)
+	}
+	if key.(*AzureKey).Version() != "v0" {
+		t.Errorf("Signer returned version %q, want v0", key.(*AzureKey).Version())
+	}
+	if err := s.Store(cert, cert); err == nil {
+		t.Error("Store succeeded with a certificate for an older version")
+	}
+
+	blob, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, rsaSigner.Public().(*rsa.PublicKey), []byte("secret"), nil)
+	if err != nil {
+		t.Fatalf("failed to encrypt test blob: %v", err)
+	}
+	plain, err := rsaSigner.(crypto.Decrypter).Decrypt(rand.Reader, blob, &rsa.OAEPOptions{Hash: crypto.SHA256})
+	if err != nil || string(plain) != "secret" {
+		t.Errorf("Decrypt returned %q, %v", plain, err)
+	}
+	if _, err := key.(crypto.Decrypter).Decrypt(rand.Reader, blob, nil); err != ErrNotSupported {
+		t.Errorf("Decrypt with an ECDSA key returned %v, want ErrNotSupported", err)
+	}
+
+	if err := s.Remove(false); err != nil {
+		t.Fatalf("Remove returned %v", err)
+	}
+	if key, err := s.Signer(); err != nil || key != nil {
+		t.Errorf("expected no key after remove, instead %v, %v", key, err)
+	}
+	if err := s.Remove(false); err != nil {
+		t.Errorf("Remove of an empty vault returned %v", err)
+	}
+}
+
+func TestAzureSignatureAlgorithm(t *testing.T) {
+	rsaPub := &rsa.PublicKey{N: big.NewInt(1), E: 65537}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	for _, tc := range []struct {
+		pub  crypto.PublicKey
+		opts crypto.SignerOpts
+		want azkeys.SignatureAlgorithm
+	}{
+		{rsaPub, crypto.SHA384, azkeys.SignatureAlgorithmRS384},
+		{rsaPub, &rsa.PSSOptions{Hash: crypto.SHA256}, azkeys.SignatureAlgorithmPS256},
+		{&p384.PublicKey, crypto.SHA384, azkeys.SignatureAlgorithmES384},
+	} {
+		if got, err := azureSignatureAlgorithm(tc.pub, tc.opts); err != nil || got != tc.want {
+			t.Errorf("azureSignatureAlgorithm(%T, %v) = %s, %v, want %s", tc.pub, tc.opts.HashFunc(), got, err, tc.want)
+		}
+	}
+	for _, tc := range []struct {
+		pub  crypto.PublicKey
+		opts crypto.SignerOpts
+	}{
+		{rsaPub, crypto.SHA1},
+		{rsaPub, &rsa.PSSOptions{Hash: crypto.SHA256, SaltLength: 20}},
+		{&p384.PublicKey, crypto.SHA256},
+	} {
+		if _, err := azureSignatureAlgorithm(tc.pub, tc.opts); err == nil {
+			t.Errorf("azureSignatureAlgorithm(%T, %v) succeeded", tc.pub, tc.opts.HashFunc())
+		}
+	}
+}
+
+func TestAzureKeyHelperVectors(t *testing.T) {
+	vault := &fakeAzureVault{versions: []crypto.Signer{helpers.RSAKey(), helpers.ECDSAKey()}}
+	s := newAzureKeyVaultStore(AzureKeyVaultOptions{Name: "test"}, vault, vault)
+	for i := range vault.versions {
+		key, err := s.key(vault.bundle(i).Key)
+		if err != nil {
+			t.Fatalf("key(v%d) returned %v", i, err)
+		}
+		helpers.AssertSigner(t, key)
+	}
+}
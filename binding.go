@@ -0,0 +1,111 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"strings"
+	"time"
+)
+
+// Binding states reported in a HostnameBinding.
+const (
+	// BindingOK means the hostname is covered by a certificate that does not
+	// expire soon.
+	BindingOK = "ok"
+	// BindingExpiring means all certificates covering the hostname expire
+	// within the warning period.
+	BindingExpiring = "expiring"
+	// BindingMissing means no valid certificate covers the hostname.
+	BindingMissing = "missing"
+)
+
+// BoundCert is a certificate that covers a hostname.
+type BoundCert struct {
+	Thumbprint string    `json:"thumbprint"`
+	Subject    string    `json:"subject"`
+	NotAfter   time.Time `json:"not_after"`
+	// Wildcard is set if the hostname is only covered by a wildcard name.
+	Wildcard bool `json:"wildcard,omitempty"`
+}
+
+// HostnameBinding lists the certificates that cover a hostname.
+type HostnameBinding struct {
+	Hostname     string      `json:"hostname"`
+	Status       string      `json:"status"`
+	Certificates []BoundCert `json:"certificates,omitempty"`
+}
+
+// BindingReport describes which certificates cover the hostnames a machine
+// serves. It is meant to be encoded as JSON for ops automation.
+type BindingReport struct {
+	Generated time.Time         `json:"generated"`
+	Hostnames []HostnameBinding `json:"hostnames"`
+}
+
+// Gaps returns the hostnames that are not covered or only by certificates
+// that expire soon.
+func (r *BindingReport) Gaps() []HostnameBinding {
+	var gaps []HostnameBinding
+	for _, h := range r.Hostnames {
+		if h.Status != BindingOK {
+			gaps = append(gaps, h)
+		}
+	}
+	return gaps
+}
+
+// bindHostnames reports which of certs cover each of hostnames at now. Certs
+// that are expired or not yet valid are ignored. A hostname is reported as
+// expiring if all certificates covering it expire within warn.
+func bindHostnames(hostnames []string, certs []*x509.Certificate, now time.Time, warn time.Duration) *BindingReport {
+	report := &BindingReport{Generated: now}
+	for _, host := range hostnames {
+		b := HostnameBinding{Hostname: host, Status: BindingMissing}
+		for _, cert := range certs {
+			if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+				continue
+			}
+			if cert.VerifyHostname(host) != nil {
+				continue
+			}
+			b.Certificates = append(b.Certificates, BoundCert{
+				Thumbprint: thumbprint(cert),
+				Subject:    cert.Subject.String(),
+				NotAfter:   cert.NotAfter,
+				Wildcard:   !hasExactName(cert, host),
+			})
+			if cert.NotAfter.Sub(now) > warn {
+				b.Status = BindingOK
+			} else if b.Status == BindingMissing {
+				b.Status = BindingExpiring
+			}
+		}
+		report.Hostnames = append(report.Hostnames, b)
+	}
+	return report
+}
+
+// hasExactName reports whether host is one of the DNS names of cert, rather
+// than only matching a wildcard.
+func hasExactName(cert *x509.Certificate, host string) bool {
+	host = strings.TrimSuffix(host, ".")
+	for _, name := range cert.DNSNames {
+		if strings.EqualFold(name, host) {
+			return true
+		}
+	}
+	return false
+}
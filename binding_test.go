@@ -0,0 +1,73 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestBindHostnames(t *testing.T) {
+	now := time.Now()
+	wildcard := &x509.Certificate{
+		DNSNames:  []string{"*.example.com"},
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.Add(90 * 24 * time.Hour),
+	}
+	expiring := &x509.Certificate{
+		DNSNames:  []string{"api.example.org"},
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.Add(24 * time.Hour),
+	}
+	expired := &x509.Certificate{
+		DNSNames:  []string{"old.example.net"},
+		NotBefore: now.Add(-48 * time.Hour),
+		NotAfter:  now.Add(-24 * time.Hour),
+	}
+	exact := &x509.Certificate{
+		DNSNames:  []string{"www.example.com"},
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.Add(24 * time.Hour),
+	}
+	certs := []*x509.Certificate{wildcard, expiring, expired, exact}
+
+	report := bindHostnames([]string{"www.example.com", "api.example.org", "old.example.net", "a.b.example.com"}, certs, now, 30*24*time.Hour)
+	want := []struct {
+		status string
+		certs  int
+	}{
+		{BindingOK, 2},
+		{BindingExpiring, 1},
+		{BindingMissing, 0},
+		{BindingMissing, 0},
+	}
+	for i, w := range want {
+		got := report.Hostnames[i]
+		if got.Status != w.status || len(got.Certificates) != w.certs {
+			t.Errorf("binding for %s: got status %q with %d certificates, want %q with %d", got.Hostname, got.Status, len(got.Certificates), w.status, w.certs)
+		}
+	}
+	if c := report.Hostnames[0].Certificates; !c[0].Wildcard || c[1].Wildcard {
+		t.Errorf("unexpected wildcard flags for www.example.com: %+v", c)
+	}
+	if gaps := report.Gaps(); len(gaps) != 3 {
+		t.Errorf("Gaps() returned %d hostnames, want 3", len(gaps))
+	}
+	if _, err := json.Marshal(report); err != nil {
+		t.Errorf("failed to encode report: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// HostnameReport reports which certificates in the store identified by loc
+// cover each of hostnames, including through wildcard names. Hostnames
+// without a valid certificate are reported as missing, and hostnames whose
+// certificates all expire within warn as expiring.
+func (w *WinCertStore) HostnameReport(hostnames []string, loc StoreLocation, warn time.Duration) (*BindingReport, error) {
+	certStore, err := openStore(loc, w.lookupFlags())
+	if err != nil {
+		return nil, fmt.Errorf("CertOpenStore for %s returned %v", loc, err)
+	}
+	defer windows.CertCloseStore(certStore, 0)
+
+	certs, err := storeCerts(certStore, loc.String())
+	if err != nil {
+		return nil, err
+	}
+	return bindHostnames(hostnames, certs, time.Now(), warn), nil
+}
+
+// storeCerts returns all certificates in certStore, which is described by
+// name in errors. Certificates that cannot be parsed are skipped.
+func storeCerts(certStore windows.Handle, name string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	// findCert frees prev, so no context needs to be freed after the loop.
+	var prev *windows.CertContext
+	for {
+		nc, err := findCert(certStore, encodingX509ASN|encodingPKCS7, 0, findAny, nil, prev)
+		if err != nil {
+			return nil, fmt.Errorf("finding certificates in %s: %v", name, err)
+		}
+		if nc == nil {
+			return certs, nil
+		}
+		prev = nc
+		xc, err := x509.ParseCertificate(certContextBytes(nc))
+		if err != nil {
+			logWarning("Skipping certificate that could not be parsed.", field("store", name), errField(err))
+			continue
+		}
+		certs = append(certs, xc)
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+)
+
+const (
+	// Magic number for RSA1 public key blobs.
+	rsa1Magic = 0x31415352 // "RSA1"
+	// Magic number for RSA3 full private key blobs.
+	rsa3Magic = 0x33415352 // "RSA3"
+	// https://github.com/dotnet/corefx/blob/master/src/Common/src/Interop/Windows/BCrypt/Interop.Blobs.cs#L92
+	ecdsaP256Magic = 0x31534345
+	ecdsaP384Magic = 0x33534345
+	ecdsaP521Magic = 0x35534345
+	ecdhP256Magic  = 0x314B4345
+	ecdhP384Magic  = 0x334B4345
+	ecdhP521Magic  = 0x354B4345
+	// Private key blob magics, from bcrypt.h.
+	ecdsaP256PrivateMagic = 0x32534345
+	ecdsaP384PrivateMagic = 0x34534345
+	ecdsaP521PrivateMagic = 0x36534345
+	ecdhP256PrivateMagic  = 0x324B4345
+	ecdhP384PrivateMagic  = 0x344B4345
+	ecdhP521PrivateMagic  = 0x364B4345
+
+	// Sizes of the BCRYPT_RSAKEY_BLOB and BCRYPT_ECCKEY_BLOB headers.
+	rsaBlobHeaderSize = 24
+	eccBlobHeaderSize = 8
+)
+
+// UnmarshalRSAPublicBlob parses a BCRYPT_RSAPUBLIC_BLOB as exported by
+// NCryptExportKey or BCryptExportKey. The blob must contain exactly the
+// exponent and modulus described by its header.
+func UnmarshalRSAPublicBlob(buf []byte) (*rsa.PublicKey, error) {
+	if len(buf) < rsaBlobHeaderSize {
+		return nil, fmt.Errorf("RSA public blob is too short (%d bytes)", len(buf))
+	}
+	// BCRYPT_RSAKEY_BLOB from bcrypt.h
+	magic := binary.LittleEndian.Uint32(buf[0:])
+	bitLength := binary.LittleEndian.Uint32(buf[4:])
+	expSize := uint64(binary.LittleEndian.Uint32(buf[8:]))
+	modSize := uint64(binary.LittleEndian.Uint32(buf[12:]))
+	prime1Size := binary.LittleEndian.Uint32(buf[16:])
+	prime2Size := binary.LittleEndian.Uint32(buf[20:])
+
+	if magic != rsa1Magic {
+		return nil, fmt.Errorf("invalid header magic %x", magic)
+	}
+	if prime1Size != 0 || prime2Size != 0 {
+		return nil, fmt.Errorf("RSA public blob declares private key primes")
+	}
+	if expSize == 0 || expSize > 4 {
+		return nil, fmt.Errorf("unsupported public exponent size (%d bits)", expSize*8)
+	}
+	if modSize == 0 || uint64(bitLength) > modSize*8 || uint64(bitLength) <= (modSize-1)*8 {
+		return nil, fmt.Errorf("modulus size %d bytes does not match bit length %d", modSize, bitLength)
+	}
+	if want := rsaBlobHeaderSize + expSize + modSize; uint64(len(buf)) != want {
+		return nil, fmt.Errorf("RSA public blob has %d bytes, header describes %d", len(buf), want)
+	}
+
+	body := buf[rsaBlobHeaderSize:]
+	e := new(big.Int).SetBytes(body[:expSize])
+	n := new(big.Int).SetBytes(body[expSize:])
+	// The exponent fits in 32 bits, but must also fit in an int on 32 bit platforms.
+	if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 || e.Bit(0) == 0 {
+		return nil, fmt.Errorf("invalid public exponent %v", e)
+	}
+	if n.BitLen() != int(bitLength) {
+		return nil, fmt.Errorf("modulus has %d bits, header declares %d", n.BitLen(), bitLength)
+	}
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+// UnmarshalECCPublicBlob parses a BCRYPT_ECCPUBLIC_BLOB for the NIST P-256,
+// P-384 or P-521 curves, as exported by NCryptExportKey or BCryptExportKey for
+// ECDSA and ECDH keys. The blob must contain exactly the point described by
+// its header, and the point must be on the curve.
+func UnmarshalECCPublicBlob(buf []byte) (*ecdsa.PublicKey, error) {
+	if len(buf) < eccBlobHeaderSize {
+		return nil, fmt.Errorf("ECC public blob is too short (%d bytes)", len(buf))
+	}
+	// BCRYPT_ECCKEY_BLOB from bcrypt.h
+	magic := binary.LittleEndian.Uint32(buf[0:])
+	cbKey := uint64(binary.LittleEndian.Uint32(buf[4:]))
+
+	var curve elliptic.Curve
+	switch magic {
+	case ecdsaP256Magic, ecdhP256Magic:
+		curve = elliptic.P256()
+	case ecdsaP384Magic, ecdhP384Magic:
+		curve = elliptic.P384()
+	case ecdsaP521Magic, ecdhP521Magic:
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported ECC header magic %x", magic)
+	}
+
+	if want := uint64(curve.Params().BitSize+7) / 8; cbKey != want {
+		return nil, fmt.Errorf("ECC key size %d does not match %s size %d", cbKey, curve.Params().Name, want)
+	}
+	if want := eccBlobHeaderSize + 2*cbKey; uint64(len(buf)) != want {
+		return nil, fmt.Errorf("ECC public blob has %d bytes, header describes %d", len(buf), want)
+	}
+
+	body := buf[eccBlobHeaderSize:]
+	x := new(big.Int).SetBytes(body[:cbKey])
+	y := new(big.Int).SetBytes(body[cbKey:])
+	if !curve.IsOnCurve(x, y) {
+		return nil, fmt.Errorf("public point is not on curve %s", curve.Params().Name)
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// marshalECDHPublicBlob encodes pub as a BCRYPT_ECCPUBLIC_BLOB for an ECDH
+// key, as expected by NCryptImportKey.
+func marshalECDHPublicBlob(pub *ecdsa.PublicKey) ([]byte, error) {
+	var magic uint32
+	switch pub.Curve {
+	case elliptic.P256():
+		magic = ecdhP256Magic
+	case elliptic.P384():
+		magic = ecdhP384Magic
+	case elliptic.P521():
+		magic = ecdhP521Magic
+	default:
+		return nil, fmt.Errorf("unsupported curve %s", pub.Curve.Params().Name)
+	}
+	size := (pub.Curve.Params().BitSize + 7) / 8
+	buf := make([]byte, eccBlobHeaderSize+2*size)
+	binary.LittleEndian.PutUint32(buf[0:], magic)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(size))
+	x, y := pub.X.Bytes(), pub.Y.Bytes()
+	copy(buf[eccBlobHeaderSize+size-len(x):], x)
+	copy(buf[eccBlobHeaderSize+2*size-len(y):], y)
+	return buf, nil
+}
+
+// unmarshalRSAFullPrivateBlob parses a BCRYPT_RSAFULLPRIVATE_BLOB as exported
+// by ExportFullPrivateBlob. The CRT values in the blob are recomputed by
+// Precompute rather than trusted, but the key is validated as a whole.
+func unmarshalRSAFullPrivateBlob(buf []byte) (*rsa.PrivateKey, error) {
+	if len(buf) < rsaBlobHeaderSize {
+		return nil, fmt.Errorf("RSA private blob is too short (%d bytes)", len(buf))
+	}
+	magic := binary.LittleEndian.Uint32(buf[0:])
+	bitLength := binary.LittleEndian.Uint32(buf[4:])
+	expSize := uint64(binary.LittleEndian.Uint32(buf[8:]))
+	modSize := uint64(binary.LittleEndian.Uint32(buf[12:]))
+	prime1Size := uint64(binary.LittleEndian.Uint32(buf[16:]))
+	prime2Size := uint64(binary.LittleEndian.Uint32(buf[20:]))
+
+	if magic != rsa3Magic {
+		return nil, fmt.Errorf("invalid header magic %x", magic)
+	}
+	if expSize == 0 || expSize > 4 {
+		return nil, fmt.Errorf("unsupported public exponent size (%d bits)", expSize*8)
+	}
+	if prime1Size == 0 || prime2Size == 0 {
+		return nil, fmt.Errorf("RSA private blob does not declare the primes")
+	}
+	// Exponent, modulus, prime1, prime2, exponent1, exponent2, coefficient
+	// and private exponent, in that order.
+	want := rsaBlobHeaderSize + expSize + modSize + 2*prime1Size + 2*prime2Size + prime1Size + modSize
+	if uint64(len(buf)) != want {
+		return nil, fmt.Errorf("RSA private blob has %d bytes, header describes %d", len(buf), want)
+	}
+
+	body := buf[rsaBlobHeaderSize:]
+	next := func(n uint64) *big.Int {
+		v := new(big.Int).SetBytes(body[:n])
+		body = body[n:]
+		return v
+	}
+	e := next(expSize)
+	n := next(modSize)
+	p := next(prime1Size)
+	q := next(prime2Size)
+	next(prime1Size) // exponent1
+	next(prime2Size) // exponent2
+	next(prime1Size) // coefficient
+	d := next(modSize)
+
+	if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 || e.Bit(0) == 0 {
+		return nil, fmt.Errorf("invalid public exponent %v", e)
+	}
+	if n.BitLen() != int(bitLength) {
+		return nil, fmt.Errorf("modulus has %d bits, header declares %d", n.BitLen(), bitLength)
+	}
+	key := &rsa.PrivateKey{
+		PublicKey: rsa.PublicKey{N: n, E: int(e.Int64())},
+		D:         d,
+		Primes:    []*big.Int{p, q},
+	}
+	if err := key.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid RSA private blob: %v", err)
+	}
+	key.Precompute()
+	return key, nil
+}
+
+// unmarshalECCPrivateBlob parses a BCRYPT_ECCPRIVATE_BLOB for the NIST P-256,
+// P-384 or P-521 curves, as exported by ExportFullPrivateBlob. The private
+// scalar must match the public point in the blob.
+func unmarshalECCPrivateBlob(buf []byte) (*ecdsa.PrivateKey, error) {
+	if len(buf) < eccBlobHeaderSize {
+		return nil, fmt.Errorf("ECC private blob is too short (%d bytes)", len(buf))
+	}
+	magic := binary.LittleEndian.Uint32(buf[0:])
+	cbKey := uint64(binary.LittleEndian.Uint32(buf[4:]))
+
+	var curve elliptic.Curve
+	switch magic {
+	case ecdsaP256PrivateMagic, ecdhP256PrivateMagic:
+		curve = elliptic.P256()
+	case ecdsaP384PrivateMagic, ecdhP384PrivateMagic:
+		curve = elliptic.P384()
+	case ecdsaP521PrivateMagic, ecdhP521PrivateMagic:
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported ECC header magic %x", magic)
+	}
+
+	if want := uint64(curve.Params().BitSize+7) / 8; cbKey != want {
+		return nil, fmt.Errorf("ECC key size %d does not match %s size %d", cbKey, curve.Params().Name, want)
+	}
+	if want := eccBlobHeaderSize + 3*cbKey; uint64(len(buf)) != want {
+		return nil, fmt.Errorf("ECC private blob has %d bytes, header describes %d", len(buf), want)
+	}
+
+	body := buf[eccBlobHeaderSize:]
+	x := new(big.Int).SetBytes(body[:cbKey])
+	y := new(big.Int).SetBytes(body[cbKey : 2*cbKey])
+	d := new(big.Int).SetBytes(body[2*cbKey:])
+	if d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+		return nil, fmt.Errorf("private scalar is out of range for curve %s", curve.Params().Name)
+	}
+	if px, py := curve.ScalarBaseMult(d.Bytes()); px.Cmp(x) != 0 || py.Cmp(y) != 0 {
+		return nil, fmt.Errorf("private scalar does not match the public point")
+	}
+	return &ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: curve, X: x, Y: y}, D: d}, nil
+}
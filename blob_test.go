@@ -0,0 +1,295 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
+	mrand "math/rand"
+	"testing"
+
+	"github.com/google/certtostore/testdata/helpers"
+)
+
+// rsaPublicBlob builds a BCRYPT_RSAPUBLIC_BLOB the way CNG exports it.
+func rsaPublicBlob(pub *rsa.PublicKey) []byte {
+	exp := big.NewInt(int64(pub.E)).Bytes()
+	mod := pub.N.Bytes()
+	buf := make([]byte, rsaBlobHeaderSize)
+	binary.LittleEndian.PutUint32(buf[0:], rsa1Magic)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(pub.N.BitLen()))
+	binary.LittleEndian.PutUint32(buf[8:], uint32(len(exp)))
+	binary.LittleEndian.PutUint32(buf[12:], uint32(len(mod)))
+	buf = append(buf, exp...)
+	return append(buf, mod...)
+}
+
+// eccPublicBlob builds a BCRYPT_ECCPUBLIC_BLOB the way CNG exports it.
+func eccPublicBlob(magic uint32, pub *ecdsa.PublicKey) []byte {
+	size := (pub.Curve.Params().BitSize + 7) / 8
+	buf := make([]byte, eccBlobHeaderSize+2*size)
+	binary.LittleEndian.PutUint32(buf[0:], magic)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(size))
+	x, y := pub.X.Bytes(), pub.Y.Bytes()
+	copy(buf[eccBlobHeaderSize+size-len(x):], x)
+	copy(buf[eccBlobHeaderSize+2*size-len(y):], y)
+	return buf
+}
+
+func TestUnmarshalRSAPublicBlob(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	blob := rsaPublicBlob(&key.PublicKey)
+
+	pub, err := UnmarshalRSAPublicBlob(blob)
+	if err != nil {
+		t.Fatalf("UnmarshalRSAPublicBlob returned %v", err)
+	}
+	if pub.E != key.E || pub.N.Cmp(key.N) != 0 {
+		t.Error("unmarshaled public key does not match")
+	}
+
+	// Blobs whose length does not match their header must be rejected.
+	if _, err := UnmarshalRSAPublicBlob(blob[:len(blob)-1]); err == nil {
+		t.Error("UnmarshalRSAPublicBlob accepted a truncated blob")
+	}
+	if _, err := UnmarshalRSAPublicBlob(append(blob, 0)); err == nil {
+		t.Error("UnmarshalRSAPublicBlob accepted trailing data")
+	}
+	huge := append([]byte(nil), blob...)
+	binary.LittleEndian.PutUint32(huge[12:], 0xFFFFFFFF)
+	if _, err := UnmarshalRSAPublicBlob(huge); err == nil {
+		t.Error("UnmarshalRSAPublicBlob accepted an oversized modulus length")
+	}
+}
+
+func TestUnmarshalECCPublicBlob(t *testing.T) {
+	for _, tt := range []struct {
+		curve elliptic.Curve
+		magic uint32
+	}{
+		{elliptic.P256(), ecdsaP256Magic},
+		{elliptic.P384(), ecdsaP384Magic},
+		{elliptic.P521(), ecdsaP521Magic},
+		{elliptic.P256(), ecdhP256Magic},
+	} {
+		key, err := ecdsa.GenerateKey(tt.curve, rand.Reader)
+		if err != nil {
+			t.Fatalf("failed to generate test key: %v", err)
+		}
+		blob := eccPublicBlob(tt.magic, &key.PublicKey)
+		pub, err := UnmarshalECCPublicBlob(blob)
+		if err != nil {
+			t.Fatalf("UnmarshalECCPublicBlob(%s) returned %v", tt.curve.Params().Name, err)
+		}
+		if pub.Curve != tt.curve || pub.X.Cmp(key.X) != 0 || pub.Y.Cmp(key.Y) != 0 {
+			t.Errorf("unmarshaled %s public key does not match", tt.curve.Params().Name)
+		}
+
+		if _, err := UnmarshalECCPublicBlob(blob[:len(blob)-1]); err == nil {
+			t.Errorf("UnmarshalECCPublicBlob(%s) accepted a truncated blob", tt.curve.Params().Name)
+		}
+		offCurve := append([]byte(nil), blob...)
+		offCurve[len(offCurve)-1] ^= 1
+		if _, err := UnmarshalECCPublicBlob(offCurve); err == nil {
+			t.Errorf("UnmarshalECCPublicBlob(%s) accepted a point that is not on the curve", tt.curve.Params().Name)
+		}
+	}
+}
+
+func TestMarshalECDHPublicBlob(t *testing.T) {
+	for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			t.Fatalf("failed to generate test key: %v", err)
+		}
+		blob, err := marshalECDHPublicBlob(&key.PublicKey)
+		if err != nil {
+			t.Fatalf("marshalECDHPublicBlob(%s) returned %v", curve.Params().Name, err)
+		}
+		pub, err := UnmarshalECCPublicBlob(blob)
+		if err != nil {
+			t.Fatalf("UnmarshalECCPublicBlob(%s) returned %v", curve.Params().Name, err)
+		}
+		if pub.X.Cmp(key.X) != 0 || pub.Y.Cmp(key.Y) != 0 {
+			t.Errorf("%s public key did not round trip", curve.Params().Name)
+		}
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	if _, err := marshalECDHPublicBlob(&key.PublicKey); err == nil {
+		t.Error("marshalECDHPublicBlob accepted a P-224 key")
+	}
+}
+
+// TestUnmarshalBlobsMalformed feeds randomly corrupted blobs to the parsers,
+// which must return errors rather than panic or over-read.
+func TestUnmarshalBlobsMalformed(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	seeds := [][]byte{
+		rsaPublicBlob(&rsaKey.PublicKey),
+		eccPublicBlob(ecdsaP256Magic, &ecKey.PublicKey),
+		rsaFullPrivateBlob(rsaKey),
+		eccPrivateBlob(ecdsaP256PrivateMagic, ecKey),
+		{},
+	}
+
+	rnd := mrand.New(mrand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		b := append([]byte(nil), seeds[rnd.Intn(len(seeds))]...)
+		switch rnd.Intn(3) {
+		case 0:
+			if len(b) > 0 {
+				b = b[:rnd.Intn(len(b))]
+			}
+		case 1:
+			for j := 0; j < 1+rnd.Intn(4) && len(b) > 0; j++ {
+				b[rnd.Intn(len(b))] = byte(rnd.Intn(256))
+			}
+		case 2:
+			extra := make([]byte, rnd.Intn(16))
+			rnd.Read(extra)
+			b = append(b, extra...)
+		}
+		UnmarshalRSAPublicBlob(b)
+		UnmarshalECCPublicBlob(b)
+		unmarshalRSAFullPrivateBlob(b)
+		unmarshalECCPrivateBlob(b)
+	}
+}
+
+// rsaFullPrivateBlob builds a BCRYPT_RSAFULLPRIVATE_BLOB the way CNG exports
+// it, with every value padded to the size declared in the header.
+func rsaFullPrivateBlob(key *rsa.PrivateKey) []byte {
+	exp := big.NewInt(int64(key.E)).Bytes()
+	modSize := (key.N.BitLen() + 7) / 8
+	p1 := (key.Primes[0].BitLen() + 7) / 8
+	p2 := (key.Primes[1].BitLen() + 7) / 8
+	buf := make([]byte, rsaBlobHeaderSize)
+	binary.LittleEndian.PutUint32(buf[0:], rsa3Magic)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(key.N.BitLen()))
+	binary.LittleEndian.PutUint32(buf[8:], uint32(len(exp)))
+	binary.LittleEndian.PutUint32(buf[12:], uint32(modSize))
+	binary.LittleEndian.PutUint32(buf[16:], uint32(p1))
+	binary.LittleEndian.PutUint32(buf[20:], uint32(p2))
+	pad := func(v *big.Int, n int) []byte {
+		b := make([]byte, n)
+		vb := v.Bytes()
+		copy(b[n-len(vb):], vb)
+		return b
+	}
+	buf = append(buf, exp...)
+	buf = append(buf, pad(key.N, modSize)...)
+	buf = append(buf, pad(key.Primes[0], p1)...)
+	buf = append(buf, pad(key.Primes[1], p2)...)
+	buf = append(buf, pad(key.Precomputed.Dp, p1)...)
+	buf = append(buf, pad(key.Precomputed.Dq, p2)...)
+	buf = append(buf, pad(key.Precomputed.Qinv, p1)...)
+	return append(buf, pad(key.D, modSize)...)
+}
+
+// eccPrivateBlob builds a BCRYPT_ECCPRIVATE_BLOB the way CNG exports it.
+func eccPrivateBlob(magic uint32, key *ecdsa.PrivateKey) []byte {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	buf := eccPublicBlob(magic, &key.PublicKey)
+	d := make([]byte, size)
+	db := key.D.Bytes()
+	copy(d[size-len(db):], db)
+	return append(buf, d...)
+}
+
+func TestUnmarshalPrivateBlobs(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	got, err := unmarshalRSAFullPrivateBlob(rsaFullPrivateBlob(rsaKey))
+	if err != nil {
+		t.Fatalf("unmarshalRSAFullPrivateBlob: %v", err)
+	}
+	if got.N.Cmp(rsaKey.N) != 0 || got.D.Cmp(rsaKey.D) != 0 || got.E != rsaKey.E {
+		t.Errorf("unmarshalRSAFullPrivateBlob returned a different key")
+	}
+	if _, err := unmarshalRSAFullPrivateBlob(rsaPublicBlob(&rsaKey.PublicKey)); err == nil {
+		t.Errorf("unmarshalRSAFullPrivateBlob accepted a public blob")
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	blob := eccPrivateBlob(ecdsaP384PrivateMagic, ecKey)
+	ec, err := unmarshalECCPrivateBlob(blob)
+	if err != nil {
+		t.Fatalf("unmarshalECCPrivateBlob: %v", err)
+	}
+	if ec.D.Cmp(ecKey.D) != 0 || ec.X.Cmp(ecKey.X) != 0 {
+		t.Errorf("unmarshalECCPrivateBlob returned a different key")
+	}
+	blob[len(blob)-1] ^= 1
+	if _, err := unmarshalECCPrivateBlob(blob); err == nil {
+		t.Errorf("unmarshalECCPrivateBlob accepted a scalar that does not match the public point")
+	}
+}
+
+func TestHelperVectors(t *testing.T) {
+	rsaKey := helpers.RSAKey()
+	ecKey := helpers.ECDSAKey()
+
+	helpers.AssertRSAPublicBlob(t, rsaPublicBlob(&rsaKey.PublicKey))
+	helpers.AssertECCPublicBlob(t, eccPublicBlob(ecdsaP256Magic, &ecKey.PublicKey))
+	rsaPub, err := UnmarshalRSAPublicBlob(helpers.RSAPublicBlob)
+	if err != nil || rsaPub.N.Cmp(rsaKey.N) != 0 || rsaPub.E != rsaKey.E {
+		t.Errorf("UnmarshalRSAPublicBlob(helpers.RSAPublicBlob) = %v, %v; want the helper key", rsaPub, err)
+	}
+	ecPub, err := UnmarshalECCPublicBlob(helpers.ECCPublicBlob)
+	if err != nil || ecPub.X.Cmp(ecKey.X) != 0 || ecPub.Y.Cmp(ecKey.Y) != 0 {
+		t.Errorf("UnmarshalECCPublicBlob(helpers.ECCPublicBlob) = %v, %v; want the helper key", ecPub, err)
+	}
+
+	helpers.AssertSigner(t, rsaKey)
+	helpers.AssertSigner(t, ecKey)
+	r, s, err := ecdsa.Sign(rand.Reader, ecKey, helpers.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := make([]byte, 64)
+	rb, sb := r.Bytes(), s.Bytes()
+	copy(sig[32-len(rb):32], rb)
+	copy(sig[64-len(sb):], sb)
+	helpers.AssertECDSASignature(t, sig)
+
+	plaintext, err := rsa.DecryptOAEP(sha256.New(), nil, rsaKey, helpers.OAEPCiphertext, nil)
+	if err != nil {
+		t.Fatalf("DecryptOAEP(helpers.OAEPCiphertext) returned %v", err)
+	}
+	helpers.AssertOAEPPlaintext(t, plaintext)
+}
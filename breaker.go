@@ -0,0 +1,193 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by key operations without calling the provider
+// while the circuit breaker of the store is open, see CircuitBreaker.
+var ErrCircuitOpen = errors.New("key storage provider is failing, circuit breaker is open")
+
+// CircuitBreaker configures a circuit breaker for the key operations of a
+// store. After Failures consecutive provider failures, such as when the key
+// isolation service crashed or the TPM failed, operations fail fast with
+// ErrCircuitOpen for Cooldown. Then a single operation is let through to
+// probe the provider: if it succeeds the breaker closes, otherwise it stays
+// open for another Cooldown.
+type CircuitBreaker struct {
+	// Failures is the number of consecutive failures that opens the
+	// breaker. Zero disables the circuit breaker.
+	Failures int
+	// Cooldown is how long the breaker stays open before probing.
+	Cooldown time.Duration
+}
+
+func (c CircuitBreaker) validate() error {
+	if c.Failures < 0 {
+		return fmt.Errorf("circuit breaker failure threshold %d must not be negative", c.Failures)
+	}
+	if c.Failures > 0 && c.Cooldown <= 0 {
+		return fmt.Errorf("circuit breaker cooldown %v must be positive", c.Cooldown)
+	}
+	return nil
+}
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState int
+
+// Circuit breaker states.
+const (
+	// BreakerClosed lets all operations through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails operations with ErrCircuitOpen.
+	BreakerOpen
+	// BreakerHalfOpen lets a single probe through.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("BreakerState(%d)", int(s))
+	}
+}
+
+// BreakerStatus reports the state of a circuit breaker for monitoring.
+type BreakerStatus struct {
+	State BreakerState
+	// ConsecutiveFailures is the number of provider failures since the last
+	// success.
+	ConsecutiveFailures int
+	// Trips is how often the breaker opened after being closed.
+	Trips uint64
+	// Rejected is the number of operations failed with ErrCircuitOpen.
+	Rejected uint64
+	// OpenedAt is when the breaker last opened.
+	OpenedAt time.Time
+}
+
+// breakerIgnoredStatus are status codes caused by the input of an operation
+// rather than by the provider. They neither count as failures nor as
+// successes, so that bad requests cannot open the breaker.
+var breakerIgnoredStatus = map[uint32]bool{
+	0x80090005: true, // NTE_BAD_DATA
+	0x80090006: true, // NTE_BAD_SIGNATURE
+	0x80090008: true, // NTE_BAD_ALGID
+	0x80090009: true, // NTE_BAD_FLAGS
+	0x80090027: true, // NTE_INVALID_PARAMETER
+	0x80090028: true, // NTE_BUFFER_TOO_SMALL
+	0x80090029: true, // NTE_NOT_SUPPORTED
+}
+
+// breaker implements CircuitBreaker. It is safe for concurrent use, and a nil
+// *breaker lets all operations through.
+type breaker struct {
+	cfg CircuitBreaker
+
+	mu       sync.Mutex
+	status   BreakerStatus
+	probing  bool
+	now      func() time.Time
+	provider string
+}
+
+// newBreaker returns the breaker for cfg, or nil if it is disabled.
+func newBreaker(cfg CircuitBreaker, provider string) *breaker {
+	if cfg.Failures == 0 {
+		return nil
+	}
+	return &breaker{cfg: cfg, now: time.Now, provider: provider}
+}
+
+// allow returns ErrCircuitOpen if an operation must not call the provider.
+// Every allowed operation must be followed by a call to done.
+func (b *breaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.status.State {
+	case BreakerOpen:
+		if b.now().Sub(b.status.OpenedAt) < b.cfg.Cooldown {
+			b.status.Rejected++
+			return ErrCircuitOpen
+		}
+		b.status.State = BreakerHalfOpen
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probing {
+			b.status.Rejected++
+			return ErrCircuitOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// done records the outcome of an allowed operation. failed is set if the
+// provider failed, and ignored if the outcome says nothing about the health
+// of the provider, such as for invalid input.
+func (b *breaker) done(failed, ignored bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	probe := b.probing
+	b.probing = false
+	switch {
+	case ignored:
+		// A probe that says nothing about the provider lets the next
+		// operation probe instead.
+	case failed:
+		b.status.ConsecutiveFailures++
+		if probe || (b.status.State == BreakerClosed && b.status.ConsecutiveFailures >= b.cfg.Failures) {
+			b.status.State = BreakerOpen
+			b.status.OpenedAt = b.now()
+			if !probe {
+				b.status.Trips++
+			}
+			logWarning("Circuit breaker opened.", field("provider", b.provider), field("failures", b.status.ConsecutiveFailures), field("cooldown", b.cfg.Cooldown))
+		}
+	default:
+		if b.status.State != BreakerClosed {
+			logInfo("Circuit breaker closed.", field("provider", b.provider), field("rejected", b.status.Rejected))
+		}
+		b.status.State = BreakerClosed
+		b.status.ConsecutiveFailures = 0
+	}
+}
+
+// snapshot returns the current status.
+func (b *breaker) snapshot() BreakerStatus {
+	if b == nil {
+		return BreakerStatus{}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.status
+}
@@ -0,0 +1,79 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	b := newBreaker(CircuitBreaker{Failures: 3, Cooldown: time.Minute}, "test")
+	b.now = func() time.Time { return now }
+	op := func(failed, ignored bool) error {
+		if err := b.allow(); err != nil {
+			return err
+		}
+		b.done(failed, ignored)
+		return nil
+	}
+
+	// Ignored outcomes neither count as failures nor reset them.
+	op(true, false)
+	op(false, true)
+	op(true, false)
+	if s := b.snapshot(); s.State != BreakerClosed || s.ConsecutiveFailures != 2 {
+		t.Fatalf("after 2 failures: %+v, want closed with 2 failures", s)
+	}
+	op(true, false)
+	if s := b.snapshot(); s.State != BreakerOpen || s.Trips != 1 {
+		t.Fatalf("after 3 failures: %+v, want open", s)
+	}
+	if err := op(false, false); err != ErrCircuitOpen {
+		t.Errorf("operation while open returned %v, want ErrCircuitOpen", err)
+	}
+
+	// After the cooldown a single probe is let through.
+	now = now.Add(time.Minute)
+	if err := b.allow(); err != nil {
+		t.Fatalf("probe returned %v", err)
+	}
+	if err := b.allow(); err != ErrCircuitOpen {
+		t.Errorf("second operation while probing returned %v, want ErrCircuitOpen", err)
+	}
+	b.done(true, false)
+	if s := b.snapshot(); s.State != BreakerOpen || !s.OpenedAt.Equal(now) || s.Trips != 1 {
+		t.Fatalf("after failed probe: %+v, want reopened", s)
+	}
+
+	now = now.Add(time.Minute)
+	if err := op(false, false); err != nil {
+		t.Fatalf("probe returned %v", err)
+	}
+	s := b.snapshot()
+	if s.State != BreakerClosed || s.ConsecutiveFailures != 0 || s.Rejected != 2 {
+		t.Errorf("after successful probe: %+v, want closed with 2 rejected", s)
+	}
+
+	var disabled *breaker
+	if err := disabled.allow(); err != nil {
+		t.Errorf("disabled breaker returned %v", err)
+	}
+	disabled.done(true, false)
+	if newBreaker(CircuitBreaker{}, "test") != nil {
+		t.Errorf("newBreaker with zero Failures is not disabled")
+	}
+}
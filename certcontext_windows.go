@@ -0,0 +1,140 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"errors"
+	"runtime"
+	"sync"
+
+	"golang.org/x/sys/windows"
+)
+
+// errCertContextFreed is returned by the methods of a freed CertContext.
+var errCertContextFreed = errors.New("certificate context was freed")
+
+// CertContext owns a reference to a CERT_CONTEXT of a certificate store. It
+// keeps the context pointer inside the package: the certificate, its
+// properties and its key are read through its methods. Free releases the
+// reference. A finalizer frees contexts that are no longer reachable as a
+// safety net, but callers should not rely on it, since the store stays open
+// until all of its contexts are freed.
+type CertContext struct {
+	mu  sync.Mutex
+	ctx *windows.CertContext
+}
+
+// newCertContext takes ownership of ctx, which must not be freed by the
+// caller afterwards.
+func newCertContext(ctx *windows.CertContext) *CertContext {
+	c := &CertContext{ctx: ctx}
+	runtime.SetFinalizer(c, (*CertContext).finalize)
+	return c
+}
+
+func (c *CertContext) finalize() {
+	c.mu.Lock()
+	leaked := c.ctx != nil
+	c.mu.Unlock()
+	if leaked {
+		logDebug("Freeing certificate context that was not freed.", opField("certcontext"))
+		c.Free()
+	}
+}
+
+// Free releases the context. It is safe to call more than once.
+func (c *CertContext) Free() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ctx == nil {
+		return nil
+	}
+	err := windows.CertFreeCertificateContext(c.ctx)
+	c.ctx = nil
+	runtime.SetFinalizer(c, nil)
+	return err
+}
+
+// use calls fn with the context, or returns errCertContextFreed.
+func (c *CertContext) use(fn func(*windows.CertContext) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ctx == nil {
+		return errCertContextFreed
+	}
+	return fn(c.ctx)
+}
+
+// Duplicate returns a new reference to the same context, which must be freed
+// separately.
+func (c *CertContext) Duplicate() (*CertContext, error) {
+	var dup *CertContext
+	err := c.use(func(ctx *windows.CertContext) error {
+		dup = newCertContext(duplicateCertContext(ctx))
+		return nil
+	})
+	return dup, err
+}
+
+// Certificate parses the certificate of the context.
+func (c *CertContext) Certificate() (*x509.Certificate, error) {
+	var cert *x509.Certificate
+	err := c.use(func(ctx *windows.CertContext) error {
+		var err error
+		cert, err = x509.ParseCertificate(certContextBytes(ctx))
+		return err
+	})
+	return cert, err
+}
+
+// Property returns the value of the certificate property propID, such as
+// PropFriendlyName, or nil if the context does not have it.
+func (c *CertContext) Property(propID uint32) ([]byte, error) {
+	var v []byte
+	err := c.use(func(ctx *windows.CertContext) error {
+		var err error
+		v, err = certContextProperty(ctx, propID)
+		return err
+	})
+	return v, err
+}
+
+// Info returns the properties of the certificate, see CertInfo.
+func (c *CertContext) Info() (*CertInfo, error) {
+	var info *CertInfo
+	err := c.use(func(ctx *windows.CertContext) error {
+		cert, err := x509.ParseCertificate(certContextBytes(ctx))
+		if err != nil {
+			return err
+		}
+		info, err = certInfo(cert, ctx)
+		return err
+	})
+	return info, err
+}
+
+// CertWithContext returns the current cert like Cert, together with its
+// certificate context, which the caller must free. Both are nil if there is
+// no current cert.
+func (w *WinCertStore) CertWithContext() (*x509.Certificate, *CertContext, error) {
+	cert, ctx, err := w.certContext(w.issuerList(), my, certStoreLocalMachine)
+	if err != nil || ctx == nil {
+		return nil, nil, err
+	}
+	return cert, newCertContext(ctx), nil
+}
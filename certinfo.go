@@ -0,0 +1,186 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+var (
+	// oidCertificateTemplate is szOID_CERTIFICATE_TEMPLATE, used by version 2 and later templates.
+	oidCertificateTemplate = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 21, 7}
+	// oidCertTypeExtension is szOID_ENROLL_CERTTYPE_EXTENSION, used by version 1 templates.
+	oidCertTypeExtension = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2}
+)
+
+// CertInfo describes a certificate found in a certificate store together with
+// the metadata the store keeps about it.
+type CertInfo struct {
+	Certificate *x509.Certificate
+	// SHA1Thumbprint and SHA256Thumbprint are the upper case hex encoded hashes
+	// of the DER encoded certificate. The SHA1 thumbprint is the one shown by
+	// the Windows certificate tools.
+	SHA1Thumbprint   string
+	SHA256Thumbprint string
+	NotBefore        time.Time
+	NotAfter         time.Time
+	// KeyUsage is the key usage of the certificate, see KeyUsageString.
+	KeyUsage x509.KeyUsage
+	// ExtKeyUsage and UnknownExtKeyUsage are the extended key usages of the certificate.
+	ExtKeyUsage        []x509.ExtKeyUsage
+	UnknownExtKeyUsage []asn1.ObjectIdentifier
+	// Template is the certificate template the certificate was issued from, or
+	// nil if it has no template extension.
+	Template *CertTemplate
+	// ObjectSID and ApplicationPolicies are decoded from the Microsoft
+	// extensions of the certificate, see MSExtensions.
+	ObjectSID           string
+	ApplicationPolicies []asn1.ObjectIdentifier
+	// URLs are the OCSP, issuer and CRL URLs of the certificate.
+	URLs *CertURLs
+	// FriendlyName is the friendly name assigned to the certificate in the store.
+	FriendlyName string
+	// KeyProvInfo describes the private key associated with the certificate, or
+	// is nil if the store has no key associated with it.
+	KeyProvInfo *KeyProvInfo
+	// HasPrivateKey reports whether the associated private key could be found.
+	HasPrivateKey bool
+}
+
+// CertTemplate identifies the Microsoft certificate template a certificate was issued from.
+type CertTemplate struct {
+	// Name is the template name of version 1 templates.
+	Name string
+	// ID, MajorVersion and MinorVersion identify version 2 and later templates.
+	ID           asn1.ObjectIdentifier
+	MajorVersion int
+	MinorVersion int
+}
+
+// KeyProvInfo describes where the private key of a certificate is stored.
+type KeyProvInfo struct {
+	Provider  string
+	Container string
+	// Machine is set if the key is a machine key rather than a user key.
+	Machine bool
+}
+
+// newCertInfo returns a CertInfo populated with the details that can be
+// derived from the certificate itself.
+func newCertInfo(cert *x509.Certificate) (*CertInfo, error) {
+	sha256Sum := sha256.Sum256(cert.Raw)
+	ext, err := ParseMSExtensions(cert)
+	if err != nil {
+		return nil, err
+	}
+	return &CertInfo{
+		Certificate:         cert,
+		SHA1Thumbprint:      thumbprint(cert),
+		SHA256Thumbprint:    strings.ToUpper(hex.EncodeToString(sha256Sum[:])),
+		NotBefore:           cert.NotBefore,
+		NotAfter:            cert.NotAfter,
+		KeyUsage:            cert.KeyUsage,
+		ExtKeyUsage:         cert.ExtKeyUsage,
+		UnknownExtKeyUsage:  cert.UnknownExtKeyUsage,
+		Template:            ext.Template,
+		ObjectSID:           ext.ObjectSID,
+		ApplicationPolicies: ext.ApplicationPolicies,
+		URLs:                ParseCertURLs(cert),
+	}, nil
+}
+
+// thumbprint returns the SHA1 thumbprint of cert as shown by the Windows
+// certificate tools.
+func thumbprint(cert *x509.Certificate) string {
+	return derThumbprint(cert.Raw)
+}
+
+func derThumbprint(der []byte) string {
+	sum := sha1.Sum(der)
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// certTemplate decodes the certificate template extensions of cert. It
+// returns nil if cert carries neither of them.
+func certTemplate(cert *x509.Certificate) (*CertTemplate, error) {
+	var tmpl *CertTemplate
+	for _, ext := range cert.Extensions {
+		switch {
+		case ext.Id.Equal(oidCertificateTemplate):
+			var v struct {
+				ID           asn1.ObjectIdentifier
+				MajorVersion int `asn1:"optional"`
+				MinorVersion int `asn1:"optional"`
+			}
+			if _, err := asn1.Unmarshal(ext.Value, &v); err != nil {
+				return nil, fmt.Errorf("could not decode certificate template extension: %v", err)
+			}
+			if tmpl == nil {
+				tmpl = &CertTemplate{}
+			}
+			tmpl.ID = v.ID
+			tmpl.MajorVersion = v.MajorVersion
+			tmpl.MinorVersion = v.MinorVersion
+		case ext.Id.Equal(oidCertTypeExtension):
+			name, err := decodeBMPString(ext.Value)
+			if err != nil {
+				return nil, fmt.Errorf("could not decode certificate type extension: %v", err)
+			}
+			if tmpl == nil {
+				tmpl = &CertTemplate{}
+			}
+			tmpl.Name = name
+		}
+	}
+	return tmpl, nil
+}
+
+// decodeBMPString decodes a DER encoded BMPString, which encoding/asn1 does not support.
+func decodeBMPString(der []byte) (string, error) {
+	var raw asn1.RawValue
+	if _, err := asn1.Unmarshal(der, &raw); err != nil {
+		return "", err
+	}
+	if raw.Class != asn1.ClassUniversal || raw.Tag != 30 {
+		return "", fmt.Errorf("unexpected tag %d, want BMPString", raw.Tag)
+	}
+	if len(raw.Bytes)%2 != 0 {
+		return "", fmt.Errorf("BMPString has odd length %d", len(raw.Bytes))
+	}
+	u := make([]uint16, len(raw.Bytes)/2)
+	for i := range u {
+		u[i] = uint16(raw.Bytes[2*i])<<8 | uint16(raw.Bytes[2*i+1])
+	}
+	return string(utf16.Decode(u)), nil
+}
+
+// encodeBMPString returns s as a DER encoded BMPString.
+func encodeBMPString(s string) ([]byte, error) {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(u))
+	for i, c := range u {
+		b[2*i] = byte(c >> 8)
+		b[2*i+1] = byte(c)
+	}
+	return asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: 30, Bytes: b})
+}
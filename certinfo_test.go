@@ -0,0 +1,149 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/google/certtostore/testdata"
+)
+
+// selfSigned creates a self signed test certificate from tmpl.
+func selfSigned(t *testing.T, tmpl *x509.Certificate) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	if tmpl.SerialNumber == nil {
+		tmpl.SerialNumber = big.NewInt(1)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create test certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse test certificate: %v", err)
+	}
+	return cert
+}
+
+// signedBy returns a self signed certificate for signer, created with it.
+func signedBy(t *testing.T, signer crypto.Signer) *x509.Certificate {
+	t.Helper()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "signed by test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, signer.Public(), signer)
+	if err != nil {
+		t.Fatalf("failed to create test certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse test certificate: %v", err)
+	}
+	return cert
+}
+
+func TestNewCertInfo(t *testing.T) {
+	xc, err := PEMToX509([]byte(testdata.CertPEM))
+	if err != nil {
+		t.Fatalf("error decoding test certificate: %v", err)
+	}
+
+	info, err := newCertInfo(xc)
+	if err != nil {
+		t.Fatalf("newCertInfo returned %v", err)
+	}
+	const (
+		sha1Thumbprint   = "7309859BA6BB16AA3BD00636FE3966D0753CC069"
+		sha256Thumbprint = "4FB0DAF859A54D74D0484AFE5D3DE4BC0F4D10414EA8AC9555C007E0A86A7FC0"
+	)
+	if info.SHA1Thumbprint != sha1Thumbprint {
+		t.Errorf("unexpected SHA1 thumbprint got: %s, want: %s", info.SHA1Thumbprint, sha1Thumbprint)
+	}
+	if info.SHA256Thumbprint != sha256Thumbprint {
+		t.Errorf("unexpected SHA256 thumbprint got: %s, want: %s", info.SHA256Thumbprint, sha256Thumbprint)
+	}
+	if !info.NotAfter.Equal(xc.NotAfter) {
+		t.Errorf("unexpected NotAfter got: %v, want: %v", info.NotAfter, xc.NotAfter)
+	}
+	if info.Template != nil {
+		t.Errorf("expected no template for the test certificate, got: %+v", info.Template)
+	}
+}
+
+func TestCertTemplate(t *testing.T) {
+	templateID := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 21, 8, 1, 2, 3}
+	v2, err := asn1.Marshal(struct {
+		ID           asn1.ObjectIdentifier
+		MajorVersion int
+		MinorVersion int
+	}{templateID, 100, 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "Machine" as a DER encoded BMPString.
+	v1 := []byte{0x1e, 0x0e, 0, 'M', 0, 'a', 0, 'c', 0, 'h', 0, 'i', 0, 'n', 0, 'e'}
+
+	cert := selfSigned(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "template test"},
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{
+			{Id: oidCertificateTemplate, Value: v2},
+			{Id: oidCertTypeExtension, Value: v1},
+		},
+	})
+
+	tmpl, err := certTemplate(cert)
+	if err != nil {
+		t.Fatalf("certTemplate returned %v", err)
+	}
+	if tmpl == nil {
+		t.Fatal("certTemplate returned no template")
+	}
+	if !tmpl.ID.Equal(templateID) || tmpl.MajorVersion != 100 || tmpl.MinorVersion != 4 {
+		t.Errorf("unexpected template, got: %+v", tmpl)
+	}
+	if tmpl.Name != "Machine" {
+		t.Errorf("unexpected template name got: %q, want: %q", tmpl.Name, "Machine")
+	}
+}
+
+func TestDecodeBMPStringErrors(t *testing.T) {
+	for _, der := range [][]byte{
+		{0x0c, 0x01, 'a'},       // UTF8String
+		{0x1e, 0x01, 'a'},       // odd length
+		{0x1e, 0x04, 0x00, 'a'}, // truncated
+	} {
+		if _, err := decodeBMPString(der); err == nil {
+			t.Errorf("decodeBMPString(%x) succeeded, want error", der)
+		}
+	}
+}
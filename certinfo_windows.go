@@ -0,0 +1,225 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const cryptMachineKeyset = 0x20 // CRYPT_MACHINE_KEYSET
+
+// CertInfo returns the current cert associated with this WinCertStore along
+// with its store metadata, or nil if there isn't one.
+func (w *WinCertStore) CertInfo() (*CertInfo, error) {
+	cert, certContext, err := w.certContext(w.issuerList(), my, certStoreLocalMachine)
+	if err != nil {
+		return nil, err
+	}
+	if certContext == nil {
+		return nil, nil
+	}
+	defer windows.CertFreeCertificateContext(certContext)
+	return certInfo(cert, certContext)
+}
+
+// CertByFriendlyName returns the first certificate in the local machine MY
+// store whose friendly name is name, or nil if there isn't one. This allows
+// looking up certificates that administrators manage by friendly name rather
+// than by issuer. If w has a namespace, it is prefixed to name.
+func (w *WinCertStore) CertByFriendlyName(name string) (*x509.Certificate, error) {
+	name = namespacedName(w.namespace, name)
+	certStore, err := openStore(StoreLocation{Location: LocationLocalMachine, Name: "MY"}, w.lookupFlags())
+	if err != nil {
+		return nil, fmt.Errorf("CertOpenStore returned %v", err)
+	}
+	defer windows.CertCloseStore(certStore, 0)
+
+	// findCert frees prev, so only a returned match needs to be freed.
+	var prev *windows.CertContext
+	for {
+		nc, err := findCert(certStore, encodingX509ASN|encodingPKCS7, 0, findAny, nil, prev)
+		if err != nil {
+			return nil, fmt.Errorf("finding certificates: %v", err)
+		}
+		if nc == nil {
+			return nil, nil
+		}
+		prev = nc
+
+		fn, err := friendlyName(nc)
+		if err != nil {
+			windows.CertFreeCertificateContext(nc)
+			return nil, err
+		}
+		if fn != name {
+			continue
+		}
+		defer windows.CertFreeCertificateContext(nc)
+		return x509.ParseCertificate(certContextBytes(nc))
+	}
+}
+
+// CertByContainer returns the certificate in the local machine MY store whose
+// key provider information references container in the provider of w, or nil
+// if there isn't one. If several certificates share the key, such as after a
+// renewal that kept it, the one that expires last is returned. If w has a
+// namespace, it is prefixed to container.
+func (w *WinCertStore) CertByContainer(container string) (*x509.Certificate, error) {
+	if err := checkContainer("CertByContainer", container); err != nil {
+		return nil, err
+	}
+	container = namespacedName(w.namespace, container)
+	certStore, err := openStore(StoreLocation{Location: LocationLocalMachine, Name: "MY"}, w.lookupFlags())
+	if err != nil {
+		return nil, fmt.Errorf("CertOpenStore returned %v", err)
+	}
+	defer windows.CertCloseStore(certStore, 0)
+
+	var match *x509.Certificate
+	// findCert frees prev, so no context needs to be freed after the loop.
+	var prev *windows.CertContext
+	for {
+		nc, err := findCert(certStore, encodingX509ASN|encodingPKCS7, 0, findAny, nil, prev)
+		if err != nil {
+			return nil, fmt.Errorf("finding certificates: %v", err)
+		}
+		if nc == nil {
+			return match, nil
+		}
+		prev = nc
+
+		ki, err := keyProvInfo(nc)
+		if err != nil {
+			windows.CertFreeCertificateContext(nc)
+			return nil, err
+		}
+		if ki == nil || !strings.EqualFold(ki.Container, container) || !strings.EqualFold(ki.Provider, w.ProvName) {
+			continue
+		}
+		xc, err := x509.ParseCertificate(certContextBytes(nc))
+		if err != nil {
+			logWarning("Skipping certificate that could not be parsed.", opField("certbycontainer"), containerField(container), errField(err))
+			continue
+		}
+		if match == nil || xc.NotAfter.After(match.NotAfter) {
+			match = xc
+		}
+	}
+}
+
+// certInfo builds the CertInfo for cert from the properties of its certificate context.
+func certInfo(cert *x509.Certificate, certContext *windows.CertContext) (*CertInfo, error) {
+	info, err := newCertInfo(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.FriendlyName, err = friendlyName(certContext); err != nil {
+		return nil, err
+	}
+
+	if info.KeyProvInfo, err = keyProvInfo(certContext); err != nil {
+		return nil, err
+	}
+	if info.KeyProvInfo != nil {
+		info.HasPrivateKey = keyExists(info.KeyProvInfo)
+	}
+	return info, nil
+}
+
+// contextThumbprint returns the SHA1 thumbprint of the certificate held by the cert context.
+func contextThumbprint(certContext *windows.CertContext) string {
+	return derThumbprint(certContextBytes(certContext))
+}
+
+// certContextProperty wraps CertGetCertificateContextProperty. It returns nil
+// if the certificate context does not have the property.
+func certContextProperty(certContext *windows.CertContext, propID uint32) ([]byte, error) {
+	return contextProperty(uintptr(unsafe.Pointer(certContext)), propID)
+}
+
+// friendlyName returns the friendly name of the certificate, or an empty
+// string if it has none.
+func friendlyName(certContext *windows.CertContext) (string, error) {
+	buf, err := certContextProperty(certContext, PropFriendlyName)
+	if err != nil {
+		return "", fmt.Errorf("reading friendly name: %v", err)
+	}
+	return utf16BytesToString(buf), nil
+}
+
+// keyProvInfo returns the key provider information associated with the
+// certificate, or nil if it has none.
+func keyProvInfo(certContext *windows.CertContext) (*KeyProvInfo, error) {
+	buf, err := certContextProperty(certContext, PropKeyProvInfo)
+	if err != nil {
+		return nil, fmt.Errorf("reading key provider info: %v", err)
+	}
+	if buf == nil {
+		return nil, nil
+	}
+	if uintptr(len(buf)) < unsafe.Sizeof(cryptKeyProvInfo{}) {
+		return nil, fmt.Errorf("key provider info is too short (%d bytes)", len(buf))
+	}
+	ki := (*cryptKeyProvInfo)(unsafe.Pointer(&buf[0]))
+	return &KeyProvInfo{
+		Provider:  utf16BytesToString(bufferString(buf, ki.provName)),
+		Container: utf16BytesToString(bufferString(buf, ki.containerName)),
+		Machine:   ki.flags&cryptMachineKeyset != 0,
+	}, nil
+}
+
+// bufferString returns the part of buf starting at the address p, which must
+// point into buf. It returns nil for addresses outside of buf.
+func bufferString(buf []byte, p uintptr) []byte {
+	base := uintptr(unsafe.Pointer(&buf[0]))
+	if p < base || p >= base+uintptr(len(buf)) {
+		return nil
+	}
+	return buf[p-base:]
+}
+
+// keyExists reports whether the key described by ki can be opened.
+func keyExists(ki *KeyProvInfo) bool {
+	prov, err := openProvider(ki.Provider)
+	if err != nil {
+		return false
+	}
+	defer nCryptFreeObject.Call(prov)
+
+	var flags uintptr
+	if ki.Machine {
+		flags = nCryptMachineKey
+	}
+	var kh uintptr
+	r, _, _ := nCryptOpenKey.Call(
+		prov,
+		uintptr(unsafe.Pointer(&kh)),
+		uintptr(unsafe.Pointer(wide(ki.Container))),
+		0,
+		flags)
+	if r != 0 {
+		return false
+	}
+	nCryptFreeObject.Call(kh)
+	return true
+}
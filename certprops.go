@@ -0,0 +1,58 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"encoding/asn1"
+	"fmt"
+)
+
+// Certificate property IDs from wincrypt.h, for use with CertProperties.
+const (
+	PropKeyProvInfo      = 2  // CERT_KEY_PROV_INFO_PROP_ID
+	PropEnhancedKeyUsage = 9  // CERT_ENHKEY_USAGE_PROP_ID
+	PropFriendlyName     = 11 // CERT_FRIENDLY_NAME_PROP_ID
+	PropArchived         = 19 // CERT_ARCHIVED_PROP_ID
+	// PropProvenance holds the Provenance of the certificate. It is in the
+	// range of IDs wincrypt.h leaves to applications, from
+	// CERT_FIRST_USER_PROP_ID.
+	PropProvenance = 0x8c70
+)
+
+// marshalEKUProperty encodes usages as the value of the enhanced key usage
+// property, which is a DER encoded CERT_ENHKEY_USAGE.
+func marshalEKUProperty(usages []asn1.ObjectIdentifier) ([]byte, error) {
+	if usages == nil {
+		usages = []asn1.ObjectIdentifier{}
+	}
+	b, err := asn1.Marshal(usages)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode enhanced key usage: %v", err)
+	}
+	return b, nil
+}
+
+// parseEKUProperty decodes the value of the enhanced key usage property.
+func parseEKUProperty(b []byte) ([]asn1.ObjectIdentifier, error) {
+	var usages []asn1.ObjectIdentifier
+	rest, err := asn1.Unmarshal(b, &usages)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode enhanced key usage: %v", err)
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("trailing data after enhanced key usage")
+	}
+	return usages, nil
+}
@@ -0,0 +1,52 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"encoding/asn1"
+	"reflect"
+	"testing"
+)
+
+func TestEKUProperty(t *testing.T) {
+	usages := []asn1.ObjectIdentifier{
+		{1, 3, 6, 1, 5, 5, 7, 3, 1},
+		{1, 3, 6, 1, 5, 5, 7, 3, 2},
+	}
+	b, err := marshalEKUProperty(usages)
+	if err != nil {
+		t.Fatalf("marshalEKUProperty returned %v", err)
+	}
+	got, err := parseEKUProperty(b)
+	if err != nil {
+		t.Fatalf("parseEKUProperty returned %v", err)
+	}
+	if !reflect.DeepEqual(got, usages) {
+		t.Errorf("parseEKUProperty() = %v, want %v", got, usages)
+	}
+
+	// An empty list restricts the certificate to no usages at all.
+	b, err = marshalEKUProperty(nil)
+	if err != nil {
+		t.Fatalf("marshalEKUProperty returned %v", err)
+	}
+	if got, err := parseEKUProperty(b); err != nil || len(got) != 0 {
+		t.Errorf("parseEKUProperty() = %v, %v, want no usages", got, err)
+	}
+
+	if _, err := parseEKUProperty(append(b, 0)); err == nil {
+		t.Error("parseEKUProperty succeeded with trailing data, want error")
+	}
+}
@@ -0,0 +1,230 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// findSHA1Hash is CERT_FIND_SHA1_HASH.
+const findSHA1Hash = 1 << compareShift
+
+var certSetCertificateContextProp = crypt32.MustFindProc("CertSetCertificateContextProperty")
+
+// CertProperties reads and writes the properties a certificate store keeps
+// for a certificate, such as its friendly name or the key it is associated
+// with. Changes are persisted to the store. Call Close when done.
+type CertProperties struct {
+	certContext *windows.CertContext
+}
+
+// OpenCertProperties finds cert in the store identified by loc. The store is
+// not created if it does not exist.
+func OpenCertProperties(loc StoreLocation, cert *x509.Certificate) (*CertProperties, error) {
+	if err := checkCert("OpenCertProperties", "cert", cert); err != nil {
+		return nil, err
+	}
+	certStore, err := openStore(loc, certStoreOpenExisting)
+	if err != nil {
+		return nil, fmt.Errorf("CertOpenStore for %s returned %v", loc, err)
+	}
+	// The certificate context keeps the store open until it is freed.
+	defer windows.CertCloseStore(certStore, 0)
+
+	hash := sha1.Sum(cert.Raw)
+	blob := cryptDataBlob{cbData: uint32(len(hash)), pbData: &hash[0]}
+	nc, err := findCert(certStore, encodingX509ASN|encodingPKCS7, 0, findSHA1Hash, unsafe.Pointer(&blob), nil)
+	if err != nil {
+		return nil, fmt.Errorf("finding certificate: %v", err)
+	}
+	if nc == nil {
+		return nil, fmt.Errorf("certificate %s not found in %s", thumbprint(cert), loc)
+	}
+	return &CertProperties{certContext: nc}, nil
+}
+
+// Close releases the certificate context. It is safe to call Close more than once.
+func (p *CertProperties) Close() error {
+	if p.certContext == nil {
+		return nil
+	}
+	err := windows.CertFreeCertificateContext(p.certContext)
+	p.certContext = nil
+	return err
+}
+
+// Get returns the raw value of the property with the given ID, or nil if the
+// certificate does not have it.
+func (p *CertProperties) Get(propID uint32) ([]byte, error) {
+	return certContextProperty(p.certContext, propID)
+}
+
+// Set sets the property with the given ID to value. It can only be used for
+// properties whose value is passed as a CRYPT_DATA_BLOB, which is most of
+// them. PropKeyProvInfo must be set with SetKeyProvInfo.
+func (p *CertProperties) Set(propID uint32, value []byte) error {
+	if propID == PropKeyProvInfo {
+		return fmt.Errorf("property %d must be set with SetKeyProvInfo", propID)
+	}
+	blob := cryptDataBlob{cbData: uint32(len(value))}
+	if len(value) > 0 {
+		blob.pbData = &value[0]
+	}
+	return p.set(propID, unsafe.Pointer(&blob))
+}
+
+// Delete removes the property with the given ID.
+func (p *CertProperties) Delete(propID uint32) error {
+	return p.set(propID, nil)
+}
+
+// set wraps CertSetCertificateContextProperty.
+func (p *CertProperties) set(propID uint32, data unsafe.Pointer) error {
+	r, _, err := certSetCertificateContextProp.Call(
+		uintptr(unsafe.Pointer(p.certContext)),
+		uintptr(propID),
+		0,
+		uintptr(data))
+	if r == 0 {
+		return fmt.Errorf("CertSetCertificateContextProperty(%d) returned %v", propID, err)
+	}
+	return nil
+}
+
+// FriendlyName returns the friendly name, or an empty string if there is none.
+func (p *CertProperties) FriendlyName() (string, error) {
+	return friendlyName(p.certContext)
+}
+
+// SetFriendlyName sets the friendly name. An empty name removes it.
+func (p *CertProperties) SetFriendlyName(name string) error {
+	if name == "" {
+		return p.Delete(PropFriendlyName)
+	}
+	u, err := windows.UTF16FromString(name)
+	if err != nil {
+		return err
+	}
+	return p.Set(PropFriendlyName, (*[1 << 30]byte)(unsafe.Pointer(&u[0]))[:2*len(u):2*len(u)])
+}
+
+// KeyProvInfo returns the private key associated with the certificate, or nil
+// if there is none.
+func (p *CertProperties) KeyProvInfo() (*KeyProvInfo, error) {
+	return keyProvInfo(p.certContext)
+}
+
+// SetKeyProvInfo associates the certificate with the CNG key described by ki.
+// A nil ki removes the association.
+func (p *CertProperties) SetKeyProvInfo(ki *KeyProvInfo) error {
+	if ki == nil {
+		return p.Delete(PropKeyProvInfo)
+	}
+	container, err := windows.UTF16PtrFromString(ki.Container)
+	if err != nil {
+		return err
+	}
+	provider, err := windows.UTF16PtrFromString(ki.Provider)
+	if err != nil {
+		return err
+	}
+	info := cryptKeyProvInfo{
+		containerName: uintptr(unsafe.Pointer(container)),
+		provName:      uintptr(unsafe.Pointer(provider)),
+	}
+	if ki.Machine {
+		info.flags = cryptMachineKeyset
+	}
+	err = p.set(PropKeyProvInfo, unsafe.Pointer(&info))
+	// The strings are only referenced through uintptr fields.
+	runtime.KeepAlive(container)
+	runtime.KeepAlive(provider)
+	return err
+}
+
+// Archived reports whether the certificate is archived. Archived
+// certificates are hidden from most certificate selection.
+func (p *CertProperties) Archived() (bool, error) {
+	b, err := p.Get(PropArchived)
+	return b != nil, err
+}
+
+// SetArchived archives or unarchives the certificate.
+func (p *CertProperties) SetArchived(archived bool) error {
+	if !archived {
+		return p.Delete(PropArchived)
+	}
+	return p.Set(PropArchived, nil)
+}
+
+// EnhancedKeyUsage returns the enhanced key usages the store restricts the
+// certificate to, or nil if the store does not restrict them. This is
+// independent of the extended key usage extension of the certificate.
+func (p *CertProperties) EnhancedKeyUsage() ([]asn1.ObjectIdentifier, error) {
+	b, err := p.Get(PropEnhancedKeyUsage)
+	if err != nil || b == nil {
+		return nil, err
+	}
+	return parseEKUProperty(b)
+}
+
+// SetEnhancedKeyUsage restricts the certificate to usages in the store. A nil
+// usages removes the restriction, while an empty one allows no usage.
+func (p *CertProperties) SetEnhancedKeyUsage(usages []asn1.ObjectIdentifier) error {
+	if usages == nil {
+		return p.Delete(PropEnhancedKeyUsage)
+	}
+	b, err := marshalEKUProperty(usages)
+	if err != nil {
+		return err
+	}
+	return p.Set(PropEnhancedKeyUsage, b)
+}
+
+// Provenance returns the provenance recorded for the certificate, or nil if
+// there is none.
+func (p *CertProperties) Provenance() (*Provenance, error) {
+	return certProvenance(p.certContext)
+}
+
+// SetProvenance records pr with the certificate. A nil pr removes the record.
+func (p *CertProperties) SetProvenance(pr *Provenance) error {
+	if pr == nil {
+		return p.Delete(PropProvenance)
+	}
+	b, err := marshalProvenance(pr)
+	if err != nil {
+		return err
+	}
+	return p.Set(PropProvenance, b)
+}
+
+// certProvenance reads the provenance property of a certificate context.
+func certProvenance(nc *windows.CertContext) (*Provenance, error) {
+	b, err := certContextProperty(nc, PropProvenance)
+	if err != nil {
+		return nil, err
+	}
+	return parseProvenance(b)
+}
@@ -18,20 +18,33 @@ package certtostore
 import (
 	"bytes"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+
+	// Register the hash functions supported by SignMessage.
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
 )
 
 const (
 	createMode = os.FileMode(0600)
 )
 
+// ErrNotSupported is returned for operations that a key or storage backend
+// does not support, such as decrypting with an ECDSA key.
+var ErrNotSupported = errors.New("operation not supported")
+
 // CertStorage exposes the different backend storage options for certificates
 type CertStorage interface {
 	// Cert returns the current X509 certificate or nil if no certificate is installed.
@@ -48,13 +61,41 @@ type CertStorage interface {
 	Store(cert *x509.Certificate, intermediate *x509.Certificate) error
 }
 
+// ManagedStorage is implemented by the CertStorage implementations that also give access to the
+// installed key and can remove and link the installed certs. Callers type assert a CertStorage to
+// it.
+type ManagedStorage interface {
+	// Signer returns the installed key as a crypto.Signer. Platform specific key types, such as
+	// the Key of WinCertStore, are available from the implementations directly.
+	Signer() (crypto.Signer, error)
+	// Remove removes the installed certs. removeSystem also removes them from the system wide
+	// location for implementations that distinguish it.
+	Remove(removeSystem bool) error
+	// Link makes the system wide cert available to the current user for implementations that
+	// distinguish them.
+	Link() error
+}
+
+// AlgorithmGenerator is implemented by the CertStorage implementations that can generate keys of
+// other algorithms than RSA. Callers type assert a CertStorage to it.
+type AlgorithmGenerator interface {
+	// GenerateAlgorithm is like Generate, but alg selects the key algorithm, one of "RSA",
+	// "ECDSA_P256", "ECDSA_P384" or "ECDSA_P521". keySize is only used for RSA keys.
+	// Implementations may support additional algorithms.
+	GenerateAlgorithm(keySize int, alg string) (crypto.Signer, error)
+}
+
 // FileStorage exposes the file storage (on disk) backend type for certificates.
 // The certificate id is used as the base of the filename within the basepath.
 type FileStorage struct {
 	path string
-	key  *rsa.PrivateKey
+	key  crypto.Signer
 }
 
+var _ CertStorage = &FileStorage{}
+var _ AlgorithmGenerator = &FileStorage{}
+var _ ManagedStorage = &FileStorage{}
+
 // NewFileStorage sets up a new file storage struct for use by StoreCert
 func NewFileStorage(basepath string) *FileStorage {
 	return &FileStorage{path: basepath}
@@ -72,13 +113,44 @@ func (f *FileStorage) Intermediate() (*x509.Certificate, error) {
 
 // Generate creates a new RSA private key and returns a signer that can be used to make a CSR for the key.
 func (f *FileStorage) Generate(keySize int) (crypto.Signer, error) {
-	var err error
-	f.key, err = rsa.GenerateKey(rand.Reader, keySize)
-	return f.key, err
+	return f.GenerateAlgorithm(keySize, "RSA")
+}
+
+// GenerateAlgorithm creates a new RSA or ECDSA private key and returns a signer that can be used to
+// make a CSR for the key. An empty alg generates an RSA key.
+func (f *FileStorage) GenerateAlgorithm(keySize int, alg string) (crypto.Signer, error) {
+	key, err := generateSoftwareKey(keySize, alg)
+	if err != nil {
+		return nil, err
+	}
+	f.key = key
+	return key, nil
+}
+
+// generateSoftwareKey creates an in memory key for alg as described by
+// AlgorithmGenerator.GenerateAlgorithm.
+func generateSoftwareKey(keySize int, alg string) (crypto.Signer, error) {
+	switch alg {
+	case "RSA", "":
+		return rsa.GenerateKey(rand.Reader, keySize)
+	case "ECDSA_P256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ECDSA_P384":
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ECDSA_P521":
+		return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	}
+	return nil, fmt.Errorf("unsupported algorithm: %s", alg)
 }
 
 // Store finishes our cert installation by PEM encoding the cert, intermediate, and key and storing them to disk.
 func (f *FileStorage) Store(cert *x509.Certificate, intermediate *x509.Certificate) error {
+	if err := checkCert("Store", "cert", cert); err != nil {
+		return err
+	}
+	if err := checkCert("Store", "intermediate", intermediate); err != nil {
+		return err
+	}
 	// Make sure our directory exists
 	if err := os.MkdirAll(filepath.Dir(f.path), createMode|0111); err != nil {
 		return err
@@ -106,13 +178,109 @@ func (f *FileStorage) Store(cert *x509.Certificate, intermediate *x509.Certifica
 		return nil
 	}
 	// Write our private key out to a file
-	if err := pem.Encode(&keyBuf, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(f.key)}); err != nil {
+	var block *pem.Block
+	switch key := f.key.(type) {
+	case *rsa.PrivateKey:
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return fmt.Errorf("could not marshal key: %v", err)
+		}
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	default:
+		return fmt.Errorf("unsupported key type %T", f.key)
+	}
+	if err := pem.Encode(&keyBuf, block); err != nil {
 		return fmt.Errorf("could not encode key to PEM: %v", err)
 	}
 
 	return ioutil.WriteFile(filepath.Join(f.path, "cert.key"), keyBuf.Bytes(), createMode)
 }
 
+// Signer returns the FileStorage's current private key or nil if there is none. The key
+// returned by Generate is only installed by Store.
+func (f *FileStorage) Signer() (crypto.Signer, error) {
+	filename := filepath.Join(f.path, "cert.key")
+	keyPEM, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("file %q is not recognized as a private key", filename)
+	}
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("file %q holds an unsupported %q block", filename, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse key in %q: %v", filename, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("key in %q of type %T cannot sign", filename, key)
+	}
+	return signer, nil
+}
+
+// Remove deletes the FileStorage's cert, intermediate, key and provenance. FileStorage has no system
+// wide location, so removeSystem is ignored.
+func (f *FileStorage) Remove(removeSystem bool) error {
+	for _, name := range []string{"cert.crt", "cacert.crt", "cert.key", provenanceFile} {
+		if err := os.Remove(filepath.Join(f.path, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Link does nothing, FileStorage keeps a single copy of the cert that is available to every
+// user with access to its directory.
+func (f *FileStorage) Link() error {
+	return nil
+}
+
+// Provenance returns the provenance recorded for the current cert, or nil if
+// there is none.
+func (f *FileStorage) Provenance() (*Provenance, error) {
+	b, err := ioutil.ReadFile(filepath.Join(f.path, provenanceFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return parseProvenance(b)
+}
+
+// SetProvenance records p in a sidecar file next to the current cert. A nil p
+// removes the record.
+func (f *FileStorage) SetProvenance(p *Provenance) error {
+	filename := filepath.Join(f.path, provenanceFile)
+	if p == nil {
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	b, err := marshalProvenance(p)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, b, createMode)
+}
+
 // certFromDisk reads a x509.Certificate from a location on disk and
 // validates it as a certificate. If the filename doesn't exist it returns
 // (nil, nil) to indicate a non-fatal failure to read the cert.
@@ -142,3 +310,18 @@ func PEMToX509(b []byte) (*x509.Certificate, error) {
 	xc, err := x509.ParseCertificate(block.Bytes)
 	return xc, err
 }
+
+// SignMessage reads the message from r, hashes it with hash and signs the
+// resulting digest with signer. Messages of any size are streamed through the
+// hash, so callers never have to pre-hash, and the digest passed to the signer
+// always matches the hash it is told about.
+func SignMessage(signer crypto.Signer, r io.Reader, hash crypto.Hash) ([]byte, error) {
+	if !hash.Available() {
+		return nil, fmt.Errorf("unsupported hash algorithm %v", hash)
+	}
+	h := hash.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, fmt.Errorf("could not hash message: %v", err)
+	}
+	return signer.Sign(rand.Reader, h.Sum(nil), hash)
+}
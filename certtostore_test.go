@@ -15,12 +15,21 @@
 package certtostore
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
 	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/pem"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/google/certtostore/testdata"
+	"github.com/google/certtostore/testdata/helpers"
 )
 
 func TestFileStore(t *testing.T) {
@@ -80,6 +89,74 @@ func TestFileStore(t *testing.T) {
 	if !cert.Equal(xc) {
 		t.Errorf("expected read-back intermediate to match xc, instead it's %v", cert)
 	}
+
+	ms, ok := tc.(ManagedStorage)
+	if !ok {
+		t.Fatalf("FileStorage does not implement ManagedStorage")
+	}
+	key, err := ms.Signer()
+	if err != nil {
+		t.Fatalf("error while reading back written key: %v", err)
+	}
+	if key == nil || !bytes.Equal(key.Public().(*rsa.PublicKey).N.Bytes(), signer.Public().(*rsa.PublicKey).N.Bytes()) {
+		t.Errorf("expected read-back key to match the generated key, instead it's %v", key)
+	}
+	if err := ms.Link(); err != nil {
+		t.Errorf("link failed: %v", err)
+	}
+
+	if err := ms.Remove(false); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	if cert, err := tc.Cert(); err != nil || cert != nil {
+		t.Errorf("expected no cert after remove, instead %v, %v", cert, err)
+	}
+	if key, err := ms.Signer(); err != nil || key != nil {
+		t.Errorf("expected no key after remove, instead %v, %v", key, err)
+	}
+	if err := ms.Remove(false); err != nil {
+		t.Errorf("remove of an empty store failed: %v", err)
+	}
+}
+
+func TestFileStoreGenerateECDSA(t *testing.T) {
+	xc, err := PEMToX509([]byte(testdata.CertPEM))
+	if err != nil {
+		t.Fatalf("error decoding test certificate: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "certstorage_test")
+	if err != nil {
+		t.Fatalf("failed to create temporary dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := NewFileStorage(dir)
+	signer, err := fs.GenerateAlgorithm(0, "ECDSA_P256")
+	if err != nil {
+		t.Fatalf("failed to generate signer: %v", err)
+	}
+	if _, ok := signer.Public().(*ecdsa.PublicKey); !ok {
+		t.Errorf("unexpected public key type %T, want *ecdsa.PublicKey", signer.Public())
+	}
+	if err := fs.Store(xc, xc); err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "cert.key"))
+	if err != nil {
+		t.Fatalf("failed to read stored key: %v", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "EC PRIVATE KEY" {
+		t.Fatalf("stored key is not an EC PRIVATE KEY PEM block")
+	}
+	if _, err := x509.ParseECPrivateKey(block.Bytes); err != nil {
+		t.Errorf("failed to parse stored key: %v", err)
+	}
+
+	if _, err := fs.GenerateAlgorithm(2048, "DSA"); err == nil {
+		t.Error("Generate succeeded with an unsupported algorithm, want error")
+	}
 }
 
 func TestFileStoreImplementation(t *testing.T) {
@@ -101,3 +178,45 @@ func TestPEMToX509(t *testing.T) {
 		t.Fatalf("unexpected certificate issuer got:%v, want:%v", xCissuer, issuer)
 	}
 }
+
+func TestSignMessage(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+
+	msg := bytes.Repeat([]byte("certtostore"), 100000)
+	sig, err := SignMessage(key, bytes.NewReader(msg), crypto.SHA256)
+	if err != nil {
+		t.Fatalf("SignMessage returned %v", err)
+	}
+
+	digest := sha256.Sum256(msg)
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], sig); err != nil {
+		t.Errorf("signature from SignMessage did not verify: %v", err)
+	}
+
+	if _, err := SignMessage(key, bytes.NewReader(msg), crypto.MD4); err == nil {
+		t.Error("SignMessage with an unavailable hash succeeded, want error")
+	}
+}
+
+func TestFileStoreHelperVectors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certstorage_test")
+	if err != nil {
+		t.Fatalf("failed to create temporary dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := NewFileStorage(dir)
+	for _, keyPEM := range []string{helpers.RSAKeyPEM, helpers.ECDSAKeyPEM} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "cert.key"), []byte(keyPEM), 0600); err != nil {
+			t.Fatalf("failed to write test key: %v", err)
+		}
+		signer, err := fs.Signer()
+		if err != nil {
+			t.Fatalf("Signer returned %v", err)
+		}
+		helpers.AssertSigner(t, signer)
+	}
+}
@@ -17,27 +17,25 @@
 package certtostore
 
 import (
-	"bytes"
 	"crypto"
 	"crypto/rsa"
 	"crypto/ecdsa"
-	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/x509"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
-	"math/big"
-	"os"
 	"os/exec"
 	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 	"unicode/utf16"
 	"unsafe"
 
 	"golang.org/x/sys/windows"
-	"github.com/google/logger"
 )
 
 const (
@@ -49,11 +47,15 @@ const (
 	certStoreLocalMachine   = uint32(certStoreLocalMachineID << compareShift) // CERT_SYSTEM_STORE_LOCAL_MACHINE
 	certStoreCurrentUserID  = 1                                               // CERT_SYSTEM_STORE_CURRENT_USER_ID
 	certStoreLocalMachineID = 2                                               // CERT_SYSTEM_STORE_LOCAL_MACHINE_ID
+	certStoreOpenExisting   = 0x4000                                          // CERT_STORE_OPEN_EXISTING_FLAG
+	certStoreReadOnly       = 0x8000                                          // CERT_STORE_READONLY_FLAG
+	certStoreAddNew         = 1                                               // CERT_STORE_ADD_NEW
 	infoIssuerFlag          = 4                                               // CERT_INFO_ISSUER_FLAG
 	compareNameStrW         = 8                                               // CERT_COMPARE_NAME_STR_A
 	compareShift            = 16                                              // CERT_COMPARE_SHIFT
 	findIssuerStr           = compareNameStrW<<compareShift | infoIssuerFlag  // CERT_FIND_ISSUER_STR_W
-	signatureKeyUsage       = 0x80                                            // CERT_DIGITAL_SIGNATURE_KEY_USAGE
+	findAny                 = 0                                               // CERT_FIND_ANY
+	findExisting            = 13 << compareShift                              // CERT_FIND_EXISTING
 	acquireCached           = 0x1                                             // CRYPT_ACQUIRE_CACHE_FLAG
 	acquireSilent           = 0x40                                            // CRYPT_ACQUIRE_SILENT_FLAG
 	acquireOnlyNCryptKey    = 0x40000                                         // CRYPT_ACQUIRE_ONLY_NCRYPT_KEY_FLAG
@@ -61,46 +63,31 @@ const (
 
 	// Legacy CryptoAPI flags
 	bCryptPadPKCS1 uintptr = 0x2
-
-	// Magic number for RSA1 public key blobs.
-	rsa1Magic = 0x31415352 // "RSA1"
-	// https://github.com/dotnet/corefx/blob/master/src/Common/src/Interop/Windows/BCrypt/Interop.Blobs.cs#L92
-  ecdsaP256Magic = 0x31534345
-	ecdsaP384Magic = 0x33534345
-	ecdsaP521Magic = 0x35534345
+	bCryptPadPSS   uintptr = 0x8
 
 	// ncrypt.h constants
-	ncryptPersistFlag      = 0x80000000 // NCRYPT_PERSIST_FLAG
-	ncryptAllowDecryptFlag = 0x1        // NCRYPT_ALLOW_DECRYPT_FLAG
-	ncryptAllowSigningFlag = 0x2        // NCRYPT_ALLOW_SIGNING_FLAG
+	ncryptPersistFlag           = 0x80000000 // NCRYPT_PERSIST_FLAG
+	ncryptAllowDecryptFlag      = 0x1        // NCRYPT_ALLOW_DECRYPT_FLAG
+	ncryptAllowSigningFlag      = 0x2        // NCRYPT_ALLOW_SIGNING_FLAG
+	ncryptAllowKeyAgreementFlag = 0x4        // NCRYPT_ALLOW_KEY_AGREEMENT_FLAG
+	ncryptSilentFlag            = 0x40       // NCRYPT_SILENT_FLAG
 
 	// NCryptPadOAEPFlag is used with Decrypt to specify whether to use OAEP.
-	NCryptPadOAEPFlag = 0x00000004 // NCRYPT_PAD_OAEP_FLAG
+	NCryptPadOAEPFlag  = 0x00000004 // NCRYPT_PAD_OAEP_FLAG
+	ncryptPadPKCS1Flag = 0x00000002 // NCRYPT_PAD_PKCS1_FLAG
 
 	// key creation flags.
-	nCryptMachineKey   = 0x20 // NCRYPT_MACHINE_KEY_FLAG
-	nCryptOverwriteKey = 0x80 // NCRYPT_OVERWRITE_KEY_FLAG
+	nCryptMachineKey              = 0x20    // NCRYPT_MACHINE_KEY_FLAG
+	nCryptOverwriteKey            = 0x80    // NCRYPT_OVERWRITE_KEY_FLAG
+	ncryptUseVirtualIsolationFlag = 0x20000 // NCRYPT_USE_VIRTUAL_ISOLATION_FLAG
+	ncryptUsePerBootKeyFlag       = 0x40000 // NCRYPT_USE_PER_BOOT_KEY_FLAG
 
 	// winerror.h constants
-	cryptENotFound = 0x80092004 // CRYPT_E_NOT_FOUND
-
-	// ProviderMSPlatform represents the Microsoft Platform Crypto Provider
-	ProviderMSPlatform = "Microsoft Platform Crypto Provider"
-	// ProviderMSSoftware represents the Microsoft Software Key Storage Provider
-	ProviderMSSoftware = "Microsoft Software Key Storage Provider"
+	cryptEExists = 0x80092005 // CRYPT_E_EXISTS
 )
 
 var (
-	bCryptRSAPublicBlob = wide("RSAPUBLICBLOB")
-	bCryptECCPublicBlob = wide("ECCPUBLICBLOB")
-
-	// algIDs maps crypto.Hash values to bcrypt.h constants.
-	algIDs = map[crypto.Hash]*uint16{
-		crypto.SHA1:   wide("SHA1"),   // BCRYPT_SHA1_ALGORITHM
-		crypto.SHA256: wide("SHA256"), // BCRYPT_SHA256_ALGORITHM
-		crypto.SHA384: wide("SHA384"), // BCRYPT_SHA384_ALGORITHM
-		crypto.SHA512: wide("SHA512"), // BCRYPT_SHA512_ALGORITHM
-	}
+	sha256AlgID = wide("SHA256") // BCRYPT_SHA256_ALGORITHM
 
 	// MY, CA and ROOT are well-known system stores that holds certificates.
 	// The store that is opened (system or user) depends on the system call used.
@@ -113,26 +100,20 @@ var (
 	nCrypt  = windows.MustLoadDLL("ncrypt.dll")
 
 	certDeleteCertificateFromStore  = crypt32.MustFindProc("CertDeleteCertificateFromStore")
-	certFindCertificateInStore      = crypt32.MustFindProc("CertFindCertificateInStore")
+	certGetCertificateContextProp   = crypt32.MustFindProc("CertGetCertificateContextProperty")
 	certGetIntendedKeyUsage         = crypt32.MustFindProc("CertGetIntendedKeyUsage")
 	cryptFindCertificateKeyProvInfo = crypt32.MustFindProc("CryptFindCertificateKeyProvInfo")
 	nCryptCreatePersistedKey        = nCrypt.MustFindProc("NCryptCreatePersistedKey")
 	nCryptDecrypt                   = nCrypt.MustFindProc("NCryptDecrypt")
-	nCryptExportKey                 = nCrypt.MustFindProc("NCryptExportKey")
+	nCryptEncrypt                   = nCrypt.MustFindProc("NCryptEncrypt")
 	nCryptFinalizeKey               = nCrypt.MustFindProc("NCryptFinalizeKey")
+	nCryptFreeObject                = nCrypt.MustFindProc("NCryptFreeObject")
 	nCryptOpenKey                   = nCrypt.MustFindProc("NCryptOpenKey")
 	nCryptOpenStorageProvider       = nCrypt.MustFindProc("NCryptOpenStorageProvider")
-	nCryptGetProperty               = nCrypt.MustFindProc("NCryptGetProperty")
-	nCryptSetProperty               = nCrypt.MustFindProc("NCryptSetProperty")
 	nCryptSignHash                  = nCrypt.MustFindProc("NCryptSignHash")
 	nCryptDeleteKey									= nCrypt.MustFindProc("NCryptDeleteKey")
 )
 
-// paddingInfo is the BCRYPT_PKCS1_PADDING_INFO struct in bcrypt.h.
-type paddingInfo struct {
-	pszAlgID *uint16
-}
-
 // wide returns a pointer to a a uint16 representing the equivalent
 // to a Windows LPCWSTR.
 func wide(s string) *uint16 {
@@ -141,6 +122,56 @@ func wide(s string) *uint16 {
 	return &w[0]
 }
 
+// algIDFor returns the CNG algorithm identifier registered for hash.
+func algIDFor(hash crypto.Hash) (*uint16, error) {
+	name, err := hashAlgorithm(hash)
+	if err != nil {
+		return nil, err
+	}
+	return wide(name), nil
+}
+
+// logKeyOp logs the outcome of an operation on a key. It is meant to be
+// deferred with a pointer to the named error result of the operation.
+func logKeyOp(op, container string, start time.Time, err *error) {
+	if *err == nil {
+		logDebug("Key operation succeeded.", opField(op), containerField(container), sinceField(start))
+		return
+	}
+	fields := []Field{opField(op), containerField(container), sinceField(start), errField(*err)}
+	switch e := (*err).(type) {
+	case *ncryptError:
+		fields = append(fields, statusField(e.status))
+	case *TPMError:
+		fields = append(fields, statusField(uintptr(e.Status)), field("remediation", e.Remediation))
+	}
+	logWarning("Key operation failed.", fields...)
+}
+
+// keyOp is like logKeyOp, but also records the operation in stats and its
+// outcome in the circuit breaker br.
+func keyOp(stats *keyStats, br *breaker, op, container string, start time.Time, err *error) {
+	logKeyOp(op, container, start, err)
+	var status uint32
+	provider := false
+	// Provider errors may be wrapped, as by TestSign.
+	var ne *ncryptError
+	var te *TPMError
+	switch {
+	case errors.As(*err, &ne):
+		status, provider = uint32(ne.status), true
+	case errors.As(*err, &te):
+		status, provider = te.Status, true
+	}
+	stats.record(op, time.Since(start), *err != nil, status)
+	if *err == ErrCircuitOpen {
+		// The operation was not allowed, so there is no outcome to record.
+		return
+	}
+	ignored := *err != nil && (!provider || breakerIgnoredStatus[status])
+	br.done(provider && !ignored, ignored)
+}
+
 func openProvider(provider string) (uintptr, error) {
 	var err error
 	var hProv uintptr
@@ -150,28 +181,23 @@ func openProvider(provider string) (uintptr, error) {
 	if r == 0 {
 		return hProv, nil
 	}
-	return hProv, fmt.Errorf("NCryptOpenStorageProvider returned %X, %v", r, err)
+	return hProv, ncryptErr("NCryptOpenStorageProvider", r, "", err)
 }
 
 // findCert wraps the CertFindCertificateInStore call. Note that any cert context passed
 // into prev will be freed. If no certificate was found, nil will be returned.
-func findCert(store windows.Handle, enc, findFlags, findType uint32, para *uint16, prev *windows.CertContext) (*windows.CertContext, error) {
-	h, _, err := certFindCertificateInStore.Call(
-		uintptr(store),
-		uintptr(enc),
-		uintptr(findFlags),
-		uintptr(findType),
-		uintptr(unsafe.Pointer(para)),
-		uintptr(unsafe.Pointer(prev)),
-	)
-	if h == 0 {
+// windows.CertFindCertificateInStore returns the context as a pointer, so it
+// never passes through a uintptr here.
+func findCert(store windows.Handle, enc, findFlags, findType uint32, para unsafe.Pointer, prev *windows.CertContext) (*windows.CertContext, error) {
+	cert, err := windows.CertFindCertificateInStore(store, enc, findFlags, findType, para, prev)
+	if cert == nil {
 		// Actual error, or simply not found?
 		if errno, ok := err.(syscall.Errno); ok && errno == cryptENotFound {
 			return nil, nil
 		}
 		return nil, err
 	}
-	return (*windows.CertContext)(unsafe.Pointer(h)), nil
+	return cert, nil
 }
 
 // intendedKeyUsage wraps CertGetIntendedKeyUsage. If there are key usage bytes they will be returned,
@@ -181,101 +207,490 @@ func intendedKeyUsage(enc uint32, cert *windows.CertContext) (usage uint16) {
 	return
 }
 
-// WinCertStore is a CertStorage implementation for the Windows Certificate Store.
+// SystemLocation is the location of a system certificate store, such as the
+// current user or the local machine.
+type SystemLocation uint32
+
+const (
+	// LocationCurrentUser refers to the system stores of the current user.
+	LocationCurrentUser = SystemLocation(certStoreCurrentUser)
+	// LocationLocalMachine refers to the system stores of the local machine.
+	// They include the certificates of the Group Policy and Enterprise
+	// locations.
+	LocationLocalMachine = SystemLocation(certStoreLocalMachine)
+	// LocationCurrentUserGroupPolicy refers to the stores of the current user
+	// that are delivered by Group Policy.
+	LocationCurrentUserGroupPolicy = SystemLocation(7 << compareShift) // CERT_SYSTEM_STORE_CURRENT_USER_GROUP_POLICY
+	// LocationLocalMachineGroupPolicy refers to the stores of the local
+	// machine that are delivered by Group Policy.
+	LocationLocalMachineGroupPolicy = SystemLocation(8 << compareShift) // CERT_SYSTEM_STORE_LOCAL_MACHINE_GROUP_POLICY
+	// LocationLocalMachineEnterprise refers to the stores of the local
+	// machine that are replicated from Active Directory.
+	LocationLocalMachineEnterprise = SystemLocation(9 << compareShift) // CERT_SYSTEM_STORE_LOCAL_MACHINE_ENTERPRISE
+)
+
+func (l SystemLocation) String() string {
+	switch l {
+	case LocationCurrentUser:
+		return "CurrentUser"
+	case LocationLocalMachine:
+		return "LocalMachine"
+	case LocationCurrentUserGroupPolicy:
+		return "CurrentUserGroupPolicy"
+	case LocationLocalMachineGroupPolicy:
+		return "LocalMachineGroupPolicy"
+	case LocationLocalMachineEnterprise:
+		return "LocalMachineEnterprise"
+	default:
+		return fmt.Sprintf("SystemLocation(%#x)", uint32(l))
+	}
+}
+
+// StoreLocation identifies a named system certificate store in a location,
+// for example the MY store of the local machine.
+type StoreLocation struct {
+	Location SystemLocation
+	// Name is the name of the system store, such as MY, CA or ROOT.
+	Name string
+}
+
+func (l StoreLocation) String() string {
+	return l.Location.String() + `\` + l.Name
+}
+
+// openStore opens the system store identified by loc with the additional
+// CertOpenStore flags.
+func openStore(loc StoreLocation, flags uint32) (windows.Handle, error) {
+	name, err := windows.UTF16PtrFromString(loc.Name)
+	if err != nil {
+		return 0, err
+	}
+	return windows.CertOpenStore(
+		certStoreProvSystem,
+		0,
+		0,
+		uint32(loc.Location)|flags,
+		uintptr(unsafe.Pointer(name)))
+}
+
+// lookupFlags returns the CertOpenStore flags for stores that w only reads from.
+func (w *WinCertStore) lookupFlags() uint32 {
+	if w.readOnlyLookups {
+		return w.openFlags(certStoreOpenExisting | certStoreReadOnly)
+	}
+	return w.openFlags(0)
+}
+
+// openFlags returns the CertOpenStore flags with the raw flags of w added.
+func (w *WinCertStore) openFlags(flags uint32) uint32 {
+	return flags | w.rawFlags.get(FlagOpOpenStore)
+}
+
+// WinCertStore stores certificates and keys in the Windows Certificate Store.
+// Its Generate takes the key algorithm, so it does not implement CertStorage
+// itself: use CertStorage to pass it to code that works with any backend.
 type WinCertStore struct {
-	CStore              windows.Handle
-	Prov                uintptr
-	ProvName            string
+	CStore   windows.Handle
+	Prov     uintptr
+	ProvName string
+	// issuersMu guards issuers and intermediateIssuers, which can be
+	// replaced at runtime with SetIssuers and SetIntermediateIssuers.
+	issuersMu           sync.RWMutex
 	issuers             []string
 	intermediateIssuers []string
+	issuerMatch         IssuerMatch
+	selection           CertSelection
+	readOnlyLookups     bool
 	container           string
+	allowPrivateExport  bool
+	generateTimeout     time.Duration
+	generateProgress    func(elapsed time.Duration)
+	rawFlags            RawFlags
+	rotationOverlap     time.Duration
+	breaker             *breaker
+	quotas              *usageQuotas
+	// keyAlgorithm, if set, restricts the certificates of the MY store to
+	// those with keys of the algorithm, see Hybrid.
+	keyAlgorithm       x509.PublicKeyAlgorithm
+	deriveIntermediate bool
+	signatureCheck     SignatureCheck
+	// namespace, if set, restricts the key containers and certificates w
+	// uses to those of the namespace, see WinCertStoreOptions.Namespace.
+	namespace      string
+	linkOnStore    bool
+	keyUsageFilter KeyUsageFilter
+	// skipStoreVerification disables the key check of Store.
+	skipStoreVerification bool
+	// machineKeys is set if the container of w is in the key store of the
+	// machine, either by WinCertStoreOptions.MachineKeys or because
+	// Generate created a machine key in it.
+	machineKeys atomic.Bool
+}
+
+// winCertStorage adapts a WinCertStore to CertStorage, whose Generate has no
+// algorithm parameter.
+type winCertStorage struct {
+	*WinCertStore
+}
+
+var _ CertStorage = winCertStorage{}
+var _ AlgorithmGenerator = winCertStorage{}
+var _ ManagedStorage = winCertStorage{}
+
+// Generate creates an RSA key of keySize bits.
+func (s winCertStorage) Generate(keySize int) (crypto.Signer, error) {
+	return s.WinCertStore.Generate(keySize, "RSA")
+}
+
+// GenerateAlgorithm creates a key of alg, like WinCertStore.Generate.
+func (s winCertStorage) GenerateAlgorithm(keySize int, alg string) (crypto.Signer, error) {
+	return s.WinCertStore.Generate(keySize, alg)
+}
+
+// CertStorage returns w as a CertStorage for code that works with any
+// backend. It also implements AlgorithmGenerator and ManagedStorage.
+func (w *WinCertStore) CertStorage() CertStorage {
+	return winCertStorage{w}
+}
+
+// WinCertStoreOptions contains the options used to open a WinCertStore.
+type WinCertStoreOptions struct {
+	// Provider is the name of the key storage provider, such as ProviderMSPlatform.
+	Provider string
+	// Container is the name of the key container.
+	Container string
+	// Issuers and IntermediateIssuers are used to look up the certificates.
+	Issuers             []string
+	IntermediateIssuers []string
+	// IssuerMatch selects how Issuers and IntermediateIssuers are matched.
+	IssuerMatch IssuerMatch
+	// Selection decides which certificate is used if the issuers have more
+	// than one.
+	Selection CertSelection
+	// ReadOnlyLookups opens the stores that are only read from, such as by
+	// Cert, Intermediate and CertInfo, read-only and without creating them if
+	// they do not exist. Inventory tools should set it, so that a mistyped
+	// store name results in an error rather than a new empty store.
+	ReadOnlyLookups bool
+	// AllowPrivateExport permits exporting private key material with
+	// ExportFullPrivateBlob, for keys whose export policy also allows
+	// plaintext export, see GenerateOpts.Export. It defeats the protection
+	// offered by the key storage provider and must only be set for approved
+	// key escrow scenarios.
+	AllowPrivateExport bool
+	// GenerateTimeout bounds how long Generate waits for the provider to
+	// finalize a key, which can take more than 30 seconds on some TPMs. Zero
+	// means no timeout. See ErrGenerateTimeout.
+	GenerateTimeout time.Duration
+	// GenerateProgress, if set, is called periodically with the elapsed time
+	// while Generate waits for the provider, so callers can report progress.
+	GenerateProgress func(elapsed time.Duration)
+	// CheckServices makes OpenWinCertStoreWithOptions verify the services
+	// the provider depends on with CheckKeyServices, and StartServices
+	// additionally starts them if they are stopped.
+	CheckServices bool
+	StartServices bool
+	// RawFlags passes additional documented flags to the Windows APIs of some
+	// operations. Only flags on an allow-list are accepted.
+	RawFlags RawFlags
+	// RotationOverlap is how long a replaced certificate stays current after
+	// a new one is issued, see RotationPair.
+	RotationOverlap time.Duration
+	// CircuitBreaker makes key operations fail fast with ErrCircuitOpen
+	// while the provider is failing. It is disabled by default.
+	CircuitBreaker CircuitBreaker
+	// UsageQuota limits how often each key signs and decrypts, and reports
+	// keys that exceed the limits. It is disabled by default.
+	UsageQuota UsageQuota
+	// DeriveIntermediate makes Intermediate find the issuer of the current
+	// cert in the CA stores by its name and key identifier, instead of
+	// looking up IntermediateIssuers, which must be empty.
+	DeriveIntermediate bool
+	// SignatureCheck selects whether Sign verifies signatures against the
+	// public key before returning them. By default only debug builds do.
+	SignatureCheck SignatureCheck
+	// Namespace isolates the identities of tenants or workloads that share a
+	// machine. It is prefixed to Container and to the names passed to KDK,
+	// CertByFriendlyName and SetFriendlyName, followed by NamespaceSeparator,
+	// and lookups skip the keys and MY store certificates of other
+	// namespaces. It may only contain letters, digits, '.', '_' and '-'.
+	Namespace string
+	// LinkOnStore makes Store link the certificate to the user store like
+	// Link, and add the intermediate to the user CA store, so that a
+	// certificate is never left stored but not linked.
+	LinkOnStore bool
+	// KeyUsageFilter selects the MY store certificates that are considered
+	// by their key usage. By default only certificates that can sign are.
+	KeyUsageFilter KeyUsageFilter
+	// SkipStoreVerification stops Store from acquiring the private key of the
+	// new certificate and test signing with it before adding the certificates
+	// to the stores. Without the check, a certificate whose key is missing or
+	// unusable is only detected when it is first used.
+	SkipStoreVerification bool
+	// MachineKeys opens the keys of Container in the key store of the
+	// machine instead of the user, as GenerateOpts.Machine creates them.
+	MachineKeys bool
 }
 
 // OpenWinCertStore creates a WinCertStore.
 func OpenWinCertStore(provider, container string, issuers, intermediateIssuers []string) (*WinCertStore, error) {
+	return OpenWinCertStoreWithOptions(WinCertStoreOptions{
+		Provider:            provider,
+		Container:           container,
+		Issuers:             issuers,
+		IntermediateIssuers: intermediateIssuers,
+	})
+}
+
+// OpenWinCertStoreWithOptions creates a WinCertStore configured by opts.
+func OpenWinCertStoreWithOptions(opts WinCertStoreOptions) (*WinCertStore, error) {
+	if opts.CheckServices || opts.StartServices {
+		if err := CheckKeyServices(opts.Provider, opts.StartServices); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := validateIssuers(opts.IssuerMatch, opts.Issuers); err != nil {
+		return nil, err
+	}
+	if err := validateIssuers(opts.IssuerMatch, opts.IntermediateIssuers); err != nil {
+		return nil, err
+	}
+	if err := opts.Selection.validate(); err != nil {
+		return nil, err
+	}
+	if err := opts.RawFlags.validate(); err != nil {
+		return nil, err
+	}
+	if err := opts.CircuitBreaker.validate(); err != nil {
+		return nil, err
+	}
+	if err := opts.UsageQuota.validate(); err != nil {
+		return nil, err
+	}
+	if err := opts.SignatureCheck.validate(); err != nil {
+		return nil, err
+	}
+	if err := validateNamespace(opts.Namespace); err != nil {
+		return nil, err
+	}
+	if err := opts.KeyUsageFilter.validate(); err != nil {
+		return nil, err
+	}
+	if opts.DeriveIntermediate && len(opts.IntermediateIssuers) > 0 {
+		return nil, errors.New("IntermediateIssuers must be empty when DeriveIntermediate is set")
+	}
+
 	// Open a handle to the crypto provider we will use for private key operations
-	cngProv, err := openProvider(provider)
+	cngProv, err := openProvider(opts.Provider)
 	if err != nil {
 		return nil, fmt.Errorf("unable to open crypto provider or provider not available: %v", err)
 	}
 
 	wcs := &WinCertStore{
-		Prov:                cngProv,
-		ProvName:            provider,
-		issuers:             issuers,
-		intermediateIssuers: intermediateIssuers,
-		container:           container,
-	}
+		Prov:                  cngProv,
+		ProvName:              opts.Provider,
+		issuers:               opts.Issuers,
+		intermediateIssuers:   opts.IntermediateIssuers,
+		issuerMatch:           opts.IssuerMatch,
+		selection:             opts.Selection,
+		readOnlyLookups:       opts.ReadOnlyLookups,
+		container:             namespacedName(opts.Namespace, opts.Container),
+		allowPrivateExport:    opts.AllowPrivateExport,
+		generateTimeout:       opts.GenerateTimeout,
+		generateProgress:      opts.GenerateProgress,
+		rawFlags:              opts.RawFlags,
+		rotationOverlap:       opts.RotationOverlap,
+		breaker:               newBreaker(opts.CircuitBreaker, opts.Provider),
+		quotas:                newUsageQuotas(opts.UsageQuota),
+		deriveIntermediate:    opts.DeriveIntermediate,
+		signatureCheck:        opts.SignatureCheck,
+		namespace:             opts.Namespace,
+		linkOnStore:           opts.LinkOnStore,
+		keyUsageFilter:        opts.KeyUsageFilter,
+		skipStoreVerification: opts.SkipStoreVerification,
+	}
+	wcs.machineKeys.Store(opts.MachineKeys)
 	return wcs, nil
 }
 
+// CircuitBreakerStatus returns the state of the circuit breaker of the key
+// operations of w, see CircuitBreaker.
+func (w *WinCertStore) CircuitBreakerStatus() BreakerStatus {
+	return w.breaker.snapshot()
+}
+
 // Cert returns the current cert associated with this WinCertStore or nil if there isn't one.
 func (w *WinCertStore) Cert() (*x509.Certificate, error) {
-	return w.cert(w.issuers, my, certStoreLocalMachine)
+	return w.cert(w.issuerList(), my, certStoreLocalMachine)
 }
 
 // cert is used by the exported Cert, Intermediate and root functions to lookup certificates.
 // store is used to specify which store to perform the lookup in (system or user).
 func (w *WinCertStore) cert(issuers []string, searchRoot *uint16, store uint32) (*x509.Certificate, error) {
+	cert, certContext, err := w.certContext(issuers, searchRoot, store)
+	if err != nil {
+		return nil, err
+	}
+	if certContext != nil {
+		windows.CertFreeCertificateContext(certContext)
+	}
+	return cert, nil
+}
+
+// certContext looks up a certificate like cert, but also returns its
+// certificate context. The caller must free the returned context.
+func (w *WinCertStore) certContext(issuers []string, searchRoot *uint16, store uint32) (*x509.Certificate, *windows.CertContext, error) {
+	xc, nc, _, err := w.selectCertContext(issuers, searchRoot, store)
+	return xc, nc, err
+}
+
+// selectCertContext looks up the usable certificates of issuers and picks one
+// according to the selection policy of w. It also returns the certificate
+// context, which the caller must free, and the reason for the selection.
+func (w *WinCertStore) selectCertContext(issuers []string, searchRoot *uint16, store uint32) (*x509.Certificate, *windows.CertContext, *Selection, error) {
 	// Open a handle to the system cert store
 	certStore, err := windows.CertOpenStore(
 		certStoreProvSystem,
 		0,
 		0,
-		store,
+		store|w.lookupFlags(),
 		uintptr(unsafe.Pointer(searchRoot)))
 	if err != nil {
-		return nil, fmt.Errorf("store: CertOpenStore returned %v", err)
+		return nil, nil, nil, fmt.Errorf("store: CertOpenStore returned %v", err)
 	}
 	defer windows.CertCloseStore(certStore, 0)
 
-	var prev *windows.CertContext
-	var cert *x509.Certificate
+	if w.selection.Policy == SelectIssuerOrder {
+		return w.issuerOrderCertContext(certStore, issuers, searchRoot)
+	}
+
+	// Candidates are kept as duplicated contexts, because findCert frees the
+	// context it continues from. The ones that are not selected are freed.
+	var candidates []certCandidate
+	var contexts []*windows.CertContext
+	defer func() {
+		for _, c := range contexts {
+			if c != nil {
+				windows.CertFreeCertificateContext(c)
+			}
+		}
+	}()
+
 	for _, issuer := range issuers {
-		i, err := windows.UTF16PtrFromString(issuer)
-		if err != nil {
-			return nil, err
+		// Walk all certificates from this issuer until one is usable for signing.
+		// findCert frees prev, so only the returned context needs to be freed.
+		var prev *windows.CertContext
+		for {
+			nc, err := w.findIssuedCert(certStore, issuer, prev)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("finding certificates: %v", err)
+			}
+			if nc == nil {
+				// No more certificates from this issuer
+				break
+			}
+			prev = nc
+			xc := w.usableCert(nc, searchRoot)
+			if xc == nil {
+				continue
+			}
+			c := certCandidate{issuer: issuer, cert: xc}
+			if w.selection.Policy == SelectFirstUsable {
+				_, sel := selectCert(w.selection, []certCandidate{c})
+				return xc, nc, sel, nil
+			}
+			candidates = append(candidates, c)
+			contexts = append(contexts, duplicateCertContext(nc))
 		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil, nil, nil
+	}
 
-		// pass 0 as the third parameter because it is not used
-		// https://msdn.microsoft.com/en-us/library/windows/desktop/aa376064(v=vs.85).aspx
-		nc, err := findCert(certStore, encodingX509ASN|encodingPKCS7, 0, findIssuerStr, i, prev)
+	i, sel := selectCert(w.selection, candidates)
+	nc := contexts[i]
+	contexts[i] = nil
+	logDebug("Selected certificate.", thumbprintField(sel.Thumbprint), field("issuer", sel.Issuer), field("policy", w.selection.Policy), field("candidates", sel.Candidates), field("reason", sel.Reason))
+	return candidates[i].cert, nc, sel, nil
+}
+
+// issuerOrderCertContext implements SelectIssuerOrder for selectCertContext.
+func (w *WinCertStore) issuerOrderCertContext(certStore windows.Handle, issuers []string, searchRoot *uint16) (*x509.Certificate, *windows.CertContext, *Selection, error) {
+	// findCert frees prev, so only the last certificate found needs to be
+	// freed if none is selected.
+	var prev *windows.CertContext
+	defer func() {
+		if prev != nil {
+			windows.CertFreeCertificateContext(prev)
+		}
+	}()
+	for _, issuer := range issuers {
+		nc, err := w.findIssuedCert(certStore, issuer, prev)
+		prev = nc
 		if err != nil {
-			return nil, fmt.Errorf("finding certificates: %v", err)
+			return nil, nil, nil, fmt.Errorf("finding certificates: %v", err)
 		}
 		if nc == nil {
 			// No certificate found
 			continue
 		}
-		prev = nc
-		if (intendedKeyUsage(encodingX509ASN, nc) & signatureKeyUsage) == 0 {
-			continue
-		}
-
-		// Extract the DER-encoded certificate from the cert context.
-		var der []byte
-		slice := (*reflect.SliceHeader)(unsafe.Pointer(&der))
-		slice.Data = uintptr(unsafe.Pointer(nc.EncodedCert))
-		slice.Len = int(nc.Length)
-		slice.Cap = int(nc.Length)
-
-		xc, err := x509.ParseCertificate(der)
-		if err != nil {
+		xc := w.usableCert(nc, searchRoot)
+		if xc == nil {
 			continue
 		}
+		prev = nil
+		_, sel := selectCert(w.selection, []certCandidate{{issuer: issuer, cert: xc}})
+		return xc, nc, sel, nil
+	}
+	return nil, nil, nil, nil
+}
 
-		cert = xc
-		break
+// usableCert parses the certificate of nc, or returns nil if it does not pass
+// the key usage, key algorithm and namespace filters of w.
+func (w *WinCertStore) usableCert(nc *windows.CertContext, searchRoot *uint16) *x509.Certificate {
+	if !w.keyUsageFilter.match(keyUsageFromCAPI(intendedKeyUsage(encodingX509ASN, nc))) {
+		return nil
 	}
-	if cert == nil {
-		return nil, nil
+	xc, err := x509.ParseCertificate(certContextBytes(nc))
+	if err != nil {
+		return nil
 	}
-	return cert, nil
+	if w.keyAlgorithm != x509.UnknownPublicKeyAlgorithm && searchRoot == my && xc.PublicKeyAlgorithm != w.keyAlgorithm {
+		return nil
+	}
+	if searchRoot == my && !w.certInNamespace(nc) {
+		return nil
+	}
+	return xc
+}
+
+// certContextBytes returns a copy of the DER-encoded certificate held by the cert context.
+func certContextBytes(certContext *windows.CertContext) []byte {
+	var der []byte
+	slice := (*reflect.SliceHeader)(unsafe.Pointer(&der))
+	slice.Data = uintptr(unsafe.Pointer(certContext.EncodedCert))
+	slice.Len = int(certContext.Length)
+	slice.Cap = int(certContext.Length)
+	return append([]byte(nil), der...)
 }
 
 // Link will associate the certificate installed in the system store to the user store.
 func (w *WinCertStore) Link() error {
-	cert, err := w.cert(w.issuers, my, certStoreLocalMachine)
+	_, err := w.LinkWithResult()
+	return err
+}
+
+// LinkWithResult is like Link, but also returns a Result describing what changed.
+func (w *WinCertStore) LinkWithResult() (*Result, error) {
+	res := &Result{Operation: "link", Container: w.container}
+	return res, w.link(res)
+}
+
+// link links the system certificate to the user store and records the
+// addition in res.
+func (w *WinCertStore) link(res *Result) error {
+	cert, err := w.cert(w.issuerList(), my, certStoreLocalMachine)
 	if err != nil {
 		return fmt.Errorf("link: checking for existing machine certificates returned %v", err)
 	}
@@ -285,13 +700,13 @@ func (w *WinCertStore) Link() error {
 	}
 
 	// If the user cert is already there and matches the system cert, return early.
-	userCert, err := w.cert(w.issuers, my, certStoreCurrentUser)
+	userCert, err := w.cert(w.issuerList(), my, certStoreCurrentUser)
 	if err != nil {
 		return fmt.Errorf("link: checking for existing user certificates returned %v", err)
 	}
 	if userCert != nil {
 		if cert.SerialNumber.Cmp(userCert.SerialNumber) == 0 {
-			fmt.Fprintf(os.Stdout, "Certificate %s is already linked to the user certificate store.\n", cert.SerialNumber)
+			logInfo("Certificate is already linked to the user certificate store.", opField("link"), thumbprintField(thumbprint(cert)))
 			return nil
 		}
 	}
@@ -314,7 +729,8 @@ func (w *WinCertStore) Link() error {
 	)
 	// Windows calls will fill err with a success message, r is what must be checked instead
 	if r == 0 {
-		fmt.Printf("link: found a matching private key for the certificate, but association failed: %v", err)
+		logWarning("Found a matching private key for the certificate, but association failed.", opField("link"), thumbprintField(thumbprint(cert)), errField(err))
+		res.warnf("associating the private key failed: %v", err)
 	}
 
 	// Open a handle to the user cert store
@@ -322,7 +738,7 @@ func (w *WinCertStore) Link() error {
 		certStoreProvSystem,
 		0,
 		0,
-		certStoreCurrentUser,
+		w.openFlags(certStoreCurrentUser),
 		uintptr(unsafe.Pointer(my)))
 	if err != nil {
 		return fmt.Errorf("link: CertOpenStore for the user store returned %v", err)
@@ -333,53 +749,132 @@ func (w *WinCertStore) Link() error {
 	if err := windows.CertAddCertificateContextToStore(userStore, certContext, windows.CERT_STORE_ADD_ALWAYS, nil); err != nil {
 		return fmt.Errorf("link: CertAddCertificateContextToStore returned %v", err)
 	}
+	res.addChange(ActionAdded, thumbprint(cert), StoreLocation{LocationCurrentUser, "MY"}.String())
 
-	logger.Infof("Successfully linked to existing system certificate with serial %s.", cert.SerialNumber)
-	fmt.Fprintf(os.Stdout, "Successfully linked to existing system certificate with serial %s.\n", cert.SerialNumber)
+	logInfo("Successfully linked to existing system certificate.", opField("link"), thumbprintField(thumbprint(cert)))
+	return nil
+}
+
+// Migrate copies the certificate issued by any of w.issuers from one system
+// store to another and associates the copy with its private key, for example to
+// move a certificate between the user and machine MY stores or between named
+// stores. If removeSource is true, the certificate is deleted from the source
+// store once the copy has been added. Link is the special case of copying from
+// the machine MY store to the user MY store.
+func (w *WinCertStore) Migrate(from, to StoreLocation, removeSource bool) error {
+	fromName, err := windows.UTF16PtrFromString(from.Name)
+	if err != nil {
+		return err
+	}
+	cert, err := w.cert(w.issuerList(), fromName, uint32(from.Location))
+	if err != nil {
+		return fmt.Errorf("migrate: checking for existing certificates in %s returned %v", from, err)
+	}
+	if cert == nil {
+		logInfo("No certificate to migrate.", opField("migrate"), field("from", from))
+		return nil
+	}
+
+	certContext, err := windows.CertCreateCertificateContext(
+		encodingX509ASN|encodingPKCS7,
+		&cert.Raw[0],
+		uint32(len(cert.Raw)))
+	if err != nil {
+		return fmt.Errorf("migrate: CertCreateCertificateContext returned %v", err)
+	}
+	defer windows.CertFreeCertificateContext(certContext)
+
+	// Re-establish the key provider info, which is not carried over when only
+	// the encoded certificate is copied. Certificates without a private key,
+	// such as intermediates, are still migrated.
+	r, _, err := cryptFindCertificateKeyProvInfo.Call(
+		uintptr(unsafe.Pointer(certContext)),
+		uintptr(uint32(0)),
+		0,
+	)
+	if r == 0 {
+		logWarning("No private key could be associated with the certificate.", opField("migrate"), thumbprintField(thumbprint(cert)), errField(err))
+	}
+
+	toStore, err := openStore(to, w.openFlags(0))
+	if err != nil {
+		return fmt.Errorf("migrate: CertOpenStore for %s returned %v", to, err)
+	}
+	defer windows.CertCloseStore(toStore, 0)
+
+	if err := windows.CertAddCertificateContextToStore(toStore, certContext, windows.CERT_STORE_ADD_REPLACE_EXISTING, nil); err != nil {
+		return fmt.Errorf("migrate: CertAddCertificateContextToStore returned %v", err)
+	}
+	logInfo("Migrated certificate.", opField("migrate"), thumbprintField(thumbprint(cert)), field("from", from), field("to", to))
+
+	if !removeSource {
+		return nil
+	}
+
+	fromStore, err := openStore(from, w.openFlags(0))
+	if err != nil {
+		return fmt.Errorf("migrate: CertOpenStore for %s returned %v", from, err)
+	}
+	defer windows.CertCloseStore(fromStore, 0)
+
+	source, err := findCert(fromStore, encodingX509ASN|encodingPKCS7, 0, findExisting, unsafe.Pointer(certContext), nil)
+	if err != nil {
+		return fmt.Errorf("migrate: finding certificate %s in %s failed: %v", cert.SerialNumber, from, err)
+	}
+	if source == nil {
+		return nil
+	}
+	if err := removeCert(source); err != nil {
+		return fmt.Errorf("migrate: failed to remove certificate from %s: %v", from, err)
+	}
+	logInfo("Removed migrated certificate from the source store.", opField("migrate"), thumbprintField(thumbprint(cert)), field("from", from))
 	return nil
 }
 
 // Remove removes certificates issued by any of w.issuers from the user and/or system cert stores.
 // If it is unable to remove any certificates, it returns an error.
 func (w *WinCertStore) Remove(removeSystem bool) error {
-	for _, issuer := range w.issuers {
-		if err := w.remove(issuer, removeSystem); err != nil {
-			return err
+	_, err := w.RemoveWithResult(removeSystem)
+	return err
+}
+
+// RemoveWithResult is like Remove, but also returns a Result describing what changed.
+func (w *WinCertStore) RemoveWithResult(removeSystem bool) (*Result, error) {
+	res := &Result{Operation: "remove", Container: w.container}
+	for _, issuer := range w.issuerList() {
+		if err := w.remove(issuer, removeSystem, res); err != nil {
+			return res, err
 		}
 	}
-	return nil
+	return res, nil
 }
 
-// remove removes a certificate issued by w.issuer from the user and/or system cert stores.
-func (w *WinCertStore) remove(issuer string, removeSystem bool) error {
+// remove removes a certificate issued by w.issuer from the user and/or system cert stores
+// and records the removals in res.
+func (w *WinCertStore) remove(issuer string, removeSystem bool, res *Result) error {
 	userStore, err := windows.CertOpenStore(
 		certStoreProvSystem,
 		0,
 		0,
-		certStoreCurrentUser,
+		w.openFlags(certStoreCurrentUser),
 		uintptr(unsafe.Pointer(my)))
 	if err != nil {
 		return fmt.Errorf("remove: certopenstore for the user store returned %v", err)
 	}
 	defer windows.CertCloseStore(userStore, 0)
 
-	userCertContext, err := findCert(
-		userStore,
-		encodingX509ASN|encodingPKCS7,
-		0,
-		findIssuerStr,
-		wide(issuer),
-		nil)
+	userCertContext, err := w.findIssuedCert(userStore, issuer, nil)
 	if err != nil {
 		return fmt.Errorf("remove: finding user certificate issued by %s failed: %v", issuer, err)
 	}
 
 	if userCertContext != nil {
+		tp := contextThumbprint(userCertContext)
 		if err := removeCert(userCertContext); err != nil {
 			return fmt.Errorf("failed to remove user cert: %v", err)
 		}
-		logger.Info("Cleaned up a user certificate.")
-		fmt.Fprintln(os.Stderr, "Cleaned up a user certificate.")
+		res.addChange(ActionRemoved, tp, StoreLocation{LocationCurrentUser, "MY"}.String())
+		logInfo("Cleaned up a user certificate.", opField("remove"), thumbprintField(tp), field("issuer", issuer))
 	}
 
 	// if we're only removing the user cert, return early.
@@ -391,30 +886,25 @@ func (w *WinCertStore) remove(issuer string, removeSystem bool) error {
 		certStoreProvSystem,
 		0,
 		0,
-		certStoreLocalMachine,
+		w.openFlags(certStoreLocalMachine),
 		uintptr(unsafe.Pointer(my)))
 	if err != nil {
 		return fmt.Errorf("remove: certopenstore for the system store returned %v", err)
 	}
 	defer windows.CertCloseStore(systemStore, 0)
 
-	systemCertContext, err := findCert(
-		systemStore,
-		encodingX509ASN|encodingPKCS7,
-		0,
-		findIssuerStr,
-		wide(issuer),
-		nil)
+	systemCertContext, err := w.findIssuedCert(systemStore, issuer, nil)
 	if err != nil {
 		return fmt.Errorf("remove: finding system certificate issued by %s failed: %v", issuer, err)
 	}
 
 	if systemCertContext != nil {
+		tp := contextThumbprint(systemCertContext)
 		if err := removeCert(systemCertContext); err != nil {
 			return fmt.Errorf("failed to remove system cert: %v", err)
 		}
-		logger.Info("Cleaned up a system certificate.")
-		fmt.Fprintln(os.Stderr, "Cleaned up a system certificate.")
+		res.addChange(ActionRemoved, tp, StoreLocation{LocationLocalMachine, "MY"}.String())
+		logInfo("Cleaned up a system certificate.", opField("remove"), thumbprintField(tp), field("issuer", issuer))
 	}
 
 	return nil
@@ -431,10 +921,14 @@ func removeCert(certContext *windows.CertContext) error {
 }
 
 // Intermediate returns the current intermediate cert associated with this
-// WinCertStore or nil if there isn't one.
+// WinCertStore or nil if there isn't one. With DeriveIntermediate it is the
+// issuer of the current cert.
 func (w *WinCertStore) Intermediate() (*x509.Certificate, error) {
+	if w.deriveIntermediate {
+		return w.derivedIntermediate()
+	}
 	//TODO parameterize which cert store to use.
-	return w.cert(w.intermediateIssuers, my, certStoreCurrentUser)
+	return w.cert(w.intermediateIssuerList(), my, certStoreCurrentUser)
 }
 
 // Root returns the certificate issued by the specified issuer from the
@@ -445,26 +939,99 @@ func (w *WinCertStore) Root(issuer []string) (*x509.Certificate, error) {
 
 type Key interface {
 	Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error)
-	// Decrypt(rand io.Reader, blob []byte, opts crypto.DecrypterOpts) ([]byte, error)
+	SignMessage(r io.Reader, hash crypto.Hash) ([]byte, error)
+	// Decrypt returns ErrNotSupported for keys that cannot decrypt.
+	Decrypt(rand io.Reader, blob []byte, opts crypto.DecrypterOpts) ([]byte, error)
 	Public() crypto.PublicKey
+	// PublicDER and PublicPEM return the public key as a SubjectPublicKeyInfo,
+	// DER encoded or as a "PUBLIC KEY" PEM block.
+	PublicDER() ([]byte, error)
+	PublicPEM() ([]byte, error)
 	// SetACL(store *WinCertStore, access string, sid string, perm string) error
 	SignRaw(data []byte) ([]byte, error)
+	TestSign(silent bool) error
 	Delete() error
+	// Duplicate opens the same key container again and returns a Key with an
+	// independent handle, which can be closed without affecting this one.
+	Duplicate() (Key, error)
+	// Close releases the key handle. The key itself is not affected.
+	Close() error
+	// Handle returns the underlying NCRYPT_KEY_HANDLE, which remains owned by
+	// the key, see RsaKey.Handle.
+	Handle() uintptr
+	// Location returns where the key is stored, or nil for ephemeral keys.
+	Location() *KeyLocation
+	// Policy returns the export and usage policy, length and modification
+	// time of the key.
+	Policy() (*KeyPolicy, error)
+	// Stats returns the counters of the operations on the key since it was
+	// opened.
+	Stats() KeyStats
+	// Times returns when the key was created and last modified.
+	Times() (*KeyTimes, error)
+	// UseContext returns the purpose tag of the key, or "" if it has none.
+	UseContext() (string, error)
+	// SetUseContext tags the key with its purpose, see UseContextVPN.
+	SetUseContext(tag string) error
 }
 
+// Keys can be wrapped with KeyMiddleware.
+var _ CryptoKey = Key(nil)
+
 // EcdsaKey and RsaKey implement crypto.Signer and crypto.Decrypter for key based operations.
+// EcdsaKey is also used for ECDH keys, which only permit key agreement and
+// cannot be used to sign.
 type EcdsaKey struct {
 	handle	  uintptr
 	pub			  *ecdsa.PublicKey
 	Container	string
+	// allowExport is set if the key was opened from a store with AllowPrivateExport.
+	allowExport bool
+	// prov, name and openFlags are used to open the key again.
+	prov      uintptr
+	name      string
+	openFlags uint32
+	// stats counts the operations on handle.
+	stats *keyStats
+	// breaker is the circuit breaker of the store, shared by its keys.
+	breaker *breaker
+	// quota limits the operations on the key, it is shared by all handles
+	// of the key opened from the store.
+	quota *usageQuota
+	// verify is set if signatures are checked before Sign returns them.
+	verify bool
+	// location is where the key is stored, or nil for ephemeral keys.
+	location *KeyLocation
 }
 
 type RsaKey struct {
 	handle	  uintptr
 	pub			  *rsa.PublicKey
 	Container	string
+	// allowExport is set if the key was opened from a store with AllowPrivateExport.
+	allowExport bool
+	// prov, name and openFlags are used to open the key again.
+	prov      uintptr
+	name      string
+	openFlags uint32
+	// stats counts the operations on handle.
+	stats *keyStats
+	// breaker is the circuit breaker of the store, shared by its keys.
+	breaker *breaker
+	// quota limits the operations on the key, it is shared by all handles
+	// of the key opened from the store.
+	quota *usageQuota
+	// verify is set if signatures are checked before Sign returns them.
+	verify bool
+	// location is where the key is stored, or nil for ephemeral keys.
+	location *KeyLocation
 }
 
+var (
+	_ crypto.Decrypter = &RsaKey{}
+	_ crypto.Decrypter = &EcdsaKey{}
+)
+
 // Public exports a public key to implement crypto.Signer
 func (rk *RsaKey) Public() crypto.PublicKey {
 	return rk.pub
@@ -474,93 +1041,229 @@ func (ek *EcdsaKey) Public() crypto.PublicKey {
 	return ek.pub
 }
 
-// Sign returns the signature of a hash to implement crypto.Signer
-func (k *RsaKey) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+// PublicDER returns the DER encoded SubjectPublicKeyInfo of the key.
+func (rk *RsaKey) PublicDER() ([]byte, error) {
+	return publicDER(rk.pub)
+}
+
+// PublicDER returns the DER encoded SubjectPublicKeyInfo of the key.
+func (ek *EcdsaKey) PublicDER() ([]byte, error) {
+	return publicDER(ek.pub)
+}
+
+// PublicPEM returns the SubjectPublicKeyInfo of the key as a PEM block.
+func (rk *RsaKey) PublicPEM() ([]byte, error) {
+	return publicPEM(rk.pub)
+}
+
+// PublicPEM returns the SubjectPublicKeyInfo of the key as a PEM block.
+func (ek *EcdsaKey) PublicPEM() ([]byte, error) {
+	return publicPEM(ek.pub)
+}
+
+// Sign returns the signature of a hash to implement crypto.Signer. If opts is
+// a *rsa.PSSOptions the signature uses PSS padding, otherwise PKCS #1 v1.5.
+func (k *RsaKey) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) (_ []byte, err error) {
+	defer keyOp(k.stats, k.breaker, "sign", k.Container, time.Now(), &err)
+	if err := k.breaker.allow(); err != nil {
+		return nil, err
+	}
+	if err := k.quota.allow("sign"); err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		return nil, &ArgError{Op: "Sign", Arg: "opts", Reason: "opts is nil"}
+	}
 	hf := opts.HashFunc()
-	algID, ok := algIDs[hf]
-	if !ok {
-		return nil, fmt.Errorf("unsupported hash algorithm %v", hf)
+	algID, err := algIDFor(hf)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDigest("Sign", digest, hf); err != nil {
+		return nil, err
 	}
 
-	return signHashPkcs1Padding(k.handle, digest, algID)
+	if pssOpts, ok := opts.(*rsa.PSSOptions); ok {
+		saltLen, err := pssSaltLength(k.pub, digest, pssOpts)
+		if err != nil {
+			return nil, err
+		}
+		sig, err := signHashPSSPadding(k.handle, digest, algID, saltLen, 0)
+		if err != nil {
+			return nil, err
+		}
+		return checkSignature(k.verify, k.Container, k.pub, digest, opts, sig)
+	}
+	sig, err := signHashPkcs1Padding(k.handle, digest, algID, 0)
+	if err != nil {
+		return nil, err
+	}
+	return checkSignature(k.verify, k.Container, k.pub, digest, opts, sig)
 }
 
-func (k *EcdsaKey) Sign(rand io.Reader, digest []byte, _ crypto.SignerOpts) ([]byte, error) {
-	return signHashNoPadding(k.handle, digest)
+func (k *EcdsaKey) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) (_ []byte, err error) {
+	defer keyOp(k.stats, k.breaker, "sign", k.Container, time.Now(), &err)
+	if err := k.breaker.allow(); err != nil {
+		return nil, err
+	}
+	if err := k.quota.allow("sign"); err != nil {
+		return nil, err
+	}
+	var hf crypto.Hash
+	if opts != nil {
+		hf = opts.HashFunc()
+	}
+	if err := checkDigest("Sign", digest, hf); err != nil {
+		return nil, err
+	}
+	sig, err := signHashNoPadding(k.handle, digest, 0)
+	if err != nil {
+		return nil, err
+	}
+	return checkSignature(k.verify, k.Container, k.pub, digest, opts, sig)
 }
 
-func (k *RsaKey) SignRaw(digest []byte) ([]byte, error) {
-	return signHashNoPadding(k.handle, digest)
+// SignMessage hashes the message read from r with hash and signs the digest.
+func (k *RsaKey) SignMessage(r io.Reader, hash crypto.Hash) ([]byte, error) {
+	return SignMessage(k, r, hash)
 }
 
-func (k *EcdsaKey) SignRaw(digest []byte) ([]byte, error) {
-	return signHashNoPadding(k.handle, digest)
+func (k *EcdsaKey) SignMessage(r io.Reader, hash crypto.Hash) ([]byte, error) {
+	return SignMessage(k, r, hash)
 }
 
-func signHashNoPadding(kh uintptr, digest []byte) ([]byte, error) {
-	var size uint32
-	// Obtain the size of the signature
-  r, _, err := nCryptSignHash.Call(
-		kh,
-		uintptr(0),
-		uintptr(unsafe.Pointer(&digest[0])),
-		uintptr(len(digest)),
-		0,
-		0,
-		uintptr(unsafe.Pointer(&size)),
-		0)
-	if r != 0 {
-		return nil, fmt.Errorf("NCryptSignHash returned %X during size check: %v", r, err)
+func (k *RsaKey) SignRaw(digest []byte) (_ []byte, err error) {
+	defer keyOp(k.stats, k.breaker, "signraw", k.Container, time.Now(), &err)
+	if err := k.breaker.allow(); err != nil {
+		return nil, err
+	}
+	if err := k.quota.allow("signraw"); err != nil {
+		return nil, err
+	}
+	if err := checkDigest("SignRaw", digest, 0); err != nil {
+		return nil, err
 	}
+	return signHashNoPadding(k.handle, digest, 0)
+}
 
-	// Obtain the signature data
-	sig := make([]byte, size)
-	r, _, err = nCryptSignHash.Call(
-		kh,
-		uintptr(0),
-		uintptr(unsafe.Pointer(&digest[0])),
-		uintptr(len(digest)),
-		uintptr(unsafe.Pointer(&sig[0])),
-		uintptr(size),
-		uintptr(unsafe.Pointer(&size)),
-		0)
-	if r != 0 {
-		return nil, fmt.Errorf("NCryptSignHash returned %X during signing: %v", r, err)
+func (k *EcdsaKey) SignRaw(digest []byte) (_ []byte, err error) {
+	defer keyOp(k.stats, k.breaker, "signraw", k.Container, time.Now(), &err)
+	if err := k.breaker.allow(); err != nil {
+		return nil, err
+	}
+	if err := k.quota.allow("signraw"); err != nil {
+		return nil, err
+	}
+	if err := checkDigest("SignRaw", digest, 0); err != nil {
+		return nil, err
 	}
+	return signHashNoPadding(k.handle, digest, 0)
+}
 
-	return sig[:size], nil
+// TestSign signs a throwaway digest with the private key and verifies the
+// signature against the public key. It allows health checks to tell a usable
+// key apart from one that is installed but broken, for example because the TPM
+// was cleared, the key ACL is wrong or the key isolation service is down.
+// If silent is set, the provider is not allowed to display any UI.
+func (k *RsaKey) TestSign(silent bool) (err error) {
+	defer keyOp(k.stats, k.breaker, "testsign", k.Container, time.Now(), &err)
+	if err := k.breaker.allow(); err != nil {
+		return err
+	}
+	digest, err := testDigest()
+	if err != nil {
+		return err
+	}
+	sig, err := signHashPkcs1Padding(k.handle, digest, sha256AlgID, testSignFlags(silent))
+	if err != nil {
+		return fmt.Errorf("test signature failed: %w", err)
+	}
+	if err := rsa.VerifyPKCS1v15(k.pub, crypto.SHA256, digest, sig); err != nil {
+		return fmt.Errorf("test signature did not verify: %v", err)
+	}
+	return nil
 }
 
-func signHashPkcs1Padding(kh uintptr, digest []byte, algID *uint16) ([]byte, error) {
+func (k *EcdsaKey) TestSign(silent bool) (err error) {
+	defer keyOp(k.stats, k.breaker, "testsign", k.Container, time.Now(), &err)
+	if err := k.breaker.allow(); err != nil {
+		return err
+	}
+	digest, err := testDigest()
+	if err != nil {
+		return err
+	}
+	sig, err := signHashNoPadding(k.handle, digest, testSignFlags(silent))
+	if err != nil {
+		return fmt.Errorf("test signature failed: %w", err)
+	}
+	if !verifyECDSARaw(k.pub, digest, sig) {
+		return errors.New("test signature did not verify")
+	}
+	return nil
+}
+
+// testDigest returns a random SHA256 sized digest for TestSign.
+func testDigest() ([]byte, error) {
+	digest := make([]byte, crypto.SHA256.Size())
+	if _, err := rand.Read(digest); err != nil {
+		return nil, fmt.Errorf("could not generate test digest: %v", err)
+	}
+	return digest, nil
+}
+
+func testSignFlags(silent bool) uintptr {
+	if silent {
+		return ncryptSilentFlag
+	}
+	return 0
+}
+
+func signHashNoPadding(kh uintptr, digest []byte, flags uintptr) ([]byte, error) {
+	return signHash(kh, nil, digest, flags)
+}
+
+func signHashPkcs1Padding(kh uintptr, digest []byte, algID *uint16, flags uintptr) ([]byte, error) {
 	padInfo := paddingInfo{pszAlgID: algID}
+	return signHash(kh, unsafe.Pointer(&padInfo), digest, bCryptPadPKCS1|flags)
+}
+
+func signHashPSSPadding(kh uintptr, digest []byte, algID *uint16, saltLen int, flags uintptr) ([]byte, error) {
+	padInfo := pssPaddingInfo{pszAlgID: algID, cbSalt: uint32(saltLen)}
+	return signHash(kh, unsafe.Pointer(&padInfo), digest, bCryptPadPSS|flags)
+}
+
+// signHash wraps NCryptSignHash. padInfo points to the padding info struct
+// matching the padding flag in flags, or is nil if no padding is used.
+func signHash(kh uintptr, padInfo unsafe.Pointer, digest []byte, flags uintptr) ([]byte, error) {
 	var size uint32
 	// Obtain the size of the signature
 	r, _, err := nCryptSignHash.Call(
 		kh,
-		uintptr(unsafe.Pointer(&padInfo)),
+		uintptr(padInfo),
 		uintptr(unsafe.Pointer(&digest[0])),
 		uintptr(len(digest)),
 		0,
 		0,
 		uintptr(unsafe.Pointer(&size)),
-		bCryptPadPKCS1)
+		flags)
 	if r != 0 {
-		return nil, fmt.Errorf("NCryptSignHash returned %X during size check: %v", r, err)
+		return nil, ncryptErr("NCryptSignHash", r, "during size check", err)
 	}
 
 	// Obtain the signature data
 	sig := make([]byte, size)
 	r, _, err = nCryptSignHash.Call(
 		kh,
-		uintptr(unsafe.Pointer(&padInfo)),
+		uintptr(padInfo),
 		uintptr(unsafe.Pointer(&digest[0])),
 		uintptr(len(digest)),
 		uintptr(unsafe.Pointer(&sig[0])),
 		uintptr(size),
 		uintptr(unsafe.Pointer(&size)),
-		bCryptPadPKCS1)
+		flags)
 	if r != 0 {
-		return nil, fmt.Errorf("NCryptSignHash returned %X during signing: %v", r, err)
+		return nil, ncryptErr("NCryptSignHash", r, "during signing", err)
 	}
 
 	return sig[:size], nil
@@ -576,58 +1279,82 @@ type DecrypterOpts struct {
 	Flags uint32
 }
 
-// oaepPaddingInfo is the BCRYPT_OAEP_PADDING_INFO struct in bcrypt.h.
-// https://msdn.microsoft.com/en-us/library/windows/desktop/aa375526(v=vs.85).aspx
-type oaepPaddingInfo struct {
-	pszAlgID *uint16 // pszAlgId
-	pbLabel  *uint16 // pbLabel
-	cbLabel  uint32  // cbLabel
-}
-
 // Decrypt returns the decrypted contents of the encrypted blob, and implements
-// crypto.Decrypter for Key.
-func (k *RsaKey) Decrypt(rand io.Reader, blob []byte, opts crypto.DecrypterOpts) ([]byte, error) {
-	decrypterOpts, ok := opts.(DecrypterOpts)
-	if !ok {
-		return nil, errors.New("opts was not certtostore.DecrypterOpts")
+// crypto.Decrypter for Key. opts is a DecrypterOpts, a *rsa.OAEPOptions for
+// OAEP padding, or nil or a *rsa.PKCS1v15DecryptOptions for PKCS #1 v1.5
+// padding, like the keys of the other stores accept.
+func (k *RsaKey) Decrypt(rand io.Reader, blob []byte, opts crypto.DecrypterOpts) (_ []byte, err error) {
+	defer keyOp(k.stats, k.breaker, "decrypt", k.Container, time.Now(), &err)
+	if err := k.breaker.allow(); err != nil {
+		return nil, err
 	}
-
-	algID, ok := algIDs[decrypterOpts.Hashfunc]
-	if !ok {
-		return nil, fmt.Errorf("unsupported hash algorithm %v", decrypterOpts.Hashfunc)
+	if err := k.quota.allow("decrypt"); err != nil {
+		return nil, err
 	}
-
-	padding := oaepPaddingInfo{
-		pszAlgID: algID,
-		pbLabel:  wide(""),
-		cbLabel:  0,
+	if err := checkNotEmpty("Decrypt", "blob", blob); err != nil {
+		return nil, err
 	}
 
-	return rsaDecrypt(k.handle, blob, padding, decrypterOpts.Flags)
+	var padding *oaepPaddingInfo
+	var flags uint32
+	switch opts := opts.(type) {
+	case DecrypterOpts:
+		algID, err := algIDFor(opts.Hashfunc)
+		if err != nil {
+			return nil, err
+		}
+		padding = &oaepPaddingInfo{
+			pszAlgID: algID,
+			pbLabel:  wide(""),
+			cbLabel:  0,
+		}
+		flags = opts.Flags
+	case *rsa.OAEPOptions:
+		if opts.MGFHash != 0 && opts.MGFHash != opts.Hash {
+			return nil, fmt.Errorf("MGF1 hash %v differs from the OAEP hash %v", opts.MGFHash, opts.Hash)
+		}
+		algID, err := algIDFor(opts.Hash)
+		if err != nil {
+			return nil, err
+		}
+		padding = &oaepPaddingInfo{pszAlgID: algID}
+		if len(opts.Label) > 0 {
+			padding.pbLabel = (*uint16)(unsafe.Pointer(&opts.Label[0]))
+			padding.cbLabel = uint32(len(opts.Label))
+		}
+		flags = NCryptPadOAEPFlag
+	case nil, *rsa.PKCS1v15DecryptOptions:
+		flags = ncryptPadPKCS1Flag
+	default:
+		return nil, fmt.Errorf("unsupported decrypter options %T", opts)
+	}
+	return rsaDecrypt(k.handle, blob, padding, flags)
 }
 
-// func (k *EcdsaKey) Decrypt(rand io.Reader, blob []byte, opts crypto.DecrypterOpts) ([]byte, error) {
-// 	return nil, fmt.Errorf("ECDSA does not support decryption")
-// }
+// Decrypt returns ErrNotSupported, since ECDSA and ECDH keys cannot decrypt.
+// It is implemented so that EcdsaKey satisfies crypto.Decrypter like RsaKey.
+func (k *EcdsaKey) Decrypt(rand io.Reader, blob []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	return nil, ErrNotSupported
+}
 
-// decrypt wraps the NCryptDecrypt function and returns the decrypted bytes
+// rsaDecrypt wraps the NCryptDecrypt function and returns the decrypted bytes
 // that were previously encrypted by NCryptEncrypt or another compatible
-// function such as rsa.EncryptOAEP.
+// function such as rsa.EncryptOAEP. padding is nil unless flags selects OAEP.
 // https://msdn.microsoft.com/en-us/library/windows/desktop/aa376249(v=vs.85).aspx
-func rsaDecrypt(kh uintptr, blob []byte, padding oaepPaddingInfo, flags uint32) ([]byte, error) {
+func rsaDecrypt(kh uintptr, blob []byte, padding *oaepPaddingInfo, flags uint32) ([]byte, error) {
 	var size uint32
 	// Obtain the size of the decrypted data
 	r, _, err := nCryptDecrypt.Call(
 		kh,                                // hKey
 		uintptr(unsafe.Pointer(&blob[0])), // pbInput
 		uintptr(len(blob)),                // cbInput
-		uintptr(unsafe.Pointer(&padding)), // *pPaddingInfo
+		uintptr(unsafe.Pointer(padding)),  // *pPaddingInfo
 		0,                                 // pbOutput, must be null on first run
 		0,                                 // cbOutput, ignored on first run
 		uintptr(unsafe.Pointer(&size)),    // pcbResult
 		uintptr(flags))
 	if r != 0 {
-		return nil, fmt.Errorf("NCryptDecrypt returned %X during size check: %v", r, err)
+		return nil, ncryptErr("NCryptDecrypt", r, "during size check", err)
 	}
 
 	// Decrypt the message
@@ -636,15 +1363,17 @@ func rsaDecrypt(kh uintptr, blob []byte, padding oaepPaddingInfo, flags uint32)
 		kh,                                     // hKey
 		uintptr(unsafe.Pointer(&blob[0])),      // pbInput
 		uintptr(len(blob)),                     // cbInput
-		uintptr(unsafe.Pointer(&padding)),      // *pPaddingInfo
+		uintptr(unsafe.Pointer(padding)),       // *pPaddingInfo
 		uintptr(unsafe.Pointer(&plainText[0])), // pbOutput, must be null on first run
 		uintptr(size),                          // cbOutput, ignored on first run
 		uintptr(unsafe.Pointer(&size)),         // pcbResult
 		uintptr(flags))
 	if r != 0 {
-		return nil, fmt.Errorf("NCryptDecrypt returned %X during decryption: %v", r, err)
+		return nil, ncryptErr("NCryptDecrypt", r, "during decryption", err)
 	}
 
+	// The size check returns an upper bound, wipe what was not used.
+	wipe(plainText[size:])
 	return plainText[:size], nil
 }
 
@@ -662,7 +1391,7 @@ func (k *RsaKey) SetACL(store *WinCertStore, access string, sid string, perm str
 
 func setAcl(store *WinCertStore, access, sid, perm, loc string) error {
 	// loc := k.Container
-	logger.Infof("running: icacls.exe %s /%s %s:%s", loc, access, sid, perm)
+	logInfo("Running icacls.exe.", opField("setacl"), containerField(loc), field("access", access), field("sid", sid), field("perm", perm))
 
 	// Run icacls as specified, parameter validation prior to this point isn't
 	// needed because icacls handles this on its own
@@ -672,7 +1401,7 @@ func setAcl(store *WinCertStore, access, sid, perm, loc string) error {
 	// for a non-existend sid, which only happens for certain permissions needed on later
 	// versions of Windows, which are not needed on Windows 7.
 	if err, ok := err.(*exec.ExitError); ok && strings.Contains(err.Error(), "1798") == false {
-		logger.Infof("ignoring error while %sing '%s' access to %s for sid: %v", access, perm, loc, sid)
+		logInfo("Ignoring icacls.exe error.", opField("setacl"), containerField(loc), field("access", access), field("sid", sid), field("perm", perm), errField(err))
 		return nil
 	} else if err != nil {
 		return fmt.Errorf("certstorage.SetFileACL is unable to %s %s access on %s to sid %s, %v", access, perm, loc, sid, err)
@@ -682,17 +1411,65 @@ func setAcl(store *WinCertStore, access, sid, perm, loc string) error {
 }
 
 // Key opens a handle to an existing private key and returns key.
-// Key implements both crypto.Signer and crypto.Decrypter
-func (w *WinCertStore) Key() (Key, error) {
-	var kh uintptr
-	r, _, err := nCryptOpenKey.Call(
-		uintptr(w.Prov),
-		uintptr(unsafe.Pointer(&kh)),
-		uintptr(unsafe.Pointer(wide(w.container))),
-		0,
-		0)
-	if r != 0 {
-		return nil, fmt.Errorf("NCryptOpenKey for container %s returned %X: %v", w.container, r, err)
+// Key implements both crypto.Signer and crypto.Decrypter. Once the
+// certificate of a key generated by RotateIfOlderThan is stored, Key returns
+// that key instead of the one in the container of w.
+func (w *WinCertStore) Key() (_ Key, err error) {
+	defer logKeyOp("openkey", w.container, time.Now(), &err)
+	if err := checkContainer("Key", w.container); err != nil {
+		return nil, err
+	}
+	return w.containerKey(w.activeContainer())
+}
+
+// activeContainer returns the container of the current key of w. It is the
+// rotation container of w if the current certificate uses its key, and the
+// container of w otherwise.
+func (w *WinCertStore) activeContainer() string {
+	_, nc, err := w.certContext(w.issuerList(), my, certStoreLocalMachine)
+	if err != nil || nc == nil {
+		return w.container
+	}
+	defer windows.CertFreeCertificateContext(nc)
+	ki, err := keyProvInfo(nc)
+	if err != nil || ki == nil || ki.Provider != w.ProvName {
+		return w.container
+	}
+	if next := RotationContainerName(w.container); ki.Container == next {
+		return next
+	}
+	return w.container
+}
+
+// Signer returns the key of the store's container as a crypto.Signer, see Key.
+func (w *WinCertStore) Signer() (crypto.Signer, error) {
+	k, err := w.Key()
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// keyOpenFlags returns the flags the keys of w are opened with.
+func (w *WinCertStore) keyOpenFlags() uint32 {
+	flags := w.rawFlags.get(FlagOpOpenKey)
+	if w.machineKeys.Load() {
+		flags |= nCryptMachineKey
+	}
+	return flags
+}
+
+// containerKey opens the key in container of the provider of w.
+func (w *WinCertStore) containerKey(container string) (Key, error) {
+	return w.openContainerKey(container, w.keyOpenFlags())
+}
+
+// openContainerKey opens the key in container of the provider of w with
+// flags, which the key also uses to open the container again.
+func (w *WinCertStore) openContainerKey(container string, flags uint32) (Key, error) {
+	kh, err := openKey(w.Prov, container, flags)
+	if err != nil {
+		return nil, err
 	}
 
 	keyAlgType, err := getKeyType(kh)
@@ -703,18 +1480,18 @@ func (w *WinCertStore) Key() (Key, error) {
 	// See https://docs.microsoft.com/en-us/windows/win32/seccng/key-storage-property-identifiers for algorithm types
 	switch keyAlgType {
 	case "RSA":
-		uc, pub, err := rsaKeyMetadata(kh, w)
+		loc, pub, err := rsaKeyMetadata(kh, w, container)
 		if err != nil {
 			return nil, err
 		}
 
-		return &RsaKey{handle: kh, pub: pub, Container: uc}, nil
-	case "ECDSA":
-		uc, pub, err := ecdsaKeyMetadata(kh, w)
+		return &RsaKey{handle: kh, pub: pub, Container: loc.container(), location: loc, allowExport: w.allowPrivateExport, prov: w.Prov, name: container, openFlags: flags, stats: newKeyStats(), breaker: w.breaker, quota: w.quotas.forKey(loc.container()), verify: w.signatureCheck.enabled()}, nil
+	case "ECDSA", "ECDH":
+		loc, pub, err := ecdsaKeyMetadata(kh, w, container)
 		if err != nil {
 			return nil, err
 		}
-		return &EcdsaKey{handle: kh, pub: pub, Container: uc}, nil
+		return &EcdsaKey{handle: kh, pub: pub, Container: loc.container(), location: loc, allowExport: w.allowPrivateExport, prov: w.Prov, name: container, openFlags: flags, stats: newKeyStats(), breaker: w.breaker, quota: w.quotas.forKey(loc.container()), verify: w.signatureCheck.enabled()}, nil
 	default:
 		return nil, fmt.Errorf("Unsupported key algorithm: %s", keyAlgType)
 	}
@@ -726,8 +1503,10 @@ func (k *EcdsaKey) Delete() error {
 		0,
 	)
 	if r != 0 {
-		return fmt.Errorf("NCryptDeleteKey returned %X: %v", r, err)
+		return ncryptErr("NCryptDeleteKey", r, "", err)
 	}
+	// NCryptDeleteKey frees the handle.
+	k.handle = 0
 	return nil
 }
 
@@ -737,87 +1516,165 @@ func (k *RsaKey) Delete() error {
 		0,
 	)
 	if r != 0 {
-		return fmt.Errorf("NCryptDeleteKey returned %X: %v", r, err)
+		return ncryptErr("NCryptDeleteKey", r, "", err)
 	}
+	// NCryptDeleteKey frees the handle.
+	k.handle = 0
 	return nil
 }
 
 // Generate returns a crypto.Signer representing either a TPM-backed or
 // software backed key, depending on support from the host OS
 // key size is set to the maximum supported by Microsoft Software Key Storage Provider
+// ECDH_P256, ECDH_P384 and ECDH_P521 keys are created for key agreement only,
+// and the returned signer's Sign method will fail for them.
+// Concurrent calls for the same container are serialized across processes.
 func (w *WinCertStore) Generate(keySize int, alg string) (crypto.Signer, error) {
-	logger.Infof("Provider: %s", w.ProvName)
-	var algId string
-	switch alg {
-	case "RSA":
-		algId = "RSA"
-		// The MPCP only supports a max keywidth of 2048, due to the TPM specification.
-		// https://www.microsoft.com/en-us/download/details.aspx?id=52487
-		// The Microsoft Software Key Storage Provider supports a max keywidth of 16384.
-		if keySize > 16384 {
-			return nil, fmt.Errorf("unsupported keysize, got: %d, want: < %d", keySize, 16384)
+	signer, _, err := w.GenerateWithResult(keySize, alg)
+	return signer, err
+}
+
+// GenerateWithResult is like Generate, but also returns a Result describing what changed.
+func (w *WinCertStore) GenerateWithResult(keySize int, alg string) (crypto.Signer, *Result, error) {
+	res := &Result{Operation: "generate", Container: w.container}
+	signer, err := w.generate(GenerateOpts{Algorithm: alg, KeySize: keySize})
+	if err == nil {
+		res.addAuditedChange(ActionGenerated, "", w.ProvName)
+	}
+	return signer, res, err
+}
+
+// GenerateWithOpts is like Generate, but allows selecting the algorithm or
+// curve, the exact CNG algorithm, the RSA public exponent, the lifetime, the
+// usage and the export policy of the key, whether it is a machine key and
+// whether an existing key is replaced, see GenerateOpts. Ephemeral and
+// per-boot keys are only supported by the software key storage provider.
+func (w *WinCertStore) GenerateWithOpts(opts GenerateOpts) (crypto.Signer, error) {
+	return w.generate(opts)
+}
+
+func (w *WinCertStore) generate(opts GenerateOpts) (_ crypto.Signer, err error) {
+	logInfo("Generating key.", opField("generate"), containerField(w.container), field("provider", w.ProvName), field("algorithm", opts.Algorithm), field("cngalgorithm", opts.CNGAlgorithm), field("keysize", opts.KeySize), field("machine", opts.Machine))
+	defer logKeyOp("generate", w.container, time.Now(), &err)
+
+	algId, keySize, err := keyParams(opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Lifetime != KeyPersisted && w.ProvName != ProviderMSSoftware {
+		return nil, fmt.Errorf("%v keys are not supported by provider %q", opts.Lifetime, w.ProvName)
+	}
+	if opts.Lifetime != KeyEphemeral {
+		if err := checkContainer("Generate", w.container); err != nil {
+			return nil, err
 		}
-	case "ECDSA_P256":
-		algId = "ECDSA_P256"
-		keySize = 256
-	case "ECDSA_P384":
-		algId = "ECDSA_P384"
-		keySize = 384
-	case "ECDSA_P521":
-		algId = "ECDSA_P521"
-		keySize = 521
-	default:
-		return nil, fmt.Errorf("unsupported algorithm: %s", alg)
 	}
 
+	// Ephemeral keys have no name, so they do not touch the container.
+	name := w.container
+	flags := uintptr(nCryptOverwriteKey)
+	if opts.NoOverwrite {
+		flags = 0
+	}
+	openFlags := w.keyOpenFlags()
+	if opts.Machine || w.machineKeys.Load() {
+		flags |= nCryptMachineKey
+		openFlags |= nCryptMachineKey
+	}
+	switch opts.Lifetime {
+	case KeyEphemeral:
+		name = ""
+		flags = 0
+	case KeyPerBoot:
+		flags |= ncryptUseVirtualIsolationFlag | ncryptUsePerBootKeyFlag
+	}
+	flags |= uintptr(w.rawFlags.get(FlagOpCreateKey))
+	unlock := func() {}
+	if name != "" {
+		if unlock, err = lockContainer(w.ProvName, name); err != nil {
+			return nil, err
+		}
+	}
+	// The container lock and the key handle are released on return, unless
+	// a finalization that timed out takes them over.
+	release := true
+	defer func() {
+		if release {
+			unlock()
+		}
+	}()
+	if keySize, err = w.validateKeyParams(algId, keySize); err != nil {
+		return nil, err
+	}
 
 	var kh uintptr
+	var namePtr uintptr
+	if name != "" {
+		namePtr = uintptr(unsafe.Pointer(wide(name)))
+	}
 	// Pass 0 as the fifth parameter because it is not used (legacy)
 	// https://msdn.microsoft.com/en-us/library/windows/desktop/aa376247(v=vs.85).aspx
 	r, _, err := nCryptCreatePersistedKey.Call(
 		uintptr(w.Prov),
 		uintptr(unsafe.Pointer(&kh)),
 		uintptr(unsafe.Pointer(wide(algId))),
-		uintptr(unsafe.Pointer(wide(w.container))),
+		namePtr,
 		0,
-		nCryptOverwriteKey)
+		flags)
+	if r == nteExists {
+		return nil, fmt.Errorf("container %q already holds a key and NoOverwrite is set", name)
+	}
 	if r != 0 {
-		return nil, fmt.Errorf("NCryptCreatePersistedKey returned %X: %v", r, err)
-	}
-
-	var usage uint32
-	if algId == "RSA" {
-		var length = uint32(keySize)
-		// Microsoft function calls return actionable return codes in r, err is often filled with text, even when successful
-		r, _, err = nCryptSetProperty.Call(
-			kh,
-			uintptr(unsafe.Pointer(wide("Length"))),
-			uintptr(unsafe.Pointer(&length)),
-			unsafe.Sizeof(length),
-			ncryptPersistFlag)
-		if r != 0 {
-			return nil, fmt.Errorf("NCryptSetProperty (Length) returned %X: %v", r, err)
+		return nil, ncryptErr("NCryptCreatePersistedKey", r, "", err)
+	}
+	defer func() {
+		if err != nil && release {
+			nCryptFreeObject.Call(kh)
+		}
+	}()
+
+	ku, err := keyUsage(algId, opts)
+	if err != nil {
+		return nil, err
+	}
+	usage := uint32(ku)
+	// A zero size uses the default length of the provider.
+	if algId == "RSA" && keySize != 0 {
+		if err := setPropertyUint32(kh, "Length", uint32(keySize), ncryptPersistFlag); err != nil {
+			return nil, err
 		}
-		usage = ncryptAllowDecryptFlag | ncryptAllowSigningFlag
-	} else {
-		usage = ncryptAllowSigningFlag
 	}
 
-	r, _, err = nCryptSetProperty.Call(
-		kh,
-		uintptr(unsafe.Pointer(wide("Key Usage"))),
-		uintptr(unsafe.Pointer(&usage)),
-		unsafe.Sizeof(usage),
-		ncryptPersistFlag)
-	if r != 0 {
-		return nil, fmt.Errorf("NCryptSetProperty (Key Usage) returned %X: %v", r, err)
+	if err := setPropertyUint32(kh, "Key Usage", usage, ncryptPersistFlag); err != nil {
+		return nil, err
 	}
 
-	// Set the second parameter to 0 because we require no flags
-	// https://msdn.microsoft.com/en-us/library/windows/desktop/aa376265(v=vs.85).aspx
-	r, _, err = nCryptFinalizeKey.Call(kh, 0)
-	if r != 0 {
-		return nil, fmt.Errorf("NCryptFinalizeKey returned %X: %v", r, err)
+	if opts.Export != 0 {
+		if err := setExportPolicy(kh, opts.Export); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.UseContext != "" {
+		if err := setUseContext(kh, opts.UseContext); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := w.finalizeKey(kh, name != "", unlock); err != nil {
+		if err == ErrGenerateTimeout {
+			release = false
+		}
+		return nil, err
+	}
+	if name != "" {
+		// Key and Duplicate must open the container in the same key store.
+		if opts.Machine {
+			w.machineKeys.Store(true)
+		}
+		// Every persisted key is audited, including those generated without
+		// a Result.
+		auditChange("generate", name, Change{Action: ActionGenerated, Store: w.ProvName})
 	}
 
 	keyAlgType, err := getKeyType(kh)
@@ -828,286 +1685,141 @@ func (w *WinCertStore) Generate(keySize int, alg string) (crypto.Signer, error)
 	// See https://docs.microsoft.com/en-us/windows/win32/seccng/key-storage-property-identifiers for algorithm types
 	switch keyAlgType {
 	case "RSA":
-		uc, pub, err := rsaKeyMetadata(kh, w)
+		var loc *KeyLocation
+		var pub *rsa.PublicKey
+		if name == "" {
+			pub, err = exportRSA(kh)
+		} else {
+			loc, pub, err = rsaKeyMetadata(kh, w, name)
+		}
 		if err != nil {
 			return nil, err
 		}
-
-		return &RsaKey{handle: kh, pub: pub, Container: uc}, nil
-	case "ECDSA":
-		uc, pub, err := ecdsaKeyMetadata(kh, w)
+		return &RsaKey{handle: kh, pub: pub, Container: loc.container(), location: loc, allowExport: w.allowPrivateExport, prov: w.Prov, name: name, openFlags: openFlags, stats: newKeyStats(), breaker: w.breaker, quota: w.quotas.forKey(loc.container()), verify: w.signatureCheck.enabled()}, nil
+	case "ECDSA", "ECDH":
+		var loc *KeyLocation
+		var pub *ecdsa.PublicKey
+		if name == "" {
+			pub, err = exportEcdsa(kh)
+		} else {
+			loc, pub, err = ecdsaKeyMetadata(kh, w, name)
+		}
 		if err != nil {
 			return nil, err
 		}
 
-		return &EcdsaKey{handle: kh, pub: pub, Container: uc}, nil
+		return &EcdsaKey{handle: kh, pub: pub, Container: loc.container(), location: loc, allowExport: w.allowPrivateExport, prov: w.Prov, name: name, openFlags: openFlags, stats: newKeyStats(), breaker: w.breaker, quota: w.quotas.forKey(loc.container()), verify: w.signatureCheck.enabled()}, nil
 	default:
 		return nil, fmt.Errorf("Unsupported key algorithm: %s", keyAlgType)
 	}
 }
 
-func getKeyType(kh uintptr) (string, error) {
-	var strSize uint32
-	r, _, err := nCryptGetProperty.Call(
-		kh,
-		uintptr(unsafe.Pointer(wide("Algorithm Group"))),
-		0,
-		0,
-		uintptr(unsafe.Pointer(&strSize)),
-		0,
-		0)
-	if r != 0 {
-		return "", fmt.Errorf("NCryptGetProperty returned %X during size check, %v", r, err)
-	}
+// finalizeKey finalizes the key while reporting progress and enforcing the
+// generate timeout of w. If the timeout passes, finalization continues in the
+// background, which takes over the key handle and unlock. Once it is done a
+// persisted key that completed late is deleted, since the caller was told
+// that generation failed, and only then is the container unlocked.
+func (w *WinCertStore) finalizeKey(kh uintptr, persisted bool, unlock func()) error {
+	done := make(chan error)
+	timedOut := make(chan struct{})
+	go func() {
+		// Set the second parameter to 0 because we require no flags
+		// https://msdn.microsoft.com/en-us/library/windows/desktop/aa376265(v=vs.85).aspx
+		var result error
+		r, _, err := nCryptFinalizeKey.Call(kh, uintptr(w.rawFlags.get(FlagOpFinalizeKey)))
+		if r != 0 {
+			result = ncryptErr("NCryptFinalizeKey", r, "", err)
+		}
+		// done is unbuffered, so exactly one of the cases happens.
+		select {
+		case done <- result:
+		case <-timedOut:
+			if result == nil && persisted {
+				// NCryptDeleteKey also frees the handle.
+				if r, _, err := nCryptDeleteKey.Call(kh, 0); r != 0 {
+					logWarning("Could not delete key finalized after the generate timeout.", opField("generate"), containerField(w.container), errField(ncryptErr("NCryptDeleteKey", r, "", err)))
+				}
+			} else {
+				nCryptFreeObject.Call(kh)
+			}
+			unlock()
+		}
+	}()
 
-	buf := make([]byte, strSize)
-	r, _, err = nCryptGetProperty.Call(
-		kh,
-		uintptr(unsafe.Pointer(wide("Algorithm Group"))),
-		uintptr(unsafe.Pointer(&buf[0])),
-		uintptr(strSize),
-		uintptr(unsafe.Pointer(&strSize)),
-		0,
-		0)
-	if r != 0 {
-		return "", fmt.Errorf("NCryptGetProperty returned %X during export, %v", r, err)
+	err := waitProgress(done, w.generateTimeout, progressInterval, func(elapsed time.Duration) {
+		logInfo("Waiting for the provider to generate the key.", opField("generate"), containerField(w.container), durationField(elapsed))
+		if w.generateProgress != nil {
+			w.generateProgress(elapsed)
+		}
+	})
+	if err == ErrGenerateTimeout {
+		close(timedOut)
 	}
-
-	algGroup := strings.Replace(string(buf), string(0x00), "", -1)
-	return algGroup, nil
+	return err
 }
 
-func rsaKeyMetadata(kh uintptr, store *WinCertStore) (string, *rsa.PublicKey, error) {
-	// uc is used to populate the container attribute of the private key
-	uc, err := container(kh)
+func rsaKeyMetadata(kh uintptr, store *WinCertStore, name string) (*KeyLocation, *rsa.PublicKey, error) {
+	loc, err := keyLocation(kh, store.ProvName, name)
 	if err != nil {
-		return "", nil, err
-	}
-
-	// Adjust the key storage location if we have a software backed key
-	if store.ProvName == ProviderMSSoftware {
-		uc = os.Getenv("ProgramData") + `\Microsoft\Crypto\Keys\` + uc
+		return nil, nil, err
 	}
 
 	pub, err := exportRSA(kh)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to export public key: %v", err)
+		return nil, nil, fmt.Errorf("failed to export public key: %v", err)
 	}
 
-	return uc, pub, nil
+	return loc, pub, nil
 }
 
-func ecdsaKeyMetadata(kh uintptr, store *WinCertStore) (string, *ecdsa.PublicKey, error) {
-  // uc is used to populate the container attribute of the private key
-  uc, err := container(kh)
-  if err != nil {
-    return "", nil, err
-  }
-
-	// Adjust the key storage location if we have a software backed key
-	if store.ProvName == ProviderMSSoftware {
-		uc = os.Getenv("ProgramData") + `\Microsoft\Crypto\Keys\` + uc
-	}
-
-  pub, err := exportEcdsa(kh)
+func ecdsaKeyMetadata(kh uintptr, store *WinCertStore, name string) (*KeyLocation, *ecdsa.PublicKey, error) {
+	loc, err := keyLocation(kh, store.ProvName, name)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to export public key: %v", err)
-	}
-  return uc, pub, nil
-}
-
-func exportEcdsa(kh uintptr) (*ecdsa.PublicKey, error) {
-  var size uint32
-  r, _, err := nCryptExportKey.Call(
-    kh,
-    0,
-    uintptr(unsafe.Pointer(bCryptECCPublicBlob)),
-    0,
-    0,
-    0,
-    uintptr(unsafe.Pointer(&size)),
-    0)
-  if r != 0 {
-    return nil, fmt.Errorf("NCryptExportKey returned %X during size check: %s", r, err)
-  }
-
-  buf := make([]byte, size)
-  r, _, err = nCryptExportKey.Call(
-    kh,
-    0,
-    uintptr(unsafe.Pointer(bCryptECCPublicBlob)),
-    0,
-    uintptr(unsafe.Pointer(&buf[0])),
-    uintptr(size),
-    uintptr(unsafe.Pointer(&size)),
-    0)
-  if r != 0 {
-    return nil, fmt.Errorf("NCryptExportKey returned %X during export: %v", r, err)
-  }
-
-  return unmarshalEcdsa(buf, kh)
-}
-
-func unmarshalEcdsa(buf []byte, kh uintptr) (*ecdsa.PublicKey, error) {
-	// BCRYPT_RSA_BLOB from bcrypt.h
-	header := struct {
-		Magic uint32
-		CBKey uint32
-	}{}
-
-	r := bytes.NewReader(buf)
-	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	var curve elliptic.Curve
-	switch header.Magic {
-	case ecdsaP256Magic:
-		curve = elliptic.P256()
-	case ecdsaP384Magic:
-		curve = elliptic.P384()
-	case ecdsaP521Magic:
-		curve = elliptic.P521()
-	default:
-		return nil, fmt.Errorf("Unsupported ECDSA header magic %x", header.Magic)
-	}
-
-	x := make([]byte, header.CBKey)
-  // 8 bytes is the length of the header, as it
-  n, err := r.Read(x)
-  if err != nil {
-    return nil, fmt.Errorf("Failed to read curve point x: %s", err)
-  }
-  if n != int(header.CBKey) {
-    return nil, fmt.Errorf("Failed to read in %d bytes for the curve point x. Actually read %d bytes", int(header.CBKey), n)
-  }
-
-  y := make([]byte, header.CBKey)
-	n, err = r.Read(y)
-  if err != nil {
-    return nil, fmt.Errorf("Failed to read curve point y: %s", err)
-  }
-  if n != int(header.CBKey) {
-    return nil, fmt.Errorf("Failed to read in %d bytes for the curve point y. Actually read %d bytes", int(header.CBKey), n)
-  }
-
-	pub := &ecdsa.PublicKey{
-    Curve: curve,
-    X: new(big.Int).SetBytes(x),
-    Y: new(big.Int).SetBytes(y),
-	}
-	return pub, nil
-}
-
-// container returns the unique container name of a private key
-func container(kh uintptr) (string, error) {
-	var strSize uint32
-	r, _, err := nCryptGetProperty.Call(
-		kh,
-		uintptr(unsafe.Pointer(wide("Unique Name"))),
-		0,
-		0,
-		uintptr(unsafe.Pointer(&strSize)),
-		0,
-		0)
-	if r != 0 {
-		return "", fmt.Errorf("NCryptGetProperty returned %X during size check, %v", r, err)
-	}
-
-	buf := make([]byte, strSize)
-	r, _, err = nCryptGetProperty.Call(
-		kh,
-		uintptr(unsafe.Pointer(wide("Unique Name"))),
-		uintptr(unsafe.Pointer(&buf[0])),
-		uintptr(strSize),
-		uintptr(unsafe.Pointer(&strSize)),
-		0,
-		0)
-	if r != 0 {
-		return "", fmt.Errorf("NCryptGetProperty returned %X during export, %v", r, err)
+	pub, err := exportEcdsa(kh)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to export public key: %v", err)
 	}
-
-	uc := strings.Replace(string(buf), string(0x00), "", -1)
-	return uc, nil
+	return loc, pub, nil
 }
 
-func exportRSA(kh uintptr) (*rsa.PublicKey, error) {
-	var size uint32
-	// When obtaining the size of a public key, most parameters are not required
-	r, _, err := nCryptExportKey.Call(
-		kh,
-		0,
-		uintptr(unsafe.Pointer(bCryptRSAPublicBlob)),
-		0,
-		0,
-		0,
-		uintptr(unsafe.Pointer(&size)),
-		0)
-	if r != 0 {
-		return nil, fmt.Errorf("NCryptExportKey returned %X during size check: %v", r, err)
-	}
-
-	// Place the exported key in buf now that we know the size required
-	buf := make([]byte, size)
-	r, _, err = nCryptExportKey.Call(
-		kh,
-		0,
-		uintptr(unsafe.Pointer(bCryptRSAPublicBlob)),
-		0,
-		uintptr(unsafe.Pointer(&buf[0])),
-		uintptr(size),
-		uintptr(unsafe.Pointer(&size)),
-		0)
-	if r != 0 {
-		return nil, fmt.Errorf("NCryptExportKey returned %X during export: %v", r, err)
-	}
-
-	return unmarshalRSA(buf)
+// Store imports certificates into the Windows certificate store
+func (w *WinCertStore) Store(cert *x509.Certificate, intermediate *x509.Certificate) error {
+	_, err := w.StoreWithResult(cert, intermediate)
+	return err
 }
 
-func unmarshalRSA(buf []byte) (*rsa.PublicKey, error) {
-	// BCRYPT_RSA_BLOB from bcrypt.h
-	header := struct {
-		Magic         uint32
-		BitLength     uint32
-		PublicExpSize uint32
-		ModulusSize   uint32
-		UnusedPrime1  uint32
-		UnusedPrime2  uint32
-	}{}
-
-	r := bytes.NewReader(buf)
-	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
-		return nil, err
+// StoreWithResult is like Store, but also returns a Result describing what changed.
+func (w *WinCertStore) StoreWithResult(cert *x509.Certificate, intermediate *x509.Certificate) (*Result, error) {
+	res := &Result{Operation: "store", Container: w.container}
+	if err := w.store(cert, intermediate, res); err != nil {
+		return res, err
 	}
-
-	if header.Magic != rsa1Magic {
-		return nil, fmt.Errorf("invalid header magic %x", header.Magic)
-	}
-
-	if header.PublicExpSize > 8 {
-		return nil, fmt.Errorf("unsupported public exponent size (%d bits)", header.PublicExpSize*8)
+	if w.linkOnStore {
+		if err := w.link(res); err != nil {
+			return res, fmt.Errorf("store: the certificate was stored, but %v", err)
+		}
+		if err := w.linkIntermediate(intermediate, res); err != nil {
+			return res, fmt.Errorf("store: the certificate was stored, but %v", err)
+		}
 	}
-
-	exp := make([]byte, 8)
-	if n, err := r.Read(exp[8-header.PublicExpSize:]); n != int(header.PublicExpSize) || err != nil {
-		return nil, fmt.Errorf("failed to read public exponent (%d, %v)", n, err)
+	// Other processes sharing the container pick up the new certificate.
+	if err := w.AnnounceRotation(); err != nil {
+		res.warnf("announcing the rotation failed: %v", err)
 	}
+	return res, nil
+}
 
-	mod := make([]byte, header.ModulusSize)
-	if n, err := r.Read(mod); n != int(header.ModulusSize) || err != nil {
-		return nil, fmt.Errorf("failed to read modulus (%d, %v)", n, err)
+// store imports the certificates and records the additions in res.
+func (w *WinCertStore) store(cert *x509.Certificate, intermediate *x509.Certificate, res *Result) error {
+	if err := checkCert("Store", "cert", cert); err != nil {
+		return err
 	}
-
-	pub := &rsa.PublicKey{
-		N: new(big.Int).SetBytes(mod),
-		E: int(binary.BigEndian.Uint64(exp)),
+	if err := checkCert("Store", "intermediate", intermediate); err != nil {
+		return err
 	}
-	return pub, nil
-}
-
-// Store imports certificates into the Windows certificate store
-func (w *WinCertStore) Store(cert *x509.Certificate, intermediate *x509.Certificate) error {
 	certContext, err := windows.CertCreateCertificateContext(
 		encodingX509ASN|encodingPKCS7,
 		&cert.Raw[0],
@@ -1128,12 +1840,21 @@ func (w *WinCertStore) Store(cert *x509.Certificate, intermediate *x509.Certific
 		return fmt.Errorf("store: found a matching private key for this certificate, but association failed: %v", err)
 	}
 
+	// Make sure the certificate can be used with its key before adding it,
+	// rather than leaving the failure to the first TLS handshake.
+	if !w.skipStoreVerification {
+		if err := verifyCertKey(certContext, cert); err != nil {
+			logError("Certificate cannot use its private key, not storing it.", opField("store"), thumbprintField(thumbprint(cert)), errField(err))
+			return fmt.Errorf("store: the private key of the certificate is unusable: %v", err)
+		}
+	}
+
 	// Open a handle to the system cert store
 	systemStore, err := windows.CertOpenStore(
 		certStoreProvSystem,
 		0,
 		0,
-		certStoreLocalMachine,
+		w.openFlags(certStoreLocalMachine),
 		uintptr(unsafe.Pointer(my)))
 	if err != nil {
 		return fmt.Errorf("store: CertOpenStore for the system store returned %v", err)
@@ -1144,6 +1865,7 @@ func (w *WinCertStore) Store(cert *x509.Certificate, intermediate *x509.Certific
 	if err := windows.CertAddCertificateContextToStore(systemStore, certContext, windows.CERT_STORE_ADD_ALWAYS, nil); err != nil {
 		return fmt.Errorf("store: CertAddCertificateContextToStore returned %v", err)
 	}
+	res.addChange(ActionAdded, thumbprint(cert), StoreLocation{LocationLocalMachine, "MY"}.String())
 
 	// Prep the intermediate cert context
 	intContext, err := windows.CertCreateCertificateContext(
@@ -1160,7 +1882,7 @@ func (w *WinCertStore) Store(cert *x509.Certificate, intermediate *x509.Certific
 		certStoreProvSystem,
 		0,
 		0,
-		certStoreLocalMachine,
+		w.openFlags(certStoreLocalMachine),
 		uintptr(unsafe.Pointer(ca)))
 	if err != nil {
 		return fmt.Errorf("store: CertOpenStore for the intermediate store returned %v", err)
@@ -1171,6 +1893,42 @@ func (w *WinCertStore) Store(cert *x509.Certificate, intermediate *x509.Certific
 	if err := windows.CertAddCertificateContextToStore(caStore, intContext, windows.CERT_STORE_ADD_ALWAYS, nil); err != nil {
 		return fmt.Errorf("store: CertAddCertificateContextToStore returned %v", err)
 	}
+	res.addChange(ActionAdded, thumbprint(intermediate), StoreLocation{LocationLocalMachine, "CA"}.String())
+
+	return nil
+}
 
+// linkIntermediate adds intermediate to the user CA store unless it is
+// already there, and records the addition in res.
+func (w *WinCertStore) linkIntermediate(intermediate *x509.Certificate, res *Result) error {
+	intContext, err := windows.CertCreateCertificateContext(
+		encodingX509ASN|encodingPKCS7,
+		&intermediate.Raw[0],
+		uint32(len(intermediate.Raw)))
+	if err != nil {
+		return fmt.Errorf("link: CertCreateCertificateContext returned %v", err)
+	}
+	defer windows.CertFreeCertificateContext(intContext)
+
+	userCA, err := windows.CertOpenStore(
+		certStoreProvSystem,
+		0,
+		0,
+		w.openFlags(certStoreCurrentUser),
+		uintptr(unsafe.Pointer(ca)))
+	if err != nil {
+		return fmt.Errorf("link: CertOpenStore for the user intermediate store returned %v", err)
+	}
+	defer windows.CertCloseStore(userCA, 0)
+
+	err = windows.CertAddCertificateContextToStore(userCA, intContext, certStoreAddNew, nil)
+	if errno, ok := err.(syscall.Errno); ok && errno == cryptEExists {
+		logInfo("Intermediate is already linked to the user certificate store.", opField("link"), thumbprintField(thumbprint(intermediate)))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("link: CertAddCertificateContextToStore returned %v", err)
+	}
+	res.addChange(ActionAdded, thumbprint(intermediate), StoreLocation{LocationCurrentUser, "CA"}.String())
 	return nil
 }
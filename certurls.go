@@ -0,0 +1,86 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"net/url"
+	"strings"
+)
+
+// URLProtocol is the lower case scheme of an access URL.
+type URLProtocol string
+
+// Protocols found in AIA and CRL distribution point extensions.
+const (
+	ProtocolHTTP  URLProtocol = "http"
+	ProtocolHTTPS URLProtocol = "https"
+	ProtocolLDAP  URLProtocol = "ldap"
+	ProtocolFile  URLProtocol = "file"
+)
+
+// AccessURL is a URL from the authority information access or CRL
+// distribution points extension of a certificate.
+type AccessURL struct {
+	URL      *url.URL
+	Protocol URLProtocol
+}
+
+func (u AccessURL) String() string {
+	return u.URL.String()
+}
+
+// HTTP reports whether the URL can be fetched over HTTP or HTTPS. Windows
+// also publishes LDAP and file URLs, which most clients cannot use.
+func (u AccessURL) HTTP() bool {
+	return u.Protocol == ProtocolHTTP || u.Protocol == ProtocolHTTPS
+}
+
+// CertURLs holds the revocation and issuer URLs of a certificate.
+type CertURLs struct {
+	// OCSP and CAIssuers are the OCSP responder and issuer certificate URLs
+	// from the authority information access extension.
+	OCSP      []AccessURL
+	CAIssuers []AccessURL
+	// CRL are the URLs of the CRL distribution points extension.
+	CRL []AccessURL
+	// Invalid holds the URLs that could not be parsed.
+	Invalid []string
+}
+
+// ParseCertURLs returns the OCSP, issuer and CRL URLs of cert, so that
+// callers do not need to decode the extensions themselves.
+func ParseCertURLs(cert *x509.Certificate) *CertURLs {
+	u := &CertURLs{}
+	u.OCSP = u.parse(cert.OCSPServer)
+	u.CAIssuers = u.parse(cert.IssuingCertificateURL)
+	u.CRL = u.parse(cert.CRLDistributionPoints)
+	return u
+}
+
+// parse converts raw to AccessURLs, adding the ones that do not parse to
+// u.Invalid.
+func (u *CertURLs) parse(raw []string) []AccessURL {
+	var urls []AccessURL
+	for _, s := range raw {
+		p, err := url.Parse(s)
+		if err != nil || p.Scheme == "" {
+			u.Invalid = append(u.Invalid, s)
+			continue
+		}
+		urls = append(urls, AccessURL{URL: p, Protocol: URLProtocol(strings.ToLower(p.Scheme))})
+	}
+	return urls
+}
@@ -0,0 +1,55 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"reflect"
+	"testing"
+)
+
+func TestParseCertURLs(t *testing.T) {
+	cert := selfSigned(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "urls"},
+		OCSPServer:            []string{"http://ocsp.example.com"},
+		IssuingCertificateURL: []string{"ldap:///CN=Issuing%20CA,CN=AIA?cACertificate", "HTTP://pki.example.com/ca.crt"},
+		CRLDistributionPoints: []string{"file://pki/ca.crl", "https://pki.example.com/ca.crl", "%zz"},
+	})
+	u := ParseCertURLs(cert)
+
+	strs := func(urls []AccessURL) []string {
+		var s []string
+		for _, u := range urls {
+			s = append(s, string(u.Protocol)+" "+u.String())
+		}
+		return s
+	}
+	if got, want := strs(u.OCSP), []string{"http http://ocsp.example.com"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("OCSP = %q, want %q", got, want)
+	}
+	if got, want := strs(u.CAIssuers), []string{"ldap ldap:///CN=Issuing%20CA,CN=AIA?cACertificate", "http http://pki.example.com/ca.crt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("CAIssuers = %q, want %q", got, want)
+	}
+	if got, want := strs(u.CRL), []string{"file file://pki/ca.crl", "https https://pki.example.com/ca.crl"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("CRL = %q, want %q", got, want)
+	}
+	if want := []string{"%zz"}; !reflect.DeepEqual(u.Invalid, want) {
+		t.Errorf("Invalid = %q, want %q", u.Invalid, want)
+	}
+	if u.CAIssuers[0].HTTP() || !u.CAIssuers[1].HTTP() || !u.CRL[1].HTTP() {
+		t.Errorf("HTTP() does not match the protocols of %q", strs(append(u.CAIssuers, u.CRL...)))
+	}
+}
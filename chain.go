@@ -0,0 +1,78 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// chainPEM PEM encodes the certificates in order, as expected by software
+// that takes a certificate bundle (leaf first, followed by the intermediates).
+func chainPEM(chain []*x509.Certificate) ([]byte, error) {
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no certificates to encode")
+	}
+	var buf bytes.Buffer
+	for _, cert := range chain {
+		if err := pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}); err != nil {
+			return nil, fmt.Errorf("could not encode cert to PEM: %v", err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// writeChainPEM writes chain as a PEM bundle to path. The bundle is written to
+// a temporary file in the same directory first and then renamed over path, so
+// readers never observe a partially written file.
+func writeChainPEM(path string, chain []*x509.Certificate) error {
+	b, err := chainPEM(chain)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b, createMode)
+}
+
+// writeFileAtomic replaces the file at path with data.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	// Clean up the temporary file on failure. After the rename it no longer exists.
+	defer os.Remove(tmp)
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
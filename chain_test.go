@@ -0,0 +1,87 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/certtostore/testdata"
+)
+
+func TestWriteChainPEM(t *testing.T) {
+	leaf, err := PEMToX509([]byte(testdata.CertPEM))
+	if err != nil {
+		t.Fatalf("error decoding test certificate: %v", err)
+	}
+	intermediate := selfSigned(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "intermediate"},
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(time.Hour),
+	})
+
+	dir, err := ioutil.TempDir("", "certtostore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "chain.pem")
+
+	// An existing bundle must be replaced.
+	if err := ioutil.WriteFile(path, []byte("old bundle"), createMode); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeChainPEM(path, []*x509.Certificate{leaf, intermediate}); err != nil {
+		t.Fatalf("writeChainPEM returned %v", err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got [][]byte
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		got = append(got, block.Bytes)
+	}
+	if len(got) != 2 || !bytes.Equal(got[0], leaf.Raw) || !bytes.Equal(got[1], intermediate.Raw) {
+		t.Errorf("unexpected bundle contents, got %d certificates", len(got))
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("temporary files left behind, got %d files in %s", len(files), dir)
+	}
+}
+
+func TestWriteChainPEMEmpty(t *testing.T) {
+	if err := writeChainPEM(filepath.Join(os.TempDir(), "unused.pem"), nil); err == nil {
+		t.Error("writeChainPEM succeeded without certificates, want error")
+	}
+}
@@ -0,0 +1,130 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// hcceLocalMachine is HCCE_LOCAL_MACHINE, the chain engine that uses the
+// local machine stores.
+const hcceLocalMachine = 0x1
+
+// WriteChainPEM writes the current cert followed by the intermediates that
+// chain it to a root as a PEM bundle to path, replacing the file atomically.
+// The root itself is left out. This is intended for software that cannot read
+// the Windows certificate store, such as nginx or Java applications.
+func (w *WinCertStore) WriteChainPEM(path string) error {
+	chain, err := w.chain()
+	if err != nil {
+		return err
+	}
+	if err := writeChainPEM(path, chain); err != nil {
+		return fmt.Errorf("writing chain to %s: %v", path, err)
+	}
+	logInfo("Wrote certificate chain.", opField("writechain"), thumbprintField(thumbprint(chain[0])), field("path", path), field("certificates", len(chain)))
+	return nil
+}
+
+// ExportSST writes the current cert followed by the intermediates that chain
+// it to a root to path as a serialized store in the .sst format, replacing
+// the file atomically. The root itself is left out like in WriteChainPEM. It
+// is intended for teams that work with certutil and other Windows tools.
+func (w *WinCertStore) ExportSST(path string) error {
+	chain, err := w.chain()
+	if err != nil {
+		return err
+	}
+	if err := WriteSST(path, chain); err != nil {
+		return err
+	}
+	logInfo("Wrote certificate chain.", opField("exportsst"), thumbprintField(thumbprint(chain[0])), field("path", path), field("certificates", len(chain)))
+	return nil
+}
+
+// chain returns the current cert and the intermediates discovered for it by
+// the chain engine, without the self-signed root.
+func (w *WinCertStore) chain() ([]*x509.Certificate, error) {
+	chain, err := w.fullChain()
+	if err != nil {
+		return nil, err
+	}
+	for i, xc := range chain {
+		if i > 0 && bytes.Equal(xc.RawIssuer, xc.RawSubject) {
+			// Skip the root, clients are expected to have it already.
+			return chain[:i], nil
+		}
+	}
+	return chain, nil
+}
+
+// VerifySCTs verifies the SCTs embedded in the current cert against logs, see
+// VerifyEmbeddedSCTs. The issuer is found with the chain engine.
+func (w *WinCertStore) VerifySCTs(logs []CTLog) ([]SCTResult, error) {
+	chain, err := w.fullChain()
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) < 2 {
+		return nil, fmt.Errorf("no issuer found for certificate %s", thumbprint(chain[0]))
+	}
+	return VerifyEmbeddedSCTs(chain[0], chain[1], logs)
+}
+
+// fullChain returns the current cert and the rest of the chain discovered for
+// it by the chain engine, up to and including the root.
+func (w *WinCertStore) fullChain() ([]*x509.Certificate, error) {
+	issuers := w.issuerList()
+	_, certContext, err := w.certContext(issuers, my, certStoreLocalMachine)
+	if err != nil {
+		return nil, err
+	}
+	if certContext == nil {
+		return nil, fmt.Errorf("no certificate found for issuers %v", issuers)
+	}
+	defer windows.CertFreeCertificateContext(certContext)
+
+	para := windows.CertChainPara{}
+	para.Size = uint32(unsafe.Sizeof(para))
+	var chainContext *windows.CertChainContext
+	if err := windows.CertGetCertificateChain(hcceLocalMachine, certContext, nil, 0, &para, 0, 0, &chainContext); err != nil {
+		return nil, fmt.Errorf("CertGetCertificateChain returned %v", err)
+	}
+	defer windows.CertFreeCertificateChain(chainContext)
+
+	if chainContext.ChainCount == 0 {
+		return nil, fmt.Errorf("CertGetCertificateChain returned no chains")
+	}
+	// The first simple chain is the one that starts at the end certificate.
+	simple := (*[1 << 20]*windows.CertSimpleChain)(unsafe.Pointer(chainContext.Chains))[0]
+	elements := (*[1 << 20]*windows.CertChainElement)(unsafe.Pointer(simple.Elements))[:simple.NumElements:simple.NumElements]
+
+	var chain []*x509.Certificate
+	for i, element := range elements {
+		xc, err := x509.ParseCertificate(certContextBytes(element.CertContext))
+		if err != nil {
+			return nil, fmt.Errorf("parsing chain certificate %d: %v", i, err)
+		}
+		chain = append(chain, xc)
+	}
+	return chain, nil
+}
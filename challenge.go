@@ -0,0 +1,126 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+)
+
+// oidChallengePassword is the PKCS #9 challengePassword attribute.
+var oidChallengePassword = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 7}
+
+// maxChallengePasswordLength is ub-challenge-password from PKCS #9.
+const maxChallengePasswordLength = 255
+
+// ndesChallenge matches the password on the mscep_admin page of NDES.
+var ndesChallenge = regexp.MustCompile(`(?i)challenge password is:\s*<B>\s*([^<\s]+)\s*</B>`)
+
+// ChallengePassword is a SCEP challenge password. It is kept in a byte slice
+// that Wipe overwrites, rather than in a string that stays in memory until
+// the garbage collector reuses it, and it is never printed.
+type ChallengePassword struct {
+	b []byte
+	// OneTime is set for dynamic passwords, which the server accepts for a
+	// single request. RequestTemplate.CSR wipes them once it used them.
+	OneTime bool
+}
+
+// NewChallengePassword returns a static challenge password with a copy of p.
+// The caller should wipe p.
+func NewChallengePassword(p []byte) *ChallengePassword {
+	return &ChallengePassword{b: append([]byte(nil), p...)}
+}
+
+// ParseNDESChallenge extracts the one-time password from the mscep_admin page
+// of NDES. Intune and other dynamic challenges that are issued as a plain
+// value are created with NewChallengePassword and OneTime set instead.
+func ParseNDESChallenge(page []byte) (*ChallengePassword, error) {
+	m := ndesChallenge.FindSubmatch(page)
+	if m == nil {
+		if bytes.Contains(bytes.ToLower(page), []byte("password cache is full")) {
+			return nil, errors.New("the NDES password cache is full")
+		}
+		return nil, errors.New("no challenge password found on the NDES page")
+	}
+	c := NewChallengePassword(m[1])
+	c.OneTime = true
+	return c, nil
+}
+
+// Wipe overwrites the password. It can no longer be used afterwards.
+func (c *ChallengePassword) Wipe() {
+	wipe(c.b)
+	c.b = nil
+}
+
+// Wiped reports whether the password was wiped.
+func (c *ChallengePassword) Wiped() bool {
+	return c.b == nil
+}
+
+// String implements fmt.Stringer without revealing the password.
+func (c *ChallengePassword) String() string {
+	return "ChallengePassword(redacted)"
+}
+
+// GoString implements fmt.GoStringer without revealing the password.
+func (c *ChallengePassword) GoString() string {
+	return c.String()
+}
+
+func (c *ChallengePassword) validate() error {
+	switch {
+	case c.Wiped():
+		return errors.New("the challenge password was wiped")
+	case len(c.b) == 0 || len(c.b) > maxChallengePasswordLength:
+		return fmt.Errorf("the challenge password must have 1 to %d bytes", maxChallengePasswordLength)
+	case !utf8.Valid(c.b):
+		return errors.New("the challenge password is not valid UTF-8")
+	}
+	return nil
+}
+
+// der encodes the password as a DirectoryString, a PrintableString if
+// possible and a UTF8String otherwise, without copying it into a string. The
+// caller should wipe the result.
+func (c *ChallengePassword) der() ([]byte, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	tag := byte(asn1.TagPrintableString)
+	for _, b := range c.b {
+		if !isPrintable(b) {
+			tag = asn1.TagUTF8String
+			break
+		}
+	}
+	v := append(make([]byte, 0, 3+len(c.b)), tag)
+	if len(c.b) < 0x80 {
+		v = append(v, byte(len(c.b)))
+	} else {
+		v = append(v, 0x81, byte(len(c.b)))
+	}
+	return append(v, c.b...), nil
+}
+
+// isPrintable reports whether b may appear in a PrintableString.
+func isPrintable(b byte) bool {
+	return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9' || bytes.IndexByte([]byte(" '()+,-./:=?"), b) >= 0
+}
@@ -0,0 +1,111 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestChallengePasswordDER(t *testing.T) {
+	for _, p := range []string{"8F2A1B3C4D5E6F70", "pässword", strings.Repeat("x", 200)} {
+		v, err := NewChallengePassword([]byte(p)).der()
+		if err != nil {
+			t.Fatalf("der(%q) returned %v", p, err)
+		}
+		var got string
+		if _, err := asn1.Unmarshal(v, &got); err != nil || got != p {
+			t.Errorf("der(%q) decodes to %q, %v", p, got, err)
+		}
+	}
+	if _, err := NewChallengePassword(nil).der(); err == nil {
+		t.Error("der of an empty password succeeded, want error")
+	}
+}
+
+func TestParseNDESChallenge(t *testing.T) {
+	page := []byte("<P> The enrollment challenge password is: <B> 8F2A1B3C4D5E6F70 </B> <P> This password can be used only once")
+	c, err := ParseNDESChallenge(page)
+	if err != nil {
+		t.Fatalf("ParseNDESChallenge returned %v", err)
+	}
+	if string(c.b) != "8F2A1B3C4D5E6F70" || !c.OneTime {
+		t.Errorf("unexpected challenge %q, one-time: %t", c.b, c.OneTime)
+	}
+	if s := fmt.Sprintf("%v %#v", c, c); strings.Contains(s, "8F2A") {
+		t.Errorf("formatted challenge reveals the password: %s", s)
+	}
+	if _, err := ParseNDESChallenge([]byte("The password cache is full.")); err == nil {
+		t.Error("ParseNDESChallenge succeeded without a password, want error")
+	}
+}
+
+func TestRequestTemplateCSRChallengePassword(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	c := NewChallengePassword([]byte("8F2A1B3C4D5E6F70"))
+	c.OneTime = true
+	rt := &RequestTemplate{
+		Subject:           pkix.Name{CommonName: "host.example.com"},
+		Key:               GenerateOpts{Algorithm: "RSA", KeySize: 2048},
+		ChallengePassword: c,
+	}
+	if _, err := rt.INF(ProviderMSSoftware); err == nil {
+		t.Error("INF with a challenge password succeeded, want error")
+	}
+	der, err := rt.CSR(rand.Reader, key)
+	if err != nil {
+		t.Fatalf("CSR returned %v", err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatalf("failed to parse CSR: %v", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		t.Errorf("CSR signature did not verify: %v", err)
+	}
+	var outer rawCSR
+	var info rawCSRInfo
+	if _, err := asn1.Unmarshal(der, &outer); err != nil {
+		t.Fatalf("failed to decode CSR: %v", err)
+	}
+	if _, err := asn1.Unmarshal(outer.TBS.FullBytes, &info); err != nil {
+		t.Fatalf("failed to decode CSR info: %v", err)
+	}
+	var got string
+	for _, raw := range info.Attributes {
+		var attr csrAttribute
+		if _, err := asn1.Unmarshal(raw.FullBytes, &attr); err == nil && attr.Type.Equal(oidChallengePassword) && len(attr.Values) == 1 {
+			asn1.Unmarshal(attr.Values[0].FullBytes, &got)
+		}
+	}
+	if got != "8F2A1B3C4D5E6F70" {
+		t.Errorf("CSR challenge password = %q, want: 8F2A1B3C4D5E6F70", got)
+	}
+	if !c.Wiped() {
+		t.Error("one-time challenge password was not wiped")
+	}
+	if _, err := rt.CSR(rand.Reader, key); err == nil {
+		t.Error("CSR with a used one-time password succeeded, want error")
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Backend identifies where a store opened by OpenCompatible keeps its keys.
+type Backend string
+
+// Backends in the order OpenCompatible degrades through them.
+const (
+	// BackendPlatform keeps keys in the TPM with ProviderMSPlatform.
+	BackendPlatform Backend = "platform"
+	// BackendSoftware keeps keys with ProviderMSSoftware.
+	BackendSoftware Backend = "software"
+	// BackendFile keeps keys and certificates in a FileStorage directory.
+	BackendFile Backend = "file"
+)
+
+var backendOrder = []Backend{BackendPlatform, BackendSoftware, BackendFile}
+
+// Names of the capabilities, used as the keys of Capabilities.Problems.
+const (
+	CapPlatformProvider = "platform provider"
+	CapSoftwareProvider = "software provider"
+	CapMachineStore     = "machine store"
+)
+
+// Capabilities reports which parts of the key storage of the host are
+// usable. Process-isolated Windows Server containers and Nano Server lack
+// the TPM, and Nano Server lacks parts of CryptoAPI, so code that runs both
+// in containerized CI and on bare metal checks them instead of failing on the
+// first unavailable API.
+type Capabilities struct {
+	// Container is set in a process-isolated Windows Server container, and
+	// NanoServer on a Nano Server installation.
+	Container  bool
+	NanoServer bool
+	// PlatformProvider and SoftwareProvider are set if ProviderMSPlatform
+	// and ProviderMSSoftware can be opened.
+	PlatformProvider bool
+	SoftwareProvider bool
+	// MachineStore is set if the LocalMachine\MY store can be opened.
+	MachineStore bool
+	// Problems explains each missing capability by its name, such as
+	// CapPlatformProvider.
+	Problems map[string]string
+}
+
+// problem records why the capability name is missing.
+func (c *Capabilities) problem(name string, err error) {
+	if c.Problems == nil {
+		c.Problems = make(map[string]string)
+	}
+	c.Problems[name] = err.Error()
+}
+
+// available reports whether b can be used. The provider backends also need
+// the machine store, where WinCertStore keeps its certificates.
+func (c Capabilities) available(b Backend, allowFile bool) bool {
+	switch b {
+	case BackendPlatform:
+		return c.PlatformProvider && c.MachineStore
+	case BackendSoftware:
+		return c.SoftwareProvider && c.MachineStore
+	case BackendFile:
+		return allowFile
+	}
+	return false
+}
+
+// Backend returns preferred if it is available, or else the first available
+// backend after it in the order platform, software, file. The file backend is
+// only used if allowFile is set.
+func (c Capabilities) Backend(preferred Backend, allowFile bool) (Backend, error) {
+	start := -1
+	for i, b := range backendOrder {
+		if b == preferred {
+			start = i
+		}
+	}
+	if start < 0 {
+		return "", &ArgError{Op: "Backend", Arg: "preferred", Reason: fmt.Sprintf("unknown backend %q", preferred)}
+	}
+	for _, b := range backendOrder[start:] {
+		if c.available(b, allowFile) {
+			return b, nil
+		}
+	}
+	return "", fmt.Errorf("no key storage backend from %s on is available: %v", preferred, c)
+}
+
+// String summarizes c as a feature matrix, followed by the problems.
+func (c Capabilities) String() string {
+	s := fmt.Sprintf("container=%s nano=%s %s=%s %s=%s %s=%s",
+		yesNo(c.Container), yesNo(c.NanoServer),
+		strings.ReplaceAll(CapPlatformProvider, " ", "-"), yesNo(c.PlatformProvider),
+		strings.ReplaceAll(CapSoftwareProvider, " ", "-"), yesNo(c.SoftwareProvider),
+		strings.ReplaceAll(CapMachineStore, " ", "-"), yesNo(c.MachineStore))
+	names := make([]string, 0, len(c.Problems))
+	for n := range c.Problems {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		s += fmt.Sprintf("; %s: %s", n, c.Problems[n])
+	}
+	return s
+}
+
+// yesNo formats b for a feature matrix.
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
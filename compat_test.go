@@ -0,0 +1,66 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCapabilitiesBackend(t *testing.T) {
+	bareMetal := Capabilities{PlatformProvider: true, SoftwareProvider: true, MachineStore: true}
+	container := Capabilities{Container: true, SoftwareProvider: true, MachineStore: true}
+	container.problem(CapPlatformProvider, errors.New("no TPM"))
+	nano := Capabilities{NanoServer: true}
+	nano.problem(CapMachineStore, errors.New("access denied"))
+
+	for _, tc := range []struct {
+		desc      string
+		caps      Capabilities
+		preferred Backend
+		allowFile bool
+		want      Backend
+		wantErr   bool
+	}{
+		{"bare metal", bareMetal, BackendPlatform, false, BackendPlatform, false},
+		{"software preferred", bareMetal, BackendSoftware, false, BackendSoftware, false},
+		{"container degrades to software", container, BackendPlatform, false, BackendSoftware, false},
+		{"no store degrades to file", nano, BackendPlatform, true, BackendFile, false},
+		{"file not allowed", nano, BackendPlatform, false, "", true},
+		{"file preferred", bareMetal, BackendFile, true, BackendFile, false},
+		{"unknown backend", bareMetal, "hsm", true, "", true},
+	} {
+		got, err := tc.caps.Backend(tc.preferred, tc.allowFile)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: Backend(%q, %t) returned error %v, want error %t", tc.desc, tc.preferred, tc.allowFile, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s: Backend(%q, %t) = %q, want %q", tc.desc, tc.preferred, tc.allowFile, got, tc.want)
+		}
+	}
+}
+
+func TestCapabilitiesString(t *testing.T) {
+	c := Capabilities{Container: true, SoftwareProvider: true, MachineStore: true}
+	c.problem(CapPlatformProvider, errors.New("no TPM"))
+	got := c.String()
+	for _, want := range []string{"container=yes", "nano=no", "platform-provider=no", "software-provider=yes", "machine-store=yes", "platform provider: no TPM"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("String() = %q, want it to contain %q", got, want)
+		}
+	}
+}
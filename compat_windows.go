@@ -0,0 +1,125 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+const (
+	// containerTypeKey holds the ContainerType value inside Windows Server
+	// containers.
+	containerTypeKey = `SYSTEM\CurrentControlSet\Control`
+	// serverLevelsKey holds the NanoServer value on Nano Server.
+	serverLevelsKey = `SOFTWARE\Microsoft\Windows NT\CurrentVersion\Server\ServerLevels`
+)
+
+// DetectCapabilities probes which parts of the key storage of the host are
+// usable. It opens both providers and the LocalMachine\MY store read-only,
+// and does not change anything.
+func DetectCapabilities() Capabilities {
+	var c Capabilities
+	c.Container = registryValueExists(containerTypeKey, "ContainerType")
+	c.NanoServer = registryValueExists(serverLevelsKey, "NanoServer")
+
+	if err := probeProvider(ProviderMSPlatform); err != nil {
+		c.problem(CapPlatformProvider, err)
+	} else {
+		c.PlatformProvider = true
+	}
+	if err := probeProvider(ProviderMSSoftware); err != nil {
+		c.problem(CapSoftwareProvider, err)
+	} else {
+		c.SoftwareProvider = true
+	}
+	s, err := openStore(StoreLocation{Location: LocationLocalMachine, Name: "MY"}, certStoreOpenExisting|certStoreReadOnly)
+	if err != nil {
+		c.problem(CapMachineStore, err)
+	} else {
+		c.MachineStore = true
+		windows.CertCloseStore(s, 0)
+	}
+	logDebug("Detected key storage capabilities.", field("capabilities", c.String()))
+	return c
+}
+
+func registryValueExists(path, name string) bool {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, path, registry.QUERY_VALUE)
+	if err != nil {
+		return false
+	}
+	defer k.Close()
+	_, _, err = k.GetIntegerValue(name)
+	return err == nil
+}
+
+// probeProvider opens and releases provider.
+func probeProvider(provider string) error {
+	h, err := openProvider(provider)
+	if err != nil {
+		return err
+	}
+	nCryptFreeObject.Call(h)
+	return nil
+}
+
+// CompatibleOptions configures OpenCompatible.
+type CompatibleOptions struct {
+	// Store configures the WinCertStore of the provider backends. Its
+	// Provider is set by OpenCompatible.
+	Store WinCertStoreOptions
+	// Preferred is the backend to use if it is available, BackendPlatform
+	// by default.
+	Preferred Backend
+	// FileDir, if set, allows degrading to a FileStorage in the directory.
+	FileDir string
+}
+
+// OpenCompatible opens the store of the preferred backend, or degrades to
+// the next available one if the host lacks it, such as in a Windows Server
+// container without a TPM. It returns the capabilities it based the choice
+// on, so callers can report what they run with.
+func OpenCompatible(opts CompatibleOptions) (CertStorage, Backend, Capabilities, error) {
+	preferred := opts.Preferred
+	if preferred == "" {
+		preferred = BackendPlatform
+	}
+	caps := DetectCapabilities()
+	b, err := caps.Backend(preferred, opts.FileDir != "")
+	if err != nil {
+		return nil, "", caps, err
+	}
+	if b != preferred {
+		logWarning("Preferred key storage backend is unavailable, degrading.", field("preferred", preferred), field("backend", b), field("capabilities", caps.String()))
+	}
+
+	if b == BackendFile {
+		return NewFileStorage(opts.FileDir), b, caps, nil
+	}
+	opts.Store.Provider = ProviderMSPlatform
+	if b == BackendSoftware {
+		opts.Store.Provider = ProviderMSSoftware
+	}
+	w, err := OpenWinCertStoreWithOptions(opts.Store)
+	if err != nil {
+		return nil, "", caps, fmt.Errorf("OpenWinCertStoreWithOptions returned %v", err)
+	}
+	return w.CertStorage(), b, caps, nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// placeholderGUIDs are system UUIDs that vendors ship on many machines
+// instead of a unique value. They are ignored so that those machines don't
+// end up sharing container names.
+var placeholderGUIDs = map[string]bool{
+	"03000200-0400-0500-0006-000700080009": true,
+}
+
+// MachineIdentity holds the stable machine identifiers a container name is
+// derived from.
+type MachineIdentity struct {
+	// Hostname is the name of the machine. It is compared case insensitively.
+	Hostname string
+	// MachineGUID is a hardware identifier such as the SMBIOS system UUID,
+	// which survives re-imaging the machine.
+	MachineGUID string
+}
+
+// ContainerName derives a deterministic key container name from id and a
+// purpose label, such as "machine-auth". The same machine and purpose always
+// yield the same name, so fleets get predictable names and a re-imaged
+// machine finds its container again. The name starts with the purpose and
+// ends with a hash of all inputs, which avoids collisions between machines
+// that share a hostname and between purposes. Known placeholder GUIDs are
+// ignored.
+func ContainerName(id MachineIdentity, purpose string) (string, error) {
+	label := sanitizeLabel(purpose)
+	if label == "" {
+		return "", errors.New("container purpose must not be empty")
+	}
+	host := strings.ToLower(strings.TrimSpace(id.Hostname))
+	guid := normalizeGUID(id.MachineGUID)
+	if host == "" && guid == "" {
+		return "", errors.New("no machine identifiers available to derive the container name from")
+	}
+
+	h := sha256.New()
+	for _, s := range []string{host, guid, purpose} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return label + "-" + strings.ToUpper(hex.EncodeToString(h.Sum(nil)[:10])), nil
+}
+
+// normalizeGUID returns guid in upper case without braces, or an empty string
+// if it is not a usable identifier.
+func normalizeGUID(guid string) string {
+	guid = strings.ToUpper(strings.Trim(strings.TrimSpace(guid), "{}"))
+	digits := strings.Replace(guid, "-", "", -1)
+	if digits == "" || placeholderGUIDs[guid] {
+		return ""
+	}
+	// All zero or all F UUIDs mean the vendor did not set one.
+	if strings.Trim(digits, "0") == "" || strings.Trim(digits, "F") == "" {
+		return ""
+	}
+	return guid
+}
+
+// sanitizeLabel drops the characters that are not safe in container names
+// or file names from a purpose label.
+func sanitizeLabel(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		default:
+			return -1
+		}
+	}, strings.TrimSpace(s))
+}
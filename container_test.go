@@ -0,0 +1,86 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestContainerName(t *testing.T) {
+	id := MachineIdentity{Hostname: "Host1.example.com", MachineGUID: "{4c4c4544-0042-3510-8052-b3c04f4d4e32}"}
+	name, err := ContainerName(id, "machine auth")
+	if err != nil {
+		t.Fatalf("ContainerName returned %v", err)
+	}
+	if !strings.HasPrefix(name, "machineauth-") {
+		t.Errorf("unexpected container name prefix, got: %s", name)
+	}
+
+	// Case and formatting differences must not change the name.
+	same, err := ContainerName(MachineIdentity{Hostname: "host1.EXAMPLE.com", MachineGUID: "4C4C4544-0042-3510-8052-B3C04F4D4E32"}, "machine auth")
+	if err != nil {
+		t.Fatalf("ContainerName returned %v", err)
+	}
+	if same != name {
+		t.Errorf("container name is not deterministic, got: %s, want: %s", same, name)
+	}
+
+	for _, other := range []struct {
+		id      MachineIdentity
+		purpose string
+	}{
+		{MachineIdentity{Hostname: "host2.example.com", MachineGUID: id.MachineGUID}, "machine auth"},
+		{MachineIdentity{Hostname: id.Hostname, MachineGUID: "4c4c4544-0042-3510-8052-b3c04f4d4e33"}, "machine auth"},
+		{id, "machine-auth"},
+	} {
+		n, err := ContainerName(other.id, other.purpose)
+		if err != nil {
+			t.Fatalf("ContainerName returned %v", err)
+		}
+		if n == name {
+			t.Errorf("ContainerName(%+v, %q) collides with %s", other.id, other.purpose, name)
+		}
+	}
+}
+
+func TestContainerNamePlaceholderGUID(t *testing.T) {
+	hostOnly, err := ContainerName(MachineIdentity{Hostname: "host1"}, "auth")
+	if err != nil {
+		t.Fatalf("ContainerName returned %v", err)
+	}
+	for _, guid := range []string{
+		"00000000-0000-0000-0000-000000000000",
+		"FFFFFFFF-FFFF-FFFF-FFFF-FFFFFFFFFFFF",
+		"03000200-0400-0500-0006-000700080009",
+	} {
+		name, err := ContainerName(MachineIdentity{Hostname: "host1", MachineGUID: guid}, "auth")
+		if err != nil {
+			t.Fatalf("ContainerName returned %v", err)
+		}
+		if name != hostOnly {
+			t.Errorf("placeholder GUID %s was not ignored", guid)
+		}
+	}
+}
+
+func TestContainerNameErrors(t *testing.T) {
+	if _, err := ContainerName(MachineIdentity{Hostname: "host1"}, " /\\ "); err == nil {
+		t.Error("ContainerName succeeded with an empty purpose, want error")
+	}
+	if _, err := ContainerName(MachineIdentity{MachineGUID: "00000000-0000-0000-0000-000000000000"}, "auth"); err == nil {
+		t.Error("ContainerName succeeded without identifiers, want error")
+	}
+}
@@ -0,0 +1,170 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// CertControlStore control types from wincrypt.h.
+const (
+	certStoreCtrlResync       = 1 // CERT_STORE_CTRL_RESYNC
+	certStoreCtrlNotifyChange = 2 // CERT_STORE_CTRL_NOTIFY_CHANGE
+)
+
+var certControlStore = crypt32.MustFindProc("CertControlStore")
+
+// rotationEvent opens or creates the machine wide event that is signaled when
+// the certificate of the container of w is rotated. Processes must have
+// access to the event, so processes running as different users may need to
+// share the account or create the event with a suitable DACL first.
+func (w *WinCertStore) rotationEvent() (windows.Handle, error) {
+	name, err := windows.UTF16PtrFromString(containerObjectName("certtostore-rotated-", w.ProvName, w.container))
+	if err != nil {
+		return 0, err
+	}
+	// The event is manual reset, so that setting it releases every waiter.
+	ev, err := windows.CreateEvent(nil, 1, 0, name)
+	if err != nil {
+		return 0, fmt.Errorf("CreateEvent returned %v", err)
+	}
+	return ev, nil
+}
+
+// AnnounceRotation wakes the processes on this machine that watch the
+// certificate of the container of w with WatchRotation. StoreWithResult and
+// Store call it after installing a certificate.
+func (w *WinCertStore) AnnounceRotation() error {
+	ev, err := w.rotationEvent()
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(ev)
+	// Setting a manual reset event releases all current waiters, even if it
+	// is reset right away. Waiters that are busy miss the event, but still see
+	// the store change notification.
+	if err := windows.SetEvent(ev); err != nil {
+		return fmt.Errorf("SetEvent returned %v", err)
+	}
+	if err := windows.ResetEvent(ev); err != nil {
+		return fmt.Errorf("ResetEvent returned %v", err)
+	}
+	logInfo("Announced certificate rotation.", opField("announce"), containerField(w.container))
+	return nil
+}
+
+// WatchRotation reports the new certificate of w whenever another process,
+// or this one, rotates it, so that services sharing a machine identity adopt
+// it without a restart. It is woken by AnnounceRotation and by change
+// notifications of the local machine MY store. Watching stops and the channel
+// is closed once ctx is done.
+func (w *WinCertStore) WatchRotation(ctx context.Context) (<-chan *x509.Certificate, error) {
+	last, err := w.Cert()
+	if err != nil {
+		return nil, err
+	}
+
+	rotated, err := w.rotationEvent()
+	if err != nil {
+		return nil, err
+	}
+	certStore, err := windows.CertOpenStore(
+		certStoreProvSystem,
+		0,
+		0,
+		certStoreLocalMachine|w.lookupFlags(),
+		uintptr(unsafe.Pointer(my)))
+	if err != nil {
+		windows.CloseHandle(rotated)
+		return nil, fmt.Errorf("rotation: CertOpenStore returned %v", err)
+	}
+	storeChanged, err := windows.CreateEvent(nil, 0, 0, nil)
+	if err != nil {
+		windows.CertCloseStore(certStore, 0)
+		windows.CloseHandle(rotated)
+		return nil, fmt.Errorf("CreateEvent returned %v", err)
+	}
+	stop, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		windows.CloseHandle(storeChanged)
+		windows.CertCloseStore(certStore, 0)
+		windows.CloseHandle(rotated)
+		return nil, fmt.Errorf("CreateEvent returned %v", err)
+	}
+	closeAll := func() {
+		windows.CloseHandle(stop)
+		windows.CloseHandle(storeChanged)
+		windows.CertCloseStore(certStore, 0)
+		windows.CloseHandle(rotated)
+	}
+	r, _, err := certControlStore.Call(uintptr(certStore), 0, certStoreCtrlNotifyChange, uintptr(unsafe.Pointer(&storeChanged)))
+	if r == 0 {
+		closeAll()
+		return nil, fmt.Errorf("CertControlStore returned %v", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		windows.SetEvent(stop)
+	}()
+
+	ch := make(chan *x509.Certificate, 1)
+	go func() {
+		defer close(ch)
+		defer closeAll()
+
+		for {
+			ev, err := windows.WaitForMultipleObjects([]windows.Handle{rotated, storeChanged, stop}, false, windows.INFINITE)
+			if err != nil {
+				logError("WaitForMultipleObjects failed, no longer watching for rotations.", opField("watchrotation"), containerField(w.container), errField(err))
+				return
+			}
+			switch ev {
+			case windows.WAIT_OBJECT_0:
+			case windows.WAIT_OBJECT_0 + 1:
+				// Resync the store with the registry and register again.
+				if r, _, err := certControlStore.Call(uintptr(certStore), 0, certStoreCtrlResync, uintptr(unsafe.Pointer(&storeChanged))); r == 0 {
+					logWarning("Could not resync the certificate store.", opField("watchrotation"), containerField(w.container), errField(err))
+				}
+			default:
+				return
+			}
+
+			cur, err := w.Cert()
+			if err != nil {
+				logWarning("Could not read the certificate.", opField("watchrotation"), containerField(w.container), errField(err))
+				continue
+			}
+			if cur == nil || (last != nil && cur.Equal(last)) {
+				continue
+			}
+			last = cur
+			logInfo("Adopting rotated certificate.", opField("watchrotation"), containerField(w.container), thumbprintField(thumbprint(cur)))
+			select {
+			case ch <- cur:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,322 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// oidSCTList is the X.509v3 extension that holds the SCTs embedded in a
+// certificate, see RFC 6962 section 3.3.
+var oidSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+// ErrNoSCTs is returned by VerifyEmbeddedSCTs if the certificate does not
+// carry any SCTs.
+var ErrNoSCTs = errors.New("certificate has no embedded SCTs")
+
+// CTLog is a certificate transparency log that SCTs are verified against.
+type CTLog struct {
+	Description string
+	// Key is the public key of the log.
+	Key crypto.PublicKey
+}
+
+// id returns the log ID, which is the SHA-256 hash of the log key.
+func (l CTLog) id() ([sha256.Size]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(l.Key)
+	if err != nil {
+		return [sha256.Size]byte{}, fmt.Errorf("invalid key for log %q: %v", l.Description, err)
+	}
+	return sha256.Sum256(der), nil
+}
+
+// ParseCTLogList parses the logs from a log list in the JSON format published
+// at https://www.gstatic.com/ct/log_list/v3/log_list.json.
+func ParseCTLogList(data []byte) ([]CTLog, error) {
+	var list struct {
+		Operators []struct {
+			Logs []struct {
+				Description string `json:"description"`
+				Key         []byte `json:"key"`
+			} `json:"logs"`
+		} `json:"operators"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("could not decode log list: %v", err)
+	}
+	var logs []CTLog
+	for _, op := range list.Operators {
+		for _, l := range op.Logs {
+			key, err := x509.ParsePKIXPublicKey(l.Key)
+			if err != nil {
+				return nil, fmt.Errorf("invalid key for log %q: %v", l.Description, err)
+			}
+			logs = append(logs, CTLog{Description: l.Description, Key: key})
+		}
+	}
+	return logs, nil
+}
+
+// SCTResult is the result of verifying one SCT embedded in a certificate.
+type SCTResult struct {
+	// LogID is the base64 encoded ID of the log that issued the SCT.
+	LogID string
+	// Log is the description of the log, or empty if it is not a known log.
+	Log       string
+	Timestamp time.Time
+	// Err is nil if the SCT was verified against a known log.
+	Err error
+}
+
+// Valid reports whether the SCT was verified against a known log.
+func (r SCTResult) Valid() bool {
+	return r.Err == nil
+}
+
+// sct is a SignedCertificateTimestamp from RFC 6962 section 3.2.
+type sct struct {
+	logID      [sha256.Size]byte
+	timestamp  uint64
+	extensions []byte
+	hashAlg    byte
+	sigAlg     byte
+	signature  []byte
+}
+
+// VerifyEmbeddedSCTs verifies the SCTs embedded in cert, which was issued by
+// issuer, against logs. It returns a result for every SCT, or ErrNoSCTs if
+// there are none. SCTs delivered in the TLS handshake or in OCSP responses
+// are not part of the certificate and are not covered.
+func VerifyEmbeddedSCTs(cert, issuer *x509.Certificate, logs []CTLog) ([]SCTResult, error) {
+	var list []byte
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidSCTList) {
+			if _, err := asn1.Unmarshal(ext.Value, &list); err != nil {
+				return nil, fmt.Errorf("could not decode SCT list extension: %v", err)
+			}
+		}
+	}
+	if list == nil {
+		return nil, ErrNoSCTs
+	}
+	scts, err := parseSCTList(list)
+	if err != nil {
+		return nil, err
+	}
+	if len(scts) == 0 {
+		return nil, ErrNoSCTs
+	}
+	tbs, err := precertTBS(cert.RawTBSCertificate)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[[sha256.Size]byte]CTLog)
+	for _, l := range logs {
+		id, err := l.id()
+		if err != nil {
+			return nil, err
+		}
+		known[id] = l
+	}
+
+	issuerKeyHash := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+	var results []SCTResult
+	for _, s := range scts {
+		r := SCTResult{
+			LogID:     base64.StdEncoding.EncodeToString(s.logID[:]),
+			Timestamp: time.Unix(0, int64(s.timestamp)*int64(time.Millisecond)).UTC(),
+		}
+		if l, ok := known[s.logID]; !ok {
+			r.Err = errors.New("SCT is from an unknown log")
+		} else {
+			r.Log = l.Description
+			r.Err = s.verify(l.Key, issuerKeyHash, tbs)
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// verify checks the signature of s over a precertificate entry for tbs.
+func (s *sct) verify(key crypto.PublicKey, issuerKeyHash [sha256.Size]byte, tbs []byte) error {
+	// hash_algorithm sha256(4).
+	if s.hashAlg != 4 {
+		return fmt.Errorf("unsupported SCT hash algorithm %d", s.hashAlg)
+	}
+	digest := sha256.Sum256(s.signedData(issuerKeyHash, tbs))
+
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		if s.sigAlg != 3 {
+			return fmt.Errorf("SCT signature algorithm %d does not match the ECDSA log key", s.sigAlg)
+		}
+		var sig struct{ R, S *big.Int }
+		if rest, err := asn1.Unmarshal(s.signature, &sig); err != nil || len(rest) != 0 {
+			return errors.New("malformed SCT signature")
+		}
+		if !ecdsa.Verify(key, digest[:], sig.R, sig.S) {
+			return errors.New("invalid SCT signature")
+		}
+	case *rsa.PublicKey:
+		if s.sigAlg != 1 {
+			return fmt.Errorf("SCT signature algorithm %d does not match the RSA log key", s.sigAlg)
+		}
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], s.signature); err != nil {
+			return errors.New("invalid SCT signature")
+		}
+	default:
+		return fmt.Errorf("unsupported log key type %T", key)
+	}
+	return nil
+}
+
+// signedData returns the data the log signed for s, for a precertificate
+// entry for tbs.
+func (s *sct) signedData(issuerKeyHash [sha256.Size]byte, tbs []byte) []byte {
+	var b bytes.Buffer
+	b.WriteByte(0) // sct_version v1
+	b.WriteByte(0) // signature_type certificate_timestamp
+	binary.Write(&b, binary.BigEndian, s.timestamp)
+	binary.Write(&b, binary.BigEndian, uint16(1)) // entry_type precert_entry
+	b.Write(issuerKeyHash[:])
+	b.Write([]byte{byte(len(tbs) >> 16), byte(len(tbs) >> 8), byte(len(tbs))})
+	b.Write(tbs)
+	binary.Write(&b, binary.BigEndian, uint16(len(s.extensions)))
+	b.Write(s.extensions)
+	return b.Bytes()
+}
+
+// parseSCTList decodes a SignedCertificateTimestampList.
+func parseSCTList(b []byte) ([]*sct, error) {
+	list, rest, err := readVector(b, 2)
+	if err != nil || len(rest) != 0 {
+		return nil, errors.New("malformed SCT list")
+	}
+	var scts []*sct
+	for len(list) > 0 {
+		var raw []byte
+		if raw, list, err = readVector(list, 2); err != nil {
+			return nil, errors.New("malformed SCT list")
+		}
+		s, err := parseSCT(raw)
+		if err != nil {
+			return nil, err
+		}
+		scts = append(scts, s)
+	}
+	return scts, nil
+}
+
+// parseSCT decodes a v1 SignedCertificateTimestamp.
+func parseSCT(b []byte) (*sct, error) {
+	if len(b) < 1+sha256.Size+8 || b[0] != 0 {
+		return nil, errors.New("malformed or unsupported SCT")
+	}
+	s := &sct{timestamp: binary.BigEndian.Uint64(b[1+sha256.Size:])}
+	copy(s.logID[:], b[1:])
+	rest := b[1+sha256.Size+8:]
+	var err error
+	if s.extensions, rest, err = readVector(rest, 2); err != nil || len(rest) < 2 {
+		return nil, errors.New("malformed SCT")
+	}
+	s.hashAlg, s.sigAlg = rest[0], rest[1]
+	if s.signature, rest, err = readVector(rest[2:], 2); err != nil || len(rest) != 0 {
+		return nil, errors.New("malformed SCT signature")
+	}
+	return s, nil
+}
+
+// readVector reads a TLS vector with a length prefix of size bytes.
+func readVector(b []byte, size int) ([]byte, []byte, error) {
+	if len(b) < size {
+		return nil, nil, errors.New("truncated length")
+	}
+	var n int
+	for _, c := range b[:size] {
+		n = n<<8 | int(c)
+	}
+	b = b[size:]
+	if len(b) < n {
+		return nil, nil, errors.New("truncated vector")
+	}
+	return b[:n], b[n:], nil
+}
+
+// precertTBS reconstructs the TBSCertificate of the precertificate the SCTs
+// were issued for, which is the TBSCertificate of the final certificate
+// without the SCT list extension.
+func precertTBS(raw []byte) ([]byte, error) {
+	var tbs asn1.RawValue
+	if _, err := asn1.Unmarshal(raw, &tbs); err != nil {
+		return nil, fmt.Errorf("could not decode TBSCertificate: %v", err)
+	}
+	var fields []byte
+	for rest := tbs.Bytes; len(rest) > 0; {
+		var field asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &field); err != nil {
+			return nil, fmt.Errorf("could not decode TBSCertificate: %v", err)
+		}
+		if field.Class != asn1.ClassContextSpecific || field.Tag != 3 {
+			fields = append(fields, field.FullBytes...)
+			continue
+		}
+		// Remove the SCT list from the explicitly tagged extensions.
+		var exts asn1.RawValue
+		if _, err := asn1.Unmarshal(field.Bytes, &exts); err != nil {
+			return nil, fmt.Errorf("could not decode extensions: %v", err)
+		}
+		var kept []byte
+		for extRest := exts.Bytes; len(extRest) > 0; {
+			var ext pkix.Extension
+			start := extRest
+			if extRest, err = asn1.Unmarshal(extRest, &ext); err != nil {
+				return nil, fmt.Errorf("could not decode extension: %v", err)
+			}
+			if !ext.Id.Equal(oidSCTList) {
+				kept = append(kept, start[:len(start)-len(extRest)]...)
+			}
+		}
+		if len(kept) == 0 {
+			// Extensions must not be empty if present.
+			continue
+		}
+		seq, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: kept})
+		if err != nil {
+			return nil, err
+		}
+		tagged, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 3, IsCompound: true, Bytes: seq})
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, tagged...)
+	}
+	return asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: fields})
+}
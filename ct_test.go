@@ -0,0 +1,154 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// marshalSCTList encodes scts as the value of the SCT list extension.
+func marshalSCTList(t *testing.T, scts ...*sct) []byte {
+	t.Helper()
+	var list []byte
+	for _, s := range scts {
+		b := []byte{0}
+		b = append(b, s.logID[:]...)
+		b = append(b, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(b[1+sha256.Size:], s.timestamp)
+		b = append(b, byte(len(s.extensions)>>8), byte(len(s.extensions)))
+		b = append(b, s.extensions...)
+		b = append(b, s.hashAlg, s.sigAlg, byte(len(s.signature)>>8), byte(len(s.signature)))
+		b = append(b, s.signature...)
+		list = append(list, byte(len(b)>>8), byte(len(b)))
+		list = append(list, b...)
+	}
+	list = append([]byte{byte(len(list) >> 8), byte(len(list))}, list...)
+	v, err := asn1.Marshal(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestVerifyEmbeddedSCTs(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	logKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate log key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sct test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	create := func(exts []pkix.Extension) *x509.Certificate {
+		tmpl.ExtraExtensions = exts
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+		if err != nil {
+			t.Fatalf("failed to create test certificate: %v", err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatalf("failed to parse test certificate: %v", err)
+		}
+		return cert
+	}
+
+	// The log signs the precertificate, which is the certificate without
+	// the SCT list.
+	precert := create(nil)
+	log := CTLog{Description: "test log", Key: &logKey.PublicKey}
+	id, err := log.id()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &sct{logID: id, timestamp: 1500000000000, hashAlg: 4, sigAlg: 3}
+	digest := sha256.Sum256(s.signedData(sha256.Sum256(precert.RawSubjectPublicKeyInfo), precert.RawTBSCertificate))
+	r, ss, err := ecdsa.Sign(rand.Reader, logKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.signature, err = asn1.Marshal(struct{ R, S *big.Int }{r, ss}); err != nil {
+		t.Fatal(err)
+	}
+	bad := *s
+	bad.timestamp++
+	cert := create([]pkix.Extension{{Id: oidSCTList, Value: marshalSCTList(t, s, &bad)}})
+
+	results, err := VerifyEmbeddedSCTs(cert, cert, []CTLog{log})
+	if err != nil {
+		t.Fatalf("VerifyEmbeddedSCTs returned %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("VerifyEmbeddedSCTs returned %d results, want 2", len(results))
+	}
+	if !results[0].Valid() || results[0].Log != "test log" || results[0].Timestamp.Unix() != 1500000000 {
+		t.Errorf("unexpected result for the valid SCT: %+v", results[0])
+	}
+	if results[1].Valid() {
+		t.Error("SCT with a modified timestamp verified, want error")
+	}
+
+	results, err = VerifyEmbeddedSCTs(cert, cert, nil)
+	if err != nil {
+		t.Fatalf("VerifyEmbeddedSCTs returned %v", err)
+	}
+	if results[0].Valid() || results[0].LogID != base64.StdEncoding.EncodeToString(id[:]) {
+		t.Errorf("unexpected result for an SCT from an unknown log: %+v", results[0])
+	}
+
+	if _, err := VerifyEmbeddedSCTs(precert, precert, []CTLog{log}); err != ErrNoSCTs {
+		t.Errorf("VerifyEmbeddedSCTs for a certificate without SCTs returned %v, want %v", err, ErrNoSCTs)
+	}
+}
+
+func TestParseCTLogList(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate log key: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fmt.Sprintf(`{"operators": [{"name": "Test", "logs": [{"description": "Test Log", "key": %q}]}]}`, base64.StdEncoding.EncodeToString(der))
+	logs, err := ParseCTLogList([]byte(data))
+	if err != nil {
+		t.Fatalf("ParseCTLogList returned %v", err)
+	}
+	if len(logs) != 1 || logs[0].Description != "Test Log" {
+		t.Fatalf("ParseCTLogList returned unexpected logs: %+v", logs)
+	}
+	if _, err := ParseCTLogList([]byte(`{"operators": [{"logs": [{"key": "AAAA"}]}]}`)); err == nil {
+		t.Error("ParseCTLogList succeeded with an invalid key, want error")
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+var (
+	oidSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+)
+
+// CTL is a certificate trust list, which lists subjects, usually
+// certificates, that are trusted for a set of usages.
+type CTL struct {
+	// Usages are the purposes the subjects are trusted for.
+	Usages         []asn1.ObjectIdentifier
+	ListIdentifier []byte
+	SequenceNumber *big.Int
+	ThisUpdate     time.Time
+	// NextUpdate is the zero time if the CTL does not specify it.
+	NextUpdate time.Time
+	// SubjectAlgorithm is the hash algorithm used to compute Subjects.
+	SubjectAlgorithm asn1.ObjectIdentifier
+	// Subjects identify the trusted subjects, usually by certificate hash.
+	Subjects [][]byte
+	// Raw is the encoded CTL message, which can be installed with AddCTL.
+	Raw []byte
+}
+
+// ctlContent is the CertificateTrustList structure signed in a CTL message.
+type ctlContent struct {
+	Version          int `asn1:"optional,default:0"`
+	SubjectUsage     []asn1.ObjectIdentifier
+	ListIdentifier   []byte   `asn1:"optional"`
+	SequenceNumber   *big.Int `asn1:"optional"`
+	ThisUpdate       time.Time
+	NextUpdate       time.Time `asn1:"optional"`
+	SubjectAlgorithm pkix.AlgorithmIdentifier
+	TrustedSubjects  []trustedSubject `asn1:"optional"`
+	Extensions       []pkix.Extension `asn1:"optional,explicit,tag:0"`
+}
+
+type trustedSubject struct {
+	Identifier []byte
+	Attributes asn1.RawValue `asn1:"optional"`
+}
+
+// parseCTL decodes content, the CertificateTrustList signed in the CTL
+// message raw.
+func parseCTL(raw, content []byte) (*CTL, error) {
+	var c ctlContent
+	rest, err := asn1.Unmarshal(content, &c)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode CTL: %v", err)
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("trailing data after CTL")
+	}
+	ctl := &CTL{
+		Usages:           c.SubjectUsage,
+		ListIdentifier:   c.ListIdentifier,
+		SequenceNumber:   c.SequenceNumber,
+		ThisUpdate:       c.ThisUpdate,
+		NextUpdate:       c.NextUpdate,
+		SubjectAlgorithm: c.SubjectAlgorithm.Algorithm,
+		Raw:              raw,
+	}
+	for _, s := range c.TrustedSubjects {
+		ctl.Subjects = append(ctl.Subjects, s.Identifier)
+	}
+	return ctl, nil
+}
+
+// Contains reports whether cert is one of the subjects of the CTL. Only CTLs
+// that identify subjects by their SHA1 or SHA256 hash are supported.
+func (c *CTL) Contains(cert *x509.Certificate) bool {
+	var id []byte
+	switch {
+	case c.SubjectAlgorithm.Equal(oidSHA1):
+		sum := sha1.Sum(cert.Raw)
+		id = sum[:]
+	case c.SubjectAlgorithm.Equal(oidSHA256):
+		sum := sha256.Sum256(cert.Raw)
+		id = sum[:]
+	default:
+		return false
+	}
+	for _, s := range c.Subjects {
+		if bytes.Equal(s, id) {
+			return true
+		}
+	}
+	return false
+}
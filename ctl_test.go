@@ -0,0 +1,74 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"testing"
+	"time"
+)
+
+func TestParseCTL(t *testing.T) {
+	cert := selfSigned(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "ctl test"},
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(time.Hour),
+	})
+	other := selfSigned(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "ctl other"},
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(time.Hour),
+	})
+	hash := sha1.Sum(cert.Raw)
+	serverAuth := asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 1}
+	thisUpdate := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	content, err := asn1.Marshal(struct {
+		SubjectUsage     []asn1.ObjectIdentifier
+		ListIdentifier   []byte
+		ThisUpdate       time.Time
+		SubjectAlgorithm pkix.AlgorithmIdentifier
+		TrustedSubjects  []trustedSubject
+	}{
+		SubjectUsage:     []asn1.ObjectIdentifier{serverAuth},
+		ListIdentifier:   []byte("corp"),
+		ThisUpdate:       thisUpdate,
+		SubjectAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1},
+		TrustedSubjects:  []trustedSubject{{Identifier: hash[:]}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctl, err := parseCTL([]byte("raw"), content)
+	if err != nil {
+		t.Fatalf("parseCTL returned %v", err)
+	}
+	if len(ctl.Usages) != 1 || !ctl.Usages[0].Equal(serverAuth) {
+		t.Errorf("unexpected usages %v", ctl.Usages)
+	}
+	if string(ctl.ListIdentifier) != "corp" || !ctl.ThisUpdate.Equal(thisUpdate) || !ctl.NextUpdate.IsZero() || ctl.SequenceNumber != nil {
+		t.Errorf("unexpected CTL %+v", ctl)
+	}
+	if !ctl.Contains(cert) || ctl.Contains(other) {
+		t.Error("Contains returned unexpected results")
+	}
+
+	if _, err := parseCTL(nil, content[:len(content)-1]); err == nil {
+		t.Error("parseCTL succeeded with truncated content, want error")
+	}
+}
@@ -0,0 +1,89 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	certEnumCTLsInStore      = crypt32.MustFindProc("CertEnumCTLsInStore")
+	certAddEncodedCTLToStore = crypt32.MustFindProc("CertAddEncodedCTLToStore")
+)
+
+// CTLs returns the certificate trust lists in the store identified by loc.
+func (w *WinCertStore) CTLs(loc StoreLocation) ([]*CTL, error) {
+	certStore, err := openStore(loc, w.lookupFlags())
+	if err != nil {
+		return nil, fmt.Errorf("CertOpenStore for %s returned %v", loc, err)
+	}
+	defer windows.CertCloseStore(certStore, 0)
+
+	var ctls []*CTL
+	// CertEnumCTLsInStore frees the previous context, and the last call
+	// returns nil, so no context needs to be freed after the loop.
+	var prev uintptr
+	for {
+		h, _, _ := certEnumCTLsInStore.Call(uintptr(certStore), prev)
+		if h == 0 {
+			return ctls, nil
+		}
+		prev = h
+		// The context is owned by crypt32, unsafe.Add turns the returned
+		// address into a pointer without a uintptr conversion.
+		c := (*ctlContext)(unsafe.Add(nil, h))
+		raw := append([]byte(nil), unsafe.Slice(c.ctlEncoded, c.ctlEncodedSz)...)
+		content := unsafe.Slice(c.ctlContent, c.ctlContentSz)
+		ctl, err := parseCTL(raw, content)
+		if err != nil {
+			logWarning("Skipping CTL that could not be parsed.", opField("ctls"), field("store", loc), errField(err))
+			continue
+		}
+		ctls = append(ctls, ctl)
+	}
+}
+
+// AddCTL installs the encoded CTL message in the store identified by loc,
+// replacing an existing CTL with the same usages and list identifier.
+func (w *WinCertStore) AddCTL(encoded []byte, loc StoreLocation) (*Result, error) {
+	res := &Result{Operation: "addctl"}
+	if err := checkNotEmpty("AddCTL", "encoded", encoded); err != nil {
+		return res, err
+	}
+	certStore, err := openStore(loc, w.openFlags(0))
+	if err != nil {
+		return res, fmt.Errorf("CertOpenStore for %s returned %v", loc, err)
+	}
+	defer windows.CertCloseStore(certStore, 0)
+
+	r, _, err := certAddEncodedCTLToStore.Call(
+		uintptr(certStore),
+		encodingX509ASN|encodingPKCS7,
+		uintptr(unsafe.Pointer(&encoded[0])),
+		uintptr(len(encoded)),
+		windows.CERT_STORE_ADD_REPLACE_EXISTING,
+		0)
+	if r == 0 {
+		return res, fmt.Errorf("CertAddEncodedCTLToStore returned %v", err)
+	}
+	res.addChange(ActionAdded, "", loc.String())
+	logInfo("Installed CTL.", opField("addctl"), field("store", loc))
+	return res, nil
+}
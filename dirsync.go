@@ -0,0 +1,116 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// certFileExtensions are the extensions of the files read by SyncFromDir.
+var certFileExtensions = map[string]bool{".pem": true, ".crt": true, ".cer": true, ".der": true}
+
+// readCertDir reads the certificates in the files of dir with one of
+// certFileExtensions, keyed by thumbprint. Files may hold one DER encoded
+// certificate or any number of PEM encoded ones. Files that cannot be parsed
+// are reported as warnings rather than failing the sync, so one bad file does
+// not remove every certificate.
+func readCertDir(dir string) (map[string]*x509.Certificate, []string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	certs := make(map[string]*x509.Certificate)
+	var warnings []string
+	for _, fi := range files {
+		if fi.IsDir() || !certFileExtensions[strings.ToLower(filepath.Ext(fi.Name()))] {
+			continue
+		}
+		path := filepath.Join(dir, fi.Name())
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		parsed, err := parseCertFile(b)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("skipped %s: %v", path, err))
+			continue
+		}
+		for _, c := range parsed {
+			certs[thumbprint(c)] = c
+		}
+	}
+	return certs, warnings, nil
+}
+
+// parseCertFile parses the PEM or DER encoded certificates in b.
+func parseCertFile(b []byte) ([]*x509.Certificate, error) {
+	if !bytes.Contains(b, []byte("-----BEGIN")) {
+		c, err := x509.ParseCertificate(b)
+		if err != nil {
+			return nil, err
+		}
+		return []*x509.Certificate{c}, nil
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found")
+	}
+	return certs, nil
+}
+
+// syncPlan returns the certificates of want that are not in the store yet,
+// and the thumbprints of the managed certificates that are no longer wanted,
+// both sorted by thumbprint. existing holds the thumbprints of all
+// certificates in the store, managed those with the friendly name prefix of
+// the sync.
+func syncPlan(want map[string]*x509.Certificate, existing, managed map[string]bool) ([]*x509.Certificate, []string) {
+	var add []*x509.Certificate
+	for tp, c := range want {
+		if !existing[tp] {
+			add = append(add, c)
+		}
+	}
+	sort.Slice(add, func(i, j int) bool { return thumbprint(add[i]) < thumbprint(add[j]) })
+	var remove []string
+	for tp := range managed {
+		if want[tp] == nil {
+			remove = append(remove, tp)
+		}
+	}
+	sort.Strings(remove)
+	return add, remove
+}
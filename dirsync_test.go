@@ -0,0 +1,91 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSyncFromDirPlan(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	var certs []*x509.Certificate
+	for i := 1; i <= 3; i++ {
+		tmpl := &x509.Certificate{SerialNumber: big.NewInt(int64(i)), Subject: pkix.Name{CommonName: "CA"}, NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+		if err != nil {
+			t.Fatalf("failed to create test certificate: %v", err)
+		}
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, c)
+	}
+
+	dir, err := ioutil.TempDir("", "dirsync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bundle := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certs[0].Raw}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certs[1].Raw})...)
+	for name, b := range map[string][]byte{
+		"bundle.pem": bundle,
+		"ca.der":     certs[1].Raw,
+		"broken.crt": []byte("not a certificate"),
+		"readme.txt": []byte("ignored"),
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, warnings, err := readCertDir(dir)
+	if err != nil {
+		t.Fatalf("readCertDir returned %v", err)
+	}
+	if len(want) != 2 || want[thumbprint(certs[0])] == nil || want[thumbprint(certs[1])] == nil {
+		t.Errorf("readCertDir returned %d certificates, want the first two", len(want))
+	}
+	if len(warnings) != 1 {
+		t.Errorf("readCertDir returned warnings %v, want one for broken.crt", warnings)
+	}
+
+	// certs[1] is already installed without the prefix, certs[2] is managed
+	// but no longer wanted.
+	existing := map[string]bool{thumbprint(certs[1]): true, thumbprint(certs[2]): true}
+	managed := map[string]bool{thumbprint(certs[2]): true}
+	add, remove := syncPlan(want, existing, managed)
+	if len(add) != 1 || add[0] != want[thumbprint(certs[0])] {
+		t.Errorf("syncPlan adds %d certificates, want only the first", len(add))
+	}
+	if !reflect.DeepEqual(remove, []string{thumbprint(certs[2])}) {
+		t.Errorf("syncPlan removes %v, want: [%s]", remove, thumbprint(certs[2]))
+	}
+}
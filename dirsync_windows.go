@@ -0,0 +1,173 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// DirSyncOptions configures SyncFromDir.
+type DirSyncOptions struct {
+	// Store is the store that is synced, such as LocalMachine\CA.
+	Store StoreLocation
+	// Prefix is the friendly name prefix of the certificates managed by the
+	// sync. Installed certificates are named Prefix followed by their
+	// thumbprint, and only certificates whose friendly name starts with
+	// Prefix are removed. It must not be empty.
+	Prefix string
+	// DryRun reports the changes in the Result without making them.
+	DryRun bool
+}
+
+// SyncFromDir makes the certificates managed in opts.Store match the PEM and
+// DER files in dir, such as a directory maintained by configuration
+// management: certificates in dir that are not in the store are installed,
+// and managed certificates that are no longer in dir are removed. The Result
+// lists the changes, even if a later change failed, and warns about files
+// that could not be parsed.
+func (w *WinCertStore) SyncFromDir(dir string, opts DirSyncOptions) (*Result, error) {
+	res := &Result{Operation: "syncfromdir", dryRun: opts.DryRun}
+	if opts.Prefix == "" {
+		return res, &ArgError{Op: "SyncFromDir", Arg: "opts", Reason: "prefix is empty"}
+	}
+	if opts.Store.Name == "" {
+		return res, &ArgError{Op: "SyncFromDir", Arg: "opts", Reason: "store is empty"}
+	}
+	want, warnings, err := readCertDir(dir)
+	if err != nil {
+		return res, err
+	}
+	for _, warning := range warnings {
+		res.warnf("%s", warning)
+	}
+
+	return res, w.syncStore(opts.Store, want, opts.Prefix, opts.DryRun, res)
+}
+
+// syncStore makes the certificates of loc whose friendly name starts with
+// prefix match want: certificates in want that are not in the store are
+// installed with the name prefix followed by their thumbprint, and managed
+// certificates that are not in want are removed. The changes are recorded
+// in res, which is also used for the operation name in logs.
+func (w *WinCertStore) syncStore(loc StoreLocation, want map[string]*x509.Certificate, prefix string, dryRun bool, res *Result) error {
+	flags := uint32(0)
+	if dryRun {
+		flags = certStoreOpenExisting | certStoreReadOnly
+	}
+	certStore, err := openStore(loc, w.openFlags(flags))
+	if err != nil {
+		return fmt.Errorf("CertOpenStore for %s returned %v", loc, err)
+	}
+	defer windows.CertCloseStore(certStore, 0)
+
+	existing, managed, err := managedCerts(certStore, loc, prefix)
+	if err != nil {
+		return err
+	}
+	add, remove := syncPlan(want, existing, managed)
+	store := loc.String()
+
+	for _, c := range add {
+		tp := thumbprint(c)
+		if !dryRun {
+			if err := addNamedCert(certStore, c.Raw, prefix+tp); err != nil {
+				return fmt.Errorf("adding certificate %s: %v", tp, err)
+			}
+			logInfo("Installed managed certificate.", opField(res.Operation), thumbprintField(tp), field("store", store))
+		}
+		res.addChange(ActionAdded, tp, store)
+	}
+	for _, tp := range remove {
+		if !dryRun {
+			if err := removeByThumbprint(certStore, tp); err != nil {
+				return fmt.Errorf("removing certificate %s: %v", tp, err)
+			}
+			logInfo("Removed managed certificate.", opField(res.Operation), thumbprintField(tp), field("store", store))
+		}
+		res.addChange(ActionRemoved, tp, store)
+	}
+	return nil
+}
+
+// managedCerts returns the thumbprints of all certificates in certStore, and
+// of those whose friendly name starts with prefix.
+func managedCerts(certStore windows.Handle, loc StoreLocation, prefix string) (existing, managed map[string]bool, err error) {
+	existing = make(map[string]bool)
+	managed = make(map[string]bool)
+	// findCert frees prev, so no context needs to be freed after the loop.
+	var prev *windows.CertContext
+	for {
+		nc, err := findCert(certStore, encodingX509ASN|encodingPKCS7, 0, findAny, nil, prev)
+		if err != nil {
+			return nil, nil, fmt.Errorf("finding certificates in %s: %v", loc, err)
+		}
+		if nc == nil {
+			return existing, managed, nil
+		}
+		prev = nc
+		tp := contextThumbprint(nc)
+		existing[tp] = true
+		fn, err := friendlyName(nc)
+		if err != nil {
+			windows.CertFreeCertificateContext(nc)
+			return nil, nil, err
+		}
+		if strings.HasPrefix(fn, prefix) {
+			managed[tp] = true
+		}
+	}
+}
+
+// addNamedCert adds the DER encoded certificate der to certStore with the
+// friendly name name.
+func addNamedCert(certStore windows.Handle, der []byte, name string) error {
+	certContext, err := windows.CertCreateCertificateContext(encodingX509ASN|encodingPKCS7, &der[0], uint32(len(der)))
+	if err != nil {
+		return fmt.Errorf("CertCreateCertificateContext returned %v", err)
+	}
+	defer windows.CertFreeCertificateContext(certContext)
+	var added *windows.CertContext
+	if err := windows.CertAddCertificateContextToStore(certStore, certContext, windows.CERT_STORE_ADD_NEW, &added); err != nil {
+		return fmt.Errorf("CertAddCertificateContextToStore returned %v", err)
+	}
+	props := &CertProperties{certContext: added}
+	defer props.Close()
+	return props.SetFriendlyName(name)
+}
+
+// removeByThumbprint removes the certificate with the hex encoded SHA1
+// thumbprint tp from certStore. It is not an error if the certificate is
+// already gone.
+func removeByThumbprint(certStore windows.Handle, tp string) error {
+	hash, err := hex.DecodeString(tp)
+	if err != nil {
+		return err
+	}
+	blob := cryptDataBlob{cbData: uint32(len(hash)), pbData: &hash[0]}
+	nc, err := findCert(certStore, encodingX509ASN|encodingPKCS7, 0, findSHA1Hash, unsafe.Pointer(&blob), nil)
+	if err != nil || nc == nil {
+		return err
+	}
+	// removeCert frees the context.
+	return removeCert(nc)
+}
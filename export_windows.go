@@ -0,0 +1,80 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import "errors"
+
+const (
+	// ncrypt.h export policy flags
+	ncryptAllowExportFlag          = 0x1 // NCRYPT_ALLOW_EXPORT_FLAG
+	ncryptAllowPlaintextExportFlag = 0x2 // NCRYPT_ALLOW_PLAINTEXT_EXPORT_FLAG
+)
+
+const (
+	bCryptRSAFullPrivateBlob = "RSAFULLPRIVATEBLOB" // BCRYPT_RSAFULLPRIVATE_BLOB
+	bCryptECCPrivateBlob     = "ECCPRIVATEBLOB"     // BCRYPT_ECCPRIVATE_BLOB
+)
+
+var (
+	errPrivateExportNotAllowed   = errors.New("private key export is not allowed, open the store with AllowPrivateExport to enable it")
+	errPlaintextExportNotAllowed = errors.New("private key export is not allowed, generate the key with PlaintextExportAllowed to enable it")
+)
+
+// ExportFullPrivateBlob exports the private key as a BCRYPT_RSAFULLPRIVATE_BLOB,
+// which includes the CRT parameters needed to convert it to PKCS #8. It fails
+// unless the store was opened with AllowPrivateExport and the export policy of
+// the key allows plaintext export, see GenerateOpts.Export. Every attempt is
+// logged. The caller should Wipe the blob once it is done with it.
+func (k *RsaKey) ExportFullPrivateBlob() ([]byte, error) {
+	return exportPrivateBlob(k.handle, k.Container, k.allowExport, bCryptRSAFullPrivateBlob)
+}
+
+// ExportFullPrivateBlob exports the private key as a BCRYPT_ECCPRIVATE_BLOB.
+// It fails unless the store was opened with AllowPrivateExport and the export
+// policy of the key allows plaintext export, see GenerateOpts.Export. Every
+// attempt is logged. The caller should Wipe the blob once it is done with it.
+func (k *EcdsaKey) ExportFullPrivateBlob() ([]byte, error) {
+	return exportPrivateBlob(k.handle, k.Container, k.allowExport, bCryptECCPrivateBlob)
+}
+
+func exportPrivateBlob(kh uintptr, container string, allowed bool, blobType string) ([]byte, error) {
+	if !allowed {
+		logWarning("Denied private key export.", opField("exportprivate"), containerField(container))
+		return nil, errPrivateExportNotAllowed
+	}
+	policy, err := getPropertyUint32(kh, "Export Policy")
+	if err != nil {
+		return nil, err
+	}
+	if policy&ncryptAllowPlaintextExportFlag == 0 {
+		logWarning("Denied private key export.", opField("exportprivate"), containerField(container))
+		return nil, errPlaintextExportNotAllowed
+	}
+	blob, err := exportKey(kh, blobType)
+	if err != nil {
+		logError("Private key export failed.", opField("exportprivate"), containerField(container), errField(err))
+		return nil, err
+	}
+	logWarning("Exported private key material.", opField("exportprivate"), containerField(container))
+	return blob, nil
+}
+
+// setExportPolicy sets the export policy of a key that has not been
+// finalized yet.
+func setExportPolicy(kh uintptr, export ExportPolicy) error {
+	return setPropertyUint32(kh, "Export Policy", uint32(export), ncryptPersistFlag)
+}
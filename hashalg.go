@@ -0,0 +1,74 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto"
+	"fmt"
+	"sync"
+)
+
+var (
+	hashAlgMu sync.RWMutex
+	// hashAlgs maps crypto.Hash values to the bcrypt.h algorithm names passed
+	// to the provider in padding info structs.
+	hashAlgs = map[crypto.Hash]string{
+		crypto.MD5:    "MD5",    // BCRYPT_MD5_ALGORITHM
+		crypto.SHA1:   "SHA1",   // BCRYPT_SHA1_ALGORITHM
+		crypto.SHA256: "SHA256", // BCRYPT_SHA256_ALGORITHM
+		crypto.SHA384: "SHA384", // BCRYPT_SHA384_ALGORITHM
+		crypto.SHA512: "SHA512", // BCRYPT_SHA512_ALGORITHM
+	}
+)
+
+// RegisterHashAlgorithm makes keys accept digests of hash for signing and
+// decryption, passing name to the provider as the CNG algorithm identifier.
+// It replaces any name already registered for hash. Whether the provider
+// accepts the algorithm is only known when the key is used.
+func RegisterHashAlgorithm(hash crypto.Hash, name string) error {
+	if hash == 0 {
+		return &ArgError{Op: "RegisterHashAlgorithm", Arg: "hash", Reason: "hash is zero"}
+	}
+	if name == "" {
+		return &ArgError{Op: "RegisterHashAlgorithm", Arg: "name", Reason: "name is empty"}
+	}
+	hashAlgMu.Lock()
+	defer hashAlgMu.Unlock()
+	hashAlgs[hash] = name
+	return nil
+}
+
+// HashAlgorithms returns the registered hashes and their CNG algorithm
+// identifiers.
+func HashAlgorithms() map[crypto.Hash]string {
+	hashAlgMu.RLock()
+	defer hashAlgMu.RUnlock()
+	m := make(map[crypto.Hash]string, len(hashAlgs))
+	for h, name := range hashAlgs {
+		m[h] = name
+	}
+	return m
+}
+
+// hashAlgorithm returns the CNG algorithm identifier registered for hash.
+func hashAlgorithm(hash crypto.Hash) (string, error) {
+	hashAlgMu.RLock()
+	name, ok := hashAlgs[hash]
+	hashAlgMu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("unsupported hash algorithm %v", hash)
+	}
+	return name, nil
+}
@@ -0,0 +1,47 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto"
+	"testing"
+)
+
+func TestRegisterHashAlgorithm(t *testing.T) {
+	if _, err := hashAlgorithm(crypto.SHA512_256); err == nil {
+		t.Fatal("hashAlgorithm(SHA512_256) succeeded before registration, want error")
+	}
+	if err := RegisterHashAlgorithm(crypto.SHA512_256, "SHA512_256"); err != nil {
+		t.Fatalf("RegisterHashAlgorithm returned %v", err)
+	}
+	defer func() {
+		hashAlgMu.Lock()
+		delete(hashAlgs, crypto.SHA512_256)
+		hashAlgMu.Unlock()
+	}()
+	if got, err := hashAlgorithm(crypto.SHA512_256); err != nil || got != "SHA512_256" {
+		t.Errorf("hashAlgorithm(SHA512_256) = %q, %v, want: SHA512_256", got, err)
+	}
+	if got := HashAlgorithms()[crypto.MD5]; got != "MD5" {
+		t.Errorf("HashAlgorithms()[MD5] = %q, want: MD5", got)
+	}
+
+	if err := RegisterHashAlgorithm(0, "SHA256"); err == nil {
+		t.Error("RegisterHashAlgorithm succeeded with a zero hash, want error")
+	}
+	if err := RegisterHashAlgorithm(crypto.SHA3_256, ""); err == nil {
+		t.Error("RegisterHashAlgorithm succeeded with an empty name, want error")
+	}
+}
@@ -0,0 +1,412 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Operations an elevated helper or a remote management server performs on
+// behalf of its clients, see ServeHelper and ServeRemote.
+const (
+	// HelperOpStore stores a certificate and its intermediate like
+	// WinCertStore.Store.
+	HelperOpStore = "store"
+	// HelperOpRemove removes the current certificate like
+	// WinCertStore.Remove.
+	HelperOpRemove = "remove"
+	// HelperOpInventory lists the certificates of a store like
+	// WinCertStore.EnumerateCerts.
+	HelperOpInventory = "inventory"
+	// HelperOpRotate generates a replacement for an old key like
+	// WinCertStore.RotateIfOlderThan.
+	HelperOpRotate = "rotate"
+)
+
+// maxHelperMessage bounds a single request or response, which leaves room
+// for the inventory of a large store.
+const maxHelperMessage = 16 << 20
+
+// maxHelperResponses is the number of responses a helper keeps for retries.
+const maxHelperResponses = 64
+
+// helperRequest is one operation requested from the helper. Requests are
+// sent as one JSON object per line.
+type helperRequest struct {
+	// ID identifies the request. A client that lost its connection resends
+	// the request with the same ID, and the helper answers with the response
+	// it already sent instead of repeating the change.
+	ID           string `json:"id"`
+	Op           string `json:"op"`
+	Cert         []byte `json:"cert,omitempty"`
+	Intermediate []byte `json:"intermediate,omitempty"`
+	RemoveSystem bool   `json:"remove_system,omitempty"`
+	// Store is the store listed by an inventory, such as LocalMachine\MY.
+	Store  string           `json:"store,omitempty"`
+	Filter *InventoryFilter `json:"filter,omitempty"`
+	MaxAge time.Duration    `json:"max_age,omitempty"`
+}
+
+// helperResponse is the answer to a helperRequest with the same ID.
+type helperResponse struct {
+	ID     string  `json:"id"`
+	Result *Result `json:"result,omitempty"`
+	// Certs are the DER encoded certificates found by an inventory, and
+	// Provenance their provenance records, nil for those without one.
+	Certs      [][]byte      `json:"certs,omitempty"`
+	Provenance []*Provenance `json:"provenance,omitempty"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// newHelperRequestID returns a random request ID.
+func newHelperRequestID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (r *helperRequest) validate() error {
+	if r.ID == "" || len(r.ID) > 64 {
+		return errors.New("request ID must have 1 to 64 characters")
+	}
+	switch r.Op {
+	case HelperOpStore:
+		if len(r.Cert) == 0 || len(r.Intermediate) == 0 {
+			return errors.New("store requires a certificate and an intermediate")
+		}
+	case HelperOpRemove:
+		if len(r.Cert) > 0 || len(r.Intermediate) > 0 {
+			return errors.New("remove takes no certificates")
+		}
+	case HelperOpInventory:
+		if r.Store == "" {
+			return errors.New("inventory requires a store")
+		}
+		if r.Filter != nil {
+			if err := r.Filter.validate(); err != nil {
+				return err
+			}
+		}
+	case HelperOpRotate:
+		if r.MaxAge <= 0 {
+			return errors.New("rotate requires a positive maximum key age")
+		}
+	default:
+		return fmt.Errorf("unsupported helper operation %q", r.Op)
+	}
+	return nil
+}
+
+// certs parses the certificates of a store request.
+func (r *helperRequest) certs() (*x509.Certificate, *x509.Certificate, error) {
+	cert, err := x509.ParseCertificate(r.Cert)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse certificate: %v", err)
+	}
+	intermediate, err := x509.ParseCertificate(r.Intermediate)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse intermediate: %v", err)
+	}
+	return cert, intermediate, nil
+}
+
+// helperExecFunc performs req and records its outcome in resp.
+type helperExecFunc func(req *helperRequest, resp *helperResponse) error
+
+// helperSessionKey identifies a request by the authenticated client that
+// sent it and its ID, so that a client can never be answered with the
+// response to the request of another client.
+type helperSessionKey struct {
+	client string
+	id     string
+}
+
+// helperSessionEntry is a request that was executed or is being executed.
+type helperSessionEntry struct {
+	// sum is the SHA256 of the request, to reject an ID reused for a
+	// different request.
+	sum  [sha256.Size]byte
+	resp *helperResponse
+	// done is closed once resp is complete.
+	done chan struct{}
+}
+
+// helperSession executes requests and remembers the most recent responses,
+// so that a request that is retried after a lost connection is executed
+// once. It is shared by all connections of a helper or a remote management
+// server, whatever client they authenticate.
+type helperSession struct {
+	exec helperExecFunc
+	// execMu makes requests execute one at a time.
+	execMu sync.Mutex
+
+	mu      sync.Mutex
+	entries map[helperSessionKey]*helperSessionEntry
+	order   []helperSessionKey
+}
+
+func newHelperSession(exec helperExecFunc) *helperSession {
+	return &helperSession{exec: exec, entries: make(map[helperSessionKey]*helperSessionEntry)}
+}
+
+// handle executes req sent by client, or returns the earlier response to the
+// request client sent with the same ID, waiting for it if the request is
+// still executing. Requests are executed one at a time.
+func (s *helperSession) handle(client string, req *helperRequest) *helperResponse {
+	resp := &helperResponse{ID: req.ID}
+	if err := req.validate(); err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+	key := helperSessionKey{client: client, id: req.ID}
+	e := &helperSessionEntry{sum: sha256.Sum256(b), resp: resp, done: make(chan struct{})}
+
+	s.mu.Lock()
+	if prev, ok := s.entries[key]; ok {
+		s.mu.Unlock()
+		if prev.sum != e.sum {
+			resp.Error = fmt.Sprintf("request ID %q was already used for a different request", req.ID)
+			return resp
+		}
+		<-prev.done
+		return prev.resp
+	}
+	s.entries[key] = e
+	s.order = append(s.order, key)
+	if len(s.order) > maxHelperResponses {
+		delete(s.entries, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.mu.Unlock()
+
+	defer close(e.done)
+	s.execMu.Lock()
+	defer s.execMu.Unlock()
+	if err := s.exec(req, resp); err != nil {
+		resp.Error = err.Error()
+	}
+	return resp
+}
+
+// newHelperScanner returns a scanner for the messages read from r.
+func newHelperScanner(r io.Reader) *bufio.Scanner {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, maxHelperMessage)
+	return sc
+}
+
+// serveHelperConn answers the requests read from rw, sent by the
+// authenticated client, until it is closed.
+func serveHelperConn(rw io.ReadWriter, s *helperSession, client string) error {
+	r := newHelperScanner(rw)
+	enc := json.NewEncoder(rw)
+	for r.Scan() {
+		var req helperRequest
+		if err := json.Unmarshal(r.Bytes(), &req); err != nil {
+			return fmt.Errorf("malformed helper request: %v", err)
+		}
+		if err := enc.Encode(s.handle(client, &req)); err != nil {
+			return err
+		}
+	}
+	return r.Err()
+}
+
+// errHelperTransport marks errors of the connection to the helper, after
+// which the request may be retried on a new connection.
+type errHelperTransport struct {
+	err error
+}
+
+func (e errHelperTransport) Error() string {
+	return fmt.Sprintf("helper connection: %v", e.err)
+}
+
+// helperCall sends req to w and waits for the response on r. The response is
+// returned even if the operation failed, its Result lists the changes made
+// before the failure.
+func helperCall(w io.Writer, r *bufio.Scanner, req *helperRequest) (*helperResponse, error) {
+	if err := json.NewEncoder(w).Encode(req); err != nil {
+		return nil, errHelperTransport{err}
+	}
+	if !r.Scan() {
+		err := r.Err()
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, errHelperTransport{err}
+	}
+	var resp helperResponse
+	if err := json.Unmarshal(r.Bytes(), &resp); err != nil {
+		return nil, errHelperTransport{fmt.Errorf("malformed helper response: %v", err)}
+	}
+	if resp.ID != req.ID {
+		return nil, errHelperTransport{fmt.Errorf("response for request %q, want %q", resp.ID, req.ID)}
+	}
+	if resp.Error != "" {
+		return &resp, fmt.Errorf("helper: %s", resp.Error)
+	}
+	return &resp, nil
+}
+
+// helperConn is a client connection to a helper or remote management
+// server. A request whose connection fails is resent once on a new
+// connection with the same ID, so the server executes it at most once. Its
+// methods are safe for concurrent use.
+type helperConn struct {
+	dial func() (io.ReadWriteCloser, error)
+
+	mu   sync.Mutex
+	conn io.ReadWriteCloser
+	sc   *bufio.Scanner
+}
+
+// connect opens the connection if there is none. c.mu must be held.
+func (c *helperConn) connect() error {
+	if c.conn != nil {
+		return nil
+	}
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	c.sc = newHelperScanner(conn)
+	return nil
+}
+
+func (c *helperConn) call(req *helperRequest) (*helperResponse, error) {
+	id, err := newHelperRequestID()
+	if err != nil {
+		return nil, err
+	}
+	req.ID = id
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for attempt := 0; ; attempt++ {
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
+		resp, err := helperCall(c.conn, c.sc, req)
+		if _, ok := err.(errHelperTransport); !ok || attempt > 0 {
+			return resp, err
+		}
+		logWarning("Helper connection failed, resuming.", opField(req.Op), errField(err))
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// result returns the Result of resp, which may be nil.
+func result(resp *helperResponse, err error) (*Result, error) {
+	if resp == nil {
+		return nil, err
+	}
+	return resp.Result, err
+}
+
+// Store asks the server to store cert and intermediate, see
+// WinCertStore.StoreWithResult.
+func (c *helperConn) Store(cert, intermediate *x509.Certificate) (*Result, error) {
+	if err := checkCert("Store", "cert", cert); err != nil {
+		return nil, err
+	}
+	if err := checkCert("Store", "intermediate", intermediate); err != nil {
+		return nil, err
+	}
+	return result(c.call(&helperRequest{Op: HelperOpStore, Cert: cert.Raw, Intermediate: intermediate.Raw}))
+}
+
+// Remove asks the server to remove the current certificate, see
+// WinCertStore.RemoveWithResult.
+func (c *helperConn) Remove(removeSystem bool) (*Result, error) {
+	return result(c.call(&helperRequest{Op: HelperOpRemove, RemoveSystem: removeSystem}))
+}
+
+// Rotate asks the server to generate a new key if its key is older than
+// maxAge, see WinCertStore.RotateIfOlderThan.
+func (c *helperConn) Rotate(maxAge time.Duration) (*Result, error) {
+	return result(c.call(&helperRequest{Op: HelperOpRotate, MaxAge: maxAge}))
+}
+
+// Inventory returns the certificates of store, such as LocalMachine\MY, that
+// match filter, see WinCertStore.EnumerateCerts.
+func (c *helperConn) Inventory(store string, filter InventoryFilter) ([]*x509.Certificate, error) {
+	resp, err := c.call(&helperRequest{Op: HelperOpInventory, Store: store, Filter: &filter})
+	if err != nil {
+		return nil, err
+	}
+	certs := make([]*x509.Certificate, 0, len(resp.Certs))
+	for _, der := range resp.Certs {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse inventory certificate: %v", err)
+		}
+		certs = append(certs, c)
+	}
+	return certs, nil
+}
+
+// ProvenanceInventory is like Inventory, but also returns the provenance of
+// each certificate, nil for those without one, see
+// WinCertStore.EnumerateProvenance.
+func (c *helperConn) ProvenanceInventory(store string, filter InventoryFilter) ([]*x509.Certificate, []*Provenance, error) {
+	resp, err := c.call(&helperRequest{Op: HelperOpInventory, Store: store, Filter: &filter})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(resp.Provenance) != len(resp.Certs) {
+		return nil, nil, fmt.Errorf("inventory returned %d provenance records for %d certificates", len(resp.Provenance), len(resp.Certs))
+	}
+	certs := make([]*x509.Certificate, 0, len(resp.Certs))
+	for _, der := range resp.Certs {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not parse inventory certificate: %v", err)
+		}
+		certs = append(certs, c)
+	}
+	return certs, resp.Provenance, nil
+}
+
+// Close closes the connection.
+func (c *helperConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
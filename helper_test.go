@@ -0,0 +1,140 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestHelperProtocol(t *testing.T) {
+	calls := 0
+	s := newHelperSession(func(req *helperRequest, resp *helperResponse) error {
+		calls++
+		resp.Result = &Result{Operation: req.Op}
+		if req.RemoveSystem {
+			return errors.New("access denied")
+		}
+		resp.Result.addChange(ActionRemoved, "7309859BA6BB16AA3BD00636FE3966D0753CC069", `LocalMachine\MY`)
+		return nil
+	})
+	server, client := net.Pipe()
+	defer client.Close()
+	go func() {
+		serveHelperConn(server, s, "client")
+		server.Close()
+	}()
+	sc := newHelperScanner(client)
+
+	req := &helperRequest{ID: "1", Op: HelperOpRemove}
+	for i := 0; i < 2; i++ {
+		resp, err := helperCall(client, sc, req)
+		if err != nil {
+			t.Fatalf("helperCall returned %v", err)
+		}
+		if resp.Result == nil || len(resp.Result.Changes) != 1 {
+			t.Errorf("helperCall returned %+v, want one change", resp.Result)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("a resent request was executed %d times, want once", calls)
+	}
+
+	resp, err := helperCall(client, sc, &helperRequest{ID: "2", Op: HelperOpRemove, RemoveSystem: true})
+	if err == nil || resp == nil || resp.Result == nil || resp.Result.Operation != HelperOpRemove {
+		t.Errorf("helperCall for a failing operation returned %+v, %v, want the result and an error", resp, err)
+	}
+	if _, ok := err.(errHelperTransport); ok {
+		t.Errorf("helperCall reported an operation error as a transport error: %v", err)
+	}
+
+	for _, req := range []*helperRequest{
+		{ID: "3", Op: "delete"},
+		{ID: "", Op: HelperOpRemove},
+		{ID: "4", Op: HelperOpStore},
+		{ID: "5", Op: HelperOpRemove, Cert: []byte{1}},
+		{ID: "6", Op: HelperOpInventory},
+		{ID: "7", Op: HelperOpInventory, Store: `LocalMachine\MY`, Filter: &InventoryFilter{Thumbprint: "AB"}},
+		{ID: "8", Op: HelperOpRotate},
+	} {
+		if _, err := helperCall(client, sc, req); err == nil {
+			t.Errorf("helperCall(%+v) returned no error", req)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("invalid requests were executed, got %d calls, want 2", calls)
+	}
+}
+
+func TestHelperSessionEviction(t *testing.T) {
+	calls := 0
+	s := newHelperSession(func(*helperRequest, *helperResponse) error {
+		calls++
+		return nil
+	})
+	for i := 0; i <= maxHelperResponses; i++ {
+		s.handle("client", &helperRequest{ID: fmt.Sprint(i), Op: HelperOpRemove})
+	}
+	s.handle("client", &helperRequest{ID: fmt.Sprint(maxHelperResponses), Op: HelperOpRemove})
+	s.handle("client", &helperRequest{ID: "0", Op: HelperOpRemove})
+	if want := maxHelperResponses + 2; calls != want {
+		t.Errorf("got %d calls, want %d: the oldest response should be evicted", calls, want)
+	}
+}
+
+func TestHelperSessionClients(t *testing.T) {
+	calls := 0
+	release := make(chan struct{})
+	s := newHelperSession(func(req *helperRequest, resp *helperResponse) error {
+		calls++
+		if req.ID == "slow" {
+			<-release
+		}
+		resp.Result = &Result{Operation: req.Op, Container: fmt.Sprint(calls)}
+		return nil
+	})
+
+	req := &helperRequest{ID: "1", Op: HelperOpRemove}
+	a := s.handle("a", req)
+	if b := s.handle("b", req); b == a || calls != 2 {
+		t.Errorf("the request of another client with the same ID was answered from the cache, got %d calls, want 2", calls)
+	}
+	if resp := s.handle("a", &helperRequest{ID: "1", Op: HelperOpRemove, RemoveSystem: true}); !strings.Contains(resp.Error, "different request") {
+		t.Errorf("reusing an ID for a different request returned %+v, want an error", resp)
+	}
+	if resp := s.handle("a", req); resp != a {
+		t.Errorf("a resent request returned %+v, want the first response %+v", resp, a)
+	}
+
+	// A retry waits for the request it repeats, and the cache of other
+	// clients stays available while it executes.
+	slow := make(chan *helperResponse, 2)
+	for i := 0; i < 2; i++ {
+		go func() { slow <- s.handle("a", &helperRequest{ID: "slow", Op: HelperOpRemove}) }()
+	}
+	if resp := s.handle("b", req); resp.Error != "" {
+		t.Errorf("a cached request of another client returned %q while a request executed", resp.Error)
+	}
+	close(release)
+	if first, second := <-slow, <-slow; first != second {
+		t.Errorf("a request and its retry returned %+v and %+v, want the same response", first, second)
+	}
+	if calls != 3 {
+		t.Errorf("got %d calls, want 3: the retry should not execute again", calls)
+	}
+}
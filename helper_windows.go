@@ -0,0 +1,317 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	advapi32                   = windows.MustLoadDLL("advapi32.dll")
+	impersonateNamedPipeClient = advapi32.MustFindProc("ImpersonateNamedPipeClient")
+)
+
+// helperPipePath returns the path of the pipe of the helper called name.
+func helperPipePath(name string) string {
+	return `\\.\pipe\certtostore-helper-` + name
+}
+
+// pipeClientUser returns the user SID of the client connected to the pipe h.
+// The client is identified by impersonating it rather than by its process
+// ID, which may be reused. A message must have been read from the pipe.
+// Impersonation changes the thread, so it runs on a thread of its own, which
+// is discarded if the impersonation cannot be reverted.
+func pipeClientUser(h windows.Handle) (string, error) {
+	type result struct {
+		user string
+		err  error
+	}
+	res := make(chan result, 1)
+	go func() {
+		runtime.LockOSThread()
+		if r, _, err := impersonateNamedPipeClient.Call(uintptr(h)); r == 0 {
+			runtime.UnlockOSThread()
+			res <- result{err: fmt.Errorf("ImpersonateNamedPipeClient returned %v", err)}
+			return
+		}
+		user, err := threadUser()
+		if err := windows.RevertToSelf(); err != nil {
+			// The thread stays locked and exits with the goroutine.
+			res <- result{err: fmt.Errorf("RevertToSelf returned %v", err)}
+			return
+		}
+		runtime.UnlockOSThread()
+		res <- result{user, err}
+	}()
+	r := <-res
+	return r.user, r.err
+}
+
+// threadUser returns the user SID of the token of the current thread.
+func threadUser() (string, error) {
+	thread, err := windows.GetCurrentThread()
+	if err != nil {
+		return "", fmt.Errorf("GetCurrentThread returned %v", err)
+	}
+	var t windows.Token
+	if err := windows.OpenThreadToken(thread, windows.TOKEN_QUERY, true, &t); err != nil {
+		return "", fmt.Errorf("OpenThreadToken returned %v", err)
+	}
+	defer t.Close()
+	u, err := t.GetTokenUser()
+	if err != nil {
+		return "", fmt.Errorf("GetTokenUser returned %v", err)
+	}
+	return u.User.Sid.String(), nil
+}
+
+// checkPipeOwner checks that the pipe h was created by LocalSystem or by an
+// elevated administrator, whose objects are owned by the Administrators
+// group. The owner is read from the pipe itself, since the token of a
+// LocalSystem server cannot be opened by an unprivileged client.
+func checkPipeOwner(h windows.Handle, path string) error {
+	sd, err := windows.GetSecurityInfo(h, windows.SE_KERNEL_OBJECT, windows.OWNER_SECURITY_INFORMATION)
+	if err != nil {
+		return fmt.Errorf("GetSecurityInfo(%s) returned %v", path, err)
+	}
+	owner, _, err := sd.Owner()
+	if err != nil {
+		return fmt.Errorf("reading the owner of %s returned %v", path, err)
+	}
+	if owner == nil || !(owner.IsWellKnown(windows.WinLocalSystemSid) || owner.IsWellKnown(windows.WinBuiltinAdministratorsSid)) {
+		return fmt.Errorf("helper %s is served by unprivileged owner %v", path, owner)
+	}
+	return nil
+}
+
+// parseStoreLocation parses a store location in the form returned by
+// StoreLocation.String, such as LocalMachine\MY.
+func parseStoreLocation(s string) (StoreLocation, error) {
+	i := strings.Index(s, `\`)
+	if i < 0 || i == len(s)-1 {
+		return StoreLocation{}, fmt.Errorf("store %q is not in the form Location\\Name", s)
+	}
+	for _, l := range []SystemLocation{LocationCurrentUser, LocationLocalMachine, LocationCurrentUserGroupPolicy, LocationLocalMachineGroupPolicy, LocationLocalMachineEnterprise} {
+		if strings.EqualFold(l.String(), s[:i]) {
+			return StoreLocation{l, s[i+1:]}, nil
+		}
+	}
+	return StoreLocation{}, fmt.Errorf("unknown store location %q", s[:i])
+}
+
+// helperExec performs the requests of helper and remote clients with store.
+func helperExec(store AdminStore) helperExecFunc {
+	return func(req *helperRequest, resp *helperResponse) error {
+		var err error
+		switch req.Op {
+		case HelperOpStore:
+			cert, intermediate, err := req.certs()
+			if err != nil {
+				return err
+			}
+			resp.Result, err = store.StoreWithResult(cert, intermediate)
+			return err
+		case HelperOpRemove:
+			resp.Result, err = store.RemoveWithResult(req.RemoveSystem)
+			return err
+		case HelperOpRotate:
+			resp.Result, err = store.RotateIfOlderThan(req.MaxAge)
+			return err
+		case HelperOpInventory:
+			loc, err := parseStoreLocation(req.Store)
+			if err != nil {
+				return err
+			}
+			var filter InventoryFilter
+			if req.Filter != nil {
+				filter = *req.Filter
+			}
+			return store.EnumerateProvenance(loc, filter, func(c *x509.Certificate, p *Provenance) error {
+				resp.Certs = append(resp.Certs, c.Raw)
+				resp.Provenance = append(resp.Provenance, p)
+				return nil
+			})
+		}
+		return fmt.Errorf("unsupported helper operation %q", req.Op)
+	}
+}
+
+// ServeHelper runs the elevated helper called name until ctx is done. It
+// performs machine store changes with store on behalf of unprivileged
+// clients that connect with DialHelper, so that only the helper needs
+// administrative rights. clients lists the user SIDs, such as
+// "S-1-5-21-...", allowed to connect. The pipe only admits LocalSystem,
+// Administrators and those users, and the user of every connecting process
+// is checked against clients before its requests are read. Only one helper
+// with a given name can run at a time.
+func ServeHelper(ctx context.Context, name string, store AdminStore, clients []string) error {
+	if err := checkAgentName("ServeHelper", name); err != nil {
+		return err
+	}
+	if len(clients) == 0 {
+		return &ArgError{Op: "ServeHelper", Arg: "clients", Reason: "no client SIDs given"}
+	}
+	allowed := make(map[string]bool)
+	sddl := "D:P(A;;GA;;;SY)(A;;GA;;;BA)"
+	for _, c := range clients {
+		sid, err := windows.StringToSid(c)
+		if err != nil {
+			return &ArgError{Op: "ServeHelper", Arg: "clients", Reason: fmt.Sprintf("%q is not a valid SID", c)}
+		}
+		allowed[sid.String()] = true
+		sddl += fmt.Sprintf("(A;;GRGW;;;%s)", sid)
+	}
+	sd, err := windows.SecurityDescriptorFromString(sddl)
+	if err != nil {
+		return fmt.Errorf("SecurityDescriptorFromString returned %v", err)
+	}
+	sa := &windows.SecurityAttributes{SecurityDescriptor: sd}
+	sa.Length = uint32(unsafe.Sizeof(*sa))
+	path := helperPipePath(name)
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+
+	session := newHelperSession(helperExec(store))
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// Wake up ConnectNamedPipe.
+			if h, err := windows.CreateFile(pathPtr, windows.GENERIC_READ, 0, nil, windows.OPEN_EXISTING, 0, 0); err == nil {
+				windows.CloseHandle(h)
+			}
+		case <-done:
+		}
+	}()
+
+	logInfo("Started helper.", opField("servehelper"), field("pipe", path), field("clients", clients))
+	// The first instance fails if the pipe exists, so that a helper never
+	// shares its pipe with another process that created it first.
+	mode := uint32(windows.PIPE_ACCESS_DUPLEX | windows.FILE_FLAG_FIRST_PIPE_INSTANCE)
+	for {
+		h, err := windows.CreateNamedPipe(pathPtr, mode, windows.PIPE_TYPE_BYTE|windows.PIPE_WAIT|windows.PIPE_REJECT_REMOTE_CLIENTS, windows.PIPE_UNLIMITED_INSTANCES, 4096, 4096, 0, sa)
+		if err != nil {
+			return fmt.Errorf("CreateNamedPipe(%s) returned %v", path, err)
+		}
+		mode &^= windows.FILE_FLAG_FIRST_PIPE_INSTANCE
+		if err := windows.ConnectNamedPipe(h, nil); err != nil && err != windows.ERROR_PIPE_CONNECTED {
+			windows.CloseHandle(h)
+			return fmt.Errorf("ConnectNamedPipe(%s) returned %v", path, err)
+		}
+		if ctx.Err() != nil {
+			windows.CloseHandle(h)
+			logInfo("Stopped helper.", opField("servehelper"), field("pipe", path))
+			return nil
+		}
+		go serveHelperPipe(h, path, session, allowed)
+	}
+}
+
+// serveHelperPipe authenticates the client connected to h and answers its
+// requests. It closes h.
+func serveHelperPipe(h windows.Handle, path string, s *helperSession, allowed map[string]bool) {
+	f := os.NewFile(uintptr(h), path)
+	defer f.Close()
+	// The client can only be impersonated once it has written to the pipe.
+	var first [1]byte
+	if _, err := io.ReadFull(f, first[:]); err != nil {
+		logDebug("Helper client sent no request.", opField("servehelper"), errField(err))
+		return
+	}
+	user, err := pipeClientUser(h)
+	if err != nil {
+		logWarning("Could not identify helper client.", opField("servehelper"), errField(err))
+		return
+	}
+	if !allowed[user] {
+		logWarning("Rejected helper client.", opField("servehelper"), field("user", user))
+		return
+	}
+	logDebug("Accepted helper client.", opField("servehelper"), field("user", user))
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{io.MultiReader(bytes.NewReader(first[:]), f), f}
+	if err := serveHelperConn(rw, s, user); err != nil {
+		logWarning("Helper connection failed.", opField("servehelper"), field("user", user), errField(err))
+	}
+}
+
+// HelperClient sends machine store changes to the helper run by ServeHelper.
+// Its methods are safe for concurrent use.
+type HelperClient struct {
+	*helperConn
+}
+
+// DialHelper connects to the helper called name. The pipe must be owned by
+// LocalSystem or the Administrators group, as it is when the helper runs as
+// LocalSystem or elevated, so a pipe created under the same name by an
+// unprivileged process is rejected.
+func DialHelper(name string) (*HelperClient, error) {
+	if err := checkAgentName("DialHelper", name); err != nil {
+		return nil, err
+	}
+	c := &helperConn{dial: func() (io.ReadWriteCloser, error) { return dialHelperPipe(name) }}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return &HelperClient{c}, nil
+}
+
+// dialHelperPipe opens a new connection to the helper called name. The
+// helper creates the next pipe instance after accepting a connection, so
+// busy and missing pipes are retried briefly.
+func dialHelperPipe(name string) (*os.File, error) {
+	path := helperPipePath(name)
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	var h windows.Handle
+	for i := 0; ; i++ {
+		// Identification keeps the helper from acting as the client.
+		h, err = windows.CreateFile(pathPtr, windows.GENERIC_READ|windows.GENERIC_WRITE, 0, nil, windows.OPEN_EXISTING, windows.SECURITY_SQOS_PRESENT|windows.SECURITY_IDENTIFICATION, 0)
+		if err == nil {
+			break
+		}
+		if (err != windows.ERROR_PIPE_BUSY && err != windows.ERROR_FILE_NOT_FOUND) || i == 10 {
+			return nil, fmt.Errorf("connecting to helper %s returned %v", path, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err := checkPipeOwner(h, path); err != nil {
+		windows.CloseHandle(h)
+		return nil, err
+	}
+	return os.NewFile(uintptr(h), path), nil
+}
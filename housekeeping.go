@@ -0,0 +1,153 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Rules reported in a Violation.
+const (
+	// RuleSHA1 is violated by certificates signed with SHA-1.
+	RuleSHA1 = "sha1"
+	// RuleKeySize is violated by certificates with keys below the minimum
+	// size.
+	RuleKeySize = "key-size"
+	// RuleSuperseded is violated by certificates that were replaced by a
+	// newer one for longer than allowed.
+	RuleSuperseded = "superseded"
+	// RuleMaxPerIssuer is violated by certificates beyond the maximum
+	// number per issuer.
+	RuleMaxPerIssuer = "max-per-issuer"
+)
+
+// HousekeepingPolicy describes the certificates allowed in a store. Reconcile
+// removes the certificates that violate it. Zero values disable the rules.
+type HousekeepingPolicy struct {
+	// ForbidSHA1 forbids certificates signed with SHA-1. Self-signed
+	// certificates are exempt, as their signature is not verified.
+	ForbidSHA1 bool
+	// MinRSABits and MinECDSABits are the minimum key sizes in bits.
+	MinRSABits   int
+	MinECDSABits int
+	// RemoveSupersededAfter is how long a certificate is kept after a newer
+	// certificate with the same subject and issuer became valid.
+	RemoveSupersededAfter time.Duration
+	// MaxPerIssuer is the maximum number of certificates per issuer. The
+	// newest ones, by NotBefore, are kept.
+	MaxPerIssuer int
+}
+
+func (p HousekeepingPolicy) validate() error {
+	if p.MinRSABits < 0 || p.MinECDSABits < 0 || p.RemoveSupersededAfter < 0 || p.MaxPerIssuer < 0 {
+		return fmt.Errorf("housekeeping policy values must not be negative: %+v", p)
+	}
+	return nil
+}
+
+// Violation is a certificate that violates a HousekeepingPolicy.
+type Violation struct {
+	Thumbprint string `json:"thumbprint"`
+	Subject    string `json:"subject"`
+	Issuer     string `json:"issuer"`
+	// Rule is the first rule the certificate violates, such as RuleSHA1.
+	Rule   string `json:"rule"`
+	Reason string `json:"reason"`
+}
+
+// violations returns the certificates among certs that violate p, sorted by
+// thumbprint. Each certificate is reported once, for the first rule it
+// violates in the order of the Rule constants. Certificates removed for one
+// rule still count towards the others, so a SHA-1 certificate can supersede
+// an older one.
+func (p HousekeepingPolicy) violations(certs []*x509.Certificate, now time.Time) []Violation {
+	found := make(map[string]Violation)
+	add := func(c *x509.Certificate, rule, reason string) {
+		tp := thumbprint(c)
+		if _, ok := found[tp]; ok {
+			return
+		}
+		found[tp] = Violation{Thumbprint: tp, Subject: c.Subject.String(), Issuer: c.Issuer.String(), Rule: rule, Reason: reason}
+	}
+
+	for _, c := range certs {
+		if p.ForbidSHA1 && isSHA1Signature(c.SignatureAlgorithm) && string(c.RawSubject) != string(c.RawIssuer) {
+			add(c, RuleSHA1, fmt.Sprintf("signed with %v", c.SignatureAlgorithm))
+		}
+	}
+	for _, c := range certs {
+		switch pub := c.PublicKey.(type) {
+		case *rsa.PublicKey:
+			if p.MinRSABits > 0 && pub.N.BitLen() < p.MinRSABits {
+				add(c, RuleKeySize, fmt.Sprintf("%d bit RSA key is smaller than %d bits", pub.N.BitLen(), p.MinRSABits))
+			}
+		case *ecdsa.PublicKey:
+			if bits := pub.Curve.Params().BitSize; p.MinECDSABits > 0 && bits < p.MinECDSABits {
+				add(c, RuleKeySize, fmt.Sprintf("%d bit ECDSA key is smaller than %d bits", bits, p.MinECDSABits))
+			}
+		}
+	}
+
+	if p.RemoveSupersededAfter > 0 {
+		for _, c := range certs {
+			for _, n := range certs {
+				if n == c || string(n.RawSubject) != string(c.RawSubject) || string(n.RawIssuer) != string(c.RawIssuer) || !n.NotBefore.After(c.NotBefore) {
+					continue
+				}
+				if since := now.Sub(n.NotBefore); since >= p.RemoveSupersededAfter {
+					add(c, RuleSuperseded, fmt.Sprintf("superseded by %s for %v", thumbprint(n), since))
+					break
+				}
+			}
+		}
+	}
+
+	if p.MaxPerIssuer > 0 {
+		byIssuer := make(map[string][]*x509.Certificate)
+		for _, c := range certs {
+			byIssuer[string(c.RawIssuer)] = append(byIssuer[string(c.RawIssuer)], c)
+		}
+		for _, group := range byIssuer {
+			if len(group) <= p.MaxPerIssuer {
+				continue
+			}
+			sort.SliceStable(group, func(i, j int) bool { return group[i].NotBefore.After(group[j].NotBefore) })
+			for _, c := range group[p.MaxPerIssuer:] {
+				add(c, RuleMaxPerIssuer, fmt.Sprintf("more than %d certificates from the issuer", p.MaxPerIssuer))
+			}
+		}
+	}
+
+	vs := make([]Violation, 0, len(found))
+	for _, v := range found {
+		vs = append(vs, v)
+	}
+	sort.Slice(vs, func(i, j int) bool { return vs[i].Thumbprint < vs[j].Thumbprint })
+	return vs
+}
+
+// isSHA1Signature reports whether alg uses SHA-1.
+func isSHA1Signature(alg x509.SignatureAlgorithm) bool {
+	switch alg {
+	case x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+		return true
+	}
+	return false
+}
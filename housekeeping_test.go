@@ -0,0 +1,111 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestHousekeepingViolations(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	smallKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	p224Key, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	caTmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "CA"}, IsCA: true, BasicConstraintsValid: true}
+	serial := int64(1)
+	issue := func(cn string, age time.Duration, pub interface{}, alg x509.SignatureAlgorithm) *x509.Certificate {
+		serial++
+		tmpl := &x509.Certificate{
+			SerialNumber:       big.NewInt(serial),
+			Subject:            pkix.Name{CommonName: cn},
+			NotBefore:          now.Add(-age),
+			NotAfter:           now.Add(365 * 24 * time.Hour),
+			SignatureAlgorithm: alg,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, caTmpl, pub, caKey)
+		if err != nil {
+			t.Fatalf("failed to create test certificate: %v", err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatalf("failed to parse test certificate: %v", err)
+		}
+		return cert
+	}
+
+	day := 24 * time.Hour
+	pub := &caKey.PublicKey
+	old := issue("host", 100*day, pub, x509.SHA256WithRSA)
+	renewed := issue("host", 40*day, pub, x509.SHA256WithRSA)
+	recent := issue("host", day, pub, x509.SHA256WithRSA)
+	sha1 := issue("legacy", day, pub, x509.SHA1WithRSA)
+	small := issue("small", 2*day, &smallKey.PublicKey, x509.SHA256WithRSA)
+	p224 := issue("p224", 3*day, &p224Key.PublicKey, x509.SHA256WithRSA)
+	certs := []*x509.Certificate{old, renewed, recent, sha1, small, p224}
+
+	policy := HousekeepingPolicy{
+		ForbidSHA1:            true,
+		MinRSABits:            2048,
+		MinECDSABits:          256,
+		RemoveSupersededAfter: 30 * day,
+		MaxPerIssuer:          5,
+	}
+	got := make(map[string]string)
+	for _, v := range policy.violations(certs, now) {
+		got[v.Thumbprint] = v.Rule
+	}
+	want := map[string]string{
+		// old is superseded by renewed for 40 days, renewed by recent for
+		// only a day.
+		thumbprint(old):   RuleSuperseded,
+		thumbprint(sha1):  RuleSHA1,
+		thumbprint(small): RuleKeySize,
+		thumbprint(p224):  RuleKeySize,
+	}
+	for tp, rule := range want {
+		if got[tp] != rule {
+			t.Errorf("certificate %s: rule %q, want %q", tp, got[tp], rule)
+		}
+	}
+	// Only the five newest are kept, so old is also over the limit, but it
+	// is reported for the first rule it violates.
+	if len(got) != len(want) {
+		t.Errorf("violations = %v, want %v", got, want)
+	}
+
+	if vs := (HousekeepingPolicy{}).violations(certs, now); len(vs) != 0 {
+		t.Errorf("empty policy reported %d violations", len(vs))
+	}
+	if vs := (HousekeepingPolicy{MaxPerIssuer: 2}).violations(certs, now); len(vs) != 4 {
+		t.Errorf("MaxPerIssuer 2 reported %d violations, want 4", len(vs))
+	}
+}
@@ -0,0 +1,81 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/sha1"
+	"crypto/x509"
+	"fmt"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Reconcile enforces policy on the store identified by loc: it removes the
+// certificates that violate the policy and returns the violations. With
+// dryRun, the violations are only reported. The returned Result lists the
+// removed certificates, even if a later removal failed.
+func (w *WinCertStore) Reconcile(policy HousekeepingPolicy, loc StoreLocation, dryRun bool) ([]Violation, *Result, error) {
+	res := &Result{Operation: "reconcile"}
+	if err := policy.validate(); err != nil {
+		return nil, res, err
+	}
+	flags := uint32(certStoreOpenExisting)
+	if dryRun {
+		flags |= certStoreReadOnly
+	}
+	certStore, err := openStore(loc, w.openFlags(flags))
+	if err != nil {
+		return nil, res, fmt.Errorf("CertOpenStore for %s returned %v", loc, err)
+	}
+	defer windows.CertCloseStore(certStore, 0)
+
+	certs, err := storeCerts(certStore, loc.String())
+	if err != nil {
+		return nil, res, err
+	}
+	vs := policy.violations(certs, time.Now())
+	if dryRun {
+		logInfo("Evaluated housekeeping policy.", opField("reconcile"), field("store", loc), field("violations", len(vs)))
+		return vs, res, nil
+	}
+
+	byThumbprint := make(map[string]*x509.Certificate, len(certs))
+	for _, c := range certs {
+		byThumbprint[thumbprint(c)] = c
+	}
+	for _, v := range vs {
+		hash := sha1.Sum(byThumbprint[v.Thumbprint].Raw)
+		blob := cryptDataBlob{cbData: uint32(len(hash)), pbData: &hash[0]}
+		nc, err := findCert(certStore, encodingX509ASN|encodingPKCS7, 0, findSHA1Hash, unsafe.Pointer(&blob), nil)
+		if err != nil {
+			return vs, res, fmt.Errorf("finding certificate %s: %v", v.Thumbprint, err)
+		}
+		if nc == nil {
+			// Removed by someone else in the meantime.
+			continue
+		}
+		// removeCert frees the context.
+		if err := removeCert(nc); err != nil {
+			return vs, res, fmt.Errorf("removing certificate %s: %v", v.Thumbprint, err)
+		}
+		res.addChange(ActionRemoved, v.Thumbprint, loc.String())
+		logInfo("Removed certificate that violates the housekeeping policy.", opField("reconcile"), thumbprintField(v.Thumbprint), field("store", loc), field("rule", v.Rule), field("reason", v.Reason))
+	}
+	return vs, res, nil
+}
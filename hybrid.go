@@ -0,0 +1,138 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/tls"
+	"errors"
+)
+
+// versionTLS13 is tls.VersionTLS13, which older Go releases do not define.
+const versionTLS13 = 0x0304
+
+// ecdsaCipherSuites are the TLS 1.2 and earlier cipher suites that use an
+// ECDSA certificate.
+var ecdsaCipherSuites = map[uint16]bool{
+	tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA:        true,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA:    true,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA:    true,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256: true,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256: true,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384: true,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305:  true,
+}
+
+// HybridContainerNames returns the names of the related key containers of
+// the RSA and ECDSA keys of a hybrid identity based on container.
+func HybridContainerNames(container string) (rsaName, ecdsaName string) {
+	return container + "-RSA", container + "-ECDSA"
+}
+
+// HybridCertificate holds the RSA and ECDSA certificates of a hybrid identity
+// for a TLS server, which serves the ECDSA chain to clients that support it
+// and the RSA chain to all others.
+type HybridCertificate struct {
+	RSA   *tls.Certificate
+	ECDSA *tls.Certificate
+}
+
+// GetCertificate selects the certificate for a handshake. It can be used as
+// tls.Config.GetCertificate. Either certificate may be nil, in which case the
+// other one is always used.
+func (h *HybridCertificate) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if h.ECDSA != nil && (h.RSA == nil || supportsECDSA(hello, h.ECDSA)) {
+		return h.ECDSA, nil
+	}
+	if h.RSA != nil {
+		return h.RSA, nil
+	}
+	return nil, errors.New("hybrid identity has no certificates")
+}
+
+// supportsECDSA reports whether the client that sent hello can authenticate
+// the server with the ECDSA certificate cert.
+func supportsECDSA(hello *tls.ClientHelloInfo, cert *tls.Certificate) bool {
+	var curve elliptic.Curve
+	if pub, ok := certPublicKey(cert).(*ecdsa.PublicKey); ok {
+		curve = pub.Curve
+	}
+	var scheme tls.SignatureScheme
+	var id tls.CurveID
+	switch curve {
+	case elliptic.P256():
+		scheme, id = tls.ECDSAWithP256AndSHA256, tls.CurveP256
+	case elliptic.P384():
+		scheme, id = tls.ECDSAWithP384AndSHA384, tls.CurveP384
+	case elliptic.P521():
+		scheme, id = tls.ECDSAWithP521AndSHA512, tls.CurveP521
+	default:
+		return false
+	}
+
+	for _, v := range hello.SupportedVersions {
+		if v == versionTLS13 {
+			// TLS 1.3 binds the signature scheme to the curve.
+			for _, s := range hello.SignatureSchemes {
+				if s == scheme {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	// Before TLS 1.3 the client must offer an ECDSA cipher suite, the curve
+	// and, if it lists signature schemes, an ECDSA one with any curve.
+	suite := false
+	for _, c := range hello.CipherSuites {
+		suite = suite || ecdsaCipherSuites[c]
+	}
+	if !suite {
+		return false
+	}
+	if len(hello.SupportedCurves) > 0 {
+		found := false
+		for _, c := range hello.SupportedCurves {
+			found = found || c == id
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(hello.SignatureSchemes) == 0 {
+		return true
+	}
+	for _, s := range hello.SignatureSchemes {
+		switch s {
+		case tls.ECDSAWithP256AndSHA256, tls.ECDSAWithP384AndSHA384, tls.ECDSAWithP521AndSHA512, tls.ECDSAWithSHA1:
+			return true
+		}
+	}
+	return false
+}
+
+// certPublicKey returns the public key of the leaf certificate of cert.
+func certPublicKey(cert *tls.Certificate) interface{} {
+	if cert.Leaf != nil {
+		return cert.Leaf.PublicKey
+	}
+	if s, ok := cert.PrivateKey.(interface{ Public() crypto.PublicKey }); ok {
+		return s.Public()
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+)
+
+func TestHybridContainerNames(t *testing.T) {
+	rsaName, ecdsaName := HybridContainerNames("web")
+	if rsaName != "web-RSA" || ecdsaName != "web-ECDSA" {
+		t.Errorf("HybridContainerNames(web) = %q, %q", rsaName, ecdsaName)
+	}
+}
+
+func TestHybridGetCertificate(t *testing.T) {
+	// selfSigned creates P-256 certificates.
+	ec := &tls.Certificate{Leaf: selfSigned(t, &x509.Certificate{Subject: pkix.Name{CommonName: "ecdsa"}})}
+	rsa := &tls.Certificate{}
+	h := &HybridCertificate{RSA: rsa, ECDSA: ec}
+
+	tests := []struct {
+		name  string
+		hello *tls.ClientHelloInfo
+		want  *tls.Certificate
+	}{
+		{"TLS 1.3 with P-256", &tls.ClientHelloInfo{
+			SupportedVersions: []uint16{versionTLS13, tls.VersionTLS12},
+			SignatureSchemes:  []tls.SignatureScheme{tls.PSSWithSHA256, tls.ECDSAWithP256AndSHA256},
+		}, ec},
+		{"TLS 1.3 without P-256", &tls.ClientHelloInfo{
+			SupportedVersions: []uint16{versionTLS13},
+			SignatureSchemes:  []tls.SignatureScheme{tls.PSSWithSHA256, tls.ECDSAWithP384AndSHA384},
+		}, rsa},
+		{"TLS 1.2 with ECDSA suite", &tls.ClientHelloInfo{
+			CipherSuites:    []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			SupportedCurves: []tls.CurveID{tls.X25519, tls.CurveP256},
+		}, ec},
+		{"TLS 1.2 without ECDSA suite", &tls.ClientHelloInfo{
+			CipherSuites:    []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+			SupportedCurves: []tls.CurveID{tls.CurveP256},
+		}, rsa},
+		{"TLS 1.2 without the curve", &tls.ClientHelloInfo{
+			CipherSuites:    []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			SupportedCurves: []tls.CurveID{tls.CurveP384},
+		}, rsa},
+		{"TLS 1.2 with RSA signature schemes only", &tls.ClientHelloInfo{
+			CipherSuites:     []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			SignatureSchemes: []tls.SignatureScheme{tls.PKCS1WithSHA256},
+		}, rsa},
+	}
+	for _, tc := range tests {
+		got, err := h.GetCertificate(tc.hello)
+		if err != nil || got != tc.want {
+			t.Errorf("%s: GetCertificate = %p, %v, want %p", tc.name, got, err, tc.want)
+		}
+	}
+
+	if got, _ := (&HybridCertificate{ECDSA: ec}).GetCertificate(&tls.ClientHelloInfo{}); got != ec {
+		t.Errorf("GetCertificate without an RSA certificate did not return the ECDSA one")
+	}
+	if _, err := (&HybridCertificate{}).GetCertificate(&tls.ClientHelloInfo{}); err == nil {
+		t.Errorf("GetCertificate without certificates succeeded")
+	}
+}
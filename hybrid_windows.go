@@ -0,0 +1,136 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+)
+
+// HybridIdentity is an RSA and an ECDSA key with their certificates, managed
+// side by side so that TLS servers can serve both chains from hardware backed
+// keys. Each half is a WinCertStore that uses the container named by
+// HybridContainerNames and only selects certificates of the MY store with a
+// key of its algorithm. Keys are generated and certificates stored through
+// the halves as usual.
+type HybridIdentity struct {
+	RSA   *WinCertStore
+	ECDSA *WinCertStore
+}
+
+// Hybrid returns the HybridIdentity based on the container of w. The halves
+// share the provider, options and circuit breaker of w and start out with its
+// current issuers, which can be changed separately afterwards.
+func (w *WinCertStore) Hybrid() *HybridIdentity {
+	rsaName, ecdsaName := HybridContainerNames(w.container)
+	return &HybridIdentity{
+		RSA:   w.view(rsaName, x509.RSA),
+		ECDSA: w.view(ecdsaName, x509.ECDSA),
+	}
+}
+
+// view returns a WinCertStore like w that uses container and only selects
+// certificates with keys of alg.
+func (w *WinCertStore) view(container string, alg x509.PublicKeyAlgorithm) *WinCertStore {
+	v := &WinCertStore{
+		CStore:                w.CStore,
+		Prov:                  w.Prov,
+		ProvName:              w.ProvName,
+		issuers:               w.issuerList(),
+		intermediateIssuers:   w.intermediateIssuerList(),
+		issuerMatch:           w.issuerMatch,
+		selection:             w.selection,
+		readOnlyLookups:       w.readOnlyLookups,
+		container:             container,
+		allowPrivateExport:    w.allowPrivateExport,
+		generateTimeout:       w.generateTimeout,
+		generateProgress:      w.generateProgress,
+		rawFlags:              w.rawFlags,
+		rotationOverlap:       w.rotationOverlap,
+		breaker:               w.breaker,
+		keyAlgorithm:          alg,
+		deriveIntermediate:    w.deriveIntermediate,
+		signatureCheck:        w.signatureCheck,
+		namespace:             w.namespace,
+		linkOnStore:           w.linkOnStore,
+		keyUsageFilter:        w.keyUsageFilter,
+		skipStoreVerification: w.skipStoreVerification,
+	}
+	v.machineKeys.Store(w.machineKeys.Load())
+	return v
+}
+
+// Generate generates the RSA key with rsaBits bits and the ECDSA key on curve,
+// which is one of "ECDSA_P256", "ECDSA_P384" or "ECDSA_P521".
+func (h *HybridIdentity) Generate(rsaBits int, curve string) (rsaSigner, ecdsaSigner crypto.Signer, err error) {
+	switch curve {
+	case "ECDSA_P256", "ECDSA_P384", "ECDSA_P521":
+	default:
+		return nil, nil, &ArgError{Op: "Generate", Arg: "curve", Reason: fmt.Sprintf("unsupported curve %q", curve)}
+	}
+	rsaSigner, err = h.RSA.Generate(rsaBits, "RSA")
+	if err != nil {
+		return nil, nil, fmt.Errorf("generating RSA key: %v", err)
+	}
+	ecdsaSigner, err = h.ECDSA.Generate(0, curve)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generating ECDSA key: %v", err)
+	}
+	return rsaSigner, ecdsaSigner, nil
+}
+
+// TLSCertificate returns the certificates of both halves with the
+// intermediates that chain them to a root and their keys. A half without a
+// certificate is left out, but at least one is required.
+func (h *HybridIdentity) TLSCertificate() (*HybridCertificate, error) {
+	rsaCert, err := h.RSA.tlsCertificate()
+	if err != nil {
+		return nil, fmt.Errorf("RSA certificate: %v", err)
+	}
+	ecdsaCert, err := h.ECDSA.tlsCertificate()
+	if err != nil {
+		return nil, fmt.Errorf("ECDSA certificate: %v", err)
+	}
+	if rsaCert == nil && ecdsaCert == nil {
+		return nil, fmt.Errorf("no RSA or ECDSA certificate found for issuers %v", h.RSA.issuerList())
+	}
+	return &HybridCertificate{RSA: rsaCert, ECDSA: ecdsaCert}, nil
+}
+
+// tlsCertificate returns the current cert of w, its intermediates and its key
+// as a tls.Certificate, or nil if there is no current cert.
+func (w *WinCertStore) tlsCertificate() (*tls.Certificate, error) {
+	cert, err := w.Cert()
+	if err != nil || cert == nil {
+		return nil, err
+	}
+	chain, err := w.chain()
+	if err != nil {
+		return nil, err
+	}
+	key, err := w.CertKey(chain[0])
+	if err != nil {
+		return nil, err
+	}
+	tc := &tls.Certificate{PrivateKey: key, Leaf: chain[0]}
+	for _, c := range chain {
+		tc.Certificate = append(tc.Certificate, c.Raw)
+	}
+	return tc, nil
+}
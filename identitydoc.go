@@ -0,0 +1,174 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// IdentityDocument describes the identity of a machine for configuration
+// management and zero trust systems that register devices.
+type IdentityDocument struct {
+	Hostname string `json:"hostname"`
+	// Thumbprint is the SHA-1 thumbprint of the certificate as shown by the
+	// Windows certificate tools.
+	Thumbprint string `json:"thumbprint"`
+	// SPKIHash is the pin of the public key in the "sha256/<base64>" form,
+	// see SPKIPin.
+	SPKIHash string `json:"spki_hash"`
+	// TPMBacked reports whether the key is held by the platform provider.
+	TPMBacked bool `json:"tpm_backed"`
+	// Attestation is an opaque attestation claim supplied by the caller, such
+	// as a TPM key attestation statement. It is omitted if empty.
+	Attestation []byte `json:"attestation,omitempty"`
+	// IssuedAt is the time the document was signed, in seconds since the Unix
+	// epoch.
+	IssuedAt int64 `json:"iat"`
+}
+
+// newIdentityDocument returns the document for cert, signed at now.
+func newIdentityDocument(hostname string, cert *x509.Certificate, tpmBacked bool, attestation []byte, now time.Time) *IdentityDocument {
+	return &IdentityDocument{
+		Hostname:    hostname,
+		Thumbprint:  thumbprint(cert),
+		SPKIHash:    "sha256/" + base64.StdEncoding.EncodeToString(SPKIPin(cert)),
+		TPMBacked:   tpmBacked,
+		Attestation: attestation,
+		IssuedAt:    now.Unix(),
+	}
+}
+
+// jwsHeader is the protected header of a signed identity document. X5C holds
+// the base64 encoded certificate whose key signed the document.
+type jwsHeader struct {
+	Alg string   `json:"alg"`
+	Typ string   `json:"typ"`
+	X5C []string `json:"x5c"`
+}
+
+// identityDocumentType is the typ header of signed identity documents.
+const identityDocumentType = "identity+jws"
+
+// jwsAlgorithm returns the JWS algorithm and hash used to sign with pub.
+func jwsAlgorithm(pub crypto.PublicKey) (string, crypto.Hash, error) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return "RS256", crypto.SHA256, nil
+	case *ecdsa.PublicKey:
+		switch pub.Curve.Params().BitSize {
+		case 256:
+			return "ES256", crypto.SHA256, nil
+		case 384:
+			return "ES384", crypto.SHA384, nil
+		case 521:
+			return "ES512", crypto.SHA512, nil
+		}
+		return "", 0, fmt.Errorf("unsupported curve %s", pub.Curve.Params().Name)
+	default:
+		return "", 0, fmt.Errorf("unsupported public key type %T", pub)
+	}
+}
+
+// SignIdentityDocument signs doc with signer, the key of cert, and returns it
+// as a JWS in compact serialization with cert in the x5c header. RSA keys
+// sign with RS256 and ECDSA keys with ES256, ES384 or ES512. ECDSA signers
+// must return raw r||s signatures like the keys of this package, which is
+// the JWS signature format.
+func SignIdentityDocument(doc *IdentityDocument, cert *x509.Certificate, signer crypto.Signer) (string, error) {
+	if err := checkCert("SignIdentityDocument", "cert", cert); err != nil {
+		return "", err
+	}
+	alg, hash, err := jwsAlgorithm(cert.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	header, err := json.Marshal(jwsHeader{Alg: alg, Typ: identityDocumentType, X5C: []string{base64.StdEncoding.EncodeToString(cert.Raw)}})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	h := hash.New()
+	h.Write([]byte(input))
+	sig, err := signer.Sign(rand.Reader, h.Sum(nil), hash)
+	if err != nil {
+		return "", fmt.Errorf("could not sign identity document: %v", err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// VerifyIdentityDocument checks the signature of a document returned by
+// SignIdentityDocument against the certificate in its header, and returns
+// the document and the certificate. The caller must decide whether to trust
+// the certificate, for example by verifying its chain.
+func VerifyIdentityDocument(jws string) (*IdentityDocument, *x509.Certificate, error) {
+	parts := strings.Split(jws, ".")
+	if len(parts) != 3 {
+		return nil, nil, errors.New("identity document is not a compact JWS")
+	}
+	var raw [3][]byte
+	for i, p := range parts {
+		b, err := base64.RawURLEncoding.DecodeString(p)
+		if err != nil {
+			return nil, nil, fmt.Errorf("identity document part %d is not valid base64: %v", i, err)
+		}
+		raw[i] = b
+	}
+	var header jwsHeader
+	if err := json.Unmarshal(raw[0], &header); err != nil {
+		return nil, nil, fmt.Errorf("could not decode identity document header: %v", err)
+	}
+	if header.Typ != identityDocumentType || len(header.X5C) == 0 {
+		return nil, nil, fmt.Errorf("header %s is not the header of an identity document", raw[0])
+	}
+	der, err := base64.StdEncoding.DecodeString(header.X5C[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("x5c certificate is not valid base64: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse x5c certificate: %v", err)
+	}
+	alg, hash, err := jwsAlgorithm(cert.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if header.Alg != alg {
+		return nil, nil, fmt.Errorf("algorithm %q does not match the %s certificate key", header.Alg, alg)
+	}
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	if err := verifySignature(cert.PublicKey, h.Sum(nil), hash, raw[2]); err != nil {
+		return nil, nil, fmt.Errorf("identity document signature does not verify: %v", err)
+	}
+	doc := &IdentityDocument{}
+	if err := json.Unmarshal(raw[1], doc); err != nil {
+		return nil, nil, fmt.Errorf("could not decode identity document: %v", err)
+	}
+	return doc, cert, nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rawECDSASigner signs like EcdsaKey, returning r||s instead of ASN.1.
+type rawECDSASigner struct {
+	*ecdsa.PrivateKey
+}
+
+func (s rawECDSASigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	r, ss, err := ecdsa.Sign(rand, s.PrivateKey, digest)
+	if err != nil {
+		return nil, err
+	}
+	size := (s.Curve.Params().BitSize + 7) / 8
+	sig := make([]byte, 2*size)
+	rb, sb := r.Bytes(), ss.Bytes()
+	copy(sig[size-len(rb):size], rb)
+	copy(sig[2*size-len(sb):], sb)
+	return sig, nil
+}
+
+func TestIdentityDocument(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		key     crypto.Signer
+		signer  crypto.Signer
+		wantAlg string
+	}{
+		{"ecdsa", ecKey, rawECDSASigner{ecKey}, "ES384"},
+		{"rsa", rsaKey, rsaKey, "RS256"},
+	}
+	for _, tt := range tests {
+		tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "host"}, NotAfter: time.Now().Add(time.Hour)}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, tt.key.Public(), tt.key)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		doc := newIdentityDocument("host.example.com", cert, true, []byte("claim"), time.Unix(1500000000, 0))
+		if !strings.HasPrefix(doc.SPKIHash, "sha256/") || doc.Thumbprint != thumbprint(cert) {
+			t.Errorf("%s: newIdentityDocument = %+v", tt.name, doc)
+		}
+		jws, err := SignIdentityDocument(doc, cert, tt.signer)
+		if err != nil {
+			t.Fatalf("%s: SignIdentityDocument returned %v", tt.name, err)
+		}
+		var header jwsHeader
+		b, err := base64.RawURLEncoding.DecodeString(strings.Split(jws, ".")[0])
+		if err != nil || json.Unmarshal(b, &header) != nil || header.Alg != tt.wantAlg {
+			t.Errorf("%s: header = %s, want alg %q", tt.name, b, tt.wantAlg)
+		}
+		got, gotCert, err := VerifyIdentityDocument(jws)
+		if err != nil {
+			t.Fatalf("%s: VerifyIdentityDocument returned %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, doc) || !gotCert.Equal(cert) {
+			t.Errorf("%s: VerifyIdentityDocument = %+v, want %+v", tt.name, got, doc)
+		}
+
+		parts := strings.Split(jws, ".")
+		other := newIdentityDocument("other.example.com", cert, true, nil, time.Unix(1500000000, 0))
+		forged, err := SignIdentityDocument(other, cert, tt.signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tampered := parts[0] + "." + strings.Split(forged, ".")[1] + "." + parts[2]
+		if _, _, err := VerifyIdentityDocument(tampered); err == nil {
+			t.Errorf("%s: VerifyIdentityDocument succeeded for a tampered payload", tt.name)
+		}
+	}
+	if _, _, err := VerifyIdentityDocument("a.b"); err == nil {
+		t.Errorf("VerifyIdentityDocument succeeded for a malformed document")
+	}
+}
@@ -0,0 +1,53 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// IdentityDocument returns an identity document for the current cert of w,
+// signed by its key, that configuration management and zero trust systems can
+// ingest to register the machine, see SignIdentityDocument. attestation is
+// included as the attestation claim if it is not empty.
+func (w *WinCertStore) IdentityDocument(attestation []byte) (string, error) {
+	cert, err := w.Cert()
+	if err != nil {
+		return "", err
+	}
+	if cert == nil {
+		return "", fmt.Errorf("no certificate found for issuers %v", w.issuerList())
+	}
+	key, err := w.CertKey(cert)
+	if err != nil {
+		return "", err
+	}
+	defer key.Close()
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("could not determine hostname: %v", err)
+	}
+	doc := newIdentityDocument(hostname, cert, w.ProvName == ProviderMSPlatform, attestation, time.Now())
+	jws, err := SignIdentityDocument(doc, cert, key)
+	if err != nil {
+		return "", err
+	}
+	logInfo("Signed identity document.", opField("identitydocument"), thumbprintField(doc.Thumbprint))
+	return jws, nil
+}
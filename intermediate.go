@@ -0,0 +1,53 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bytes"
+	"crypto/x509"
+	"time"
+)
+
+// deriveIntermediate returns the certificate among candidates that issued
+// leaf: its subject must be the issuer of leaf, its subject key ID must match
+// the authority key ID of leaf if both are present, and it must have signed
+// leaf. Self-issued certificates are roots and are skipped. If several
+// candidates match, such as after the CA renewed its certificate with the
+// same key, the one that is valid at now and expires last is preferred. It
+// returns nil if no candidate issued leaf.
+func deriveIntermediate(leaf *x509.Certificate, candidates []*x509.Certificate, now time.Time) *x509.Certificate {
+	var best *x509.Certificate
+	bestValid := false
+	for _, c := range candidates {
+		if !bytes.Equal(c.RawSubject, leaf.RawIssuer) || bytes.Equal(c.RawSubject, c.RawIssuer) {
+			continue
+		}
+		if len(leaf.AuthorityKeyId) > 0 && len(c.SubjectKeyId) > 0 && !bytes.Equal(leaf.AuthorityKeyId, c.SubjectKeyId) {
+			continue
+		}
+		if leaf.CheckSignatureFrom(c) != nil {
+			continue
+		}
+		valid := !now.Before(c.NotBefore) && !now.After(c.NotAfter)
+		switch {
+		case best == nil, valid && !bestValid:
+		case valid == bestValid && c.NotAfter.After(best.NotAfter):
+		default:
+			continue
+		}
+		best, bestValid = c, valid
+	}
+	return best
+}
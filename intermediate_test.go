@@ -0,0 +1,91 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestDeriveIntermediate(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	serial := int64(0)
+	issue := func(tmpl, parent *x509.Certificate, pub *ecdsa.PublicKey, signer *ecdsa.PrivateKey) *x509.Certificate {
+		serial++
+		tmpl.SerialNumber = big.NewInt(serial)
+		if parent == nil {
+			parent = tmpl
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, signer)
+		if err != nil {
+			t.Fatalf("failed to create test certificate: %v", err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatalf("failed to parse test certificate: %v", err)
+		}
+		return cert
+	}
+	newKey := func() *ecdsa.PrivateKey {
+		k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("failed to generate test key: %v", err)
+		}
+		return k
+	}
+	ca := func(name string, notAfter time.Time) *x509.Certificate {
+		return &x509.Certificate{
+			Subject:               pkix.Name{CommonName: name},
+			NotBefore:             now.Add(-48 * time.Hour),
+			NotAfter:              notAfter,
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign,
+		}
+	}
+
+	rootKey, caKey, otherKey := newKey(), newKey(), newKey()
+	root := issue(ca("root", now.Add(1000*time.Hour)), nil, &rootKey.PublicKey, rootKey)
+	current := issue(ca("issuing", now.Add(100*time.Hour)), root, &caKey.PublicKey, rootKey)
+	renewedLater := issue(ca("issuing", now.Add(200*time.Hour)), root, &caKey.PublicKey, rootKey)
+	expired := issue(ca("issuing", now.Add(-time.Hour)), root, &caKey.PublicKey, rootKey)
+	otherKeyCA := issue(ca("issuing", now.Add(300*time.Hour)), root, &otherKey.PublicKey, rootKey)
+	otherName := issue(ca("other", now.Add(300*time.Hour)), root, &caKey.PublicKey, rootKey)
+	leaf := issue(&x509.Certificate{Subject: pkix.Name{CommonName: "leaf"}, NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour)}, current, &newKey().PublicKey, caKey)
+
+	tests := []struct {
+		name       string
+		candidates []*x509.Certificate
+		want       *x509.Certificate
+	}{
+		{"single", []*x509.Certificate{current}, current},
+		{"renewed with same key", []*x509.Certificate{current, renewedLater}, renewedLater},
+		{"valid preferred over expired", []*x509.Certificate{expired, current}, current},
+		{"only expired", []*x509.Certificate{expired}, expired},
+		{"different key or name", []*x509.Certificate{otherKeyCA, otherName, root}, nil},
+		{"none", nil, nil},
+	}
+	for _, tc := range tests {
+		if got := deriveIntermediate(leaf, tc.candidates, now); got != tc.want {
+			t.Errorf("%s: deriveIntermediate returned %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"fmt"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// intermediateStores are searched for the issuer of the current cert with
+// DeriveIntermediate. Store puts intermediates in the first one.
+var intermediateStores = []StoreLocation{
+	{Location: LocationLocalMachine, Name: "CA"},
+	{Location: LocationCurrentUser, Name: "CA"},
+}
+
+// derivedIntermediate returns the issuer of the current cert found in the
+// intermediateStores, or nil if there is no current cert or issuer.
+func (w *WinCertStore) derivedIntermediate() (*x509.Certificate, error) {
+	leaf, err := w.Cert()
+	if err != nil || leaf == nil {
+		return nil, err
+	}
+	var candidates []*x509.Certificate
+	for _, loc := range intermediateStores {
+		certStore, err := openStore(loc, w.lookupFlags())
+		if errno, ok := err.(syscall.Errno); ok && errno == windows.ERROR_FILE_NOT_FOUND {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("CertOpenStore for %s returned %v", loc, err)
+		}
+		certs, err := storeCerts(certStore, loc.String())
+		windows.CertCloseStore(certStore, 0)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, certs...)
+	}
+	issuer := deriveIntermediate(leaf, candidates, time.Now())
+	if issuer == nil {
+		logWarning("No intermediate found for certificate.", opField("intermediate"), thumbprintField(thumbprint(leaf)), field("issuer", leaf.Issuer))
+		return nil, nil
+	}
+	logDebug("Derived intermediate.", opField("intermediate"), thumbprintField(thumbprint(issuer)), field("leaf", thumbprint(leaf)))
+	return issuer, nil
+}
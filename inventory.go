@@ -0,0 +1,112 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrStopInventory can be returned by the callback of EnumerateCerts to stop
+// the enumeration without an error.
+var ErrStopInventory = errors.New("stop inventory")
+
+// InventoryFilter selects the certificates passed to the callback of
+// EnumerateCerts. Empty fields match all certificates. Thumbprint, Subject or
+// Issuer, in that order of preference, is applied by the store itself, so
+// certificates that do not match it are never loaded.
+type InventoryFilter struct {
+	// Thumbprint is the hex encoded SHA1 thumbprint of the certificate.
+	Thumbprint string
+	// Subject and Issuer match certificates whose subject or issuer name
+	// contains them, ignoring case.
+	Subject string
+	Issuer  string
+	// ExpiresBefore, if set, matches certificates that expire before it.
+	ExpiresBefore time.Time
+	// EnrollmentBackend matches certificates whose Provenance names the
+	// backend, ignoring case.
+	EnrollmentBackend string
+}
+
+// inventoryFind identifies the filter field applied by the store.
+type inventoryFind int
+
+const (
+	inventoryFindAny inventoryFind = iota
+	inventoryFindThumbprint
+	inventoryFindSubject
+	inventoryFindIssuer
+)
+
+func (f InventoryFilter) validate() error {
+	if f.Thumbprint == "" {
+		return nil
+	}
+	if b, err := hex.DecodeString(f.Thumbprint); err != nil || len(b) != 20 {
+		return &ArgError{Op: "EnumerateCerts", Arg: "filter", Reason: "thumbprint must be 40 hex digits"}
+	}
+	return nil
+}
+
+// find returns the filter field the store applies.
+func (f InventoryFilter) find() inventoryFind {
+	switch {
+	case f.Thumbprint != "":
+		return inventoryFindThumbprint
+	case f.Subject != "":
+		return inventoryFindSubject
+	case f.Issuer != "":
+		return inventoryFindIssuer
+	default:
+		return inventoryFindAny
+	}
+}
+
+// match reports whether cert matches the fields of f other than the one
+// already applied by the store. The store formats names differently than
+// pkix.Name, so a name filter applied by the store is not checked again.
+func (f InventoryFilter) match(cert *x509.Certificate) bool {
+	applied := f.find()
+	if f.Thumbprint != "" && applied != inventoryFindThumbprint && !strings.EqualFold(f.Thumbprint, thumbprint(cert)) {
+		return false
+	}
+	if f.Subject != "" && applied != inventoryFindSubject && !containsFold(cert.Subject.String(), f.Subject) {
+		return false
+	}
+	if f.Issuer != "" && applied != inventoryFindIssuer && !containsFold(cert.Issuer.String(), f.Issuer) {
+		return false
+	}
+	if !f.ExpiresBefore.IsZero() && !cert.NotAfter.Before(f.ExpiresBefore) {
+		return false
+	}
+	return true
+}
+
+// matchProvenance reports whether the provenance p of a certificate matches
+// f. p is nil for certificates without one.
+func (f InventoryFilter) matchProvenance(p *Provenance) bool {
+	if f.EnrollmentBackend == "" {
+		return true
+	}
+	return p != nil && strings.EqualFold(p.Backend, f.EnrollmentBackend)
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
@@ -0,0 +1,63 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInventoryFilter(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	cert := &x509.Certificate{
+		Raw:      []byte("cert"),
+		Subject:  pkix.Name{CommonName: "www.example.com"},
+		Issuer:   pkix.Name{CommonName: "Example CA", Organization: []string{"Example"}},
+		NotAfter: now.Add(24 * time.Hour),
+	}
+	tp := thumbprint(cert)
+	tests := []struct {
+		desc   string
+		filter InventoryFilter
+		find   inventoryFind
+		want   bool
+	}{
+		{"empty", InventoryFilter{}, inventoryFindAny, true},
+		{"thumbprint", InventoryFilter{Thumbprint: strings.ToLower(tp)}, inventoryFindThumbprint, true},
+		{"subject", InventoryFilter{Subject: "EXAMPLE.com"}, inventoryFindSubject, true},
+		{"issuer applied by the store", InventoryFilter{Issuer: "CN=Other CA"}, inventoryFindIssuer, true},
+		{"issuer checked after thumbprint", InventoryFilter{Thumbprint: tp, Issuer: "Other CA"}, inventoryFindThumbprint, false},
+		{"expiring", InventoryFilter{Subject: "www", ExpiresBefore: now.Add(48 * time.Hour)}, inventoryFindSubject, true},
+		{"not expiring", InventoryFilter{ExpiresBefore: now}, inventoryFindAny, false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.find(); got != tt.find {
+			t.Errorf("%s: find() = %v, want: %v", tt.desc, got, tt.find)
+		}
+		if got := tt.filter.match(cert); got != tt.want {
+			t.Errorf("%s: match() = %t, want: %t", tt.desc, got, tt.want)
+		}
+	}
+
+	if err := (InventoryFilter{Thumbprint: "abc"}).validate(); err == nil {
+		t.Error("validate succeeded with a short thumbprint, want error")
+	}
+	if err := (InventoryFilter{Thumbprint: tp}).validate(); err != nil {
+		t.Errorf("validate returned %v", err)
+	}
+}
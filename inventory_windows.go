@@ -0,0 +1,117 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	infoSubjectFlag = 7                                               // CERT_INFO_SUBJECT_FLAG
+	findSubjectStr  = compareNameStrW<<compareShift | infoSubjectFlag // CERT_FIND_SUBJECT_STR_W
+)
+
+// EnumerateCerts calls fn for each certificate in the store identified by loc
+// that matches filter. Certificates are loaded one at a time, so stores with
+// thousands of certificates can be inventoried without holding them all in
+// memory. Enumeration stops at the first error returned by fn, which
+// EnumerateCerts returns unless it is ErrStopInventory.
+func (w *WinCertStore) EnumerateCerts(loc StoreLocation, filter InventoryFilter, fn func(*x509.Certificate) error) error {
+	return w.enumerateCerts(loc, filter, false, func(c *x509.Certificate, _ *Provenance) error {
+		return fn(c)
+	})
+}
+
+// EnumerateProvenance is like EnumerateCerts, but also passes fn the
+// provenance recorded for each certificate, or nil if there is none.
+func (w *WinCertStore) EnumerateProvenance(loc StoreLocation, filter InventoryFilter, fn func(*x509.Certificate, *Provenance) error) error {
+	return w.enumerateCerts(loc, filter, true, fn)
+}
+
+// enumerateCerts implements EnumerateCerts and EnumerateProvenance. The
+// provenance is only read if withProvenance is set or filter needs it.
+func (w *WinCertStore) enumerateCerts(loc StoreLocation, filter InventoryFilter, withProvenance bool, fn func(*x509.Certificate, *Provenance) error) error {
+	if err := filter.validate(); err != nil {
+		return err
+	}
+	certStore, err := openStore(loc, w.openFlags(certStoreOpenExisting|certStoreReadOnly))
+	if err != nil {
+		return fmt.Errorf("opening %s: %v", loc, err)
+	}
+	defer windows.CertCloseStore(certStore, 0)
+
+	findType := uint32(findAny)
+	var para unsafe.Pointer
+	switch filter.find() {
+	case inventoryFindThumbprint:
+		hash, _ := hex.DecodeString(filter.Thumbprint)
+		para = unsafe.Pointer(&cryptDataBlob{cbData: uint32(len(hash)), pbData: &hash[0]})
+		findType = findSHA1Hash
+	case inventoryFindSubject:
+		para = unsafe.Pointer(wide(filter.Subject))
+		findType = findSubjectStr
+	case inventoryFindIssuer:
+		para = unsafe.Pointer(wide(filter.Issuer))
+		findType = findIssuerStr
+	}
+
+	var n int
+	// findCert frees prev, so only a context the loop stops at is freed.
+	var prev *windows.CertContext
+	for {
+		nc, err := findCert(certStore, encodingX509ASN|encodingPKCS7, 0, findType, para, prev)
+		if err != nil {
+			return fmt.Errorf("finding certificates in %s: %v", loc, err)
+		}
+		if nc == nil {
+			logDebug("Enumerated certificates.", opField("enumeratecerts"), field("store", loc), field("matched", n))
+			return nil
+		}
+		prev = nc
+		xc, err := x509.ParseCertificate(certContextBytes(nc))
+		if err != nil {
+			logWarning("Skipping certificate that could not be parsed.", opField("enumeratecerts"), field("store", loc), errField(err))
+			continue
+		}
+		if !filter.match(xc) {
+			continue
+		}
+		var prov *Provenance
+		if withProvenance || filter.EnrollmentBackend != "" {
+			if prov, err = certProvenance(nc); err != nil {
+				logWarning("Ignoring provenance that could not be read.", opField("enumeratecerts"), field("store", loc), thumbprintField(thumbprint(xc)), errField(err))
+				prov = nil
+			}
+		}
+		if !filter.matchProvenance(prov) {
+			continue
+		}
+		n++
+		if err := fn(xc, prov); err != nil {
+			windows.CertFreeCertificateContext(nc)
+			if err == ErrStopInventory {
+				return nil
+			}
+			return err
+		}
+	}
+}
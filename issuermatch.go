@@ -0,0 +1,110 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509/pkix"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// IssuerMatch selects how issuer names are matched against certificates.
+type IssuerMatch int
+
+const (
+	// IssuerSubstring matches certificates whose issuer name contains the
+	// issuer, case insensitively. This is the default.
+	IssuerSubstring IssuerMatch = iota
+	// IssuerWildcard treats issuers as patterns in which '*' matches any
+	// sequence of characters and '?' matches a single character. Patterns are
+	// anchored at both ends and matched case insensitively, so
+	// "Corp Issuing CA *" matches "Corp Issuing CA 2" but not
+	// "Old Corp Issuing CA 2".
+	IssuerWildcard
+	// IssuerRegexp treats issuers as regular expressions in the syntax of the
+	// regexp package. They are not anchored, use ^ and $ to anchor them.
+	IssuerRegexp
+)
+
+func (m IssuerMatch) String() string {
+	switch m {
+	case IssuerSubstring:
+		return "substring"
+	case IssuerWildcard:
+		return "wildcard"
+	case IssuerRegexp:
+		return "regexp"
+	default:
+		return fmt.Sprintf("IssuerMatch(%d)", int(m))
+	}
+}
+
+// issuerPattern compiles an issuer for the pattern based match modes.
+func issuerPattern(mode IssuerMatch, issuer string) (*regexp.Regexp, error) {
+	var expr string
+	switch mode {
+	case IssuerWildcard:
+		expr = "(?i)^" + wildcardExpr(issuer) + "$"
+	case IssuerRegexp:
+		expr = issuer
+	default:
+		return nil, fmt.Errorf("issuer match mode %v does not use patterns", mode)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %v issuer %q: %v", mode, issuer, err)
+	}
+	return re, nil
+}
+
+// wildcardExpr converts a wildcard pattern into a regular expression.
+func wildcardExpr(pattern string) string {
+	var b strings.Builder
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return b.String()
+}
+
+// validateIssuers checks that all issuers are valid patterns for mode.
+func validateIssuers(mode IssuerMatch, issuers []string) error {
+	switch mode {
+	case IssuerSubstring:
+		return nil
+	case IssuerWildcard, IssuerRegexp:
+	default:
+		return fmt.Errorf("unsupported issuer match mode: %v", mode)
+	}
+	for _, issuer := range issuers {
+		if _, err := issuerPattern(mode, issuer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchIssuer reports whether re matches the common name or the full
+// distinguished name of issuer.
+func matchIssuer(re *regexp.Regexp, issuer pkix.Name) bool {
+	return (issuer.CommonName != "" && re.MatchString(issuer.CommonName)) || re.MatchString(issuer.String())
+}
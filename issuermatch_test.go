@@ -0,0 +1,66 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509/pkix"
+	"testing"
+)
+
+func TestMatchIssuer(t *testing.T) {
+	issuer := pkix.Name{CommonName: "Corp Issuing CA 2", Organization: []string{"Corp"}}
+	tests := []struct {
+		mode    IssuerMatch
+		pattern string
+		want    bool
+	}{
+		{IssuerWildcard, "Corp Issuing CA *", true},
+		{IssuerWildcard, "corp issuing ca ?", true},
+		{IssuerWildcard, "Issuing CA *", false},
+		{IssuerWildcard, "*Issuing CA*", true},
+		{IssuerWildcard, "Corp Issuing CA (2)", false},
+		{IssuerRegexp, `Issuing CA \d+`, true},
+		{IssuerRegexp, `^Issuing CA`, false},
+		{IssuerRegexp, `^CN=Corp Issuing CA 2,O=Corp$`, true},
+	}
+	for _, tt := range tests {
+		re, err := issuerPattern(tt.mode, tt.pattern)
+		if err != nil {
+			t.Errorf("issuerPattern(%v, %q) returned %v", tt.mode, tt.pattern, err)
+			continue
+		}
+		if got := matchIssuer(re, issuer); got != tt.want {
+			t.Errorf("matchIssuer(%v %q) = %t, want %t", tt.mode, tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestValidateIssuers(t *testing.T) {
+	tests := []struct {
+		mode    IssuerMatch
+		issuers []string
+		ok      bool
+	}{
+		{IssuerSubstring, []string{"Corp (CA"}, true},
+		{IssuerWildcard, []string{"Corp (CA *"}, true},
+		{IssuerRegexp, []string{"Corp CA", "Corp (CA"}, false},
+		{IssuerMatch(9), nil, false},
+	}
+	for _, tt := range tests {
+		if err := validateIssuers(tt.mode, tt.issuers); (err == nil) != tt.ok {
+			t.Errorf("validateIssuers(%v, %q) returned error %v, want success: %t", tt.mode, tt.issuers, err, tt.ok)
+		}
+	}
+}
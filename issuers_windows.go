@@ -0,0 +1,100 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// SetIssuers replaces the issuers used to look up the certificate of w, so
+// that a long-running process can follow a CA migration without opening the
+// store again. It returns an error if an issuer is not a valid pattern for
+// the IssuerMatch mode of w. It is safe to call concurrently with other
+// methods.
+func (w *WinCertStore) SetIssuers(issuers []string) error {
+	if err := validateIssuers(w.issuerMatch, issuers); err != nil {
+		return err
+	}
+	w.issuersMu.Lock()
+	defer w.issuersMu.Unlock()
+	w.issuers = append([]string(nil), issuers...)
+	return nil
+}
+
+// SetIntermediateIssuers replaces the issuers used to look up the
+// intermediate certificate of w, like SetIssuers.
+func (w *WinCertStore) SetIntermediateIssuers(issuers []string) error {
+	if err := validateIssuers(w.issuerMatch, issuers); err != nil {
+		return err
+	}
+	w.issuersMu.Lock()
+	defer w.issuersMu.Unlock()
+	w.intermediateIssuers = append([]string(nil), issuers...)
+	return nil
+}
+
+// issuerList returns the current issuers of w.
+func (w *WinCertStore) issuerList() []string {
+	w.issuersMu.RLock()
+	defer w.issuersMu.RUnlock()
+	return w.issuers
+}
+
+// intermediateIssuerList returns the current intermediate issuers of w.
+func (w *WinCertStore) intermediateIssuerList() []string {
+	w.issuersMu.RLock()
+	defer w.issuersMu.RUnlock()
+	return w.intermediateIssuers
+}
+
+// findIssuedCert returns the next certificate in store after prev that was
+// issued by issuer according to the IssuerMatch mode of w, or nil if there is
+// none. Like findCert, it frees prev.
+func (w *WinCertStore) findIssuedCert(store windows.Handle, issuer string, prev *windows.CertContext) (*windows.CertContext, error) {
+	if w.issuerMatch == IssuerSubstring {
+		i, err := windows.UTF16PtrFromString(issuer)
+		if err != nil {
+			return nil, err
+		}
+		// pass 0 as the third parameter because it is not used
+		// https://msdn.microsoft.com/en-us/library/windows/desktop/aa376064(v=vs.85).aspx
+		return findCert(store, encodingX509ASN|encodingPKCS7, 0, findIssuerStr, unsafe.Pointer(i), prev)
+	}
+
+	// CryptoAPI has no pattern search, so test the issuer of every certificate.
+	re, err := issuerPattern(w.issuerMatch, issuer)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		nc, err := findCert(store, encodingX509ASN|encodingPKCS7, 0, findAny, nil, prev)
+		if err != nil || nc == nil {
+			return nil, err
+		}
+		prev = nc
+		xc, err := x509.ParseCertificate(certContextBytes(nc))
+		if err != nil {
+			continue
+		}
+		if matchIssuer(re, xc.Issuer) {
+			return nc, nil
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+)
+
+// KDFAlgorithm selects the key derivation function used by DeriveKey and
+// KDK.Derive.
+type KDFAlgorithm int
+
+const (
+	// KDFHKDF is HKDF (RFC 5869).
+	KDFHKDF KDFAlgorithm = iota
+	// KDFSP800108CTR is the SP800-108 KDF in counter mode with HMAC. It is
+	// only available for key derivation keys, not for ECDH secrets.
+	KDFSP800108CTR
+)
+
+func (a KDFAlgorithm) String() string {
+	switch a {
+	case KDFHKDF:
+		return "HKDF"
+	case KDFSP800108CTR:
+		return "SP800-108 CTR"
+	default:
+		return fmt.Sprintf("KDFAlgorithm(%d)", int(a))
+	}
+}
+
+// KDFParams describes a key derivation.
+type KDFParams struct {
+	Algorithm KDFAlgorithm
+	// Hash is the hash used by the HMAC of the KDF. The default is SHA256.
+	// For HKDF key derivation keys the hash is set when the key is created
+	// and Hash must be zero.
+	Hash crypto.Hash
+	// Salt and Info are the HKDF salt and info. For HKDF key derivation keys
+	// the salt is set when the key is created and Salt must be empty.
+	Salt []byte
+	Info []byte
+	// Label and Context are the SP800-108 label and context.
+	Label   []byte
+	Context []byte
+	// Length is the size of the derived key in bytes.
+	Length int
+}
+
+// Parameter types of the NCryptBuffer list, from bcrypt.h.
+const (
+	kdfHashAlgorithm = 0x00 // KDF_HASH_ALGORITHM
+	kdfLabel         = 0x0D // KDF_LABEL
+	kdfContext       = 0x0E // KDF_CONTEXT
+	kdfHKDFSalt      = 0x13 // KDF_HKDF_SALT
+	kdfHKDFInfo      = 0x14 // KDF_HKDF_INFO
+)
+
+// kdfHashNames maps the hashes supported for key derivation to bcrypt.h
+// algorithm names.
+var kdfHashNames = map[crypto.Hash]string{
+	crypto.SHA1:   "SHA1",
+	crypto.SHA256: "SHA256",
+	crypto.SHA384: "SHA384",
+	crypto.SHA512: "SHA512",
+}
+
+// kdfParam is one NCryptBuffer of a KDF parameter list.
+type kdfParam struct {
+	typ  uint32
+	data []byte
+}
+
+// kdfParams validates p and returns the parameter list for it. agreement is
+// set for derivations from an ECDH secret with NCryptDeriveKey, which takes
+// the salt as a parameter, and unset for NCryptKeyDerivation with a key
+// derivation key.
+func kdfParams(p KDFParams, agreement bool) ([]kdfParam, error) {
+	if p.Length <= 0 {
+		return nil, errors.New("derived key length must be positive")
+	}
+	var params []kdfParam
+	switch p.Algorithm {
+	case KDFHKDF:
+		if len(p.Label) > 0 || len(p.Context) > 0 {
+			return nil, errors.New("HKDF does not take a label or context")
+		}
+		if !agreement {
+			// The hash and salt of a HKDF key derivation key are set when it
+			// is created.
+			if p.Hash != 0 || len(p.Salt) > 0 {
+				return nil, errors.New("the HKDF hash and salt of a key derivation key are set when it is created")
+			}
+		} else {
+			hash, err := kdfHashParam(p.Hash)
+			if err != nil {
+				return nil, err
+			}
+			params = append(params, hash)
+		}
+		if len(p.Salt) > 0 {
+			params = append(params, kdfParam{kdfHKDFSalt, p.Salt})
+		}
+		if len(p.Info) > 0 {
+			params = append(params, kdfParam{kdfHKDFInfo, p.Info})
+		}
+	case KDFSP800108CTR:
+		if agreement {
+			return nil, fmt.Errorf("%v cannot derive from a secret agreement", p.Algorithm)
+		}
+		if len(p.Salt) > 0 || len(p.Info) > 0 {
+			return nil, errors.New("SP800-108 does not take a salt or info")
+		}
+		hash, err := kdfHashParam(p.Hash)
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, hash)
+		if len(p.Label) > 0 {
+			params = append(params, kdfParam{kdfLabel, p.Label})
+		}
+		if len(p.Context) > 0 {
+			params = append(params, kdfParam{kdfContext, p.Context})
+		}
+	default:
+		return nil, fmt.Errorf("unsupported KDF algorithm %v", p.Algorithm)
+	}
+	return params, nil
+}
+
+// kdfHashParam returns the KDF_HASH_ALGORITHM parameter for hash, which
+// defaults to SHA256.
+func kdfHashParam(hash crypto.Hash) (kdfParam, error) {
+	if hash == 0 {
+		hash = crypto.SHA256
+	}
+	name, ok := kdfHashNames[hash]
+	if !ok {
+		return kdfParam{}, fmt.Errorf("unsupported KDF hash %v", hash)
+	}
+	return kdfParam{kdfHashAlgorithm, utf16z(name)}, nil
+}
+
+// utf16z encodes s as a NUL terminated little-endian UTF-16 string.
+func utf16z(s string) []byte {
+	u := append(utf16.Encode([]rune(s)), 0)
+	b := make([]byte, 2*len(u))
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(b[2*i:], c)
+	}
+	return b
+}
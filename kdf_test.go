@@ -0,0 +1,72 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bytes"
+	"crypto"
+	"testing"
+)
+
+func TestKDFParams(t *testing.T) {
+	tests := []struct {
+		params    KDFParams
+		agreement bool
+		wantTypes []uint32
+		ok        bool
+	}{
+		{KDFParams{Length: 32}, true, []uint32{kdfHashAlgorithm}, true},
+		{KDFParams{Salt: []byte("s"), Info: []byte("i"), Length: 32}, true, []uint32{kdfHashAlgorithm, kdfHKDFSalt, kdfHKDFInfo}, true},
+		{KDFParams{Salt: []byte("s"), Length: 32}, false, nil, false},
+		{KDFParams{Info: []byte("i"), Length: 32}, false, []uint32{kdfHKDFInfo}, true},
+		{KDFParams{Hash: crypto.SHA256, Length: 32}, false, nil, false},
+		{KDFParams{Label: []byte("l"), Length: 32}, true, nil, false},
+		{KDFParams{Algorithm: KDFSP800108CTR, Label: []byte("l"), Context: []byte("c"), Length: 16}, false, []uint32{kdfHashAlgorithm, kdfLabel, kdfContext}, true},
+		{KDFParams{Algorithm: KDFSP800108CTR, Length: 16}, true, nil, false},
+		{KDFParams{Algorithm: KDFSP800108CTR, Info: []byte("i"), Length: 16}, false, nil, false},
+		{KDFParams{Hash: crypto.MD5, Length: 16}, true, nil, false},
+		{KDFParams{}, true, nil, false},
+		{KDFParams{Algorithm: KDFAlgorithm(5), Length: 16}, false, nil, false},
+	}
+	for _, tt := range tests {
+		params, err := kdfParams(tt.params, tt.agreement)
+		if (err == nil) != tt.ok {
+			t.Errorf("kdfParams(%+v, %t) returned error %v, want success: %t", tt.params, tt.agreement, err, tt.ok)
+			continue
+		}
+		var types []uint32
+		for _, p := range params {
+			types = append(types, p.typ)
+		}
+		if len(types) != len(tt.wantTypes) {
+			t.Errorf("kdfParams(%+v, %t) returned parameter types %v, want: %v", tt.params, tt.agreement, types, tt.wantTypes)
+			continue
+		}
+		for i := range types {
+			if types[i] != tt.wantTypes[i] {
+				t.Errorf("kdfParams(%+v, %t) returned parameter types %v, want: %v", tt.params, tt.agreement, types, tt.wantTypes)
+				break
+			}
+		}
+	}
+
+	params, err := kdfParams(KDFParams{Hash: crypto.SHA384, Length: 48}, true)
+	if err != nil {
+		t.Fatalf("kdfParams returned %v", err)
+	}
+	if want := []byte("S\x00H\x00A\x003\x008\x004\x00\x00\x00"); !bytes.Equal(params[0].data, want) {
+		t.Errorf("hash parameter = %q, want: %q", params[0].data, want)
+	}
+}
@@ -0,0 +1,168 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"runtime"
+	"time"
+	"unsafe"
+)
+
+var (
+	nCryptImportKey       = nCrypt.MustFindProc("NCryptImportKey")
+	nCryptSecretAgreement = nCrypt.MustFindProc("NCryptSecretAgreement")
+	nCryptDeriveKey       = nCrypt.MustFindProc("NCryptDeriveKey")
+	nCryptKeyDerivation   = nCrypt.MustFindProc("NCryptKeyDerivation")
+
+	// bCryptKDFHKDF is BCRYPT_KDF_HKDF.
+	bCryptKDFHKDF = wide("HKDF")
+)
+
+// DeriveKey agrees on a secret with peer using the ECDH key k and derives a
+// key of params.Length bytes from it. The secret never leaves the provider.
+// Only KDFHKDF can be used with a secret agreement.
+func (k *EcdsaKey) DeriveKey(peer *ecdsa.PublicKey, params KDFParams) (_ []byte, err error) {
+	defer keyOp(k.stats, k.breaker, "derive", k.Container, time.Now(), &err)
+	if err := k.breaker.allow(); err != nil {
+		return nil, err
+	}
+	list, err := kdfParams(params, true)
+	if err != nil {
+		return nil, err
+	}
+	if peer == nil {
+		return nil, &ArgError{Op: "DeriveKey", Arg: "peer", Reason: "peer key is nil"}
+	}
+	if peer.Curve != k.pub.Curve {
+		return nil, fmt.Errorf("peer key is on curve %s, want %s", peer.Curve.Params().Name, k.pub.Curve.Params().Name)
+	}
+	blob, err := marshalECDHPublicBlob(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	var pub uintptr
+	r, _, err := nCryptImportKey.Call(
+		k.prov,
+		0,
+		uintptr(unsafe.Pointer(wide(bCryptECCPublicBlob))),
+		0,
+		uintptr(unsafe.Pointer(&pub)),
+		uintptr(unsafe.Pointer(&blob[0])),
+		uintptr(len(blob)),
+		0)
+	if r != 0 {
+		return nil, ncryptErr("NCryptImportKey", r, "for the peer public key", err)
+	}
+	defer closeKey(&pub)
+
+	var secret uintptr
+	r, _, err = nCryptSecretAgreement.Call(k.handle, pub, uintptr(unsafe.Pointer(&secret)), 0)
+	if r != 0 {
+		return nil, ncryptErr("NCryptSecretAgreement", r, "", err)
+	}
+	defer closeKey(&secret)
+
+	desc, bufs := kdfBufferDesc(list)
+	derived := make([]byte, params.Length)
+	var n uint32
+	r, _, err = nCryptDeriveKey.Call(
+		secret,
+		uintptr(unsafe.Pointer(bCryptKDFHKDF)),
+		uintptr(unsafe.Pointer(desc)),
+		uintptr(unsafe.Pointer(&derived[0])),
+		uintptr(len(derived)),
+		uintptr(unsafe.Pointer(&n)),
+		0)
+	runtime.KeepAlive(bufs)
+	runtime.KeepAlive(list)
+	if r != 0 {
+		return nil, ncryptErr("NCryptDeriveKey", r, "", err)
+	}
+	wipe(derived[n:])
+	return derived[:n], nil
+}
+
+// KDK is a key derivation key in the key storage provider, such as a
+// SP800_108_CTR_HMAC or HKDF key. Keys are derived from it without the key
+// material ever leaving the provider.
+type KDK struct {
+	handle    uintptr
+	Container string
+}
+
+// KDK opens the key derivation key in container of the store's provider.
+func (w *WinCertStore) KDK(container string) (*KDK, error) {
+	if err := checkContainer("KDK", container); err != nil {
+		return nil, err
+	}
+	container = namespacedName(w.namespace, container)
+	kh, err := openKey(w.Prov, container, w.keyOpenFlags())
+	if err != nil {
+		return nil, err
+	}
+	return &KDK{handle: kh, Container: container}, nil
+}
+
+// Derive derives a key of params.Length bytes. params.Algorithm must match the
+// algorithm the key derivation key was created with.
+func (k *KDK) Derive(params KDFParams) (_ []byte, err error) {
+	defer logKeyOp("derive", k.Container, time.Now(), &err)
+	list, err := kdfParams(params, false)
+	if err != nil {
+		return nil, err
+	}
+
+	desc, bufs := kdfBufferDesc(list)
+	derived := make([]byte, params.Length)
+	var n uint32
+	r, _, err := nCryptKeyDerivation.Call(
+		k.handle,
+		uintptr(unsafe.Pointer(desc)),
+		uintptr(unsafe.Pointer(&derived[0])),
+		uintptr(len(derived)),
+		uintptr(unsafe.Pointer(&n)),
+		0)
+	runtime.KeepAlive(bufs)
+	runtime.KeepAlive(list)
+	if r != 0 {
+		return nil, ncryptErr("NCryptKeyDerivation", r, "for container "+k.Container, err)
+	}
+	wipe(derived[n:])
+	return derived[:n], nil
+}
+
+// Close releases the key handle. It is safe to call Close more than once.
+func (k *KDK) Close() error {
+	return closeKey(&k.handle)
+}
+
+// kdfBufferDesc builds the NCryptBufferDesc for list. The returned buffers
+// must be kept alive until the call that uses the descriptor returns. An
+// empty list results in a nil descriptor.
+func kdfBufferDesc(list []kdfParam) (*ncryptBufferDesc, []ncryptBuffer) {
+	if len(list) == 0 {
+		return nil, nil
+	}
+	bufs := make([]ncryptBuffer, len(list))
+	for i, p := range list {
+		bufs[i] = ncryptBuffer{cbBuffer: uint32(len(p.data)), bufferType: p.typ, pvBuffer: &p.data[0]}
+	}
+	return &ncryptBufferDesc{cBuffers: uint32(len(bufs)), pBuffers: &bufs[0]}, bufs
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"errors"
+	"time"
+)
+
+// KeyTimes holds when a key was created and last changed.
+type KeyTimes struct {
+	// Created is the creation time of the key file, or the zero time if the
+	// key is not kept in a file that could be found, as for TPM keys.
+	Created time.Time
+	// Modified is the time the key was last persisted, or the zero time if
+	// the provider does not record it.
+	Modified time.Time
+}
+
+// Age returns how long before now the key was created. CNG has no creation
+// time property, so if the key file was not found the modification time is
+// used instead. It returns false if neither time is known.
+func (t KeyTimes) Age(now time.Time) (time.Duration, bool) {
+	switch {
+	case !t.Created.IsZero():
+		return now.Sub(t.Created), true
+	case !t.Modified.IsZero():
+		return now.Sub(t.Modified), true
+	default:
+		return 0, false
+	}
+}
+
+// errKeyAgeUnknown is returned if a key has neither a creation nor a
+// modification time.
+var errKeyAgeUnknown = errors.New("the key has no creation or modification time")
+
+// RotationContainerName returns the name of the key container that
+// WinCertStore.RotateIfOlderThan alternates with container, so that a new
+// key never replaces the key of the current certificate.
+func RotationContainerName(container string) string {
+	return container + "-NEXT"
+}
+
+// rotationTarget returns the container of the rotation pair of container
+// that does not hold the active key.
+func rotationTarget(container, active string) string {
+	if active == RotationContainerName(container) {
+		return container
+	}
+	return RotationContainerName(container)
+}
+
+// rotationDue reports whether a key with times t is older than maxAge at now.
+func rotationDue(t KeyTimes, maxAge time.Duration, now time.Time) (bool, error) {
+	if maxAge <= 0 {
+		return false, &ArgError{Op: "RotateIfOlderThan", Arg: "maxAge", Reason: "maxAge must be positive"}
+	}
+	age, ok := t.Age(now)
+	if !ok {
+		return false, errKeyAgeUnknown
+	}
+	return age > maxAge, nil
+}
@@ -0,0 +1,70 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRotationDue(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	year := 365 * 24 * time.Hour
+	tests := []struct {
+		desc  string
+		times KeyTimes
+		want  bool
+	}{
+		{"created recently", KeyTimes{Created: now.Add(-24 * time.Hour), Modified: now.Add(-2 * year)}, false},
+		{"created long ago", KeyTimes{Created: now.Add(-2 * year), Modified: now.Add(-time.Hour)}, true},
+		{"modified only", KeyTimes{Modified: now.Add(-2 * year)}, true},
+	}
+	for _, tt := range tests {
+		got, err := rotationDue(tt.times, year, now)
+		if err != nil {
+			t.Errorf("%s: rotationDue returned %v", tt.desc, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: rotationDue = %t, want: %t", tt.desc, got, tt.want)
+		}
+	}
+
+	if _, err := rotationDue(KeyTimes{}, year, now); err != errKeyAgeUnknown {
+		t.Errorf("rotationDue without times returned %v, want: %v", err, errKeyAgeUnknown)
+	}
+	if _, err := rotationDue(KeyTimes{Created: now}, 0, now); err == nil {
+		t.Error("rotationDue succeeded with a zero maxAge, want error")
+	}
+}
+
+func TestRotationTarget(t *testing.T) {
+	next := RotationContainerName("app")
+	if next == "app" {
+		t.Fatalf("RotationContainerName(%q) = %q, want a different container", "app", next)
+	}
+	tests := []struct {
+		active string
+		want   string
+	}{
+		{"app", next},
+		{next, "app"},
+	}
+	for _, tt := range tests {
+		if got := rotationTarget("app", tt.active); got != tt.want {
+			t.Errorf("rotationTarget(%q, %q) = %q, want: %q", "app", tt.active, got, tt.want)
+		}
+	}
+}
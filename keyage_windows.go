@@ -0,0 +1,140 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Times returns when the key was created and last modified.
+func (k *RsaKey) Times() (*KeyTimes, error) {
+	return keyTimes(k.handle, k.location)
+}
+
+// Times returns when the key was created and last modified.
+func (k *EcdsaKey) Times() (*KeyTimes, error) {
+	return keyTimes(k.handle, k.location)
+}
+
+func keyTimes(kh uintptr, loc *KeyLocation) (*KeyTimes, error) {
+	t := &KeyTimes{}
+	if b, err := getProperty(kh, "Modified"); err == nil {
+		if t.Modified, err = parseFiletime(b); err != nil {
+			return nil, err
+		}
+	}
+	if loc != nil && loc.Path != "" {
+		fi, err := os.Stat(loc.Path)
+		if err != nil {
+			return nil, err
+		}
+		if d, ok := fi.Sys().(*syscall.Win32FileAttributeData); ok {
+			t.Created = time.Unix(0, d.CreationTime.Nanoseconds()).UTC()
+		}
+	}
+	return t, nil
+}
+
+// RotateIfOlderThan generates a new key if the current key was created more
+// than maxAge ago, with the algorithm, size, usage, export policy, scope and
+// use context of the current key. This enforces key lifetimes independently
+// of certificate validity. The new key is generated in the container that
+// does not hold the current key, alternating between the container of w and
+// RotationContainerName of it, so the current key and its certificate stay
+// usable until a certificate for the new key is stored. Key then switches to
+// the new key. A new key that is still younger than maxAge is kept, so that
+// repeated calls do not replace a key that is being enrolled. The Result has
+// no changes if no key was generated.
+func (w *WinCertStore) RotateIfOlderThan(maxAge time.Duration) (_ *Result, err error) {
+	defer logKeyOp("rotate", w.container, time.Now(), &err)
+	res := &Result{Operation: "rotate", Container: w.container}
+	if err := checkContainer("RotateIfOlderThan", w.container); err != nil {
+		return res, err
+	}
+	active := w.activeContainer()
+	key, err := w.containerKey(active)
+	if err != nil {
+		return res, err
+	}
+	opts, due, err := rotationOpts(key, maxAge)
+	key.Close()
+	if err != nil || !due {
+		return res, err
+	}
+
+	target := rotationTarget(w.container, active)
+	if pending, err := w.containerKey(target); err == nil {
+		times, err := pending.Times()
+		pending.Close()
+		if err == nil {
+			if due, err := rotationDue(*times, maxAge, time.Now()); err == nil && !due {
+				logInfo("Keeping the pending rotated key.", opField("rotate"), containerField(target))
+				return res, nil
+			}
+		}
+	}
+
+	signer, err := w.view(target, w.keyAlgorithm).generate(opts)
+	if err != nil {
+		return res, err
+	}
+	if k, ok := signer.(Key); ok {
+		k.Close()
+	}
+	res.addAuditedChange(ActionGenerated, "", w.ProvName)
+	return res, nil
+}
+
+// rotationOpts reports whether key is older than maxAge and returns the
+// options that generate a key with the same policy.
+func rotationOpts(key Key, maxAge time.Duration) (GenerateOpts, bool, error) {
+	times, err := key.Times()
+	if err != nil {
+		return GenerateOpts{}, false, err
+	}
+	due, err := rotationDue(*times, maxAge, time.Now())
+	if err != nil || !due {
+		return GenerateOpts{}, false, err
+	}
+	alg, err := getPropertyString(key.Handle(), "Algorithm Name")
+	if err != nil {
+		return GenerateOpts{}, false, fmt.Errorf("could not determine the key algorithm: %v", err)
+	}
+	policy, err := key.Policy()
+	if err != nil {
+		return GenerateOpts{}, false, err
+	}
+	opts := GenerateOpts{Algorithm: alg, Export: policy.Export}
+	// Keys that allow every usage get the default usage of their algorithm,
+	// which is all generate accepts for them.
+	if policy.Usage != KeyUsageAll {
+		opts.Usage = policy.Usage
+	}
+	if alg == "RSA" {
+		opts.KeySize = policy.Length
+	}
+	if loc := key.Location(); loc != nil {
+		opts.Machine = loc.Machine
+	}
+	if opts.UseContext, err = key.UseContext(); err != nil {
+		return GenerateOpts{}, false, err
+	}
+	return opts, true, nil
+}
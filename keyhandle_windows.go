@@ -0,0 +1,161 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// OpenKeyOptions configures the keys opened with OpenKey. The fields have the
+// meaning of the WinCertStoreOptions fields of the same name.
+type OpenKeyOptions struct {
+	AllowPrivateExport bool
+	// RawFlags may only hold flags for FlagOpOpenKey.
+	RawFlags       RawFlags
+	CircuitBreaker CircuitBreaker
+	UsageQuota     UsageQuota
+	SignatureCheck SignatureCheck
+}
+
+// OpenKey opens the key in container of the key storage provider, such as
+// ProviderMSPlatform, for tools that sign but do not use certificate stores
+// and so have no issuers to configure a WinCertStore with.
+func OpenKey(provider, container string, opts OpenKeyOptions) (Key, error) {
+	if err := checkContainer("OpenKey", container); err != nil {
+		return nil, err
+	}
+	for op := range opts.RawFlags {
+		if op != FlagOpOpenKey {
+			return nil, &ArgError{Op: "OpenKey", Arg: "opts", Reason: fmt.Sprintf("raw flags for %v do not apply to OpenKey", op)}
+		}
+	}
+	w, err := OpenWinCertStoreWithOptions(WinCertStoreOptions{
+		Provider:           provider,
+		Container:          container,
+		AllowPrivateExport: opts.AllowPrivateExport,
+		RawFlags:           opts.RawFlags,
+		CircuitBreaker:     opts.CircuitBreaker,
+		UsageQuota:         opts.UsageQuota,
+		SignatureCheck:     opts.SignatureCheck,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return w.Key()
+}
+
+// openKey wraps NCryptOpenKey for the named container of the provider.
+func openKey(prov uintptr, name string, flags uint32) (uintptr, error) {
+	var kh uintptr
+	r, _, err := nCryptOpenKey.Call(
+		prov,
+		uintptr(unsafe.Pointer(&kh)),
+		uintptr(unsafe.Pointer(wide(name))),
+		0,
+		uintptr(flags))
+	if r != 0 {
+		return 0, ncryptErr("NCryptOpenKey", r, "for container "+name, err)
+	}
+	return kh, nil
+}
+
+// Duplicate opens the container of k again and returns an RsaKey with an
+// independent handle, so that different components can use and close their
+// own copy of the key.
+func (k *RsaKey) Duplicate() (Key, error) {
+	kh, err := openKey(k.prov, k.name, k.openFlags)
+	if err != nil {
+		return nil, err
+	}
+	dup := *k
+	dup.handle = kh
+	dup.stats = newKeyStats()
+	return &dup, nil
+}
+
+// Duplicate opens the container of k again and returns an EcdsaKey with an
+// independent handle, so that different components can use and close their
+// own copy of the key.
+func (k *EcdsaKey) Duplicate() (Key, error) {
+	kh, err := openKey(k.prov, k.name, k.openFlags)
+	if err != nil {
+		return nil, err
+	}
+	dup := *k
+	dup.handle = kh
+	dup.stats = newKeyStats()
+	return &dup, nil
+}
+
+// Stats returns the counters of the operations on the key since it was
+// opened. Duplicates have their own counters.
+func (k *RsaKey) Stats() KeyStats {
+	return k.stats.snapshot()
+}
+
+// Stats returns the counters of the operations on the key since it was
+// opened. Duplicates have their own counters.
+func (k *EcdsaKey) Stats() KeyStats {
+	return k.stats.snapshot()
+}
+
+// Handle returns the NCRYPT_KEY_HANDLE of k for calling NCrypt functions
+// that this package does not wrap. The handle is owned by k: it must not be
+// freed with NCryptFreeObject or NCryptDeleteKey, and it is only valid until
+// k is closed or deleted, after which Handle returns 0. Callers that need a
+// handle with a lifetime of its own should use Duplicate. Operations on the
+// handle are not counted in Stats and bypass the circuit breaker.
+func (k *RsaKey) Handle() uintptr {
+	return k.handle
+}
+
+// Handle returns the NCRYPT_KEY_HANDLE of k, see RsaKey.Handle for the
+// ownership rules.
+func (k *EcdsaKey) Handle() uintptr {
+	return k.handle
+}
+
+// ProviderHandle returns the NCRYPT_PROV_HANDLE of the key storage provider
+// of w for calling NCrypt functions that this package does not wrap. The
+// handle is owned by w and shared with the keys opened from it, so it must
+// not be freed with NCryptFreeObject. It stays valid as long as w is in use.
+func (w *WinCertStore) ProviderHandle() uintptr {
+	return w.Prov
+}
+
+// Close releases the key handle. It is safe to call Close more than once.
+func (k *RsaKey) Close() error {
+	return closeKey(&k.handle)
+}
+
+// Close releases the key handle. It is safe to call Close more than once.
+func (k *EcdsaKey) Close() error {
+	return closeKey(&k.handle)
+}
+
+func closeKey(kh *uintptr) error {
+	if *kh == 0 {
+		return nil
+	}
+	r, _, err := nCryptFreeObject.Call(*kh)
+	if r != 0 {
+		return ncryptErr("NCryptFreeObject", r, "", err)
+	}
+	*kh = 0
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// KeyLengths describes the key sizes in bits a provider supports for an
+// algorithm, as reported in the NCRYPT_SUPPORTED_LENGTHS struct.
+type KeyLengths struct {
+	Min       int
+	Max       int
+	Increment int
+	Default   int
+}
+
+// Supports reports whether size is one of the supported key sizes.
+func (l KeyLengths) Supports(size int) bool {
+	if size < l.Min || size > l.Max {
+		return false
+	}
+	return l.Increment == 0 || (size-l.Min)%l.Increment == 0
+}
+
+func (l KeyLengths) String() string {
+	return fmt.Sprintf("%d-%d bits in steps of %d", l.Min, l.Max, l.Increment)
+}
+
+// parseKeyLengths decodes a NCRYPT_SUPPORTED_LENGTHS struct.
+func parseKeyLengths(b []byte) (KeyLengths, error) {
+	if len(b) < 16 {
+		return KeyLengths{}, fmt.Errorf("supported lengths are too short (%d bytes)", len(b))
+	}
+	return KeyLengths{
+		Min:       int(binary.LittleEndian.Uint32(b[0:])),
+		Max:       int(binary.LittleEndian.Uint32(b[4:])),
+		Increment: int(binary.LittleEndian.Uint32(b[8:])),
+		Default:   int(binary.LittleEndian.Uint32(b[12:])),
+	}, nil
+}
+
+// UnsupportedKeyError is returned by Generate when the provider cannot create
+// a key with the requested algorithm or size.
+type UnsupportedKeyError struct {
+	Provider  string
+	Algorithm string
+	KeySize   int
+	// Supported lists the sizes the provider supports for the algorithm, or
+	// is nil if the provider does not support the algorithm at all.
+	Supported *KeyLengths
+}
+
+func (e *UnsupportedKeyError) Error() string {
+	if e.Supported == nil {
+		return fmt.Sprintf("provider %q does not support %s keys", e.Provider, e.Algorithm)
+	}
+	return fmt.Sprintf("provider %q does not support %d bit %s keys, supported sizes are %v", e.Provider, e.KeySize, e.Algorithm, e.Supported)
+}
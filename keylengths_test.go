@@ -0,0 +1,55 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"testing"
+)
+
+func TestKeyLengths(t *testing.T) {
+	// The RSA lengths reported by the Microsoft Platform Crypto Provider.
+	b := []byte{
+		0x00, 0x04, 0x00, 0x00, // dwMinLength 1024
+		0x00, 0x08, 0x00, 0x00, // dwMaxLength 2048
+		0x00, 0x04, 0x00, 0x00, // dwIncrement 1024
+		0x00, 0x08, 0x00, 0x00, // dwDefaultLength 2048
+	}
+	l, err := parseKeyLengths(b)
+	if err != nil {
+		t.Fatalf("parseKeyLengths returned %v", err)
+	}
+	want := KeyLengths{Min: 1024, Max: 2048, Increment: 1024, Default: 2048}
+	if l != want {
+		t.Errorf("unexpected lengths got: %+v, want: %+v", l, want)
+	}
+
+	for size, ok := range map[int]bool{1024: true, 2048: true, 1536: false, 4096: false, 512: false} {
+		if got := l.Supports(size); got != ok {
+			t.Errorf("Supports(%d) = %t, want: %t", size, got, ok)
+		}
+	}
+
+	if _, err := parseKeyLengths(b[:12]); err == nil {
+		t.Error("parseKeyLengths succeeded on a short buffer, want error")
+	}
+}
+
+func TestUnsupportedKeyError(t *testing.T) {
+	err := &UnsupportedKeyError{Provider: "Microsoft Platform Crypto Provider", Algorithm: "RSA", KeySize: 4096, Supported: &KeyLengths{Min: 1024, Max: 2048, Increment: 1024}}
+	want := `provider "Microsoft Platform Crypto Provider" does not support 4096 bit RSA keys, supported sizes are 1024-2048 bits in steps of 1024`
+	if err.Error() != want {
+		t.Errorf("unexpected error got: %s, want: %s", err, want)
+	}
+}
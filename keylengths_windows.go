@@ -0,0 +1,97 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"unsafe"
+)
+
+const (
+	// winerror.h constants
+	nteNotSupported = 0x80090029 // NTE_NOT_SUPPORTED
+	nteBadAlgID     = 0x80090008 // NTE_BAD_ALGID
+	nteInvalidParam = 0x80090027 // NTE_INVALID_PARAMETER
+	nteExists       = 0x8009000F // NTE_EXISTS
+)
+
+// SupportedKeyLengths returns the key sizes the provider of w supports for
+// algorithm alg, such as "RSA". It returns an *UnsupportedKeyError if the
+// provider does not support the algorithm.
+func (w *WinCertStore) SupportedKeyLengths(alg string) (*KeyLengths, error) {
+	kh, err := w.ephemeralKey(alg)
+	if err != nil {
+		return nil, err
+	}
+	defer nCryptFreeObject.Call(kh)
+
+	b, err := getProperty(kh, "Lengths")
+	if err != nil {
+		return nil, err
+	}
+	l, err := parseKeyLengths(b)
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// validateKeyParams checks that the provider of w can create a key of the
+// given algorithm and size before anything is persisted, so that callers get
+// an *UnsupportedKeyError instead of a failure from NCryptFinalizeKey. It
+// returns the key size to use, which is the provider default if keySize is 0
+// and the provider reports one.
+func (w *WinCertStore) validateKeyParams(algID string, keySize int) (int, error) {
+	l, err := w.SupportedKeyLengths(algID)
+	if err != nil {
+		if _, ok := err.(*UnsupportedKeyError); ok {
+			return 0, err
+		}
+		// Not every provider reports its supported lengths, let the provider
+		// decide when the key is created.
+		logDebug("Could not query supported key lengths.", opField("generate"), field("provider", w.ProvName), field("algorithm", algID), errField(err))
+		return keySize, nil
+	}
+	if keySize == 0 {
+		return l.Default, nil
+	}
+	if !l.Supports(keySize) {
+		return 0, &UnsupportedKeyError{Provider: w.ProvName, Algorithm: algID, KeySize: keySize, Supported: l}
+	}
+	return keySize, nil
+}
+
+// ephemeralKey creates a key object for alg that is neither finalized nor
+// persisted, which allows querying algorithm properties of the provider. The
+// caller must free the returned handle.
+func (w *WinCertStore) ephemeralKey(alg string) (uintptr, error) {
+	var kh uintptr
+	r, _, err := nCryptCreatePersistedKey.Call(
+		w.Prov,
+		uintptr(unsafe.Pointer(&kh)),
+		uintptr(unsafe.Pointer(wide(alg))),
+		0,
+		0,
+		0)
+	switch r {
+	case 0:
+		return kh, nil
+	case nteNotSupported, nteBadAlgID, nteInvalidParam:
+		return 0, &UnsupportedKeyError{Provider: w.ProvName, Algorithm: alg}
+	default:
+		return 0, ncryptErr("NCryptCreatePersistedKey", r, "for an ephemeral "+alg+" key", err)
+	}
+}
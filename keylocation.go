@@ -0,0 +1,123 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"fmt"
+	"strings"
+)
+
+// KeyResidency tells where a key storage provider keeps the private key.
+type KeyResidency int
+
+// Key residencies.
+const (
+	// ResidencyUnknown is used for providers this package does not know.
+	ResidencyUnknown KeyResidency = iota
+	// ResidencyFile keys are kept in files on disk, protected with DPAPI.
+	ResidencyFile
+	// ResidencyTPM keys never leave the TPM in plaintext.
+	ResidencyTPM
+)
+
+func (r KeyResidency) String() string {
+	switch r {
+	case ResidencyUnknown:
+		return "unknown"
+	case ResidencyFile:
+		return "file"
+	case ResidencyTPM:
+		return "tpm"
+	default:
+		return fmt.Sprintf("KeyResidency(%d)", int(r))
+	}
+}
+
+// providerResidency returns the residency of the keys of provider.
+func providerResidency(provider string) KeyResidency {
+	switch provider {
+	case ProviderMSSoftware:
+		return ResidencyFile
+	case ProviderMSPlatform:
+		return ResidencyTPM
+	default:
+		return ResidencyUnknown
+	}
+}
+
+// KeyLocation describes where a persisted key is stored.
+type KeyLocation struct {
+	Provider string
+	// Container is the name the key was created or opened with.
+	Container string
+	// UniqueName is the NCRYPT_UNIQUE_NAME_PROPERTY of the key, which the
+	// Microsoft providers use as the name of the key file.
+	UniqueName string
+	// Path is the file that holds the key, or "" if the provider does not
+	// keep keys in files or the file could not be found.
+	Path string
+	// Machine is set for machine keys rather than user keys.
+	Machine   bool
+	Residency KeyResidency
+}
+
+// container returns the value of the Container field of keys at l: the
+// path of keys with a file and the unique name of the others. Ephemeral
+// keys have no location and an empty container.
+func (l *KeyLocation) container() string {
+	switch {
+	case l == nil:
+		return ""
+	case l.Path != "":
+		return l.Path
+	default:
+		return l.UniqueName
+	}
+}
+
+// keyFileCandidates returns the files that may hold the software provider
+// key with uniqueName, in the order they are searched. User keys are kept
+// in the roaming profile, machine keys under ProgramData, and the keys of
+// the SYSTEM account in SystemKeys. getenv looks up environment variables.
+func keyFileCandidates(uniqueName string, machine bool, getenv func(string) string) []string {
+	if isAbsWindowsPath(uniqueName) {
+		return []string{uniqueName}
+	}
+	dir := func(env, sub string) string {
+		base := getenv(env)
+		if base == "" {
+			return ""
+		}
+		return strings.TrimRight(base, `\`) + `\Microsoft\Crypto\` + sub + `\` + uniqueName
+	}
+	var dirs []string
+	if machine {
+		dirs = []string{dir("ProgramData", "Keys"), dir("ProgramData", "SystemKeys")}
+	} else {
+		dirs = []string{dir("APPDATA", "Keys"), dir("ProgramData", "SystemKeys"), dir("ProgramData", "Keys")}
+	}
+	var candidates []string
+	for _, d := range dirs {
+		if d != "" {
+			candidates = append(candidates, d)
+		}
+	}
+	return candidates
+}
+
+// isAbsWindowsPath reports whether p is a drive or UNC path.
+func isAbsWindowsPath(p string) bool {
+	return strings.HasPrefix(p, `\\`) || (len(p) >= 3 && p[1] == ':' && p[2] == '\\')
+}
@@ -0,0 +1,74 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKeyFileCandidates(t *testing.T) {
+	env := map[string]string{
+		"APPDATA":     `C:\Users\u\AppData\Roaming`,
+		"ProgramData": `C:\ProgramData\`,
+	}
+	getenv := func(k string) string { return env[k] }
+	tests := []struct {
+		name    string
+		unique  string
+		machine bool
+		want    []string
+	}{
+		{"user", "abc_1", false, []string{
+			`C:\Users\u\AppData\Roaming\Microsoft\Crypto\Keys\abc_1`,
+			`C:\ProgramData\Microsoft\Crypto\SystemKeys\abc_1`,
+			`C:\ProgramData\Microsoft\Crypto\Keys\abc_1`,
+		}},
+		{"machine", "abc_1", true, []string{
+			`C:\ProgramData\Microsoft\Crypto\Keys\abc_1`,
+			`C:\ProgramData\Microsoft\Crypto\SystemKeys\abc_1`,
+		}},
+		{"path", `D:\keys\abc_1`, false, []string{`D:\keys\abc_1`}},
+		{"unc", `\\server\keys\abc_1`, true, []string{`\\server\keys\abc_1`}},
+	}
+	for _, tt := range tests {
+		if got := keyFileCandidates(tt.unique, tt.machine, getenv); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: keyFileCandidates = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if got := keyFileCandidates("abc_1", false, func(string) string { return "" }); len(got) != 0 {
+		t.Errorf("keyFileCandidates without environment = %q, want none", got)
+	}
+}
+
+func TestKeyLocationContainer(t *testing.T) {
+	var nilLoc *KeyLocation
+	tests := []struct {
+		loc  *KeyLocation
+		want string
+	}{
+		{nilLoc, ""},
+		{&KeyLocation{UniqueName: "u"}, "u"},
+		{&KeyLocation{UniqueName: "u", Path: `C:\k\u`}, `C:\k\u`},
+	}
+	for _, tt := range tests {
+		if got := tt.loc.container(); got != tt.want {
+			t.Errorf("%+v.container() = %q, want %q", tt.loc, got, tt.want)
+		}
+	}
+	if providerResidency(ProviderMSPlatform) != ResidencyTPM || providerResidency(ProviderMSSoftware) != ResidencyFile || providerResidency("other") != ResidencyUnknown {
+		t.Errorf("providerResidency does not match the providers")
+	}
+}
@@ -0,0 +1,78 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"os"
+)
+
+// keyLocation resolves where the key opened as name in provider is stored.
+// The key file is searched for rather than assumed, since its directory
+// depends on the account and on the Windows release.
+func keyLocation(kh uintptr, provider, name string) (*KeyLocation, error) {
+	unique, err := container(kh)
+	if err != nil {
+		return nil, err
+	}
+	loc := &KeyLocation{
+		Provider:   provider,
+		Container:  name,
+		UniqueName: unique,
+		Residency:  providerResidency(provider),
+	}
+	// NCRYPT_KEY_TYPE_PROPERTY holds NCRYPT_MACHINE_KEY_FLAG for machine keys.
+	if kt, err := getPropertyUint32(kh, "Key Type"); err == nil {
+		loc.Machine = kt&nCryptMachineKey != 0
+	}
+	if loc.Residency == ResidencyFile {
+		for _, p := range keyFileCandidates(unique, loc.Machine, os.Getenv) {
+			if _, err := os.Stat(p); err == nil {
+				loc.Path = p
+				break
+			}
+		}
+	}
+	return loc, nil
+}
+
+// Location returns where the key is stored, or nil for ephemeral keys.
+func (k *RsaKey) Location() *KeyLocation {
+	return copyLocation(k.location)
+}
+
+// Location returns where the key is stored, or nil for ephemeral keys.
+func (k *EcdsaKey) Location() *KeyLocation {
+	return copyLocation(k.location)
+}
+
+func copyLocation(loc *KeyLocation) *KeyLocation {
+	if loc == nil {
+		return nil
+	}
+	l := *loc
+	return &l
+}
+
+// KeyLocation returns where the key of w is stored.
+func (w *WinCertStore) KeyLocation() (*KeyLocation, error) {
+	key, err := w.Key()
+	if err != nil {
+		return nil, err
+	}
+	defer key.Close()
+	return key.Location(), nil
+}
@@ -0,0 +1,223 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/elliptic"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// defaultRSAExponent is the public exponent used by the CNG providers.
+const defaultRSAExponent = 65537
+
+const (
+	// ProviderMSPlatform represents the Microsoft Platform Crypto Provider
+	ProviderMSPlatform = "Microsoft Platform Crypto Provider"
+	// ProviderMSSoftware represents the Microsoft Software Key Storage Provider
+	ProviderMSSoftware = "Microsoft Software Key Storage Provider"
+)
+
+// KeyLifetime selects how long a generated key is kept.
+type KeyLifetime int
+
+const (
+	// KeyPersisted keys are stored in the key container. This is the default.
+	KeyPersisted KeyLifetime = iota
+	// KeyEphemeral keys are never persisted and only exist until they are
+	// closed. They have no container, so no certificate can be stored for them.
+	KeyEphemeral
+	// KeyPerBoot keys are persisted, but protected by virtualization based
+	// security with a key that is discarded on reboot. This requires VBS to be
+	// enabled on the machine.
+	KeyPerBoot
+)
+
+func (l KeyLifetime) String() string {
+	switch l {
+	case KeyPersisted:
+		return "persisted"
+	case KeyEphemeral:
+		return "ephemeral"
+	case KeyPerBoot:
+		return "per-boot"
+	default:
+		return fmt.Sprintf("KeyLifetime(%d)", int(l))
+	}
+}
+
+// GenerateOpts holds the parameters of a key generated by a CertStorage backend.
+type GenerateOpts struct {
+	// Algorithm is one of "RSA", "ECDSA_P256", "ECDSA_P384", "ECDSA_P521",
+	// "ECDH_P256", "ECDH_P384" or "ECDH_P521", or "ECDSA" or "ECDH" together
+	// with Curve.
+	Algorithm string
+	// Curve selects the named curve of "ECDSA" and "ECDH" keys. It must be
+	// nil or match the curve of the other ECC algorithms, and nil for RSA.
+	Curve elliptic.Curve
+	// KeySize is the size of RSA keys in bits. It is ignored for the named
+	// curve algorithms.
+	KeySize int
+	// CNGAlgorithm, if set, is the exact CNG algorithm identifier passed to
+	// the provider instead of the one derived from Algorithm. It may be used
+	// without Algorithm, in which case the provider must support KeySize, or
+	// report a default size if KeySize is zero.
+	CNGAlgorithm string
+	// PublicExponent is the public exponent of RSA keys. Only zero and
+	// 65537 are accepted: the CNG key storage providers always generate keys
+	// with exponent 65537, so generating with any other value fails.
+	PublicExponent int
+	// Lifetime selects whether the key is persisted, see KeyLifetime.
+	Lifetime KeyLifetime
+	// UseContext, if set, tags the new key with its purpose, such as
+	// UseContextVPN, see WinCertStore.Keys.
+	UseContext string
+	// Usage restricts the operations the key may be used for. Zero selects
+	// the usage from the algorithm: decrypt and sign for RSA, sign for
+	// ECDSA and key agreement for ECDH.
+	Usage KeyUsage
+	// Machine creates the key in the key store of the machine instead of
+	// that of the current user.
+	Machine bool
+	// Export is the export policy of the key, none by default. Only keys
+	// that allow plaintext export can be exported with
+	// ExportFullPrivateBlob, and only from stores opened with
+	// AllowPrivateExport. This defeats the protection offered by the key
+	// storage provider and must only be set for approved key escrow
+	// scenarios.
+	Export ExportPolicy
+	// NoOverwrite fails the generation if the container already holds a
+	// key, instead of replacing the key.
+	NoOverwrite bool
+}
+
+// curveSuffix returns the suffix of the CNG algorithm names for curve.
+func curveSuffix(curve elliptic.Curve) (string, error) {
+	switch curve {
+	case elliptic.P256():
+		return "_P256", nil
+	case elliptic.P384():
+		return "_P384", nil
+	case elliptic.P521():
+		return "_P521", nil
+	}
+	return "", fmt.Errorf("unsupported curve %s", curve.Params().Name)
+}
+
+// keyUsage returns the usage of a key of the CNG algorithm algID generated
+// with opts.
+func keyUsage(algID string, opts GenerateOpts) (KeyUsage, error) {
+	var allowed, def KeyUsage
+	switch {
+	case algID == "RSA":
+		allowed, def = KeyUsageDecrypt|KeyUsageSign, KeyUsageDecrypt|KeyUsageSign
+	case strings.HasPrefix(algID, "ECDH"):
+		// ECDH keys are rejected by the providers if they are marked for signing.
+		allowed, def = KeyUsageKeyAgreement, KeyUsageKeyAgreement
+	case strings.HasPrefix(algID, "ECDSA"):
+		allowed, def = KeyUsageSign, KeyUsageSign
+	default:
+		// Leave custom algorithms to the provider.
+		allowed, def = KeyUsageAll, KeyUsageSign
+	}
+	switch {
+	case opts.Usage == 0:
+		return def, nil
+	case opts.Usage == KeyUsageAll && allowed == KeyUsageAll:
+		return opts.Usage, nil
+	case opts.Usage&^allowed != 0:
+		return 0, fmt.Errorf("key usage %v is not supported for %s keys", opts.Usage, algID)
+	}
+	return opts.Usage, nil
+}
+
+// keyParams returns the CNG algorithm identifier and key size for opts.
+func keyParams(opts GenerateOpts) (string, int, error) {
+	var algID string
+	keySize := opts.KeySize
+	switch opts.Algorithm {
+	case "RSA":
+		algID = "RSA"
+		// The MPCP only supports a max keywidth of 2048, due to the TPM specification.
+		// https://www.microsoft.com/en-us/download/details.aspx?id=52487
+		// The Microsoft Software Key Storage Provider supports a max keywidth of 16384.
+		if keySize > 16384 {
+			return "", 0, fmt.Errorf("unsupported keysize, got: %d, want: < %d", keySize, 16384)
+		}
+	case "ECDSA_P256", "ECDH_P256":
+		algID, keySize = opts.Algorithm, 256
+	case "ECDSA_P384", "ECDH_P384":
+		algID, keySize = opts.Algorithm, 384
+	case "ECDSA_P521", "ECDH_P521":
+		algID, keySize = opts.Algorithm, 521
+	case "ECDSA", "ECDH":
+		if opts.Curve == nil {
+			return "", 0, fmt.Errorf("%s keys require a curve", opts.Algorithm)
+		}
+		suffix, err := curveSuffix(opts.Curve)
+		if err != nil {
+			return "", 0, err
+		}
+		algID, keySize = opts.Algorithm+suffix, opts.Curve.Params().BitSize
+	case "":
+		if opts.CNGAlgorithm == "" {
+			return "", 0, errors.New("no key algorithm specified")
+		}
+	default:
+		return "", 0, fmt.Errorf("unsupported algorithm: %s", opts.Algorithm)
+	}
+	if opts.Curve != nil {
+		suffix, err := curveSuffix(opts.Curve)
+		switch {
+		case err != nil:
+			return "", 0, err
+		case !strings.HasSuffix(algID, suffix):
+			return "", 0, fmt.Errorf("curve %s does not match algorithm %s", opts.Curve.Params().Name, algID)
+		}
+	}
+	if opts.CNGAlgorithm != "" {
+		algID = opts.CNGAlgorithm
+	}
+
+	switch opts.Lifetime {
+	case KeyPersisted, KeyEphemeral, KeyPerBoot:
+	default:
+		return "", 0, fmt.Errorf("unsupported key lifetime: %v", opts.Lifetime)
+	}
+	if opts.Lifetime == KeyEphemeral && (opts.Machine || opts.NoOverwrite) {
+		return "", 0, errors.New("ephemeral keys have no container, Machine and NoOverwrite do not apply")
+	}
+	if _, err := keyUsage(algID, opts); err != nil {
+		return "", 0, err
+	}
+	if opts.Export&^(ExportAllowed|PlaintextExportAllowed|ArchivingAllowed|PlaintextArchivingAllowed) != 0 {
+		return "", 0, fmt.Errorf("export policy %v has unknown flags", opts.Export)
+	}
+
+	switch {
+	case opts.PublicExponent == 0:
+	case algID != "RSA":
+		return "", 0, fmt.Errorf("a public exponent can only be specified for RSA keys, not %s", algID)
+	case opts.PublicExponent != defaultRSAExponent:
+		return "", 0, fmt.Errorf("public exponent %d is not supported, CNG providers only generate RSA keys with exponent %d", opts.PublicExponent, defaultRSAExponent)
+	}
+	if opts.UseContext != "" {
+		if err := checkUseContext("Generate", opts.UseContext); err != nil {
+			return "", 0, err
+		}
+	}
+	return algID, keySize, nil
+}
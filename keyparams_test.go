@@ -0,0 +1,91 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/elliptic"
+	"testing"
+)
+
+func TestKeyParams(t *testing.T) {
+	tests := []struct {
+		opts     GenerateOpts
+		wantAlg  string
+		wantSize int
+		ok       bool
+	}{
+		{GenerateOpts{Algorithm: "RSA", KeySize: 2048}, "RSA", 2048, true},
+		{GenerateOpts{Algorithm: "RSA", KeySize: 32768}, "", 0, false},
+		{GenerateOpts{Algorithm: "ECDSA_P384", KeySize: 2048}, "ECDSA_P384", 384, true},
+		{GenerateOpts{Algorithm: "ECDH_P521"}, "ECDH_P521", 521, true},
+		{GenerateOpts{Algorithm: "DSA"}, "", 0, false},
+		{GenerateOpts{}, "", 0, false},
+		{GenerateOpts{CNGAlgorithm: "ECDSA", KeySize: 256}, "ECDSA", 256, true},
+		{GenerateOpts{Algorithm: "ECDSA_P256", CNGAlgorithm: "ECDSA"}, "ECDSA", 256, true},
+		{GenerateOpts{Algorithm: "RSA", KeySize: 2048, PublicExponent: 65537}, "RSA", 2048, true},
+		{GenerateOpts{Algorithm: "RSA", KeySize: 2048, PublicExponent: 3}, "", 0, false},
+		{GenerateOpts{Algorithm: "ECDSA_P256", PublicExponent: 65537}, "", 0, false},
+		{GenerateOpts{Algorithm: "ECDSA_P256", Lifetime: KeyEphemeral}, "ECDSA_P256", 256, true},
+		{GenerateOpts{Algorithm: "ECDSA_P256", Lifetime: KeyLifetime(7)}, "", 0, false},
+		{GenerateOpts{Algorithm: "ECDSA_P256", UseContext: UseContextWiFi}, "ECDSA_P256", 256, true},
+		{GenerateOpts{Algorithm: "ECDSA_P256", UseContext: "wifi\x00"}, "", 0, false},
+		{GenerateOpts{Algorithm: "ECDSA", Curve: elliptic.P384()}, "ECDSA_P384", 384, true},
+		{GenerateOpts{Algorithm: "ECDH", Curve: elliptic.P521()}, "ECDH_P521", 521, true},
+		{GenerateOpts{Algorithm: "ECDSA"}, "", 0, false},
+		{GenerateOpts{Algorithm: "ECDSA", Curve: elliptic.P224()}, "", 0, false},
+		{GenerateOpts{Algorithm: "ECDSA_P256", Curve: elliptic.P256()}, "ECDSA_P256", 256, true},
+		{GenerateOpts{Algorithm: "ECDSA_P256", Curve: elliptic.P384()}, "", 0, false},
+		{GenerateOpts{Algorithm: "RSA", Curve: elliptic.P256()}, "", 0, false},
+		{GenerateOpts{Algorithm: "RSA", KeySize: 2048, Usage: KeyUsageDecrypt}, "RSA", 2048, true},
+		{GenerateOpts{Algorithm: "RSA", KeySize: 2048, Usage: KeyUsageKeyAgreement}, "", 0, false},
+		{GenerateOpts{Algorithm: "ECDH_P256", Usage: KeyUsageSign}, "", 0, false},
+		{GenerateOpts{Algorithm: "ECDSA_P256", Machine: true, NoOverwrite: true, Export: ExportAllowed}, "ECDSA_P256", 256, true},
+		{GenerateOpts{Algorithm: "ECDSA_P256", Export: ExportPolicy(0x10)}, "", 0, false},
+		{GenerateOpts{Algorithm: "ECDSA_P256", Lifetime: KeyEphemeral, Machine: true}, "", 0, false},
+	}
+	for _, tt := range tests {
+		alg, size, err := keyParams(tt.opts)
+		if (err == nil) != tt.ok {
+			t.Errorf("keyParams(%+v) returned error %v, want success: %t", tt.opts, err, tt.ok)
+			continue
+		}
+		if alg != tt.wantAlg || size != tt.wantSize {
+			t.Errorf("keyParams(%+v) = %s, %d, want: %s, %d", tt.opts, alg, size, tt.wantAlg, tt.wantSize)
+		}
+	}
+}
+
+func TestKeyUsage(t *testing.T) {
+	tests := []struct {
+		alg  string
+		opts GenerateOpts
+		want KeyUsage
+		ok   bool
+	}{
+		{"RSA", GenerateOpts{}, KeyUsageDecrypt | KeyUsageSign, true},
+		{"RSA", GenerateOpts{Usage: KeyUsageSign}, KeyUsageSign, true},
+		{"ECDSA_P256", GenerateOpts{}, KeyUsageSign, true},
+		{"ECDSA_P256", GenerateOpts{Usage: KeyUsageDecrypt}, 0, false},
+		{"ECDH_P384", GenerateOpts{}, KeyUsageKeyAgreement, true},
+		{"RSA", GenerateOpts{Usage: KeyUsageAll}, 0, false},
+		{"DH", GenerateOpts{Usage: KeyUsageAll}, KeyUsageAll, true},
+	}
+	for _, tt := range tests {
+		got, err := keyUsage(tt.alg, tt.opts)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("keyUsage(%s, %+v) = %v, %v, want: %v, success: %t", tt.alg, tt.opts, got, err, tt.want, tt.ok)
+		}
+	}
+}
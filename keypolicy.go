@@ -0,0 +1,116 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ExportPolicy is the NCRYPT_EXPORT_POLICY_PROPERTY of a key.
+type ExportPolicy uint32
+
+// Export policy flags, see NCRYPT_ALLOW_*_FLAG in ncrypt.h.
+const (
+	ExportAllowed             ExportPolicy = 0x1
+	PlaintextExportAllowed    ExportPolicy = 0x2
+	ArchivingAllowed          ExportPolicy = 0x4
+	PlaintextArchivingAllowed ExportPolicy = 0x8
+)
+
+// Exportable reports whether the private key may be exported at all.
+func (p ExportPolicy) Exportable() bool {
+	return p&(ExportAllowed|PlaintextExportAllowed) != 0
+}
+
+func (p ExportPolicy) String() string {
+	return flagString(uint32(p), []string{"export", "plaintext-export", "archiving", "plaintext-archiving"})
+}
+
+// KeyUsage is the NCRYPT_KEY_USAGE_PROPERTY of a key.
+type KeyUsage uint32
+
+// Key usage flags, see NCRYPT_ALLOW_*_FLAG in ncrypt.h.
+const (
+	KeyUsageDecrypt      KeyUsage = 0x1
+	KeyUsageSign         KeyUsage = 0x2
+	KeyUsageKeyAgreement KeyUsage = 0x4
+	KeyUsageAll          KeyUsage = 0x00ffffff
+)
+
+// SignOnly reports whether the key may only be used for signing.
+func (u KeyUsage) SignOnly() bool {
+	return u == KeyUsageSign
+}
+
+func (u KeyUsage) String() string {
+	if u == KeyUsageAll {
+		return "all"
+	}
+	return flagString(uint32(u), []string{"decrypt", "sign", "key-agreement"})
+}
+
+// flagString joins the names of the bits set in v, with unnamed bits in hex.
+func flagString(v uint32, names []string) string {
+	if v == 0 {
+		return "none"
+	}
+	var parts []string
+	for i, name := range names {
+		if bit := uint32(1) << uint(i); v&bit != 0 {
+			parts = append(parts, name)
+			v &^= bit
+		}
+	}
+	if v != 0 {
+		parts = append(parts, fmt.Sprintf("%#x", v))
+	}
+	return strings.Join(parts, "|")
+}
+
+// KeyPolicy holds the policy properties of a persisted key.
+type KeyPolicy struct {
+	Export ExportPolicy
+	Usage  KeyUsage
+	// Length is the key size in bits.
+	Length int
+	// Modified is the time the key was last persisted, or the zero time if
+	// the provider does not record it. CNG has no creation time property, for
+	// keys that were not changed after generation this is their creation time.
+	Modified time.Time
+}
+
+// parseUint32Property decodes a DWORD property value.
+func parseUint32Property(name string, b []byte) (uint32, error) {
+	if len(b) != 4 {
+		return 0, fmt.Errorf("property %q has %d bytes, want 4", name, len(b))
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+// filetimeEpochDelta is the number of 100ns intervals between the FILETIME
+// epoch (1601-01-01) and the Unix epoch.
+const filetimeEpochDelta = 116444736000000000
+
+// parseFiletime decodes a FILETIME property value.
+func parseFiletime(b []byte) (time.Time, error) {
+	if len(b) != 8 {
+		return time.Time{}, fmt.Errorf("FILETIME has %d bytes, want 8", len(b))
+	}
+	ft := int64(binary.LittleEndian.Uint64(b))
+	return time.Unix(0, (ft-filetimeEpochDelta)*100).UTC(), nil
+}
@@ -0,0 +1,74 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestFlagStrings(t *testing.T) {
+	tests := []struct {
+		got  string
+		want string
+	}{
+		{KeyUsage(0).String(), "none"},
+		{(KeyUsageDecrypt | KeyUsageSign).String(), "decrypt|sign"},
+		{KeyUsageAll.String(), "all"},
+		{KeyUsage(0x12).String(), "sign|0x10"},
+		{(ExportAllowed | PlaintextExportAllowed).String(), "export|plaintext-export"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("got %q, want %q", tt.got, tt.want)
+		}
+	}
+}
+
+func TestPolicyPredicates(t *testing.T) {
+	if !KeyUsageSign.SignOnly() || (KeyUsageSign | KeyUsageDecrypt).SignOnly() {
+		t.Error("SignOnly returned unexpected results")
+	}
+	if ExportPolicy(0).Exportable() || ArchivingAllowed.Exportable() || !PlaintextExportAllowed.Exportable() {
+		t.Error("Exportable returned unexpected results")
+	}
+}
+
+func TestParseUint32Property(t *testing.T) {
+	v, err := parseUint32Property("Length", []byte{0x00, 0x08, 0x00, 0x00})
+	if err != nil || v != 2048 {
+		t.Errorf("parseUint32Property() = %d, %v, want 2048", v, err)
+	}
+	if _, err := parseUint32Property("Length", []byte{0x00, 0x08}); err == nil {
+		t.Error("parseUint32Property succeeded with a short value, want error")
+	}
+}
+
+func TestParseFiletime(t *testing.T) {
+	want := time.Date(2020, 3, 4, 5, 6, 7, 800, time.UTC)
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(want.UnixNano()/100+filetimeEpochDelta))
+	got, err := parseFiletime(b)
+	if err != nil {
+		t.Fatalf("parseFiletime returned %v", err)
+	}
+	if !got.Equal(want) {
+		t.Errorf("parseFiletime() = %v, want %v", got, want)
+	}
+	if _, err := parseFiletime(b[:4]); err == nil {
+		t.Error("parseFiletime succeeded with a short value, want error")
+	}
+}
@@ -0,0 +1,65 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+// Policy reads the export and usage policy, length and modification time of
+// the key, so that deployed keys can be checked against a compliance policy.
+func (k *RsaKey) Policy() (*KeyPolicy, error) {
+	return keyPolicy(k.handle)
+}
+
+// Policy reads the export and usage policy, length and modification time of
+// the key, so that deployed keys can be checked against a compliance policy.
+func (k *EcdsaKey) Policy() (*KeyPolicy, error) {
+	return keyPolicy(k.handle)
+}
+
+func keyPolicy(kh uintptr) (*KeyPolicy, error) {
+	export, err := getPropertyUint32(kh, "Export Policy")
+	if err != nil {
+		return nil, err
+	}
+	usage, err := getPropertyUint32(kh, "Key Usage")
+	if err != nil {
+		return nil, err
+	}
+	length, err := getPropertyUint32(kh, "Length")
+	if err != nil {
+		return nil, err
+	}
+	p := &KeyPolicy{
+		Export: ExportPolicy(export),
+		Usage:  KeyUsage(usage),
+		Length: int(length),
+	}
+	// Not every provider records a modification time, so it is optional.
+	if b, err := getProperty(kh, "Modified"); err == nil {
+		if p.Modified, err = parseFiletime(b); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// getPropertyUint32 returns the value of a DWORD property.
+func getPropertyUint32(kh uintptr, property string) (uint32, error) {
+	b, err := getProperty(kh, property)
+	if err != nil {
+		return 0, err
+	}
+	return parseUint32Property(property, b)
+}
@@ -0,0 +1,511 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+)
+
+// DefaultKeyStoreIterations is the PBKDF2 and MAC iteration count used when
+// KeyStoreOptions.Iterations is zero. It matches the default of recent JDKs.
+const DefaultKeyStoreIterations = 10000
+
+var (
+	oidData                = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidPKCS8ShroudedKeyBag = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidX509CertType        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPBES2               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA256      = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES256CBC           = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	// oidJavaTrustedKeyUsage is the attribute the JDK uses to mark
+	// certificate entries of a PKCS #12 keystore as trusted. Certificates
+	// without it are not loaded as trusted certificate entries.
+	oidJavaTrustedKeyUsage = asn1.ObjectIdentifier{2, 16, 840, 1, 113894, 746875, 1, 1}
+	oidAnyExtendedKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37, 0}
+
+	aliasUnsafe = regexp.MustCompile(`[^a-z0-9._-]+`)
+)
+
+// KeyStoreOptions configures the PKCS #12 keystores written for JVM
+// applications.
+type KeyStoreOptions struct {
+	// Alias names the entry of the private key. If empty, KeyStoreAlias of
+	// the certificate is used. Truststore entries are always named with
+	// KeyStoreAlias.
+	Alias string
+	// Password protects the private key and the integrity of the keystore.
+	// Java requires one, "changeit" is the conventional truststore password.
+	Password string
+	// Iterations is the PBKDF2 and MAC iteration count. Zero means
+	// DefaultKeyStoreIterations.
+	Iterations int
+}
+
+func (o KeyStoreOptions) iterations() int {
+	if o.Iterations == 0 {
+		return DefaultKeyStoreIterations
+	}
+	return o.Iterations
+}
+
+// KeyStoreAlias returns the alias under which cert is stored in a keystore:
+// the lower-cased common name of the subject with characters other than
+// letters, digits, '.', '_' and '-' replaced by '-', or the lower-case SHA1
+// thumbprint if the subject has no usable common name. Java lower-cases the
+// aliases of PKCS #12 keystores when loading them, so the alias is
+// lower-cased here to make it predictable for the application.
+func KeyStoreAlias(cert *x509.Certificate) string {
+	alias := strings.Trim(aliasUnsafe.ReplaceAllString(strings.ToLower(cert.Subject.CommonName), "-"), "-")
+	if alias == "" {
+		return strings.ToLower(thumbprint(cert))
+	}
+	return alias
+}
+
+// EncodeKeyStore returns a PKCS #12 keystore containing key under the alias
+// of opts, together with its certificate chain. chain starts with the
+// certificate of key, followed by its intermediates. The key is encrypted
+// with PBES2 using PBKDF2 with HMAC-SHA256 and AES-256-CBC, and the keystore
+// is protected with an HMAC-SHA256 MAC, which JDK 8u301, 11.0.12 and later
+// can read.
+func EncodeKeyStore(key crypto.PrivateKey, chain []*x509.Certificate, opts KeyStoreOptions) ([]byte, error) {
+	if len(chain) == 0 {
+		return nil, &ArgError{Op: "EncodeKeyStore", Arg: "chain", Reason: "no certificates"}
+	}
+	for _, c := range chain {
+		if err := checkCert("EncodeKeyStore", "chain", c); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkKeyStoreOptions("EncodeKeyStore", opts); err != nil {
+		return nil, err
+	}
+	if !keyMatchesCert(key, chain[0]) {
+		return nil, fmt.Errorf("key does not match certificate %s", thumbprint(chain[0]))
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("encoding private key: %v", err)
+	}
+	defer protect(der)()
+	alias := opts.Alias
+	if alias == "" {
+		alias = KeyStoreAlias(chain[0])
+	}
+
+	// The local key ID links the key to its certificate. Java and OpenSSL
+	// only require it to be equal on both bags.
+	id := sha1.Sum(chain[0].Raw)
+	idAttrs, err := bagAttributes(alias, id[:])
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := encryptPrivateKey(der, opts.Password, opts.iterations())
+	if err != nil {
+		return nil, err
+	}
+	keyBag := safeBag{ID: oidPKCS8ShroudedKeyBag, Value: explicit(encrypted), Attributes: idAttrs}
+
+	certBags := make([]safeBag, 0, len(chain))
+	for i, c := range chain {
+		var attrs []pkcs12Attribute
+		if i == 0 {
+			attrs = idAttrs
+		}
+		bag, err := certBag(c, attrs)
+		if err != nil {
+			return nil, err
+		}
+		certBags = append(certBags, bag)
+	}
+	return encodePFX([][]safeBag{certBags, {keyBag}}, opts.Password, opts.iterations())
+}
+
+// EncodeTrustStore returns a PKCS #12 truststore containing certs as trusted
+// certificate entries, each named with KeyStoreAlias. Entries with the same
+// alias are numbered, starting with the second one.
+func EncodeTrustStore(certs []*x509.Certificate, opts KeyStoreOptions) ([]byte, error) {
+	if len(certs) == 0 {
+		return nil, &ArgError{Op: "EncodeTrustStore", Arg: "certs", Reason: "no certificates"}
+	}
+	if err := checkKeyStoreOptions("EncodeTrustStore", opts); err != nil {
+		return nil, err
+	}
+	trusted, err := asn1.Marshal(oidAnyExtendedKeyUsage)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]int)
+	var bags []safeBag
+	for _, c := range certs {
+		if err := checkCert("EncodeTrustStore", "certs", c); err != nil {
+			return nil, err
+		}
+		alias := KeyStoreAlias(c)
+		seen[alias]++
+		if n := seen[alias]; n > 1 {
+			alias = fmt.Sprintf("%s-%d", alias, n)
+		}
+		attrs, err := bagAttributes(alias, nil)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, pkcs12Attribute{ID: oidJavaTrustedKeyUsage, Values: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: trusted}})
+		bag, err := certBag(c, attrs)
+		if err != nil {
+			return nil, err
+		}
+		bags = append(bags, bag)
+	}
+	return encodePFX([][]safeBag{bags}, opts.Password, opts.iterations())
+}
+
+func checkKeyStoreOptions(op string, opts KeyStoreOptions) error {
+	if opts.Password == "" {
+		return &ArgError{Op: op, Arg: "Password", Reason: "Java keystores require a password"}
+	}
+	if opts.Iterations < 0 {
+		return &ArgError{Op: op, Arg: "Iterations", Reason: "must not be negative"}
+	}
+	return nil
+}
+
+// keyMatchesCert reports whether key is the private key of cert.
+func keyMatchesCert(key crypto.PrivateKey, cert *x509.Certificate) bool {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		pub, ok := cert.PublicKey.(*rsa.PublicKey)
+		return ok && pub.N.Cmp(k.N) == 0 && pub.E == k.E
+	case *ecdsa.PrivateKey:
+		pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+		return ok && pub.Curve == k.Curve && pub.X.Cmp(k.X) == 0 && pub.Y.Cmp(k.Y) == 0
+	}
+	return false
+}
+
+// ASN.1 structures of RFC 7292.
+
+type pfx struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	// Content is the [0] EXPLICIT content, see explicit.
+	Content asn1.RawValue
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int
+}
+
+type digestInfo struct {
+	Algorithm algorithmIdentifier
+	Digest    []byte
+}
+
+type algorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue     // [0] EXPLICIT, see explicit
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID     asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+type pkcs12CertBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm algorithmIdentifier
+	Data      []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc algorithmIdentifier
+	EncryptionScheme  algorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	KeyLength  int `asn1:"optional"`
+	PRF        algorithmIdentifier
+}
+
+// explicit wraps der, which must be a complete DER encoding, as the content
+// of an [0] EXPLICIT field. encoding/asn1 ignores the explicit tag of
+// RawValue fields when marshaling, so the tag is added here.
+func explicit(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+// bagAttributes returns the friendlyName and, if id is not nil, localKeyId
+// attributes of a bag.
+func bagAttributes(alias string, id []byte) ([]pkcs12Attribute, error) {
+	name, err := encodeBMPString(alias)
+	if err != nil {
+		return nil, err
+	}
+	attrs := []pkcs12Attribute{{ID: oidFriendlyName, Values: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: name}}}
+	if id != nil {
+		v, err := asn1.Marshal(id)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, pkcs12Attribute{ID: oidLocalKeyID, Values: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: v}})
+	}
+	return attrs, nil
+}
+
+func certBag(cert *x509.Certificate, attrs []pkcs12Attribute) (safeBag, error) {
+	der, err := asn1.Marshal(pkcs12CertBag{ID: oidX509CertType, Data: cert.Raw})
+	if err != nil {
+		return safeBag{}, err
+	}
+	return safeBag{ID: oidCertBag, Value: explicit(der), Attributes: attrs}, nil
+}
+
+// encryptPrivateKey encrypts the PKCS #8 encoded key as an
+// EncryptedPrivateKeyInfo using PBES2 with PBKDF2-HMAC-SHA256 and AES-256-CBC.
+func encryptPrivateKey(der []byte, password string, iterations int) ([]byte, error) {
+	salt := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+	pw := []byte(password)
+	defer protect(pw)()
+	key := pbkdf2(pw, salt, iterations, 32, sha256.New)
+	defer protect(key)()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	pad := aes.BlockSize - len(der)%aes.BlockSize
+	data := append(append([]byte(nil), der...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+
+	null := asn1.RawValue{Tag: asn1.TagNull}
+	kdf, err := asn1.Marshal(pbkdf2Params{
+		Salt:       salt,
+		Iterations: iterations,
+		KeyLength:  32,
+		PRF:        algorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: null},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ivDER, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: algorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdf}},
+		EncryptionScheme:  algorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivDER}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm: algorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		Data:      data,
+	})
+}
+
+// encodePFX encodes each list of bags as an unencrypted data content of the
+// authenticated safe, and protects the result with an HMAC-SHA256 MAC.
+// Private keys are expected to be encrypted in their bags already.
+func encodePFX(contents [][]safeBag, password string, iterations int) ([]byte, error) {
+	var infos []contentInfo
+	for _, bags := range contents {
+		safe, err := asn1.Marshal(bags)
+		if err != nil {
+			return nil, err
+		}
+		info, err := dataContentInfo(safe)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	authSafe, err := asn1.Marshal(infos)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	pw := bmpPassword(password)
+	defer protect(pw)()
+	key := pkcs12KDF(sha256.New, 64, pw, salt, 3, iterations, 32)
+	defer protect(key)()
+	mac := hmac.New(sha256.New, key)
+	mac.Write(authSafe)
+
+	info, err := dataContentInfo(authSafe)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pfx{
+		Version:  3,
+		AuthSafe: info,
+		MacData: macData{
+			Mac: digestInfo{
+				Algorithm: algorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.RawValue{Tag: asn1.TagNull}},
+				Digest:    mac.Sum(nil),
+			},
+			MacSalt:    salt,
+			Iterations: iterations,
+		},
+	})
+}
+
+// dataContentInfo wraps content in a ContentInfo of type data.
+func dataContentInfo(content []byte) (contentInfo, error) {
+	octets, err := asn1.Marshal(content)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	return contentInfo{ContentType: oidData, Content: explicit(octets)}, nil
+}
+
+// bmpPassword encodes password as a NUL terminated big-endian UTF-16 string,
+// as required by the PKCS #12 key derivation.
+func bmpPassword(password string) []byte {
+	u := utf16.Encode([]rune(password + "\x00"))
+	b := make([]byte, 2*len(u))
+	for i, c := range u {
+		binary.BigEndian.PutUint16(b[2*i:], c)
+	}
+	return b
+}
+
+// pkcs12KDF derives size bytes from password and salt as described in
+// RFC 7292, appendix B.2. v is the block size of the hash in bytes, and id
+// selects the purpose of the key (3 for MAC keys).
+func pkcs12KDF(h func() hash.Hash, v int, password, salt []byte, id byte, iterations, size int) []byte {
+	fill := func(b []byte) []byte {
+		if len(b) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(b)+v-1)/v))
+		for i := range out {
+			out[i] = b[i%len(b)]
+		}
+		return out
+	}
+	d := bytes.Repeat([]byte{id}, v)
+	in := append(fill(salt), fill(password)...)
+
+	one := big.NewInt(1)
+	mod := new(big.Int).Lsh(one, uint(8*v))
+	var out []byte
+	for len(out) < size {
+		hh := h()
+		hh.Write(d)
+		hh.Write(in)
+		a := hh.Sum(nil)
+		for i := 1; i < iterations; i++ {
+			hh.Reset()
+			hh.Write(a)
+			a = hh.Sum(a[:0])
+		}
+		out = append(out, a...)
+		if len(out) >= size {
+			break
+		}
+		b := new(big.Int).SetBytes(fill(a)[:v])
+		b.Add(b, one)
+		for j := 0; j < len(in); j += v {
+			ij := new(big.Int).SetBytes(in[j : j+v])
+			ij.Add(ij, b).Mod(ij, mod)
+			buf := ij.Bytes()
+			block := in[j : j+v]
+			for k := range block {
+				block[k] = 0
+			}
+			copy(block[v-len(buf):], buf)
+		}
+	}
+	return out[:size]
+}
+
+// pbkdf2 derives size bytes from password and salt as described in RFC 8018,
+// section 5.2.
+func pbkdf2(password, salt []byte, iterations, size int, h func() hash.Hash) []byte {
+	prf := hmac.New(h, password)
+	var out []byte
+	for block := uint32(1); len(out) < size; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		var n [4]byte
+		binary.BigEndian.PutUint32(n[:], block)
+		prf.Write(n[:])
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		out = append(out, t...)
+	}
+	return out[:size]
+}
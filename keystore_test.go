@@ -0,0 +1,231 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+func TestKeyStoreAlias(t *testing.T) {
+	tests := []struct {
+		cn   string
+		want string
+	}{
+		{"host.example.com", "host.example.com"},
+		{"My Service (Prod)", "my-service-prod"},
+		{"  ", ""},
+	}
+	for _, tc := range tests {
+		cert := selfSigned(t, &x509.Certificate{Subject: pkix.Name{CommonName: tc.cn}})
+		want := tc.want
+		if want == "" {
+			sum := sha1.Sum(cert.Raw)
+			want = hex.EncodeToString(sum[:])
+		}
+		if got := KeyStoreAlias(cert); got != want {
+			t.Errorf("KeyStoreAlias(%q) = %q, want %q", tc.cn, got, want)
+		}
+	}
+}
+
+func TestPKCS12KDF(t *testing.T) {
+	// Test vector from the Bouncy Castle PKCS #12 tests.
+	salt, _ := hex.DecodeString("0A58CF64530D823F")
+	got := pkcs12KDF(sha1.New, 64, bmpPassword("smeg"), salt, 1, 1, 24)
+	if want := "8aaae6297b6cb04642ab5b077851284eb7128f1a2a7fbca3"; hex.EncodeToString(got) != want {
+		t.Errorf("pkcs12KDF = %x, want %s", got, want)
+	}
+}
+
+func TestPBKDF2(t *testing.T) {
+	// Test vector from RFC 6070.
+	got := pbkdf2([]byte("password"), []byte("salt"), 2, 20, sha1.New)
+	if want := "ea6c014dc72d6f8ccd1ed92ace1d41f0d8de8957"; hex.EncodeToString(got) != want {
+		t.Errorf("pbkdf2 = %x, want %s", got, want)
+	}
+}
+
+// decodePFX verifies the MAC of a keystore written by encodePFX and returns
+// its bags.
+func decodePFX(t *testing.T, der []byte, password string) []safeBag {
+	t.Helper()
+	var p pfx
+	if _, err := asn1.Unmarshal(der, &p); err != nil {
+		t.Fatalf("parsing PFX: %v", err)
+	}
+	var authSafe []byte
+	if _, err := asn1.Unmarshal(p.AuthSafe.Content.Bytes, &authSafe); err != nil {
+		t.Fatalf("parsing authenticated safe: %v", err)
+	}
+	key := pkcs12KDF(sha256.New, 64, bmpPassword(password), p.MacData.MacSalt, 3, p.MacData.Iterations, 32)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(authSafe)
+	if !hmac.Equal(mac.Sum(nil), p.MacData.Mac.Digest) {
+		t.Fatalf("MAC does not verify")
+	}
+	var infos []contentInfo
+	if _, err := asn1.Unmarshal(authSafe, &infos); err != nil {
+		t.Fatalf("parsing content infos: %v", err)
+	}
+	var bags []safeBag
+	for _, info := range infos {
+		var safe []byte
+		if _, err := asn1.Unmarshal(info.Content.Bytes, &safe); err != nil {
+			t.Fatalf("parsing content: %v", err)
+		}
+		var b []safeBag
+		if _, err := asn1.Unmarshal(safe, &b); err != nil {
+			t.Fatalf("parsing safe contents: %v", err)
+		}
+		bags = append(bags, b...)
+	}
+	return bags
+}
+
+// bagAlias returns the friendly name of bag.
+func bagAlias(t *testing.T, bag safeBag) string {
+	t.Helper()
+	for _, a := range bag.Attributes {
+		if a.ID.Equal(oidFriendlyName) {
+			s, err := decodeBMPString(a.Values.Bytes)
+			if err != nil {
+				t.Fatalf("decoding friendly name: %v", err)
+			}
+			return s
+		}
+	}
+	return ""
+}
+
+func TestEncodeKeyStore(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "Web Server"}}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create test certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse test certificate: %v", err)
+	}
+	intermediate := selfSigned(t, &x509.Certificate{Subject: pkix.Name{CommonName: "Issuing CA"}})
+
+	if _, err := EncodeKeyStore(key, []*x509.Certificate{cert}, KeyStoreOptions{}); err == nil {
+		t.Errorf("EncodeKeyStore without a password succeeded")
+	}
+	if _, err := EncodeKeyStore(key, []*x509.Certificate{intermediate}, KeyStoreOptions{Password: "changeit"}); err == nil {
+		t.Errorf("EncodeKeyStore with a mismatched certificate succeeded")
+	}
+
+	ks, err := EncodeKeyStore(key, []*x509.Certificate{cert, intermediate}, KeyStoreOptions{Password: "changeit", Iterations: 100})
+	if err != nil {
+		t.Fatalf("EncodeKeyStore: %v", err)
+	}
+	bags := decodePFX(t, ks, "changeit")
+	if len(bags) != 3 {
+		t.Fatalf("keystore has %d bags, want 3", len(bags))
+	}
+	var keyBag *safeBag
+	for i := range bags {
+		if bags[i].ID.Equal(oidPKCS8ShroudedKeyBag) {
+			keyBag = &bags[i]
+		}
+	}
+	if keyBag == nil {
+		t.Fatalf("keystore has no key bag")
+	}
+	if got := bagAlias(t, *keyBag); got != "web-server" {
+		t.Errorf("key alias = %q, want %q", got, "web-server")
+	}
+
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(keyBag.Value.Bytes, &info); err != nil {
+		t.Fatalf("parsing encrypted key: %v", err)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		t.Fatalf("parsing PBES2 parameters: %v", err)
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		t.Fatalf("parsing PBKDF2 parameters: %v", err)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		t.Fatalf("parsing IV: %v", err)
+	}
+	block, err := aes.NewCipher(pbkdf2([]byte("changeit"), kdf.Salt, kdf.Iterations, 32, sha256.New))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := make([]byte, len(info.Data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, info.Data)
+	plain = plain[:len(plain)-int(plain[len(plain)-1])]
+	got, err := x509.ParsePKCS8PrivateKey(plain)
+	if err != nil {
+		t.Fatalf("parsing decrypted key: %v", err)
+	}
+	if ec, ok := got.(*ecdsa.PrivateKey); !ok || ec.D.Cmp(key.D) != 0 {
+		t.Errorf("decrypted key does not match")
+	}
+}
+
+func TestEncodeTrustStore(t *testing.T) {
+	a := selfSigned(t, &x509.Certificate{Subject: pkix.Name{CommonName: "Root CA"}})
+	b := selfSigned(t, &x509.Certificate{Subject: pkix.Name{CommonName: "Root CA"}})
+	ts, err := EncodeTrustStore([]*x509.Certificate{a, b}, KeyStoreOptions{Password: "changeit", Iterations: 100})
+	if err != nil {
+		t.Fatalf("EncodeTrustStore: %v", err)
+	}
+	bags := decodePFX(t, ts, "changeit")
+	var aliases []string
+	for _, bag := range bags {
+		aliases = append(aliases, bagAlias(t, bag))
+		trusted := false
+		for _, a := range bag.Attributes {
+			trusted = trusted || a.ID.Equal(oidJavaTrustedKeyUsage)
+		}
+		if !trusted {
+			t.Errorf("certificate %q is not marked as trusted", bagAlias(t, bag))
+		}
+		var cb pkcs12CertBag
+		if _, err := asn1.Unmarshal(bag.Value.Bytes, &cb); err != nil {
+			t.Fatalf("parsing certificate bag: %v", err)
+		}
+		if !bytes.Equal(cb.Data, a.Raw) && !bytes.Equal(cb.Data, b.Raw) {
+			t.Errorf("certificate bag holds an unexpected certificate")
+		}
+	}
+	if len(aliases) != 2 || aliases[0] != "root-ca" || aliases[1] != "root-ca-2" {
+		t.Errorf("aliases = %v, want [root-ca root-ca-2]", aliases)
+	}
+}
@@ -0,0 +1,137 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto"
+	"fmt"
+)
+
+// WriteKeyStore writes the current cert, the intermediates that chain it to a
+// root and its private key as a PKCS #12 keystore to path, replacing the file
+// atomically. This is intended for JVM applications on the same host that
+// cannot use CNG keys. The store must be opened with AllowPrivateExport and
+// the key must have been generated with an export policy that allows
+// plaintext export.
+func (w *WinCertStore) WriteKeyStore(path string, opts KeyStoreOptions) error {
+	chain, err := w.chain()
+	if err != nil {
+		return err
+	}
+	key, err := w.CertKey(chain[0])
+	if err != nil {
+		return err
+	}
+	defer key.Close()
+	priv, err := exportPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	defer wipePrivateKey(priv)
+	ks, err := EncodeKeyStore(priv, chain, opts)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, ks, createMode); err != nil {
+		return fmt.Errorf("writing keystore to %s: %v", path, err)
+	}
+	alias := opts.Alias
+	if alias == "" {
+		alias = KeyStoreAlias(chain[0])
+	}
+	logInfo("Wrote keystore.", opField("writekeystore"), thumbprintField(thumbprint(chain[0])), field("path", path), field("alias", alias))
+	return nil
+}
+
+// WriteTrustStore writes the issuers of the current cert, up to and including
+// the root, as a PKCS #12 truststore to path, replacing the file atomically.
+// It can be used together with WriteKeyStore for JVM applications that need
+// to trust the same chain, such as for mutual TLS.
+func (w *WinCertStore) WriteTrustStore(path string, opts KeyStoreOptions) error {
+	chain, err := w.fullChain()
+	if err != nil {
+		return err
+	}
+	if len(chain) < 2 {
+		return fmt.Errorf("no issuer found for certificate %s", thumbprint(chain[0]))
+	}
+	ts, err := EncodeTrustStore(chain[1:], opts)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, ts, createMode); err != nil {
+		return fmt.Errorf("writing truststore to %s: %v", path, err)
+	}
+	logInfo("Wrote truststore.", opField("writetruststore"), thumbprintField(thumbprint(chain[0])), field("path", path), field("certificates", len(chain)-1))
+	return nil
+}
+
+// ExportEncryptedPKCS8 exports the private key of the current cert as PEM
+// encoded PKCS #8, encrypted with a passphrase or to a recipient certificate,
+// see PKCS8ExportOptions. The store must be opened with AllowPrivateExport and
+// the key must have been generated with an export policy that allows
+// plaintext export.
+func (w *WinCertStore) ExportEncryptedPKCS8(opts PKCS8ExportOptions) ([]byte, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	cert, err := w.Cert()
+	if err != nil {
+		return nil, err
+	}
+	if cert == nil {
+		return nil, fmt.Errorf("no certificate found for issuers %v", w.issuerList())
+	}
+	key, err := w.CertKey(cert)
+	if err != nil {
+		return nil, err
+	}
+	defer key.Close()
+	priv, err := exportPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	defer wipePrivateKey(priv)
+	b, err := EncryptPKCS8(priv, opts)
+	if err != nil {
+		return nil, err
+	}
+	logInfo("Exported encrypted private key.", opField("exportpkcs8"), thumbprintField(thumbprint(cert)), field("recipient", opts.Recipient != nil))
+	return b, nil
+}
+
+// exportPrivateKey exports the private key material of key. The exported blob
+// is wiped, the caller should wipe the returned key with wipePrivateKey.
+func exportPrivateKey(key Key) (crypto.PrivateKey, error) {
+	switch k := key.(type) {
+	case *RsaKey:
+		blob, err := k.ExportFullPrivateBlob()
+		if err != nil {
+			return nil, err
+		}
+		defer protect(blob)()
+		return unmarshalRSAFullPrivateBlob(blob)
+	case *EcdsaKey:
+		blob, err := k.ExportFullPrivateBlob()
+		if err != nil {
+			return nil, err
+		}
+		defer protect(blob)()
+		return unmarshalECCPrivateBlob(blob)
+	}
+	return nil, fmt.Errorf("unsupported key type %T", key)
+}
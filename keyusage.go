@@ -0,0 +1,88 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// allKeyUsages holds every x509.KeyUsage bit.
+const allKeyUsages = x509.KeyUsageDecipherOnly<<1 - 1
+
+// keyUsageNames are the names of the x509.KeyUsage bits, in bit order.
+var keyUsageNames = []string{
+	"digital-signature", "content-commitment", "key-encipherment", "data-encipherment",
+	"key-agreement", "cert-sign", "crl-sign", "encipher-only", "decipher-only",
+}
+
+// KeyUsageString returns the names of the key usages in u, such as
+// "digital-signature|key-encipherment".
+func KeyUsageString(u x509.KeyUsage) string {
+	return flagString(uint32(u), keyUsageNames)
+}
+
+// KeyUsageFilter selects the certificates of the MY store that Cert and the
+// other lookups consider, by their key usage extension. The zero value
+// requires x509.KeyUsageDigitalSignature, so certificates that can only
+// encrypt are skipped unless the filter is changed.
+type KeyUsageFilter struct {
+	// Usage holds the key usages of which a certificate must have at least
+	// one. Zero means x509.KeyUsageDigitalSignature.
+	Usage x509.KeyUsage
+	// Disabled considers certificates regardless of their key usage,
+	// including certificates without the extension. Usage must be zero.
+	Disabled bool
+}
+
+func (f KeyUsageFilter) validate() error {
+	if f.Usage&^allKeyUsages != 0 {
+		return fmt.Errorf("key usage filter %#x has unknown bits", int(f.Usage))
+	}
+	if f.Disabled && f.Usage != 0 {
+		return errors.New("a disabled key usage filter must not set Usage")
+	}
+	return nil
+}
+
+// match reports whether a certificate with usage passes the filter.
+func (f KeyUsageFilter) match(usage x509.KeyUsage) bool {
+	if f.Disabled {
+		return true
+	}
+	want := f.Usage
+	if want == 0 {
+		want = x509.KeyUsageDigitalSignature
+	}
+	return usage&want != 0
+}
+
+// keyUsageFromCAPI converts the key usage returned by
+// CertGetIntendedKeyUsage, whose first byte holds the bits of the extension
+// in ASN.1 bit string order, to an x509.KeyUsage.
+func keyUsageFromCAPI(bits uint16) x509.KeyUsage {
+	var u x509.KeyUsage
+	for i := uint(0); i < 8; i++ {
+		// CERT_DIGITAL_SIGNATURE_KEY_USAGE is 0x80, CERT_ENCIPHER_ONLY_KEY_USAGE 0x01.
+		if bits&(0x80>>i) != 0 {
+			u |= 1 << i
+		}
+	}
+	if bits&0x8000 != 0 { // CERT_DECIPHER_ONLY_KEY_USAGE
+		u |= x509.KeyUsageDecipherOnly
+	}
+	return u
+}
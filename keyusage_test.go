@@ -0,0 +1,70 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"testing"
+)
+
+func TestKeyUsageFromCAPI(t *testing.T) {
+	tests := []struct {
+		bits uint16
+		want x509.KeyUsage
+	}{
+		{0, 0},
+		{0x80, x509.KeyUsageDigitalSignature},
+		{0xa0, x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment},
+		{0x06, x509.KeyUsageCertSign | x509.KeyUsageCRLSign},
+		{0x8001, x509.KeyUsageEncipherOnly | x509.KeyUsageDecipherOnly},
+	}
+	for _, tt := range tests {
+		if got := keyUsageFromCAPI(tt.bits); got != tt.want {
+			t.Errorf("keyUsageFromCAPI(%#x) = %s, want %s", tt.bits, KeyUsageString(got), KeyUsageString(tt.want))
+		}
+	}
+}
+
+func TestKeyUsageFilter(t *testing.T) {
+	encipher := x509.KeyUsageKeyEncipherment
+	tests := []struct {
+		f     KeyUsageFilter
+		usage x509.KeyUsage
+		want  bool
+	}{
+		{KeyUsageFilter{}, x509.KeyUsageDigitalSignature | encipher, true},
+		{KeyUsageFilter{}, encipher, false},
+		{KeyUsageFilter{}, 0, false},
+		{KeyUsageFilter{Usage: encipher | x509.KeyUsageDataEncipherment}, encipher, true},
+		{KeyUsageFilter{Usage: encipher}, x509.KeyUsageDigitalSignature, false},
+		{KeyUsageFilter{Disabled: true}, 0, true},
+	}
+	for _, tt := range tests {
+		if err := tt.f.validate(); err != nil {
+			t.Errorf("%+v.validate() returned %v", tt.f, err)
+		}
+		if got := tt.f.match(tt.usage); got != tt.want {
+			t.Errorf("%+v.match(%s) = %t, want %t", tt.f, KeyUsageString(tt.usage), got, tt.want)
+		}
+	}
+	for _, f := range []KeyUsageFilter{{Usage: 1 << 9}, {Usage: encipher, Disabled: true}} {
+		if err := f.validate(); err == nil {
+			t.Errorf("%+v.validate() succeeded, want error", f)
+		}
+	}
+	if got, want := KeyUsageString(x509.KeyUsageDigitalSignature|encipher), "digital-signature|key-encipherment"; got != want {
+		t.Errorf("KeyUsageString = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,198 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// ncrypt.h notification flags
+	ncryptRegisterNotifyFlag   = 0x1 // NCRYPT_REGISTER_NOTIFY_FLAG
+	ncryptUnregisterNotifyFlag = 0x2 // NCRYPT_UNREGISTER_NOTIFY_FLAG
+
+	// winerror.h constants
+	nteBadKeyset = 0x80090016 // NTE_BAD_KEYSET
+
+	bCryptPublicKeyBlob = "PUBLICBLOB" // BCRYPT_PUBLIC_KEY_BLOB
+)
+
+var nCryptNotifyChangeKey = nCrypt.MustFindProc("NCryptNotifyChangeKey")
+
+// KeyChange describes how the key container of a WinCertStore changed.
+type KeyChange int
+
+const (
+	// KeyCreated means the container was created.
+	KeyCreated KeyChange = iota + 1
+	// KeyDeleted means the container was deleted.
+	KeyDeleted
+	// KeyModified means the container still exists, but its key material or
+	// modification time changed, for example because it was regenerated.
+	KeyModified
+)
+
+func (c KeyChange) String() string {
+	switch c {
+	case KeyCreated:
+		return "created"
+	case KeyDeleted:
+		return "deleted"
+	case KeyModified:
+		return "modified"
+	default:
+		return fmt.Sprintf("KeyChange(%d)", int(c))
+	}
+}
+
+// WatchKey registers for key change notifications from the provider of w
+// using NCryptNotifyChangeKey, and reports every change to the container of w
+// on the returned channel. Changes to other containers in the provider are
+// ignored. This allows agents to react immediately when the key is deleted or
+// re-provisioned out of band. Watching stops and the channel is closed once
+// ctx is done.
+func (w *WinCertStore) WatchKey(ctx context.Context) (<-chan KeyChange, error) {
+	last, err := w.keySnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	changed, err := windows.CreateEvent(nil, 0, 0, nil)
+	if err != nil {
+		return nil, fmt.Errorf("CreateEvent returned %v", err)
+	}
+	stop, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		windows.CloseHandle(changed)
+		return nil, fmt.Errorf("CreateEvent returned %v", err)
+	}
+
+	r, _, err := nCryptNotifyChangeKey.Call(w.Prov, uintptr(unsafe.Pointer(&changed)), ncryptRegisterNotifyFlag)
+	if r != 0 {
+		windows.CloseHandle(changed)
+		windows.CloseHandle(stop)
+		return nil, fmt.Errorf("NCryptNotifyChangeKey returned %X during registration: %v", r, err)
+	}
+
+	// stop is only closed once both goroutines are done with it, so cancel
+	// never signals a closed or reused handle.
+	var stopOnce sync.Once
+	cancel := func() { stopOnce.Do(func() { windows.SetEvent(stop) }) }
+	done := make(chan struct{})
+	canceled := make(chan struct{})
+	go func() {
+		defer close(canceled)
+		select {
+		case <-ctx.Done():
+			cancel()
+		case <-done:
+		}
+	}()
+
+	ch := make(chan KeyChange, 1)
+	go func() {
+		defer close(ch)
+		defer func() {
+			close(done)
+			<-canceled
+			windows.CloseHandle(stop)
+		}()
+		defer windows.CloseHandle(changed)
+		defer nCryptNotifyChangeKey.Call(w.Prov, uintptr(unsafe.Pointer(&changed)), ncryptUnregisterNotifyFlag)
+
+		for {
+			ev, err := windows.WaitForMultipleObjects([]windows.Handle{changed, stop}, false, windows.INFINITE)
+			if err != nil {
+				logError("WaitForMultipleObjects failed, no longer watching key.", opField("watchkey"), containerField(w.container), errField(err))
+				return
+			}
+			if ev != windows.WAIT_OBJECT_0 {
+				return
+			}
+
+			cur, err := w.keySnapshot()
+			if err != nil {
+				logWarning("Could not inspect key container.", opField("watchkey"), containerField(w.container), errField(err))
+				continue
+			}
+			change, ok := compareKeySnapshots(last, cur)
+			last = cur
+			if !ok {
+				continue
+			}
+			select {
+			case ch <- change:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// keySnapshot returns a value that changes whenever the key material or
+// modification time of the container of w changes, or nil if the container
+// does not exist.
+func (w *WinCertStore) keySnapshot() ([]byte, error) {
+	var kh uintptr
+	r, _, err := nCryptOpenKey.Call(
+		w.Prov,
+		uintptr(unsafe.Pointer(&kh)),
+		uintptr(unsafe.Pointer(wide(w.container))),
+		0,
+		uintptr(w.keyOpenFlags()))
+	if r == nteBadKeyset {
+		return nil, nil
+	}
+	if r != 0 {
+		return nil, fmt.Errorf("NCryptOpenKey for container %s returned %X: %v", w.container, r, err)
+	}
+	defer nCryptFreeObject.Call(kh)
+
+	snapshot, err := exportKey(kh, bCryptPublicKeyBlob)
+	if err != nil {
+		return nil, err
+	}
+	// Not every provider records a modification time, so it is optional.
+	if modified, err := getProperty(kh, "Modified"); err == nil {
+		snapshot = append(snapshot, modified...)
+	}
+	return snapshot, nil
+}
+
+// compareKeySnapshots returns the change between two snapshots taken by
+// keySnapshot, and false if there was none.
+func compareKeySnapshots(before, after []byte) (KeyChange, bool) {
+	switch {
+	case before == nil && after == nil:
+		return 0, false
+	case before == nil:
+		return KeyCreated, true
+	case after == nil:
+		return KeyDeleted, true
+	case !bytes.Equal(before, after):
+		return KeyModified, true
+	default:
+		return 0, false
+	}
+}
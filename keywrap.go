@@ -0,0 +1,167 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+)
+
+// KeyWrapAlgorithm selects the RSA-OAEP variant WrapKey and UnwrapKey use
+// to protect a symmetric key. The names returned by String are the JOSE "alg"
+// values of RFC 7518.
+type KeyWrapAlgorithm int
+
+// Supported key wrap algorithms.
+const (
+	// KeyWrapRSAOAEP is RSAES-OAEP with SHA-1 and MGF1 with SHA-1, "RSA-OAEP"
+	// in JOSE and the default RSAES-OAEP parameters in CMS.
+	KeyWrapRSAOAEP KeyWrapAlgorithm = iota
+	// KeyWrapRSAOAEP256 is RSAES-OAEP with SHA-256 and MGF1 with SHA-256,
+	// "RSA-OAEP-256" in JOSE.
+	KeyWrapRSAOAEP256
+)
+
+func (a KeyWrapAlgorithm) String() string {
+	switch a {
+	case KeyWrapRSAOAEP:
+		return "RSA-OAEP"
+	case KeyWrapRSAOAEP256:
+		return "RSA-OAEP-256"
+	default:
+		return fmt.Sprintf("KeyWrapAlgorithm(%d)", int(a))
+	}
+}
+
+// ParseKeyWrapAlgorithm returns the algorithm with the JOSE "alg" name alg.
+func ParseKeyWrapAlgorithm(alg string) (KeyWrapAlgorithm, error) {
+	for _, a := range []KeyWrapAlgorithm{KeyWrapRSAOAEP, KeyWrapRSAOAEP256} {
+		if a.String() == alg {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported key wrap algorithm %q", alg)
+}
+
+// hash returns the OAEP hash of a.
+func (a KeyWrapAlgorithm) hash() (crypto.Hash, error) {
+	switch a {
+	case KeyWrapRSAOAEP:
+		return crypto.SHA1, nil
+	case KeyWrapRSAOAEP256:
+		return crypto.SHA256, nil
+	}
+	return 0, fmt.Errorf("unsupported key wrap algorithm %v", a)
+}
+
+// emptySequence is the DER encoding of RSAES-OAEP-params with every field
+// set to its default, SHA-1 and MGF1 with SHA-1.
+var emptySequence = []byte{0x30, 0x00}
+
+// algorithmIdentifier returns the CMS keyEncryptionAlgorithm of a.
+func (a KeyWrapAlgorithm) algorithmIdentifier() (algorithmIdentifier, error) {
+	switch a {
+	case KeyWrapRSAOAEP:
+		return algorithmIdentifier{Algorithm: oidRSAESOAEP, Parameters: asn1.RawValue{FullBytes: emptySequence}}, nil
+	case KeyWrapRSAOAEP256:
+		sha256ID := algorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.RawValue{Tag: asn1.TagNull}}
+		sha256DER, err := asn1.Marshal(sha256ID)
+		if err != nil {
+			return algorithmIdentifier{}, err
+		}
+		params, err := asn1.Marshal(rsaesOAEPParams{
+			Hash: sha256ID,
+			MGF:  algorithmIdentifier{Algorithm: oidMGF1, Parameters: asn1.RawValue{FullBytes: sha256DER}},
+		})
+		if err != nil {
+			return algorithmIdentifier{}, err
+		}
+		return algorithmIdentifier{Algorithm: oidRSAESOAEP, Parameters: asn1.RawValue{FullBytes: params}}, nil
+	}
+	return algorithmIdentifier{}, fmt.Errorf("unsupported key wrap algorithm %v", a)
+}
+
+// keyWrapAlgorithmFor returns the algorithm of the CMS keyEncryptionAlgorithm
+// id. Only the two parameter sets produced by algorithmIdentifier are
+// accepted.
+func keyWrapAlgorithmFor(id algorithmIdentifier) (KeyWrapAlgorithm, error) {
+	if !id.Algorithm.Equal(oidRSAESOAEP) {
+		return 0, fmt.Errorf("unsupported key encryption algorithm %v", id.Algorithm)
+	}
+	for _, a := range []KeyWrapAlgorithm{KeyWrapRSAOAEP, KeyWrapRSAOAEP256} {
+		want, err := a.algorithmIdentifier()
+		if err != nil {
+			return 0, err
+		}
+		// An absent parameter field means the defaults as well.
+		if bytes.Equal(id.Parameters.FullBytes, want.Parameters.FullBytes) || a == KeyWrapRSAOAEP && len(id.Parameters.FullBytes) == 0 {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported RSAES-OAEP parameters %x", id.Parameters.FullBytes)
+}
+
+// KeyTransRecipientInfo encodes a key wrapped with alg for the key of
+// recipient as a CMS KeyTransRecipientInfo (RFC 5652, section 6.2.1) that
+// identifies the recipient by issuer and serial number.
+func KeyTransRecipientInfo(recipient *x509.Certificate, alg KeyWrapAlgorithm, wrapped []byte) ([]byte, error) {
+	ri, err := keyTransRecipient(recipient, alg, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(ri)
+}
+
+func keyTransRecipient(recipient *x509.Certificate, alg KeyWrapAlgorithm, wrapped []byte) (keyTransRecipientInfo, error) {
+	if recipient == nil {
+		return keyTransRecipientInfo{}, &ArgError{Op: "KeyTransRecipientInfo", Arg: "recipient", Reason: "certificate is nil"}
+	}
+	if err := checkNotEmpty("KeyTransRecipientInfo", "wrapped", wrapped); err != nil {
+		return keyTransRecipientInfo{}, err
+	}
+	id, err := alg.algorithmIdentifier()
+	if err != nil {
+		return keyTransRecipientInfo{}, err
+	}
+	return keyTransRecipientInfo{
+		IssuerAndSerialNumber:  issuerAndSerialNumber{Issuer: asn1.RawValue{FullBytes: recipient.RawIssuer}, SerialNumber: recipient.SerialNumber},
+		KeyEncryptionAlgorithm: id,
+		EncryptedKey:           wrapped,
+	}, nil
+}
+
+// parseKeyTransRecipientInfo decodes a KeyTransRecipientInfo that identifies
+// the recipient by issuer and serial number.
+func parseKeyTransRecipientInfo(der []byte) (*keyTransRecipientInfo, KeyWrapAlgorithm, error) {
+	var ri keyTransRecipientInfo
+	rest, err := asn1.Unmarshal(der, &ri)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not parse KeyTransRecipientInfo: %v", err)
+	}
+	if len(rest) > 0 {
+		return nil, 0, fmt.Errorf("trailing data after KeyTransRecipientInfo")
+	}
+	if ri.Version != 0 {
+		return nil, 0, fmt.Errorf("unsupported KeyTransRecipientInfo version %d", ri.Version)
+	}
+	alg, err := keyWrapAlgorithmFor(ri.KeyEncryptionAlgorithm)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &ri, alg, nil
+}
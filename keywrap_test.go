@@ -0,0 +1,105 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestKeyWrapAlgorithm(t *testing.T) {
+	for _, alg := range []KeyWrapAlgorithm{KeyWrapRSAOAEP, KeyWrapRSAOAEP256} {
+		got, err := ParseKeyWrapAlgorithm(alg.String())
+		if err != nil || got != alg {
+			t.Errorf("ParseKeyWrapAlgorithm(%q) returned %v, %v", alg, got, err)
+		}
+		id, err := alg.algorithmIdentifier()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := keyWrapAlgorithmFor(id); err != nil || got != alg {
+			t.Errorf("keyWrapAlgorithmFor(%v) returned %v, %v", alg, got, err)
+		}
+	}
+	if _, err := ParseKeyWrapAlgorithm("RSA1_5"); err == nil {
+		t.Error("ParseKeyWrapAlgorithm accepted RSA1_5")
+	}
+	if got, err := keyWrapAlgorithmFor(algorithmIdentifier{Algorithm: oidRSAESOAEP}); err != nil || got != KeyWrapRSAOAEP {
+		t.Errorf("keyWrapAlgorithmFor without parameters returned %v, %v, want the defaults", got, err)
+	}
+	if _, err := keyWrapAlgorithmFor(algorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}}); err == nil {
+		t.Error("keyWrapAlgorithmFor accepted rsaEncryption")
+	}
+}
+
+func TestKeyTransRecipientInfo(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(42), Subject: pkix.Name{CommonName: "recipient"}, NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cek := []byte("0123456789abcdef0123456789abcdef")
+
+	for _, tc := range []struct {
+		alg  KeyWrapAlgorithm
+		wrap func() ([]byte, error)
+	}{
+		{KeyWrapRSAOAEP, func() ([]byte, error) { return rsa.EncryptOAEP(sha1.New(), rand.Reader, &priv.PublicKey, cek, nil) }},
+		{KeyWrapRSAOAEP256, func() ([]byte, error) { return rsa.EncryptOAEP(sha256.New(), rand.Reader, &priv.PublicKey, cek, nil) }},
+	} {
+		wrapped, err := tc.wrap()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := KeyTransRecipientInfo(cert, tc.alg, wrapped)
+		if err != nil {
+			t.Fatalf("KeyTransRecipientInfo(%v) returned %v", tc.alg, err)
+		}
+		ri, alg, err := parseKeyTransRecipientInfo(b)
+		if err != nil {
+			t.Fatalf("parseKeyTransRecipientInfo(%v) returned %v", tc.alg, err)
+		}
+		if alg != tc.alg || ri.IssuerAndSerialNumber.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+			t.Errorf("parseKeyTransRecipientInfo(%v) returned %v for serial %v", tc.alg, alg, ri.IssuerAndSerialNumber.SerialNumber)
+		}
+		hash, err := alg.hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := rsa.DecryptOAEP(hash.New(), nil, priv, ri.EncryptedKey, nil)
+		if err != nil || string(got) != string(cek) {
+			t.Errorf("unwrapping the %v recipient info returned %q, %v", tc.alg, got, err)
+		}
+	}
+	if _, err := KeyTransRecipientInfo(nil, KeyWrapRSAOAEP, []byte{1}); err == nil {
+		t.Error("KeyTransRecipientInfo accepted a nil certificate")
+	}
+}
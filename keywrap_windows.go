@@ -0,0 +1,114 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"time"
+	"unsafe"
+)
+
+// oaepPadding returns the OAEP padding info for alg.
+func oaepPadding(alg KeyWrapAlgorithm) (oaepPaddingInfo, error) {
+	hash, err := alg.hash()
+	if err != nil {
+		return oaepPaddingInfo{}, err
+	}
+	algID, err := algIDFor(hash)
+	if err != nil {
+		return oaepPaddingInfo{}, err
+	}
+	return oaepPaddingInfo{pszAlgID: algID}, nil
+}
+
+// WrapKey encrypts the symmetric key cek with the public key of k using alg.
+// The result is the JWE Encrypted Key for the JOSE algorithm alg.String(),
+// and the encryptedKey of a KeyTransRecipientInfo for the certificate of k.
+func (k *RsaKey) WrapKey(cek []byte, alg KeyWrapAlgorithm) (_ []byte, err error) {
+	defer keyOp(k.stats, k.breaker, "wrapkey", k.Container, time.Now(), &err)
+	if err := k.breaker.allow(); err != nil {
+		return nil, err
+	}
+	if err := checkNotEmpty("WrapKey", "cek", cek); err != nil {
+		return nil, err
+	}
+	padding, err := oaepPadding(alg)
+	if err != nil {
+		return nil, err
+	}
+
+	var size uint32
+	r, _, err := nCryptEncrypt.Call(
+		k.handle,                          // hKey
+		uintptr(unsafe.Pointer(&cek[0])),  // pbInput
+		uintptr(len(cek)),                 // cbInput
+		uintptr(unsafe.Pointer(&padding)), // *pPaddingInfo
+		0,                                 // pbOutput, must be null on first run
+		0,                                 // cbOutput, ignored on first run
+		uintptr(unsafe.Pointer(&size)),    // pcbResult
+		NCryptPadOAEPFlag)
+	if r != 0 {
+		return nil, ncryptErr("NCryptEncrypt", r, "during size check", err)
+	}
+
+	wrapped := make([]byte, size)
+	r, _, err = nCryptEncrypt.Call(
+		k.handle,                             // hKey
+		uintptr(unsafe.Pointer(&cek[0])),     // pbInput
+		uintptr(len(cek)),                    // cbInput
+		uintptr(unsafe.Pointer(&padding)),    // *pPaddingInfo
+		uintptr(unsafe.Pointer(&wrapped[0])), // pbOutput
+		uintptr(size),                        // cbOutput
+		uintptr(unsafe.Pointer(&size)),       // pcbResult
+		NCryptPadOAEPFlag)
+	if r != 0 {
+		return nil, ncryptErr("NCryptEncrypt", r, "during encryption", err)
+	}
+	return wrapped[:size], nil
+}
+
+// UnwrapKey decrypts a symmetric key that was wrapped for k with alg, such
+// as by WrapKey or a JOSE library. The caller should Wipe the key once it is
+// done with it.
+func (k *RsaKey) UnwrapKey(wrapped []byte, alg KeyWrapAlgorithm) (_ []byte, err error) {
+	defer keyOp(k.stats, k.breaker, "unwrapkey", k.Container, time.Now(), &err)
+	if err := k.breaker.allow(); err != nil {
+		return nil, err
+	}
+	if err := k.quota.allow("unwrapkey"); err != nil {
+		return nil, err
+	}
+	if err := checkNotEmpty("UnwrapKey", "wrapped", wrapped); err != nil {
+		return nil, err
+	}
+	padding, err := oaepPadding(alg)
+	if err != nil {
+		return nil, err
+	}
+	return rsaDecrypt(k.handle, wrapped, &padding, NCryptPadOAEPFlag)
+}
+
+// UnwrapCMSKey decrypts the key in a DER encoded CMS KeyTransRecipientInfo
+// that uses RSAES-OAEP, such as one produced by KeyTransRecipientInfo. The
+// caller is expected to have selected the recipient info matching the
+// certificate of k.
+func (k *RsaKey) UnwrapCMSKey(recipientInfo []byte) ([]byte, error) {
+	ri, alg, err := parseKeyTransRecipientInfo(recipientInfo)
+	if err != nil {
+		return nil, err
+	}
+	return k.UnwrapKey(ri.EncryptedKey, alg)
+}
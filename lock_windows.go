@@ -0,0 +1,118 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// waitTimeout is WAIT_TIMEOUT as returned by WaitForSingleObject.
+	waitTimeout = 0x102
+
+	// containerLockTimeout bounds how long Generate waits for another process
+	// that is provisioning the same container.
+	containerLockTimeout = 10 * time.Minute
+)
+
+// containerLockName returns the name of the machine wide mutex guarding a key
+// container.
+func containerLockName(provider, container string) string {
+	return containerObjectName("certtostore-", provider, container)
+}
+
+// containerObjectName returns the name of a machine wide object for a key
+// container. Container names may contain characters that are not valid in
+// object names, so the name is derived from a hash.
+func containerObjectName(prefix, provider, container string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(provider) + "\x00" + strings.ToLower(container)))
+	return `Global\` + prefix + hex.EncodeToString(sum[:16])
+}
+
+// lockContainer acquires the named mutex for the container, so that
+// concurrent provisioning attempts from different processes serialize instead
+// of silently overwriting each other's keys. The returned function releases
+// the lock and may be called from any goroutine. Mutexes are owned by threads,
+// so the lock is held by a goroutine locked to its own OS thread until it is
+// released.
+func lockContainer(provider, container string) (func(), error) {
+	name, err := windows.UTF16PtrFromString(containerLockName(provider, container))
+	if err != nil {
+		return nil, err
+	}
+	return holdMutex(func() (windows.Handle, error) { return acquireMutex(name, container) })
+}
+
+// holdMutex calls acquire, which opens and waits for a mutex, on a goroutine
+// locked to its own OS thread, and keeps the thread until the returned
+// function releases the mutex. The returned function may be called from any
+// goroutine.
+func holdMutex(acquire func() (windows.Handle, error)) (func(), error) {
+	acquired := make(chan error, 1)
+	release := make(chan struct{})
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		mu, err := acquire()
+		acquired <- err
+		if err != nil {
+			return
+		}
+		<-release
+		windows.ReleaseMutex(mu)
+		windows.CloseHandle(mu)
+	}()
+	if err := <-acquired; err != nil {
+		return nil, err
+	}
+	var once sync.Once
+	return func() { once.Do(func() { close(release) }) }, nil
+}
+
+// acquireMutex opens the named mutex and waits until the calling thread owns
+// it.
+func acquireMutex(name *uint16, container string) (windows.Handle, error) {
+	mu, err := windows.CreateMutex(nil, false, name)
+	if err != nil {
+		return 0, fmt.Errorf("CreateMutex returned %v", err)
+	}
+
+	start := time.Now()
+	ev, err := windows.WaitForSingleObject(mu, uint32(containerLockTimeout/time.Millisecond))
+	switch {
+	case err != nil:
+		windows.CloseHandle(mu)
+		return 0, fmt.Errorf("WaitForSingleObject returned %v", err)
+	case ev == waitTimeout:
+		windows.CloseHandle(mu)
+		return 0, fmt.Errorf("timed out after %v waiting for another process to finish provisioning container %s", containerLockTimeout, container)
+	case ev == windows.WAIT_ABANDONED:
+		// The previous owner exited without releasing the lock. We own it now,
+		// but the container may hold a partially provisioned key.
+		logWarning("Acquired an abandoned container lock.", opField("lock"), containerField(container))
+	}
+	logDebug("Acquired container lock.", opField("lock"), containerField(container), sinceField(start))
+	return mu, nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log message.
+type Level int
+
+// Log levels in increasing order of severity.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarning
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarning:
+		return "WARNING"
+	case LevelError:
+		return "ERROR"
+	default:
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+}
+
+// Keys of the structured fields attached to log messages.
+const (
+	FieldOperation  = "operation"
+	FieldContainer  = "container"
+	FieldThumbprint = "thumbprint"
+	FieldDuration   = "duration"
+	FieldStatus     = "status"
+)
+
+// Field is a key/value pair attached to a log message.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Logger receives the log messages of this package.
+type Logger interface {
+	Log(level Level, msg string, fields ...Field)
+}
+
+var (
+	logMu     sync.RWMutex
+	pkgLogger Logger = stdLogger{}
+	minLevel         = LevelInfo
+)
+
+// SetLogger directs the log messages of this package to l. A nil Logger
+// discards all messages.
+func SetLogger(l Logger) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	pkgLogger = l
+}
+
+// SetLogLevel sets the minimum level of the messages passed to the Logger.
+// Messages below LevelInfo are dropped by default.
+func SetLogLevel(l Level) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	minLevel = l
+}
+
+// logMsg passes msg to the package Logger if its level is enabled.
+func logMsg(level Level, msg string, fields ...Field) {
+	logMu.RLock()
+	l, min := pkgLogger, minLevel
+	logMu.RUnlock()
+	if l == nil || level < min {
+		return
+	}
+	l.Log(level, msg, fields...)
+}
+
+func logDebug(msg string, fields ...Field)   { logMsg(LevelDebug, msg, fields...) }
+func logInfo(msg string, fields ...Field)    { logMsg(LevelInfo, msg, fields...) }
+func logWarning(msg string, fields ...Field) { logMsg(LevelWarning, msg, fields...) }
+func logError(msg string, fields ...Field)   { logMsg(LevelError, msg, fields...) }
+
+func opField(op string) Field               { return Field{FieldOperation, op} }
+func containerField(c string) Field         { return Field{FieldContainer, c} }
+func thumbprintField(t string) Field        { return Field{FieldThumbprint, t} }
+func durationField(d time.Duration) Field   { return Field{FieldDuration, d} }
+func statusField(status uintptr) Field      { return Field{FieldStatus, fmt.Sprintf("%X", status)} }
+func errField(err error) Field              { return Field{"error", err} }
+func sinceField(start time.Time) Field      { return durationField(time.Since(start)) }
+func field(key string, v interface{}) Field { return Field{key, v} }
+
+// FormatFields renders msg followed by the fields as key=value pairs, quoting
+// values that contain spaces. It can be used by Logger implementations that
+// write plain text.
+func FormatFields(msg string, fields ...Field) string {
+	var b bytes.Buffer
+	b.WriteString(msg)
+	for _, f := range fields {
+		v := fmt.Sprint(f.Value)
+		if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+			v = fmt.Sprintf("%q", v)
+		}
+		fmt.Fprintf(&b, " %s=%s", f.Key, v)
+	}
+	return b.String()
+}
+
+// stdLogger writes messages through the standard library log package.
+type stdLogger struct{}
+
+func (stdLogger) Log(level Level, msg string, fields ...Field) {
+	log.Printf("%s: %s", level, FormatFields(msg, fields...))
+}
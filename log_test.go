@@ -0,0 +1,62 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"testing"
+)
+
+type recordingLogger struct {
+	levels []Level
+}
+
+func (r *recordingLogger) Log(level Level, msg string, fields ...Field) {
+	r.levels = append(r.levels, level)
+}
+
+func TestFormatFields(t *testing.T) {
+	got := FormatFields("Key opened.", opField("openkey"), containerField("my container"), statusField(0x80090016), field("empty", ""))
+	want := `Key opened. operation=openkey container="my container" status=80090016 empty=""`
+	if got != want {
+		t.Errorf("unexpected FormatFields output got: %s, want: %s", got, want)
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	logMu.RLock()
+	oldLogger, oldLevel := pkgLogger, minLevel
+	logMu.RUnlock()
+	defer func() {
+		SetLogger(oldLogger)
+		SetLogLevel(oldLevel)
+	}()
+
+	r := &recordingLogger{}
+	SetLogger(r)
+	SetLogLevel(LevelWarning)
+	logDebug("debug")
+	logInfo("info")
+	logWarning("warning")
+	logError("error")
+	if len(r.levels) != 2 || r.levels[0] != LevelWarning || r.levels[1] != LevelError {
+		t.Errorf("unexpected logged levels got: %v, want: [WARNING ERROR]", r.levels)
+	}
+
+	SetLogger(nil)
+	logError("discarded")
+	if len(r.levels) != 2 {
+		t.Errorf("message logged after SetLogger(nil)")
+	}
+}
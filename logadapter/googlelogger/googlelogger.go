@@ -0,0 +1,41 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package googlelogger sends the log messages of certtostore to
+// github.com/google/logger, which certtostore used before it had a Logger
+// interface:
+//
+//	certtostore.SetLogger(googlelogger.Logger{})
+package googlelogger
+
+import (
+	"github.com/google/certtostore"
+	"github.com/google/logger"
+)
+
+// Logger is a certtostore.Logger that writes to github.com/google/logger.
+// Debug messages are logged at the info level.
+type Logger struct{}
+
+// Log implements certtostore.Logger.
+func (Logger) Log(level certtostore.Level, msg string, fields ...certtostore.Field) {
+	switch level {
+	case certtostore.LevelError:
+		logger.Error(certtostore.FormatFields(msg, fields...))
+	case certtostore.LevelWarning:
+		logger.Warning(certtostore.FormatFields(msg, fields...))
+	default:
+		logger.Info(certtostore.FormatFields(msg, fields...))
+	}
+}
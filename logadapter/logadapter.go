@@ -0,0 +1,84 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package logadapter connects the log messages of certtostore to common
+// logging libraries. Adapters that need a third party module live in their own
+// subpackages so that only the binaries using them depend on it.
+package logadapter
+
+import (
+	"log"
+
+	"github.com/google/certtostore"
+)
+
+// Std returns a certtostore.Logger that writes plain text messages to l, or to
+// the standard logger if l is nil.
+func Std(l *log.Logger) certtostore.Logger {
+	return stdLogger{l}
+}
+
+type stdLogger struct {
+	l *log.Logger
+}
+
+func (s stdLogger) Log(level certtostore.Level, msg string, fields ...certtostore.Field) {
+	if s.l == nil {
+		log.Printf("%s: %s", level, certtostore.FormatFields(msg, fields...))
+		return
+	}
+	s.l.Printf("%s: %s", level, certtostore.FormatFields(msg, fields...))
+}
+
+// SugaredLogger is the structured logging interface implemented by
+// *zap.SugaredLogger.
+type SugaredLogger interface {
+	Debugw(msg string, keysAndValues ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
+	Errorw(msg string, keysAndValues ...interface{})
+}
+
+// Sugared returns a certtostore.Logger that writes to s, passing the message
+// fields as key/value pairs.
+func Sugared(s SugaredLogger) certtostore.Logger {
+	return sugaredLogger{s}
+}
+
+type sugaredLogger struct {
+	s SugaredLogger
+}
+
+func (s sugaredLogger) Log(level certtostore.Level, msg string, fields ...certtostore.Field) {
+	kv := keysAndValues(fields)
+	switch level {
+	case certtostore.LevelDebug:
+		s.s.Debugw(msg, kv...)
+	case certtostore.LevelInfo:
+		s.s.Infow(msg, kv...)
+	case certtostore.LevelWarning:
+		s.s.Warnw(msg, kv...)
+	default:
+		s.s.Errorw(msg, kv...)
+	}
+}
+
+// keysAndValues flattens fields into alternating keys and values.
+func keysAndValues(fields []certtostore.Field) []interface{} {
+	kv := make([]interface{}, 0, 2*len(fields))
+	for _, f := range fields {
+		kv = append(kv, f.Key, f.Value)
+	}
+	return kv
+}
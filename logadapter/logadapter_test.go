@@ -0,0 +1,64 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logadapter
+
+import (
+	"bytes"
+	"log"
+	"reflect"
+	"testing"
+
+	"github.com/google/certtostore"
+)
+
+type recordingSugared struct {
+	calls []string
+	kv    []interface{}
+}
+
+func (r *recordingSugared) record(level, msg string, kv []interface{}) {
+	r.calls = append(r.calls, level+":"+msg)
+	r.kv = kv
+}
+
+func (r *recordingSugared) Debugw(msg string, kv ...interface{}) { r.record("debug", msg, kv) }
+func (r *recordingSugared) Infow(msg string, kv ...interface{})  { r.record("info", msg, kv) }
+func (r *recordingSugared) Warnw(msg string, kv ...interface{})  { r.record("warn", msg, kv) }
+func (r *recordingSugared) Errorw(msg string, kv ...interface{}) { r.record("error", msg, kv) }
+
+func TestSugared(t *testing.T) {
+	r := &recordingSugared{}
+	l := Sugared(r)
+	l.Log(certtostore.LevelDebug, "a")
+	l.Log(certtostore.LevelInfo, "b")
+	l.Log(certtostore.LevelWarning, "c")
+	l.Log(certtostore.LevelError, "d", certtostore.Field{Key: "container", Value: "k"})
+	want := []string{"debug:a", "info:b", "warn:c", "error:d"}
+	if !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("unexpected calls got: %v, want: %v", r.calls, want)
+	}
+	if !reflect.DeepEqual(r.kv, []interface{}{"container", "k"}) {
+		t.Errorf("unexpected key/value pairs got: %v", r.kv)
+	}
+}
+
+func TestStd(t *testing.T) {
+	var b bytes.Buffer
+	Std(log.New(&b, "", 0)).Log(certtostore.LevelWarning, "Key opened.", certtostore.Field{Key: "container", Value: "my key"})
+	want := "WARNING: Key opened. container=\"my key\"\n"
+	if b.String() != want {
+		t.Errorf("unexpected output got: %q, want: %q", b.String(), want)
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build go1.21
+
+package logadapter
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/google/certtostore"
+)
+
+// Slog returns a certtostore.Logger that writes to l, or to the default slog
+// logger if l is nil.
+func Slog(l *slog.Logger) certtostore.Logger {
+	return slogLogger{l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (s slogLogger) Log(level certtostore.Level, msg string, fields ...certtostore.Field) {
+	l := s.l
+	if l == nil {
+		l = slog.Default()
+	}
+	l.Log(context.Background(), slogLevel(level), msg, keysAndValues(fields)...)
+}
+
+func slogLevel(level certtostore.Level) slog.Level {
+	switch level {
+	case certtostore.LevelDebug:
+		return slog.LevelDebug
+	case certtostore.LevelInfo:
+		return slog.LevelInfo
+	case certtostore.LevelWarning:
+		return slog.LevelWarn
+	default:
+		return slog.LevelError
+	}
+}
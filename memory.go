@@ -0,0 +1,124 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto"
+	"crypto/x509"
+	"sync"
+)
+
+// MemoryStorage is a CertStorage that keeps the certificates and software
+// keys in memory. It lets applications unit test their enrollment and
+// rotation logic without a certificate store, a TPM or administrator rights.
+// It is safe for concurrent use.
+type MemoryStorage struct {
+	mu           sync.Mutex
+	cert         *x509.Certificate
+	intermediate *x509.Certificate
+	key          crypto.Signer
+	// pending is the key of the last Generate call until Store installs it.
+	pending crypto.Signer
+}
+
+var _ CertStorage = &MemoryStorage{}
+var _ AlgorithmGenerator = &MemoryStorage{}
+var _ ManagedStorage = &MemoryStorage{}
+
+// NewMemoryStorage returns an empty MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{}
+}
+
+// Install replaces the contents of the storage with cert, intermediate and
+// key, so tests can start from existing fixtures. Any argument may be nil.
+func (m *MemoryStorage) Install(cert, intermediate *x509.Certificate, key crypto.Signer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cert, m.intermediate, m.key = cert, intermediate, key
+}
+
+// Cert returns the current cert or nil if there is none.
+func (m *MemoryStorage) Cert() (*x509.Certificate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.cert, nil
+}
+
+// Intermediate returns the current intermediate cert or nil if there is none.
+func (m *MemoryStorage) Intermediate() (*x509.Certificate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.intermediate, nil
+}
+
+// Generate creates a new RSA key of keySize bits, see GenerateAlgorithm.
+func (m *MemoryStorage) Generate(keySize int) (crypto.Signer, error) {
+	return m.GenerateAlgorithm(keySize, "RSA")
+}
+
+// GenerateAlgorithm creates a new RSA or ECDSA private key and returns a signer
+// that can be used to make a CSR for the key. An empty alg generates an RSA
+// key. The current key is kept until Store installs the new one.
+func (m *MemoryStorage) GenerateAlgorithm(keySize int, alg string) (crypto.Signer, error) {
+	key, err := generateSoftwareKey(keySize, alg)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending = key
+	return key, nil
+}
+
+// Store installs cert, intermediate and the key of the last Generate call.
+// Like FileStorage, the current key is kept if Generate was not called.
+func (m *MemoryStorage) Store(cert *x509.Certificate, intermediate *x509.Certificate) error {
+	if err := checkCert("Store", "cert", cert); err != nil {
+		return err
+	}
+	if err := checkCert("Store", "intermediate", intermediate); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cert, m.intermediate = cert, intermediate
+	if m.pending != nil {
+		m.key, m.pending = m.pending, nil
+	}
+	return nil
+}
+
+// Signer returns the current private key or nil if there is none. The key
+// returned by Generate is only installed by Store.
+func (m *MemoryStorage) Signer() (crypto.Signer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.key, nil
+}
+
+// Remove deletes the cert, intermediate and key. MemoryStorage has no system
+// wide location, so removeSystem is ignored.
+func (m *MemoryStorage) Remove(removeSystem bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cert, m.intermediate, m.key = nil, nil, nil
+	return nil
+}
+
+// Link does nothing, MemoryStorage keeps a single copy of the cert.
+func (m *MemoryStorage) Link() error {
+	return nil
+}
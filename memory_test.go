@@ -0,0 +1,103 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"testing"
+
+	"github.com/google/certtostore/testdata"
+	"github.com/google/certtostore/testdata/helpers"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	xc, err := PEMToX509([]byte(testdata.CertPEM))
+	if err != nil {
+		t.Fatalf("error decoding test certificate: %v", err)
+	}
+
+	m := NewMemoryStorage()
+	if cert, err := m.Cert(); err != nil || cert != nil {
+		t.Errorf("expected no cert on a new store, instead %v, %v", cert, err)
+	}
+	if key, err := m.Signer(); err != nil || key != nil {
+		t.Errorf("expected no key on a new store, instead %v, %v", key, err)
+	}
+
+	signer, err := m.GenerateAlgorithm(0, "ECDSA_P256")
+	if err != nil {
+		t.Fatalf("failed to generate signer: %v", err)
+	}
+	if _, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{}, signer); err != nil {
+		t.Errorf("failed to create signed CSR with signer from Generate: %v", err)
+	}
+	if key, _ := m.Signer(); key != nil {
+		t.Error("Generate installed the key before Store")
+	}
+
+	if err := m.Store(xc, xc); err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+	if cert, err := m.Cert(); err != nil || !cert.Equal(xc) {
+		t.Errorf("expected read-back cert to match xc, instead %v, %v", cert, err)
+	}
+	if cert, err := m.Intermediate(); err != nil || !cert.Equal(xc) {
+		t.Errorf("expected read-back intermediate to match xc, instead %v, %v", cert, err)
+	}
+	key, err := m.Signer()
+	if err != nil || key == nil || !key.Public().(*ecdsa.PublicKey).Equal(signer.Public()) {
+		t.Errorf("expected read-back key to match the generated key, instead %v, %v", key, err)
+	}
+
+	// Storing a renewed cert without a new key keeps the key.
+	if err := m.Store(xc, xc); err != nil {
+		t.Fatalf("second store failed: %v", err)
+	}
+	if key2, _ := m.Signer(); key2 != key {
+		t.Error("Store without Generate replaced the key")
+	}
+
+	if err := m.Store(nil, xc); err == nil {
+		t.Error("Store succeeded without a cert")
+	}
+	if _, err := m.GenerateAlgorithm(2048, "DSA"); err == nil {
+		t.Error("Generate succeeded with an unsupported algorithm, want error")
+	}
+
+	if err := m.Remove(true); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	if cert, err := m.Cert(); err != nil || cert != nil {
+		t.Errorf("expected no cert after remove, instead %v, %v", cert, err)
+	}
+	if key, err := m.Signer(); err != nil || key != nil {
+		t.Errorf("expected no key after remove, instead %v, %v", key, err)
+	}
+}
+
+func TestMemoryStorageHelperVectors(t *testing.T) {
+	for _, key := range []crypto.Signer{helpers.RSAKey(), helpers.ECDSAKey()} {
+		m := NewMemoryStorage()
+		m.Install(nil, nil, key)
+		signer, err := m.Signer()
+		if err != nil {
+			t.Fatalf("Signer returned %v", err)
+		}
+		helpers.AssertSigner(t, signer)
+	}
+}
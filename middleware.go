@@ -0,0 +1,205 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto"
+	"io"
+	"time"
+)
+
+// CryptoKey is the set of key operations shared by the keys of every
+// backend: the Key of WinCertStore, PKCS11Key, TPMKey and software keys
+// wrapped with NewSoftwareKey.
+type CryptoKey interface {
+	Public() crypto.PublicKey
+	Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error)
+	SignMessage(r io.Reader, hash crypto.Hash) ([]byte, error)
+	// Decrypt returns ErrNotSupported for keys that cannot decrypt.
+	Decrypt(rand io.Reader, blob []byte, opts crypto.DecrypterOpts) ([]byte, error)
+	PublicDER() ([]byte, error)
+	PublicPEM() ([]byte, error)
+}
+
+// KeyMiddleware adds behavior, such as logging, metrics, retries or rate
+// limiting, to the operations of a key. It returns a key that performs the
+// operations of the key it is given.
+type KeyMiddleware func(CryptoKey) CryptoKey
+
+// WrapKey applies mws to k. The first middleware is the outermost, so it
+// sees every operation before the others do.
+func WrapKey(k CryptoKey, mws ...KeyMiddleware) CryptoKey {
+	for i := len(mws) - 1; i >= 0; i-- {
+		k = mws[i](k)
+	}
+	return k
+}
+
+// interceptedKey runs the signing and decryption operations of a key through
+// around, which must call call exactly once to perform the operation unless
+// it fails it. The public key methods are passed through.
+type interceptedKey struct {
+	CryptoKey
+	around func(op string, call func() error) error
+}
+
+func (k *interceptedKey) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) (sig []byte, err error) {
+	err = k.around("sign", func() (err error) {
+		sig, err = k.CryptoKey.Sign(rand, digest, opts)
+		return err
+	})
+	return sig, err
+}
+
+func (k *interceptedKey) SignMessage(r io.Reader, hash crypto.Hash) (sig []byte, err error) {
+	err = k.around("sign", func() (err error) {
+		sig, err = k.CryptoKey.SignMessage(r, hash)
+		return err
+	})
+	return sig, err
+}
+
+func (k *interceptedKey) Decrypt(rand io.Reader, blob []byte, opts crypto.DecrypterOpts) (plain []byte, err error) {
+	err = k.around("decrypt", func() (err error) {
+		plain, err = k.CryptoKey.Decrypt(rand, blob, opts)
+		return err
+	})
+	return plain, err
+}
+
+// intercept returns a KeyMiddleware that runs the operations through around.
+func intercept(around func(op string, call func() error) error) KeyMiddleware {
+	return func(k CryptoKey) CryptoKey {
+		return &interceptedKey{CryptoKey: k, around: around}
+	}
+}
+
+// WithLogging logs the key operations like the keys of WinCertStore do,
+// naming the key name in the container field.
+func WithLogging(name string) KeyMiddleware {
+	return intercept(func(op string, call func() error) error {
+		start := time.Now()
+		err := call()
+		if err != nil {
+			logWarning("Key operation failed.", opField(op), containerField(name), sinceField(start), errField(err))
+		} else {
+			logDebug("Key operation succeeded.", opField(op), containerField(name), sinceField(start))
+		}
+		return err
+	})
+}
+
+// WithMetrics calls observe after every key operation with its name, such
+// as "sign" or "decrypt", its duration and its error.
+func WithMetrics(observe func(op string, d time.Duration, err error)) KeyMiddleware {
+	return intercept(func(op string, call func() error) error {
+		start := time.Now()
+		err := call()
+		observe(op, time.Since(start), err)
+		return err
+	})
+}
+
+// WithRetry retries failed key operations up to attempts times in total,
+// waiting backoff before the first retry and doubling it after each. Only
+// errors for which retryable returns true are retried. A nil retryable
+// retries every error except argument errors, ErrNotSupported,
+// ErrCircuitOpen and ErrQuotaExceeded, which a retry cannot fix.
+//
+// Retried operations read rand again, and SignMessage cannot reread its
+// message, so it is not retried.
+func WithRetry(attempts int, backoff time.Duration, retryable func(error) bool) KeyMiddleware {
+	if retryable == nil {
+		retryable = defaultRetryable
+	}
+	return func(k CryptoKey) CryptoKey {
+		return &retryKey{
+			interceptedKey: interceptedKey{CryptoKey: k, around: func(op string, call func() error) error {
+				err := call()
+				for i, wait := 1, backoff; i < attempts && err != nil && retryable(err); i, wait = i+1, wait*2 {
+					logWarning("Retrying key operation.", opField(op), errField(err), field("attempt", i+1))
+					time.Sleep(wait)
+					err = call()
+				}
+				return err
+			}},
+		}
+	}
+}
+
+// retryKey is the interceptedKey of WithRetry, which passes SignMessage
+// through.
+type retryKey struct {
+	interceptedKey
+}
+
+func (k *retryKey) SignMessage(r io.Reader, hash crypto.Hash) ([]byte, error) {
+	return k.CryptoKey.SignMessage(r, hash)
+}
+
+func defaultRetryable(err error) bool {
+	if _, ok := err.(*ArgError); ok {
+		return false
+	}
+	return err != ErrNotSupported && err != ErrCircuitOpen && err != ErrQuotaExceeded
+}
+
+// WithQuota counts the key operations against q, which is shared by every
+// key the middleware is applied to. Anomalies are reported with an empty
+// Container.
+func WithQuota(q UsageQuota) (KeyMiddleware, error) {
+	if err := q.validate(); err != nil {
+		return nil, &ArgError{Op: "WithQuota", Arg: "q", Reason: err.Error()}
+	}
+	quota := newUsageQuotas(q).forKey("")
+	return intercept(func(op string, call func() error) error {
+		if err := quota.allow(op); err != nil {
+			return err
+		}
+		return call()
+	}), nil
+}
+
+// softwareKey is the CryptoKey of NewSoftwareKey.
+type softwareKey struct {
+	crypto.Signer
+}
+
+// NewSoftwareKey returns s, such as a key of FileStorage or MemoryStorage, as
+// a CryptoKey so middleware can be applied to it. Decrypt returns
+// ErrNotSupported unless s is also a crypto.Decrypter.
+func NewSoftwareKey(s crypto.Signer) CryptoKey {
+	return softwareKey{s}
+}
+
+func (k softwareKey) SignMessage(r io.Reader, hash crypto.Hash) ([]byte, error) {
+	return SignMessage(k.Signer, r, hash)
+}
+
+func (k softwareKey) Decrypt(rand io.Reader, blob []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	d, ok := k.Signer.(crypto.Decrypter)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return d.Decrypt(rand, blob, opts)
+}
+
+func (k softwareKey) PublicDER() ([]byte, error) {
+	return publicDER(k.Public())
+}
+
+func (k softwareKey) PublicPEM() ([]byte, error) {
+	return publicPEM(k.Public())
+}
@@ -0,0 +1,134 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// flakyKey fails its first failures signatures with err.
+type flakyKey struct {
+	CryptoKey
+	failures int
+	err      error
+	calls    int
+}
+
+func (k *flakyKey) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	k.calls++
+	if k.calls <= k.failures {
+		return nil, k.err
+	}
+	return k.CryptoKey.Sign(rand, digest, opts)
+}
+
+func TestWrapKey(t *testing.T) {
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	digest := sha256.Sum256([]byte("message"))
+
+	var order []string
+	trace := func(name string) KeyMiddleware {
+		return WithMetrics(func(op string, d time.Duration, err error) {
+			order = append(order, name+" "+op)
+		})
+	}
+	k := WrapKey(NewSoftwareKey(ec), trace("outer"), trace("inner"), WithLogging("test"))
+	sig, err := k.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("Sign returned %v", err)
+	}
+	if !ecdsa.VerifyASN1(&ec.PublicKey, digest[:], sig) {
+		t.Error("signature of the wrapped key does not verify")
+	}
+	if _, err := k.Decrypt(rand.Reader, []byte{1}, nil); err != ErrNotSupported {
+		t.Errorf("Decrypt of an ECDSA key returned %v, want ErrNotSupported", err)
+	}
+	// Metrics are observed once the operation returns, so the inner
+	// middleware reports first.
+	want := []string{"inner sign", "outer sign", "inner decrypt", "outer decrypt"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("middleware ran in order %q, want %q", order, want)
+	}
+	if _, err := k.PublicDER(); err != nil {
+		t.Errorf("PublicDER returned %v", err)
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	rk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	digest := sha256.Sum256([]byte("message"))
+	transient := errors.New("transient")
+
+	flaky := &flakyKey{CryptoKey: NewSoftwareKey(rk), failures: 2, err: transient}
+	if _, err := WrapKey(flaky, WithRetry(3, time.Millisecond, nil)).Sign(rand.Reader, digest[:], crypto.SHA256); err != nil {
+		t.Errorf("Sign with retries returned %v", err)
+	}
+	if flaky.calls != 3 {
+		t.Errorf("key was called %d times, want 3", flaky.calls)
+	}
+
+	flaky = &flakyKey{CryptoKey: NewSoftwareKey(rk), failures: 5, err: transient}
+	if _, err := WrapKey(flaky, WithRetry(2, time.Millisecond, nil)).Sign(rand.Reader, digest[:], crypto.SHA256); err != transient {
+		t.Errorf("Sign returned %v after exhausting retries, want %v", err, transient)
+	}
+
+	flaky = &flakyKey{CryptoKey: NewSoftwareKey(rk), failures: 1, err: ErrNotSupported}
+	if _, err := WrapKey(flaky, WithRetry(3, time.Millisecond, nil)).Sign(rand.Reader, digest[:], crypto.SHA256); err != ErrNotSupported || flaky.calls != 1 {
+		t.Errorf("Sign returned %v after %d calls, want ErrNotSupported after 1", err, flaky.calls)
+	}
+}
+
+func TestWithQuota(t *testing.T) {
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	digest := sha256.Sum256([]byte("message"))
+
+	if _, err := WithQuota(UsageQuota{PerMinute: -1}); err == nil {
+		t.Error("WithQuota succeeded with a negative limit")
+	}
+	var anomalies []UsageAnomaly
+	mw, err := WithQuota(UsageQuota{PerMinute: 1, Throttle: true, OnAnomaly: func(a UsageAnomaly) { anomalies = append(anomalies, a) }})
+	if err != nil {
+		t.Fatalf("WithQuota returned %v", err)
+	}
+	k := WrapKey(NewSoftwareKey(ec), mw)
+	if _, err := k.Sign(rand.Reader, digest[:], crypto.SHA256); err != nil {
+		t.Fatalf("first Sign returned %v", err)
+	}
+	if _, err := k.Sign(rand.Reader, digest[:], crypto.SHA256); err != ErrQuotaExceeded {
+		t.Errorf("second Sign returned %v, want ErrQuotaExceeded", err)
+	}
+	if len(anomalies) != 1 || anomalies[0].Op != "sign" {
+		t.Errorf("unexpected anomalies %+v", anomalies)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+)
+
+var (
+	// oidNTDSCASecurityExt is szOID_NTDS_CA_SECURITY_EXT, added by enterprise
+	// CAs for strong certificate mapping (KB5014754).
+	oidNTDSCASecurityExt = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 25, 2}
+	// oidNTDSObjectSID is szOID_NTDS_OBJECTSID, the other name in
+	// szOID_NTDS_CA_SECURITY_EXT that holds the SID.
+	oidNTDSObjectSID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 25, 2, 1}
+	// oidApplicationCertPolicies is szOID_APPLICATION_CERT_POLICIES.
+	oidApplicationCertPolicies = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 21, 10}
+)
+
+// MSExtensions holds the Microsoft specific extensions of a certificate that
+// enterprise policy decisions commonly depend on.
+type MSExtensions struct {
+	// Template is the certificate template the certificate was issued from,
+	// or nil if it has no template extension.
+	Template *CertTemplate
+	// ObjectSID is the SID of the Active Directory account the certificate
+	// was issued to, from szOID_NTDS_CA_SECURITY_EXT, or "" if absent.
+	ObjectSID string
+	// ApplicationPolicies are the policy OIDs of szOID_APPLICATION_CERT_POLICIES,
+	// which Windows evaluates in addition to the extended key usages.
+	ApplicationPolicies []asn1.ObjectIdentifier
+}
+
+// ParseMSExtensions decodes the Microsoft specific extensions of cert. The
+// fields of extensions cert does not carry are left empty.
+func ParseMSExtensions(cert *x509.Certificate) (*MSExtensions, error) {
+	if err := checkCert("ParseMSExtensions", "cert", cert); err != nil {
+		return nil, err
+	}
+	tmpl, err := certTemplate(cert)
+	if err != nil {
+		return nil, err
+	}
+	ext := &MSExtensions{Template: tmpl}
+	for _, e := range cert.Extensions {
+		switch {
+		case e.Id.Equal(oidNTDSCASecurityExt):
+			if ext.ObjectSID, err = decodeNTDSSecurity(e.Value); err != nil {
+				return nil, fmt.Errorf("could not decode NTDS CA security extension: %v", err)
+			}
+		case e.Id.Equal(oidApplicationCertPolicies):
+			if ext.ApplicationPolicies, err = decodeApplicationPolicies(e.Value); err != nil {
+				return nil, fmt.Errorf("could not decode application policies extension: %v", err)
+			}
+		}
+	}
+	return ext, nil
+}
+
+// ntdsOtherName is the OtherName of szOID_NTDS_CA_SECURITY_EXT.
+type ntdsOtherName struct {
+	ID    asn1.ObjectIdentifier
+	Value []byte `asn1:"explicit,tag:0"`
+}
+
+// decodeNTDSSecurity returns the SID of a szOID_NTDS_CA_SECURITY_EXT value,
+// a sequence of [0] OtherName, or "" if it has no szOID_NTDS_OBJECTSID.
+func decodeNTDSSecurity(der []byte) (string, error) {
+	var names []asn1.RawValue
+	if rest, err := asn1.Unmarshal(der, &names); err != nil {
+		return "", err
+	} else if len(rest) > 0 {
+		return "", fmt.Errorf("%d trailing bytes", len(rest))
+	}
+	for _, n := range names {
+		if n.Class != asn1.ClassContextSpecific || n.Tag != 0 {
+			continue
+		}
+		var on ntdsOtherName
+		if _, err := asn1.UnmarshalWithParams(n.FullBytes, &on, "tag:0"); err != nil {
+			return "", err
+		}
+		if on.ID.Equal(oidNTDSObjectSID) {
+			return string(on.Value), nil
+		}
+	}
+	return "", nil
+}
+
+// decodeApplicationPolicies returns the policy identifiers of a
+// szOID_APPLICATION_CERT_POLICIES value, which has the syntax of the
+// certificate policies extension.
+func decodeApplicationPolicies(der []byte) ([]asn1.ObjectIdentifier, error) {
+	var policies []struct {
+		ID         asn1.ObjectIdentifier
+		Qualifiers asn1.RawValue `asn1:"optional"`
+	}
+	if rest, err := asn1.Unmarshal(der, &policies); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, fmt.Errorf("%d trailing bytes", len(rest))
+	}
+	ids := make([]asn1.ObjectIdentifier, len(policies))
+	for i, p := range policies {
+		ids[i] = p.ID
+	}
+	return ids, nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseMSExtensions(t *testing.T) {
+	const sid = "S-1-5-21-1004336348-1177238915-682003330-512"
+	otherName, err := asn1.MarshalWithParams(ntdsOtherName{ID: oidNTDSObjectSID, Value: []byte(sid)}, "tag:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	security, err := asn1.Marshal([]asn1.RawValue{{FullBytes: otherName}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientAuth := asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 2}
+	smartcardLogon := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 2}
+	policies, err := asn1.Marshal([]struct{ ID asn1.ObjectIdentifier }{{clientAuth}, {smartcardLogon}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := selfSigned(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "extensions test"},
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{
+			{Id: oidNTDSCASecurityExt, Value: security},
+			{Id: oidApplicationCertPolicies, Value: policies},
+		},
+	})
+	ext, err := ParseMSExtensions(cert)
+	if err != nil {
+		t.Fatalf("ParseMSExtensions returned %v", err)
+	}
+	if ext.ObjectSID != sid {
+		t.Errorf("unexpected SID got: %q, want: %q", ext.ObjectSID, sid)
+	}
+	if want := []asn1.ObjectIdentifier{clientAuth, smartcardLogon}; !reflect.DeepEqual(ext.ApplicationPolicies, want) {
+		t.Errorf("unexpected application policies got: %v, want: %v", ext.ApplicationPolicies, want)
+	}
+	if ext.Template != nil {
+		t.Errorf("expected no template, got: %+v", ext.Template)
+	}
+
+	info, err := newCertInfo(cert)
+	if err != nil {
+		t.Fatalf("newCertInfo returned %v", err)
+	}
+	if info.ObjectSID != sid || len(info.ApplicationPolicies) != 2 {
+		t.Errorf("newCertInfo did not surface the extensions, got SID %q and policies %v", info.ObjectSID, info.ApplicationPolicies)
+	}
+
+	bad := selfSigned(t, &x509.Certificate{
+		Subject:         pkix.Name{CommonName: "bad extension test"},
+		NotBefore:       time.Now(),
+		NotAfter:        time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{{Id: oidNTDSCASecurityExt, Value: []byte{0x04, 0x01, 0x00}}},
+	})
+	if _, err := ParseMSExtensions(bad); err == nil {
+		t.Error("ParseMSExtensions succeeded for a malformed security extension")
+	}
+}
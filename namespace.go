@@ -0,0 +1,61 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NamespaceSeparator separates the namespace from the name of key
+// containers and friendly names, see WinCertStoreOptions.Namespace.
+const NamespaceSeparator = "/"
+
+// maxNamespaceLen is the maximum length of a namespace.
+const maxNamespaceLen = 64
+
+// validateNamespace returns an error unless ns is empty or consists of
+// letters, digits, '.', '_' and '-'.
+func validateNamespace(ns string) error {
+	if len(ns) > maxNamespaceLen {
+		return fmt.Errorf("namespace %q is longer than %d characters", ns, maxNamespaceLen)
+	}
+	for _, c := range ns {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '_', c == '-':
+		default:
+			return fmt.Errorf("namespace %q contains the invalid character %q", ns, c)
+		}
+	}
+	return nil
+}
+
+// namespacedName returns name in namespace ns. Names are not changed if ns is
+// empty.
+func namespacedName(ns, name string) string {
+	if ns == "" {
+		return name
+	}
+	return ns + NamespaceSeparator + name
+}
+
+// inNamespace reports whether name, a key container or friendly name, belongs
+// to namespace ns. All names belong to the empty namespace.
+func inNamespace(ns, name string) bool {
+	if ns == "" {
+		return true
+	}
+	return strings.HasPrefix(name, ns+NamespaceSeparator)
+}
@@ -0,0 +1,56 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateNamespace(t *testing.T) {
+	for _, ns := range []string{"", "tenant-a", "Workload_1.prod"} {
+		if err := validateNamespace(ns); err != nil {
+			t.Errorf("validateNamespace(%q) returned %v", ns, err)
+		}
+	}
+	for _, ns := range []string{"a/b", "a b", "tenant\x00", strings.Repeat("x", maxNamespaceLen+1)} {
+		if err := validateNamespace(ns); err == nil {
+			t.Errorf("validateNamespace(%q) succeeded, want error", ns)
+		}
+	}
+}
+
+func TestNamespacedName(t *testing.T) {
+	tests := []struct {
+		ns, name, want string
+	}{
+		{"", "container", "container"},
+		{"tenant-a", "container", "tenant-a/container"},
+	}
+	for _, tt := range tests {
+		got := namespacedName(tt.ns, tt.name)
+		if got != tt.want {
+			t.Errorf("namespacedName(%q, %q) = %q, want %q", tt.ns, tt.name, got, tt.want)
+		}
+		if !inNamespace(tt.ns, got) {
+			t.Errorf("inNamespace(%q, %q) = false, want true", tt.ns, got)
+		}
+	}
+	for _, name := range []string{"container", "tenant-b/container", "tenant-ab/container", "tenant-a"} {
+		if inNamespace("tenant-a", name) {
+			t.Errorf("inNamespace(%q, %q) = true, want false", "tenant-a", name)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// certInNamespace reports whether the key of the certificate in certContext
+// belongs to the namespace of w. Certificates without a key belong to no
+// namespace.
+func (w *WinCertStore) certInNamespace(certContext *windows.CertContext) bool {
+	if w.namespace == "" {
+		return true
+	}
+	ki, err := keyProvInfo(certContext)
+	if err != nil || ki == nil {
+		return false
+	}
+	return inNamespace(w.namespace, ki.Container)
+}
+
+// SetFriendlyName sets the friendly name of cert in the local machine MY
+// store to name, prefixed with the namespace of w, so that it can be found
+// with CertByFriendlyName. The key of cert must belong to the namespace.
+func (w *WinCertStore) SetFriendlyName(cert *x509.Certificate, name string) error {
+	if err := checkCert("SetFriendlyName", "cert", cert); err != nil {
+		return err
+	}
+	props, err := OpenCertProperties(StoreLocation{Location: LocationLocalMachine, Name: "MY"}, cert)
+	if err != nil {
+		return err
+	}
+	defer props.Close()
+
+	if !w.certInNamespace(props.certContext) {
+		return fmt.Errorf("key of certificate %s is not in namespace %q", thumbprint(cert), w.namespace)
+	}
+	if name == "" {
+		return props.SetFriendlyName("")
+	}
+	return props.SetFriendlyName(namespacedName(w.namespace, name))
+}
@@ -0,0 +1,114 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// certStoreProvPhysical is CERT_STORE_PROV_PHYSICAL_W.
+const certStoreProvPhysical = 14
+
+// CertOrigin describes where a certificate of a local machine store is
+// delivered from.
+type CertOrigin struct {
+	Certificate *x509.Certificate
+	// Local is set if the certificate was installed on the machine itself.
+	Local bool
+	// GroupPolicy is set if the certificate is delivered by Group Policy.
+	GroupPolicy bool
+	// Enterprise is set if the certificate is replicated from Active Directory.
+	Enterprise bool
+}
+
+// CertOrigins returns the certificates of the local machine store name, such
+// as ROOT, together with where they come from, so that locally installed
+// certificates can be told apart from policy delivered ones. A certificate
+// can have more than one origin.
+func CertOrigins(name string) ([]CertOrigin, error) {
+	var origins []CertOrigin
+	index := make(map[string]int)
+	add := func(certs []*x509.Certificate, set func(*CertOrigin)) {
+		for _, cert := range certs {
+			tp := thumbprint(cert)
+			i, ok := index[tp]
+			if !ok {
+				i = len(origins)
+				index[tp] = i
+				origins = append(origins, CertOrigin{Certificate: cert})
+			}
+			set(&origins[i])
+		}
+	}
+
+	// The logical local machine store also shows the policy delivered
+	// certificates, the locally installed ones are in its .Default physical
+	// store.
+	local, err := physicalStoreCerts(name + `\.Default`)
+	if err != nil {
+		return nil, err
+	}
+	add(local, func(o *CertOrigin) { o.Local = true })
+
+	for _, loc := range []SystemLocation{LocationLocalMachineGroupPolicy, LocationLocalMachineEnterprise} {
+		sl := StoreLocation{Location: loc, Name: name}
+		certStore, err := openStore(sl, certStoreOpenExisting|certStoreReadOnly)
+		if errno, ok := err.(syscall.Errno); ok && errno == windows.ERROR_FILE_NOT_FOUND {
+			// Policy stores only exist if a policy delivered certificates.
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("CertOpenStore for %s returned %v", sl, err)
+		}
+		certs, err := storeCerts(certStore, sl.String())
+		windows.CertCloseStore(certStore, 0)
+		if err != nil {
+			return nil, err
+		}
+		if loc == LocationLocalMachineGroupPolicy {
+			add(certs, func(o *CertOrigin) { o.GroupPolicy = true })
+		} else {
+			add(certs, func(o *CertOrigin) { o.Enterprise = true })
+		}
+	}
+	return origins, nil
+}
+
+// physicalStoreCerts returns the certificates of the local machine physical
+// store path, such as ROOT\.Default.
+func physicalStoreCerts(path string) ([]*x509.Certificate, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	certStore, err := windows.CertOpenStore(
+		certStoreProvPhysical,
+		0,
+		0,
+		certStoreLocalMachine|certStoreOpenExisting|certStoreReadOnly,
+		uintptr(unsafe.Pointer(p)))
+	if err != nil {
+		return nil, fmt.Errorf("CertOpenStore for %s returned %v", path, err)
+	}
+	defer windows.CertCloseStore(certStore, 0)
+	return storeCerts(certStore, `LocalMachine\`+path)
+}
@@ -0,0 +1,125 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+)
+
+// PeerPolicy configures the client certificates accepted by the callback of
+// VerifyPeerCertificate.
+type PeerPolicy struct {
+	// Issuers, if set, restricts the accepted certificates to those whose
+	// issuer matches one of them, as matched by IssuerMatch.
+	Issuers     []string
+	IssuerMatch IssuerMatch
+	// Templates, if set, restricts the accepted certificates to those issued
+	// from one of the Microsoft certificate templates, given by name or by
+	// dotted OID.
+	Templates []string
+	// CheckRevocation checks the revocation status of the chain, except for
+	// the root. AllowUnknownRevocation accepts certificates whose status
+	// cannot be determined, such as when the CRL cannot be downloaded.
+	CheckRevocation        bool
+	AllowUnknownRevocation bool
+}
+
+func (p PeerPolicy) validate() error {
+	return validateIssuers(p.IssuerMatch, p.Issuers)
+}
+
+// PeerVerificationError is returned by the callback of VerifyPeerCertificate
+// if a peer certificate is rejected.
+type PeerVerificationError struct {
+	// Thumbprint identifies the peer certificate, it is empty if the
+	// certificate could not be parsed.
+	Thumbprint string
+	Reason     string
+	Err        error
+}
+
+func (e *PeerVerificationError) Error() string {
+	msg := "peer certificate " + e.Thumbprint + " rejected: " + e.Reason
+	if e.Thumbprint == "" {
+		msg = "peer certificate rejected: " + e.Reason
+	}
+	if e.Err != nil {
+		msg += fmt.Sprintf(": %v", e.Err)
+	}
+	return msg
+}
+
+// parsePeerCertificates parses the certificates presented by a peer, leaf
+// first.
+func parsePeerCertificates(rawCerts [][]byte) ([]*x509.Certificate, error) {
+	if len(rawCerts) == 0 {
+		return nil, &PeerVerificationError{Reason: "no certificate presented"}
+	}
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		c, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return nil, &PeerVerificationError{Reason: fmt.Sprintf("certificate %d could not be parsed", i), Err: err}
+		}
+		certs[i] = c
+	}
+	return certs, nil
+}
+
+// checkLeaf checks the issuer and template of the peer certificate cert
+// against p. The chain is verified separately.
+func (p PeerPolicy) checkLeaf(cert *x509.Certificate) error {
+	if len(p.Issuers) > 0 {
+		ok, err := p.matchIssuer(cert.Issuer)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return &PeerVerificationError{Thumbprint: thumbprint(cert), Reason: fmt.Sprintf("issuer %q is not accepted", cert.Issuer)}
+		}
+	}
+	if len(p.Templates) > 0 {
+		var ok bool
+		for _, t := range p.Templates {
+			ok = ok || hasTemplate(cert, t)
+		}
+		if !ok {
+			return &PeerVerificationError{Thumbprint: thumbprint(cert), Reason: "not issued from an accepted template"}
+		}
+	}
+	return nil
+}
+
+// matchIssuer reports whether issuer matches one of p.Issuers.
+func (p PeerPolicy) matchIssuer(issuer pkix.Name) (bool, error) {
+	for _, want := range p.Issuers {
+		if p.IssuerMatch == IssuerSubstring {
+			if containsFold(issuer.String(), want) {
+				return true, nil
+			}
+			continue
+		}
+		re, err := issuerPattern(p.IssuerMatch, want)
+		if err != nil {
+			return false, err
+		}
+		if matchIssuer(re, issuer) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
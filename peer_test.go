@@ -0,0 +1,60 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+)
+
+func TestPeerPolicyCheckLeaf(t *testing.T) {
+	machine := []pkix.Extension{{Id: oidCertTypeExtension, Value: []byte{0x1e, 0x0e, 0, 'M', 0, 'a', 0, 'c', 0, 'h', 0, 'i', 0, 'n', 0, 'e'}}}
+	cert := &x509.Certificate{
+		Raw:        []byte("cert"),
+		Issuer:     pkix.Name{CommonName: "Corp Issuing CA 2"},
+		Extensions: machine,
+	}
+	tests := []struct {
+		desc   string
+		policy PeerPolicy
+		ok     bool
+	}{
+		{"no restrictions", PeerPolicy{}, true},
+		{"issuer substring", PeerPolicy{Issuers: []string{"issuing ca"}}, true},
+		{"issuer wildcard", PeerPolicy{Issuers: []string{"Corp Issuing CA *"}, IssuerMatch: IssuerWildcard}, true},
+		{"other issuer", PeerPolicy{Issuers: []string{"Other CA"}}, false},
+		{"template", PeerPolicy{Templates: []string{"User", "machine"}}, true},
+		{"other template", PeerPolicy{Templates: []string{"User"}}, false},
+	}
+	for _, tt := range tests {
+		err := tt.policy.checkLeaf(cert)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: checkLeaf returned %v, want ok: %t", tt.desc, err, tt.ok)
+		}
+		if err != nil {
+			if _, ok := err.(*PeerVerificationError); !ok {
+				t.Errorf("%s: checkLeaf returned %T, want *PeerVerificationError", tt.desc, err)
+			}
+		}
+	}
+
+	if _, err := parsePeerCertificates(nil); err == nil {
+		t.Error("parsePeerCertificates succeeded without certificates, want error")
+	}
+	if err := (PeerPolicy{Issuers: []string{"("}, IssuerMatch: IssuerRegexp}).validate(); err == nil {
+		t.Error("validate succeeded with an invalid issuer pattern, want error")
+	}
+}
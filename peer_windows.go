@@ -0,0 +1,127 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	certChainRevocationCheckChainExcludeRoot = 0x40000000 // CERT_CHAIN_REVOCATION_CHECK_CHAIN_EXCLUDE_ROOT
+	certChainPolicyIgnoreAllRevUnknownFlags  = 0x00000F00 // CERT_CHAIN_POLICY_IGNORE_ALL_REV_UNKNOWN_FLAGS
+)
+
+// oidClientAuth is szOID_PKIX_KP_CLIENT_AUTH, the usage requested from the
+// chain engine for peer certificates.
+var oidClientAuth = append([]byte("1.3.6.1.5.5.7.3.2"), 0)
+
+// VerifyPeerCertificate returns a callback for tls.Config.VerifyPeerCertificate
+// that accepts client certificates matching policy whose chain the Windows
+// chain engine trusts for client authentication. The callback does its own
+// chain building, so the server should set tls.Config.ClientAuth to
+// tls.RequireAnyClientCert, leaving verifiedChains empty.
+func VerifyPeerCertificate(policy PeerPolicy) (func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error, error) {
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		certs, err := parsePeerCertificates(rawCerts)
+		if err != nil {
+			return err
+		}
+		if err := policy.checkLeaf(certs[0]); err != nil {
+			logInfo("Rejected peer certificate.", opField("verifypeer"), thumbprintField(thumbprint(certs[0])), errField(err))
+			return err
+		}
+		if err := verifyPeerChain(rawCerts, policy); err != nil {
+			err = &PeerVerificationError{Thumbprint: thumbprint(certs[0]), Reason: "chain verification failed", Err: err}
+			logInfo("Rejected peer certificate.", opField("verifypeer"), thumbprintField(thumbprint(certs[0])), errField(err))
+			return err
+		}
+		return nil
+	}, nil
+}
+
+// verifyPeerChain builds the chain of rawCerts[0] with the other certificates
+// as additional intermediates and checks it with the SSL client policy.
+func verifyPeerChain(rawCerts [][]byte, policy PeerPolicy) error {
+	memStore, err := windows.CertOpenStore(windows.CERT_STORE_PROV_MEMORY, 0, 0, windows.CERT_STORE_DEFER_CLOSE_UNTIL_LAST_FREE_FLAG, 0)
+	if err != nil {
+		return fmt.Errorf("CertOpenStore returned %v", err)
+	}
+	defer windows.CertCloseStore(memStore, 0)
+
+	var leaf *windows.CertContext
+	for i, raw := range rawCerts {
+		ctx, err := windows.CertCreateCertificateContext(encodingX509ASN|encodingPKCS7, &raw[0], uint32(len(raw)))
+		if err != nil {
+			return fmt.Errorf("CertCreateCertificateContext returned %v", err)
+		}
+		var added *windows.CertContext
+		err = windows.CertAddCertificateContextToStore(memStore, ctx, windows.CERT_STORE_ADD_ALWAYS, &added)
+		windows.CertFreeCertificateContext(ctx)
+		if err != nil {
+			return fmt.Errorf("CertAddCertificateContextToStore returned %v", err)
+		}
+		if i == 0 {
+			leaf = added
+		} else {
+			windows.CertFreeCertificateContext(added)
+		}
+	}
+	defer windows.CertFreeCertificateContext(leaf)
+
+	usage := &oidClientAuth[0]
+	para := windows.CertChainPara{
+		RequestedUsage: windows.CertUsageMatch{
+			Type:  windows.USAGE_MATCH_TYPE_AND,
+			Usage: windows.CertEnhKeyUsage{Length: 1, UsageIdentifiers: &usage},
+		},
+	}
+	para.Size = uint32(unsafe.Sizeof(para))
+	var flags uint32
+	if policy.CheckRevocation {
+		flags |= certChainRevocationCheckChainExcludeRoot
+	}
+	var chainContext *windows.CertChainContext
+	if err := windows.CertGetCertificateChain(hcceLocalMachine, leaf, nil, leaf.Store, &para, flags, 0, &chainContext); err != nil {
+		return fmt.Errorf("CertGetCertificateChain returned %v", err)
+	}
+	defer windows.CertFreeCertificateChain(chainContext)
+
+	ssl := windows.SSLExtraCertChainPolicyPara{AuthType: windows.AUTHTYPE_CLIENT}
+	ssl.Size = uint32(unsafe.Sizeof(ssl))
+	policyPara := windows.CertChainPolicyPara{ExtraPolicyPara: (windows.Pointer)(unsafe.Pointer(&ssl))}
+	policyPara.Size = uint32(unsafe.Sizeof(policyPara))
+	if !policy.CheckRevocation || policy.AllowUnknownRevocation {
+		policyPara.Flags = certChainPolicyIgnoreAllRevUnknownFlags
+	}
+	status := windows.CertChainPolicyStatus{}
+	status.Size = uint32(unsafe.Sizeof(status))
+	if err := windows.CertVerifyCertificateChainPolicy(windows.CERT_CHAIN_POLICY_SSL, chainContext, &policyPara, &status); err != nil {
+		return fmt.Errorf("CertVerifyCertificateChainPolicy returned %v", err)
+	}
+	if status.Error != 0 {
+		return syscall.Errno(status.Error)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrPinMismatch is returned by connections configured with Pins when no
+// certificate of the verified chain matches a pin.
+var ErrPinMismatch = errors.New("no certificate in the chain matches a pinned public key")
+
+// Pins configures certificate pinning for the HTTPS endpoints used to enroll
+// and renew certificates, so that a proxy with a publicly trusted certificate
+// cannot intercept the traffic.
+type Pins struct {
+	// SPKI are SHA-256 hashes of SubjectPublicKeyInfos, see SPKIPin and
+	// ParseSPKIPin. A connection is accepted if a certificate of a verified
+	// chain matches one of them.
+	SPKI [][]byte
+	// Roots, if set, replace the system roots for verifying the server.
+	Roots []*x509.Certificate
+}
+
+// SPKIPin returns the SHA-256 hash of the SubjectPublicKeyInfo of cert.
+func SPKIPin(cert *x509.Certificate) []byte {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return sum[:]
+}
+
+// ParseSPKIPin parses a pin in the "sha256/<base64>" form used by HPKP and
+// most pinning tools. The "sha256/" prefix is optional.
+func ParseSPKIPin(s string) ([]byte, error) {
+	pin, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, "sha256/"))
+	if err != nil {
+		return nil, fmt.Errorf("pin %q is not valid base64: %v", s, err)
+	}
+	if len(pin) != sha256.Size {
+		return nil, fmt.Errorf("pin %q has %d bytes, want %d", s, len(pin), sha256.Size)
+	}
+	return pin, nil
+}
+
+// TLSConfig returns a copy of base, which may be nil, that enforces p. The
+// normal verification of the server certificate still applies, so base must
+// not set InsecureSkipVerify.
+func (p Pins) TLSConfig(base *tls.Config) (*tls.Config, error) {
+	if len(p.SPKI) == 0 && len(p.Roots) == 0 {
+		return nil, errors.New("no pins or roots configured")
+	}
+	for _, pin := range p.SPKI {
+		if len(pin) != sha256.Size {
+			return nil, fmt.Errorf("pin has %d bytes, want %d", len(pin), sha256.Size)
+		}
+	}
+	cfg := &tls.Config{}
+	if base != nil {
+		if base.InsecureSkipVerify {
+			return nil, errors.New("pinning requires certificate verification, InsecureSkipVerify must not be set")
+		}
+		cfg = base.Clone()
+	}
+	if len(p.Roots) > 0 {
+		pool := x509.NewCertPool()
+		for _, root := range p.Roots {
+			pool.AddCert(root)
+		}
+		cfg.RootCAs = pool
+	}
+	if len(p.SPKI) > 0 {
+		next := cfg.VerifyPeerCertificate
+		cfg.VerifyPeerCertificate = func(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+			if err := p.verify(chains); err != nil {
+				return err
+			}
+			if next != nil {
+				return next(rawCerts, chains)
+			}
+			return nil
+		}
+	}
+	return cfg, nil
+}
+
+// verify returns ErrPinMismatch unless a certificate of chains matches one
+// of the SPKI pins.
+func (p Pins) verify(chains [][]*x509.Certificate) error {
+	for _, chain := range chains {
+		for _, cert := range chain {
+			got := SPKIPin(cert)
+			for _, pin := range p.SPKI {
+				if bytes.Equal(got, pin) {
+					return nil
+				}
+			}
+		}
+	}
+	return ErrPinMismatch
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"testing"
+
+	"github.com/google/certtostore/testdata"
+)
+
+func TestParseSPKIPin(t *testing.T) {
+	sum := sha256.Sum256([]byte("key"))
+	enc := base64.StdEncoding.EncodeToString(sum[:])
+	for _, s := range []string{"sha256/" + enc, enc} {
+		pin, err := ParseSPKIPin(s)
+		if err != nil {
+			t.Errorf("ParseSPKIPin(%q) returned %v", s, err)
+			continue
+		}
+		if string(pin) != string(sum[:]) {
+			t.Errorf("ParseSPKIPin(%q) = %x, want %x", s, pin, sum)
+		}
+	}
+	for _, s := range []string{"sha256/!!", "sha256/" + base64.StdEncoding.EncodeToString(sum[:16])} {
+		if _, err := ParseSPKIPin(s); err == nil {
+			t.Errorf("ParseSPKIPin(%q) succeeded, want error", s)
+		}
+	}
+}
+
+func TestPins(t *testing.T) {
+	xc, err := PEMToX509([]byte(testdata.CertPEM))
+	if err != nil {
+		t.Fatalf("error decoding test certificate: %v", err)
+	}
+	chains := [][]*x509.Certificate{{xc}}
+	other := sha256.Sum256([]byte("other"))
+
+	if err := (Pins{SPKI: [][]byte{other[:], SPKIPin(xc)}}).verify(chains); err != nil {
+		t.Errorf("verify with a matching pin returned %v", err)
+	}
+	if err := (Pins{SPKI: [][]byte{other[:]}}).verify(chains); err != ErrPinMismatch {
+		t.Errorf("verify without a matching pin returned %v, want %v", err, ErrPinMismatch)
+	}
+
+	cfg, err := Pins{SPKI: [][]byte{SPKIPin(xc)}}.TLSConfig(&tls.Config{ServerName: "example.com"})
+	if err != nil {
+		t.Fatalf("TLSConfig returned %v", err)
+	}
+	if cfg.ServerName != "example.com" || cfg.VerifyPeerCertificate == nil {
+		t.Error("TLSConfig did not keep the base config or install the pin check")
+	}
+	if err := cfg.VerifyPeerCertificate(nil, chains); err != nil {
+		t.Errorf("VerifyPeerCertificate returned %v", err)
+	}
+
+	cfg, err = Pins{Roots: []*x509.Certificate{xc}}.TLSConfig(nil)
+	if err != nil {
+		t.Fatalf("TLSConfig returned %v", err)
+	}
+	if cfg.RootCAs == nil || cfg.VerifyPeerCertificate != nil {
+		t.Error("TLSConfig with roots did not set only RootCAs")
+	}
+
+	if _, err := (Pins{}).TLSConfig(nil); err == nil {
+		t.Error("TLSConfig without pins succeeded, want error")
+	}
+	if _, err := (Pins{SPKI: [][]byte{other[:16]}}).TLSConfig(nil); err == nil {
+		t.Error("TLSConfig with a short pin succeeded, want error")
+	}
+	if _, err := (Pins{SPKI: [][]byte{other[:]}}).TLSConfig(&tls.Config{InsecureSkipVerify: true}); err == nil {
+		t.Error("TLSConfig with InsecureSkipVerify succeeded, want error")
+	}
+}
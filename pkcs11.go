@@ -0,0 +1,170 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+)
+
+// defaultPKCS11Label is the object label used when PKCS11Options.Label is
+// empty.
+const defaultPKCS11Label = "certtostore"
+
+var (
+	oidSHA224 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 4}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	oidCurveP256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	oidCurveP384 = asn1.ObjectIdentifier{1, 3, 132, 0, 34}
+	oidCurveP521 = asn1.ObjectIdentifier{1, 3, 132, 0, 35}
+)
+
+// PKCS11Options configures OpenPKCS11, which is only built on Linux with cgo
+// and the pkcs11 build tag.
+type PKCS11Options struct {
+	// Module is the path of the PKCS #11 module, such as
+	// /usr/lib/softhsm/libsofthsm2.so.
+	Module string
+	// TokenLabel selects the token that holds the keys and certificates.
+	TokenLabel string
+	// PIN is the user PIN of the token. Tokens that do not require a login
+	// may leave it empty. OpenPKCS11 wipes it once it has logged in.
+	PIN []byte
+	// Label is the CKA_LABEL of the managed key pair and certificate, and
+	// the prefix of the labels of the intermediate and of generated keys.
+	// Several agents can share a token with different labels. It defaults
+	// to "certtostore".
+	Label string
+}
+
+// validate returns an *ArgError for incomplete options.
+func (o PKCS11Options) validate() error {
+	if o.Module == "" {
+		return &ArgError{Op: "OpenPKCS11", Arg: "opts", Reason: "no module configured"}
+	}
+	if o.TokenLabel == "" {
+		return &ArgError{Op: "OpenPKCS11", Arg: "opts", Reason: "no token label configured"}
+	}
+	return nil
+}
+
+// labels returns the labels of the installed objects, of the intermediate
+// and of the key pair created by Generate until it is stored.
+func (o PKCS11Options) labels() (current, intermediate, pending string) {
+	l := o.Label
+	if l == "" {
+		l = defaultPKCS11Label
+	}
+	return l, l + "-intermediate", l + "-pending"
+}
+
+// pkcs11DigestInfo returns the DER encoded DigestInfo of digest, which a
+// token pads and signs for CKM_RSA_PKCS, see RFC 8017 section 9.2.
+func pkcs11DigestInfo(hash crypto.Hash, digest []byte) ([]byte, error) {
+	var oid asn1.ObjectIdentifier
+	switch hash {
+	case crypto.SHA1:
+		oid = oidSHA1
+	case crypto.SHA224:
+		oid = oidSHA224
+	case crypto.SHA256:
+		oid = oidSHA256
+	case crypto.SHA384:
+		oid = oidSHA384
+	case crypto.SHA512:
+		oid = oidSHA512
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm %v", hash)
+	}
+	return asn1.Marshal(struct {
+		Algorithm algorithmIdentifier
+		Digest    []byte
+	}{algorithmIdentifier{Algorithm: oid, Parameters: asn1.RawValue{Tag: asn1.TagNull}}, digest})
+}
+
+// pkcs11Curve returns the curve and its DER encoded CKA_EC_PARAMS for an
+// ECDSA algorithm name of AlgorithmGenerator.GenerateAlgorithm.
+func pkcs11Curve(alg string) (elliptic.Curve, []byte, error) {
+	var curve elliptic.Curve
+	var oid asn1.ObjectIdentifier
+	switch alg {
+	case "ECDSA_P256":
+		curve, oid = elliptic.P256(), oidCurveP256
+	case "ECDSA_P384":
+		curve, oid = elliptic.P384(), oidCurveP384
+	case "ECDSA_P521":
+		curve, oid = elliptic.P521(), oidCurveP521
+	default:
+		return nil, nil, fmt.Errorf("unsupported algorithm: %s", alg)
+	}
+	params, err := asn1.Marshal(oid)
+	if err != nil {
+		return nil, nil, err
+	}
+	return curve, params, nil
+}
+
+// pkcs11ECPublicKey parses the CKA_EC_PARAMS and CKA_EC_POINT attributes of
+// an EC public key object. The point is a DER OCTET STRING, but some modules
+// return the bare uncompressed point.
+func pkcs11ECPublicKey(params, point []byte) (*ecdsa.PublicKey, error) {
+	var oid asn1.ObjectIdentifier
+	if rest, err := asn1.Unmarshal(params, &oid); err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("could not parse EC parameters %x", params)
+	}
+	var curve elliptic.Curve
+	switch {
+	case oid.Equal(oidCurveP256):
+		curve = elliptic.P256()
+	case oid.Equal(oidCurveP384):
+		curve = elliptic.P384()
+	case oid.Equal(oidCurveP521):
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %v", oid)
+	}
+	// A bare point can also parse as an OCTET STRING, so the unwrapped value
+	// is only used if it is a point.
+	var x, y *big.Int
+	var raw []byte
+	if rest, err := asn1.Unmarshal(point, &raw); err == nil && len(rest) == 0 {
+		x, y = elliptic.Unmarshal(curve, raw)
+	}
+	if x == nil {
+		x, y = elliptic.Unmarshal(curve, point)
+	}
+	if x == nil {
+		return nil, fmt.Errorf("could not parse EC point %x", point)
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// ecdsaRawToASN1 converts an ECDSA signature in the r||s format returned by
+// CKM_ECDSA to the ASN.1 format expected from a crypto.Signer.
+func ecdsaRawToASN1(sig []byte) ([]byte, error) {
+	if len(sig) == 0 || len(sig)%2 != 0 {
+		return nil, fmt.Errorf("invalid ECDSA signature length %d", len(sig))
+	}
+	return asn1.Marshal(struct{ R, S *big.Int }{
+		new(big.Int).SetBytes(sig[:len(sig)/2]),
+		new(big.Int).SetBytes(sig[len(sig)/2:]),
+	})
+}
@@ -0,0 +1,677 @@
+// +build linux,cgo,pkcs11
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/miekg/pkcs11"
+)
+
+// PKCS11Store stores a key pair, its certificate and the intermediate on a
+// PKCS #11 token, such as SoftHSM, a YubiHSM or a TPM through tpm2-pkcs11.
+// Keys are generated on the token and cannot be exported. Its methods are
+// safe for concurrent use.
+type PKCS11Store struct {
+	ctx    *pkcs11.Ctx
+	module string
+	// current, intermediate and pending are the object labels, see
+	// PKCS11Options.labels.
+	current, intermediate, pending string
+
+	// mu serializes the use of session, PKCS #11 sessions are not safe for
+	// concurrent use. It is shared with the keys of the store.
+	mu      *sync.Mutex
+	session pkcs11.SessionHandle
+}
+
+var _ CertStorage = &PKCS11Store{}
+var _ AlgorithmGenerator = &PKCS11Store{}
+var _ ManagedStorage = &PKCS11Store{}
+
+// OpenPKCS11 loads the module of opts and opens a session with the token
+// labeled opts.TokenLabel. The store must be closed to release the session
+// and the module. opts.PIN is wiped before OpenPKCS11 returns.
+func OpenPKCS11(opts PKCS11Options) (*PKCS11Store, error) {
+	defer wipe(opts.PIN)
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	ctx, err := loadPKCS11Module(opts.Module)
+	if err != nil {
+		return nil, err
+	}
+	s := &PKCS11Store{ctx: ctx, module: opts.Module, mu: new(sync.Mutex)}
+	s.current, s.intermediate, s.pending = opts.labels()
+	slot, err := s.findSlot(opts.TokenLabel)
+	if err != nil {
+		releasePKCS11Module(s.module)
+		return nil, err
+	}
+	if s.session, err = ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION); err != nil {
+		releasePKCS11Module(s.module)
+		return nil, fmt.Errorf("C_OpenSession returned %v", err)
+	}
+	if len(opts.PIN) > 0 {
+		// The PIN is passed without copying it to a string that could not be
+		// wiped.
+		pin := unsafe.String(&opts.PIN[0], len(opts.PIN))
+		if err := ctx.Login(s.session, pkcs11.CKU_USER, pin); err != nil && !isPKCS11Error(err, pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+			s.Close()
+			return nil, fmt.Errorf("C_Login returned %v", err)
+		}
+	}
+	return s, nil
+}
+
+// pkcs11Module is a loaded PKCS #11 module. C_Finalize affects every user of
+// a module in the process, so the stores share one and the last to be closed
+// finalizes it.
+type pkcs11Module struct {
+	ctx  *pkcs11.Ctx
+	refs int
+	// external is set if other code of the process had initialized the
+	// module, which is then left to finalize it.
+	external bool
+}
+
+var (
+	pkcs11ModulesMu sync.Mutex
+	pkcs11Modules   = make(map[string]*pkcs11Module)
+)
+
+// loadPKCS11Module returns the module at path, loading and initializing it
+// unless a store already did.
+func loadPKCS11Module(path string) (*pkcs11.Ctx, error) {
+	pkcs11ModulesMu.Lock()
+	defer pkcs11ModulesMu.Unlock()
+	if m, ok := pkcs11Modules[path]; ok {
+		m.refs++
+		return m.ctx, nil
+	}
+	ctx := pkcs11.New(path)
+	if ctx == nil {
+		return nil, fmt.Errorf("could not load PKCS #11 module %s", path)
+	}
+	m := &pkcs11Module{ctx: ctx, refs: 1}
+	if err := ctx.Initialize(); err != nil {
+		if !isPKCS11Error(err, pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+			ctx.Destroy()
+			return nil, fmt.Errorf("C_Initialize returned %v", err)
+		}
+		m.external = true
+	}
+	pkcs11Modules[path] = m
+	return ctx, nil
+}
+
+// releasePKCS11Module releases a reference to the module at path, and
+// finalizes and unloads it with the last one.
+func releasePKCS11Module(path string) {
+	pkcs11ModulesMu.Lock()
+	defer pkcs11ModulesMu.Unlock()
+	m, ok := pkcs11Modules[path]
+	if !ok {
+		return
+	}
+	if m.refs--; m.refs > 0 {
+		return
+	}
+	delete(pkcs11Modules, path)
+	if !m.external {
+		m.ctx.Finalize()
+	}
+	m.ctx.Destroy()
+}
+
+// isPKCS11Error reports whether err is the PKCS #11 return value rv.
+func isPKCS11Error(err error, rv uint) bool {
+	var e pkcs11.Error
+	return errors.As(err, &e) && uint(e) == rv
+}
+
+// findSlot returns the slot holding the token labeled label.
+func (s *PKCS11Store) findSlot(label string) (uint, error) {
+	slots, err := s.ctx.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("C_GetSlotList returned %v", err)
+	}
+	for _, slot := range slots {
+		info, err := s.ctx.GetTokenInfo(slot)
+		if err != nil {
+			return 0, fmt.Errorf("C_GetTokenInfo(%d) returned %v", slot, err)
+		}
+		// Token labels are padded with spaces to 32 bytes.
+		if strings.TrimRight(info.Label, " \x00") == label {
+			return slot, nil
+		}
+	}
+	return 0, fmt.Errorf("no PKCS #11 token labeled %q", label)
+}
+
+// Close closes the session and releases the module, which is finalized and
+// unloaded once every store using it is closed. Keys returned by the store
+// cannot be used afterwards.
+func (s *PKCS11Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.ctx.CloseSession(s.session)
+	releasePKCS11Module(s.module)
+	if err != nil {
+		return fmt.Errorf("C_CloseSession returned %v", err)
+	}
+	return nil
+}
+
+// find returns the objects of class labeled label. s.mu must be held.
+func (s *PKCS11Store) find(class uint, label string) ([]pkcs11.ObjectHandle, error) {
+	tmpl := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+	if err := s.ctx.FindObjectsInit(s.session, tmpl); err != nil {
+		return nil, fmt.Errorf("C_FindObjectsInit returned %v", err)
+	}
+	var objs []pkcs11.ObjectHandle
+	for {
+		found, _, err := s.ctx.FindObjects(s.session, 16)
+		if err != nil {
+			s.ctx.FindObjectsFinal(s.session)
+			return nil, fmt.Errorf("C_FindObjects returned %v", err)
+		}
+		if len(found) == 0 {
+			break
+		}
+		objs = append(objs, found...)
+	}
+	if err := s.ctx.FindObjectsFinal(s.session); err != nil {
+		return nil, fmt.Errorf("C_FindObjectsFinal returned %v", err)
+	}
+	return objs, nil
+}
+
+// findOne returns the object of class labeled label, or 0 if there is none.
+// s.mu must be held.
+func (s *PKCS11Store) findOne(class uint, label string) (pkcs11.ObjectHandle, error) {
+	objs, err := s.find(class, label)
+	if err != nil {
+		return 0, err
+	}
+	switch len(objs) {
+	case 0:
+		return 0, nil
+	case 1:
+		return objs[0], nil
+	default:
+		return 0, fmt.Errorf("found %d objects labeled %q, want one", len(objs), label)
+	}
+}
+
+// attributes returns the values of the attributes types of obj. s.mu must
+// be held.
+func (s *PKCS11Store) attributes(obj pkcs11.ObjectHandle, types ...uint) ([][]byte, error) {
+	tmpl := make([]*pkcs11.Attribute, len(types))
+	for i, t := range types {
+		tmpl[i] = pkcs11.NewAttribute(t, nil)
+	}
+	attrs, err := s.ctx.GetAttributeValue(s.session, obj, tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("C_GetAttributeValue returned %v", err)
+	}
+	values := make([][]byte, len(attrs))
+	for i, a := range attrs {
+		values[i] = a.Value
+	}
+	return values, nil
+}
+
+// destroy destroys the objects of classes labeled label. s.mu must be held.
+func (s *PKCS11Store) destroy(label string, classes ...uint) error {
+	for _, class := range classes {
+		objs, err := s.find(class, label)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objs {
+			if err := s.ctx.DestroyObject(s.session, obj); err != nil {
+				return fmt.Errorf("C_DestroyObject returned %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// cert returns the certificate labeled label or nil if there is none.
+func (s *PKCS11Store) cert(label string) (*x509.Certificate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, err := s.findOne(pkcs11.CKO_CERTIFICATE, label)
+	if err != nil || obj == 0 {
+		return nil, err
+	}
+	v, err := s.attributes(obj, pkcs11.CKA_VALUE)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(v[0])
+	if err != nil {
+		return nil, fmt.Errorf("certificate %q is not recognized as a certificate: %v", label, err)
+	}
+	return cert, nil
+}
+
+// Cert returns the current certificate or nil if there is none.
+func (s *PKCS11Store) Cert() (*x509.Certificate, error) {
+	return s.cert(s.current)
+}
+
+// Intermediate returns the current intermediate certificate or nil if there
+// is none.
+func (s *PKCS11Store) Intermediate() (*x509.Certificate, error) {
+	return s.cert(s.intermediate)
+}
+
+// Generate creates a new RSA key of keySize bits, see GenerateAlgorithm.
+func (s *PKCS11Store) Generate(keySize int) (crypto.Signer, error) {
+	return s.GenerateAlgorithm(keySize, "RSA")
+}
+
+// GenerateAlgorithm creates a new key pair on the token and returns a signer
+// that can be used to make a CSR for the key. alg is "RSA", "ECDSA_P256",
+// "ECDSA_P384" or "ECDSA_P521", an empty alg generates an RSA key. The key
+// replaces the current one when Store is called, a key generated earlier and
+// not stored is destroyed.
+func (s *PKCS11Store) GenerateAlgorithm(keySize int, alg string) (crypto.Signer, error) {
+	var mech *pkcs11.Mechanism
+	pubTmpl := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, s.pending),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+	}
+	privTmpl := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, s.pending),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+	}
+	switch alg {
+	case "RSA", "":
+		if keySize <= 0 {
+			return nil, &ArgError{Op: "Generate", Arg: "keySize", Reason: fmt.Sprintf("invalid RSA key size %d", keySize)}
+		}
+		mech = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, nil)
+		pubTmpl = append(pubTmpl,
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, keySize),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, big.NewInt(defaultRSAExponent).Bytes()),
+			pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, true))
+		privTmpl = append(privTmpl, pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, true))
+	default:
+		_, params, err := pkcs11Curve(alg)
+		if err != nil {
+			return nil, err
+		}
+		mech = pkcs11.NewMechanism(pkcs11.CKM_EC_KEY_PAIR_GEN, nil)
+		pubTmpl = append(pubTmpl, pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, params))
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("could not generate key ID: %v", err)
+	}
+	pubTmpl = append(pubTmpl, pkcs11.NewAttribute(pkcs11.CKA_ID, id))
+	privTmpl = append(privTmpl, pkcs11.NewAttribute(pkcs11.CKA_ID, id))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.destroy(s.pending, pkcs11.CKO_PRIVATE_KEY, pkcs11.CKO_PUBLIC_KEY); err != nil {
+		return nil, err
+	}
+	pub, priv, err := s.ctx.GenerateKeyPair(s.session, []*pkcs11.Mechanism{mech}, pubTmpl, privTmpl)
+	if err != nil {
+		return nil, fmt.Errorf("C_GenerateKeyPair returned %v", err)
+	}
+	return s.key(priv, pub, s.pending)
+}
+
+// key returns the PKCS11Key for the key pair priv and pub. s.mu must be held.
+func (s *PKCS11Store) key(priv, pub pkcs11.ObjectHandle, label string) (*PKCS11Key, error) {
+	v, err := s.attributes(pub, pkcs11.CKA_KEY_TYPE)
+	if err != nil {
+		return nil, err
+	}
+	k := &PKCS11Key{store: s, handle: priv, Label: label}
+	// CK_ULONG attributes are in native byte order, like the values built by
+	// NewAttribute.
+	switch {
+	case bytes.Equal(v[0], pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA).Value):
+		v, err := s.attributes(pub, pkcs11.CKA_MODULUS, pkcs11.CKA_PUBLIC_EXPONENT)
+		if err != nil {
+			return nil, err
+		}
+		k.pub = &rsa.PublicKey{N: new(big.Int).SetBytes(v[0]), E: int(new(big.Int).SetBytes(v[1]).Int64())}
+	case bytes.Equal(v[0], pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC).Value):
+		v, err := s.attributes(pub, pkcs11.CKA_EC_PARAMS, pkcs11.CKA_EC_POINT)
+		if err != nil {
+			return nil, err
+		}
+		if k.pub, err = pkcs11ECPublicKey(v[0], v[1]); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PKCS #11 key type %x", v[0])
+	}
+	return k, nil
+}
+
+// Store installs cert and intermediate and replaces the current key with the
+// key created by the last Generate call, if any. cert must be issued for
+// that key.
+func (s *PKCS11Store) Store(cert *x509.Certificate, intermediate *x509.Certificate) error {
+	if err := checkCert("Store", "cert", cert); err != nil {
+		return err
+	}
+	if err := checkCert("Store", "intermediate", intermediate); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keyLabel := s.pending
+	priv, err := s.findOne(pkcs11.CKO_PRIVATE_KEY, s.pending)
+	if err != nil {
+		return err
+	}
+	if priv == 0 {
+		keyLabel = s.current
+		if priv, err = s.findOne(pkcs11.CKO_PRIVATE_KEY, s.current); err != nil {
+			return err
+		}
+		if priv == 0 {
+			return errors.New("no key to store the certificate for, call Generate first")
+		}
+	}
+	pub, err := s.findOne(pkcs11.CKO_PUBLIC_KEY, keyLabel)
+	if err != nil {
+		return err
+	}
+	if pub == 0 {
+		return fmt.Errorf("key %q has no public key object", keyLabel)
+	}
+	k, err := s.key(priv, pub, keyLabel)
+	if err != nil {
+		return err
+	}
+	if !k.pub.(interface{ Equal(crypto.PublicKey) bool }).Equal(cert.PublicKey) {
+		return fmt.Errorf("certificate %q is not issued for key %q", cert.Subject, keyLabel)
+	}
+	v, err := s.attributes(priv, pkcs11.CKA_ID)
+	if err != nil {
+		return err
+	}
+	id := v[0]
+
+	// Remove the certificates first, so that a failure leaves the new key
+	// pending instead of pairing the old certificate with it.
+	for _, label := range []string{s.current, s.intermediate} {
+		if err := s.destroy(label, pkcs11.CKO_CERTIFICATE); err != nil {
+			return err
+		}
+	}
+	if keyLabel == s.pending {
+		if err := s.destroy(s.current, pkcs11.CKO_PRIVATE_KEY, pkcs11.CKO_PUBLIC_KEY); err != nil {
+			return err
+		}
+		for _, obj := range []pkcs11.ObjectHandle{priv, pub} {
+			if err := s.ctx.SetAttributeValue(s.session, obj, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_LABEL, s.current)}); err != nil {
+				return fmt.Errorf("C_SetAttributeValue returned %v", err)
+			}
+		}
+	}
+	if err := s.createCert(cert, s.current, id); err != nil {
+		return err
+	}
+	return s.createCert(intermediate, s.intermediate, nil)
+}
+
+// createCert creates a certificate object for cert labeled label. id links
+// the certificate to its key and may be nil. s.mu must be held.
+func (s *PKCS11Store) createCert(cert *x509.Certificate, label string, id []byte) error {
+	tmpl := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_CERTIFICATE),
+		pkcs11.NewAttribute(pkcs11.CKA_CERTIFICATE_TYPE, pkcs11.CKC_X_509),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_SUBJECT, cert.RawSubject),
+		pkcs11.NewAttribute(pkcs11.CKA_ISSUER, cert.RawIssuer),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE, cert.Raw),
+	}
+	serial, err := asn1.Marshal(cert.SerialNumber)
+	if err != nil {
+		return fmt.Errorf("could not marshal serial number: %v", err)
+	}
+	tmpl = append(tmpl, pkcs11.NewAttribute(pkcs11.CKA_SERIAL_NUMBER, serial))
+	if id != nil {
+		tmpl = append(tmpl, pkcs11.NewAttribute(pkcs11.CKA_ID, id))
+	}
+	if _, err := s.ctx.CreateObject(s.session, tmpl); err != nil {
+		return fmt.Errorf("C_CreateObject returned %v", err)
+	}
+	return nil
+}
+
+// Signer returns the current key or nil if there is none. The key returned by
+// Generate is only installed by Store.
+func (s *PKCS11Store) Signer() (crypto.Signer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	priv, err := s.findOne(pkcs11.CKO_PRIVATE_KEY, s.current)
+	if err != nil || priv == 0 {
+		return nil, err
+	}
+	pub, err := s.findOne(pkcs11.CKO_PUBLIC_KEY, s.current)
+	if err != nil {
+		return nil, err
+	}
+	if pub == 0 {
+		return nil, fmt.Errorf("key %q has no public key object", s.current)
+	}
+	return s.key(priv, pub, s.current)
+}
+
+// Remove destroys the current certificate, intermediate and key pair. A token
+// has no system wide location, so removeSystem is ignored.
+func (s *PKCS11Store) Remove(removeSystem bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.destroy(s.current, pkcs11.CKO_CERTIFICATE, pkcs11.CKO_PRIVATE_KEY, pkcs11.CKO_PUBLIC_KEY); err != nil {
+		return err
+	}
+	return s.destroy(s.intermediate, pkcs11.CKO_CERTIFICATE)
+}
+
+// Link does nothing, the objects on a token are available to every user that
+// can log in to it.
+func (s *PKCS11Store) Link() error {
+	return nil
+}
+
+// PKCS11Key is a key pair on a PKCS #11 token. It implements crypto.Signer
+// and, for RSA keys, crypto.Decrypter. ECDSA signatures are ASN.1 encoded
+// like those of crypto/ecdsa.
+type PKCS11Key struct {
+	store  *PKCS11Store
+	handle pkcs11.ObjectHandle
+	pub    crypto.PublicKey
+	// Label is the CKA_LABEL of the key when it was opened.
+	Label string
+}
+
+var (
+	_ crypto.Signer    = &PKCS11Key{}
+	_ crypto.Decrypter = &PKCS11Key{}
+	_ CryptoKey        = &PKCS11Key{}
+)
+
+// Public returns the public key to implement crypto.Signer.
+func (k *PKCS11Key) Public() crypto.PublicKey {
+	return k.pub
+}
+
+// PublicDER returns the DER encoded SubjectPublicKeyInfo of the key.
+func (k *PKCS11Key) PublicDER() ([]byte, error) {
+	return publicDER(k.pub)
+}
+
+// PublicPEM returns the SubjectPublicKeyInfo of the key as a PEM block.
+func (k *PKCS11Key) PublicPEM() ([]byte, error) {
+	return publicPEM(k.pub)
+}
+
+// pkcs11Hash returns the mechanism and MGF1 function of hash.
+func pkcs11Hash(hash crypto.Hash) (uint, uint, error) {
+	switch hash {
+	case crypto.SHA1:
+		return pkcs11.CKM_SHA_1, pkcs11.CKG_MGF1_SHA1, nil
+	case crypto.SHA224:
+		return pkcs11.CKM_SHA224, pkcs11.CKG_MGF1_SHA224, nil
+	case crypto.SHA256:
+		return pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256, nil
+	case crypto.SHA384:
+		return pkcs11.CKM_SHA384, pkcs11.CKG_MGF1_SHA384, nil
+	case crypto.SHA512:
+		return pkcs11.CKM_SHA512, pkcs11.CKG_MGF1_SHA512, nil
+	}
+	return 0, 0, fmt.Errorf("unsupported hash algorithm %v", hash)
+}
+
+// Sign signs digest with the key to implement crypto.Signer. If opts is a
+// *rsa.PSSOptions RSA signatures use PSS padding, otherwise PKCS #1 v1.5.
+func (k *PKCS11Key) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var hf crypto.Hash
+	if opts != nil {
+		hf = opts.HashFunc()
+	}
+	if err := checkDigest("Sign", digest, hf); err != nil {
+		return nil, err
+	}
+	switch pub := k.pub.(type) {
+	case *rsa.PublicKey:
+		if opts == nil {
+			return nil, &ArgError{Op: "Sign", Arg: "opts", Reason: "opts is nil"}
+		}
+		if pssOpts, ok := opts.(*rsa.PSSOptions); ok {
+			saltLen, err := pssSaltLength(pub, digest, pssOpts)
+			if err != nil {
+				return nil, err
+			}
+			hashMech, mgf, err := pkcs11Hash(hf)
+			if err != nil {
+				return nil, err
+			}
+			return k.sign(pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_PSS, pkcs11.NewPSSParams(hashMech, mgf, uint(saltLen))), digest)
+		}
+		info, err := pkcs11DigestInfo(hf, digest)
+		if err != nil {
+			return nil, err
+		}
+		return k.sign(pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil), info)
+	case *ecdsa.PublicKey:
+		sig, err := k.sign(pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil), digest)
+		if err != nil {
+			return nil, err
+		}
+		return ecdsaRawToASN1(sig)
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", k.pub)
+}
+
+// sign signs data with mech.
+func (k *PKCS11Key) sign(mech *pkcs11.Mechanism, data []byte) ([]byte, error) {
+	k.store.mu.Lock()
+	defer k.store.mu.Unlock()
+	if err := k.store.ctx.SignInit(k.store.session, []*pkcs11.Mechanism{mech}, k.handle); err != nil {
+		return nil, fmt.Errorf("C_SignInit returned %v", err)
+	}
+	sig, err := k.store.ctx.Sign(k.store.session, data)
+	if err != nil {
+		return nil, fmt.Errorf("C_Sign returned %v", err)
+	}
+	return sig, nil
+}
+
+// SignMessage hashes the message read from r with hash and signs the digest.
+func (k *PKCS11Key) SignMessage(r io.Reader, hash crypto.Hash) ([]byte, error) {
+	return SignMessage(k, r, hash)
+}
+
+// Decrypt decrypts blob with an RSA key to implement crypto.Decrypter. opts
+// is a *rsa.OAEPOptions for OAEP padding, or nil or a
+// *rsa.PKCS1v15DecryptOptions for PKCS #1 v1.5 padding. ECDSA keys return
+// ErrNotSupported.
+func (k *PKCS11Key) Decrypt(rand io.Reader, blob []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	if _, ok := k.pub.(*rsa.PublicKey); !ok {
+		return nil, ErrNotSupported
+	}
+	if err := checkNotEmpty("Decrypt", "blob", blob); err != nil {
+		return nil, err
+	}
+	var mech *pkcs11.Mechanism
+	switch opts := opts.(type) {
+	case nil, *rsa.PKCS1v15DecryptOptions:
+		mech = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)
+	case *rsa.OAEPOptions:
+		if opts.MGFHash != 0 && opts.MGFHash != opts.Hash {
+			return nil, fmt.Errorf("MGF1 hash %v differs from the OAEP hash %v", opts.MGFHash, opts.Hash)
+		}
+		hashMech, mgf, err := pkcs11Hash(opts.Hash)
+		if err != nil {
+			return nil, err
+		}
+		source := uint(0)
+		if len(opts.Label) > 0 {
+			source = pkcs11.CKZ_DATA_SPECIFIED
+		}
+		mech = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_OAEP, pkcs11.NewOAEPParams(hashMech, mgf, source, opts.Label))
+	default:
+		return nil, fmt.Errorf("unsupported decrypter options %T", opts)
+	}
+
+	k.store.mu.Lock()
+	defer k.store.mu.Unlock()
+	if err := k.store.ctx.DecryptInit(k.store.session, []*pkcs11.Mechanism{mech}, k.handle); err != nil {
+		return nil, fmt.Errorf("C_DecryptInit returned %v", err)
+	}
+	plain, err := k.store.ctx.Decrypt(k.store.session, blob)
+	if err != nil {
+		return nil, fmt.Errorf("C_Decrypt returned %v", err)
+	}
+	return plain, nil
+}
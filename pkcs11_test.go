@@ -0,0 +1,168 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/hex"
+	"testing"
+
+	"github.com/google/certtostore/testdata/helpers"
+)
+
+func TestPKCS11DigestInfo(t *testing.T) {
+	digest := sha256.Sum256([]byte("message"))
+	info, err := pkcs11DigestInfo(crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("pkcs11DigestInfo returned %v", err)
+	}
+	// The SHA-256 DigestInfo prefix of RFC 8017 section 9.2, note 1.
+	prefix, _ := hex.DecodeString("3031300d060960864801650304020105000420")
+	if want := append(prefix, digest[:]...); !bytes.Equal(info, want) {
+		t.Errorf("pkcs11DigestInfo returned %x, want %x", info, want)
+	}
+	if _, err := pkcs11DigestInfo(crypto.MD5, digest[:16]); err == nil {
+		t.Error("pkcs11DigestInfo succeeded for MD5")
+	}
+}
+
+func TestPKCS11ECPublicKey(t *testing.T) {
+	for _, alg := range []string{"ECDSA_P256", "ECDSA_P384", "ECDSA_P521"} {
+		curve, params, err := pkcs11Curve(alg)
+		if err != nil {
+			t.Fatalf("pkcs11Curve(%q) returned %v", alg, err)
+		}
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			t.Fatalf("failed to generate test key: %v", err)
+		}
+		raw := elliptic.Marshal(curve, key.X, key.Y)
+		wrapped, err := asn1.Marshal(raw)
+		if err != nil {
+			t.Fatalf("failed to marshal EC point: %v", err)
+		}
+		for _, point := range [][]byte{wrapped, raw} {
+			pub, err := pkcs11ECPublicKey(params, point)
+			if err != nil {
+				t.Errorf("pkcs11ECPublicKey(%s) returned %v", alg, err)
+				continue
+			}
+			if !pub.Equal(&key.PublicKey) {
+				t.Errorf("pkcs11ECPublicKey(%s) returned a different key", alg)
+			}
+		}
+	}
+	if _, _, err := pkcs11Curve("ECDSA_P224"); err == nil {
+		t.Error("pkcs11Curve succeeded for P-224")
+	}
+	_, params, _ := pkcs11Curve("ECDSA_P256")
+	if _, err := pkcs11ECPublicKey(params, []byte{4, 1, 2}); err == nil {
+		t.Error("pkcs11ECPublicKey succeeded for an invalid point")
+	}
+
+	// A bare P-256 point whose X starts with 0x3f also parses as an OCTET
+	// STRING of the remaining 63 bytes.
+	for {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("failed to generate test key: %v", err)
+		}
+		raw := elliptic.Marshal(elliptic.P256(), key.X, key.Y)
+		if raw[1] != 63 {
+			continue
+		}
+		pub, err := pkcs11ECPublicKey(params, raw)
+		if err != nil {
+			t.Fatalf("pkcs11ECPublicKey(%x) returned %v", raw, err)
+		}
+		if !pub.Equal(&key.PublicKey) {
+			t.Errorf("pkcs11ECPublicKey(%x) returned a different key", raw)
+		}
+		break
+	}
+}
+
+func TestECDSARawToASN1(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	digest := sha256.Sum256([]byte("message"))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatalf("ecdsa.Sign returned %v", err)
+	}
+	raw := make([]byte, 64)
+	r.FillBytes(raw[:32])
+	s.FillBytes(raw[32:])
+	sig, err := ecdsaRawToASN1(raw)
+	if err != nil {
+		t.Fatalf("ecdsaRawToASN1 returned %v", err)
+	}
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], sig) {
+		t.Error("converted signature did not verify")
+	}
+	if _, err := ecdsaRawToASN1(raw[:63]); err == nil {
+		t.Error("ecdsaRawToASN1 succeeded for an odd length")
+	}
+}
+
+func TestPKCS11Options(t *testing.T) {
+	if err := (PKCS11Options{TokenLabel: "agent"}).validate(); err == nil {
+		t.Error("validate succeeded without a module")
+	}
+	if err := (PKCS11Options{Module: "libsofthsm2.so"}).validate(); err == nil {
+		t.Error("validate succeeded without a token label")
+	}
+	current, intermediate, pending := PKCS11Options{}.labels()
+	if current != "certtostore" || intermediate != "certtostore-intermediate" || pending != "certtostore-pending" {
+		t.Errorf("labels() = %q, %q, %q", current, intermediate, pending)
+	}
+}
+
+// TestPKCS11HelperVectors signs like a token does for the mechanisms used by
+// PKCS11Key.Sign and checks the converted signatures.
+func TestPKCS11HelperVectors(t *testing.T) {
+	info, err := pkcs11DigestInfo(crypto.SHA256, helpers.Digest)
+	if err != nil {
+		t.Fatalf("pkcs11DigestInfo returned %v", err)
+	}
+	// CKM_RSA_PKCS pads and signs the DigestInfo as is.
+	sig, err := rsa.SignPKCS1v15(nil, helpers.RSAKey(), 0, info)
+	if err != nil {
+		t.Fatalf("SignPKCS1v15 returned %v", err)
+	}
+	helpers.AssertRSAPKCS1Signature(t, sig)
+
+	// CKM_ECDSA returns r||s.
+	r, s, err := ecdsa.Sign(rand.Reader, helpers.ECDSAKey(), helpers.Digest)
+	if err != nil {
+		t.Fatalf("ecdsa.Sign returned %v", err)
+	}
+	raw := make([]byte, 64)
+	r.FillBytes(raw[:32])
+	s.FillBytes(raw[32:])
+	if sig, err = ecdsaRawToASN1(raw); err != nil {
+		t.Fatalf("ecdsaRawToASN1 returned %v", err)
+	}
+	helpers.AssertECDSASignature(t, sig)
+}
@@ -0,0 +1,177 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+)
+
+var (
+	oidEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+	oidRSAESOAEP     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7}
+	oidMGF1          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+)
+
+// PKCS8ExportOptions configures how an exported private key is encrypted.
+// Exactly one of Passphrase and Recipient must be set, keys are never
+// exported in plaintext.
+type PKCS8ExportOptions struct {
+	// Passphrase encrypts the key as a PKCS #8 EncryptedPrivateKeyInfo with
+	// PBES2, using PBKDF2-HMAC-SHA256 and AES-256-CBC. The result is PEM
+	// encoded as an ENCRYPTED PRIVATE KEY.
+	Passphrase string
+	// Iterations is the PBKDF2 iteration count. Zero means
+	// DefaultKeyStoreIterations.
+	Iterations int
+	// Recipient encrypts the PKCS #8 key to the RSA key of the certificate as
+	// CMS EnvelopedData, using RSAES-OAEP with SHA256 and AES-256-CBC, for
+	// escrow to a key recovery agent. The result is PEM encoded as CMS and
+	// can be decrypted with openssl cms -decrypt.
+	Recipient *x509.Certificate
+}
+
+func (o PKCS8ExportOptions) validate() error {
+	if (o.Passphrase == "") == (o.Recipient == nil) {
+		return &ArgError{Op: "EncryptPKCS8", Arg: "opts", Reason: "exactly one of a passphrase and a recipient is required"}
+	}
+	if o.Iterations < 0 {
+		return &ArgError{Op: "EncryptPKCS8", Arg: "opts", Reason: "iterations must not be negative"}
+	}
+	if o.Recipient != nil {
+		if _, ok := o.Recipient.PublicKey.(*rsa.PublicKey); !ok {
+			return &ArgError{Op: "EncryptPKCS8", Arg: "opts", Reason: fmt.Sprintf("recipient has a %T, want an RSA key", o.Recipient.PublicKey)}
+		}
+	}
+	return nil
+}
+
+// EncryptPKCS8 encodes key as PKCS #8 and returns it PEM encoded and
+// encrypted as configured by opts. The plaintext encoding is wiped before
+// EncryptPKCS8 returns.
+func EncryptPKCS8(key crypto.PrivateKey, opts PKCS8ExportOptions) ([]byte, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	defer protect(der)()
+
+	if opts.Recipient != nil {
+		env, err := envelopeData(der, opts.Recipient)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "CMS", Bytes: env}), nil
+	}
+	iterations := opts.Iterations
+	if iterations == 0 {
+		iterations = DefaultKeyStoreIterations
+	}
+	enc, err := encryptPrivateKey(der, opts.Passphrase, iterations)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: enc}), nil
+}
+
+type envelopedData struct {
+	Version              int
+	RecipientInfos       []keyTransRecipientInfo `asn1:"set"`
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type keyTransRecipientInfo struct {
+	Version                int
+	IssuerAndSerialNumber  issuerAndSerialNumber
+	KeyEncryptionAlgorithm algorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm algorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+type rsaesOAEPParams struct {
+	Hash algorithmIdentifier `asn1:"explicit,tag:0"`
+	MGF  algorithmIdentifier `asn1:"explicit,tag:1"`
+}
+
+// envelopeData encrypts content for the RSA key of recipient as a CMS
+// ContentInfo with EnvelopedData.
+func envelopeData(content []byte, recipient *x509.Certificate) ([]byte, error) {
+	pub := recipient.PublicKey.(*rsa.PublicKey)
+	cek := make([]byte, 32)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, cek); err != nil {
+		return nil, err
+	}
+	defer protect(cek)()
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	pad := aes.BlockSize - len(content)%aes.BlockSize
+	data := append(append([]byte(nil), content...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+
+	encKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, cek, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not encrypt the content key: %v", err)
+	}
+	ri, err := keyTransRecipient(recipient, KeyWrapRSAOAEP256, encKey)
+	if err != nil {
+		return nil, err
+	}
+	ivDER, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	env, err := asn1.Marshal(envelopedData{
+		RecipientInfos: []keyTransRecipientInfo{ri},
+		EncryptedContentInfo: encryptedContentInfo{
+			ContentType:                oidData,
+			ContentEncryptionAlgorithm: algorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivDER}},
+			EncryptedContent:           data,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{ContentType: oidEnvelopedData, Content: explicit(env)})
+}
@@ -0,0 +1,134 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestEncryptPKCS8Passphrase(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	b, err := EncryptPKCS8(key, PKCS8ExportOptions{Passphrase: "secret", Iterations: 1000})
+	if err != nil {
+		t.Fatalf("EncryptPKCS8 returned %v", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "ENCRYPTED PRIVATE KEY" {
+		t.Fatalf("EncryptPKCS8 returned no ENCRYPTED PRIVATE KEY block:\n%s", b)
+	}
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(block.Bytes, &info); err != nil {
+		t.Fatalf("failed to decode EncryptedPrivateKeyInfo: %v", err)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		t.Errorf("key is encrypted with %v, want PBES2", info.Algorithm.Algorithm)
+	}
+	plain, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(block.Bytes, plain) {
+		t.Error("encrypted key contains the plaintext key")
+	}
+
+	for name, opts := range map[string]PKCS8ExportOptions{
+		"no protection": {},
+		"both":          {Passphrase: "secret", Recipient: &x509.Certificate{PublicKey: &key.PublicKey}},
+		"EC recipient":  {Recipient: &x509.Certificate{PublicKey: &key.PublicKey}},
+	} {
+		if _, err := EncryptPKCS8(key, opts); err == nil {
+			t.Errorf("EncryptPKCS8 with %s succeeded, want error", name)
+		}
+	}
+}
+
+func TestEncryptPKCS8Recipient(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	kra, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(7), Subject: pkix.Name{CommonName: "KRA"}, NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &kra.PublicKey, kra)
+	if err != nil {
+		t.Fatalf("failed to create test certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := EncryptPKCS8(key, PKCS8ExportOptions{Recipient: cert})
+	if err != nil {
+		t.Fatalf("EncryptPKCS8 returned %v", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "CMS" {
+		t.Fatalf("EncryptPKCS8 returned no CMS block:\n%s", b)
+	}
+	var ci contentInfo
+	var env envelopedData
+	if _, err := asn1.Unmarshal(block.Bytes, &ci); err != nil || !ci.ContentType.Equal(oidEnvelopedData) {
+		t.Fatalf("failed to decode ContentInfo: %v", err)
+	}
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &env); err != nil {
+		t.Fatalf("failed to decode EnvelopedData: %v", err)
+	}
+	if len(env.RecipientInfos) != 1 || env.RecipientInfos[0].IssuerAndSerialNumber.SerialNumber.Cmp(big.NewInt(7)) != 0 {
+		t.Fatalf("unexpected recipients %+v", env.RecipientInfos)
+	}
+	cek, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, kra, env.RecipientInfos[0].EncryptedKey, nil)
+	if err != nil {
+		t.Fatalf("failed to decrypt the content key: %v", err)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(env.EncryptedContentInfo.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil {
+		t.Fatalf("failed to decode IV: %v", err)
+	}
+	block2, err := aes.NewCipher(cek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := env.EncryptedContentInfo.EncryptedContent
+	cipher.NewCBCDecrypter(block2, iv).CryptBlocks(data, data)
+	data = data[:len(data)-int(data[len(data)-1])]
+	got, err := x509.ParsePKCS8PrivateKey(data)
+	if err != nil {
+		t.Fatalf("failed to parse decrypted key: %v", err)
+	}
+	if got.(*ecdsa.PrivateKey).D.Cmp(key.D) != 0 {
+		t.Error("decrypted key does not match")
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Names of the privileged operations, used as the keys of
+// Privileges.Problems and as the arguments of Privileges.Require.
+const (
+	PrivAccessMachineStore  = "access machine store"
+	PrivGenerateMachineKeys = "generate machine keys"
+	PrivSetACL              = "set key ACL"
+)
+
+// Privileges is the feature matrix of the operations that need more rights
+// than a standard user has, as probed by ProbePrivileges. Callers evaluate it
+// at startup and choose user scope behavior, such as a current user store or
+// DialHelper, instead of discovering access denied errors one operation at a
+// time.
+type Privileges struct {
+	// Elevated is set if the process runs with an elevated token.
+	Elevated bool
+	// CanAccessMachineStore is set if the LocalMachine\MY store can be
+	// opened for writing, which Store, Remove and Link need.
+	CanAccessMachineStore bool
+	// CanGenerateMachineKeys is set if the provider can create machine keys
+	// for the process.
+	CanGenerateMachineKeys bool
+	// CanSetACL is set if SetKeyACL can change the ACL of the key.
+	CanSetACL bool
+	// Problems explains each missing privilege by its name, such as
+	// PrivSetACL.
+	Problems map[string]string
+}
+
+// problem records why the privilege name is missing.
+func (p *Privileges) problem(name, reason string) {
+	if p.Problems == nil {
+		p.Problems = make(map[string]string)
+	}
+	p.Problems[name] = reason
+}
+
+// has reports whether the privilege name is available.
+func (p Privileges) has(name string) (bool, error) {
+	switch name {
+	case PrivAccessMachineStore:
+		return p.CanAccessMachineStore, nil
+	case PrivGenerateMachineKeys:
+		return p.CanGenerateMachineKeys, nil
+	case PrivSetACL:
+		return p.CanSetACL, nil
+	}
+	return false, &ArgError{Op: "Require", Arg: "names", Reason: fmt.Sprintf("unknown privilege %q", name)}
+}
+
+// Require returns an error naming the privileges of names that are missing,
+// with the reasons, or nil if all are available.
+func (p Privileges) Require(names ...string) error {
+	var missing []string
+	for _, n := range names {
+		ok, err := p.has(n)
+		if err != nil {
+			return err
+		}
+		if ok {
+			continue
+		}
+		msg := n
+		if reason := p.Problems[n]; reason != "" {
+			msg += " (" + reason + ")"
+		}
+		missing = append(missing, msg)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return fmt.Errorf("process lacks the privileges to %s", strings.Join(missing, ", "))
+}
+
+// UserScope reports whether the process should use user scope behavior
+// because it can neither write to the machine store nor create machine keys.
+func (p Privileges) UserScope() bool {
+	return !p.CanAccessMachineStore || !p.CanGenerateMachineKeys
+}
+
+// String summarizes p as a feature matrix, followed by the problems.
+func (p Privileges) String() string {
+	s := fmt.Sprintf("elevated=%s machine-store=%s machine-keys=%s set-acl=%s",
+		yesNo(p.Elevated), yesNo(p.CanAccessMachineStore), yesNo(p.CanGenerateMachineKeys), yesNo(p.CanSetACL))
+	names := make([]string, 0, len(p.Problems))
+	for n := range p.Problems {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		s += fmt.Sprintf("; %s: %s", n, p.Problems[n])
+	}
+	return s
+}
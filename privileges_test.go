@@ -0,0 +1,74 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPrivilegesRequire(t *testing.T) {
+	p := Privileges{CanAccessMachineStore: true}
+	p.problem(PrivGenerateMachineKeys, "the provider requires elevation")
+	p.problem(PrivSetACL, "access denied")
+
+	if err := p.Require(PrivAccessMachineStore); err != nil {
+		t.Errorf("Require(%q) returned %v", PrivAccessMachineStore, err)
+	}
+	if err := p.Require(); err != nil {
+		t.Errorf("Require() returned %v", err)
+	}
+	err := p.Require(PrivAccessMachineStore, PrivGenerateMachineKeys, PrivSetACL)
+	if err == nil {
+		t.Fatal("Require succeeded with missing privileges")
+	}
+	for _, want := range []string{"generate machine keys (the provider requires elevation)", "set key ACL (access denied)"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Require returned %q, want it to contain %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), PrivAccessMachineStore) {
+		t.Errorf("Require returned %q, which names an available privilege", err)
+	}
+	if _, ok := p.Require("format disk").(*ArgError); !ok {
+		t.Error("Require of an unknown privilege did not return an *ArgError")
+	}
+}
+
+func TestPrivilegesUserScope(t *testing.T) {
+	for _, tc := range []struct {
+		p    Privileges
+		want bool
+	}{
+		{Privileges{}, true},
+		{Privileges{CanAccessMachineStore: true}, true},
+		{Privileges{CanAccessMachineStore: true, CanGenerateMachineKeys: true}, false},
+	} {
+		if got := tc.p.UserScope(); got != tc.want {
+			t.Errorf("%+v.UserScope() = %t, want %t", tc.p, got, tc.want)
+		}
+	}
+}
+
+func TestPrivilegesString(t *testing.T) {
+	p := Privileges{Elevated: true, CanAccessMachineStore: true, CanGenerateMachineKeys: true}
+	p.problem(PrivSetACL, "access denied")
+	got := p.String()
+	for _, want := range []string{"elevated=yes", "machine-store=yes", "machine-keys=yes", "set-acl=no", "set key ACL: access denied"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("String() = %q, want it to contain %q", got, want)
+		}
+	}
+}
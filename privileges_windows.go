@@ -0,0 +1,109 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"os"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// fileAddFile is FILE_ADD_FILE, the right to create files in a directory.
+const fileAddFile = 0x0002
+
+// ProbePrivileges probes which privileged operations the process can perform
+// with provider. The probes open the machine store, the provider and the
+// machine key directory with the rights the operations need, and do not
+// change anything. Without a key to check, CanSetACL requires elevation; the
+// Privileges method of WinCertStore also checks the key of the store.
+func ProbePrivileges(provider string) Privileges {
+	p := Privileges{Elevated: windows.GetCurrentProcessToken().IsElevated()}
+
+	s, err := openStore(StoreLocation{Location: LocationLocalMachine, Name: "MY"}, certStoreOpenExisting)
+	if err != nil {
+		p.problem(PrivAccessMachineStore, err.Error())
+	} else {
+		p.CanAccessMachineStore = true
+		windows.CertCloseStore(s, 0)
+	}
+
+	switch err := probeProvider(provider); {
+	case err != nil:
+		p.problem(PrivGenerateMachineKeys, err.Error())
+	case providerResidency(provider) != ResidencyFile:
+		// Providers without key files, such as the platform provider,
+		// only create machine keys for elevated processes.
+		p.CanGenerateMachineKeys = p.Elevated
+		if !p.Elevated {
+			p.problem(PrivGenerateMachineKeys, "the provider requires elevation")
+		}
+	default:
+		if err := probeFileAccess(machineKeyDir(), fileAddFile, windows.FILE_FLAG_BACKUP_SEMANTICS); err != nil {
+			p.problem(PrivGenerateMachineKeys, err.Error())
+		} else {
+			p.CanGenerateMachineKeys = true
+		}
+	}
+
+	p.CanSetACL = p.Elevated
+	if !p.Elevated {
+		p.problem(PrivSetACL, "changing the ACL of a machine key requires elevation")
+	}
+	logDebug("Probed privileges.", field("provider", provider), field("privileges", p.String()))
+	return p
+}
+
+// Privileges probes the privileged operations of w like ProbePrivileges. If
+// the key of w is kept in a file, CanSetACL reports whether the process may
+// change the ACL of that file, which its owner can do without elevation.
+func (w *WinCertStore) Privileges() Privileges {
+	p := ProbePrivileges(w.ProvName)
+	if p.CanSetACL {
+		return p
+	}
+	loc, err := w.KeyLocation()
+	if err != nil || loc == nil || loc.Path == "" {
+		return p
+	}
+	if err := probeFileAccess(loc.Path, windows.WRITE_DAC, 0); err != nil {
+		p.problem(PrivSetACL, err.Error())
+		return p
+	}
+	p.CanSetACL = true
+	delete(p.Problems, PrivSetACL)
+	return p
+}
+
+// machineKeyDir returns the directory of the machine keys of the software
+// providers.
+func machineKeyDir() string {
+	return strings.TrimRight(os.Getenv("ProgramData"), `\`) + `\Microsoft\Crypto\Keys`
+}
+
+// probeFileAccess opens path with access and closes it again.
+func probeFileAccess(path string, access, flags uint32) error {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	h, err := windows.CreateFile(name, access, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE, nil, windows.OPEN_EXISTING, flags, 0)
+	if err != nil {
+		return &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return windows.CloseHandle(h)
+}
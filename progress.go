@@ -0,0 +1,62 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"errors"
+	"time"
+)
+
+// progressInterval is how often a progress callback is invoked while a slow
+// operation is running.
+const progressInterval = 5 * time.Second
+
+// ErrGenerateTimeout is returned by Generate if the provider did not finish
+// generating the key within the configured timeout. The provider may still
+// complete the key in the background. The container stays locked until it
+// does, so a later Generate for the container waits for it, and a key it
+// completes is deleted. A key the container held before may already have been
+// replaced.
+var ErrGenerateTimeout = errors.New("timed out waiting for the provider to generate the key")
+
+// waitProgress waits for the result of an operation on done. It calls
+// progress with the elapsed time every interval while waiting, and returns
+// ErrGenerateTimeout once timeout has passed. A zero timeout waits forever
+// and a nil progress function is not called.
+func waitProgress(done <-chan error, timeout, interval time.Duration, progress func(elapsed time.Duration)) error {
+	start := time.Now()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-expired:
+			return ErrGenerateTimeout
+		case <-ticker.C:
+			if progress != nil {
+				progress(time.Since(start))
+			}
+		}
+	}
+}
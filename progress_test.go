@@ -0,0 +1,45 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWaitProgress(t *testing.T) {
+	done := make(chan error, 1)
+	var calls int32
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		done <- errors.New("finalize failed")
+	}()
+	err := waitProgress(done, time.Second, 5*time.Millisecond, func(time.Duration) { atomic.AddInt32(&calls, 1) })
+	if err == nil || err.Error() != "finalize failed" {
+		t.Errorf("waitProgress returned %v, want the operation error", err)
+	}
+	if atomic.LoadInt32(&calls) == 0 {
+		t.Error("progress was never called")
+	}
+}
+
+func TestWaitProgressTimeout(t *testing.T) {
+	done := make(chan error)
+	if err := waitProgress(done, 20*time.Millisecond, time.Hour, nil); err != ErrGenerateTimeout {
+		t.Errorf("waitProgress returned %v, want: %v", err, ErrGenerateTimeout)
+	}
+}
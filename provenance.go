@@ -0,0 +1,85 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// provenanceFile is the sidecar record of FileStorage.
+const provenanceFile = "cert.provenance.json"
+
+// Provenance records where a key and its certificate come from, for supply
+// chain style audits of machine identities. It is kept with the certificate,
+// as the PropProvenance property in a Windows store and in a sidecar file of
+// FileStorage, and is reported by inventories.
+type Provenance struct {
+	// GeneratedBy is the principal that generated the key, such as a user or
+	// service account.
+	GeneratedBy string `json:"generated_by,omitempty"`
+	// Tool is the program that generated the key, with its version.
+	Tool string `json:"tool,omitempty"`
+	// Backend is the enrollment backend that issued the certificate, such as
+	// the name of the CA or the ACME directory URL.
+	Backend string `json:"backend,omitempty"`
+	// CSRHash is the hash of the certificate request, as returned by
+	// CSRHash.
+	CSRHash string `json:"csr_hash,omitempty"`
+	// Attestation refers to the key attestation presented to the backend,
+	// such as the ID of the attestation statement it recorded.
+	Attestation string `json:"attestation,omitempty"`
+	// Created is when the key was generated.
+	Created time.Time `json:"created,omitempty"`
+}
+
+// CSRHash returns the hash of the DER encoded certificate request csr for
+// Provenance.CSRHash, as "sha256:" followed by the hex encoded digest.
+func CSRHash(csr []byte) string {
+	sum := sha256.Sum256(csr)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// MatchesCSR reports whether p records the request csr.
+func (p *Provenance) MatchesCSR(csr []byte) bool {
+	return p != nil && strings.EqualFold(p.CSRHash, CSRHash(csr))
+}
+
+// marshalProvenance encodes p as the value of the provenance property and
+// the content of the sidecar file.
+func marshalProvenance(p *Provenance) ([]byte, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode provenance: %v", err)
+	}
+	return b, nil
+}
+
+// parseProvenance decodes a provenance record. It returns nil for an empty
+// record.
+func parseProvenance(b []byte) (*Provenance, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	p := new(Provenance)
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("could not decode provenance: %v", err)
+	}
+	return p, nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCSRHash(t *testing.T) {
+	got := CSRHash([]byte("csr"))
+	if !strings.HasPrefix(got, "sha256:") || len(got) != len("sha256:")+64 {
+		t.Errorf("CSRHash returned %q, want a sha256: prefixed hex digest", got)
+	}
+	p := &Provenance{CSRHash: strings.ToUpper(got)}
+	if !p.MatchesCSR([]byte("csr")) {
+		t.Error("MatchesCSR(csr) = false, want true")
+	}
+	if p.MatchesCSR([]byte("other")) {
+		t.Error("MatchesCSR(other) = true, want false")
+	}
+	if (*Provenance)(nil).MatchesCSR([]byte("csr")) {
+		t.Error("MatchesCSR of nil provenance = true, want false")
+	}
+}
+
+func TestProvenanceRoundTrip(t *testing.T) {
+	want := &Provenance{
+		GeneratedBy: `NT AUTHORITY\SYSTEM`,
+		Tool:        "agent/1.2.3",
+		Backend:     "https://ca.example.com/acme",
+		CSRHash:     CSRHash([]byte("csr")),
+		Attestation: "attestation-42",
+		Created:     time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	b, err := marshalProvenance(want)
+	if err != nil {
+		t.Fatalf("marshalProvenance returned %v", err)
+	}
+	got, err := parseProvenance(b)
+	if err != nil {
+		t.Fatalf("parseProvenance returned %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseProvenance returned %+v, want %+v", got, want)
+	}
+	if got, err := parseProvenance(nil); got != nil || err != nil {
+		t.Errorf("parseProvenance(nil) = %v, %v, want nil, nil", got, err)
+	}
+	if _, err := parseProvenance([]byte("{")); err == nil {
+		t.Error("parseProvenance of a truncated record succeeded")
+	}
+}
+
+func TestFileStorageProvenance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "provenance")
+	if err != nil {
+		t.Fatalf("TempDir returned %v", err)
+	}
+	defer os.RemoveAll(dir)
+	f := NewFileStorage(dir)
+	if p, err := f.Provenance(); p != nil || err != nil {
+		t.Fatalf("Provenance before SetProvenance = %v, %v, want nil, nil", p, err)
+	}
+	want := &Provenance{Tool: "agent", Backend: "test-ca"}
+	if err := f.SetProvenance(want); err != nil {
+		t.Fatalf("SetProvenance returned %v", err)
+	}
+	got, err := f.Provenance()
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Provenance = %+v, %v, want %+v", got, err, want)
+	}
+	if err := f.Remove(false); err != nil {
+		t.Fatalf("Remove returned %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, provenanceFile)); !os.IsNotExist(err) {
+		t.Errorf("Remove left the provenance record: %v", err)
+	}
+	if err := f.SetProvenance(nil); err != nil {
+		t.Errorf("SetProvenance(nil) without a record returned %v", err)
+	}
+}
+
+func TestInventoryFilterMatchProvenance(t *testing.T) {
+	p := &Provenance{Backend: "Test-CA"}
+	for _, tc := range []struct {
+		filter InventoryFilter
+		p      *Provenance
+		want   bool
+	}{
+		{InventoryFilter{}, nil, true},
+		{InventoryFilter{}, p, true},
+		{InventoryFilter{EnrollmentBackend: "test-ca"}, p, true},
+		{InventoryFilter{EnrollmentBackend: "other-ca"}, p, false},
+		{InventoryFilter{EnrollmentBackend: "test-ca"}, nil, false},
+	} {
+		if got := tc.filter.matchProvenance(tc.p); got != tc.want {
+			t.Errorf("%+v.matchProvenance(%+v) = %t, want %t", tc.filter, tc.p, got, tc.want)
+		}
+	}
+}
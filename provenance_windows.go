@@ -0,0 +1,46 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"fmt"
+)
+
+// SetProvenance records p with cert in the local machine MY store, where
+// EnumerateProvenance and the inventory of ServeHelper and ServeRemote
+// report it. Enrollment code calls it after Store. A nil p removes the
+// record. The key of cert must be in the namespace of w.
+func (w *WinCertStore) SetProvenance(cert *x509.Certificate, p *Provenance) error {
+	if err := checkCert("SetProvenance", "cert", cert); err != nil {
+		return err
+	}
+	props, err := OpenCertProperties(StoreLocation{Location: LocationLocalMachine, Name: "MY"}, cert)
+	if err != nil {
+		return err
+	}
+	defer props.Close()
+
+	if !w.certInNamespace(props.certContext) {
+		return fmt.Errorf("key of certificate %s is not in namespace %q", thumbprint(cert), w.namespace)
+	}
+	if err := props.SetProvenance(p); err != nil {
+		return err
+	}
+	logInfo("Recorded certificate provenance.", opField("setprovenance"), thumbprintField(thumbprint(cert)), field("removed", p == nil))
+	return nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// Enroller submits certificate requests to a CA. Implementations wrap the
+// enrollment protocol of the CA, so that Provision works with any of them.
+type Enroller interface {
+	// Enroll submits the DER encoded PKCS #10 request and returns the issued
+	// certificate followed by its issuers.
+	Enroll(csr []byte) ([]*x509.Certificate, error)
+}
+
+// KeyACL grants access to the private key to a security principal, using the
+// permission syntax of icacls, such as "R" or "F".
+type KeyACL struct {
+	SID  string
+	Perm string
+}
+
+// ProvisionReport describes what Provision did.
+type ProvisionReport struct {
+	// Provider is the key storage provider that was selected.
+	Provider string
+	// Certificate is the issued certificate and Chain its issuers, as
+	// returned by the Enroller.
+	Certificate *x509.Certificate
+	Chain       []*x509.Certificate
+	// Steps lists the completed steps in order.
+	Steps []string
+	// Result lists the changes made by all steps.
+	Result *Result
+}
+
+// Provisioning steps recorded in ProvisionReport.Steps.
+const (
+	StepSelectProvider = "selectprovider"
+	StepGenerate       = "generate"
+	StepRequest        = "request"
+	StepEnroll         = "enroll"
+	StepStore          = "store"
+	StepLink           = "link"
+	StepACL            = "acl"
+)
+
+// checkIssuedChain checks that chain, as returned by an Enroller, starts with
+// a certificate for pub and has an issuer to install as the intermediate.
+func checkIssuedChain(chain []*x509.Certificate, pub crypto.PublicKey) error {
+	if len(chain) == 0 {
+		return errors.New("enroller returned no certificate")
+	}
+	for i, cert := range chain {
+		if cert == nil {
+			return fmt.Errorf("enroller returned a nil certificate at position %d", i)
+		}
+	}
+	if len(chain) < 2 {
+		return errors.New("enroller returned no issuer for the certificate")
+	}
+	want, err := publicDER(pub)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(chain[0].RawSubjectPublicKeyInfo, want) {
+		return fmt.Errorf("certificate %s is not for the generated key", thumbprint(chain[0]))
+	}
+	return nil
+}
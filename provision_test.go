@@ -0,0 +1,73 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// issue returns a certificate for pub signed by the CA key ca.
+func issue(t *testing.T, pub interface{}, ca *ecdsa.PrivateKey) *x509.Certificate {
+	t.Helper()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, pub, ca)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return cert
+}
+
+func TestCheckIssuedChain(t *testing.T) {
+	ca, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate CA key: %v", err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	leaf := issue(t, key.Public(), ca)
+	caCert := issue(t, ca.Public(), ca)
+
+	if err := checkIssuedChain([]*x509.Certificate{leaf, caCert}, key.Public()); err != nil {
+		t.Errorf("checkIssuedChain returned %v", err)
+	}
+	for name, chain := range map[string][]*x509.Certificate{
+		"empty":      nil,
+		"leaf only":  {leaf},
+		"nil issuer": {leaf, nil},
+		"wrong key":  {caCert, caCert},
+	} {
+		if err := checkIssuedChain(chain, key.Public()); err == nil {
+			t.Errorf("checkIssuedChain with %s chain succeeded, want error", name)
+		}
+	}
+}
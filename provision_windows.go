@@ -0,0 +1,138 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// ProvisionConfig configures Provision.
+type ProvisionConfig struct {
+	// Providers are tried in order and the first one that can be opened is
+	// used. The default is ProviderMSPlatform, then ProviderMSSoftware.
+	Providers []string
+	// Store configures the store the certificate is installed with. Its
+	// Provider is replaced by the selected provider.
+	Store WinCertStoreOptions
+	// Request describes the certificate request and the key to generate.
+	Request RequestTemplate
+	// Enroller submits the request to the CA.
+	Enroller Enroller
+	// LinkUserStore also adds the certificate to the current user store.
+	LinkUserStore bool
+	// ACLs grant access to the private key after it is installed.
+	ACLs []KeyACL
+}
+
+// Provision performs the complete enrollment workflow: it selects a provider,
+// generates the key, creates the request, submits it with cfg.Enroller,
+// installs the certificate and its issuer, links the user store and sets
+// the key ACLs. The report is returned even if a step fails, listing the
+// steps and changes completed before the failure.
+func Provision(cfg ProvisionConfig) (*ProvisionReport, error) {
+	report := &ProvisionReport{Result: &Result{Operation: "provision", Container: cfg.Store.Container}}
+	if cfg.Enroller == nil {
+		return report, errors.New("provision: no enroller configured")
+	}
+	if err := cfg.Request.Validate(); err != nil {
+		return report, fmt.Errorf("provision: %v", err)
+	}
+
+	providers := cfg.Providers
+	if len(providers) == 0 {
+		providers = []string{ProviderMSPlatform, ProviderMSSoftware}
+	}
+	var w *WinCertStore
+	for _, p := range providers {
+		opts := cfg.Store
+		opts.Provider = p
+		store, err := OpenWinCertStoreWithOptions(opts)
+		if err != nil {
+			logInfo("Provider is not available.", opField("provision"), field("provider", p), errField(err))
+			continue
+		}
+		w = store
+		break
+	}
+	if w == nil {
+		return report, fmt.Errorf("provision: none of the providers %q is available", providers)
+	}
+	report.Provider = w.ProvName
+	report.Steps = append(report.Steps, StepSelectProvider)
+
+	signer, err := w.GenerateWithOpts(cfg.Request.Key)
+	if err != nil {
+		return report, fmt.Errorf("provision: generating key: %v", err)
+	}
+	report.Result.addAuditedChange(ActionGenerated, "", w.ProvName)
+	report.Steps = append(report.Steps, StepGenerate)
+
+	csr, err := cfg.Request.CSR(rand.Reader, signer)
+	if err != nil {
+		return report, fmt.Errorf("provision: creating request: %v", err)
+	}
+	report.Steps = append(report.Steps, StepRequest)
+
+	chain, err := cfg.Enroller.Enroll(csr)
+	if err != nil {
+		return report, fmt.Errorf("provision: enrolling: %v", err)
+	}
+	if err := checkIssuedChain(chain, signer.Public()); err != nil {
+		return report, fmt.Errorf("provision: %v", err)
+	}
+	report.Certificate = chain[0]
+	report.Chain = chain[1:]
+	report.Steps = append(report.Steps, StepEnroll)
+
+	res, err := w.StoreWithResult(chain[0], chain[1])
+	report.Result.merge(res)
+	if err != nil {
+		return report, fmt.Errorf("provision: %v", err)
+	}
+	report.Steps = append(report.Steps, StepStore)
+
+	if cfg.LinkUserStore {
+		res, err := w.LinkWithResult()
+		report.Result.merge(res)
+		if err != nil {
+			return report, fmt.Errorf("provision: %v", err)
+		}
+		report.Steps = append(report.Steps, StepLink)
+	}
+
+	if len(cfg.ACLs) > 0 {
+		if err := w.provisionACLs(cfg.ACLs, report.Result); err != nil {
+			return report, fmt.Errorf("provision: %v", err)
+		}
+		report.Steps = append(report.Steps, StepACL)
+	}
+	logInfo("Provisioned certificate.", opField("provision"), containerField(w.container), field("provider", w.ProvName), thumbprintField(thumbprint(chain[0])))
+	return report, nil
+}
+
+// provisionACLs grants acls on the key of w and records the changes in res.
+func (w *WinCertStore) provisionACLs(acls []KeyACL, res *Result) error {
+	for _, acl := range acls {
+		if err := w.SetKeyACL("grant", acl.SID, acl.Perm); err != nil {
+			return err
+		}
+		res.addChange(ActionACLChanged, "", w.ProvName)
+	}
+	return nil
+}
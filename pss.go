@@ -0,0 +1,61 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/rsa"
+	"fmt"
+)
+
+// pssSaltLength validates a PSS signature request for digest with the RSA key
+// pub and returns the explicit salt length to use. The salt length in opts
+// follows the conventions of crypto/rsa: rsa.PSSSaltLengthAuto uses the
+// largest salt that fits and rsa.PSSSaltLengthEqualsHash uses the size of the
+// hash. CNG always uses MGF1 with the message hash, so the digest must have
+// been produced by opts.Hash for the signature to be accepted by strict
+// verifiers.
+func pssSaltLength(pub *rsa.PublicKey, digest []byte, opts *rsa.PSSOptions) (int, error) {
+	hash := opts.HashFunc()
+	if !hash.Available() {
+		return 0, fmt.Errorf("unsupported hash algorithm %v", hash)
+	}
+	if len(digest) != hash.Size() {
+		return 0, fmt.Errorf("digest length %d does not match the %v hash length %d", len(digest), hash, hash.Size())
+	}
+
+	// emLen is the length of the encoded message, see RFC 8017 section 9.1.1.
+	emLen := (pub.N.BitLen() - 1 + 7) / 8
+	maxSalt := emLen - hash.Size() - 2
+	if maxSalt < 0 {
+		return 0, fmt.Errorf("%d bit key is too small for PSS with %v", pub.N.BitLen(), hash)
+	}
+
+	switch salt := opts.SaltLength; {
+	case salt == rsa.PSSSaltLengthAuto:
+		return maxSalt, nil
+	case salt == rsa.PSSSaltLengthEqualsHash:
+		salt = hash.Size()
+		if salt > maxSalt {
+			return 0, fmt.Errorf("salt length %d is too large for a %d bit key, the maximum is %d", salt, pub.N.BitLen(), maxSalt)
+		}
+		return salt, nil
+	case salt < 0:
+		return 0, fmt.Errorf("invalid PSS salt length %d", salt)
+	case salt > maxSalt:
+		return 0, fmt.Errorf("salt length %d is too large for a %d bit key, the maximum is %d", salt, pub.N.BitLen(), maxSalt)
+	default:
+		return salt, nil
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+)
+
+func TestPSSSaltLength(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	sha256Digest := make([]byte, crypto.SHA256.Size())
+	sha512Digest := make([]byte, crypto.SHA512.Size())
+
+	tests := []struct {
+		desc   string
+		digest []byte
+		opts   *rsa.PSSOptions
+		want   int
+		ok     bool
+	}{
+		{"auto", sha256Digest, &rsa.PSSOptions{Hash: crypto.SHA256, SaltLength: rsa.PSSSaltLengthAuto}, 256 - 32 - 2, true},
+		{"equals hash", sha512Digest, &rsa.PSSOptions{Hash: crypto.SHA512, SaltLength: rsa.PSSSaltLengthEqualsHash}, 64, true},
+		{"explicit", sha256Digest, &rsa.PSSOptions{Hash: crypto.SHA256, SaltLength: 20}, 20, true},
+		{"explicit maximum", sha256Digest, &rsa.PSSOptions{Hash: crypto.SHA256, SaltLength: 222}, 222, true},
+		{"too large", sha256Digest, &rsa.PSSOptions{Hash: crypto.SHA256, SaltLength: 223}, 0, false},
+		{"negative", sha256Digest, &rsa.PSSOptions{Hash: crypto.SHA256, SaltLength: -2}, 0, false},
+		{"digest mismatch", sha256Digest, &rsa.PSSOptions{Hash: crypto.SHA512}, 0, false},
+		{"unavailable hash", sha256Digest, &rsa.PSSOptions{Hash: crypto.MD4}, 0, false},
+	}
+	for _, tt := range tests {
+		got, err := pssSaltLength(&key.PublicKey, tt.digest, tt.opts)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: pssSaltLength returned error %v, want success: %t", tt.desc, err, tt.ok)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: unexpected salt length got: %d, want: %d", tt.desc, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrQuotaExceeded is returned by key operations that a UsageQuota with
+// Throttle set rejects.
+var ErrQuotaExceeded = errors.New("key usage quota exceeded")
+
+// UsageQuota limits how often the keys of a store sign, decrypt and unwrap,
+// so that a compromised process that uses the machine key for unexpected
+// volumes of operations raises an alert or is throttled. Each key container
+// has its own counters, which are shared by all handles of the key opened
+// from the store. TestSign is not counted.
+type UsageQuota struct {
+	// PerMinute and PerDay are the number of operations a key may perform
+	// in a minute and in a day. Zero means no limit.
+	PerMinute int
+	PerDay    int
+	// Throttle makes operations over a limit fail with ErrQuotaExceeded
+	// until the window ends. Otherwise they proceed and are only reported.
+	Throttle bool
+	// OnAnomaly, if set, is called when a key first exceeds a limit in a
+	// window. It is called by the key operation and must not block.
+	OnAnomaly func(UsageAnomaly)
+}
+
+func (q UsageQuota) validate() error {
+	if q.PerMinute < 0 || q.PerDay < 0 {
+		return fmt.Errorf("usage quota limits %d per minute and %d per day must not be negative", q.PerMinute, q.PerDay)
+	}
+	if q.PerMinute == 0 && q.PerDay == 0 && (q.Throttle || q.OnAnomaly != nil) {
+		return errors.New("usage quota has no limit")
+	}
+	return nil
+}
+
+// UsageAnomaly reports a key that exceeded a limit of its UsageQuota.
+type UsageAnomaly struct {
+	// Container is the key container, or "" for an ephemeral key.
+	Container string
+	// Op is the operation over the limit, such as "sign" or "decrypt".
+	Op string
+	// Window is the period of the exceeded limit, a minute or a day, and
+	// Start is when the current window began.
+	Window time.Duration
+	Start  time.Time
+	Limit  int
+	// Throttled is set if the operation was rejected with ErrQuotaExceeded.
+	Throttled bool
+}
+
+// quotaWindow counts the operations of a key in a fixed window.
+type quotaWindow struct {
+	start    time.Time
+	count    int
+	reported bool
+}
+
+// usageQuota implements UsageQuota for one key. It is safe for concurrent
+// use, and a nil *usageQuota allows all operations.
+type usageQuota struct {
+	cfg       UsageQuota
+	container string
+	now       func() time.Time
+
+	mu          sync.Mutex
+	minute, day quotaWindow
+}
+
+// allow counts an operation and reports it if it exceeds a limit. It returns
+// ErrQuotaExceeded if the operation must be rejected, rejected operations are
+// not counted.
+func (q *usageQuota) allow(op string) error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	now := q.now()
+	var anomalies []UsageAnomaly
+	exceeded := false
+	for _, w := range []struct {
+		win    *quotaWindow
+		period time.Duration
+		limit  int
+	}{
+		{&q.minute, time.Minute, q.cfg.PerMinute},
+		{&q.day, 24 * time.Hour, q.cfg.PerDay},
+	} {
+		if w.limit == 0 {
+			continue
+		}
+		if now.Sub(w.win.start) >= w.period {
+			*w.win = quotaWindow{start: now}
+		}
+		if w.win.count < w.limit {
+			continue
+		}
+		exceeded = true
+		if !w.win.reported {
+			w.win.reported = true
+			anomalies = append(anomalies, UsageAnomaly{
+				Container: q.container,
+				Op:        op,
+				Window:    w.period,
+				Start:     w.win.start,
+				Limit:     w.limit,
+				Throttled: q.cfg.Throttle,
+			})
+		}
+	}
+	throttled := exceeded && q.cfg.Throttle
+	if !throttled {
+		q.minute.count++
+		q.day.count++
+	}
+	q.mu.Unlock()
+
+	for _, a := range anomalies {
+		logWarning("Key usage quota exceeded.", opField(op), containerField(q.container), field("window", a.Window), field("limit", a.Limit), field("throttled", a.Throttled))
+		if q.cfg.OnAnomaly != nil {
+			q.cfg.OnAnomaly(a)
+		}
+	}
+	if throttled {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// usageQuotas hands out the usageQuota of each key container of a store. It
+// is safe for concurrent use, and a nil *usageQuotas hands out nil quotas.
+type usageQuotas struct {
+	cfg UsageQuota
+
+	mu   sync.Mutex
+	keys map[string]*usageQuota
+}
+
+// newUsageQuotas returns the quotas for cfg, or nil if it has no limit.
+func newUsageQuotas(cfg UsageQuota) *usageQuotas {
+	if cfg.PerMinute == 0 && cfg.PerDay == 0 {
+		return nil
+	}
+	return &usageQuotas{cfg: cfg, keys: make(map[string]*usageQuota)}
+}
+
+// forKey returns the quota of container. Ephemeral keys, with an empty
+// container, each get their own.
+func (s *usageQuotas) forKey(container string) *usageQuota {
+	if s == nil {
+		return nil
+	}
+	if container == "" {
+		return &usageQuota{cfg: s.cfg, now: time.Now}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, ok := s.keys[container]
+	if !ok {
+		q = &usageQuota{cfg: s.cfg, container: container, now: time.Now}
+		s.keys[container] = q
+	}
+	return q
+}
@@ -0,0 +1,84 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUsageQuota(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	var anomalies []UsageAnomaly
+	cfg := UsageQuota{PerMinute: 2, PerDay: 3, OnAnomaly: func(a UsageAnomaly) { anomalies = append(anomalies, a) }}
+	qs := newUsageQuotas(cfg)
+	q := qs.forKey("machine")
+	q.now = func() time.Time { return now }
+	if qs.forKey("machine") != q {
+		t.Error("forKey returned a different quota for the same container")
+	}
+
+	// Without Throttle, operations over a limit proceed and are reported
+	// once per window.
+	for i := 0; i < 3; i++ {
+		if err := q.allow("sign"); err != nil {
+			t.Fatalf("allow returned %v without Throttle", err)
+		}
+	}
+	if len(anomalies) != 1 || anomalies[0].Window != time.Minute || anomalies[0].Limit != 2 || anomalies[0].Container != "machine" || anomalies[0].Throttled {
+		t.Fatalf("anomalies = %+v, want one for the minute limit", anomalies)
+	}
+
+	// The minute window restarts, but the day limit is exceeded.
+	now = now.Add(time.Minute)
+	q.allow("decrypt")
+	if len(anomalies) != 2 || anomalies[1].Window != 24*time.Hour || anomalies[1].Op != "decrypt" {
+		t.Fatalf("anomalies = %+v, want a second one for the day limit", anomalies)
+	}
+
+	// With Throttle, rejected operations are not counted.
+	cfg.Throttle = true
+	anomalies = nil
+	q = newUsageQuotas(cfg).forKey("machine")
+	q.now = func() time.Time { return now }
+	q.allow("sign")
+	q.allow("sign")
+	if err := q.allow("sign"); err != ErrQuotaExceeded {
+		t.Errorf("third operation in a minute returned %v, want ErrQuotaExceeded", err)
+	}
+	if len(anomalies) != 1 || !anomalies[0].Throttled {
+		t.Errorf("anomalies = %+v, want one throttled", anomalies)
+	}
+	now = now.Add(time.Minute)
+	if err := q.allow("sign"); err != nil {
+		t.Errorf("operation in the next minute returned %v", err)
+	}
+	if err := q.allow("sign"); err != ErrQuotaExceeded {
+		t.Errorf("fourth operation in a day returned %v, want ErrQuotaExceeded", err)
+	}
+
+	var nilQuota *usageQuota
+	if err := nilQuota.allow("sign"); err != nil {
+		t.Errorf("nil quota returned %v", err)
+	}
+	if newUsageQuotas(UsageQuota{}) != nil {
+		t.Error("newUsageQuotas returned quotas without limits")
+	}
+	for _, c := range []UsageQuota{{PerMinute: -1}, {Throttle: true}} {
+		if err := c.validate(); err == nil {
+			t.Errorf("%+v validated", c)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"io"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// bCryptUseSystemPreferredRNG is BCRYPT_USE_SYSTEM_PREFERRED_RNG.
+const bCryptUseSystemPreferredRNG = 0x00000002
+
+var (
+	bCrypt          = windows.MustLoadDLL("bcrypt.dll")
+	bCryptGenRandom = bCrypt.MustFindProc("BCryptGenRandom")
+)
+
+// RandReader is a cryptographically secure random number generator backed by
+// BCryptGenRandom with the system preferred RNG. It can be used wherever an
+// io.Reader for randomness is expected, such as the rand argument of Sign.
+var RandReader io.Reader = bcryptReader{}
+
+type bcryptReader struct{}
+
+// maxRandChunk bounds the size of a single BCryptGenRandom call, whose
+// length argument is a ULONG.
+const maxRandChunk = 1 << 30
+
+func (bcryptReader) Read(b []byte) (int, error) {
+	n := 0
+	for n < len(b) {
+		chunk := b[n:]
+		if len(chunk) > maxRandChunk {
+			chunk = chunk[:maxRandChunk]
+		}
+		r, _, err := bCryptGenRandom.Call(
+			0,
+			uintptr(unsafe.Pointer(&chunk[0])),
+			uintptr(len(chunk)),
+			bCryptUseSystemPreferredRNG)
+		if r != 0 {
+			return n, ncryptErr("BCryptGenRandom", r, "", err)
+		}
+		n += len(chunk)
+	}
+	return n, nil
+}
@@ -0,0 +1,38 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRandReader(t *testing.T) {
+	for _, size := range []int{0, 1, 32, 4096} {
+		b := make([]byte, size)
+		n, err := RandReader.Read(b)
+		if err != nil {
+			t.Fatalf("RandReader.Read(%d bytes) returned %v", size, err)
+		}
+		if n != size {
+			t.Errorf("RandReader.Read(%d bytes) read %d bytes", size, n)
+		}
+		if size >= 32 && bytes.Equal(b, make([]byte, size)) {
+			t.Errorf("RandReader.Read(%d bytes) left the buffer zeroed", size)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"fmt"
+)
+
+// FlagOp identifies an operation of WinCertStore whose flags can be extended
+// with RawFlags.
+type FlagOp int
+
+const (
+	// FlagOpOpenStore adds CertOpenStore flags to the stores opened by a
+	// WinCertStore.
+	FlagOpOpenStore FlagOp = iota
+	// FlagOpOpenKey adds NCryptOpenKey flags when keys are opened.
+	FlagOpOpenKey
+	// FlagOpCreateKey adds NCryptCreatePersistedKey flags when keys are
+	// generated.
+	FlagOpCreateKey
+	// FlagOpFinalizeKey adds NCryptFinalizeKey flags when keys are generated.
+	FlagOpFinalizeKey
+)
+
+func (o FlagOp) String() string {
+	switch o {
+	case FlagOpOpenStore:
+		return "CertOpenStore"
+	case FlagOpOpenKey:
+		return "NCryptOpenKey"
+	case FlagOpCreateKey:
+		return "NCryptCreatePersistedKey"
+	case FlagOpFinalizeKey:
+		return "NCryptFinalizeKey"
+	default:
+		return fmt.Sprintf("FlagOp(%d)", int(o))
+	}
+}
+
+// RawFlags are additional documented flags passed to the Windows API of an
+// operation, for callers that need a flag the package does not expose as an
+// option. They are added to the flags the package sets itself and are
+// checked against an allow-list when the store is opened.
+type RawFlags map[FlagOp]uint32
+
+// allowedRawFlags are the flags RawFlags may contain for each operation.
+// Flags that change which object an operation acts on, or that conflict with
+// the flags the package sets itself, are not allowed.
+var allowedRawFlags = map[FlagOp]uint32{
+	FlagOpOpenStore: 0x00000080 | // CERT_STORE_SHARE_CONTEXT_FLAG
+		0x00000200 | // CERT_STORE_ENUM_ARCHIVED_FLAG
+		0x00000800 | // CERT_STORE_BACKUP_RESTORE_FLAG
+		0x00001000, // CERT_STORE_MAXIMUM_ALLOWED_FLAG
+	FlagOpOpenKey: 0x00000040, // NCRYPT_SILENT_FLAG
+	FlagOpCreateKey: 0x00000040 | // NCRYPT_SILENT_FLAG
+		0x00010000, // NCRYPT_PREFER_VBS_FLAG
+	FlagOpFinalizeKey: 0x00000008 | // NCRYPT_NO_KEY_VALIDATION
+		0x00000040 | // NCRYPT_SILENT_FLAG
+		0x00000200, // NCRYPT_WRITE_KEY_TO_LEGACY_STORE_FLAG
+}
+
+// validate returns an error if f contains an unknown operation or a flag that
+// is not allowed for its operation.
+func (f RawFlags) validate() error {
+	for op, flags := range f {
+		allowed, ok := allowedRawFlags[op]
+		if !ok {
+			return fmt.Errorf("raw flags are not supported for %v", op)
+		}
+		if extra := flags &^ allowed; extra != 0 {
+			return fmt.Errorf("raw flags %#x are not allowed for %v", extra, op)
+		}
+	}
+	return nil
+}
+
+// get returns the raw flags for op, or 0 if there are none.
+func (f RawFlags) get(op FlagOp) uint32 {
+	return f[op]
+}
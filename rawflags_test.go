@@ -0,0 +1,42 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"testing"
+)
+
+func TestRawFlagsValidate(t *testing.T) {
+	tests := []struct {
+		flags RawFlags
+		ok    bool
+	}{
+		{nil, true},
+		{RawFlags{FlagOpOpenStore: 0x1000}, true},
+		{RawFlags{FlagOpOpenStore: 0x8000}, false},
+		{RawFlags{FlagOpOpenKey: 0x40, FlagOpFinalizeKey: 0x8 | 0x40}, true},
+		{RawFlags{FlagOpOpenKey: 0x20}, false},
+		{RawFlags{FlagOpCreateKey: 0x80}, false},
+		{RawFlags{FlagOp(9): 0}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.flags.validate(); (err == nil) != tt.ok {
+			t.Errorf("RawFlags(%v).validate() returned %v, want success: %t", tt.flags, err, tt.ok)
+		}
+	}
+	if got := RawFlags(nil).get(FlagOpOpenKey); got != 0 {
+		t.Errorf("RawFlags(nil).get(FlagOpOpenKey) = %#x, want 0", got)
+	}
+}
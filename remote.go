@@ -0,0 +1,162 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// remoteHandshakeTimeout bounds the TLS handshake of remote clients.
+const remoteHandshakeTimeout = 30 * time.Second
+
+// RemoteOptions configures ServeRemote.
+type RemoteOptions struct {
+	// TLS holds the certificate of the server and the ClientCAs that issue
+	// the client certificates. Client certificates are always required and
+	// verified, whatever ClientAuth is set to.
+	TLS *tls.Config
+	// Clients are the SPKI pins of the client certificates that may manage
+	// the host, see SPKIPin. Clients with a verified certificate for another
+	// key are rejected.
+	Clients [][]byte
+}
+
+// serverConfig returns the TLS configuration of the server.
+func (o RemoteOptions) serverConfig() (*tls.Config, error) {
+	if o.TLS == nil || (len(o.TLS.Certificates) == 0 && o.TLS.GetCertificate == nil) {
+		return nil, &ArgError{Op: "ServeRemote", Arg: "opts", Reason: "no server certificate configured"}
+	}
+	if o.TLS.ClientCAs == nil {
+		return nil, &ArgError{Op: "ServeRemote", Arg: "opts", Reason: "no client CAs configured"}
+	}
+	if len(o.Clients) == 0 {
+		return nil, &ArgError{Op: "ServeRemote", Arg: "opts", Reason: "no clients configured"}
+	}
+	for _, pin := range o.Clients {
+		if len(pin) != sha256.Size {
+			return nil, &ArgError{Op: "ServeRemote", Arg: "opts", Reason: fmt.Sprintf("client pin has %d bytes, want %d", len(pin), sha256.Size)}
+		}
+	}
+	cfg := o.TLS.Clone()
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	if cfg.MinVersion < tls.VersionTLS12 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+	return cfg, nil
+}
+
+// serveRemote answers the requests of the clients that connect to l until
+// ctx is done.
+func serveRemote(ctx context.Context, l net.Listener, s *helperSession, opts RemoteOptions) error {
+	cfg, err := opts.serverConfig()
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.Close()
+		case <-done:
+		}
+	}()
+	logInfo("Started remote management server.", opField("serveremote"), field("address", l.Addr()))
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				logInfo("Stopped remote management server.", opField("serveremote"), field("address", l.Addr()))
+				return nil
+			}
+			return err
+		}
+		go func() {
+			if err := serveRemoteConn(conn, cfg, s, opts.Clients); err != nil {
+				logWarning("Remote management connection failed.", opField("serveremote"), field("remote", conn.RemoteAddr()), errField(err))
+			}
+		}()
+	}
+}
+
+// serveRemoteConn authenticates the client of conn and answers its
+// requests. It closes conn.
+func serveRemoteConn(conn net.Conn, cfg *tls.Config, s *helperSession, clients [][]byte) error {
+	tc := tls.Server(conn, cfg)
+	defer tc.Close()
+	tc.SetDeadline(time.Now().Add(remoteHandshakeTimeout))
+	if err := tc.Handshake(); err != nil {
+		return err
+	}
+	tc.SetDeadline(time.Time{})
+	leaf := tc.ConnectionState().PeerCertificates[0]
+	pin := SPKIPin(leaf)
+	allowed := false
+	for _, c := range clients {
+		if bytes.Equal(c, pin) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("client %q is not allowed to manage this host", leaf.Subject)
+	}
+	logDebug("Accepted remote management client.", opField("serveremote"), field("remote", conn.RemoteAddr()), field("client", leaf.Subject.String()))
+	return serveHelperConn(tc, s, hex.EncodeToString(pin))
+}
+
+// RemoteClient invokes operations on a host that runs ServeRemote. A request
+// whose connection fails is resent once on a new connection, and the server
+// executes it at most once. Its methods are safe for concurrent use.
+type RemoteClient struct {
+	*helperConn
+}
+
+// DialRemote connects to the remote management server at addr. cfg must
+// hold the client certificate and verify the server, for example with
+// Pins.TLSConfig.
+func DialRemote(addr string, cfg *tls.Config) (*RemoteClient, error) {
+	if cfg == nil || (len(cfg.Certificates) == 0 && cfg.GetClientCertificate == nil) {
+		return nil, &ArgError{Op: "DialRemote", Arg: "cfg", Reason: "no client certificate configured"}
+	}
+	if cfg.InsecureSkipVerify {
+		return nil, &ArgError{Op: "DialRemote", Arg: "cfg", Reason: "the server certificate must be verified"}
+	}
+	cfg = cfg.Clone()
+	if cfg.MinVersion < tls.VersionTLS12 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+	c := &helperConn{dial: func() (io.ReadWriteCloser, error) {
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: remoteHandshakeTimeout}, "tcp", addr, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to %s: %v", addr, err)
+		}
+		return conn, nil
+	}}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return &RemoteClient{c}, nil
+}
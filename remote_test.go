@@ -0,0 +1,147 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRemote(t *testing.T) {
+	now := time.Now()
+	serial := int64(0)
+	issue := func(tmpl, parent *x509.Certificate, key, signer *ecdsa.PrivateKey) tls.Certificate {
+		serial++
+		tmpl.SerialNumber = big.NewInt(serial)
+		tmpl.NotBefore = now.Add(-time.Hour)
+		tmpl.NotAfter = now.Add(time.Hour)
+		if parent == nil {
+			parent = tmpl
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+		if err != nil {
+			t.Fatalf("failed to create test certificate: %v", err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatalf("failed to parse test certificate: %v", err)
+		}
+		return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}
+	}
+	newKey := func() *ecdsa.PrivateKey {
+		k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("failed to generate test key: %v", err)
+		}
+		return k
+	}
+
+	caKey := newKey()
+	ca := issue(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: "ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, caKey, caKey)
+	server := issue(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca.Leaf, newKey(), caKey)
+	clientAuth := []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	client := issue(&x509.Certificate{Subject: pkix.Name{CommonName: "client"}, ExtKeyUsage: clientAuth}, ca.Leaf, newKey(), caKey)
+	other := issue(&x509.Certificate{Subject: pkix.Name{CommonName: "other"}, ExtKeyUsage: clientAuth}, ca.Leaf, newKey(), caKey)
+	stored := issue(&x509.Certificate{Subject: pkix.Name{CommonName: "stored"}}, ca.Leaf, newKey(), caKey)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	opts := RemoteOptions{
+		TLS:     &tls.Config{Certificates: []tls.Certificate{server}, ClientCAs: pool},
+		Clients: [][]byte{SPKIPin(client.Leaf)},
+	}
+	var got []*helperRequest
+	s := newHelperSession(func(req *helperRequest, resp *helperResponse) error {
+		got = append(got, req)
+		resp.Result = &Result{Operation: req.Op}
+		if req.Op == HelperOpInventory {
+			resp.Certs = [][]byte{stored.Leaf.Raw}
+		}
+		return nil
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serveRemote(ctx, l, s, opts) }()
+	defer func() {
+		cancel()
+		if err := <-served; err != nil {
+			t.Errorf("serveRemote returned %v", err)
+		}
+	}()
+
+	rc, err := DialRemote(l.Addr().String(), &tls.Config{Certificates: []tls.Certificate{client}, RootCAs: pool})
+	if err != nil {
+		t.Fatalf("DialRemote returned %v", err)
+	}
+	defer rc.Close()
+	certs, err := rc.Inventory(`LocalMachine\MY`, InventoryFilter{})
+	if err != nil {
+		t.Fatalf("Inventory returned %v", err)
+	}
+	if len(certs) != 1 || !certs[0].Equal(stored.Leaf) {
+		t.Errorf("Inventory returned %v, want the stored certificate", certs)
+	}
+	if _, err := rc.Store(stored.Leaf, ca.Leaf); err != nil {
+		t.Errorf("Store returned %v", err)
+	}
+	if len(got) != 2 || got[0].Store != `LocalMachine\MY` || got[1].Op != HelperOpStore {
+		t.Errorf("server executed %+v, want an inventory and a store request", got)
+	}
+
+	// A client with a valid certificate for a key that is not pinned gets no
+	// answer.
+	oc, err := DialRemote(l.Addr().String(), &tls.Config{Certificates: []tls.Certificate{other}, RootCAs: pool})
+	if err == nil {
+		_, err = oc.Inventory(`LocalMachine\MY`, InventoryFilter{})
+		oc.Close()
+	}
+	if err == nil {
+		t.Error("unpinned client was able to manage the host")
+	}
+	if len(got) != 2 {
+		t.Errorf("server executed %d requests, want 2", len(got))
+	}
+
+	if _, err := DialRemote(l.Addr().String(), &tls.Config{RootCAs: pool}); err == nil {
+		t.Error("DialRemote without a client certificate succeeded")
+	}
+	if err := serveRemote(ctx, l, s, RemoteOptions{TLS: opts.TLS}); err == nil {
+		t.Error("serveRemote without clients succeeded")
+	}
+}
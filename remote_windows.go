@@ -0,0 +1,32 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"context"
+	"net"
+)
+
+// ServeRemote runs the remote management server of the host on l until ctx
+// is done, so that a central service can take inventory, store, remove and
+// rotate certificates with store through a RemoteClient. Clients must present
+// a certificate issued by opts.TLS.ClientCAs for a key pinned in
+// opts.Clients. A request that a client resends after a lost connection is
+// executed once.
+func ServeRemote(ctx context.Context, l net.Listener, store AdminStore, opts RemoteOptions) error {
+	return serveRemote(ctx, l, newHelperSession(helperExec(store)), opts)
+}
@@ -0,0 +1,301 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+)
+
+var (
+	oidExtKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+
+	// extKeyUsageOIDs maps the extended key usages a RequestTemplate can
+	// request to their OIDs.
+	extKeyUsageOIDs = map[x509.ExtKeyUsage]asn1.ObjectIdentifier{
+		x509.ExtKeyUsageServerAuth:      {1, 3, 6, 1, 5, 5, 7, 3, 1},
+		x509.ExtKeyUsageClientAuth:      {1, 3, 6, 1, 5, 5, 7, 3, 2},
+		x509.ExtKeyUsageCodeSigning:     {1, 3, 6, 1, 5, 5, 7, 3, 3},
+		x509.ExtKeyUsageEmailProtection: {1, 3, 6, 1, 5, 5, 7, 3, 4},
+		x509.ExtKeyUsageTimeStamping:    {1, 3, 6, 1, 5, 5, 7, 3, 8},
+		x509.ExtKeyUsageOCSPSigning:     {1, 3, 6, 1, 5, 5, 7, 3, 9},
+	}
+)
+
+// RequestTemplate describes a certificate request, so that enrollment
+// configuration can be kept as data. It is rendered to a PKCS #10 request
+// with CSR, or to a certreq.exe INF file for CertEnroll with INF.
+type RequestTemplate struct {
+	Subject        pkix.Name
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []net.IP
+	URIs           []*url.URL
+	// ExtKeyUsage and UnknownExtKeyUsage are the requested extended key usages.
+	ExtKeyUsage        []x509.ExtKeyUsage
+	UnknownExtKeyUsage []asn1.ObjectIdentifier
+	// Key describes the key to generate for the request.
+	Key GenerateOpts
+	// Template is the certificate template to enroll with, either by Name or
+	// by ID and version. It may be nil.
+	Template *CertTemplate
+	// Attestation requests a TPM key whose attestation is sent with the
+	// request, as required by CAs that enforce key attestation. CertEnroll
+	// creates the attestation itself, CSR embeds AttestationStatement.
+	Attestation bool
+	// AttestationStatement is the key attestation statement embedded by CSR,
+	// see RsaKey.AttestationClaim.
+	AttestationStatement []byte
+	// AttestationFormat selects how CSR embeds AttestationStatement.
+	AttestationFormat AttestationFormat
+	// ChallengePassword is sent in the challengePassword attribute of the
+	// request, as required by SCEP servers such as NDES. A one-time password
+	// is wiped once CSR used it. It cannot be rendered to an INF file.
+	ChallengePassword *ChallengePassword
+}
+
+// Validate checks that t describes a complete request.
+func (t *RequestTemplate) Validate() error {
+	if t.Subject.CommonName == "" && len(t.DNSNames)+len(t.EmailAddresses)+len(t.IPAddresses)+len(t.URIs) == 0 {
+		return errors.New("request has neither a common name nor a subject alternative name")
+	}
+	if _, _, err := keyParams(t.Key); err != nil {
+		return err
+	}
+	if _, err := t.extKeyUsages(); err != nil {
+		return err
+	}
+	if t.Template != nil {
+		if (t.Template.Name == "") == (len(t.Template.ID) == 0) {
+			return errors.New("template must have exactly one of a name or an ID")
+		}
+	}
+	if t.Attestation && t.Key.Lifetime != KeyPersisted {
+		return fmt.Errorf("attestation is not supported for %v keys", t.Key.Lifetime)
+	}
+	switch t.AttestationFormat {
+	case AttestationAttribute, AttestationExtension:
+	default:
+		return fmt.Errorf("unsupported attestation format %v", t.AttestationFormat)
+	}
+	if t.ChallengePassword != nil {
+		if err := t.ChallengePassword.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extKeyUsages returns the OIDs of all requested extended key usages.
+func (t *RequestTemplate) extKeyUsages() ([]asn1.ObjectIdentifier, error) {
+	var oids []asn1.ObjectIdentifier
+	for _, eku := range t.ExtKeyUsage {
+		oid, ok := extKeyUsageOIDs[eku]
+		if !ok {
+			return nil, fmt.Errorf("unsupported extended key usage %d", eku)
+		}
+		oids = append(oids, oid)
+	}
+	return append(oids, t.UnknownExtKeyUsage...), nil
+}
+
+// templateExtension returns the certificate template extension for t.
+func (t *RequestTemplate) templateExtension() (pkix.Extension, error) {
+	if t.Template.Name != "" {
+		v, err := encodeBMPString(t.Template.Name)
+		return pkix.Extension{Id: oidCertTypeExtension, Value: v}, err
+	}
+	v, err := asn1.Marshal(struct {
+		ID           asn1.ObjectIdentifier
+		MajorVersion int
+		MinorVersion int `asn1:"optional"`
+	}{t.Template.ID, t.Template.MajorVersion, t.Template.MinorVersion})
+	return pkix.Extension{Id: oidCertificateTemplate, Value: v}, err
+}
+
+// CSR renders t to a DER encoded PKCS #10 request signed by signer, whose
+// key should have been generated with t.Key. Attested requests carry
+// t.AttestationStatement in t.AttestationFormat, so that the CA can verify
+// the key in the same round trip.
+func (t *RequestTemplate) CSR(rand io.Reader, signer crypto.Signer) ([]byte, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	if t.Attestation && len(t.AttestationStatement) == 0 {
+		return nil, errNoAttestationStatement
+	}
+	req := &x509.CertificateRequest{
+		Subject:        t.Subject,
+		DNSNames:       t.DNSNames,
+		EmailAddresses: t.EmailAddresses,
+		IPAddresses:    t.IPAddresses,
+		URIs:           t.URIs,
+	}
+	ekus, err := t.extKeyUsages()
+	if err != nil {
+		return nil, err
+	}
+	if len(ekus) > 0 {
+		v, err := asn1.Marshal(ekus)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode extended key usages: %v", err)
+		}
+		req.ExtraExtensions = append(req.ExtraExtensions, pkix.Extension{Id: oidExtKeyUsage, Value: v})
+	}
+	if t.Template != nil {
+		ext, err := t.templateExtension()
+		if err != nil {
+			return nil, fmt.Errorf("could not encode certificate template: %v", err)
+		}
+		req.ExtraExtensions = append(req.ExtraExtensions, ext)
+	}
+	if t.Attestation && t.AttestationFormat == AttestationExtension {
+		ext, err := csrAttestationExtension(t.AttestationStatement)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode attestation statement: %v", err)
+		}
+		req.ExtraExtensions = append(req.ExtraExtensions, ext)
+	}
+	der, err := x509.CreateCertificateRequest(rand, req, signer)
+	if err != nil {
+		return nil, err
+	}
+
+	// x509 cannot encode arbitrary attributes, they are added to the signed
+	// request, which is then signed again.
+	var attrs []csrAttribute
+	if t.ChallengePassword != nil {
+		v, err := t.ChallengePassword.der()
+		if err != nil {
+			return nil, err
+		}
+		defer wipe(v)
+		attrs = append(attrs, csrAttribute{Type: oidChallengePassword, Values: []asn1.RawValue{{FullBytes: v}}})
+	}
+	if t.Attestation && t.AttestationFormat == AttestationAttribute {
+		v, err := asn1.Marshal(t.AttestationStatement)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode attestation statement: %v", err)
+		}
+		attrs = append(attrs, csrAttribute{Type: oidEnrollAttestationStatement, Values: []asn1.RawValue{{FullBytes: v}}})
+	}
+	if len(attrs) == 0 {
+		return der, nil
+	}
+	if der, err = addCSRAttributes(rand, der, signer, attrs); err != nil {
+		return nil, err
+	}
+	if t.ChallengePassword != nil && t.ChallengePassword.OneTime {
+		t.ChallengePassword.Wipe()
+	}
+	return der, nil
+}
+
+// INF renders t to a certreq.exe INF file, which CertEnroll uses to generate
+// the key in provider and create the request.
+func (t *RequestTemplate) INF(provider string) (string, error) {
+	if err := t.Validate(); err != nil {
+		return "", err
+	}
+	if t.Attestation && provider != ProviderMSPlatform {
+		return "", fmt.Errorf("attestation requires provider %q", ProviderMSPlatform)
+	}
+	if t.ChallengePassword != nil {
+		return "", errors.New("challenge passwords are not written to INF files")
+	}
+	algID, keySize, err := keyParams(t.Key)
+	if err != nil {
+		return "", err
+	}
+
+	var b bytes.Buffer
+	b.WriteString("[Version]\r\nSignature = \"$Windows NT$\"\r\n\r\n[NewRequest]\r\n")
+	fmt.Fprintf(&b, "Subject = %s\r\n", infQuote(t.Subject.String()))
+	fmt.Fprintf(&b, "KeyAlgorithm = %s\r\n", algID)
+	if keySize != 0 {
+		fmt.Fprintf(&b, "KeyLength = %d\r\n", keySize)
+	}
+	fmt.Fprintf(&b, "ProviderName = %s\r\n", infQuote(provider))
+	b.WriteString("MachineKeySet = TRUE\r\nExportable = FALSE\r\n")
+	if t.Attestation {
+		b.WriteString("RequestType = CMC\r\n")
+	} else {
+		b.WriteString("RequestType = PKCS10\r\n")
+	}
+
+	var san []string
+	for _, n := range t.DNSNames {
+		san = append(san, "dns="+n)
+	}
+	for _, e := range t.EmailAddresses {
+		san = append(san, "email="+e)
+	}
+	for _, ip := range t.IPAddresses {
+		san = append(san, "ipaddress="+ip.String())
+	}
+	for _, u := range t.URIs {
+		san = append(san, "url="+u.String())
+	}
+	var tmplExt *pkix.Extension
+	if t.Template != nil && t.Template.Name == "" {
+		ext, err := t.templateExtension()
+		if err != nil {
+			return "", fmt.Errorf("could not encode certificate template: %v", err)
+		}
+		tmplExt = &ext
+	}
+	if len(san) > 0 || tmplExt != nil {
+		b.WriteString("\r\n[Extensions]\r\n")
+		if len(san) > 0 {
+			b.WriteString("2.5.29.17 = \"{text}\"\r\n")
+			for _, s := range san {
+				fmt.Fprintf(&b, "_continue_ = %s\r\n", infQuote(s+"&"))
+			}
+		}
+		if tmplExt != nil {
+			fmt.Fprintf(&b, "%s = %s\r\n", tmplExt.Id, infQuote(base64.StdEncoding.EncodeToString(tmplExt.Value)))
+		}
+	}
+
+	ekus, err := t.extKeyUsages()
+	if err != nil {
+		return "", err
+	}
+	if len(ekus) > 0 {
+		b.WriteString("\r\n[EnhancedKeyUsageExtension]\r\n")
+		for _, oid := range ekus {
+			fmt.Fprintf(&b, "OID = %s\r\n", oid)
+		}
+	}
+	if t.Template != nil && t.Template.Name != "" {
+		fmt.Fprintf(&b, "\r\n[RequestAttributes]\r\nCertificateTemplate = %s\r\n", infQuote(t.Template.Name))
+	}
+	return b.String(), nil
+}
+
+// infQuote quotes s for an INF file, where quotes are escaped by doubling.
+func infQuote(s string) string {
+	return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+}
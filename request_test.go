@@ -0,0 +1,193 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"strings"
+	"testing"
+)
+
+func TestRequestTemplateCSR(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	tmplID := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 21, 8, 1, 2}
+	rt := &RequestTemplate{
+		Subject:     pkix.Name{CommonName: "host.example.com"},
+		DNSNames:    []string{"host.example.com"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		Key:         GenerateOpts{Algorithm: "ECDSA_P256"},
+		Template:    &CertTemplate{ID: tmplID, MajorVersion: 100, MinorVersion: 3},
+	}
+	der, err := rt.CSR(rand.Reader, key)
+	if err != nil {
+		t.Fatalf("CSR returned %v", err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatalf("failed to parse CSR: %v", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		t.Errorf("CSR signature did not verify: %v", err)
+	}
+	if len(csr.DNSNames) != 1 || csr.DNSNames[0] != "host.example.com" {
+		t.Errorf("CSR DNS names = %v, want [host.example.com]", csr.DNSNames)
+	}
+	// The extensions are read back the way they are read from issued certificates.
+	got, err := certTemplate(&x509.Certificate{Extensions: csr.Extensions})
+	if err != nil {
+		t.Fatalf("certTemplate returned %v", err)
+	}
+	if got == nil || !got.ID.Equal(tmplID) || got.MajorVersion != 100 || got.MinorVersion != 3 {
+		t.Errorf("CSR template = %+v, want %v version 100.3", got, tmplID)
+	}
+	var hasEKU bool
+	for _, ext := range csr.Extensions {
+		hasEKU = hasEKU || ext.Id.Equal(oidExtKeyUsage)
+	}
+	if !hasEKU {
+		t.Error("CSR has no extended key usage extension")
+	}
+
+	rt.Attestation = true
+	if _, err := rt.CSR(rand.Reader, key); err == nil {
+		t.Error("CSR with attestation but no statement succeeded, want error")
+	}
+}
+
+func TestRequestTemplateCSRAttestation(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	statement := []byte("KAST statement")
+	for _, format := range []AttestationFormat{AttestationAttribute, AttestationExtension} {
+		rt := &RequestTemplate{
+			Subject:              pkix.Name{CommonName: "host.example.com"},
+			Key:                  GenerateOpts{Algorithm: "RSA", KeySize: 2048},
+			Attestation:          true,
+			AttestationStatement: statement,
+			AttestationFormat:    format,
+		}
+		der, err := rt.CSR(rand.Reader, key)
+		if err != nil {
+			t.Fatalf("CSR with %v returned %v", format, err)
+		}
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			t.Fatalf("failed to parse CSR with %v: %v", format, err)
+		}
+		if err := csr.CheckSignature(); err != nil {
+			t.Errorf("CSR with %v signature did not verify: %v", format, err)
+		}
+		if csr.Subject.CommonName != "host.example.com" {
+			t.Errorf("CSR with %v has subject %v", format, csr.Subject)
+		}
+
+		var got []byte
+		if format == AttestationExtension {
+			for _, ext := range csr.Extensions {
+				if ext.Id.Equal(oidEnrollAttestationStatement) {
+					asn1.Unmarshal(ext.Value, &got)
+				}
+			}
+		} else {
+			var outer rawCSR
+			var info rawCSRInfo
+			if _, err := asn1.Unmarshal(der, &outer); err != nil {
+				t.Fatalf("failed to decode CSR: %v", err)
+			}
+			if _, err := asn1.Unmarshal(outer.TBS.FullBytes, &info); err != nil {
+				t.Fatalf("failed to decode CSR info: %v", err)
+			}
+			for _, raw := range info.Attributes {
+				var attr csrAttribute
+				if _, err := asn1.Unmarshal(raw.FullBytes, &attr); err == nil && attr.Type.Equal(oidEnrollAttestationStatement) && len(attr.Values) == 1 {
+					asn1.Unmarshal(attr.Values[0].FullBytes, &got)
+				}
+			}
+		}
+		if !bytes.Equal(got, statement) {
+			t.Errorf("CSR with %v carries statement %q, want: %q", format, got, statement)
+		}
+	}
+}
+
+func TestRequestTemplateINF(t *testing.T) {
+	rt := &RequestTemplate{
+		Subject:     pkix.Name{CommonName: "host.example.com", Organization: []string{`Example "Org"`}},
+		DNSNames:    []string{"host.example.com"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		Key:         GenerateOpts{Algorithm: "RSA", KeySize: 2048},
+		Template:    &CertTemplate{Name: "Machine"},
+		Attestation: true,
+	}
+	inf, err := rt.INF(ProviderMSPlatform)
+	if err != nil {
+		t.Fatalf("INF returned %v", err)
+	}
+	for _, want := range []string{
+		"KeyAlgorithm = RSA\r\n",
+		"KeyLength = 2048\r\n",
+		`ProviderName = "Microsoft Platform Crypto Provider"`,
+		"RequestType = CMC\r\n",
+		`_continue_ = "dns=host.example.com&"`,
+		"OID = 1.3.6.1.5.5.7.3.2\r\n",
+		`CertificateTemplate = "Machine"`,
+		`\""Org\""`,
+	} {
+		if !strings.Contains(inf, want) {
+			t.Errorf("INF does not contain %q:\n%s", want, inf)
+		}
+	}
+	if _, err := rt.INF(ProviderMSSoftware); err == nil {
+		t.Error("INF with attestation and the software provider succeeded, want error")
+	}
+}
+
+func TestRequestTemplateValidate(t *testing.T) {
+	valid := func() *RequestTemplate {
+		return &RequestTemplate{Subject: pkix.Name{CommonName: "host"}, Key: GenerateOpts{Algorithm: "ECDSA_P256"}}
+	}
+	if err := valid().Validate(); err != nil {
+		t.Fatalf("Validate returned %v", err)
+	}
+	for name, modify := range map[string]func(*RequestTemplate){
+		"no names":       func(rt *RequestTemplate) { rt.Subject = pkix.Name{} },
+		"no key":         func(rt *RequestTemplate) { rt.Key = GenerateOpts{} },
+		"unknown EKU":    func(rt *RequestTemplate) { rt.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageAny} },
+		"empty template": func(rt *RequestTemplate) { rt.Template = &CertTemplate{} },
+		"unknown format": func(rt *RequestTemplate) { rt.AttestationFormat = AttestationFormat(2) },
+		"ephemeral attestation": func(rt *RequestTemplate) {
+			rt.Attestation = true
+			rt.Key.Lifetime = KeyEphemeral
+		},
+	} {
+		rt := valid()
+		modify(rt)
+		if err := rt.Validate(); err == nil {
+			t.Errorf("Validate with %s succeeded, want error", name)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import "fmt"
+
+// Actions recorded in a Change.
+const (
+	// ActionAdded means a certificate was added to a store.
+	ActionAdded = "added"
+	// ActionRemoved means a certificate was removed from a store.
+	ActionRemoved = "removed"
+	// ActionGenerated means a new key was generated in a container.
+	ActionGenerated = "generated"
+	// ActionACLChanged means the access control list of a key was changed.
+	ActionACLChanged = "aclchanged"
+)
+
+// Change is a single state transition made by an operation.
+type Change struct {
+	Action string `json:"action"`
+	// Thumbprint is the SHA1 thumbprint of the certificate that changed. It
+	// is empty for changes to keys or CTLs.
+	Thumbprint string `json:"thumbprint,omitempty"`
+	// Store is the certificate store or key storage provider that changed.
+	Store string `json:"store"`
+}
+
+// Result describes the outcome of an operation in a form that orchestration
+// systems can record. The *WithResult methods return a Result even if the
+// operation failed part way, listing the changes made before the failure.
+type Result struct {
+	Operation string   `json:"operation"`
+	Changes   []Change `json:"changes,omitempty"`
+	// Container is the key container the operation worked with.
+	Container string `json:"container,omitempty"`
+	// Warnings lists problems that did not cause the operation to fail.
+	Warnings []string `json:"warnings,omitempty"`
+
+	// dryRun keeps the changes out of the audit log because they were
+	// only planned.
+	dryRun bool
+}
+
+// Changed reports whether the operation changed any state.
+func (r *Result) Changed() bool {
+	return len(r.Changes) > 0
+}
+
+func (r *Result) addChange(action, thumbprint, store string) {
+	c := Change{Action: action, Thumbprint: thumbprint, Store: store}
+	r.Changes = append(r.Changes, c)
+	if !r.dryRun {
+		r.audit(c)
+	}
+}
+
+// addAuditedChange records a change in r that was already written to the
+// audit log, such as the keys that generate records itself.
+func (r *Result) addAuditedChange(action, thumbprint, store string) {
+	r.Changes = append(r.Changes, Change{Action: action, Thumbprint: thumbprint, Store: store})
+}
+
+func (r *Result) warnf(format string, v ...interface{}) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, v...))
+}
+
+// merge appends the changes and warnings of other, which may be nil, to r.
+func (r *Result) merge(other *Result) {
+	if other == nil {
+		return
+	}
+	r.Changes = append(r.Changes, other.Changes...)
+	r.Warnings = append(r.Warnings, other.Warnings...)
+}
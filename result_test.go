@@ -0,0 +1,53 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestResultJSON(t *testing.T) {
+	r := &Result{Operation: "link"}
+	if r.Changed() {
+		t.Error("empty result reports a change")
+	}
+	r.addChange(ActionAdded, "7309859BA6BB16AA3BD00636FE3966D0753CC069", `CurrentUser\MY`)
+	r.warnf("association failed: %v", "access denied")
+	if !r.Changed() {
+		t.Error("result with a change reports no change")
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"operation":"link","changes":[{"action":"added","thumbprint":"7309859BA6BB16AA3BD00636FE3966D0753CC069","store":"CurrentUser\\MY"}],"warnings":["association failed: access denied"]}`
+	if string(b) != want {
+		t.Errorf("unexpected JSON got: %s, want: %s", b, want)
+	}
+}
+
+func TestResultMerge(t *testing.T) {
+	r := &Result{Operation: "provision"}
+	r.merge(nil)
+	other := &Result{Operation: "store"}
+	other.addChange(ActionAdded, "7309859BA6BB16AA3BD00636FE3966D0753CC069", `LocalMachine\MY`)
+	other.warnf("first")
+	r.merge(other)
+	if len(r.Changes) != 1 || len(r.Warnings) != 1 || r.Operation != "provision" {
+		t.Errorf("merged result = %+v, want one change and one warning of provision", r)
+	}
+}
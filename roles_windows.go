@@ -0,0 +1,196 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"time"
+)
+
+// ErrReadOnly is returned by keys opened through a ReadOnlyStore for
+// operations that would change machine state.
+var ErrReadOnly = errors.New("operation not permitted on a read-only store")
+
+// ReadOnlyStore is the part of WinCertStore that looks up certificates and
+// keys, builds chains and exports them, without changing the certificate
+// stores or key containers. Services that only use certificates should
+// depend on it rather than on WinCertStore.
+type ReadOnlyStore interface {
+	Cert() (*x509.Certificate, error)
+	CertWithSelection() (*x509.Certificate, *Selection, error)
+	CertInfo() (*CertInfo, error)
+	CertByFriendlyName(name string) (*x509.Certificate, error)
+	CertByContainer(container string) (*x509.Certificate, error)
+	Intermediate() (*x509.Certificate, error)
+	Root(issuer []string) (*x509.Certificate, error)
+	RotationPair() (*RotationPair, error)
+	Key() (Key, error)
+	Signer() (crypto.Signer, error)
+	KeyLocation() (*KeyLocation, error)
+	CertKey(cert *x509.Certificate) (Key, error)
+	CertWithContext() (*x509.Certificate, *CertContext, error)
+	Keys(tag string) ([]Key, error)
+	SupportedKeyLengths(alg string) (*KeyLengths, error)
+	SignatureSchemes() ([]tls.SignatureScheme, error)
+	CircuitBreakerStatus() BreakerStatus
+	Privileges() Privileges
+	CTLs(loc StoreLocation) ([]*CTL, error)
+	VerifySCTs(logs []CTLog) ([]SCTResult, error)
+	HostnameReport(hostnames []string, loc StoreLocation, warn time.Duration) (*BindingReport, error)
+	Snapshot(locs ...StoreLocation) (*Snapshot, error)
+	EnumerateCerts(loc StoreLocation, filter InventoryFilter, fn func(*x509.Certificate) error) error
+	EnumerateProvenance(loc StoreLocation, filter InventoryFilter, fn func(*x509.Certificate, *Provenance) error) error
+	ExportSerializedStore(loc StoreLocation) ([]byte, error)
+	WriteChainPEM(path string) error
+	ExportSST(path string) error
+	IdentityDocument(attestation []byte) (string, error)
+	WriteKeyStore(path string, opts KeyStoreOptions) error
+	WriteTrustStore(path string, opts KeyStoreOptions) error
+	ExportEncryptedPKCS8(opts PKCS8ExportOptions) ([]byte, error)
+	WatchKey(ctx context.Context) (<-chan KeyChange, error)
+	WatchRotation(ctx context.Context) (<-chan *x509.Certificate, error)
+}
+
+// AdminStore adds the operations that change the certificate stores and key
+// containers of the machine to ReadOnlyStore. It is implemented by
+// WinCertStore.
+type AdminStore interface {
+	ReadOnlyStore
+	Generate(keySize int, alg string) (crypto.Signer, error)
+	GenerateWithOpts(opts GenerateOpts) (crypto.Signer, error)
+	GenerateWithResult(keySize int, alg string) (crypto.Signer, *Result, error)
+	CertStorage() CertStorage
+	Store(cert *x509.Certificate, intermediate *x509.Certificate) error
+	StoreWithResult(cert *x509.Certificate, intermediate *x509.Certificate) (*Result, error)
+	Remove(removeSystem bool) error
+	RemoveWithResult(removeSystem bool) (*Result, error)
+	Link() error
+	LinkWithResult() (*Result, error)
+	Migrate(from, to StoreLocation, removeSource bool) error
+	AddCTL(encoded []byte, loc StoreLocation) (*Result, error)
+	ImportSerializedStore(data []byte, loc StoreLocation) (*Result, error)
+	AnnounceRotation() error
+	Reconcile(policy HousekeepingPolicy, loc StoreLocation, dryRun bool) ([]Violation, *Result, error)
+	SetKeyACL(access, sid, perm string) error
+	ProviderHandle() uintptr
+	SetFriendlyName(cert *x509.Certificate, name string) error
+	SetProvenance(cert *x509.Certificate, p *Provenance) error
+	RotateIfOlderThan(maxAge time.Duration) (*Result, error)
+	SyncFromDir(dir string, opts DirSyncOptions) (*Result, error)
+	ManageRoots(desired []*x509.Certificate) (*Result, error)
+}
+
+var _ AdminStore = &WinCertStore{}
+
+// OpenReadOnlyStore opens a store configured by opts that can only be used
+// for lookups and exports. ReadOnlyLookups is always set, so stores are
+// neither created nor opened for writing. The result cannot be converted back
+// to a WinCertStore, and the keys it returns fail Delete with ErrReadOnly.
+func OpenReadOnlyStore(opts WinCertStoreOptions) (ReadOnlyStore, error) {
+	opts.ReadOnlyLookups = true
+	w, err := OpenWinCertStoreWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return readOnlyStore{w}, nil
+}
+
+// readOnlyStore hides the AdminStore methods of a WinCertStore.
+type readOnlyStore struct {
+	ReadOnlyStore
+}
+
+func (s readOnlyStore) Key() (Key, error) {
+	return readOnlyKeyOf(s.ReadOnlyStore.Key())
+}
+
+func (s readOnlyStore) Signer() (crypto.Signer, error) {
+	k, err := s.Key()
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (s readOnlyStore) CertKey(cert *x509.Certificate) (Key, error) {
+	return readOnlyKeyOf(s.ReadOnlyStore.CertKey(cert))
+}
+
+func (s readOnlyStore) Keys(tag string) ([]Key, error) {
+	keys, err := s.ReadOnlyStore.Keys(tag)
+	for i, k := range keys {
+		keys[i] = readOnlyKey{k}
+	}
+	return keys, err
+}
+
+// readOnlyKey is a Key that cannot be deleted.
+type readOnlyKey struct {
+	Key
+}
+
+func readOnlyKeyOf(k Key, err error) (Key, error) {
+	if err != nil {
+		return nil, err
+	}
+	return readOnlyKey{k}, nil
+}
+
+// Delete returns ErrReadOnly.
+func (k readOnlyKey) Delete() error {
+	return ErrReadOnly
+}
+
+// Handle returns 0, because the raw handle would allow changing or deleting
+// the key.
+func (k readOnlyKey) Handle() uintptr {
+	return 0
+}
+
+// SetUseContext returns ErrReadOnly.
+func (k readOnlyKey) SetUseContext(tag string) error {
+	return ErrReadOnly
+}
+
+// Duplicate returns a read-only duplicate of k.
+func (k readOnlyKey) Duplicate() (Key, error) {
+	return readOnlyKeyOf(k.Key.Duplicate())
+}
+
+// SetKeyACL changes the permissions of the key container of w by wrapping
+// icacls, see RsaKey.SetACL. access is an icacls action such as "grant" or
+// "deny".
+func (w *WinCertStore) SetKeyACL(access, sid, perm string) error {
+	key, err := w.Key()
+	if err != nil {
+		return err
+	}
+	defer key.Close()
+
+	var path string
+	switch k := key.(type) {
+	case *RsaKey:
+		path = k.Container
+	case *EcdsaKey:
+		path = k.Container
+	}
+	return setAcl(w, access, sid, perm, path)
+}
@@ -0,0 +1,50 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"time"
+)
+
+// ManagedRootPrefix is the friendly name prefix of the roots installed by
+// ManageRoots. It is followed by the thumbprint, and namespaced like other
+// friendly names if the store has a namespace.
+const ManagedRootPrefix = "certtostore-root-"
+
+// desiredRoots returns the roots of desired that should be installed at
+// now, keyed by thumbprint. Expired roots are left out with a warning, so
+// that they are removed like retired ones.
+func desiredRoots(desired []*x509.Certificate, now time.Time) (map[string]*x509.Certificate, []string, error) {
+	want := make(map[string]*x509.Certificate)
+	var warnings []string
+	for i, c := range desired {
+		if err := checkCert("ManageRoots", fmt.Sprintf("desired[%d]", i), c); err != nil {
+			return nil, nil, err
+		}
+		if !c.IsCA || !bytes.Equal(c.RawSubject, c.RawIssuer) {
+			return nil, nil, &ArgError{Op: "ManageRoots", Arg: fmt.Sprintf("desired[%d]", i), Reason: fmt.Sprintf("%q is not a self-issued CA certificate", c.Subject)}
+		}
+		tp := thumbprint(c)
+		if now.After(c.NotAfter) {
+			warnings = append(warnings, fmt.Sprintf("root %s (%s) expired on %s and is not installed", tp, c.Subject, c.NotAfter.Format(time.RFC3339)))
+			continue
+		}
+		want[tp] = c
+	}
+	return want, warnings, nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestDesiredRoots(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	now := time.Now()
+	newCert := func(serial int64, isCA bool, notAfter time.Time) *x509.Certificate {
+		tmpl := &x509.Certificate{
+			SerialNumber:          big.NewInt(serial),
+			Subject:               pkix.Name{CommonName: "Root"},
+			NotBefore:             now.Add(-time.Hour),
+			NotAfter:              notAfter,
+			IsCA:                  isCA,
+			BasicConstraintsValid: true,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+		if err != nil {
+			t.Fatalf("failed to create test certificate: %v", err)
+		}
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	current := newCert(1, true, now.Add(time.Hour))
+	expired := newCert(2, true, now.Add(-time.Minute))
+
+	want, warnings, err := desiredRoots([]*x509.Certificate{current, expired, current}, now)
+	if err != nil {
+		t.Fatalf("desiredRoots returned %v", err)
+	}
+	if len(want) != 1 || want[thumbprint(current)] == nil {
+		t.Errorf("desiredRoots returned %d roots, want only the current one", len(want))
+	}
+	if len(warnings) != 1 {
+		t.Errorf("desiredRoots returned warnings %v, want one for the expired root", warnings)
+	}
+
+	if _, _, err := desiredRoots([]*x509.Certificate{newCert(3, false, now.Add(time.Hour))}, now); err == nil {
+		t.Error("desiredRoots accepted a certificate that is not a CA")
+	}
+	if _, _, err := desiredRoots([]*x509.Certificate{nil}, now); err == nil {
+		t.Error("desiredRoots accepted a nil certificate")
+	}
+	if want, _, err := desiredRoots(nil, now); err != nil || len(want) != 0 {
+		t.Errorf("desiredRoots(nil) = %v, %v, want no roots", want, err)
+	}
+}
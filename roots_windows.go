@@ -0,0 +1,43 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"time"
+)
+
+// ManageRoots makes the roots managed by the application in the ROOT store
+// of the machine match desired: desired roots that are missing are
+// installed, named ManagedRootPrefix followed by their thumbprint, and
+// managed roots that are no longer desired or have expired are removed.
+// Roots installed by anyone else are never touched. Every call converges the
+// full set, so retiring a root means leaving it out of desired. The Result
+// lists the changes, even if a later change failed, and warns about expired
+// roots.
+func (w *WinCertStore) ManageRoots(desired []*x509.Certificate) (*Result, error) {
+	res := &Result{Operation: "manageroots"}
+	want, warnings, err := desiredRoots(desired, time.Now())
+	if err != nil {
+		return res, err
+	}
+	for _, warning := range warnings {
+		res.warnf("%s", warning)
+	}
+	prefix := namespacedName(w.namespace, ManagedRootPrefix)
+	return res, w.syncStore(StoreLocation{LocationLocalMachine, "ROOT"}, want, prefix, false, res)
+}
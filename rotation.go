@@ -0,0 +1,67 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"sort"
+	"time"
+)
+
+// RotationPair holds the certificates in use while a certificate is rotated.
+// Keeping the replaced certificate current for an overlap window avoids a
+// hard cutover that breaks in-flight mTLS sessions.
+type RotationPair struct {
+	// Current is the certificate to use now.
+	Current *x509.Certificate
+	// Next is the certificate that becomes current at OverlapEnds. It is nil
+	// outside of an overlap window.
+	Next        *x509.Certificate
+	OverlapEnds time.Time
+}
+
+// rotationPair picks the current and next certificate among certs at now.
+// The newest valid certificate is current, unless it was issued less than
+// overlap ago. Then it is next, and the valid certificate that expires last
+// among the older ones stays current until the overlap ends or it expires.
+// It returns nil if no certificate is valid.
+func rotationPair(certs []*x509.Certificate, overlap time.Duration, now time.Time) *RotationPair {
+	var valid []*x509.Certificate
+	for _, c := range certs {
+		if !now.Before(c.NotBefore) && now.Before(c.NotAfter) {
+			valid = append(valid, c)
+		}
+	}
+	if len(valid) == 0 {
+		return nil
+	}
+	sort.SliceStable(valid, func(i, j int) bool { return valid[i].NotBefore.Before(valid[j].NotBefore) })
+	newest := valid[len(valid)-1]
+	ends := newest.NotBefore.Add(overlap)
+	if len(valid) == 1 || !now.Before(ends) {
+		return &RotationPair{Current: newest}
+	}
+
+	prev := valid[0]
+	for _, c := range valid[:len(valid)-1] {
+		if c.NotAfter.After(prev.NotAfter) {
+			prev = c
+		}
+	}
+	if prev.NotAfter.Before(ends) {
+		ends = prev.NotAfter
+	}
+	return &RotationPair{Current: prev, Next: newest, OverlapEnds: ends}
+}
@@ -0,0 +1,63 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestRotationPair(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	cert := func(issued time.Duration, lifetime time.Duration) *x509.Certificate {
+		return &x509.Certificate{NotBefore: now.Add(-issued), NotAfter: now.Add(-issued + lifetime)}
+	}
+	old := cert(300*24*time.Hour, 365*24*time.Hour)
+	older := cert(400*24*time.Hour, 500*24*time.Hour)
+	fresh := cert(time.Hour, 365*24*time.Hour)
+	expired := cert(400*24*time.Hour, 365*24*time.Hour)
+	future := cert(-time.Hour, 365*24*time.Hour)
+
+	tests := []struct {
+		name        string
+		certs       []*x509.Certificate
+		overlap     time.Duration
+		wantCurrent *x509.Certificate
+		wantNext    *x509.Certificate
+		wantEnds    time.Time
+	}{
+		{"single", []*x509.Certificate{old}, 24 * time.Hour, old, nil, time.Time{}},
+		{"in overlap", []*x509.Certificate{fresh, old}, 24 * time.Hour, old, fresh, fresh.NotBefore.Add(24 * time.Hour)},
+		{"after overlap", []*x509.Certificate{old, fresh}, 30 * time.Minute, fresh, nil, time.Time{}},
+		{"no overlap", []*x509.Certificate{old, fresh}, 0, fresh, nil, time.Time{}},
+		{"previous expires last", []*x509.Certificate{older, old, fresh}, 24 * time.Hour, older, fresh, fresh.NotBefore.Add(24 * time.Hour)},
+		{"overlap capped by expiry", []*x509.Certificate{old, fresh}, 100 * 24 * time.Hour, old, fresh, old.NotAfter},
+		{"invalid ignored", []*x509.Certificate{expired, future, old}, 24 * time.Hour, old, nil, time.Time{}},
+	}
+	for _, tt := range tests {
+		got := rotationPair(tt.certs, tt.overlap, now)
+		if got == nil {
+			t.Errorf("%s: rotationPair returned nil", tt.name)
+			continue
+		}
+		if got.Current != tt.wantCurrent || got.Next != tt.wantNext || !got.OverlapEnds.Equal(tt.wantEnds) {
+			t.Errorf("%s: rotationPair = %+v, want current %p, next %p, ends %v", tt.name, got, tt.wantCurrent, tt.wantNext, tt.wantEnds)
+		}
+	}
+	if got := rotationPair([]*x509.Certificate{expired}, time.Hour, now); got != nil {
+		t.Errorf("rotationPair without valid certificates = %+v, want nil", got)
+	}
+}
@@ -0,0 +1,112 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"fmt"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// RotationPair returns the current and next certificate of the configured
+// issuers in the local machine MY store. While a new certificate is within
+// the RotationOverlap of w, the certificate it replaces stays current and
+// the new one is returned as next, so that servers can keep using the old
+// certificate and key while clients learn about the new one. It returns nil
+// if there is no valid certificate.
+func (w *WinCertStore) RotationPair() (*RotationPair, error) {
+	certStore, err := windows.CertOpenStore(
+		certStoreProvSystem,
+		0,
+		0,
+		certStoreLocalMachine|w.lookupFlags(),
+		uintptr(unsafe.Pointer(my)))
+	if err != nil {
+		return nil, fmt.Errorf("rotation: CertOpenStore returned %v", err)
+	}
+	defer windows.CertCloseStore(certStore, 0)
+
+	var certs []*x509.Certificate
+	for _, issuer := range w.issuerList() {
+		// findIssuedCert frees prev, and the last call returns nil.
+		var prev *windows.CertContext
+		for {
+			nc, err := w.findIssuedCert(certStore, issuer, prev)
+			if err != nil {
+				return nil, fmt.Errorf("finding certificates: %v", err)
+			}
+			if nc == nil {
+				break
+			}
+			prev = nc
+			if !w.keyUsageFilter.match(keyUsageFromCAPI(intendedKeyUsage(encodingX509ASN, nc))) {
+				continue
+			}
+			xc, err := x509.ParseCertificate(certContextBytes(nc))
+			if err != nil {
+				continue
+			}
+			certs = append(certs, xc)
+		}
+	}
+	return rotationPair(certs, w.rotationOverlap, time.Now()), nil
+}
+
+// CertKey opens the private key associated with cert in the local machine MY
+// store, such as the key of the Current or Next certificate of a
+// RotationPair. The key must be in the provider of w. The key of a
+// certificate enrolled after RotateIfOlderThan is in the other container of
+// the rotation pair, so Current keeps signing with the old key while Next
+// uses the new one.
+func (w *WinCertStore) CertKey(cert *x509.Certificate) (Key, error) {
+	if err := checkCert("CertKey", "cert", cert); err != nil {
+		return nil, err
+	}
+	props, err := OpenCertProperties(StoreLocation{Location: LocationLocalMachine, Name: "MY"}, cert)
+	if err != nil {
+		return nil, err
+	}
+	defer props.Close()
+
+	ki, err := props.KeyProvInfo()
+	if err != nil {
+		return nil, err
+	}
+	return w.provInfoKey(cert, ki)
+}
+
+// provInfoKey opens the key that ki, the key provider information of cert,
+// refers to.
+func (w *WinCertStore) provInfoKey(cert *x509.Certificate, ki *KeyProvInfo) (Key, error) {
+	if ki == nil {
+		return nil, fmt.Errorf("certificate %s has no associated key", thumbprint(cert))
+	}
+	if ki.Provider != w.ProvName {
+		return nil, fmt.Errorf("key of certificate %s is in provider %q, not %q", thumbprint(cert), ki.Provider, w.ProvName)
+	}
+	if !inNamespace(w.namespace, ki.Container) {
+		return nil, fmt.Errorf("key of certificate %s is not in namespace %q", thumbprint(cert), w.namespace)
+	}
+	flags := w.keyOpenFlags()
+	if ki.Machine {
+		flags |= nCryptMachineKey
+	}
+	return w.openContainerKey(ki.Container, flags)
+}
@@ -0,0 +1,91 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRotationPairKeys(t *testing.T) {
+	container := fmt.Sprintf("certtostore-test-rotation-%d", time.Now().UnixNano())
+	w, err := OpenWinCertStoreWithOptions(WinCertStoreOptions{Provider: ProviderMSSoftware, Container: container})
+	if err != nil {
+		t.Skipf("opening the software key storage provider failed: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, name := range []string{container, RotationContainerName(container)} {
+			if k, err := w.containerKey(name); err == nil {
+				k.Delete()
+			}
+		}
+	})
+
+	oldKey, err := w.GenerateWithOpts(GenerateOpts{Algorithm: "RSA", KeySize: 2048})
+	if err != nil {
+		t.Fatalf("GenerateWithOpts returned %v", err)
+	}
+	oldCert := signedBy(t, oldKey)
+	oldKey.(Key).Close()
+
+	time.Sleep(10 * time.Millisecond)
+	res, err := w.RotateIfOlderThan(time.Nanosecond)
+	if err != nil {
+		t.Fatalf("RotateIfOlderThan returned %v", err)
+	}
+	if len(res.Changes) != 1 {
+		t.Fatalf("RotateIfOlderThan made %d changes, want 1", len(res.Changes))
+	}
+	newKey, err := w.containerKey(RotationContainerName(container))
+	if err != nil {
+		t.Fatalf("opening the rotated key failed: %v", err)
+	}
+	newCert := signedBy(t, newKey)
+	newKey.Close()
+
+	pair := rotationPair([]*x509.Certificate{oldCert, newCert}, time.Hour, time.Now())
+	if pair == nil || pair.Current != oldCert || pair.Next != newCert {
+		t.Fatalf("rotationPair = %+v, want the old certificate current and the new one next", pair)
+	}
+	keys := []struct {
+		cert      *x509.Certificate
+		container string
+	}{
+		{pair.Current, container},
+		{pair.Next, RotationContainerName(container)},
+	}
+	for _, k := range keys {
+		key, err := w.provInfoKey(k.cert, &KeyProvInfo{Provider: ProviderMSSoftware, Container: k.container})
+		if err != nil {
+			t.Fatalf("provInfoKey(%s) returned %v", k.container, err)
+		}
+		digest := sha256.Sum256([]byte("rotation"))
+		sig, err := key.Sign(nil, digest[:], crypto.SHA256)
+		key.Close()
+		if err != nil {
+			t.Fatalf("signing with the key of %s returned %v", k.container, err)
+		}
+		if err := rsa.VerifyPKCS1v15(k.cert.PublicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], sig); err != nil {
+			t.Errorf("the key of %s does not sign for its certificate: %v", k.container, err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"math/big"
+	"sync/atomic"
+)
+
+// The package wipes and optionally locks sensitive buffers, but does not
+// compare secrets: the pins, thumbprints and key identifiers it compares are
+// public, and the keystore MAC is only computed, never verified. The only
+// comparison of a keyed value, the hashes of a keyed audit log, uses
+// hmac.Equal. Code added later that compares a secret, such as a PIN or a
+// MAC received from a peer, must use subtle.ConstantTimeCompare.
+
+// lockSensitive is set by SetLockSensitiveMemory.
+var lockSensitive int32
+
+// SetLockSensitiveMemory controls whether buffers holding private key
+// material, passphrases and derived keys are locked into physical memory
+// while the package works with them, so that they are not written to the page
+// file. Locking is best effort: it is only supported on Windows, it is limited
+// by the working set size of the process, and it works on whole pages. The
+// buffers are wiped after use whether or not they are locked.
+func SetLockSensitiveMemory(enabled bool) {
+	v := int32(0)
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&lockSensitive, v)
+}
+
+// protect locks b into memory if SetLockSensitiveMemory is enabled and
+// returns a function that wipes and unlocks it, for use as
+// defer protect(b)().
+func protect(b []byte) func() {
+	if len(b) == 0 || atomic.LoadInt32(&lockSensitive) == 0 {
+		return func() { wipe(b) }
+	}
+	if err := lockMemory(b); err != nil {
+		logDebug("Locking sensitive memory failed.", errField(err))
+		return func() { wipe(b) }
+	}
+	return func() {
+		wipe(b)
+		if err := unlockMemory(b); err != nil {
+			logDebug("Unlocking sensitive memory failed.", errField(err))
+		}
+	}
+}
+
+// Wipe overwrites b with zeros. Callers use it for the plaintexts returned by
+// Decrypt, the derived keys returned by DeriveKey and Derive, and the blobs
+// returned by ExportFullPrivateBlob once they are done with them.
+func Wipe(b []byte) {
+	wipe(b)
+}
+
+// wipe overwrites b with zeros.
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// wipeInt overwrites the magnitude of x with zeros.
+func wipeInt(x *big.Int) {
+	if x == nil {
+		return
+	}
+	b := x.Bits()
+	for i := range b {
+		b[i] = 0
+	}
+	x.SetInt64(0)
+}
+
+// wipePrivateKey overwrites the private values of a key exported from the
+// provider. The key cannot be used afterwards.
+func wipePrivateKey(key crypto.PrivateKey) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		wipeInt(k.D)
+		for _, p := range k.Primes {
+			wipeInt(p)
+		}
+		wipeInt(k.Precomputed.Dp)
+		wipeInt(k.Precomputed.Dq)
+		wipeInt(k.Precomputed.Qinv)
+		for _, v := range k.Precomputed.CRTValues {
+			wipeInt(v.Exp)
+			wipeInt(v.Coeff)
+			wipeInt(v.R)
+		}
+	case *ecdsa.PrivateKey:
+		wipeInt(k.D)
+	}
+}
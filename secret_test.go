@@ -0,0 +1,61 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+)
+
+func TestProtect(t *testing.T) {
+	for _, lock := range []bool{false, true} {
+		SetLockSensitiveMemory(lock)
+		b := []byte("passphrase")
+		protect(b)()
+		for _, c := range b {
+			if c != 0 {
+				t.Errorf("protect with locking %t did not wipe the buffer: %q", lock, b)
+				break
+			}
+		}
+	}
+	SetLockSensitiveMemory(false)
+	protect(nil)()
+}
+
+func TestWipePrivateKey(t *testing.T) {
+	rk, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ek, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wipePrivateKey(rk)
+	wipePrivateKey(ek)
+	if rk.D.Sign() != 0 || rk.Primes[0].Sign() != 0 || rk.Primes[1].Sign() != 0 || rk.Precomputed.Dp.Sign() != 0 {
+		t.Error("wipePrivateKey left RSA private values")
+	}
+	if ek.D.Sign() != 0 {
+		t.Error("wipePrivateKey left the ECDSA private value")
+	}
+	if rk.N.Sign() == 0 || ek.X.Sign() == 0 {
+		t.Error("wipePrivateKey wiped the public key")
+	}
+}
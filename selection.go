@@ -0,0 +1,151 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SelectionPolicy decides which certificate is used when the configured
+// issuers have more than one certificate.
+type SelectionPolicy int
+
+const (
+	// SelectIssuerOrder checks one certificate of each issuer, in the order
+	// the issuers are configured, and uses the first that is usable. The
+	// search for each issuer continues after the certificate found for the
+	// previous one. This is the default and how lookups always worked.
+	SelectIssuerOrder SelectionPolicy = iota
+	// SelectNewest uses the certificate with the latest NotBefore time among
+	// the certificates of all issuers.
+	SelectNewest
+	// SelectTemplate uses the first certificate, in issuer order, that was
+	// issued from CertSelection.Template. If there is none, it falls back to
+	// SelectIssuerOrder.
+	SelectTemplate
+	// SelectFirstUsable uses the first usable certificate of the first issuer
+	// that has one, in the order the issuers are configured. Unlike
+	// SelectIssuerOrder, it skips the unusable certificates of an issuer.
+	SelectFirstUsable
+)
+
+func (p SelectionPolicy) String() string {
+	switch p {
+	case SelectIssuerOrder:
+		return "issuer-order"
+	case SelectNewest:
+		return "newest"
+	case SelectTemplate:
+		return "template"
+	case SelectFirstUsable:
+		return "first-usable"
+	default:
+		return fmt.Sprintf("SelectionPolicy(%d)", int(p))
+	}
+}
+
+// CertSelection configures how a certificate is selected among the
+// certificates of the configured issuers.
+type CertSelection struct {
+	Policy SelectionPolicy
+	// Template is the name or dotted OID of the certificate template preferred
+	// by SelectTemplate.
+	Template string
+}
+
+func (s CertSelection) validate() error {
+	switch s.Policy {
+	case SelectIssuerOrder, SelectNewest, SelectFirstUsable:
+		return nil
+	case SelectTemplate:
+		if s.Template == "" {
+			return errors.New("the template selection policy requires a template")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported selection policy: %v", s.Policy)
+	}
+}
+
+// Selection describes which certificate a selection policy chose and why.
+type Selection struct {
+	// Issuer is the configured issuer the certificate was found for.
+	Issuer     string
+	Thumbprint string
+	// Candidates is the number of certificates that were considered. The
+	// SelectIssuerOrder and SelectFirstUsable policies stop at the first
+	// usable certificate.
+	Candidates int
+	Reason     string
+	// KeyUsage and ExtKeyUsage are the key usages of the selected certificate.
+	KeyUsage    x509.KeyUsage
+	ExtKeyUsage []x509.ExtKeyUsage
+}
+
+// certCandidate is a certificate found for one of the configured issuers.
+type certCandidate struct {
+	issuer string
+	cert   *x509.Certificate
+}
+
+// selectCert picks one of candidates, which must be in issuer order and not
+// empty, according to s.
+func selectCert(s CertSelection, candidates []certCandidate) (int, *Selection) {
+	i, reason := 0, fmt.Sprintf("first configured issuer with a certificate is %q", candidates[0].issuer)
+	switch s.Policy {
+	case SelectNewest:
+		for j, c := range candidates {
+			if c.cert.NotBefore.After(candidates[i].cert.NotBefore) {
+				i = j
+			}
+		}
+		reason = fmt.Sprintf("newest certificate, issued at %v", candidates[i].cert.NotBefore)
+	case SelectTemplate:
+		found := false
+		for j, c := range candidates {
+			if hasTemplate(c.cert, s.Template) {
+				i, found = j, true
+				break
+			}
+		}
+		if found {
+			reason = fmt.Sprintf("issued from template %q", s.Template)
+		} else {
+			reason = fmt.Sprintf("no certificate from template %q, %s", s.Template, reason)
+		}
+	}
+	return i, &Selection{
+		Issuer:      candidates[i].issuer,
+		Thumbprint:  thumbprint(candidates[i].cert),
+		Candidates:  len(candidates),
+		Reason:      reason,
+		KeyUsage:    candidates[i].cert.KeyUsage,
+		ExtKeyUsage: candidates[i].cert.ExtKeyUsage,
+	}
+}
+
+// hasTemplate reports whether cert was issued from the template with the
+// given name or dotted OID.
+func hasTemplate(cert *x509.Certificate, template string) bool {
+	tmpl, err := certTemplate(cert)
+	if err != nil || tmpl == nil {
+		return false
+	}
+	return (tmpl.Name != "" && strings.EqualFold(tmpl.Name, template)) ||
+		(tmpl.ID != nil && tmpl.ID.String() == template)
+}
@@ -0,0 +1,72 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+)
+
+func TestSelectCert(t *testing.T) {
+	// "Machine" as a DER encoded BMPString.
+	machine := []pkix.Extension{{Id: oidCertTypeExtension, Value: []byte{0x1e, 0x0e, 0, 'M', 0, 'a', 0, 'c', 0, 'h', 0, 'i', 0, 'n', 0, 'e'}}}
+	now := time.Now()
+	candidates := []certCandidate{
+		{"Old CA", &x509.Certificate{NotBefore: now.Add(-2 * time.Hour)}},
+		{"New CA", &x509.Certificate{NotBefore: now, Extensions: machine}},
+		{"New CA", &x509.Certificate{NotBefore: now.Add(-time.Hour)}},
+	}
+	tests := []struct {
+		sel        CertSelection
+		candidates []certCandidate
+		want       int
+	}{
+		{CertSelection{}, candidates, 0},
+		{CertSelection{Policy: SelectNewest}, candidates, 1},
+		{CertSelection{Policy: SelectTemplate, Template: "machine"}, candidates, 1},
+		{CertSelection{Policy: SelectTemplate, Template: "WebServer"}, candidates, 0},
+		{CertSelection{Policy: SelectNewest}, candidates[:1], 0},
+	}
+	for _, tt := range tests {
+		got, sel := selectCert(tt.sel, tt.candidates)
+		if got != tt.want {
+			t.Errorf("selectCert(%+v) = %d, want %d", tt.sel, got, tt.want)
+		}
+		if sel.Issuer != tt.candidates[tt.want].issuer || sel.Candidates != len(tt.candidates) || sel.Reason == "" {
+			t.Errorf("selectCert(%+v) returned unexpected selection %+v", tt.sel, sel)
+		}
+	}
+}
+
+func TestCertSelectionValidate(t *testing.T) {
+	tests := []struct {
+		sel CertSelection
+		ok  bool
+	}{
+		{CertSelection{}, true},
+		{CertSelection{Policy: SelectNewest}, true},
+		{CertSelection{Policy: SelectFirstUsable}, true},
+		{CertSelection{Policy: SelectTemplate}, false},
+		{CertSelection{Policy: SelectTemplate, Template: "Machine"}, true},
+		{CertSelection{Policy: SelectionPolicy(5)}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.sel.validate(); (err == nil) != tt.ok {
+			t.Errorf("%+v.validate() returned error %v, want success: %t", tt.sel, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var certDuplicateCertificateContext = crypt32.MustFindProc("CertDuplicateCertificateContext")
+
+// CertWithSelection is like Cert, but also reports which issuer the
+// certificate was selected for and why. The Selection is nil if there is no
+// certificate.
+func (w *WinCertStore) CertWithSelection() (*x509.Certificate, *Selection, error) {
+	cert, certContext, sel, err := w.selectCertContext(w.issuerList(), my, certStoreLocalMachine)
+	if err != nil {
+		return nil, nil, err
+	}
+	if certContext != nil {
+		windows.CertFreeCertificateContext(certContext)
+	}
+	return cert, sel, nil
+}
+
+// duplicateCertContext wraps CertDuplicateCertificateContext, which
+// increments the reference count of the context. The duplicate is the context
+// itself, so certContext is returned rather than converting the returned
+// address, or nil if the call failed.
+func duplicateCertContext(certContext *windows.CertContext) *windows.CertContext {
+	h, _, _ := certDuplicateCertificateContext.Call(uintptr(unsafe.Pointer(certContext)))
+	if h == 0 {
+		return nil
+	}
+	return certContext
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// serializedStoreMagic is the "CERT" signature in the header of a store
+	// saved with CERT_STORE_SAVE_AS_STORE.
+	serializedStoreMagic = 0x54524543
+	// Element IDs of a serialized store.
+	serializedEnd  = 0  // end of the store
+	serializedCert = 32 // CERT_CERT_PROP_ID, an encoded certificate
+	// x509ASNEncoding is X509_ASN_ENCODING, the encoding type of the
+	// certificate elements.
+	x509ASNEncoding = 1
+)
+
+// ParseSerializedStore returns the certificates in a store serialized with
+// ExportSerializedStore, so that it can be analyzed on any platform. The
+// properties of the certificates, CRLs and CTLs are skipped.
+func ParseSerializedStore(data []byte) ([]*x509.Certificate, error) {
+	if len(data) < 8 || binary.LittleEndian.Uint32(data) != 0 || binary.LittleEndian.Uint32(data[4:]) != serializedStoreMagic {
+		return nil, errors.New("not a serialized certificate store")
+	}
+	var certs []*x509.Certificate
+	for rest := data[8:]; len(rest) > 0; {
+		if len(rest) < 12 {
+			return nil, errors.New("truncated serialized store element")
+		}
+		id := binary.LittleEndian.Uint32(rest)
+		size := uint64(binary.LittleEndian.Uint32(rest[8:]))
+		rest = rest[12:]
+		if uint64(len(rest)) < size {
+			return nil, fmt.Errorf("serialized store element %d is truncated", id)
+		}
+		value := rest[:size]
+		rest = rest[size:]
+
+		switch id {
+		case serializedEnd:
+			return certs, nil
+		case serializedCert:
+			cert, err := x509.ParseCertificate(value)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse serialized certificate: %v", err)
+			}
+			certs = append(certs, cert)
+		}
+	}
+	return certs, nil
+}
+
+// MarshalSST serializes certs as a certificate store in the .sst format
+// written by CERT_STORE_SAVE_AS_STORE, which certutil, the Certificates
+// snap-in and Import-Certificate read. The certificates carry no properties.
+func MarshalSST(certs []*x509.Certificate) ([]byte, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates to serialize")
+	}
+	var buf bytes.Buffer
+	element := func(id, encoding uint32, value []byte) {
+		var hdr [12]byte
+		binary.LittleEndian.PutUint32(hdr[:], id)
+		binary.LittleEndian.PutUint32(hdr[4:], encoding)
+		binary.LittleEndian.PutUint32(hdr[8:], uint32(len(value)))
+		buf.Write(hdr[:])
+		buf.Write(value)
+	}
+	binary.Write(&buf, binary.LittleEndian, [2]uint32{0, serializedStoreMagic})
+	for i, cert := range certs {
+		if err := checkCert("MarshalSST", fmt.Sprintf("certs[%d]", i), cert); err != nil {
+			return nil, err
+		}
+		element(serializedCert, x509ASNEncoding, cert.Raw)
+	}
+	element(serializedEnd, 0, nil)
+	return buf.Bytes(), nil
+}
+
+// WriteSST writes certs to path in the .sst format, see MarshalSST. The file
+// is replaced atomically.
+func WriteSST(path string, certs []*x509.Certificate) error {
+	b, err := MarshalSST(certs)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, b, createMode); err != nil {
+		return fmt.Errorf("writing %s: %v", path, err)
+	}
+	return nil
+}
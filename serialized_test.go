@@ -0,0 +1,102 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// serializedElement encodes an element of a serialized store.
+func serializedElement(id uint32, value []byte) []byte {
+	b := make([]byte, 12, 12+len(value))
+	binary.LittleEndian.PutUint32(b, id)
+	binary.LittleEndian.PutUint32(b[4:], x509ASNEncoding)
+	binary.LittleEndian.PutUint32(b[8:], uint32(len(value)))
+	return append(b, value...)
+}
+
+func TestParseSerializedStore(t *testing.T) {
+	cert := selfSigned(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "serialized test"},
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(time.Hour),
+	})
+	data := []byte{0, 0, 0, 0, 'C', 'E', 'R', 'T'}
+	// A friendly name property precedes the certificate it belongs to.
+	data = append(data, serializedElement(11, []byte{'a', 0, 0, 0})...)
+	data = append(data, serializedElement(serializedCert, cert.Raw)...)
+	data = append(data, serializedElement(serializedEnd, nil)...)
+
+	certs, err := ParseSerializedStore(data)
+	if err != nil {
+		t.Fatalf("ParseSerializedStore returned %v", err)
+	}
+	if len(certs) != 1 || !certs[0].Equal(cert) {
+		t.Errorf("ParseSerializedStore returned unexpected certificates: %v", certs)
+	}
+
+	for _, bad := range [][]byte{
+		nil,
+		[]byte("not a store"),
+		data[:len(data)-20],
+	} {
+		if _, err := ParseSerializedStore(bad); err == nil {
+			t.Errorf("ParseSerializedStore(%x) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestMarshalSST(t *testing.T) {
+	var certs []*x509.Certificate
+	for _, name := range []string{"leaf", "intermediate"} {
+		certs = append(certs, selfSigned(t, &x509.Certificate{
+			Subject:   pkix.Name{CommonName: name},
+			NotBefore: time.Now(),
+			NotAfter:  time.Now().Add(time.Hour),
+		}))
+	}
+	path := filepath.Join(t.TempDir(), "chain.sst")
+	if err := WriteSST(path, certs); err != nil {
+		t.Fatalf("WriteSST returned %v", err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", path, err)
+	}
+	got, err := ParseSerializedStore(data)
+	if err != nil {
+		t.Fatalf("ParseSerializedStore returned %v", err)
+	}
+	if len(got) != 2 || !got[0].Equal(certs[0]) || !got[1].Equal(certs[1]) {
+		t.Errorf("ParseSerializedStore returned %v, want the written certificates in order", got)
+	}
+	if end := data[len(data)-12:]; !bytes.Equal(end, make([]byte, 12)) {
+		t.Errorf("store ends with %x, want an empty end element", end)
+	}
+
+	if _, err := MarshalSST(nil); err == nil {
+		t.Error("MarshalSST succeeded without certificates")
+	}
+	if _, err := MarshalSST([]*x509.Certificate{nil}); err == nil {
+		t.Error("MarshalSST succeeded for a nil certificate")
+	}
+}
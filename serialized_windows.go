@@ -0,0 +1,117 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	certStoreProvSerialized = 6 // CERT_STORE_PROV_SERIALIZED
+	certStoreSaveAsStore    = 1 // CERT_STORE_SAVE_AS_STORE
+	certStoreSaveToMemory   = 2 // CERT_STORE_SAVE_TO_MEMORY
+)
+
+var certSaveStore = crypt32.MustFindProc("CertSaveStore")
+
+// ExportSerializedStore serializes the certificates, CRLs and CTLs of the
+// store identified by loc together with their properties. Private keys are
+// not included. The result can be imported with ImportSerializedStore or
+// analyzed with ParseSerializedStore.
+func (w *WinCertStore) ExportSerializedStore(loc StoreLocation) ([]byte, error) {
+	certStore, err := openStore(loc, w.lookupFlags())
+	if err != nil {
+		return nil, fmt.Errorf("CertOpenStore for %s returned %v", loc, err)
+	}
+	defer windows.CertCloseStore(certStore, 0)
+
+	// The first call only determines the size.
+	var blob cryptDataBlob
+	if err := saveStore(certStore, &blob); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, blob.cbData)
+	if len(buf) == 0 {
+		return buf, nil
+	}
+	blob.pbData = &buf[0]
+	if err := saveStore(certStore, &blob); err != nil {
+		return nil, err
+	}
+	return buf[:blob.cbData], nil
+}
+
+// saveStore wraps CertSaveStore for saving a serialized store to memory.
+func saveStore(certStore windows.Handle, blob *cryptDataBlob) error {
+	r, _, err := certSaveStore.Call(
+		uintptr(certStore),
+		encodingX509ASN|encodingPKCS7,
+		certStoreSaveAsStore,
+		certStoreSaveToMemory,
+		uintptr(unsafe.Pointer(blob)),
+		0)
+	if r == 0 {
+		return fmt.Errorf("CertSaveStore returned %v", err)
+	}
+	return nil
+}
+
+// ImportSerializedStore adds the certificates of a store serialized with
+// ExportSerializedStore, including their properties, to the store identified
+// by loc, replacing certificates that are already present. The returned
+// Result lists the certificates that were added.
+func (w *WinCertStore) ImportSerializedStore(data []byte, loc StoreLocation) (*Result, error) {
+	res := &Result{Operation: "import"}
+	if err := checkNotEmpty("ImportSerializedStore", "data", data); err != nil {
+		return res, err
+	}
+	blob := cryptDataBlob{cbData: uint32(len(data)), pbData: &data[0]}
+	// The serialized store is opened as a memory store.
+	source, err := windows.CertOpenStore(certStoreProvSerialized, 0, 0, 0, uintptr(unsafe.Pointer(&blob)))
+	if err != nil {
+		return res, fmt.Errorf("CertOpenStore for the serialized store returned %v", err)
+	}
+	defer windows.CertCloseStore(source, 0)
+
+	target, err := openStore(loc, w.openFlags(0))
+	if err != nil {
+		return res, fmt.Errorf("CertOpenStore for %s returned %v", loc, err)
+	}
+	defer windows.CertCloseStore(target, 0)
+
+	// findCert frees prev, so no context needs to be freed after the loop.
+	var prev *windows.CertContext
+	for {
+		nc, err := findCert(source, encodingX509ASN|encodingPKCS7, 0, findAny, nil, prev)
+		if err != nil {
+			return res, fmt.Errorf("finding certificates: %v", err)
+		}
+		if nc == nil {
+			return res, nil
+		}
+		prev = nc
+		tp := contextThumbprint(nc)
+		if err := windows.CertAddCertificateContextToStore(target, nc, windows.CERT_STORE_ADD_REPLACE_EXISTING, nil); err != nil {
+			return res, fmt.Errorf("import: CertAddCertificateContextToStore returned %v", err)
+		}
+		res.addChange(ActionAdded, tp, loc.String())
+		logInfo("Imported certificate.", opField("import"), thumbprintField(tp), field("store", loc))
+	}
+}
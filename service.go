@@ -0,0 +1,40 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import "fmt"
+
+// ServiceError is returned when a system service the key storage providers
+// depend on is unavailable.
+type ServiceError struct {
+	// Service is the name of the service, such as "KeyIso".
+	Service string
+	// Problem describes the state of the service.
+	Problem string
+	// Remediation describes how an administrator can fix the problem.
+	Remediation string
+	Err         error
+}
+
+func (e *ServiceError) Error() string {
+	msg := fmt.Sprintf("service %s %s", e.Service, e.Problem)
+	if e.Err != nil {
+		msg += fmt.Sprintf(": %v", e.Err)
+	}
+	if e.Remediation != "" {
+		msg += " (" + e.Remediation + ")"
+	}
+	return msg
+}
@@ -0,0 +1,41 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestServiceError(t *testing.T) {
+	tests := []struct {
+		err  *ServiceError
+		want string
+	}{
+		{
+			&ServiceError{Service: "KeyIso", Problem: "is disabled", Remediation: "set its start type to manual"},
+			"service KeyIso is disabled (set its start type to manual)",
+		},
+		{
+			&ServiceError{Service: "TBS", Problem: "could not be started", Err: errors.New("Access is denied.")},
+			"service TBS could not be started: Access is denied.",
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("unexpected error got: %s, want: %s", got, tt.want)
+		}
+	}
+}
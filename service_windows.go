@@ -0,0 +1,139 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// keyIsoService is the CNG Key Isolation service, which hosts the private
+	// keys of the key storage providers.
+	keyIsoService = "KeyIso"
+	// tbsService is the TPM Base Services service used by the Microsoft
+	// Platform Crypto Provider.
+	tbsService = "TBS"
+
+	// winerror.h constants
+	errorInsufficientBuffer    = syscall.Errno(122)  // ERROR_INSUFFICIENT_BUFFER
+	errorServiceAlreadyRunning = syscall.Errno(1056) // ERROR_SERVICE_ALREADY_RUNNING
+	errorServiceDoesNotExist   = syscall.Errno(1060) // ERROR_SERVICE_DOES_NOT_EXIST
+
+	// serviceStartTimeout bounds how long CheckKeyServices waits for a
+	// service it started.
+	serviceStartTimeout = 30 * time.Second
+)
+
+// CheckKeyServices checks that the services the provider depends on can run:
+// the CNG Key Isolation service for all providers, and the TPM Base Services
+// for ProviderMSPlatform. A stopped service is fine as long as it can be
+// started on demand. If start is set, stopped services are started, which
+// requires administrative rights. Problems are reported as *ServiceError,
+// since a stopped or disabled service otherwise surfaces as an obscure NCrypt
+// failure.
+func CheckKeyServices(provider string, start bool) error {
+	services := []string{keyIsoService}
+	if provider == ProviderMSPlatform {
+		services = append(services, tbsService)
+	}
+	scm, err := windows.OpenSCManager(nil, nil, windows.SC_MANAGER_CONNECT)
+	if err != nil {
+		return &ServiceError{Service: "control manager", Problem: "could not be opened", Err: err}
+	}
+	defer windows.CloseServiceHandle(scm)
+
+	for _, name := range services {
+		if err := checkService(scm, name, start); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkService(scm windows.Handle, name string, start bool) error {
+	access := uint32(windows.SERVICE_QUERY_STATUS | windows.SERVICE_QUERY_CONFIG)
+	if start {
+		access |= windows.SERVICE_START
+	}
+	s, err := windows.OpenService(scm, wide(name), access)
+	switch {
+	case err == errorServiceDoesNotExist:
+		return &ServiceError{Service: name, Problem: "is not installed"}
+	case err == windows.ERROR_ACCESS_DENIED && start:
+		return &ServiceError{Service: name, Problem: "could not be opened for starting", Remediation: "run as an administrator to start it", Err: err}
+	case err != nil:
+		return &ServiceError{Service: name, Problem: "could not be opened", Err: err}
+	}
+	defer windows.CloseServiceHandle(s)
+
+	var status windows.SERVICE_STATUS
+	if err := windows.QueryServiceStatus(s, &status); err != nil {
+		return &ServiceError{Service: name, Problem: "could not be queried", Err: err}
+	}
+	if status.CurrentState == windows.SERVICE_RUNNING {
+		return nil
+	}
+
+	startType, err := serviceStartType(s)
+	if err != nil {
+		return &ServiceError{Service: name, Problem: "could not be queried", Err: err}
+	}
+	if startType == windows.SERVICE_DISABLED {
+		return &ServiceError{Service: name, Problem: "is disabled", Remediation: "set its start type to manual or automatic"}
+	}
+	if !start {
+		// The service is started on demand by the first key operation.
+		logDebug("Service is stopped but can be started.", opField("checkservices"), field("service", name))
+		return nil
+	}
+
+	if err := windows.StartService(s, 0, nil); err != nil && err != errorServiceAlreadyRunning {
+		return &ServiceError{Service: name, Problem: "could not be started", Err: err}
+	}
+	deadline := time.Now().Add(serviceStartTimeout)
+	for status.CurrentState != windows.SERVICE_RUNNING {
+		if time.Now().After(deadline) {
+			return &ServiceError{Service: name, Problem: "did not start in time", Remediation: "check the System event log"}
+		}
+		time.Sleep(250 * time.Millisecond)
+		if err := windows.QueryServiceStatus(s, &status); err != nil {
+			return &ServiceError{Service: name, Problem: "could not be queried", Err: err}
+		}
+	}
+	logInfo("Started service.", opField("checkservices"), field("service", name))
+	return nil
+}
+
+// serviceStartType returns the start type of the service, such as
+// SERVICE_DISABLED.
+func serviceStartType(s windows.Handle) (uint32, error) {
+	var needed uint32
+	err := windows.QueryServiceConfig(s, nil, 0, &needed)
+	if err != errorInsufficientBuffer {
+		return 0, err
+	}
+	buf := make([]byte, needed)
+	cfg := (*windows.QUERY_SERVICE_CONFIG)(unsafe.Pointer(&buf[0]))
+	if err := windows.QueryServiceConfig(s, cfg, needed, &needed); err != nil {
+		return 0, err
+	}
+	return cfg.StartType, nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"fmt"
+)
+
+// schemeParams are the signing parameters of a TLS signature scheme.
+type schemeParams struct {
+	scheme tls.SignatureScheme
+	hash   crypto.Hash
+	pss    bool
+}
+
+// candidateSchemes returns the TLS signature schemes that keys like pub can
+// sign with, in the order of preference of crypto/tls.
+func candidateSchemes(pub crypto.PublicKey) ([]schemeParams, error) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return []schemeParams{
+			{tls.PSSWithSHA256, crypto.SHA256, true},
+			{tls.PSSWithSHA384, crypto.SHA384, true},
+			{tls.PSSWithSHA512, crypto.SHA512, true},
+			{tls.PKCS1WithSHA256, crypto.SHA256, false},
+			{tls.PKCS1WithSHA384, crypto.SHA384, false},
+			{tls.PKCS1WithSHA512, crypto.SHA512, false},
+			{tls.PKCS1WithSHA1, crypto.SHA1, false},
+		}, nil
+	case *ecdsa.PublicKey:
+		// TLS 1.3 binds the hash to the curve, so only the matching scheme is
+		// offered.
+		switch pub.Curve.Params().BitSize {
+		case 256:
+			return []schemeParams{{tls.ECDSAWithP256AndSHA256, crypto.SHA256, false}}, nil
+		case 384:
+			return []schemeParams{{tls.ECDSAWithP384AndSHA384, crypto.SHA384, false}}, nil
+		case 521:
+			return []schemeParams{{tls.ECDSAWithP521AndSHA512, crypto.SHA512, false}}, nil
+		}
+		return nil, fmt.Errorf("unsupported curve %s", pub.Curve.Params().Name)
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+}
+
+// SupportedSignatureSchemes reports the TLS signature schemes signer can
+// actually produce, in the order of preference of crypto/tls. Each candidate
+// is tried with a test signature that must verify, since providers differ:
+// some TPMs cannot sign with PSS or with every hash, for example. Servers can
+// use the result to configure TLS instead of failing handshakes at runtime.
+// ECDSA signers must return raw r||s signatures like the keys of this
+// package. It returns an error if signer supports no scheme, if the circuit
+// breaker of the key is open or if its usage quota is exhausted.
+func SupportedSignatureSchemes(signer crypto.Signer) ([]tls.SignatureScheme, error) {
+	candidates, err := candidateSchemes(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	var schemes []tls.SignatureScheme
+	var lastErr error
+	for _, c := range candidates {
+		if err := trySignatureScheme(signer, c); err != nil {
+			if err == ErrCircuitOpen || err == ErrQuotaExceeded {
+				return nil, err
+			}
+			logDebug("Signature scheme is not supported.", opField("signatureschemes"), field("scheme", fmt.Sprintf("%#04x", uint16(c.scheme))), errField(err))
+			lastErr = err
+			continue
+		}
+		schemes = append(schemes, c.scheme)
+	}
+	if len(schemes) == 0 {
+		return nil, fmt.Errorf("the key supports no TLS signature scheme: %v", lastErr)
+	}
+	return schemes, nil
+}
+
+// trySignatureScheme signs a random digest with the parameters of c and
+// verifies the signature.
+func trySignatureScheme(signer crypto.Signer, c schemeParams) error {
+	digest := make([]byte, c.hash.Size())
+	if _, err := rand.Read(digest); err != nil {
+		return fmt.Errorf("could not generate test digest: %v", err)
+	}
+	var opts crypto.SignerOpts = c.hash
+	if c.pss {
+		// TLS requires the salt to be as long as the hash.
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: c.hash}
+	}
+	sig, err := signer.Sign(rand.Reader, digest, opts)
+	if err != nil {
+		return err
+	}
+	return verifySignature(signer.Public(), digest, opts, sig)
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// noPSSSigner is an RSA signer that cannot sign with PSS, like some TPMs.
+type noPSSSigner struct {
+	*rsa.PrivateKey
+	err error
+}
+
+func (s noPSSSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	if _, ok := opts.(*rsa.PSSOptions); ok {
+		return nil, errors.New("PSS is not supported")
+	}
+	return s.PrivateKey.Sign(rand, digest, opts)
+}
+
+func TestSupportedSignatureSchemes(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs1 := []tls.SignatureScheme{tls.PKCS1WithSHA256, tls.PKCS1WithSHA384, tls.PKCS1WithSHA512, tls.PKCS1WithSHA1}
+	tests := []struct {
+		name   string
+		signer crypto.Signer
+		want   []tls.SignatureScheme
+	}{
+		{"rsa", rsaKey, append([]tls.SignatureScheme{tls.PSSWithSHA256, tls.PSSWithSHA384, tls.PSSWithSHA512}, pkcs1...)},
+		{"rsa without pss", noPSSSigner{PrivateKey: rsaKey}, pkcs1},
+		{"ecdsa", rawECDSASigner{ecKey}, []tls.SignatureScheme{tls.ECDSAWithP384AndSHA384}},
+	}
+	for _, tt := range tests {
+		got, err := SupportedSignatureSchemes(tt.signer)
+		if err != nil {
+			t.Errorf("%s: SupportedSignatureSchemes returned %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: SupportedSignatureSchemes = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// ECDSA signers returning ASN.1 do not produce TLS compatible signatures
+	// through this package, and failing signers support nothing.
+	for _, signer := range []crypto.Signer{ecKey, noPSSSigner{PrivateKey: rsaKey, err: errors.New("broken")}} {
+		if got, err := SupportedSignatureSchemes(signer); err == nil {
+			t.Errorf("SupportedSignatureSchemes(%T) = %v, want error", signer, got)
+		}
+	}
+	if _, err := SupportedSignatureSchemes(noPSSSigner{PrivateKey: rsaKey, err: ErrCircuitOpen}); err != ErrCircuitOpen {
+		t.Errorf("SupportedSignatureSchemes with an open circuit returned %v, want ErrCircuitOpen", err)
+	}
+}
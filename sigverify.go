@@ -0,0 +1,128 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// SignatureCheck selects whether keys verify every signature they produce
+// against their public key before returning it. Verification catches
+// provider and padding misconfiguration where it happens, instead of at the
+// relying party, at the cost of a public key operation per signature.
+type SignatureCheck int
+
+// Signature check modes.
+const (
+	// SignatureCheckDefault verifies signatures only in debug builds, which
+	// are built with the certtostore_debug tag.
+	SignatureCheckDefault SignatureCheck = iota
+	// SignatureCheckOn always verifies signatures.
+	SignatureCheckOn
+	// SignatureCheckOff never verifies signatures, even in debug builds.
+	SignatureCheckOff
+)
+
+func (c SignatureCheck) String() string {
+	switch c {
+	case SignatureCheckDefault:
+		return "default"
+	case SignatureCheckOn:
+		return "on"
+	case SignatureCheckOff:
+		return "off"
+	default:
+		return fmt.Sprintf("SignatureCheck(%d)", int(c))
+	}
+}
+
+func (c SignatureCheck) validate() error {
+	switch c {
+	case SignatureCheckDefault, SignatureCheckOn, SignatureCheckOff:
+		return nil
+	default:
+		return fmt.Errorf("unsupported signature check: %v", c)
+	}
+}
+
+// enabled reports whether signatures are verified in this build.
+func (c SignatureCheck) enabled() bool {
+	switch c {
+	case SignatureCheckOn:
+		return true
+	case SignatureCheckOff:
+		return false
+	default:
+		return debugBuild
+	}
+}
+
+// SignatureVerificationError is returned by Sign if a signature check is
+// enabled and the signature the provider produced does not verify.
+type SignatureVerificationError struct {
+	Container string
+	Err       error
+}
+
+func (e *SignatureVerificationError) Error() string {
+	return fmt.Sprintf("signature of key %q does not verify against its public key: %v", e.Container, e.Err)
+}
+
+// checkSignature returns sig if verify is unset or sig verifies, and a
+// *SignatureVerificationError otherwise.
+func checkSignature(verify bool, container string, pub crypto.PublicKey, digest []byte, opts crypto.SignerOpts, sig []byte) ([]byte, error) {
+	if !verify {
+		return sig, nil
+	}
+	if err := verifySignature(pub, digest, opts, sig); err != nil {
+		return nil, &SignatureVerificationError{Container: container, Err: err}
+	}
+	return sig, nil
+}
+
+// verifySignature verifies sig, as returned by Sign for opts, over digest.
+// ECDSA signatures are in the r||s format produced by NCryptSignHash.
+func verifySignature(pub crypto.PublicKey, digest []byte, opts crypto.SignerOpts, sig []byte) error {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if pssOpts, ok := opts.(*rsa.PSSOptions); ok {
+			return rsa.VerifyPSS(pub, pssOpts.Hash, digest, sig, pssOpts)
+		}
+		return rsa.VerifyPKCS1v15(pub, opts.HashFunc(), digest, sig)
+	case *ecdsa.PublicKey:
+		if !verifyECDSARaw(pub, digest, sig) {
+			return errors.New("ECDSA verification error")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported public key type %T", pub)
+	}
+}
+
+// verifyECDSARaw verifies an ECDSA signature in the r||s format produced by
+// NCryptSignHash.
+func verifyECDSARaw(pub *ecdsa.PublicKey, digest, sig []byte) bool {
+	if len(sig) == 0 || len(sig)%2 != 0 {
+		return false
+	}
+	r := new(big.Int).SetBytes(sig[:len(sig)/2])
+	s := new(big.Int).SetBytes(sig[len(sig)/2:])
+	return ecdsa.Verify(pub, digest, r, s)
+}
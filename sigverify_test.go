@@ -0,0 +1,94 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestVerifySignature(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("message"))
+	pssOpts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+
+	pkcs1, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pss, err := rsa.SignPSS(rand.Reader, rsaKey, crypto.SHA256, digest[:], pssOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := make([]byte, 64)
+	rb, sb := r.Bytes(), s.Bytes()
+	copy(raw[32-len(rb):32], rb)
+	copy(raw[64-len(sb):], sb)
+
+	tests := []struct {
+		name string
+		pub  crypto.PublicKey
+		opts crypto.SignerOpts
+		sig  []byte
+		ok   bool
+	}{
+		{"pkcs1", &rsaKey.PublicKey, crypto.SHA256, pkcs1, true},
+		{"pkcs1 as pss", &rsaKey.PublicKey, pssOpts, pkcs1, false},
+		{"pss", &rsaKey.PublicKey, pssOpts, pss, true},
+		{"pss as pkcs1", &rsaKey.PublicKey, crypto.SHA256, pss, false},
+		{"ecdsa", &ecKey.PublicKey, nil, raw, true},
+		{"ecdsa truncated", &ecKey.PublicKey, nil, raw[:63], false},
+		{"ecdsa wrong key", &ecKey.PublicKey, nil, append(raw[32:], raw[:32]...), false},
+		{"unsupported key", "key", crypto.SHA256, pkcs1, false},
+	}
+	for _, tt := range tests {
+		err := verifySignature(tt.pub, digest[:], tt.opts, tt.sig)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: verifySignature returned %v, want success: %t", tt.name, err, tt.ok)
+		}
+		sig, err := checkSignature(true, "c", tt.pub, digest[:], tt.opts, tt.sig)
+		if _, isVerr := err.(*SignatureVerificationError); isVerr == tt.ok || (err == nil && string(sig) != string(tt.sig)) {
+			t.Errorf("%s: checkSignature returned %v, want success: %t", tt.name, err, tt.ok)
+		}
+		if sig, err := checkSignature(false, "c", tt.pub, digest[:], tt.opts, tt.sig); err != nil || string(sig) != string(tt.sig) {
+			t.Errorf("%s: checkSignature without verification returned %v", tt.name, err)
+		}
+	}
+}
+
+func TestSignatureCheck(t *testing.T) {
+	if !SignatureCheckOn.enabled() || SignatureCheckOff.enabled() || SignatureCheckDefault.enabled() != debugBuild {
+		t.Errorf("enabled() does not match the signature check modes")
+	}
+	if err := SignatureCheck(3).validate(); err == nil {
+		t.Errorf("SignatureCheck(3).validate() succeeded, want error")
+	}
+}
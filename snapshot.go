@@ -0,0 +1,136 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"sort"
+	"time"
+)
+
+// ActionPropertiesChanged means the properties of a certificate changed
+// between two snapshots, such as its friendly name or associated key.
+const ActionPropertiesChanged = "propertieschanged"
+
+// Snapshot records the certificates of a set of stores and their properties
+// at a point in time. Snapshots are JSON serializable, so they can be kept
+// and compared with Diff later to detect unexpected changes.
+type Snapshot struct {
+	Taken time.Time `json:"taken"`
+	// Stores maps the name of each store, such as LocalMachine\ROOT, to the
+	// certificates in it.
+	Stores map[string][]SnapshotCert `json:"stores"`
+}
+
+// SnapshotCert is a certificate in a Snapshot.
+type SnapshotCert struct {
+	Thumbprint string    `json:"thumbprint"`
+	Subject    string    `json:"subject"`
+	Issuer     string    `json:"issuer"`
+	NotAfter   time.Time `json:"notAfter"`
+	// Properties maps the IDs of the certificate's properties to the
+	// hex encoded SHA256 of their value, so that changes can be detected
+	// without keeping the values.
+	Properties map[uint32]string `json:"properties,omitempty"`
+}
+
+// SnapshotDiff is a difference between two snapshots.
+type SnapshotDiff struct {
+	// Change describes the certificate and store. Its Action is
+	// ActionAdded, ActionRemoved or ActionPropertiesChanged.
+	Change
+	Subject string `json:"subject"`
+	// Properties lists the IDs of the properties that were added, removed or
+	// changed, for ActionPropertiesChanged.
+	Properties []uint32 `json:"properties,omitempty"`
+}
+
+// snapshotCert returns the SnapshotCert of cert with the given property
+// values.
+func snapshotCert(cert *x509.Certificate, props map[uint32][]byte) SnapshotCert {
+	sc := SnapshotCert{
+		Thumbprint: thumbprint(cert),
+		Subject:    cert.Subject.String(),
+		Issuer:     cert.Issuer.String(),
+		NotAfter:   cert.NotAfter,
+	}
+	if len(props) > 0 {
+		sc.Properties = make(map[uint32]string, len(props))
+		for id, v := range props {
+			sum := sha256.Sum256(v)
+			sc.Properties[id] = hex.EncodeToString(sum[:])
+		}
+	}
+	return sc
+}
+
+// Diff returns the differences from s to later: the certificates that were
+// added to or removed from a store, and those whose properties changed.
+// Stores that are only in one of the snapshots are skipped, as they were not
+// captured by both. The result is sorted by store and thumbprint.
+func (s *Snapshot) Diff(later *Snapshot) []SnapshotDiff {
+	var diffs []SnapshotDiff
+	for store, before := range s.Stores {
+		after, ok := later.Stores[store]
+		if !ok {
+			continue
+		}
+		old := make(map[string]SnapshotCert, len(before))
+		for _, c := range before {
+			old[c.Thumbprint] = c
+		}
+		for _, c := range after {
+			prev, ok := old[c.Thumbprint]
+			delete(old, c.Thumbprint)
+			if !ok {
+				diffs = append(diffs, SnapshotDiff{Change: Change{Action: ActionAdded, Thumbprint: c.Thumbprint, Store: store}, Subject: c.Subject})
+				continue
+			}
+			if ids := changedProperties(prev.Properties, c.Properties); len(ids) > 0 {
+				diffs = append(diffs, SnapshotDiff{Change: Change{Action: ActionPropertiesChanged, Thumbprint: c.Thumbprint, Store: store}, Subject: c.Subject, Properties: ids})
+			}
+		}
+		for _, c := range old {
+			diffs = append(diffs, SnapshotDiff{Change: Change{Action: ActionRemoved, Thumbprint: c.Thumbprint, Store: store}, Subject: c.Subject})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		if diffs[i].Store != diffs[j].Store {
+			return diffs[i].Store < diffs[j].Store
+		}
+		return diffs[i].Thumbprint < diffs[j].Thumbprint
+	})
+	return diffs
+}
+
+// changedProperties returns the sorted IDs of the properties that differ
+// between a and b.
+func changedProperties(a, b map[uint32]string) []uint32 {
+	var ids []uint32
+	for id, v := range a {
+		if w, ok := b[id]; !ok || w != v {
+			ids = append(ids, id)
+		}
+	}
+	for id := range b {
+		if _, ok := a[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
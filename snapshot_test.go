@@ -0,0 +1,65 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"reflect"
+	"testing"
+)
+
+func TestSnapshotDiff(t *testing.T) {
+	a := selfSigned(t, &x509.Certificate{Subject: pkix.Name{CommonName: "a"}})
+	b := selfSigned(t, &x509.Certificate{Subject: pkix.Name{CommonName: "b"}})
+	c := selfSigned(t, &x509.Certificate{Subject: pkix.Name{CommonName: "c"}})
+	name := map[uint32][]byte{PropFriendlyName: []byte("n")}
+	renamed := map[uint32][]byte{PropFriendlyName: []byte("m"), PropArchived: {}}
+
+	before := &Snapshot{Stores: map[string][]SnapshotCert{
+		`LocalMachine\ROOT`: {snapshotCert(a, name), snapshotCert(b, nil)},
+		`LocalMachine\CA`:   {snapshotCert(a, nil)},
+		`LocalMachine\MY`:   {snapshotCert(a, nil)},
+	}}
+	after := &Snapshot{Stores: map[string][]SnapshotCert{
+		`LocalMachine\ROOT`: {snapshotCert(a, renamed), snapshotCert(c, nil)},
+		`LocalMachine\CA`:   {snapshotCert(a, nil)},
+	}}
+
+	var got []string
+	for _, d := range before.Diff(after) {
+		got = append(got, d.Store+" "+d.Action+" "+d.Subject)
+		if d.Action == ActionPropertiesChanged && !reflect.DeepEqual(d.Properties, []uint32{PropFriendlyName, PropArchived}) {
+			t.Errorf("changed properties = %v, want [%d %d]", d.Properties, PropFriendlyName, PropArchived)
+		}
+	}
+	// Sorted by store, then thumbprint, so compare as a set per store.
+	want := map[string]bool{
+		`LocalMachine\ROOT propertieschanged CN=a`: true,
+		`LocalMachine\ROOT removed CN=b`:           true,
+		`LocalMachine\ROOT added CN=c`:             true,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Diff = %v, want %d differences", got, len(want))
+	}
+	for _, g := range got {
+		if !want[g] {
+			t.Errorf("unexpected difference %q", g)
+		}
+	}
+	if d := after.Diff(after); len(d) != 0 {
+		t.Errorf("Diff of a snapshot with itself = %v, want none", d)
+	}
+}
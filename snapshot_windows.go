@@ -0,0 +1,128 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/x509"
+	"fmt"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var certEnumCertificateContextProps = crypt32.MustFindProc("CertEnumCertificateContextProperties")
+
+// volatileProperties are properties that describe the in-memory state of a
+// certificate context rather than the store, and differ between snapshots.
+var volatileProperties = map[uint32]bool{
+	1:  true, // CERT_KEY_PROV_HANDLE_PROP_ID
+	5:  true, // CERT_KEY_CONTEXT_PROP_ID
+	14: true, // CERT_ACCESS_STATE_PROP_ID
+}
+
+// DefaultSnapshotStores are the stores captured by Snapshot if none are given.
+var DefaultSnapshotStores = []StoreLocation{
+	{Location: LocationLocalMachine, Name: "MY"},
+	{Location: LocationLocalMachine, Name: "ROOT"},
+	{Location: LocationLocalMachine, Name: "AuthRoot"},
+	{Location: LocationLocalMachine, Name: "CA"},
+	{Location: LocationLocalMachine, Name: "TrustedPeople"},
+	{Location: LocationLocalMachine, Name: "TrustedPublisher"},
+	{Location: LocationLocalMachine, Name: "Disallowed"},
+	{Location: LocationCurrentUser, Name: "MY"},
+	{Location: LocationCurrentUser, Name: "ROOT"},
+}
+
+// Snapshot captures the certificates and properties of the stores identified
+// by locs, or of DefaultSnapshotStores if locs is empty. Stores that do not
+// exist are left out of the snapshot. Compare snapshots with Diff to detect
+// certificates installed or changed since an earlier snapshot.
+func (w *WinCertStore) Snapshot(locs ...StoreLocation) (*Snapshot, error) {
+	if len(locs) == 0 {
+		locs = DefaultSnapshotStores
+	}
+	s := &Snapshot{Taken: time.Now(), Stores: make(map[string][]SnapshotCert)}
+	for _, loc := range locs {
+		certs, err := w.snapshotStore(loc)
+		if errno, ok := err.(syscall.Errno); ok && errno == windows.ERROR_FILE_NOT_FOUND {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		s.Stores[loc.String()] = certs
+	}
+	logInfo("Captured store snapshot.", opField("snapshot"), field("stores", len(s.Stores)))
+	return s, nil
+}
+
+// snapshotStore returns the certificates of the store identified by loc. It
+// returns the syscall.Errno of CertOpenStore if the store cannot be opened.
+func (w *WinCertStore) snapshotStore(loc StoreLocation) ([]SnapshotCert, error) {
+	certStore, err := openStore(loc, w.openFlags(certStoreOpenExisting|certStoreReadOnly))
+	if err != nil {
+		return nil, err
+	}
+	defer windows.CertCloseStore(certStore, 0)
+
+	certs := []SnapshotCert{}
+	// findCert frees prev, so no context needs to be freed after the loop.
+	var prev *windows.CertContext
+	for {
+		nc, err := findCert(certStore, encodingX509ASN|encodingPKCS7, 0, findAny, nil, prev)
+		if err != nil {
+			return nil, fmt.Errorf("finding certificates in %s: %v", loc, err)
+		}
+		if nc == nil {
+			return certs, nil
+		}
+		prev = nc
+		xc, err := x509.ParseCertificate(certContextBytes(nc))
+		if err != nil {
+			logWarning("Skipping certificate that could not be parsed.", opField("snapshot"), field("store", loc), errField(err))
+			continue
+		}
+		props, err := certContextProperties(nc)
+		if err != nil {
+			return nil, fmt.Errorf("reading properties of %s in %s: %v", thumbprint(xc), loc, err)
+		}
+		certs = append(certs, snapshotCert(xc, props))
+	}
+}
+
+// certContextProperties returns the values of the properties of
+// certContext, except for volatileProperties.
+func certContextProperties(certContext *windows.CertContext) (map[uint32][]byte, error) {
+	props := make(map[uint32][]byte)
+	var id uintptr
+	for {
+		id, _, _ = certEnumCertificateContextProps.Call(uintptr(unsafe.Pointer(certContext)), id)
+		if id == 0 {
+			return props, nil
+		}
+		if volatileProperties[uint32(id)] {
+			continue
+		}
+		v, err := certContextProperty(certContext, uint32(id))
+		if err != nil {
+			return nil, err
+		}
+		props[uint32(id)] = v
+	}
+}
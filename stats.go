@@ -0,0 +1,107 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"sync"
+	"time"
+)
+
+// OpStats are the counters of one kind of key operation.
+type OpStats struct {
+	// Count is the number of operations, including failed ones.
+	Count uint64
+	// Failures is the number of failed operations. FailuresByStatus breaks
+	// them down by the status code returned by Windows, with failures that
+	// have no status code counted under 0.
+	Failures         uint64
+	FailuresByStatus map[uint32]uint64
+	// TotalLatency is the time spent in all operations.
+	TotalLatency time.Duration
+}
+
+// AverageLatency returns the average duration of an operation.
+func (s OpStats) AverageLatency() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.TotalLatency / time.Duration(s.Count)
+}
+
+// KeyStats are the operation counters of a key since it was opened.
+type KeyStats struct {
+	// Since is the time the key was opened.
+	Since time.Time
+	// Ops holds the counters by operation, such as "sign", "signraw",
+	// "decrypt" and "derive".
+	Ops map[string]OpStats
+}
+
+// keyStats collects the KeyStats of a key. It is safe for concurrent use,
+// and a nil *keyStats ignores all operations.
+type keyStats struct {
+	mu    sync.Mutex
+	since time.Time
+	ops   map[string]*OpStats
+}
+
+func newKeyStats() *keyStats {
+	return &keyStats{since: time.Now(), ops: make(map[string]*OpStats)}
+}
+
+// record adds an operation that took d. status is the status code of a
+// failed operation.
+func (s *keyStats) record(op string, d time.Duration, failed bool, status uint32) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.ops[op]
+	if !ok {
+		st = &OpStats{}
+		s.ops[op] = st
+	}
+	st.Count++
+	st.TotalLatency += d
+	if failed {
+		st.Failures++
+		if st.FailuresByStatus == nil {
+			st.FailuresByStatus = make(map[uint32]uint64)
+		}
+		st.FailuresByStatus[status]++
+	}
+}
+
+// snapshot returns a copy of the counters.
+func (s *keyStats) snapshot() KeyStats {
+	if s == nil {
+		return KeyStats{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ks := KeyStats{Since: s.since, Ops: make(map[string]OpStats, len(s.ops))}
+	for op, st := range s.ops {
+		c := *st
+		if st.FailuresByStatus != nil {
+			c.FailuresByStatus = make(map[uint32]uint64, len(st.FailuresByStatus))
+			for status, n := range st.FailuresByStatus {
+				c.FailuresByStatus[status] = n
+			}
+		}
+		ks.Ops[op] = c
+	}
+	return ks
+}
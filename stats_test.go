@@ -0,0 +1,54 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyStats(t *testing.T) {
+	s := newKeyStats()
+	s.record("sign", 10*time.Millisecond, false, 0)
+	s.record("sign", 30*time.Millisecond, true, 0x80090016)
+	s.record("decrypt", time.Millisecond, true, 0)
+
+	ks := s.snapshot()
+	sign := ks.Ops["sign"]
+	if sign.Count != 2 || sign.Failures != 1 || sign.FailuresByStatus[0x80090016] != 1 {
+		t.Errorf("sign stats = %+v, want 2 operations with 1 failure of status 80090016", sign)
+	}
+	if got := sign.AverageLatency(); got != 20*time.Millisecond {
+		t.Errorf("sign AverageLatency() = %v, want 20ms", got)
+	}
+	if dec := ks.Ops["decrypt"]; dec.Count != 1 || dec.FailuresByStatus[0] != 1 {
+		t.Errorf("decrypt stats = %+v, want 1 failure without status", dec)
+	}
+
+	// Snapshots must not change with later operations.
+	s.record("sign", time.Millisecond, true, 0x80090016)
+	if ks.Ops["sign"].Count != 2 || ks.Ops["sign"].FailuresByStatus[0x80090016] != 1 {
+		t.Error("snapshot changed after a later operation")
+	}
+
+	var nilStats *keyStats
+	nilStats.record("sign", time.Millisecond, false, 0)
+	if got := nilStats.snapshot(); len(got.Ops) != 0 {
+		t.Errorf("nil keyStats snapshot = %+v, want empty", got)
+	}
+	if got := (OpStats{}).AverageLatency(); got != 0 {
+		t.Errorf("empty AverageLatency() = %v, want 0", got)
+	}
+}
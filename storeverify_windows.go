@@ -0,0 +1,85 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var cryptAcquireCertificatePrivateKey = crypt32.MustFindProc("CryptAcquireCertificatePrivateKey")
+
+// verifyCertKey acquires the private key associated with certContext the way
+// TLS stacks such as SChannel do, and signs a test digest with it that must
+// verify against the public key of cert. certContext only needs its key
+// provider info set, so this catches certificates that cannot be used before
+// they are stored, because the key is missing, inaccessible or does not
+// match. Keys of certificates that may not sign are only acquired.
+func verifyCertKey(certContext *windows.CertContext, cert *x509.Certificate) error {
+	var kh uintptr
+	var keySpec uint32
+	var callerFree int32
+	r, _, err := cryptAcquireCertificatePrivateKey.Call(
+		uintptr(unsafe.Pointer(certContext)),
+		acquireOnlyNCryptKey|acquireSilent,
+		0,
+		uintptr(unsafe.Pointer(&kh)),
+		uintptr(unsafe.Pointer(&keySpec)),
+		uintptr(unsafe.Pointer(&callerFree)))
+	if r == 0 {
+		return fmt.Errorf("CryptAcquireCertificatePrivateKey returned %v", err)
+	}
+	if callerFree != 0 {
+		defer nCryptFreeObject.Call(kh)
+	}
+	if cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		return nil
+	}
+
+	digest, err := testDigest()
+	if err != nil {
+		return err
+	}
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		sig, err := signHashPkcs1Padding(kh, digest, sha256AlgID, ncryptSilentFlag)
+		if err != nil {
+			return fmt.Errorf("test signature failed: %w", err)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, sig); err != nil {
+			return fmt.Errorf("test signature does not match the certificate: %v", err)
+		}
+	case *ecdsa.PublicKey:
+		sig, err := signHashNoPadding(kh, digest, ncryptSilentFlag)
+		if err != nil {
+			return fmt.Errorf("test signature failed: %w", err)
+		}
+		if !verifyECDSARaw(pub, digest, sig) {
+			return errors.New("test signature does not match the certificate")
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", pub)
+	}
+	return nil
+}
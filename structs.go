@@ -0,0 +1,86 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+// The structs in this file are passed to Windows APIs. They are not limited to
+// Windows builds so that their layout can be tested on every architecture.
+// They must not contain 64-bit integer fields, which Go aligns differently
+// from the Windows C ABI on 386.
+
+// paddingInfo is the BCRYPT_PKCS1_PADDING_INFO struct in bcrypt.h.
+type paddingInfo struct {
+	pszAlgID *uint16
+}
+
+// pssPaddingInfo is the BCRYPT_PSS_PADDING_INFO struct in bcrypt.h.
+type pssPaddingInfo struct {
+	pszAlgID *uint16
+	cbSalt   uint32
+}
+
+// oaepPaddingInfo is the BCRYPT_OAEP_PADDING_INFO struct in bcrypt.h.
+// https://msdn.microsoft.com/en-us/library/windows/desktop/aa375526(v=vs.85).aspx
+type oaepPaddingInfo struct {
+	pszAlgID *uint16 // pszAlgId
+	pbLabel  *uint16 // pbLabel
+	cbLabel  uint32  // cbLabel
+}
+
+// cryptKeyProvInfo is the CRYPT_KEY_PROV_INFO struct in wincrypt.h. The
+// string fields are kept as uintptr because they point into the buffer the
+// struct was read from.
+type cryptKeyProvInfo struct {
+	containerName uintptr // pwszContainerName
+	provName      uintptr // pwszProvName
+	provType      uint32  // dwProvType
+	flags         uint32  // dwFlags
+	provParam     uint32  // cProvParam
+	rgProvParam   uintptr // rgProvParam
+	keySpec       uint32  // dwKeySpec
+}
+
+// cryptDataBlob is the CRYPT_DATA_BLOB struct in wincrypt.h, which is also
+// used as CRYPT_HASH_BLOB.
+type cryptDataBlob struct {
+	cbData uint32 // cbData
+	pbData *byte  // pbData
+}
+
+// ctlContext is the CTL_CONTEXT struct in wincrypt.h.
+type ctlContext struct {
+	encodingType uint32  // dwMsgAndCertEncodingType
+	ctlEncoded   *byte   // pbCtlEncoded
+	ctlEncodedSz uint32  // cbCtlEncoded
+	ctlInfo      uintptr // pCtlInfo
+	certStore    uintptr // hCertStore
+	cryptMsg     uintptr // hCryptMsg
+	ctlContent   *byte   // pbCtlContent
+	ctlContentSz uint32  // cbCtlContent
+}
+
+// ncryptBuffer is the NCryptBuffer (BCryptBuffer) struct in bcrypt.h.
+type ncryptBuffer struct {
+	cbBuffer   uint32 // cbBuffer
+	bufferType uint32 // BufferType
+	pvBuffer   *byte  // pvBuffer
+}
+
+// ncryptBufferDesc is the NCryptBufferDesc (BCryptBufferDesc) struct in
+// bcrypt.h.
+type ncryptBufferDesc struct {
+	ulVersion uint32        // ulVersion
+	cBuffers  uint32        // cBuffers
+	pBuffers  *ncryptBuffer // pBuffers
+}
@@ -0,0 +1,75 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"testing"
+	"unsafe"
+)
+
+// ptrSize is the size of pointers on the architecture the test runs on.
+const ptrSize = unsafe.Sizeof(uintptr(0))
+
+// layout returns v32 on 32-bit and v64 on 64-bit architectures.
+func layout(v32, v64 uintptr) uintptr {
+	if ptrSize == 4 {
+		return v32
+	}
+	return v64
+}
+
+// TestStructLayout checks the structs passed to Windows APIs against the
+// sizes and offsets of the C structs, on both 32-bit and 64-bit Windows.
+func TestStructLayout(t *testing.T) {
+	var pss pssPaddingInfo
+	var oaep oaepPaddingInfo
+	var kpi cryptKeyProvInfo
+	var blob cryptDataBlob
+	var ctl ctlContext
+	var buf ncryptBuffer
+	var desc ncryptBufferDesc
+	tests := []struct {
+		name      string
+		got, want uintptr
+	}{
+		{"sizeof(BCRYPT_PKCS1_PADDING_INFO)", unsafe.Sizeof(paddingInfo{}), layout(4, 8)},
+		{"sizeof(BCRYPT_PSS_PADDING_INFO)", unsafe.Sizeof(pss), layout(8, 16)},
+		{"offsetof(BCRYPT_PSS_PADDING_INFO, cbSalt)", unsafe.Offsetof(pss.cbSalt), layout(4, 8)},
+		{"sizeof(BCRYPT_OAEP_PADDING_INFO)", unsafe.Sizeof(oaep), layout(12, 24)},
+		{"offsetof(BCRYPT_OAEP_PADDING_INFO, pbLabel)", unsafe.Offsetof(oaep.pbLabel), layout(4, 8)},
+		{"offsetof(BCRYPT_OAEP_PADDING_INFO, cbLabel)", unsafe.Offsetof(oaep.cbLabel), layout(8, 16)},
+		{"sizeof(CRYPT_KEY_PROV_INFO)", unsafe.Sizeof(kpi), layout(28, 48)},
+		{"offsetof(CRYPT_KEY_PROV_INFO, pwszProvName)", unsafe.Offsetof(kpi.provName), layout(4, 8)},
+		{"offsetof(CRYPT_KEY_PROV_INFO, dwFlags)", unsafe.Offsetof(kpi.flags), layout(12, 20)},
+		{"offsetof(CRYPT_KEY_PROV_INFO, rgProvParam)", unsafe.Offsetof(kpi.rgProvParam), layout(20, 32)},
+		{"offsetof(CRYPT_KEY_PROV_INFO, dwKeySpec)", unsafe.Offsetof(kpi.keySpec), layout(24, 40)},
+		{"sizeof(CRYPT_DATA_BLOB)", unsafe.Sizeof(blob), layout(8, 16)},
+		{"offsetof(CRYPT_DATA_BLOB, pbData)", unsafe.Offsetof(blob.pbData), layout(4, 8)},
+		{"sizeof(CTL_CONTEXT)", unsafe.Sizeof(ctl), layout(32, 64)},
+		{"offsetof(CTL_CONTEXT, pbCtlEncoded)", unsafe.Offsetof(ctl.ctlEncoded), layout(4, 8)},
+		{"offsetof(CTL_CONTEXT, pCtlInfo)", unsafe.Offsetof(ctl.ctlInfo), layout(12, 24)},
+		{"offsetof(CTL_CONTEXT, pbCtlContent)", unsafe.Offsetof(ctl.ctlContent), layout(24, 48)},
+		{"offsetof(CTL_CONTEXT, cbCtlContent)", unsafe.Offsetof(ctl.ctlContentSz), layout(28, 56)},
+		{"sizeof(NCryptBuffer)", unsafe.Sizeof(buf), layout(12, 16)},
+		{"offsetof(NCryptBuffer, pvBuffer)", unsafe.Offsetof(buf.pvBuffer), 8},
+		{"sizeof(NCryptBufferDesc)", unsafe.Sizeof(desc), layout(12, 16)},
+		{"offsetof(NCryptBufferDesc, pBuffers)", unsafe.Offsetof(desc.pBuffers), 8},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.name, tt.got, tt.want)
+		}
+	}
+}
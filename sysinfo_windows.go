@@ -106,3 +106,17 @@ func NetInfo() ([]string, error) {
 
 	return macs, nil
 }
+
+// HostContainerName derives the key container name for purpose from the
+// hostname and the SMBIOS system UUID of this machine. See ContainerName.
+func HostContainerName(purpose string) (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("could not determine the hostname: %v", err)
+	}
+	prod, err := CompProdInfo()
+	if err != nil {
+		return "", fmt.Errorf("could not determine the system UUID: %v", err)
+	}
+	return ContainerName(MachineIdentity{Hostname: host, MachineGUID: prod.UUID}, purpose)
+}
@@ -0,0 +1,81 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+)
+
+const (
+	// defaultTPMDevice is the TPM resource manager of the Linux kernel.
+	defaultTPMDevice = "/dev/tpmrm0"
+	// defaultTPMKeyHandle is the persistent handle of the key if
+	// TPMOptions.Handle is zero.
+	defaultTPMKeyHandle = 0x81000100
+	// tpmOwnerPersistentFirst and tpmOwnerPersistentLast bound the
+	// persistent handles of the owner hierarchy.
+	tpmOwnerPersistentFirst = 0x81000000
+	tpmOwnerPersistentLast  = 0x817FFFFF
+	// tpmSRKHandle is the well known persistent handle of the storage root
+	// key, which is reserved for it.
+	tpmSRKHandle = 0x81000001
+)
+
+// TPMOptions configures OpenTPM, which is only built on Linux with the tpm
+// build tag.
+type TPMOptions struct {
+	// Device is the TPM device, /dev/tpmrm0 by default. Without the kernel
+	// resource manager, the store must be the only user of the TPM.
+	Device string
+	// Handle is the persistent handle of the key in the owner hierarchy,
+	// between 0x81000000 and 0x817FFFFF. It defaults to 0x81000100.
+	Handle uint32
+	// CertDir is the directory of the certificate and the intermediate,
+	// which are stored like FileStorage stores them.
+	CertDir string
+}
+
+// withDefaults validates o and fills in the defaults.
+func (o TPMOptions) withDefaults() (TPMOptions, error) {
+	if o.CertDir == "" {
+		return o, &ArgError{Op: "OpenTPM", Arg: "opts", Reason: "no certificate directory configured"}
+	}
+	if o.Device == "" {
+		o.Device = defaultTPMDevice
+	}
+	if o.Handle == 0 {
+		o.Handle = defaultTPMKeyHandle
+	}
+	if o.Handle < tpmOwnerPersistentFirst || o.Handle > tpmOwnerPersistentLast || o.Handle == tpmSRKHandle {
+		return o, &ArgError{Op: "OpenTPM", Arg: "opts", Reason: fmt.Sprintf("handle %#x is not a persistent owner handle", o.Handle)}
+	}
+	return o, nil
+}
+
+// tpmSignature returns a signature returned by TPM2_Sign in the format
+// expected from a crypto.Signer, with ECDSA signatures ASN.1 encoded.
+func tpmSignature(sig *tpm2.Signature) ([]byte, error) {
+	switch {
+	case sig.RSA != nil:
+		return sig.RSA.Signature, nil
+	case sig.ECC != nil:
+		return asn1.Marshal(struct{ R, S *big.Int }{sig.ECC.R, sig.ECC.S})
+	}
+	return nil, fmt.Errorf("TPM returned an unexpected %v signature", sig.Alg)
+}
@@ -0,0 +1,482 @@
+// +build linux,tpm
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+)
+
+// tpmKeyAttributes are the attributes of the keys created by Generate. Like
+// the keys of the Platform Crypto Provider, they never leave the TPM and are
+// used without an authorization value.
+const tpmKeyAttributes = tpm2.FlagSign | tpm2.FlagFixedTPM | tpm2.FlagFixedParent | tpm2.FlagSensitiveDataOrigin | tpm2.FlagUserWithAuth
+
+// tpmSRKTemplate is the RSA storage root key template of the TCG TPM v2.0
+// Provisioning Guidance, which the keys are created under.
+var tpmSRKTemplate = tpm2.Public{
+	Type:       tpm2.AlgRSA,
+	NameAlg:    tpm2.AlgSHA256,
+	Attributes: tpm2.FlagStorageDefault | tpm2.FlagNoDA,
+	RSAParameters: &tpm2.RSAParams{
+		Symmetric:  &tpm2.SymScheme{Alg: tpm2.AlgAES, KeyBits: 128, Mode: tpm2.AlgCFB},
+		KeyBits:    2048,
+		ModulusRaw: make([]byte, 256),
+	},
+}
+
+// TPMStore stores a key persisted in the owner hierarchy of a TPM 2.0 and
+// its certificates in a directory, like the Platform Crypto Provider does on
+// Windows. Its methods are safe for concurrent use.
+type TPMStore struct {
+	handle tpmutil.Handle
+	certs  *FileStorage
+
+	// mu serializes the commands sent to rw. It is shared with the keys of
+	// the store.
+	mu *sync.Mutex
+	rw io.ReadWriteCloser
+	// srk is the transient handle of the storage root key, or 0 until it
+	// is needed.
+	srk tpmutil.Handle
+	// pending is the transient handle of the key created by Generate until
+	// it is stored, or 0.
+	pending tpmutil.Handle
+	// pendingKey is the key Generate returned for pending. Store binds it
+	// to the persistent handle, so that it stays usable.
+	pendingKey *TPMKey
+}
+
+var _ CertStorage = &TPMStore{}
+var _ AlgorithmGenerator = &TPMStore{}
+var _ ManagedStorage = &TPMStore{}
+
+// OpenTPM opens the TPM of opts. The store must be closed to release the
+// device.
+func OpenTPM(opts TPMOptions) (*TPMStore, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	rw, err := tpmutil.OpenTPM(opts.Device)
+	if err != nil {
+		return nil, fmt.Errorf("could not open TPM %s: %v", opts.Device, err)
+	}
+	return &TPMStore{
+		handle: tpmutil.Handle(opts.Handle),
+		certs:  NewFileStorage(opts.CertDir),
+		mu:     new(sync.Mutex),
+		rw:     rw,
+	}, nil
+}
+
+// Close flushes the transient objects of the store, including a key that was
+// generated but not stored, and closes the device. Keys returned by the
+// store cannot be used afterwards.
+func (s *TPMStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, h := range []tpmutil.Handle{s.pending, s.srk} {
+		if h != 0 {
+			tpm2.FlushContext(s.rw, h)
+		}
+	}
+	s.pending, s.pendingKey, s.srk = 0, nil, 0
+	return s.rw.Close()
+}
+
+// loadSRK returns the storage root key, creating it if needed. The template
+// is fixed, so the TPM derives the same key every time. s.mu must be held.
+func (s *TPMStore) loadSRK() (tpmutil.Handle, error) {
+	if s.srk != 0 {
+		return s.srk, nil
+	}
+	h, _, err := tpm2.CreatePrimary(s.rw, tpm2.HandleOwner, tpm2.PCRSelection{}, "", "", tpmSRKTemplate)
+	if err != nil {
+		return 0, fmt.Errorf("TPM2_CreatePrimary returned %v", err)
+	}
+	s.srk = h
+	return h, nil
+}
+
+// persisted reports whether the key handle of the store exists. s.mu must
+// be held.
+func (s *TPMStore) persisted() (bool, error) {
+	vals, _, err := tpm2.GetCapability(s.rw, tpm2.CapabilityHandles, 1, uint32(s.handle))
+	if err != nil {
+		return false, fmt.Errorf("TPM2_GetCapability returned %v", err)
+	}
+	return len(vals) > 0 && vals[0] == s.handle, nil
+}
+
+// key returns the TPMKey for the loaded key h. s.mu must be held.
+func (s *TPMStore) key(h tpmutil.Handle) (*TPMKey, error) {
+	pub, _, _, err := tpm2.ReadPublic(s.rw, h)
+	if err != nil {
+		return nil, fmt.Errorf("TPM2_ReadPublic(%#x) returned %v", uint32(h), err)
+	}
+	key, err := pub.Key()
+	if err != nil {
+		return nil, err
+	}
+	return &TPMKey{store: s, handle: h, pub: key}, nil
+}
+
+// Cert returns the current certificate or nil if there is none.
+func (s *TPMStore) Cert() (*x509.Certificate, error) {
+	return s.certs.Cert()
+}
+
+// Intermediate returns the current intermediate certificate or nil if there
+// is none.
+func (s *TPMStore) Intermediate() (*x509.Certificate, error) {
+	return s.certs.Intermediate()
+}
+
+// Generate creates a new RSA key of keySize bits, see GenerateAlgorithm.
+func (s *TPMStore) Generate(keySize int) (crypto.Signer, error) {
+	return s.GenerateAlgorithm(keySize, "RSA")
+}
+
+// GenerateAlgorithm creates a new key under the storage root key of the TPM and
+// returns a signer that can be used to make a CSR for the key. alg is "RSA",
+// "ECDSA_P256", "ECDSA_P384" or "ECDSA_P521", an empty alg generates an RSA
+// key. The key is only loaded until Store persists it in place of the current
+// key, so it is lost if the store is closed or Generate is called again
+// first, and the signer returned for it can no longer be used. Once Store
+// persists the key, the signer uses the persistent key.
+func (s *TPMStore) GenerateAlgorithm(keySize int, alg string) (crypto.Signer, error) {
+	tmpl := tpm2.Public{NameAlg: tpm2.AlgSHA256, Attributes: tpmKeyAttributes}
+	switch alg {
+	case "RSA", "":
+		if keySize <= 0 || keySize > 0xFFFF {
+			return nil, &ArgError{Op: "Generate", Arg: "keySize", Reason: fmt.Sprintf("invalid RSA key size %d", keySize)}
+		}
+		// RSA keys can also decrypt, as they can with CNG.
+		tmpl.Type = tpm2.AlgRSA
+		tmpl.Attributes |= tpm2.FlagDecrypt
+		tmpl.RSAParameters = &tpm2.RSAParams{KeyBits: uint16(keySize)}
+	case "ECDSA_P256":
+		tmpl.Type = tpm2.AlgECC
+		tmpl.ECCParameters = &tpm2.ECCParams{CurveID: tpm2.CurveNISTP256}
+	case "ECDSA_P384":
+		tmpl.Type = tpm2.AlgECC
+		tmpl.ECCParameters = &tpm2.ECCParams{CurveID: tpm2.CurveNISTP384}
+	case "ECDSA_P521":
+		tmpl.Type = tpm2.AlgECC
+		tmpl.ECCParameters = &tpm2.ECCParams{CurveID: tpm2.CurveNISTP521}
+	default:
+		return nil, fmt.Errorf("unsupported algorithm: %s", alg)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	srk, err := s.loadSRK()
+	if err != nil {
+		return nil, err
+	}
+	priv, pub, _, _, _, err := tpm2.CreateKey(s.rw, srk, tpm2.PCRSelection{}, "", "", tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("TPM2_Create returned %v", err)
+	}
+	h, _, err := tpm2.Load(s.rw, srk, "", pub, priv)
+	if err != nil {
+		return nil, fmt.Errorf("TPM2_Load returned %v", err)
+	}
+	k, err := s.key(h)
+	if err != nil {
+		tpm2.FlushContext(s.rw, h)
+		return nil, err
+	}
+	if s.pending != 0 {
+		tpm2.FlushContext(s.rw, s.pending)
+	}
+	s.pending, s.pendingKey = h, k
+	return k, nil
+}
+
+// Store persists the key created by the last Generate call, if any, in place
+// of the current key and writes cert and intermediate to the certificate
+// directory. cert must be issued for the key.
+func (s *TPMStore) Store(cert *x509.Certificate, intermediate *x509.Certificate) error {
+	if err := checkCert("Store", "cert", cert); err != nil {
+		return err
+	}
+	if err := checkCert("Store", "intermediate", intermediate); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h := s.pending
+	if h == 0 {
+		ok, err := s.persisted()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("no key to store the certificate for, call Generate first")
+		}
+		h = s.handle
+	}
+	k, err := s.key(h)
+	if err != nil {
+		return err
+	}
+	if !k.pub.(interface{ Equal(crypto.PublicKey) bool }).Equal(cert.PublicKey) {
+		return fmt.Errorf("certificate %q is not issued for the key", cert.Subject)
+	}
+	if s.pending != 0 {
+		if err := s.replace(); err != nil {
+			return err
+		}
+	}
+	return s.certs.Store(cert, intermediate)
+}
+
+// replace persists the pending key at the handle of the store in place of
+// the current key. The new key is persisted at a spare handle before the
+// current key is evicted, so that it survives if persisting it at the
+// handle of the store fails. s.mu must be held.
+func (s *TPMStore) replace() error {
+	ok, err := s.persisted()
+	if err != nil {
+		return err
+	}
+	var spare tpmutil.Handle
+	if ok {
+		if spare, err = s.spareHandle(); err != nil {
+			return err
+		}
+		if err := tpm2.EvictControl(s.rw, "", tpm2.HandleOwner, s.pending, spare); err != nil {
+			return fmt.Errorf("TPM2_EvictControl persisting %#x returned %v", uint32(spare), err)
+		}
+		if err := s.evict(); err != nil {
+			tpm2.EvictControl(s.rw, "", tpm2.HandleOwner, spare, spare)
+			return err
+		}
+	}
+	if err := tpm2.EvictControl(s.rw, "", tpm2.HandleOwner, s.pending, s.handle); err != nil {
+		if spare != 0 {
+			return fmt.Errorf("TPM2_EvictControl persisting %#x returned %v, the new key is persisted at %#x", uint32(s.handle), err, uint32(spare))
+		}
+		return fmt.Errorf("TPM2_EvictControl persisting %#x returned %v", uint32(s.handle), err)
+	}
+	if spare != 0 {
+		if err := tpm2.EvictControl(s.rw, "", tpm2.HandleOwner, spare, spare); err != nil {
+			logWarning("Could not evict the spare copy of the new key.", opField("Store"), field("handle", fmt.Sprintf("%#x", uint32(spare))), errField(err))
+		}
+	}
+	tpm2.FlushContext(s.rw, s.pending)
+	// The signer returned by Generate now uses the persistent key.
+	s.pendingKey.handle = s.handle
+	s.pending, s.pendingKey = 0, nil
+	return nil
+}
+
+// spareHandle returns a persistent owner handle that is not in use. s.mu must
+// be held.
+func (s *TPMStore) spareHandle() (tpmutil.Handle, error) {
+	used := make(map[tpmutil.Handle]bool)
+	for next := uint32(tpmOwnerPersistentFirst); ; {
+		vals, more, err := tpm2.GetCapability(s.rw, tpm2.CapabilityHandles, 64, next)
+		if err != nil {
+			return 0, fmt.Errorf("TPM2_GetCapability returned %v", err)
+		}
+		for _, v := range vals {
+			h := v.(tpmutil.Handle)
+			used[h] = true
+			next = uint32(h) + 1
+		}
+		if !more || len(vals) == 0 {
+			break
+		}
+	}
+	for h := tpmutil.Handle(tpmOwnerPersistentFirst); h <= tpmOwnerPersistentLast; h++ {
+		if !used[h] && h != tpmSRKHandle && h != s.handle {
+			return h, nil
+		}
+	}
+	return 0, errors.New("no free persistent handle for the new key")
+}
+
+// evict removes the persisted key, if there is one. s.mu must be held.
+func (s *TPMStore) evict() error {
+	ok, err := s.persisted()
+	if err != nil || !ok {
+		return err
+	}
+	if err := tpm2.EvictControl(s.rw, "", tpm2.HandleOwner, s.handle, s.handle); err != nil {
+		return fmt.Errorf("TPM2_EvictControl evicting %#x returned %v", uint32(s.handle), err)
+	}
+	return nil
+}
+
+// Signer returns the persisted key or nil if there is none. The key returned
+// by Generate is only persisted by Store.
+func (s *TPMStore) Signer() (crypto.Signer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ok, err := s.persisted()
+	if err != nil || !ok {
+		return nil, err
+	}
+	return s.key(s.handle)
+}
+
+// Remove evicts the persisted key and deletes the certificates. A TPM has no
+// system wide location, so removeSystem is ignored.
+func (s *TPMStore) Remove(removeSystem bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.evict(); err != nil {
+		return err
+	}
+	return s.certs.Remove(removeSystem)
+}
+
+// Link does nothing, the key is available to every user with access to the
+// TPM device.
+func (s *TPMStore) Link() error {
+	return nil
+}
+
+// TPMKey is a key loaded in a TPM. It implements crypto.Signer and, for RSA
+// keys, crypto.Decrypter. ECDSA signatures are ASN.1 encoded like those of
+// crypto/ecdsa.
+type TPMKey struct {
+	store *TPMStore
+	// handle is the loaded key. Store moves the key returned by Generate to
+	// the persistent handle, so it is guarded by store.mu.
+	handle tpmutil.Handle
+	pub    crypto.PublicKey
+}
+
+var (
+	_ crypto.Signer    = &TPMKey{}
+	_ crypto.Decrypter = &TPMKey{}
+	_ CryptoKey        = &TPMKey{}
+)
+
+// Public returns the public key to implement crypto.Signer.
+func (k *TPMKey) Public() crypto.PublicKey {
+	return k.pub
+}
+
+// PublicDER returns the DER encoded SubjectPublicKeyInfo of the key.
+func (k *TPMKey) PublicDER() ([]byte, error) {
+	return publicDER(k.pub)
+}
+
+// PublicPEM returns the SubjectPublicKeyInfo of the key as a PEM block.
+func (k *TPMKey) PublicPEM() ([]byte, error) {
+	return publicPEM(k.pub)
+}
+
+// Sign signs digest with the key to implement crypto.Signer. If opts is a
+// *rsa.PSSOptions RSA signatures use PSS padding, otherwise PKCS #1 v1.5.
+// The TPM uses a PSS salt as long as the hash, so other explicit salt lengths
+// are rejected.
+func (k *TPMKey) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts == nil {
+		return nil, &ArgError{Op: "Sign", Arg: "opts", Reason: "opts is nil"}
+	}
+	hf := opts.HashFunc()
+	if err := checkDigest("Sign", digest, hf); err != nil {
+		return nil, err
+	}
+	hashAlg, err := tpm2.HashToAlgorithm(hf)
+	if err != nil {
+		return nil, err
+	}
+	scheme := &tpm2.SigScheme{Hash: hashAlg}
+	switch k.pub.(type) {
+	case *rsa.PublicKey:
+		scheme.Alg = tpm2.AlgRSASSA
+		if pssOpts, ok := opts.(*rsa.PSSOptions); ok {
+			if salt := pssOpts.SaltLength; salt != rsa.PSSSaltLengthAuto && salt != rsa.PSSSaltLengthEqualsHash && salt != hf.Size() {
+				return nil, fmt.Errorf("PSS salt length %d is not supported by the TPM, which uses %d", salt, hf.Size())
+			}
+			scheme.Alg = tpm2.AlgRSAPSS
+		}
+	case *ecdsa.PublicKey:
+		scheme.Alg = tpm2.AlgECDSA
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", k.pub)
+	}
+
+	k.store.mu.Lock()
+	sig, err := tpm2.Sign(k.store.rw, k.handle, "", digest, nil, scheme)
+	k.store.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("TPM2_Sign returned %v", err)
+	}
+	return tpmSignature(sig)
+}
+
+// SignMessage hashes the message read from r with hash and signs the digest.
+func (k *TPMKey) SignMessage(r io.Reader, hash crypto.Hash) ([]byte, error) {
+	return SignMessage(k, r, hash)
+}
+
+// Decrypt decrypts blob with an RSA key to implement crypto.Decrypter. opts
+// is a *rsa.OAEPOptions for OAEP padding, or nil or a
+// *rsa.PKCS1v15DecryptOptions for PKCS #1 v1.5 padding. ECDSA keys return
+// ErrNotSupported.
+func (k *TPMKey) Decrypt(rand io.Reader, blob []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	if _, ok := k.pub.(*rsa.PublicKey); !ok {
+		return nil, ErrNotSupported
+	}
+	if err := checkNotEmpty("Decrypt", "blob", blob); err != nil {
+		return nil, err
+	}
+	var scheme *tpm2.AsymScheme
+	var label string
+	switch opts := opts.(type) {
+	case nil, *rsa.PKCS1v15DecryptOptions:
+		scheme = &tpm2.AsymScheme{Alg: tpm2.AlgRSAES}
+	case *rsa.OAEPOptions:
+		if opts.MGFHash != 0 && opts.MGFHash != opts.Hash {
+			return nil, fmt.Errorf("MGF1 hash %v differs from the OAEP hash %v", opts.MGFHash, opts.Hash)
+		}
+		hashAlg, err := tpm2.HashToAlgorithm(opts.Hash)
+		if err != nil {
+			return nil, err
+		}
+		scheme = &tpm2.AsymScheme{Alg: tpm2.AlgOAEP, Hash: hashAlg}
+		label = string(opts.Label)
+	default:
+		return nil, fmt.Errorf("unsupported decrypter options %T", opts)
+	}
+
+	k.store.mu.Lock()
+	defer k.store.mu.Unlock()
+	plain, err := tpm2.RSADecrypt(k.store.rw, k.handle, "", blob, scheme, label)
+	if err != nil {
+		return nil, fmt.Errorf("TPM2_RSA_Decrypt returned %v", err)
+	}
+	return plain, nil
+}
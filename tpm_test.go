@@ -0,0 +1,76 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/google/certtostore/testdata/helpers"
+	"github.com/google/go-tpm/legacy/tpm2"
+)
+
+func TestTPMOptions(t *testing.T) {
+	o, err := TPMOptions{CertDir: "/var/lib/agent"}.withDefaults()
+	if err != nil {
+		t.Fatalf("withDefaults returned %v", err)
+	}
+	if o.Device != defaultTPMDevice || o.Handle != defaultTPMKeyHandle {
+		t.Errorf("withDefaults returned device %q and handle %#x, want %q and %#x", o.Device, o.Handle, defaultTPMDevice, defaultTPMKeyHandle)
+	}
+
+	for _, tc := range []struct {
+		desc string
+		opts TPMOptions
+	}{
+		{"no cert dir", TPMOptions{}},
+		{"transient handle", TPMOptions{CertDir: "/tmp", Handle: 0x80000001}},
+		{"platform handle", TPMOptions{CertDir: "/tmp", Handle: 0x81800000}},
+		{"SRK handle", TPMOptions{CertDir: "/tmp", Handle: tpmSRKHandle}},
+	} {
+		if _, err := tc.opts.withDefaults(); err == nil {
+			t.Errorf("%s: withDefaults succeeded", tc.desc)
+		}
+	}
+}
+
+func TestTPMSignatureHelperVectors(t *testing.T) {
+	rsaSig, err := rsa.SignPKCS1v15(nil, helpers.RSAKey(), crypto.SHA256, helpers.Digest)
+	if err != nil {
+		t.Fatalf("SignPKCS1v15 returned %v", err)
+	}
+	sig, err := tpmSignature(&tpm2.Signature{Alg: tpm2.AlgRSASSA, RSA: &tpm2.SignatureRSA{HashAlg: tpm2.AlgSHA256, Signature: rsaSig}})
+	if err != nil {
+		t.Fatalf("tpmSignature(RSA) returned %v", err)
+	}
+	helpers.AssertRSAPKCS1Signature(t, sig)
+
+	r, s, err := ecdsa.Sign(rand.Reader, helpers.ECDSAKey(), helpers.Digest)
+	if err != nil {
+		t.Fatalf("ecdsa.Sign returned %v", err)
+	}
+	sig, err = tpmSignature(&tpm2.Signature{Alg: tpm2.AlgECDSA, ECC: &tpm2.SignatureECC{HashAlg: tpm2.AlgSHA256, R: r, S: s}})
+	if err != nil {
+		t.Fatalf("tpmSignature(ECDSA) returned %v", err)
+	}
+	helpers.AssertECDSASignature(t, sig)
+
+	if _, err := tpmSignature(&tpm2.Signature{Alg: tpm2.AlgHMAC}); err == nil {
+		t.Error("tpmSignature succeeded without a signature")
+	}
+}
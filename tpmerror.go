@@ -0,0 +1,138 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import "fmt"
+
+// TPM status codes as surfaced by the Microsoft Platform Crypto Provider.
+const (
+	tpmERetry             = 0x80280800 // TPM_E_RETRY
+	tpmEDefendLockRunning = 0x80280803 // TPM_E_DEFEND_LOCK_RUNNING
+	tpmEDeactivated       = 0x80280006 // TPM_E_DEACTIVATED
+	tpmEDisabled          = 0x80280007 // TPM_E_DISABLED
+	tpmENoSRK             = 0x80280012 // TPM_E_NOSRK
+	tpm20EDisabled        = 0x80280120 // TPM_20_E_DISABLED
+	tpm20EYielded         = 0x80280908 // TPM_20_E_YIELDED
+	tpm20ETesting         = 0x8028090A // TPM_20_E_TESTING
+	tpm20ELockout         = 0x80280921 // TPM_20_E_LOCKOUT
+	tpm20ERetry           = 0x80280922 // TPM_20_E_RETRY
+	tbsEServiceNotRunning = 0x80284008 // TBS_E_SERVICE_NOT_RUNNING
+	tbsETPMNotFound       = 0x8028400F // TBS_E_TPM_NOT_FOUND
+	tbsEOwnerAuthNotFound = 0x80284015 // TBS_E_OWNERAUTH_NOT_FOUND
+	tpmEPCPDeviceNotReady = 0x80290101 // TPM_E_PCP_DEVICE_NOT_READY
+	nteDeviceNotReady     = 0x80090030 // NTE_DEVICE_NOT_READY
+	nteDeviceNotFound     = 0x80090035 // NTE_DEVICE_NOT_FOUND
+)
+
+// TPMCondition classifies TPM failures that callers may want to handle
+// differently from other errors.
+type TPMCondition int
+
+const (
+	// TPMBusy means the TPM is temporarily unable to process the command.
+	TPMBusy TPMCondition = iota + 1
+	// TPMLockout means the TPM is in dictionary attack lockout.
+	TPMLockout
+	// TPMNotProvisioned means there is no usable TPM, because it is missing,
+	// disabled or has not been provisioned for use by Windows.
+	TPMNotProvisioned
+	// TPMDeviceNotReady means the TPM or the TPM Base Services are not ready
+	// yet, which is common shortly after boot.
+	TPMDeviceNotReady
+)
+
+func (c TPMCondition) String() string {
+	switch c {
+	case TPMBusy:
+		return "TPM busy"
+	case TPMLockout:
+		return "TPM in lockout"
+	case TPMNotProvisioned:
+		return "TPM not provisioned"
+	case TPMDeviceNotReady:
+		return "TPM device not ready"
+	default:
+		return fmt.Sprintf("TPMCondition(%d)", int(c))
+	}
+}
+
+// Remediation suggests how a caller can recover from a TPMError.
+type Remediation int
+
+const (
+	// RemediationRetryLater means the operation may succeed if it is retried
+	// later without any other change.
+	RemediationRetryLater Remediation = iota + 1
+	// RemediationUseSoftware means the TPM cannot be used on this machine
+	// without administrator action, so callers should fall back to a software
+	// provider such as ProviderMSSoftware.
+	RemediationUseSoftware
+)
+
+func (r Remediation) String() string {
+	switch r {
+	case RemediationRetryLater:
+		return "retry later"
+	case RemediationUseSoftware:
+		return "fall back to a software provider"
+	default:
+		return fmt.Sprintf("Remediation(%d)", int(r))
+	}
+}
+
+// TPMError is returned when a key operation fails because of the state of
+// the TPM rather than because of the request.
+type TPMError struct {
+	// Op is the operation that failed, such as "NCryptFinalizeKey".
+	Op          string
+	Status      uint32
+	Condition   TPMCondition
+	Remediation Remediation
+}
+
+func (e *TPMError) Error() string {
+	return fmt.Sprintf("%s failed: %v (%X), %v", e.Op, e.Condition, e.Status, e.Remediation)
+}
+
+// newTPMError returns a *TPMError if status indicates a TPM condition, and
+// nil otherwise.
+func newTPMError(op string, status uint32) *TPMError {
+	c, ok := tpmCondition(status)
+	if !ok {
+		return nil
+	}
+	rem := RemediationRetryLater
+	if c == TPMNotProvisioned {
+		rem = RemediationUseSoftware
+	}
+	return &TPMError{Op: op, Status: status, Condition: c, Remediation: rem}
+}
+
+// tpmCondition returns the condition indicated by a status code, and false
+// if the status code does not indicate one.
+func tpmCondition(status uint32) (TPMCondition, bool) {
+	switch status {
+	case tpmERetry, tpm20EYielded, tpm20ETesting, tpm20ERetry:
+		return TPMBusy, true
+	case tpmEDefendLockRunning, tpm20ELockout:
+		return TPMLockout, true
+	case tpmEDeactivated, tpmEDisabled, tpmENoSRK, tpm20EDisabled, tbsETPMNotFound, tbsEOwnerAuthNotFound, nteDeviceNotFound:
+		return TPMNotProvisioned, true
+	case nteDeviceNotReady, tpmEPCPDeviceNotReady, tbsEServiceNotRunning:
+		return TPMDeviceNotReady, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"testing"
+)
+
+func TestTPMCondition(t *testing.T) {
+	for status, want := range map[uint32]TPMCondition{
+		tpm20ELockout:         TPMLockout,
+		tpmEDefendLockRunning: TPMLockout,
+		tpm20ERetry:           TPMBusy,
+		tpm20EYielded:         TPMBusy,
+		tbsETPMNotFound:       TPMNotProvisioned,
+		nteDeviceNotReady:     TPMDeviceNotReady,
+	} {
+		got, ok := tpmCondition(status)
+		if !ok || got != want {
+			t.Errorf("tpmCondition(%X) = %v, %t, want: %v", status, got, ok, want)
+		}
+	}
+	if c, ok := tpmCondition(0x80090029); ok {
+		t.Errorf("tpmCondition(NTE_NOT_SUPPORTED) = %v, want none", c)
+	}
+}
+
+func TestNewTPMError(t *testing.T) {
+	if err := newTPMError("NCryptOpenKey", 0x80090016); err != nil {
+		t.Errorf("newTPMError(NTE_BAD_KEYSET) = %v, want nil", err)
+	}
+	for status, want := range map[uint32]Remediation{
+		tpm20ELockout:         RemediationRetryLater,
+		tpmEPCPDeviceNotReady: RemediationRetryLater,
+		tpmEDisabled:          RemediationUseSoftware,
+	} {
+		err := newTPMError("NCryptSignHash", status)
+		if err == nil {
+			t.Errorf("newTPMError(%X) = nil, want error", status)
+			continue
+		}
+		if err.Remediation != want {
+			t.Errorf("unexpected remediation for %X got: %v, want: %v", status, err.Remediation, want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Common use context tags. Keys can be tagged with any string, these only
+// give fleets that share a provider the same names for the usual purposes.
+const (
+	UseContextDeviceIdentity = "device-identity"
+	UseContextVPN            = "vpn"
+	UseContextWiFi           = "wifi"
+)
+
+// maxUseContextLen is the maximum length of a use context tag in characters.
+// CNG does not limit the property, but tags are meant to be short labels.
+const maxUseContextLen = 256
+
+// checkUseContext returns an *ArgError if tag cannot be stored as the
+// NCRYPT_USE_CONTEXT_PROPERTY of a key.
+func checkUseContext(op, tag string) error {
+	switch {
+	case tag == "":
+		return &ArgError{Op: op, Arg: "tag", Reason: "use context is empty"}
+	case !utf8.ValidString(tag):
+		return &ArgError{Op: op, Arg: "tag", Reason: "use context is not valid UTF-8"}
+	case strings.ContainsRune(tag, 0):
+		return &ArgError{Op: op, Arg: "tag", Reason: "use context contains a NUL character"}
+	case utf8.RuneCountInString(tag) > maxUseContextLen:
+		return &ArgError{Op: op, Arg: "tag", Reason: "use context is too long"}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+//go:build windows
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"time"
+	"unicode/utf16"
+	"unsafe"
+)
+
+const (
+	// ncryptUseContextProperty is NCRYPT_USE_CONTEXT_PROPERTY in ncrypt.h.
+	ncryptUseContextProperty = "Use Context"
+
+	// winerror.h constants
+	nteNotFound    = 0x80090011 // NTE_NOT_FOUND
+	nteNoMoreItems = 0x8009002A // NTE_NO_MORE_ITEMS
+)
+
+var (
+	nCryptEnumKeys   = nCrypt.MustFindProc("NCryptEnumKeys")
+	nCryptFreeBuffer = nCrypt.MustFindProc("NCryptFreeBuffer")
+)
+
+// ncryptKeyName is the NCryptKeyName struct in ncrypt.h.
+type ncryptKeyName struct {
+	name          *uint16
+	algID         *uint16
+	legacyKeySpec uint32
+	flags         uint32
+}
+
+// UseContext returns the use context tag of the key, or "" if it was never
+// tagged.
+func (k *RsaKey) UseContext() (string, error) {
+	return useContext(k.handle)
+}
+
+// UseContext returns the use context tag of the key, or "" if it was never
+// tagged.
+func (k *EcdsaKey) UseContext() (string, error) {
+	return useContext(k.handle)
+}
+
+// SetUseContext persists tag, such as UseContextVPN, as the
+// NCRYPT_USE_CONTEXT_PROPERTY of the key, so that keys with different
+// purposes in the same provider can be told apart with WinCertStore.Keys.
+func (k *RsaKey) SetUseContext(tag string) (err error) {
+	defer keyOp(k.stats, k.breaker, "setusecontext", k.Container, time.Now(), &err)
+	if err := k.breaker.allow(); err != nil {
+		return err
+	}
+	return setUseContext(k.handle, tag)
+}
+
+// SetUseContext persists tag, such as UseContextVPN, as the
+// NCRYPT_USE_CONTEXT_PROPERTY of the key, so that keys with different
+// purposes in the same provider can be told apart with WinCertStore.Keys.
+func (k *EcdsaKey) SetUseContext(tag string) (err error) {
+	defer keyOp(k.stats, k.breaker, "setusecontext", k.Container, time.Now(), &err)
+	if err := k.breaker.allow(); err != nil {
+		return err
+	}
+	return setUseContext(k.handle, tag)
+}
+
+func useContext(kh uintptr) (string, error) {
+	tag, err := getPropertyString(kh, ncryptUseContextProperty)
+	if e, ok := err.(*ncryptError); ok && e.status == nteNotFound {
+		return "", nil
+	}
+	return tag, err
+}
+
+func setUseContext(kh uintptr, tag string) error {
+	if err := checkUseContext("SetUseContext", tag); err != nil {
+		return err
+	}
+	return setProperty(kh, ncryptUseContextProperty, utf16Bytes(tag), ncryptPersistFlag)
+}
+
+// Keys opens the keys in the provider and namespace of w whose use context
+// is tag, or all keys if tag is empty. Keys of algorithms that are not supported, and keys
+// that cannot be opened, are skipped. The caller must close the keys.
+func (w *WinCertStore) Keys(tag string) (_ []Key, err error) {
+	defer logKeyOp("keys", w.container, time.Now(), &err)
+	names, err := w.keyNames()
+	if err != nil {
+		return nil, err
+	}
+	var keys []Key
+	for _, name := range names {
+		if !inNamespace(w.namespace, name) {
+			continue
+		}
+		k, err := w.containerKey(name)
+		if err != nil {
+			logDebug("Skipping key.", opField("keys"), containerField(name), errField(err))
+			continue
+		}
+		if tag != "" {
+			got, err := k.UseContext()
+			if err != nil || got != tag {
+				k.Close()
+				continue
+			}
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// keyNames returns the names of the persisted keys in the provider of w.
+func (w *WinCertStore) keyNames() ([]string, error) {
+	var names []string
+	var state uintptr
+	defer func() {
+		if state != 0 {
+			nCryptFreeBuffer.Call(state)
+		}
+	}()
+	for {
+		var kn *ncryptKeyName
+		r, _, err := nCryptEnumKeys.Call(
+			w.Prov,
+			0,
+			uintptr(unsafe.Pointer(&kn)),
+			uintptr(unsafe.Pointer(&state)),
+			uintptr(w.keyOpenFlags()))
+		if r == nteNoMoreItems {
+			return names, nil
+		}
+		if r != 0 {
+			return nil, ncryptErr("NCryptEnumKeys", r, "", err)
+		}
+		names = append(names, utf16PtrToString(kn.name))
+		nCryptFreeBuffer.Call(uintptr(unsafe.Pointer(kn)))
+	}
+}
+
+// utf16PtrToString returns the NUL terminated string at p.
+func utf16PtrToString(p *uint16) string {
+	if p == nil {
+		return ""
+	}
+	a := (*[1 << 29]uint16)(unsafe.Pointer(p))
+	n := 0
+	for a[n] != 0 {
+		n++
+	}
+	return string(utf16.Decode(a[:n:n]))
+}
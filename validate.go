@@ -0,0 +1,76 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+)
+
+// ArgError is returned for invalid arguments to the public API. They are
+// rejected before they are passed to a Windows API, where they could crash
+// the process or result in an unhelpful error.
+type ArgError struct {
+	// Op is the function or method, such as "Sign".
+	Op string
+	// Arg is the name of the invalid argument, such as "digest".
+	Arg string
+	// Reason describes why the argument is invalid.
+	Reason string
+}
+
+func (e *ArgError) Error() string {
+	return fmt.Sprintf("%s: invalid %s: %s", e.Op, e.Arg, e.Reason)
+}
+
+// checkDigest returns an *ArgError if digest is empty, or if hash is set and
+// digest does not have its size.
+func checkDigest(op string, digest []byte, hash crypto.Hash) error {
+	if len(digest) == 0 {
+		return &ArgError{Op: op, Arg: "digest", Reason: "digest is empty"}
+	}
+	if hash != 0 && hash.Available() && len(digest) != hash.Size() {
+		return &ArgError{Op: op, Arg: "digest", Reason: fmt.Sprintf("%d bytes do not match the %d bytes of %v", len(digest), hash.Size(), hash)}
+	}
+	return nil
+}
+
+// checkCert returns an *ArgError if cert is nil or has no DER encoding.
+func checkCert(op, arg string, cert *x509.Certificate) error {
+	if cert == nil {
+		return &ArgError{Op: op, Arg: arg, Reason: "certificate is nil"}
+	}
+	if len(cert.Raw) == 0 {
+		return &ArgError{Op: op, Arg: arg, Reason: "certificate has no DER encoding"}
+	}
+	return nil
+}
+
+// checkNotEmpty returns an *ArgError if b is empty.
+func checkNotEmpty(op, arg string, b []byte) error {
+	if len(b) == 0 {
+		return &ArgError{Op: op, Arg: arg, Reason: "data is empty"}
+	}
+	return nil
+}
+
+// checkContainer returns an *ArgError if the key container name is empty.
+func checkContainer(op, name string) error {
+	if name == "" {
+		return &ArgError{Op: op, Arg: "container", Reason: "container name is empty"}
+	}
+	return nil
+}
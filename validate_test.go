@@ -0,0 +1,65 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto"
+	"crypto/x509"
+	"strings"
+	"testing"
+)
+
+func TestArgChecks(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		ok   bool
+	}{
+		{"digest", checkDigest("Sign", make([]byte, 32), crypto.SHA256), true},
+		{"raw digest", checkDigest("SignRaw", make([]byte, 20), 0), true},
+		{"empty digest", checkDigest("Sign", nil, crypto.SHA256), false},
+		{"short digest", checkDigest("Sign", make([]byte, 20), crypto.SHA256), false},
+		{"cert", checkCert("Store", "cert", &x509.Certificate{Raw: []byte{0x30}}), true},
+		{"nil cert", checkCert("Store", "cert", nil), false},
+		{"unencoded cert", checkCert("Store", "cert", &x509.Certificate{}), false},
+		{"data", checkNotEmpty("AddCTL", "encoded", []byte{1}), true},
+		{"empty data", checkNotEmpty("AddCTL", "encoded", nil), false},
+		{"container", checkContainer("Key", "c"), true},
+		{"empty container", checkContainer("Key", ""), false},
+		{"use context", checkUseContext("SetUseContext", UseContextVPN), true},
+		{"empty use context", checkUseContext("SetUseContext", ""), false},
+		{"use context with NUL", checkUseContext("SetUseContext", "vpn\x00wifi"), false},
+		{"invalid use context", checkUseContext("SetUseContext", "\xff"), false},
+		{"long use context", checkUseContext("SetUseContext", strings.Repeat("x", maxUseContextLen+1)), false},
+	}
+	for _, tt := range tests {
+		if (tt.err == nil) != tt.ok {
+			t.Errorf("%s: check returned %v, want success: %t", tt.name, tt.err, tt.ok)
+			continue
+		}
+		if tt.err != nil {
+			if _, ok := tt.err.(*ArgError); !ok {
+				t.Errorf("%s: check returned %T, want *ArgError", tt.name, tt.err)
+			}
+		}
+	}
+}
+
+func TestFileStoreStoreNilCert(t *testing.T) {
+	err := NewFileStorage(t.Name()).Store(nil, nil)
+	if _, ok := err.(*ArgError); !ok {
+		t.Errorf("Store(nil, nil) returned %v, want *ArgError", err)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/binary"
+	"fmt"
+	"syscall"
+	"unicode/utf16"
+)
+
+const (
+	// winerror.h constants
+	cryptENotFound = 0x80092004 // CRYPT_E_NOT_FOUND
+
+	bCryptRSAPublicBlob = "RSAPUBLICBLOB" // BCRYPT_RSAPUBLIC_BLOB
+	bCryptECCPublicBlob = "ECCPUBLICBLOB" // BCRYPT_ECCPUBLIC_BLOB
+)
+
+// winAPI is the layer between the Windows logic of the package and the
+// nCrypt and crypt32 functions it calls. Each method wraps one function with
+// byte slices in place of buffer pointers and lengths, and returns what the
+// function returned, so that the logic interpreting the results, such as the
+// size queries and the mapping of status codes to errors, can be tested with
+// a fake on any platform. An empty out only queries the size.
+type winAPI interface {
+	NCryptGetProperty(h uintptr, property string, out []byte) (size uint32, status uintptr, err error)
+	NCryptSetProperty(h uintptr, property string, value []byte, flags uint32) (status uintptr, err error)
+	NCryptExportKey(kh uintptr, blobType string, out []byte) (size uint32, status uintptr, err error)
+	// CertGetCertificateContextProperty returns false, with the last error
+	// in err, if the function failed.
+	CertGetCertificateContextProperty(certContext uintptr, propID uint32, out []byte) (size uint32, ok bool, err error)
+}
+
+// ncryptError is returned when an NCrypt function reports a failure status.
+type ncryptError struct {
+	fn     string
+	status uintptr
+	detail string
+	err    error
+}
+
+// ncryptErr returns a *TPMError if status indicates a TPM condition and an
+// *ncryptError otherwise.
+func ncryptErr(fn string, status uintptr, detail string, err error) error {
+	if te := newTPMError(fn, uint32(status)); te != nil {
+		return te
+	}
+	return &ncryptError{fn: fn, status: status, detail: detail, err: err}
+}
+
+func (e *ncryptError) Error() string {
+	if e.detail == "" {
+		return fmt.Sprintf("%s returned %X: %v", e.fn, e.status, e.err)
+	}
+	return fmt.Sprintf("%s returned %X %s: %v", e.fn, e.status, e.detail, e.err)
+}
+
+func getKeyType(kh uintptr) (string, error) {
+	return getPropertyString(kh, "Algorithm Group")
+}
+
+// getProperty wraps NCryptGetProperty and returns the raw value of the property.
+func getProperty(kh uintptr, property string) ([]byte, error) {
+	size, r, err := winSys.NCryptGetProperty(kh, property, nil)
+	if r != 0 {
+		return nil, ncryptErr("NCryptGetProperty("+property+")", r, "during size check", err)
+	}
+	if size == 0 {
+		return []byte{}, nil
+	}
+
+	buf := make([]byte, size)
+	size, r, err = winSys.NCryptGetProperty(kh, property, buf)
+	if r != 0 {
+		return nil, ncryptErr("NCryptGetProperty("+property+")", r, "during export", err)
+	}
+	return buf[:size], nil
+}
+
+// getPropertyString returns the value of a string property.
+func getPropertyString(kh uintptr, property string) (string, error) {
+	buf, err := getProperty(kh, property)
+	if err != nil {
+		return "", err
+	}
+	return utf16BytesToString(buf), nil
+}
+
+// setProperty wraps NCryptSetProperty.
+func setProperty(kh uintptr, property string, value []byte, flags uint32) error {
+	// Microsoft function calls return actionable return codes in r, err is often filled with text, even when successful
+	if r, err := winSys.NCryptSetProperty(kh, property, value, flags); r != 0 {
+		return ncryptErr("NCryptSetProperty ("+property+")", r, "", err)
+	}
+	return nil
+}
+
+// setPropertyUint32 sets a DWORD property.
+func setPropertyUint32(kh uintptr, property string, value uint32, flags uint32) error {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, value)
+	return setProperty(kh, property, b, flags)
+}
+
+// container returns the unique container name of a private key
+func container(kh uintptr) (string, error) {
+	return getPropertyString(kh, "Unique Name")
+}
+
+func exportRSA(kh uintptr) (*rsa.PublicKey, error) {
+	buf, err := exportKey(kh, bCryptRSAPublicBlob)
+	if err != nil {
+		return nil, err
+	}
+	return UnmarshalRSAPublicBlob(buf)
+}
+
+func exportEcdsa(kh uintptr) (*ecdsa.PublicKey, error) {
+	buf, err := exportKey(kh, bCryptECCPublicBlob)
+	if err != nil {
+		return nil, err
+	}
+	return UnmarshalECCPublicBlob(buf)
+}
+
+// exportKey wraps NCryptExportKey and returns the key exported as blobType.
+func exportKey(kh uintptr, blobType string) ([]byte, error) {
+	size, r, err := winSys.NCryptExportKey(kh, blobType, nil)
+	if r != 0 {
+		return nil, ncryptErr("NCryptExportKey", r, "during size check", err)
+	}
+
+	// Place the exported key in buf now that we know the size required
+	buf := make([]byte, size)
+	size, r, err = winSys.NCryptExportKey(kh, blobType, buf)
+	if r != 0 {
+		return nil, ncryptErr("NCryptExportKey", r, "during export", err)
+	}
+
+	wipe(buf[size:])
+	return buf[:size], nil
+}
+
+// contextProperty wraps CertGetCertificateContextProperty. It returns nil if
+// the certificate context does not have the property.
+func contextProperty(certContext uintptr, propID uint32) ([]byte, error) {
+	size, ok, err := winSys.CertGetCertificateContextProperty(certContext, propID, nil)
+	if !ok {
+		if errno, isErrno := err.(syscall.Errno); isErrno && errno == cryptENotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("CertGetCertificateContextProperty returned %v during size check", err)
+	}
+	if size == 0 {
+		return []byte{}, nil
+	}
+
+	buf := make([]byte, size)
+	size, ok, err = winSys.CertGetCertificateContextProperty(certContext, propID, buf)
+	if !ok {
+		return nil, fmt.Errorf("CertGetCertificateContextProperty returned %v", err)
+	}
+	return buf[:size], nil
+}
+
+// utf16BytesToString decodes a null terminated little endian UTF-16 string.
+func utf16BytesToString(b []byte) string {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		c := uint16(b[i]) | uint16(b[i+1])<<8
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u))
+}
+
+// utf16Bytes encodes s as a null terminated little endian UTF-16 string.
+func utf16Bytes(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(u)+2)
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(b[2*i:], c)
+	}
+	return b
+}
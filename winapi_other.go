@@ -0,0 +1,43 @@
+// +build !windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+// nteNotSupported is NTE_NOT_SUPPORTED.
+const nteNotSupported = 0x80090029
+
+// winSys fails every call, there is no nCrypt or crypt32 to call. Tests
+// replace it with a fake.
+var winSys winAPI = unavailableAPI{}
+
+// unavailableAPI implements winAPI where the Windows functions do not exist.
+type unavailableAPI struct{}
+
+func (unavailableAPI) NCryptGetProperty(h uintptr, property string, out []byte) (uint32, uintptr, error) {
+	return 0, nteNotSupported, ErrNotSupported
+}
+
+func (unavailableAPI) NCryptSetProperty(h uintptr, property string, value []byte, flags uint32) (uintptr, error) {
+	return nteNotSupported, ErrNotSupported
+}
+
+func (unavailableAPI) NCryptExportKey(kh uintptr, blobType string, out []byte) (uint32, uintptr, error) {
+	return 0, nteNotSupported, ErrNotSupported
+}
+
+func (unavailableAPI) CertGetCertificateContextProperty(certContext uintptr, propID uint32, out []byte) (uint32, bool, error) {
+	return 0, false, ErrNotSupported
+}
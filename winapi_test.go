@@ -0,0 +1,156 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+// fakeWinAPI is a winAPI backed by maps, which fails calls with status when
+// it is set.
+type fakeWinAPI struct {
+	props     map[string][]byte
+	blobs     map[string][]byte
+	certProps map[uint32][]byte
+	status    uintptr
+	calls     int
+}
+
+// withFakeWinAPI replaces winSys with f for the duration of the test.
+func withFakeWinAPI(t *testing.T, f *fakeWinAPI) {
+	old := winSys
+	winSys = f
+	t.Cleanup(func() { winSys = old })
+}
+
+// fill copies v to out as the functions do, when out is large enough.
+func fill(v, out []byte) uint32 {
+	if len(out) >= len(v) {
+		copy(out, v)
+	}
+	return uint32(len(v))
+}
+
+func (f *fakeWinAPI) NCryptGetProperty(h uintptr, property string, out []byte) (uint32, uintptr, error) {
+	f.calls++
+	if f.status != 0 {
+		return 0, f.status, syscall.Errno(f.status)
+	}
+	return fill(f.props[property], out), 0, nil
+}
+
+func (f *fakeWinAPI) NCryptSetProperty(h uintptr, property string, value []byte, flags uint32) (uintptr, error) {
+	f.calls++
+	if f.status != 0 {
+		return f.status, syscall.Errno(f.status)
+	}
+	f.props[property] = append([]byte(nil), value...)
+	return 0, nil
+}
+
+func (f *fakeWinAPI) NCryptExportKey(kh uintptr, blobType string, out []byte) (uint32, uintptr, error) {
+	f.calls++
+	if f.status != 0 {
+		return 0, f.status, syscall.Errno(f.status)
+	}
+	return fill(f.blobs[blobType], out), 0, nil
+}
+
+func (f *fakeWinAPI) CertGetCertificateContextProperty(certContext uintptr, propID uint32, out []byte) (uint32, bool, error) {
+	f.calls++
+	v, ok := f.certProps[propID]
+	if !ok {
+		return 0, false, syscall.Errno(cryptENotFound)
+	}
+	return fill(v, out), true, nil
+}
+
+func TestWinAPIProperties(t *testing.T) {
+	f := &fakeWinAPI{props: map[string][]byte{"Unique Name": utf16Bytes("container-1"), "Empty": nil}}
+	withFakeWinAPI(t, f)
+
+	name, err := container(1)
+	if err != nil {
+		t.Fatalf("container returned %v", err)
+	}
+	if name != "container-1" {
+		t.Errorf("container returned %q, want %q", name, "container-1")
+	}
+	if f.calls != 2 {
+		t.Errorf("NCryptGetProperty was called %d times, want a size query and a read", f.calls)
+	}
+	if b, err := getProperty(1, "Empty"); err != nil || len(b) != 0 {
+		t.Errorf("getProperty of an empty property returned %v, %v", b, err)
+	}
+
+	if err := setPropertyUint32(1, "Length", 2048, 0); err != nil {
+		t.Fatalf("setPropertyUint32 returned %v", err)
+	}
+	if got := binary.LittleEndian.Uint32(f.props["Length"]); got != 2048 {
+		t.Errorf("setPropertyUint32 stored %d, want 2048", got)
+	}
+
+	f.status = 0x80090016 // NTE_BAD_KEYSET
+	if _, err := getProperty(1, "Unique Name"); err == nil {
+		t.Error("getProperty succeeded with a failure status")
+	} else if e, ok := err.(*ncryptError); !ok || e.status != f.status {
+		t.Errorf("getProperty returned %v, want an ncryptError with status %X", err, f.status)
+	}
+	f.status = tpm20ELockout
+	if err := setProperty(1, "Length", nil, 0); err == nil {
+		t.Error("setProperty succeeded with a failure status")
+	} else if _, ok := err.(*TPMError); !ok {
+		t.Errorf("setProperty returned %T for a TPM status, want *TPMError", err)
+	}
+}
+
+func TestWinAPIExportKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	withFakeWinAPI(t, &fakeWinAPI{blobs: map[string][]byte{bCryptRSAPublicBlob: rsaPublicBlob(&key.PublicKey)}})
+
+	pub, err := exportRSA(1)
+	if err != nil {
+		t.Fatalf("exportRSA returned %v", err)
+	}
+	if !pub.Equal(&key.PublicKey) {
+		t.Error("exported public key does not match")
+	}
+	if _, err := exportEcdsa(1); err == nil {
+		t.Error("exportEcdsa succeeded without an ECC blob")
+	}
+}
+
+func TestWinAPIContextProperty(t *testing.T) {
+	withFakeWinAPI(t, &fakeWinAPI{certProps: map[uint32][]byte{PropFriendlyName: utf16Bytes("web")}})
+
+	b, err := contextProperty(1, PropFriendlyName)
+	if err != nil {
+		t.Fatalf("contextProperty returned %v", err)
+	}
+	if !reflect.DeepEqual(b, utf16Bytes("web")) {
+		t.Errorf("contextProperty returned %x, want %x", b, utf16Bytes("web"))
+	}
+	if b, err := contextProperty(1, PropKeyProvInfo); err != nil || b != nil {
+		t.Errorf("contextProperty of a missing property returned %v, %v, want nil, nil", b, err)
+	}
+}
@@ -0,0 +1,85 @@
+// +build windows
+
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certtostore
+
+import "unsafe"
+
+var (
+	nCryptExportKey   = nCrypt.MustFindProc("NCryptExportKey")
+	nCryptGetProperty = nCrypt.MustFindProc("NCryptGetProperty")
+	nCryptSetProperty = nCrypt.MustFindProc("NCryptSetProperty")
+
+	// winSys calls the functions of ncrypt.dll and crypt32.dll.
+	winSys winAPI = dllAPI{}
+)
+
+// dllAPI implements winAPI with the DLL functions.
+type dllAPI struct{}
+
+// bufPtr returns the address of the first byte of b, or 0 if b is empty.
+func bufPtr(b []byte) uintptr {
+	if len(b) == 0 {
+		return 0
+	}
+	return uintptr(unsafe.Pointer(&b[0]))
+}
+
+func (dllAPI) NCryptGetProperty(h uintptr, property string, out []byte) (size uint32, status uintptr, err error) {
+	status, _, err = nCryptGetProperty.Call(
+		h,
+		uintptr(unsafe.Pointer(wide(property))),
+		bufPtr(out),
+		uintptr(len(out)),
+		uintptr(unsafe.Pointer(&size)),
+		0,
+		0)
+	return size, status, err
+}
+
+func (dllAPI) NCryptSetProperty(h uintptr, property string, value []byte, flags uint32) (status uintptr, err error) {
+	status, _, err = nCryptSetProperty.Call(
+		h,
+		uintptr(unsafe.Pointer(wide(property))),
+		bufPtr(value),
+		uintptr(len(value)),
+		uintptr(flags))
+	return status, err
+}
+
+func (dllAPI) NCryptExportKey(kh uintptr, blobType string, out []byte) (size uint32, status uintptr, err error) {
+	// When obtaining the size of a public key, most parameters are not required
+	status, _, err = nCryptExportKey.Call(
+		kh,
+		0,
+		uintptr(unsafe.Pointer(wide(blobType))),
+		0,
+		bufPtr(out),
+		uintptr(len(out)),
+		uintptr(unsafe.Pointer(&size)),
+		0)
+	return size, status, err
+}
+
+func (dllAPI) CertGetCertificateContextProperty(certContext uintptr, propID uint32, out []byte) (size uint32, ok bool, err error) {
+	size = uint32(len(out))
+	r, _, err := certGetCertificateContextProp.Call(
+		certContext,
+		uintptr(propID),
+		bufPtr(out),
+		uintptr(unsafe.Pointer(&size)))
+	return size, r != 0, err
+}